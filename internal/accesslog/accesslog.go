@@ -0,0 +1,108 @@
+package accesslog
+
+import (
+	"encoding/json"
+	"fmt"
+	"skyport-agent/internal/logfile"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Log formats
+const (
+	FormatApache = "apache" // Apache combined log format
+	FormatJSON   = "json"   // One JSON object per line
+)
+
+// Entry is one proxied request
+type Entry struct {
+	Time      time.Time     `json:"time"`
+	RequestID string        `json:"request_id,omitempty"`
+	ClientIP  string        `json:"client_ip,omitempty"`
+	Method    string        `json:"method"`
+	Path      string        `json:"path"`
+	Status    int           `json:"status"`
+	Bytes     int           `json:"bytes"`
+	Duration  time.Duration `json:"-"`
+	Referer   string        `json:"referer,omitempty"`
+	UserAgent string        `json:"user_agent,omitempty"`
+}
+
+// Logger appends entries to a size-rotated file
+type Logger struct {
+	format string
+	file   *logfile.RotatingFile
+}
+
+// Open opens (or creates) an access log
+func Open(path, format string, maxSize int64, maxBackups int) (*Logger, error) {
+	if format != FormatApache && format != FormatJSON {
+		return nil, fmt.Errorf("unknown access log format %q (use %s or %s)", format, FormatApache, FormatJSON)
+	}
+
+	file, err := logfile.Open(path, maxSize, maxBackups)
+	if err != nil {
+		return nil, err
+	}
+	return &Logger{format: format, file: file}, nil
+}
+
+// Path returns the file the log is written to
+func (l *Logger) Path() string {
+	return l.file.Path()
+}
+
+// Log writes an entry. Write failures are returned but never block traffic.
+func (l *Logger) Log(entry Entry) error {
+	_, err := l.file.Write([]byte(l.formatEntry(entry)))
+	return err
+}
+
+// Close closes the log file
+func (l *Logger) Close() error {
+	return l.file.Close()
+}
+
+func (l *Logger) formatEntry(entry Entry) string {
+	if l.format == FormatJSON {
+		data, _ := json.Marshal(struct {
+			Entry
+			DurationMs float64 `json:"duration_ms"`
+		}{entry, float64(entry.Duration.Microseconds()) / 1000})
+		return string(data) + "\n"
+	}
+
+	// host ident authuser [date] "request" status bytes "referer" "user-agent" duration-us request-id
+	return fmt.Sprintf("%s - - [%s] \"%s %s HTTP/1.1\" %d %s \"%s\" \"%s\" %d %s\n",
+		orDash(entry.ClientIP),
+		entry.Time.Format("02/Jan/2006:15:04:05 -0700"),
+		entry.Method,
+		escape(entry.Path),
+		entry.Status,
+		bytesField(entry.Bytes),
+		orDash(escape(entry.Referer)),
+		orDash(escape(entry.UserAgent)),
+		entry.Duration.Microseconds(),
+		orDash(escape(entry.RequestID)),
+	)
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func bytesField(n int) string {
+	if n == 0 {
+		return "-"
+	}
+	return strconv.Itoa(n)
+}
+
+// escape keeps client-controlled values from breaking the line format
+func escape(s string) string {
+	return strings.NewReplacer(`"`, `\"`, "\n", `\n`, "\r", `\r`).Replace(s)
+}
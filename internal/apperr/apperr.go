@@ -0,0 +1,158 @@
+// Package apperr defines the errors the CLI reports to users, each with the
+// exit status it ends the process with and a hint on how to fix it.
+package apperr
+
+import (
+	"fmt"
+)
+
+// Exit statuses. Scripts can tell failures apart by them; 1 is any failure
+// without a more specific status.
+const (
+	ExitFailure           = 1
+	ExitNotAuthenticated  = 3
+	ExitServerUnreachable = 4
+	ExitNotFound          = 5
+	ExitConflict          = 6
+	ExitLocalUnavailable  = 7
+	ExitUnhealthy         = 8
+)
+
+// Error is a failure explained to the user
+type Error struct {
+	Code     int    // Process exit status
+	Category string // Stable name for the kind of failure, used in usage reports
+	Message  string // What went wrong, in the user's terms
+	Hint     string // What to do about it; may be empty
+	Err      error  // The underlying error, shown with --debug
+
+	kind *Error // The sentinel this error was made from
+}
+
+// Errors reported by the CLI. Use Wrap to attach the underlying error and
+// Withf to make the message specific; errors.Is matches the results against
+// these.
+var (
+	ErrNotAuthenticated = &Error{
+		Code:     ExitNotAuthenticated,
+		Category: "not_authenticated",
+		Message:  "You are not logged in",
+		Hint:     "Run 'skyport login' first",
+	}
+	ErrSessionExpired = &Error{
+		Code:     ExitNotAuthenticated,
+		Category: "session_expired",
+		Message:  "Your session has expired",
+		Hint:     "Run 'skyport login' again",
+	}
+	ErrNoInternet = &Error{
+		Code:     ExitServerUnreachable,
+		Category: "no_internet",
+		Message:  "No internet connection",
+		Hint:     "Check your network connection, proxy and firewall settings",
+	}
+	ErrServerUnreachable = &Error{
+		Code:     ExitServerUnreachable,
+		Category: "server_unreachable",
+		Message:  "Failed to connect to SkyPort server",
+		Hint:     "Check your internet connection and try again; run with --debug for details",
+	}
+	ErrServerBusy = &Error{
+		Code:     ExitServerUnreachable,
+		Category: "server_busy",
+		Message:  "The SkyPort server is busy",
+		Hint:     "Try again in a few minutes; the server is overloaded or limiting connections",
+	}
+	ErrTunnelNotFound = &Error{
+		Code:     ExitNotFound,
+		Category: "tunnel_not_found",
+		Message:  "Tunnel not found",
+		Hint:     "Use 'skyport tunnel list' to see available tunnels",
+	}
+	ErrTunnelRunning = &Error{
+		Code:     ExitConflict,
+		Category: "tunnel_running",
+		Message:  "Tunnel is already running",
+		Hint:     "Use 'skyport tunnel stop <name>' to stop it first",
+	}
+	ErrSubdomainTaken = &Error{
+		Code:     ExitConflict,
+		Category: "subdomain_taken",
+		Message:  "The tunnel's subdomain is already in use",
+		Hint:     "Stop the tunnel where it is running, or choose another subdomain in the dashboard",
+	}
+	ErrLocalPortClosed = &Error{
+		Code:     ExitLocalUnavailable,
+		Category: "local_port_closed",
+		Message:  "Nothing is listening on the tunnel's local port",
+		Hint:     "Start your local service, or point the tunnel elsewhere with 'skyport tunnel target'",
+	}
+	ErrLocalUnhealthy = &Error{
+		Code:     ExitLocalUnavailable,
+		Category: "local_unhealthy",
+		Message:  "The tunnel's local service is not healthy",
+		Hint:     "Check your local service, or change the check with 'skyport tunnel preflight'",
+	}
+	ErrCheckFailed = &Error{
+		Code:     ExitUnhealthy,
+		Category: "check_failed",
+		Message:  "Health check failed",
+		Hint:     "Run 'skyport tunnel status <name>' to see why a tunnel is down",
+	}
+	ErrStartFailed = &Error{
+		Code:     ExitFailure,
+		Category: "start_failed",
+		Message:  "Failed to start tunnel",
+		Hint:     "Run with --debug for details; 'skyport support bundle' collects them for a bug report",
+	}
+	ErrReadOnlyToken = &Error{
+		Code:     ExitFailure,
+		Category: "read_only_token",
+		Message:  "This agent's login token is read-only",
+		Hint:     "It can list and run tunnels; log in with a full token ('skyport login') to create, delete or stop them",
+	}
+	ErrUnknownCommand = &Error{
+		Code:     ExitFailure,
+		Category: "unknown_command",
+		Message:  "Unknown command",
+		Hint:     "Run 'skyport --help' to see the available commands",
+	}
+)
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %v", e.Message, e.Err)
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is the sentinel e was made from
+func (e *Error) Is(target error) bool {
+	return target == e || (e.kind != nil && target == e.kind)
+}
+
+// Wrap returns a copy of e caused by err
+func (e *Error) Wrap(err error) *Error {
+	wrapped := e.copy()
+	wrapped.Err = err
+	return wrapped
+}
+
+// Withf returns a copy of e with a more specific message
+func (e *Error) Withf(format string, args ...interface{}) *Error {
+	specific := e.copy()
+	specific.Message = fmt.Sprintf(format, args...)
+	return specific
+}
+
+func (e *Error) copy() *Error {
+	c := *e
+	if c.kind == nil {
+		c.kind = e
+	}
+	return &c
+}
@@ -3,24 +3,43 @@ package auth
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
+	"os"
+	"runtime"
 	"skyport-agent/internal/config"
+	"skyport-agent/internal/journal"
+	"skyport-agent/internal/logger"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/pkg/browser"
-	"github.com/zalando/go-keyring"
 )
 
-const (
-	KeyringService = "skyport-agent"
-	KeyringUser    = "default"
-)
+const KeyringService = "skyport-agent"
+
+// keyringUser is the keyring account the login token is stored under, one
+// per agent instance
+func keyringUser() string {
+	if instance := config.Instance(); instance != "" {
+		return instance
+	}
+	return "default"
+}
+
+// ErrServerUnreachable means the SkyPort server could not be asked, as
+// opposed to answering that a token is invalid
+var ErrServerUnreachable = errors.New("SkyPort server unreachable")
+
+// ErrSubdomainTaken is returned when creating a tunnel with a subdomain that
+// another tunnel already has
+var ErrSubdomainTaken = errors.New("subdomain already taken")
 
 type AuthManager struct {
 	config           *config.Config
+	client           *http.Client
 	lastTokenCheck   int64  // Unix timestamp of last validation
 	lastTokenValid   bool   // Result of last validation
 	lastCheckedToken string // The token that was last checked
@@ -48,6 +67,13 @@ type ServerTunnel struct {
 	LocalPort int    `json:"local_port"`
 	AuthToken string `json:"auth_token"`
 	IsActive  bool   `json:"is_active"`
+
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// The machine a running tunnel is connected from and since when
+	ConnectedHost      string    `json:"connected_host,omitempty"`
+	ConnectedMachineID string    `json:"connected_machine_id,omitempty"`
+	ConnectedAt        time.Time `json:"connected_at,omitempty"`
 }
 
 type TunnelsResponse struct {
@@ -55,7 +81,7 @@ type TunnelsResponse struct {
 }
 
 func NewAuthManager(cfg *config.Config) *AuthManager {
-	return &AuthManager{config: cfg}
+	return &AuthManager{config: cfg, client: cfg.HTTPClient(30 * time.Second)}
 }
 
 func (a *AuthManager) GetWebURL() string {
@@ -108,16 +134,19 @@ func (a *AuthManager) ValidateToken(token string) (*config.UserData, error) {
 		return nil, fmt.Errorf("failed to marshal request: %w", err)
 	}
 
-	resp, err := http.Post(
+	resp, err := a.client.Post(
 		fmt.Sprintf("%s/auth/agent-auth", a.config.ServerURL),
 		"application/json",
 		bytes.NewBuffer(jsonData),
 	)
 	if err != nil {
-		return nil, fmt.Errorf("failed to validate token: %w", err)
+		return nil, fmt.Errorf("failed to validate token: %w: %v", ErrServerUnreachable, err)
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return nil, fmt.Errorf("token validation failed with status %d: %w", resp.StatusCode, ErrServerUnreachable)
+	}
 	if resp.StatusCode != http.StatusOK {
 		return nil, fmt.Errorf("token validation failed with status: %d", resp.StatusCode)
 	}
@@ -142,9 +171,11 @@ func (a *AuthManager) ValidateToken(token string) (*config.UserData, error) {
 }
 
 func (a *AuthManager) SaveCredentials(userData *config.UserData) error {
-	// Save token to keyring
-	if err := keyring.Set(KeyringService, KeyringUser, userData.Token); err != nil {
-		return fmt.Errorf("failed to save token to keyring: %w", err)
+	logger.AddSecret(userData.Token)
+
+	// Save token to keyring (or the encrypted token file)
+	if err := a.storeToken(userData.Token); err != nil {
+		return err
 	}
 
 	// Save user data to config file
@@ -162,11 +193,12 @@ func (a *AuthManager) LoadCredentials() (*config.UserData, error) {
 		return nil, err
 	}
 
-	// Load token from keyring
-	token, err := keyring.Get(KeyringService, KeyringUser)
+	// Load token from keyring (or the encrypted token file)
+	token, err := a.loadToken()
 	if err != nil {
-		return nil, fmt.Errorf("failed to get token from keyring: %w", err)
+		return nil, err
 	}
+	logger.AddSecret(token)
 
 	userData.Token = token
 
@@ -177,12 +209,16 @@ func (a *AuthManager) LoadCredentials() (*config.UserData, error) {
 		return nil, fmt.Errorf("stored token is expired")
 	}
 
-	// Always validate with server - no offline mode
-	// If server is down, user can't use tunnels anyway
+	// Always validate with server when it can be reached
 	validatedUserData, err := a.ValidateToken(token)
+	if errors.Is(err, ErrServerUnreachable) {
+		// An outage says nothing about the token, which has not expired, so
+		// stay logged in: commands that can work from cached data still do
+		return userData, nil
+	}
 	if err != nil {
-		// Any validation error (network, server down, invalid token, etc.)
-		// Clear credentials so user knows they need to re-authenticate
+		// The server rejected the token: clear credentials so the user
+		// knows they need to re-authenticate
 		a.ClearCredentials()
 		return nil, fmt.Errorf("failed to validate credentials with server: %w", err)
 	}
@@ -190,19 +226,44 @@ func (a *AuthManager) LoadCredentials() (*config.UserData, error) {
 	return validatedUserData, nil
 }
 
-func (a *AuthManager) ClearCredentials() error {
-	// Clear token from keyring
-	keyring.Delete(KeyringService, KeyringUser)
+// ClearCredentials removes the stored token and account details, returning
+// what was actually removed. Credentials that are already gone are not an
+// error.
+func (a *AuthManager) ClearCredentials() ([]string, error) {
+	// Clear token from keyring and the encrypted token file
+	removed, err := a.deleteToken()
+	errs := []error{err}
 
 	// Clear user data from config file
-	config.ClearUserData()
+	switch err := config.ClearUserData(); {
+	case err == nil:
+		removed = append(removed, "account details (user.json)")
+	case os.IsNotExist(err):
+	default:
+		errs = append(errs, fmt.Errorf("failed to remove account details: %w", err))
+	}
+	config.NewConfigManager().InvalidateTunnelCache()
 
 	// Clear cache
 	a.lastTokenCheck = 0
 	a.lastTokenValid = false
 	a.lastCheckedToken = ""
 
-	return nil
+	journal.Record(journal.Entry{Type: journal.EventLogout})
+
+	return removed, errors.Join(errs...)
+}
+
+// KeyringName names the platform keyring the login token is stored in
+func KeyringName() string {
+	switch runtime.GOOS {
+	case "darwin":
+		return "macOS Keychain"
+	case "windows":
+		return "Windows Credential Manager"
+	default:
+		return "Secret Service keyring"
+	}
 }
 
 func (a *AuthManager) LoginWithToken(token string) (*config.UserData, error) {
@@ -217,6 +278,8 @@ func (a *AuthManager) LoginWithToken(token string) (*config.UserData, error) {
 		return nil, err
 	}
 
+	journal.Record(journal.Entry{Type: journal.EventLogin, Reason: fmt.Sprintf("Logged in as %s", userData.Email)})
+
 	return userData, nil
 }
 
@@ -241,40 +304,107 @@ func (a *AuthManager) ParseAuthURL(rawURL string) (string, error) {
 	return token, nil
 }
 
+// FetchTunnels gets the tunnel list from the server and refreshes the local cache
 func (a *AuthManager) FetchTunnels(token string) ([]config.Tunnel, error) {
-	// Create HTTP client
-	client := &http.Client{}
+	tunnels, etag, _, err := a.fetchTunnels(token, "")
+	if err != nil {
+		return nil, err
+	}
 
+	a.saveTunnelCache(tunnels, etag)
+	return tunnels, nil
+}
+
+// TunnelList is a tunnel list and where it came from
+type TunnelList struct {
+	Tunnels   []config.Tunnel
+	FetchedAt time.Time // When the server last confirmed the list
+	Cached    bool      // Served from the local cache without asking the server
+	Stale     bool      // The server could not be reached, so the list may be out of date
+	Err       error     // Why the server could not be reached, when Stale
+}
+
+// ListTunnels returns the user's tunnels. A list cached within the
+// tunnel_list_ttl setting is returned without asking the server unless
+// refresh is set; an older one is revalidated with its ETag. If the server
+// cannot be reached, a cached list of any age is returned marked Stale.
+func (a *AuthManager) ListTunnels(token string, refresh bool) (*TunnelList, error) {
+	configManager := config.NewConfigManager()
+	cache := configManager.LoadTunnelCache()
+
+	if cache != nil && !refresh && time.Since(cache.FetchedAt) < a.config.GetSettings().Intervals.TunnelListTTL.Duration {
+		return &TunnelList{Tunnels: cache.Tunnels, FetchedAt: cache.FetchedAt, Cached: true}, nil
+	}
+
+	etag := ""
+	if cache != nil {
+		etag = cache.ETag
+	}
+
+	tunnels, newETag, notModified, err := a.fetchTunnels(token, etag)
+	if err != nil {
+		if cache == nil {
+			return nil, err
+		}
+		return &TunnelList{Tunnels: cache.Tunnels, FetchedAt: cache.FetchedAt, Cached: true, Stale: true, Err: err}, nil
+	}
+	if notModified {
+		tunnels, newETag = cache.Tunnels, cache.ETag
+	}
+
+	a.saveTunnelCache(tunnels, newETag)
+	return &TunnelList{Tunnels: tunnels, FetchedAt: time.Now()}, nil
+}
+
+func (a *AuthManager) saveTunnelCache(tunnels []config.Tunnel, etag string) {
+	// The cache only saves round trips, so failing to write it is not an error
+	config.NewConfigManager().SaveTunnelCache(&config.TunnelListCache{
+		Tunnels:   tunnels,
+		ETag:      etag,
+		FetchedAt: time.Now(),
+	})
+}
+
+// fetchTunnels gets the tunnel list from the server. With an ETag, the
+// server may answer that the list has not changed, reported as notModified.
+func (a *AuthManager) fetchTunnels(token, etag string) (tunnels []config.Tunnel, newETag string, notModified bool, err error) {
 	// Create request
 	req, err := http.NewRequest("GET", fmt.Sprintf("%s/tunnels", a.config.ServerURL), nil)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+		return nil, "", false, fmt.Errorf("failed to create request: %w", err)
 	}
 
 	// Add authorization header
 	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
 	req.Header.Add("Content-Type", "application/json")
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
 
 	// Make request
-	resp, err := client.Do(req)
+	resp, err := a.client.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("failed to fetch tunnels: %w", err)
+		return nil, "", false, fmt.Errorf("failed to fetch tunnels: %w", err)
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusNotModified && etag != "" {
+		return nil, etag, true, nil
+	}
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("failed to fetch tunnels with status: %d", resp.StatusCode)
+		return nil, "", false, fmt.Errorf("failed to fetch tunnels with status: %d", resp.StatusCode)
 	}
 
 	// Parse response
 	var tunnelsResp TunnelsResponse
 	if err := json.NewDecoder(resp.Body).Decode(&tunnelsResp); err != nil {
-		return nil, fmt.Errorf("failed to decode tunnels response: %w", err)
+		return nil, "", false, fmt.Errorf("failed to decode tunnels response: %w", err)
 	}
 
 	// Convert server tunnels to agent config tunnels
 	var configTunnels []config.Tunnel
 	for _, serverTunnel := range tunnelsResp.Tunnels {
+		logger.AddSecret(serverTunnel.AuthToken)
 		configTunnel := config.Tunnel{
 			ID:        serverTunnel.ID,
 			Name:      serverTunnel.Name,
@@ -283,19 +413,25 @@ func (a *AuthManager) FetchTunnels(token string) ([]config.Tunnel, error) {
 			AuthToken: serverTunnel.AuthToken,
 			IsActive:  serverTunnel.IsActive,
 			AutoStart: false, // Default to false, can be set by user
+			Labels:    serverTunnel.Labels,
+
+			ConnectedHost:      serverTunnel.ConnectedHost,
+			ConnectedMachineID: serverTunnel.ConnectedMachineID,
+			ConnectedAt:        serverTunnel.ConnectedAt,
 		}
 		configTunnels = append(configTunnels, configTunnel)
 	}
 
-	return configTunnels, nil
+	return configTunnels, resp.Header.Get("ETag"), false, nil
 }
 
 // GetStoredToken retrieves the stored authentication token
 func (am *AuthManager) GetStoredToken() (string, error) {
-	token, err := keyring.Get(KeyringService, KeyringUser)
+	token, err := am.loadToken()
 	if err != nil {
-		return "", fmt.Errorf("failed to get token from keyring: %w", err)
+		return "", err
 	}
+	logger.AddSecret(token)
 	return token, nil
 }
 
@@ -328,3 +464,132 @@ func (am *AuthManager) GetValidToken() (string, error) {
 func (am *AuthManager) OpenURL(url string) error {
 	return browser.OpenURL(url)
 }
+
+// CreateTunnel creates a tunnel on the server and returns it
+func (a *AuthManager) CreateTunnel(token, name, subdomain string, localPort int) (*config.Tunnel, error) {
+	if err := checkWritable(token, "create tunnels"); err != nil {
+		return nil, err
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"name":       name,
+		"subdomain":  subdomain,
+		"local_port": localPort,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest("POST", fmt.Sprintf("%s/tunnels", a.config.ServerURL), bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
+	req.Header.Add("Content-Type", "application/json")
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create tunnel: %w: %v", ErrServerUnreachable, err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated:
+	case http.StatusConflict:
+		return nil, fmt.Errorf("%w: %s", ErrSubdomainTaken, subdomain)
+	case http.StatusNotFound, http.StatusMethodNotAllowed:
+		return nil, fmt.Errorf("the SkyPort server does not support creating tunnels from the agent (status %d); create the tunnel in the dashboard", resp.StatusCode)
+	default:
+		return nil, fmt.Errorf("failed to create tunnel with status: %d", resp.StatusCode)
+	}
+
+	// The tunnel comes on its own or wrapped as {"tunnel": ...}
+	var created struct {
+		ServerTunnel
+		Tunnel *ServerTunnel `json:"tunnel"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		return nil, fmt.Errorf("failed to decode created tunnel: %w", err)
+	}
+	serverTunnel := created.ServerTunnel
+	if created.Tunnel != nil {
+		serverTunnel = *created.Tunnel
+	}
+	if serverTunnel.ID == "" {
+		return nil, fmt.Errorf("the server did not return the created tunnel")
+	}
+
+	logger.AddSecret(serverTunnel.AuthToken)
+	return &config.Tunnel{
+		ID:        serverTunnel.ID,
+		Name:      serverTunnel.Name,
+		Subdomain: serverTunnel.Subdomain,
+		LocalPort: serverTunnel.LocalPort,
+		AuthToken: serverTunnel.AuthToken,
+		Labels:    serverTunnel.Labels,
+	}, nil
+}
+
+// DeleteTunnel deletes a tunnel from the server. A tunnel that is already
+// gone is not an error.
+func (a *AuthManager) DeleteTunnel(token, tunnelID string) error {
+	if err := checkWritable(token, "delete tunnels"); err != nil {
+		return err
+	}
+	req, err := http.NewRequest("DELETE", fmt.Sprintf("%s/tunnels/%s", a.config.ServerURL, url.PathEscape(tunnelID)), nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to delete tunnel: %w: %v", ErrServerUnreachable, err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300, resp.StatusCode == http.StatusNotFound:
+		return nil
+	case resp.StatusCode == http.StatusMethodNotAllowed, resp.StatusCode == http.StatusNotImplemented:
+		return fmt.Errorf("the SkyPort server does not support deleting tunnels from the agent (status %d); delete the tunnel in the dashboard", resp.StatusCode)
+	default:
+		return fmt.Errorf("failed to delete tunnel with status: %d", resp.StatusCode)
+	}
+}
+
+// ErrLabelsUnsupported is returned when the server cannot store tunnel labels
+var ErrLabelsUnsupported = errors.New("the SkyPort server does not support tunnel labels")
+
+// SetTunnelLabels replaces a tunnel's labels on the server
+func (a *AuthManager) SetTunnelLabels(token, tunnelID string, labels map[string]string) error {
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	body, err := json.Marshal(map[string]interface{}{"labels": labels})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("PATCH", fmt.Sprintf("%s/tunnels/%s", a.config.ServerURL, url.PathEscape(tunnelID)), bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
+	req.Header.Add("Content-Type", "application/json")
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to update labels: %w: %v", ErrServerUnreachable, err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusNotFound, resp.StatusCode == http.StatusMethodNotAllowed,
+		resp.StatusCode == http.StatusBadRequest, resp.StatusCode == http.StatusNotImplemented:
+		return fmt.Errorf("%w (status %d)", ErrLabelsUnsupported, resp.StatusCode)
+	default:
+		return fmt.Errorf("failed to update labels with status: %d", resp.StatusCode)
+	}
+}
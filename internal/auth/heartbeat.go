@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// ErrHeartbeatUnsupported is returned when the server does not accept agent
+// heartbeats
+var ErrHeartbeatUnsupported = errors.New("the SkyPort server does not accept agent heartbeats")
+
+// Heartbeat reports an agent's health to the server, so the dashboard can
+// show which agents are up and flag outdated versions
+type Heartbeat struct {
+	MachineID string            `json:"machine_id"`
+	Host      string            `json:"host"`
+	Version   string            `json:"version"`
+	OS        string            `json:"os"`
+	Arch      string            `json:"arch"`
+	Uptime    int64             `json:"uptime_seconds"` // Since the agent started
+	Tunnels   []HeartbeatTunnel `json:"tunnels"`
+	SentAt    time.Time         `json:"sent_at"`
+}
+
+// HeartbeatTunnel is a tunnel's state and counters in a heartbeat. Counters
+// are cumulative since the agent started.
+type HeartbeatTunnel struct {
+	ID           string `json:"id"`
+	Name         string `json:"name"`
+	Connected    bool   `json:"connected"`
+	Requests     int64  `json:"requests"`
+	BytesIn      int64  `json:"bytes_in"`
+	BytesOut     int64  `json:"bytes_out"`
+	ServerErrors int    `json:"server_errors"` // 5xx responses in the last few minutes
+	Quality      string `json:"quality,omitempty"`
+	RoundTripMS  int64  `json:"round_trip_ms,omitempty"` // Median ping time to the server
+}
+
+// SendHeartbeat reports the agent's health to the server
+func (a *AuthManager) SendHeartbeat(token string, heartbeat *Heartbeat) error {
+	body, err := json.Marshal(heartbeat)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", fmt.Sprintf("%s/agents/heartbeat", a.config.ServerURL), bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
+	req.Header.Add("Content-Type", "application/json")
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send heartbeat: %w: %v", ErrServerUnreachable, err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusNotFound, resp.StatusCode == http.StatusMethodNotAllowed,
+		resp.StatusCode == http.StatusNotImplemented:
+		return fmt.Errorf("%w (status %d)", ErrHeartbeatUnsupported, resp.StatusCode)
+	default:
+		return fmt.Errorf("failed to send heartbeat with status: %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,48 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// ErrReadOnlyToken is returned for operations a read-only login token may
+// not perform
+var ErrReadOnlyToken = errors.New("the login token is read-only")
+
+// IsReadOnlyToken reports whether a login token is flagged read-only, by a
+// true "read_only" claim or a "read" scope. The agent can list and serve the
+// tunnels of such a token, say on a kiosk machine, but refuses to create,
+// delete or stop tunnels with it.
+func IsReadOnlyToken(token string) bool {
+	parsedToken, _, err := jwt.NewParser().ParseUnverified(token, jwt.MapClaims{})
+	if err != nil {
+		return false
+	}
+	claims, ok := parsedToken.Claims.(jwt.MapClaims)
+	if !ok {
+		return false
+	}
+
+	if readOnly, ok := claims["read_only"].(bool); ok {
+		return readOnly
+	}
+	scope, _ := claims["scope"].(string)
+	for _, s := range strings.Fields(scope) {
+		if s == "read" {
+			return true
+		}
+	}
+	return false
+}
+
+// checkWritable refuses an operation that changes tunnels on the server
+// when the token is read-only
+func checkWritable(token, operation string) error {
+	if IsReadOnlyToken(token) {
+		return fmt.Errorf("%w: cannot %s", ErrReadOnlyToken, operation)
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+//go:build darwin
+
+package auth
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	"github.com/zalando/go-keyring"
+)
+
+// tokenKeyUser is the Keychain account of the key the token file is
+// encrypted with. A 32-byte key fits in the Keychain where a token may not be
+// wanted, and never leaves it.
+func tokenKeyUser() string {
+	return keyringUser() + "-token-key"
+}
+
+// sealToken encrypts the token with AES-GCM under a key kept in the macOS
+// Keychain, so the file is useless without this user's Keychain
+func sealToken(token []byte) ([]byte, error) {
+	aead, err := tokenCipher(true)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, token, nil), nil
+}
+
+// openToken decrypts a token sealed by sealToken
+func openToken(sealed []byte) ([]byte, error) {
+	aead, err := tokenCipher(false)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("token file is truncated")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, nil)
+}
+
+// forgetTokenKey removes the token file's key from the Keychain
+func forgetTokenKey() {
+	keyring.Delete(KeyringService, tokenKeyUser())
+}
+
+// tokenCipher returns the cipher for the token file, creating its key in the
+// Keychain if asked to and it does not exist yet
+func tokenCipher(create bool) (cipher.AEAD, error) {
+	encoded, err := keyring.Get(KeyringService, tokenKeyUser())
+	if errors.Is(err, keyring.ErrNotFound) && create {
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+		encoded = base64.StdEncoding.EncodeToString(key)
+		err = keyring.Set(KeyringService, tokenKeyUser(), encoded)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the token key from the Keychain: %w", err)
+	}
+
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(key) != 32 {
+		return nil, errors.New("the token key in the Keychain is invalid")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
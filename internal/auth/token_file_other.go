@@ -0,0 +1,17 @@
+//go:build !windows && !darwin
+
+package auth
+
+// sealToken fails: without DPAPI or a Keychain there is no key bound to the
+// user, and the token is not written to disk in plaintext
+func sealToken(token []byte) ([]byte, error) {
+	return nil, errTokenFileUnsupported
+}
+
+// openToken fails, as no token file can have been written
+func openToken(sealed []byte) ([]byte, error) {
+	return nil, errTokenFileUnsupported
+}
+
+// forgetTokenKey does nothing, as there is no key
+func forgetTokenKey() {}
@@ -0,0 +1,50 @@
+//go:build windows
+
+package auth
+
+import (
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// tokenEntropy is mixed into the DPAPI encryption, tying the token file to
+// SkyPort rather than to any DPAPI caller of the same user
+var tokenEntropy = []byte(KeyringService)
+
+// sealToken encrypts the token with DPAPI, so only this Windows user on this
+// machine can decrypt it
+func sealToken(token []byte) ([]byte, error) {
+	var sealed windows.DataBlob
+	err := windows.CryptProtectData(dataBlob(token), nil, dataBlob(tokenEntropy), 0, nil, windows.CRYPTPROTECT_UI_FORBIDDEN, &sealed)
+	if err != nil {
+		return nil, err
+	}
+	return takeBlob(&sealed), nil
+}
+
+// openToken decrypts a token sealed by sealToken
+func openToken(sealed []byte) ([]byte, error) {
+	var token windows.DataBlob
+	err := windows.CryptUnprotectData(dataBlob(sealed), nil, dataBlob(tokenEntropy), 0, nil, windows.CRYPTPROTECT_UI_FORBIDDEN, &token)
+	if err != nil {
+		return nil, err
+	}
+	return takeBlob(&token), nil
+}
+
+// forgetTokenKey does nothing: DPAPI keys belong to the Windows user
+func forgetTokenKey() {}
+
+func dataBlob(data []byte) *windows.DataBlob {
+	if len(data) == 0 {
+		return &windows.DataBlob{}
+	}
+	return &windows.DataBlob{Size: uint32(len(data)), Data: &data[0]}
+}
+
+// takeBlob copies a blob DPAPI allocated and frees it
+func takeBlob(blob *windows.DataBlob) []byte {
+	defer windows.LocalFree(windows.Handle(unsafe.Pointer(blob.Data)))
+	return append([]byte(nil), unsafe.Slice(blob.Data, blob.Size)...)
+}
@@ -0,0 +1,172 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"skyport-agent/internal/config"
+	"skyport-agent/internal/logger"
+
+	"github.com/zalando/go-keyring"
+)
+
+// errTokenFileUnsupported means this platform has no way to bind an
+// encrypted token file to the user, so the token is only kept in the keyring
+var errTokenFileUnsupported = fmt.Errorf("encrypted token files are not supported on %s", runtime.GOOS)
+
+// TokenFileName names the encrypted token file, which is used instead of
+// the keyring when it is unavailable or the settings ask for a file
+func TokenFileName() string {
+	switch runtime.GOOS {
+	case "darwin":
+		return "token file (encrypted with a key in the macOS Keychain)"
+	case "windows":
+		return "token file (encrypted with Windows DPAPI)"
+	default:
+		return "token file"
+	}
+}
+
+// tokenFilePath returns where the encrypted login token is kept, one file
+// per agent instance
+func tokenFilePath() (string, error) {
+	configDir, err := config.GetConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(configDir, fmt.Sprintf("token-%s.enc", keyringUser())), nil
+}
+
+// usesTokenFile reports whether the settings ask for the token to be kept
+// in an encrypted file rather than the keyring
+func (a *AuthManager) usesTokenFile() bool {
+	return a.config.GetSettings().Credentials.Storage == "file"
+}
+
+// storeToken saves the login token in the keyring or, when the keyring is
+// unavailable or the settings ask for a file, in a file encrypted with a key
+// only this OS user can use. It never stores the token in plaintext.
+func (a *AuthManager) storeToken(token string) error {
+	if a.usesTokenFile() {
+		if err := writeTokenFile(token); err != nil {
+			return fmt.Errorf("failed to save token to the %s: %w", TokenFileName(), err)
+		}
+		// Leave no older copy in the keyring
+		keyring.Delete(KeyringService, keyringUser())
+		return nil
+	}
+
+	err := keyring.Set(KeyringService, keyringUser(), token)
+	if err == nil {
+		removeTokenFile()
+		return nil
+	}
+	if fileErr := writeTokenFile(token); fileErr != nil {
+		logger.Debug("Failed to save token to the %s: %v", TokenFileName(), fileErr)
+		return fmt.Errorf("failed to save token to keyring: %w", err)
+	}
+	logger.Debug("Keyring unavailable (%v); saved the login token to the %s", err, TokenFileName())
+	return nil
+}
+
+// loadToken returns the stored login token from wherever storeToken put it
+func (a *AuthManager) loadToken() (string, error) {
+	if a.usesTokenFile() {
+		token, err := readTokenFile()
+		if err != nil {
+			return "", fmt.Errorf("failed to get token from the %s: %w", TokenFileName(), err)
+		}
+		return token, nil
+	}
+
+	token, err := keyring.Get(KeyringService, keyringUser())
+	if err == nil {
+		return token, nil
+	}
+	if fileToken, fileErr := readTokenFile(); fileErr == nil {
+		return fileToken, nil
+	}
+	return "", fmt.Errorf("failed to get token from keyring: %w", err)
+}
+
+// deleteToken removes the login token from the keyring and the token file,
+// returning what was removed
+func (a *AuthManager) deleteToken() ([]string, error) {
+	var removed []string
+	var errs []error
+
+	switch err := keyring.Delete(KeyringService, keyringUser()); {
+	case err == nil:
+		removed = append(removed, "login token in the "+KeyringName())
+	case errors.Is(err, keyring.ErrNotFound):
+	case a.usesTokenFile():
+		// The keyring may not be usable at all where a file is used
+		logger.Debug("Failed to remove token from the %s: %v", KeyringName(), err)
+	default:
+		errs = append(errs, fmt.Errorf("failed to remove token from the %s: %w", KeyringName(), err))
+	}
+
+	switch err := removeTokenFile(); {
+	case err == nil:
+		removed = append(removed, "login token in the "+TokenFileName())
+	case os.IsNotExist(err):
+	default:
+		errs = append(errs, fmt.Errorf("failed to remove the %s: %w", TokenFileName(), err))
+	}
+
+	return removed, errors.Join(errs...)
+}
+
+// writeTokenFile encrypts the token for this OS user and writes it to the
+// token file
+func writeTokenFile(token string) error {
+	sealed, err := sealToken([]byte(token))
+	if err != nil {
+		return err
+	}
+	path, err := tokenFilePath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, sealed, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// readTokenFile reads and decrypts the token file
+func readTokenFile() (string, error) {
+	path, err := tokenFilePath()
+	if err != nil {
+		return "", err
+	}
+	sealed, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	token, err := openToken(sealed)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt token: %w", err)
+	}
+	return string(token), nil
+}
+
+// removeTokenFile deletes the token file and the key it was encrypted with
+func removeTokenFile() error {
+	path, err := tokenFilePath()
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil {
+		return err
+	}
+	forgetTokenKey()
+	return nil
+}
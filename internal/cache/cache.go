@@ -0,0 +1,85 @@
+// Package cache is a size-bounded in-memory LRU cache with per-entry expiry.
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// Cache holds values up to a total size in bytes, evicting the least
+// recently used entries first
+type Cache struct {
+	mutex    sync.Mutex
+	maxBytes int64
+	size     int64
+	order    *list.List // Front is most recently used
+	entries  map[string]*list.Element
+}
+
+type entry struct {
+	key     string
+	value   interface{}
+	size    int64
+	expires time.Time
+}
+
+// New creates a cache holding at most maxBytes of values
+func New(maxBytes int64) *Cache {
+	return &Cache{
+		maxBytes: maxBytes,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+// Get returns the value stored under key if it has not expired
+func (c *Cache) Get(key string) (interface{}, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	element, exists := c.entries[key]
+	if !exists {
+		return nil, false
+	}
+
+	e := element.Value.(*entry)
+	if time.Now().After(e.expires) {
+		c.remove(element)
+		return nil, false
+	}
+
+	c.order.MoveToFront(element)
+	return e.value, true
+}
+
+// Put stores a value of the given size under key until ttl elapses. Values
+// larger than the whole cache are not stored.
+func (c *Cache) Put(key string, value interface{}, size int64, ttl time.Duration) {
+	if size > c.maxBytes || ttl <= 0 {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if element, exists := c.entries[key]; exists {
+		c.remove(element)
+	}
+
+	e := &entry{key: key, value: value, size: size, expires: time.Now().Add(ttl)}
+	c.entries[key] = c.order.PushFront(e)
+	c.size += size
+
+	for c.size > c.maxBytes {
+		c.remove(c.order.Back())
+	}
+}
+
+// remove deletes an entry. Must be called with the mutex held.
+func (c *Cache) remove(element *list.Element) {
+	e := element.Value.(*entry)
+	c.order.Remove(element)
+	delete(c.entries, e.key)
+	c.size -= e.size
+}
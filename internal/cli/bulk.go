@@ -0,0 +1,70 @@
+package cli
+
+import (
+	"fmt"
+	"path"
+	"skyport-agent/internal/apperr"
+	"skyport-agent/internal/config"
+	"sort"
+	"strings"
+)
+
+// isTunnelPattern reports whether a tunnel argument is a glob such as web-*
+func isTunnelPattern(arg string) bool {
+	return strings.ContainsAny(arg, "*?[")
+}
+
+// selectTunnels resolves tunnel names, IDs and glob patterns over names, in
+// the order given; the tunnels a pattern matches are sorted by name. It fails
+// for an argument that matches no tunnel or a malformed pattern.
+func selectTunnels(tunnels []*config.Tunnel, args []string) ([]*config.Tunnel, error) {
+	var selected []*config.Tunnel
+	seen := make(map[string]bool)
+	for _, arg := range args {
+		var matches []*config.Tunnel
+		for _, t := range tunnels {
+			if isTunnelPattern(arg) {
+				ok, err := path.Match(arg, t.Name)
+				if err != nil {
+					return nil, fmt.Errorf("invalid tunnel pattern %q: %w", arg, err)
+				}
+				if ok {
+					matches = append(matches, t)
+				}
+			} else if t.ID == arg || t.Name == arg {
+				matches = append(matches, t)
+				break
+			}
+		}
+		if len(matches) == 0 {
+			if isTunnelPattern(arg) {
+				return nil, apperr.ErrTunnelNotFound.Withf("No tunnel matches '%s'", arg)
+			}
+			return nil, apperr.ErrTunnelNotFound.Withf("Tunnel '%s' not found", arg)
+		}
+
+		sort.Slice(matches, func(i, j int) bool { return matches[i].Name < matches[j].Name })
+		for _, t := range matches {
+			if !seen[t.ID] {
+				seen[t.ID] = true
+				selected = append(selected, t)
+			}
+		}
+	}
+	return selected, nil
+}
+
+// tunnelRefs returns pointers to the tunnels of a list, for selectTunnels
+func tunnelRefs(tunnels []config.Tunnel) []*config.Tunnel {
+	refs := make([]*config.Tunnel, len(tunnels))
+	for i := range tunnels {
+		refs[i] = &tunnels[i]
+	}
+	return refs
+}
+
+// manyTunnels reports whether run or stop arguments name more than one
+// tunnel, or may
+func manyTunnels(args []string) bool {
+	return len(args) > 1 || (len(args) == 1 && isTunnelPattern(args[0]))
+}
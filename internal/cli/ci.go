@@ -0,0 +1,67 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// writeCIOutputs publishes a tunnel's public URL for later pipeline steps: to
+// urlFile if set, and as the "url" step output when running in GitHub Actions
+func writeCIOutputs(publicURL, urlFile string) error {
+	if urlFile != "" {
+		if err := os.WriteFile(urlFile, []byte(publicURL+"\n"), 0644); err != nil {
+			return fmt.Errorf("failed to write URL file: %w", err)
+		}
+	}
+
+	if outputFile := os.Getenv("GITHUB_OUTPUT"); outputFile != "" {
+		f, err := os.OpenFile(outputFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return fmt.Errorf("failed to open GITHUB_OUTPUT: %w", err)
+		}
+		defer f.Close()
+		if _, err := fmt.Fprintf(f, "url=%s\n", publicURL); err != nil {
+			return fmt.Errorf("failed to write GITHUB_OUTPUT: %w", err)
+		}
+	}
+	return nil
+}
+
+// watchCIExit reports on the returned channel why a CI run should end: its
+// stdin was closed, or the process that started it exited. Stdin is only
+// watched when it is a pipe or terminal, since steps started without one
+// (stdin is /dev/null) would otherwise stop immediately.
+func watchCIExit() <-chan string {
+	done := make(chan string, 2)
+
+	if info, err := os.Stdin.Stat(); err == nil && info.Mode()&(os.ModeNamedPipe|os.ModeSocket|os.ModeCharDevice) != 0 && !isDevNull(info) {
+		go func() {
+			io.Copy(io.Discard, os.Stdin)
+			done <- "stdin closed"
+		}()
+	}
+
+	// An orphaned process is re-parented (to init or a subreaper), which
+	// changes its parent PID
+	parent := os.Getppid()
+	if parent > 1 {
+		go func() {
+			for range time.Tick(time.Second) {
+				if os.Getppid() != parent {
+					done <- "parent process exited"
+					return
+				}
+			}
+		}()
+	}
+
+	return done
+}
+
+// isDevNull reports whether a file is the null device
+func isDevNull(info os.FileInfo) bool {
+	null, err := os.Stat(os.DevNull)
+	return err == nil && os.SameFile(info, null)
+}
@@ -0,0 +1,71 @@
+package cli
+
+import (
+	"fmt"
+	"skyport-agent/internal/config"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// configKeys are the values 'skyport config get' can show
+var configKeys = map[string]func() (string, error){
+	// The token local clients send to the control API of running agents
+	"admin-token": func() (string, error) {
+		return config.NewConfigManager().AdminToken()
+	},
+	"server-url": func() (string, error) {
+		return config.Load().ServerURL, nil
+	},
+	"web-url": func() (string, error) {
+		return config.Load().WebURL, nil
+	},
+	"tunnel-domain": func() (string, error) {
+		return config.Load().TunnelDomain, nil
+	},
+}
+
+var configCmd = &cobra.Command{
+	Use:         "config",
+	Short:       "Show agent configuration values",
+	Annotations: map[string]string{offlineAnnotation: "true"},
+}
+
+var configGetCmd = &cobra.Command{
+	Use:   "get <key>",
+	Short: "Print a configuration value",
+	Long: `Print a configuration value, for use in scripts.
+
+Keys:
+  admin-token    Token for the local control API of running agents (generated on first use)
+  server-url     SkyPort server API address
+  web-url        SkyPort dashboard address
+  tunnel-domain  Domain tunnels are served under
+
+Example:
+  curl --unix-socket ~/.skyport/run/control-<pid>.sock \
+    -H "Authorization: Bearer $(skyport config get admin-token)" http://skyport/v1/stats`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		get, ok := configKeys[args[0]]
+		if !ok {
+			keys := make([]string, 0, len(configKeys))
+			for key := range configKeys {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			exitWithError(fmt.Errorf("unknown key '%s'; use one of: %s", args[0], strings.Join(keys, ", ")))
+		}
+
+		value, err := get()
+		if err != nil {
+			exitWithError(err)
+		}
+		fmt.Println(value)
+	},
+}
+
+func init() {
+	configCmd.AddCommand(configGetCmd)
+}
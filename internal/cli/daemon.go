@@ -1,11 +1,15 @@
 package cli
 
 import (
+	"fmt"
 	"os"
 	"os/signal"
+	"skyport-agent/internal/apperr"
 	"skyport-agent/internal/config"
+	"skyport-agent/internal/crash"
 	"skyport-agent/internal/logger"
 	"skyport-agent/internal/service"
+	"skyport-agent/internal/state"
 	"syscall"
 	"time"
 
@@ -30,6 +34,10 @@ var (
 		logLevel       string
 		foreground     bool
 		connectTunnels []string
+		mdns           bool
+		allowRoot      bool
+		waitForPort    bool
+		takeOver       bool
 	}{}
 )
 
@@ -38,22 +46,39 @@ func init() {
 	daemonCmd.Flags().StringVar(&daemonConfig.logLevel, "log-level", "info", "Log level (debug, info, warn, error)")
 	daemonCmd.Flags().BoolVar(&daemonConfig.foreground, "foreground", false, "Run in foreground (for debugging)")
 	daemonCmd.Flags().StringSliceVar(&daemonConfig.connectTunnels, "connect-tunnel", []string{}, "Tunnel ID(s) to connect on start")
+	daemonCmd.Flags().BoolVar(&daemonConfig.mdns, "mdns", false, "Advertise connected tunnels' URLs on the local network")
+	daemonCmd.Flags().BoolVar(&daemonConfig.allowRoot, "allow-root", false, "Allow the daemon to run as root")
+	daemonCmd.Flags().BoolVar(&daemonConfig.waitForPort, "wait-for-port", false, "Connect --connect-tunnel tunnels only while their local port is open")
+	daemonCmd.Flags().BoolVar(&daemonConfig.takeOver, "take-over", false, "Take --connect-tunnel tunnels over from other machines they are running on")
 }
 
 func runDaemon(cmd *cobra.Command, args []string) {
+	defer crash.Recover("daemon")
+
+	// Tunnels forward internet traffic into this machine, so the daemon runs
+	// with as few privileges as possible
+	if os.Geteuid() == 0 && !daemonConfig.allowRoot {
+		rootErr := apperr.ErrStartFailed.Withf("Refusing to run the daemon as root")
+		rootErr.Hint = "Run it as a regular user, or pass --allow-root"
+		exitWithError(rootErr)
+	}
+
 	logger.Debug("Starting SkyPort Agent Daemon...")
 
 	// Load configuration
 	cfg, err := loadDaemonConfig()
 	if err != nil {
-		logger.Error("Failed to load configuration: %v", err)
-		os.Exit(1)
+		exitWithError(fmt.Errorf("failed to load configuration: %w", err))
 	}
 
 	logger.Debug("Configuration loaded successfully")
 	logger.Debug("Server URL: %s", cfg.ServerURL)
 	logger.Debug("Tunnel Domain: %s", cfg.TunnelDomain)
 
+	if daemonConfig.mdns {
+		cfg.GetSettings().MDNS.Enabled = true
+	}
+
 	// Create service manager
 	manager := service.NewManager(cfg)
 	logger.Debug("Service manager created")
@@ -63,7 +88,7 @@ func runDaemon(cmd *cobra.Command, args []string) {
 	logger.Debug("Health monitor created")
 
 	// Create network monitor
-	networkMonitor := service.NewNetworkMonitor()
+	networkMonitor := service.NewNetworkMonitor(cfg)
 	logger.Debug("Network monitor created")
 
 	// Start background manager
@@ -77,6 +102,14 @@ func runDaemon(cmd *cobra.Command, args []string) {
 			// Small delay to allow auth/monitors to initialize
 			time.Sleep(500 * time.Millisecond)
 			for _, tID := range daemonConfig.connectTunnels {
+				if daemonConfig.takeOver {
+					manager.AllowTakeover(tID)
+				}
+				if daemonConfig.waitForPort {
+					watchTunnelPort(manager, tID)
+					continue
+				}
+
 				logger.Debug("Attempting to connect tunnel: %s", tID)
 				// Enable auto-reconnect (true) so tunnel stays connected
 				if err := manager.ConnectTunnel(tID, true); err != nil {
@@ -116,11 +149,37 @@ func runDaemon(cmd *cobra.Command, args []string) {
 	}
 }
 
+// watchTunnelPort connects a tunnel whenever its local port is open and
+// disconnects it when the port closes
+func watchTunnelPort(manager *service.Manager, tunnelID string) {
+	changes, err := manager.WatchPort(tunnelID)
+	if err != nil {
+		logger.Error("Failed to watch the local port of tunnel %s: %v", tunnelID, err)
+		return
+	}
+	logger.Info("Waiting for the local port of tunnel %s to open before connecting", tunnelID)
+
+	go func() {
+		for change := range changes {
+			switch {
+			case change.Listening && change.Err != nil:
+				logger.Error("Local port %s is open but tunnel %s failed to connect: %v", change.Address, tunnelID, change.Err)
+			case change.Listening:
+				logger.Info("Connected tunnel: %s (local port %s is open)", tunnelID, change.Address)
+			case change.Err != nil:
+				logger.Error("Failed to disconnect tunnel %s: %v", tunnelID, change.Err)
+			}
+		}
+	}()
+}
+
 func loadDaemonConfig() (*config.Config, error) {
 	return config.Load(), nil
 }
 
 func handleNetworkChanges(networkMonitor *service.NetworkMonitor, manager *service.Manager) {
+	defer crash.Recover("network change handler")
+
 	changeChan := networkMonitor.GetChangeChannel()
 
 	for change := range changeChan {
@@ -141,22 +200,13 @@ func handleNetworkChanges(networkMonitor *service.NetworkMonitor, manager *servi
 func handleIPChange(manager *service.Manager) {
 	logger.Debug("Handling IP address change...")
 
-	// Disconnect all tunnels
+	// Reconnect immediately rather than waiting for the old connections to time
+	// out. Where the server supports it the new connection is established
+	// before the old one is torn down, so requests keep flowing.
 	activeTunnels := manager.GetActiveTunnels()
 	for _, tunnelID := range activeTunnels {
-		logger.Debug("Disconnecting tunnel %s due to IP change", tunnelID)
-		if err := manager.DisconnectTunnel(tunnelID); err != nil {
-			logger.Error("Error disconnecting tunnel %s: %v", tunnelID, err)
-		}
-	}
-
-	// Wait a moment for disconnections to complete
-	time.Sleep(2 * time.Second)
-
-	// Reconnect tunnels
-	for _, tunnelID := range activeTunnels {
-		logger.Info("Reconnecting tunnel %s after IP change", tunnelID)
-		if err := manager.ConnectTunnel(tunnelID, false); err != nil {
+		logger.Info("Reconnecting tunnel %s after network change", tunnelID)
+		if err := manager.ReconnectTunnel(tunnelID); err != nil {
 			logger.Error("Error reconnecting tunnel %s: %v", tunnelID, err)
 		}
 	}
@@ -204,6 +254,11 @@ func gracefulShutdown(manager *service.Manager, healthMonitor *service.HealthMon
 	manager.StopSilently()
 	logger.Debug("Manager stopped")
 
+	// Forget this process in the background process records
+	for _, tID := range daemonConfig.connectTunnels {
+		state.UnregisterProcess(tID, os.Getpid())
+	}
+
 	logger.Info("Graceful shutdown complete")
 }
 
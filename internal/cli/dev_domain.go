@@ -0,0 +1,206 @@
+package cli
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"skyport-agent/internal/config"
+	"skyport-agent/internal/hostsfile"
+	"skyport-agent/internal/logger"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+var devDomainCmd = &cobra.Command{
+	Use:   "dev-domain",
+	Short: "Map local domains such as myapp.test to tunnels' local services",
+	Long: `Map a friendly local domain to a tunnel's local service in the system hosts
+file, so cookies and absolute URLs behave in local testing the way they do
+under the tunnel's public URL. The hosts file only maps names to addresses:
+the domain points at the host of the tunnel's local target, and is opened
+with the tunnel's local port, e.g. http://myapp.test:3000.
+
+Entries are marked as added by skyport, and only those are ever changed or
+removed. Updating the hosts file needs administrator rights; sudo asks for
+your password when needed.
+
+Example:
+  skyport dev-domain add myapp.test myapp
+  skyport dev-domain list
+  skyport dev-domain remove myapp.test`,
+	Annotations: map[string]string{offlineAnnotation: "true"},
+}
+
+var devDomainAddCmd = &cobra.Command{
+	Use:   "add <domain> [tunnel-name-or-id]",
+	Short: "Point a local domain at a tunnel's local service",
+	Long: `Point a local domain at a tunnel's local service. The tunnel can be left
+out when you have only one.`,
+	Args: cobra.RangeArgs(1, 2),
+	Run:  runDevDomainAdd,
+}
+
+var devDomainRemoveCmd = &cobra.Command{
+	Use:   "remove [domain...]",
+	Short: "Remove local domains added with 'dev-domain add'",
+	Run:   runDevDomainRemove,
+}
+
+var devDomainListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List local domains added with 'dev-domain add'",
+	Args:  cobra.NoArgs,
+	Run:   runDevDomainList,
+}
+
+func init() {
+	devDomainRemoveCmd.Flags().Bool("all", false, "Remove every domain added with 'dev-domain add'")
+	devDomainCmd.AddCommand(devDomainAddCmd)
+	devDomainCmd.AddCommand(devDomainRemoveCmd)
+	devDomainCmd.AddCommand(devDomainListCmd)
+}
+
+func runDevDomainAdd(cmd *cobra.Command, args []string) {
+	domain := strings.ToLower(args[0])
+	if err := hostsfile.ValidDomain(domain); err != nil {
+		exitWithError(err)
+	}
+
+	configManager := config.NewConfigManager()
+	var t *config.Tunnel
+	if len(args) == 2 {
+		t = findLocalTunnel(configManager, args[1])
+	} else {
+		t = onlyLocalTunnel(configManager)
+	}
+
+	host, port, err := net.SplitHostPort(t.LocalAddress())
+	if err != nil {
+		exitWithError(fmt.Errorf("invalid local target %s: %w", t.LocalAddress(), err))
+	}
+	ip, err := hostAddress(host)
+	if err != nil {
+		exitWithError(err)
+	}
+
+	path := hostsfile.Path()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		exitWithError(fmt.Errorf("failed to read %s: %w", path, err))
+	}
+	updated, err := hostsfile.Add(data, hostsfile.Entry{Domain: domain, IP: ip, Tunnel: t.Name})
+	if err != nil {
+		exitWithError(err)
+	}
+	writeHostsFile(path, updated)
+
+	fmt.Printf(" %s %s now points to %s (tunnel '%s')\n", logger.SuccessMark(), domain, ip, t.Name)
+	fmt.Printf(" Open http://%s for local testing; the public URL is unchanged\n", net.JoinHostPort(domain, port))
+}
+
+func runDevDomainRemove(cmd *cobra.Command, args []string) {
+	all, _ := cmd.Flags().GetBool("all")
+	if all == (len(args) > 0) {
+		exitWithError(fmt.Errorf("name the domains to remove, or use --all"))
+	}
+
+	path := hostsfile.Path()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		exitWithError(fmt.Errorf("failed to read %s: %w", path, err))
+	}
+
+	domains := args
+	if all {
+		for _, entry := range hostsfile.Entries(data) {
+			domains = append(domains, entry.Domain)
+		}
+		if len(domains) == 0 {
+			fmt.Println(" No local domains to remove")
+			return
+		}
+	}
+
+	var removed []string
+	for _, domain := range domains {
+		domain = strings.ToLower(domain)
+		var ok bool
+		if data, ok = hostsfile.Remove(data, domain); ok {
+			removed = append(removed, domain)
+		} else {
+			fmt.Printf(" %s %s was not added with 'skyport dev-domain add'\n", logger.WarningMark(), domain)
+		}
+	}
+	if len(removed) == 0 {
+		exitWithError(fmt.Errorf("no domains removed"))
+	}
+	writeHostsFile(path, data)
+
+	for _, domain := range removed {
+		fmt.Printf(" %s Removed %s\n", logger.SuccessMark(), domain)
+	}
+}
+
+func runDevDomainList(cmd *cobra.Command, args []string) {
+	path := hostsfile.Path()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		exitWithError(fmt.Errorf("failed to read %s: %w", path, err))
+	}
+
+	entries := hostsfile.Entries(data)
+	if len(entries) == 0 {
+		fmt.Println(" No local domains. Add one with 'skyport dev-domain add <domain> <tunnel>'")
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "DOMAIN\tADDRESS\tTUNNEL")
+	for _, entry := range entries {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", entry.Domain, entry.IP, entry.Tunnel)
+	}
+	w.Flush()
+}
+
+// onlyLocalTunnel returns the only tunnel in the local config, exiting if
+// there are none or several
+func onlyLocalTunnel(configManager *config.ConfigManager) *config.Tunnel {
+	appConfig, err := configManager.LoadConfig()
+	if err != nil {
+		exitWithError(fmt.Errorf("failed to load config: %w", err))
+	}
+	if len(appConfig.Tunnels) != 1 {
+		exitWithError(fmt.Errorf("name the tunnel: skyport dev-domain add <domain> <tunnel-name-or-id>"))
+	}
+	for _, t := range appConfig.Tunnels {
+		return t
+	}
+	return nil
+}
+
+// hostAddress returns the IP address a local target host is reached at
+func hostAddress(host string) (string, error) {
+	if host == "localhost" {
+		return "127.0.0.1", nil
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.String(), nil
+	}
+	addrs, err := net.LookupHost(host)
+	if err != nil || len(addrs) == 0 {
+		return "", fmt.Errorf("failed to resolve the tunnel's local host %s: %w", host, err)
+	}
+	return addrs[0], nil
+}
+
+// writeHostsFile writes the hosts file, through sudo when needed
+func writeHostsFile(path string, data []byte) {
+	if !hostsfile.CanWrite(path) {
+		fmt.Printf(" Updating %s needs administrator rights\n", path)
+	}
+	if err := hostsfile.Write(path, data); err != nil {
+		exitWithError(fmt.Errorf("failed to update %s: %w", path, err))
+	}
+}
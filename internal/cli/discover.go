@@ -0,0 +1,121 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"skyport-agent/internal/config"
+	"skyport-agent/internal/docker"
+	"skyport-agent/internal/localnet"
+	"skyport-agent/internal/service"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var discoverCmd = &cobra.Command{
+	Use:   "discover [docker|local]",
+	Short: "Find local services and containers to create tunnels for",
+	Long: `'skyport discover local' scans common development ports on localhost (or
+the ports given with --ports), identifies what answers on them from its HTTP
+response and page title, and offers to create a tunnel for each service that
+has none.
+
+'skyport discover docker' lists running containers labelled for SkyPort and
+the tunnels they match. Label a docker-compose service with the subdomain (or
+name) of one of your tunnels and the container port to forward:
+
+  services:
+    web:
+      labels:
+        skyport.subdomain: myapp
+        skyport.port: "3000"
+
+With "discovery": {"docker": true} in the settings of skyport.json, the
+daemon points matching tunnels at their containers and connects them while
+the containers run, disconnecting them when the containers go away.
+
+Example:
+  skyport discover local
+  skyport discover local --ports 3000-3999,8080
+  skyport discover docker`,
+	Args:        cobra.ExactArgs(1),
+	ValidArgs:   []string{"docker", "local"},
+	Annotations: map[string]string{offlineAnnotation: "true"},
+	Run:         runDiscover,
+}
+
+func init() {
+	discoverCmd.Flags().String("host", "", "Docker daemon address (default: settings, DOCKER_HOST, then the local socket)")
+	discoverCmd.Flags().String("ports", localnet.DefaultScanPorts, "Localhost ports to scan with 'local', e.g. 3000-3010,8080")
+	discoverCmd.Flags().Duration("timeout", time.Second, "How long to wait for each port with 'local'")
+}
+
+func runDiscover(cmd *cobra.Command, args []string) {
+	switch args[0] {
+	case "docker":
+		discoverDocker(cmd)
+	case "local":
+		discoverLocal(cmd)
+	default:
+		exitWithError(fmt.Errorf("first argument must be 'docker' or 'local'"))
+	}
+}
+
+// discoverDocker lists containers labelled for SkyPort and their tunnels
+func discoverDocker(cmd *cobra.Command) {
+	defaultConfig := config.Load()
+	settings := defaultConfig.GetSettings().Discovery
+
+	host, _ := cmd.Flags().GetString("host")
+	if host == "" {
+		host = settings.DockerHost
+	}
+	client, err := docker.NewClient(host)
+	if err != nil {
+		exitWithError(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	containers, err := client.LabeledContainers(ctx)
+	if err != nil {
+		exitWithError(err)
+	}
+
+	services, problems := docker.Services(containers)
+	if len(services) == 0 && len(problems) == 0 {
+		fmt.Printf(" No running containers have a %s label.\n", docker.LabelPort)
+		return
+	}
+
+	var tunnels []*config.Tunnel
+	if appConfig, err := config.NewConfigManager().LoadConfig(); err == nil {
+		for _, tunnel := range appConfig.Tunnels {
+			tunnels = append(tunnels, tunnel)
+		}
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SERVICE\tCONTAINER\tTARGET\tTUNNEL")
+	for _, svc := range services {
+		match := "(no matching tunnel)"
+		if tunnel := service.MatchTunnel(tunnels, svc); tunnel != nil {
+			match = fmt.Sprintf("%s (%s.%s)", tunnel.Name, tunnel.Subdomain, defaultConfig.TunnelDomain)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", svc.Describe(), svc.Container, svc.Target, match)
+	}
+	w.Flush()
+
+	for _, problem := range problems {
+		fmt.Printf(" Ignored: %v\n", problem)
+	}
+
+	fmt.Println()
+	if settings.Docker {
+		fmt.Println(" Docker discovery is enabled; the daemon keeps these tunnels connected.")
+	} else {
+		fmt.Println(` Set "discovery": {"docker": true} in skyport.json settings to let the daemon connect them.`)
+	}
+}
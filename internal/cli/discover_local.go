@@ -0,0 +1,156 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"skyport-agent/internal/apperr"
+	"skyport-agent/internal/auth"
+	"skyport-agent/internal/config"
+	"skyport-agent/internal/localnet"
+	"skyport-agent/internal/logger"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// nonSubdomainChars are replaced when suggesting a tunnel name from a page title
+var nonSubdomainChars = regexp.MustCompile(`[^a-z0-9]+`)
+
+// discoverLocal scans localhost ports for services and offers to create
+// tunnels for those that have none
+func discoverLocal(cmd *cobra.Command) {
+	spec, _ := cmd.Flags().GetString("ports")
+	timeout, _ := cmd.Flags().GetDuration("timeout")
+	ports, err := localnet.ParsePorts(spec)
+	if err != nil {
+		exitWithError(err)
+	}
+	if timeout < 50*time.Millisecond {
+		exitWithError(fmt.Errorf("timeout must be at least 50ms"))
+	}
+
+	scanning := startProgress(fmt.Sprintf("Scanning %d localhost port(s)", len(ports)))
+	services := localnet.Scan(context.Background(), ports, timeout)
+	scanning.Stop()
+	if len(services) == 0 {
+		fmt.Printf(" Nothing is listening on the scanned ports (%s).\n", spec)
+		fmt.Println(" Scan others with --ports, e.g. --ports 3000-3999")
+		return
+	}
+
+	configManager := config.NewConfigManager()
+	var tunnels []*config.Tunnel
+	if appConfig, err := configManager.LoadConfig(); err == nil {
+		for _, t := range appConfig.Tunnels {
+			tunnels = append(tunnels, t)
+		}
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PORT\tSERVICE\tTUNNEL")
+	var untunneled []localnet.Service
+	for _, svc := range services {
+		tunnel := "-"
+		if t := tunnelForPort(tunnels, svc.Port); t != nil {
+			tunnel = t.Name
+		} else if svc.HTTP {
+			untunneled = append(untunneled, svc)
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\n", svc.Port, svc.Describe(), tunnel)
+	}
+	w.Flush()
+
+	if len(untunneled) == 0 {
+		return
+	}
+	fmt.Println()
+	if !interactive() && !assumeYes {
+		for _, svc := range untunneled {
+			fmt.Printf(" Create a tunnel for port %d with 'skyport tunnel create %s --port %d'\n", svc.Port, suggestTunnelName(svc), svc.Port)
+		}
+		return
+	}
+
+	var authManager *auth.AuthManager
+	var token string
+	for _, svc := range untunneled {
+		if !confirm(fmt.Sprintf(" Create a tunnel for localhost:%d (%s)?", svc.Port, svc.Describe())) {
+			continue
+		}
+
+		name := suggestTunnelName(svc)
+		if answer, ok := promptLine(fmt.Sprintf(" Tunnel name [%s]: ", name)); ok && strings.TrimSpace(answer) != "" {
+			name = strings.TrimSpace(answer)
+		}
+		subdomain := strings.ToLower(name)
+		if !subdomainPattern.MatchString(subdomain) {
+			fmt.Printf(" %s Invalid name '%s': use lowercase letters, digits and dashes\n", logger.ErrorMark(), name)
+			continue
+		}
+
+		// Log in only once a tunnel is actually wanted
+		if authManager == nil {
+			authManager = auth.NewAuthManager(config.Load())
+			if !authManager.IsAuthenticated() {
+				exitWithError(apperr.ErrNotAuthenticated)
+			}
+			if token, err = authManager.GetValidToken(); err != nil {
+				exitWithError(explainError(err, apperr.ErrSessionExpired))
+			}
+			refuseReadOnly(token, "create tunnels")
+		}
+
+		creating := startProgress(fmt.Sprintf("Creating tunnel %s", name))
+		created, err := authManager.CreateTunnel(token, name, subdomain, svc.Port)
+		if errors.Is(err, auth.ErrSubdomainTaken) {
+			creating.Fail(fmt.Sprintf("Subdomain '%s' is already taken; run discover again to pick another name", subdomain))
+			continue
+		}
+		if err != nil {
+			creating.Stop()
+			exitWithError(explainError(err, apperr.ErrStartFailed.Withf("Failed to create tunnel '%s'", name)))
+		}
+		if err := configManager.AddTunnel(created); err != nil {
+			exitWithError(fmt.Errorf("failed to save tunnel: %w", err))
+		}
+		// The new tunnel must show up in the next list
+		configManager.InvalidateTunnelCache()
+		creating.Done(fmt.Sprintf("Created tunnel '%s' for localhost:%d. Start it with 'skyport tunnel run %s'",
+			created.Name, svc.Port, created.Name))
+	}
+}
+
+// tunnelForPort returns a local tunnel forwarding to the given localhost
+// port, or nil
+func tunnelForPort(tunnels []*config.Tunnel, port int) *config.Tunnel {
+	for _, t := range tunnels {
+		host, portText, err := net.SplitHostPort(t.LocalAddress())
+		if err != nil || portText != strconv.Itoa(port) {
+			continue
+		}
+		if ip := net.ParseIP(host); host == "localhost" || (ip != nil && ip.IsLoopback()) {
+			return t
+		}
+	}
+	return nil
+}
+
+// suggestTunnelName makes a tunnel name from a service's page title, or
+// from its port
+func suggestTunnelName(svc localnet.Service) string {
+	name := strings.Trim(nonSubdomainChars.ReplaceAllString(strings.ToLower(svc.Title), "-"), "-")
+	if len(name) > 30 {
+		name = strings.TrimRight(name[:30], "-")
+	}
+	if name == "" || !subdomainPattern.MatchString(name) {
+		return fmt.Sprintf("app-%d", svc.Port)
+	}
+	return name
+}
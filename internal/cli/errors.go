@@ -0,0 +1,164 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"skyport-agent/internal/apperr"
+	"skyport-agent/internal/auth"
+	"skyport-agent/internal/config"
+	"skyport-agent/internal/logger"
+	"skyport-agent/internal/network"
+	"skyport-agent/internal/tunnel"
+	"time"
+)
+
+// exitWithError reports err with its hint and ends the process with its exit
+// status. The underlying error is only shown with --debug.
+func exitWithError(err error) {
+	var appErr *apperr.Error
+	if !errors.As(err, &appErr) {
+		appErr = &apperr.Error{Code: apperr.ExitFailure, Message: err.Error()}
+	}
+
+	fmt.Printf(" %s %s\n", logger.ErrorMark(), appErr.Message)
+	if appErr.Hint != "" {
+		fmt.Printf(" %s\n", appErr.Hint)
+	}
+	if appErr.Err != nil && config.IsDebugMode() {
+		fmt.Printf(" [DEBUG] %v\n", appErr.Err)
+	}
+	recordUsage(appErr, appErr.Code)
+	os.Exit(appErr.Code)
+}
+
+// explainError turns errors from the lower layers into the error the user is
+// shown. Errors it does not recognise become fallback, wrapping them.
+func explainError(err error, fallback *apperr.Error) *apperr.Error {
+	var appErr *apperr.Error
+	switch {
+	case errors.As(err, &appErr):
+		return appErr
+	case errors.Is(err, network.ErrNoInternet):
+		return apperr.ErrNoInternet.Wrap(err)
+	case errors.Is(err, network.ErrServerUnreachable), errors.Is(err, auth.ErrServerUnreachable):
+		return apperr.ErrServerUnreachable.Wrap(err)
+	case errors.Is(err, tunnel.ErrUnauthorized):
+		return apperr.ErrSessionExpired.Wrap(err)
+	case errors.Is(err, auth.ErrReadOnlyToken):
+		return apperr.ErrReadOnlyToken.Wrap(err)
+	case errors.Is(err, tunnel.ErrSubdomainInUse):
+		return apperr.ErrSubdomainTaken.Wrap(err)
+	case errors.Is(err, tunnel.ErrRunningElsewhere):
+		return apperr.ErrTunnelRunning.Wrap(err)
+	case errors.Is(err, tunnel.ErrServerBusy):
+		busy := apperr.ErrServerBusy.Wrap(err)
+		var busyErr *tunnel.ServerBusyError
+		if errors.As(err, &busyErr) && busyErr.RetryAfter > 0 {
+			busy.Hint = fmt.Sprintf("The server asked to retry after %v; try again then", busyErr.RetryAfter)
+		}
+		return busy
+	default:
+		return fallback.Wrap(err)
+	}
+}
+
+// refuseReadOnly exits before an operation a read-only login token may not
+// perform, such as creating or stopping tunnels
+func refuseReadOnly(token, operation string) {
+	if auth.IsReadOnlyToken(token) {
+		exitWithError(apperr.ErrReadOnlyToken.Withf("Cannot %s: this agent's login token is read-only", operation))
+	}
+}
+
+// explainConnectError explains why a tunnel failed to connect. A local
+// service that is not listening is the most common cause the server's error
+// does not reveal, so it is checked for directly.
+func explainConnectError(err error, t *config.Tunnel) *apperr.Error {
+	var elsewhere *tunnel.RunningElsewhereError
+	switch {
+	case errors.As(err, &elsewhere):
+		return tunnelRunningError(t.Name, elsewhere.Owner).Wrap(err)
+	case errors.Is(err, tunnel.ErrLocalNotListening):
+		return apperr.ErrLocalPortClosed.Withf("Nothing is listening on %s", t.LocalAddress()).Wrap(err)
+	case errors.Is(err, tunnel.ErrLocalUnhealthy):
+		return apperr.ErrLocalUnhealthy.Wrap(err)
+	case errors.Is(err, tunnel.ErrTunnelExpired):
+		expired := apperr.ErrStartFailed.Withf("Tunnel '%s' has expired", t.Name).Wrap(err)
+		expired.Hint = fmt.Sprintf("Start it with 'skyport tunnel run %s' to use it again", t.Name)
+		return expired
+	}
+
+	explained := explainError(err, apperr.ErrStartFailed)
+	if !errors.Is(explained, apperr.ErrStartFailed) || t.StaticSettings().Dir != "" {
+		return explained
+	}
+
+	conn, dialErr := net.DialTimeout("tcp", t.LocalAddress(), 2*time.Second)
+	if dialErr != nil {
+		return apperr.ErrLocalPortClosed.Withf("Nothing is listening on %s", t.LocalAddress()).Wrap(err)
+	}
+	conn.Close()
+	return explained
+}
+
+// tunnelRunningError explains that a tunnel is already running: where and
+// since when, as far as the server says, and how to stop or take it over
+func tunnelRunningError(name string, owner tunnel.Owner) *apperr.Error {
+	running := apperr.ErrTunnelRunning.Withf("Tunnel '%s' is already running%s", name, describeOwner(owner))
+	if isThisMachine(owner) {
+		running.Hint = fmt.Sprintf("Use 'skyport tunnel stop %s' to stop it first", name)
+		return running
+	}
+	running.Hint = fmt.Sprintf("Use 'skyport tunnel stop %s' to stop it, or 'skyport tunnel run %s --force' to take it over", name, name)
+	return running
+}
+
+// tunnelOwner returns the connection holding a running tunnel, as listed by
+// the server
+func tunnelOwner(t *config.Tunnel) tunnel.Owner {
+	return tunnel.Owner{Host: t.ConnectedHost, MachineID: t.ConnectedMachineID, Since: t.ConnectedAt}
+}
+
+// describeOwner says where and since when a tunnel runs, as in " on laptop
+// (machine 3f2a9c1e07b4d5a6) since 09:14 (2h5m0s ago)", or returns "" if the
+// server did not say
+func describeOwner(owner tunnel.Owner) string {
+	var where string
+	switch {
+	case isThisMachine(owner):
+		where = " on this machine"
+	case owner.Host != "" && owner.MachineID != "":
+		where = fmt.Sprintf(" on %s (machine %s)", owner.Host, owner.MachineID)
+	case owner.Host != "":
+		where = fmt.Sprintf(" on %s", owner.Host)
+	case owner.MachineID != "":
+		where = fmt.Sprintf(" on machine %s", owner.MachineID)
+	}
+	if !owner.Since.IsZero() {
+		where += fmt.Sprintf(" since %s (%s ago)", formatSince(owner.Since), time.Since(owner.Since).Round(time.Second))
+	}
+	return where
+}
+
+// isThisMachine reports whether a tunnel's connection is from this agent's
+// machine. The machine ID decides when the server reports it, since
+// hostnames need not be unique.
+func isThisMachine(owner tunnel.Owner) bool {
+	if owner.MachineID != "" {
+		machineID, err := config.NewConfigManager().MachineID()
+		return err == nil && machineID == owner.MachineID
+	}
+	hostname, err := os.Hostname()
+	return owner.Host != "" && err == nil && hostname == owner.Host
+}
+
+// formatSince shows a time of day, with the date if it is not today
+func formatSince(t time.Time) string {
+	t = t.Local()
+	if now := time.Now(); t.YearDay() == now.YearDay() && t.Year() == now.Year() {
+		return t.Format("15:04")
+	}
+	return t.Format("2006-01-02 15:04")
+}
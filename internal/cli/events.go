@@ -0,0 +1,79 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"skyport-agent/internal/journal"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var eventsCmd = &cobra.Command{
+	Use:   "events",
+	Short: "Show tunnel connection and authentication history",
+	Long: `Show the persistent journal of tunnel lifecycle events (connected, disconnected,
+reconnecting, reconnected, auth failures, waits for a busy server, responses blocked or redacted by content
+scanning, local target switches) and logins/logouts, recorded by every skyport process on this machine.
+
+Example:
+  skyport events
+  skyport events --tunnel myapp --since 1h
+  skyport events --type disconnected --since 7d`,
+	Args:        cobra.NoArgs,
+	Annotations: map[string]string{offlineAnnotation: "true"},
+	Run:         runEvents,
+}
+
+func init() {
+	eventsCmd.Flags().String("tunnel", "", "Only show events for this tunnel (name or ID)")
+	eventsCmd.Flags().String("since", "24h", "How far back to show (e.g. 30m, 1h, 7d)")
+	eventsCmd.Flags().String("type", "", "Only show events of this type")
+}
+
+func runEvents(cmd *cobra.Command, args []string) {
+	tunnelFilter, _ := cmd.Flags().GetString("tunnel")
+	sinceFlag, _ := cmd.Flags().GetString("since")
+	typeFilter, _ := cmd.Flags().GetString("type")
+
+	period, err := parsePeriod(sinceFlag)
+	if err != nil {
+		exitWithError(fmt.Errorf("invalid --since value: %w", err))
+	}
+
+	entries, err := journal.Read(journal.Filter{
+		Since:  time.Now().Add(-period),
+		Tunnel: tunnelFilter,
+		Type:   typeFilter,
+	})
+	if err != nil {
+		exitWithError(fmt.Errorf("failed to read event journal: %w", err))
+	}
+
+	if len(entries) == 0 {
+		fmt.Printf(" No events in the last %s.\n", sinceFlag)
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "TIME\tTUNNEL\tEVENT\tDETAILS")
+	fmt.Fprintln(w, "----\t------\t-----\t-------")
+
+	for _, entry := range entries {
+		tunnelName := entry.Tunnel
+		if tunnelName == "" {
+			tunnelName = "-"
+		}
+
+		details := entry.Reason
+		if entry.Attempt > 0 {
+			details = fmt.Sprintf("attempt %d: %s", entry.Attempt, details)
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n",
+			entry.Time.Local().Format("2006-01-02 15:04:05"), tunnelName, entry.Type, details)
+	}
+
+	w.Flush()
+}
@@ -0,0 +1,357 @@
+package cli
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"skyport-agent/internal/apperr"
+	"skyport-agent/internal/auth"
+	"skyport-agent/internal/config"
+	"skyport-agent/internal/logger"
+	"skyport-agent/internal/service"
+	"strings"
+	"syscall"
+
+	"github.com/spf13/cobra"
+)
+
+var groupCmd = &cobra.Command{
+	Use:   "group",
+	Short: "Manage groups of tunnels that are started together",
+	Long: `Manage named groups of tunnels, kept in the local config. 'skyport tunnel run
+--group <name>' starts a group's tunnels, each after the tunnels it depends on
+(see 'skyport tunnel depends-on').
+
+Example:
+  skyport tunnel group add staging api db-admin
+  skyport tunnel run --group staging`,
+	Annotations: map[string]string{offlineAnnotation: "true"},
+}
+
+var groupListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List tunnel groups and their tunnels in start order",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		configManager := config.NewConfigManager()
+		names, err := configManager.GroupNames()
+		if err != nil {
+			exitWithError(fmt.Errorf("failed to load config: %w", err))
+		}
+		if len(names) == 0 {
+			fmt.Println(" No tunnel groups. Create one with 'skyport tunnel group add <group> <tunnel>...'")
+			return
+		}
+
+		for _, name := range names {
+			tunnels, err := configManager.GroupTunnels(name)
+			if err != nil {
+				fmt.Printf(" %s %s: %v\n", logger.ErrorMark(), name, err)
+				continue
+			}
+			fmt.Printf(" %s: %s\n", name, describeStartOrder(tunnels))
+		}
+	},
+}
+
+var groupAddCmd = &cobra.Command{
+	Use:   "add <group> <tunnel-name-or-id>...",
+	Short: "Add tunnels to a group, creating it if needed",
+	Args:  cobra.MinimumNArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		updateGroup(args[0], args[1:], addEntries)
+	},
+}
+
+var groupRemoveCmd = &cobra.Command{
+	Use:   "remove <group> <tunnel-name-or-id>...",
+	Short: "Remove tunnels from a group; a group left empty is deleted",
+	Args:  cobra.MinimumNArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		updateGroup(args[0], args[1:], removeEntries)
+	},
+}
+
+var groupDeleteCmd = &cobra.Command{
+	Use:   "delete <group>",
+	Short: "Delete a group (its tunnels are kept)",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		configManager := config.NewConfigManager()
+		if _, err := configManager.GroupTunnels(args[0]); err != nil {
+			exitWithError(err)
+		}
+		if err := configManager.SetGroup(args[0], nil); err != nil {
+			exitWithError(fmt.Errorf("failed to delete group: %w", err))
+		}
+		fmt.Printf(" %s Deleted group '%s'\n", logger.SuccessMark(), args[0])
+	},
+}
+
+var dependsOnCmd = &cobra.Command{
+	Use:   "depends-on [tunnel-name-or-id] [tunnel-name-or-id...|none]",
+	Short: "Start a tunnel only after other tunnels connect",
+	Long: `Make a tunnel start after the tunnels it depends on when they are started
+together, with 'skyport tunnel run --group' or as auto-start tunnels of the
+agent. In a group, a tunnel whose dependency fails to connect is not started.
+Running the tunnel on its own warns if a dependency is not running.
+
+Example:
+  skyport tunnel depends-on db-admin api
+  skyport tunnel depends-on db-admin none`,
+	Args:        cobra.MinimumNArgs(2),
+	Annotations: map[string]string{offlineAnnotation: "true"},
+	Run: func(cmd *cobra.Command, args []string) {
+		configManager := config.NewConfigManager()
+		tunnel := findLocalTunnel(configManager, args[0])
+
+		var dependsOn []string
+		if !(len(args) == 2 && args[1] == "none") {
+			for _, nameOrID := range args[1:] {
+				dependsOn = addEntries(dependsOn, []string{findLocalTunnel(configManager, nameOrID).ID})
+			}
+		}
+
+		if err := configManager.SetTunnelDependsOn(tunnel.ID, dependsOn); err != nil {
+			exitWithError(err)
+		}
+
+		if len(dependsOn) == 0 {
+			fmt.Printf(" Tunnel '%s' does not depend on other tunnels\n", tunnel.Name)
+			return
+		}
+		fmt.Printf(" Tunnel '%s' starts after %s\n", tunnel.Name, strings.Join(tunnelNames(configManager, dependsOn), ", "))
+	},
+}
+
+func init() {
+	groupCmd.AddCommand(groupListCmd)
+	groupCmd.AddCommand(groupAddCmd)
+	groupCmd.AddCommand(groupRemoveCmd)
+	groupCmd.AddCommand(groupDeleteCmd)
+	tunnelCmd.AddCommand(groupCmd)
+	tunnelCmd.AddCommand(dependsOnCmd)
+}
+
+// updateGroup adds tunnels to or removes them from a group
+func updateGroup(group string, namesOrIDs []string, update func(list, entries []string) []string) {
+	configManager := config.NewConfigManager()
+	appConfig, err := configManager.LoadConfig()
+	if err != nil {
+		exitWithError(fmt.Errorf("failed to load config: %w", err))
+	}
+
+	var tunnelIDs []string
+	for _, nameOrID := range namesOrIDs {
+		tunnelIDs = append(tunnelIDs, findLocalTunnel(configManager, nameOrID).ID)
+	}
+
+	members := update(appConfig.Groups[group], tunnelIDs)
+	if err := configManager.SetGroup(group, members); err != nil {
+		exitWithError(err)
+	}
+
+	if len(members) == 0 {
+		fmt.Printf(" %s Group '%s' is empty and was deleted\n", logger.SuccessMark(), group)
+		return
+	}
+	tunnels, err := configManager.GroupTunnels(group)
+	if err != nil {
+		exitWithError(err)
+	}
+	fmt.Printf(" %s Group '%s': %s\n", logger.SuccessMark(), group, describeStartOrder(tunnels))
+}
+
+// describeStartOrder lists tunnels in the order they are started
+func describeStartOrder(tunnels []*config.Tunnel) string {
+	names := make([]string, 0, len(tunnels))
+	for _, t := range tunnels {
+		names = append(names, t.Name)
+	}
+	return strings.Join(names, " "+logger.Arrow()+" ")
+}
+
+// tunnelNames returns the names of the given local tunnels
+func tunnelNames(configManager *config.ConfigManager, tunnelIDs []string) []string {
+	names := make([]string, 0, len(tunnelIDs))
+	for _, tunnelID := range tunnelIDs {
+		names = append(names, findLocalTunnel(configManager, tunnelID).Name)
+	}
+	return names
+}
+
+// runGroup starts the tunnels of a group in dependency order and keeps them
+// running until interrupted
+func runGroup(cmd *cobra.Command, group string) {
+	checkManyTunnelFlags(cmd, "--group")
+	fmt.Printf(" Starting tunnel group: %s\n", group)
+
+	runTunnels(cmd, fmt.Sprintf("of group '%s'", group), func(configManager *config.ConfigManager) []*config.Tunnel {
+		tunnels, err := configManager.GroupTunnels(group)
+		if err != nil {
+			notFound := apperr.ErrTunnelNotFound.Withf("Tunnel group '%s' could not be started", group).Wrap(err)
+			notFound.Hint = "Use 'skyport tunnel group list' to see your groups"
+			exitWithError(notFound)
+		}
+		if len(tunnels) == 0 {
+			exitWithError(fmt.Errorf("tunnel group '%s' has no tunnels", group))
+		}
+		return tunnels
+	})
+}
+
+// runSelected starts the tunnels named or matched by the arguments of 'run',
+// such as 'skyport tunnel run api web-*', in dependency order
+func runSelected(cmd *cobra.Command, args []string) {
+	checkManyTunnelFlags(cmd, "Running several tunnels")
+	fmt.Printf(" Starting tunnels: %s\n", strings.Join(args, " "))
+
+	runTunnels(cmd, "matching "+strings.Join(args, " "), func(configManager *config.ConfigManager) []*config.Tunnel {
+		appConfig, err := configManager.LoadConfig()
+		if err != nil {
+			exitWithError(fmt.Errorf("failed to load config: %w", err))
+		}
+		var all []*config.Tunnel
+		for _, t := range appConfig.Tunnels {
+			all = append(all, t)
+		}
+		selected, err := selectTunnels(all, args)
+		if err != nil {
+			exitWithError(err)
+		}
+		tunnels, err := config.StartOrder(selected)
+		if err != nil {
+			exitWithError(err)
+		}
+		return tunnels
+	})
+}
+
+// checkManyTunnelFlags rejects run flags that only make sense for a single
+// tunnel
+func checkManyTunnelFlags(cmd *cobra.Command, what string) {
+	for _, flag := range []string{"background", "ci", "wait-for-port", "url-file", "qr", "ttl", "delete-on-expiry", "force"} {
+		if cmd.Flags().Changed(flag) {
+			exitWithError(fmt.Errorf("%s cannot be used with --%s", what, flag))
+		}
+	}
+}
+
+// runTunnels starts the tunnels returned by resolve, which runs once the
+// tunnels are synced from the server, and keeps them running until
+// interrupted. Tunnels whose dependencies did not connect are skipped. which
+// describes the tunnels in messages, as in "of group 'staging'".
+func runTunnels(cmd *cobra.Command, which string, resolve func(configManager *config.ConfigManager) []*config.Tunnel) {
+
+	defaultConfig := config.Load()
+	authManager := auth.NewAuthManager(defaultConfig)
+	if !authManager.IsAuthenticated() {
+		exitWithError(apperr.ErrNotAuthenticated)
+	}
+
+	if advertise, _ := cmd.Flags().GetBool("mdns"); advertise {
+		defaultConfig.GetSettings().MDNS.Enabled = true
+	}
+	manager := service.NewManager(defaultConfig)
+
+	syncing := startProgress("Syncing tunnels from the server")
+	err := manager.SyncTunnelsFromServer()
+	syncing.Stop()
+	if err != nil {
+		log.Printf(" Warning: Failed to sync tunnels from server: %v", err)
+	}
+
+	configManager := config.NewConfigManager()
+	tunnels := resolve(configManager)
+
+	failed := make(map[string]string) // Tunnel ID to name, for tunnels that did not start
+	var started []*config.Tunnel
+	for _, t := range tunnels {
+		if dep := failedDependency(t, failed); dep != "" {
+			fmt.Printf(" %s Skipping '%s': '%s' did not start\n", logger.WarningMark(), t.Name, dep)
+			failed[t.ID] = t.Name
+			continue
+		}
+
+		connecting := startProgress(fmt.Sprintf("Connecting %s (%s %s)", t.Name, t.Subdomain, t.LocalTarget()))
+		if err := manager.ConnectTunnel(t.ID, false); err != nil {
+			connecting.Stop()
+			explained := explainConnectError(err, t)
+			fmt.Printf(" %s Tunnel '%s' failed to start: %s\n", logger.ErrorMark(), t.Name, explained.Message)
+			failed[t.ID] = t.Name
+			continue
+		}
+		connecting.Done(fmt.Sprintf("Tunnel '%s' started: http://%s.%s", t.Name, t.Subdomain, defaultConfig.TunnelDomain))
+		started = append(started, t)
+	}
+	configManager.InvalidateTunnelCache()
+
+	if len(started) == 0 {
+		manager.Shutdown()
+		exitWithError(apperr.ErrStartFailed.Withf("No tunnel %s started", which))
+	}
+	fmt.Printf(" Started %d of %d tunnels. Press Ctrl+C to stop them\n", len(started), len(tunnels))
+
+	manager.WatchForResume()
+	manager.StartControlServer()
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+
+wait:
+	for {
+		select {
+		case <-sigChan:
+			fmt.Println("\n Stopping tunnels...")
+			break wait
+		case event := <-manager.Stopped():
+			fmt.Printf(" %s Tunnel '%s' stopped (%s)\n", logger.WarningMark(), event.TunnelName, event.Reason)
+		}
+	}
+
+	// Dependents stop before the tunnels they depend on
+	for i := len(started) - 1; i >= 0; i-- {
+		if err := manager.DisconnectTunnel(started[i].ID); err != nil && config.IsDebugMode() {
+			log.Printf(" Warning: Failed to disconnect tunnel %s: %v", started[i].Name, err)
+		}
+	}
+	manager.Shutdown()
+	configManager.InvalidateTunnelCache()
+
+	fmt.Printf(" %s Tunnels stopped.\n", logger.SuccessMark())
+}
+
+// failedDependency returns the name of a tunnel t depends on that did not
+// start, or ""
+func failedDependency(t *config.Tunnel, failed map[string]string) string {
+	for _, depID := range t.DependsOn() {
+		if name, ok := failed[depID]; ok {
+			return name
+		}
+	}
+	return ""
+}
+
+// warnStoppedDependencies warns when a tunnel started on its own depends on
+// tunnels that are not running
+func warnStoppedDependencies(t *config.Tunnel, tunnels []config.Tunnel) {
+	for _, depID := range t.DependsOn() {
+		for i := range tunnels {
+			if tunnels[i].ID == depID && !tunnels[i].IsActive {
+				fmt.Printf(" %s Tunnel '%s' depends on '%s', which is not running\n", logger.WarningMark(), t.Name, tunnels[i].Name)
+			}
+		}
+	}
+}
+
+// runArgs accepts tunnel names and patterns, or none with --group
+func runArgs(cmd *cobra.Command, args []string) error {
+	if group, _ := cmd.Flags().GetString("group"); group != "" {
+		if len(args) > 0 {
+			return fmt.Errorf("--group starts the tunnels of a group; do not also name a tunnel")
+		}
+		return nil
+	}
+	return cobra.MinimumNArgs(1)(cmd, args)
+}
@@ -0,0 +1,122 @@
+package cli
+
+import (
+	"fmt"
+	"os/exec"
+	"skyport-agent/internal/config"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var hooksCmd = &cobra.Command{
+	Use:   "hooks [tunnel-name-or-id] [list|add|remove|clear] [args...]",
+	Short: "Run external programs on requests and responses",
+	Long: `Manage hook programs for a tunnel. For every request (and/or response) the
+agent runs the program with the exchange as JSON on stdin:
+
+  {"phase": "request", "tunnel": "myapp", "client_ip": "203.0.113.7",
+   "request": {"method": "GET", "url": "/x", "headers": {...}, "body": "<base64>"},
+   "response": {"status": 200, "headers": {...}, "body": "<base64>"}}
+
+Printing nothing lets the exchange continue unchanged. Printing
+{"request": {...}} in the request phase replaces the method, URL, headers or
+body sent to your service; printing {"response": {"status": 403}} answers the
+request (or replaces the response) instead. If a hook fails or times out the
+client gets 502, unless --fail-open is set.
+
+Example:
+  skyport tunnel hooks myapp add --phase request --timeout 2s -- /usr/local/bin/check-token
+  skyport tunnel hooks myapp list
+  skyport tunnel hooks myapp remove 1
+  skyport tunnel hooks myapp clear`,
+	Args:        cobra.MinimumNArgs(2),
+	Annotations: map[string]string{offlineAnnotation: "true"},
+	Run:         runHooks,
+}
+
+func init() {
+	hooksCmd.Flags().String("phase", config.HookPhaseRequest, "When to run the hook: request, response or both")
+	hooksCmd.Flags().Duration("timeout", 5*time.Second, "Maximum run time per invocation")
+	hooksCmd.Flags().Bool("fail-open", false, "Continue unchanged if the hook fails instead of answering 502")
+	tunnelCmd.AddCommand(hooksCmd)
+}
+
+func runHooks(cmd *cobra.Command, args []string) {
+	configManager := config.NewConfigManager()
+	tunnel := findLocalTunnel(configManager, args[0])
+
+	var hooks []config.HookSettings
+	if tunnel.Settings != nil {
+		hooks = tunnel.Settings.Hooks
+	}
+
+	switch args[1] {
+	case "list":
+		printHooks(tunnel.Name, tunnel.HookSettings())
+		return
+	case "add":
+		if len(args) < 3 {
+			exitWithError(fmt.Errorf("usage: skyport tunnel hooks <tunnel> add [flags] -- <command> [args...]"))
+		}
+		phase, _ := cmd.Flags().GetString("phase")
+		timeout, _ := cmd.Flags().GetDuration("timeout")
+		failOpen, _ := cmd.Flags().GetBool("fail-open")
+
+		if phase != config.HookPhaseRequest && phase != config.HookPhaseResponse && phase != config.HookPhaseBoth {
+			exitWithError(fmt.Errorf("phase must be 'request', 'response' or 'both'"))
+		}
+		if _, err := exec.LookPath(args[2]); err != nil {
+			fmt.Printf(" Warning: %v\n", err)
+		}
+
+		hooks = append(hooks, config.HookSettings{
+			Command:  args[2],
+			Args:     args[3:],
+			Phase:    phase,
+			Timeout:  config.Duration{Duration: timeout},
+			FailOpen: failOpen,
+		})
+	case "remove":
+		if len(args) != 3 {
+			exitWithError(fmt.Errorf("usage: skyport tunnel hooks <tunnel> remove <number>"))
+		}
+		index, err := strconv.Atoi(args[2])
+		if err != nil || index < 1 || index > len(hooks) {
+			exitWithError(fmt.Errorf("no hook number %s; run 'skyport tunnel hooks %s list' to see them", args[2], args[0]))
+		}
+		hooks = append(hooks[:index-1], hooks[index:]...)
+	case "clear":
+		hooks = nil
+	default:
+		exitWithError(fmt.Errorf("action must be 'list', 'add', 'remove' or 'clear'"))
+	}
+
+	if err := configManager.SetTunnelHooks(tunnel.ID, hooks); err != nil {
+		exitWithError(fmt.Errorf("failed to update hooks: %w", err))
+	}
+
+	tunnel = findLocalTunnel(configManager, tunnel.ID)
+	printHooks(tunnel.Name, tunnel.HookSettings())
+	fmt.Println(" Takes effect the next time the tunnel connects.")
+}
+
+// printHooks shows a tunnel's hooks, numbered in the order they run
+func printHooks(tunnelName string, hooks []config.HookSettings) {
+	if len(hooks) == 0 {
+		fmt.Printf(" No hooks for tunnel '%s'\n", tunnelName)
+		return
+	}
+
+	fmt.Printf(" Hooks for tunnel '%s':\n", tunnelName)
+	for i, hook := range hooks {
+		mode := "fail closed"
+		if hook.FailOpen {
+			mode = "fail open"
+		}
+		command := strings.TrimSpace(hook.Command + " " + strings.Join(hook.Args, " "))
+		fmt.Printf("   %d. [%s, %s, %s] %s\n", i+1, hook.Phase, hook.Timeout.Duration, mode, command)
+	}
+}
@@ -0,0 +1,202 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"skyport-agent/internal/apperr"
+	"skyport-agent/internal/auth"
+	"skyport-agent/internal/config"
+	"skyport-agent/internal/htpasswd"
+	"skyport-agent/internal/importer"
+	"skyport-agent/internal/logger"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var importCmd = &cobra.Command{
+	Use:   "import [ngrok|cloudflared] [config-file]",
+	Short: "Create tunnels from an ngrok or cloudflared config",
+	Long: `Create SkyPort tunnels for the HTTP tunnels defined in an ngrok.yml or a
+cloudflared config.yml. Without a file, the tool's usual config locations are
+tried. Each tunnel keeps its name and, where the config has one, its
+subdomain; a local address other than localhost becomes the tunnel's target,
+and ngrok basic auth credentials are carried over. TCP and TLS tunnels, path
+rules and other options SkyPort has no equivalent for are reported and
+skipped. Tunnels whose name or subdomain you already have are left alone.
+
+Example:
+  skyport import ngrok
+  skyport import ngrok ~/.config/ngrok/ngrok.yml --dry-run
+  skyport import cloudflared ~/.cloudflared/config.yml`,
+	Args:      cobra.RangeArgs(1, 2),
+	ValidArgs: []string{importer.Ngrok, importer.Cloudflared},
+	// --dry-run works offline; otherwise the server is reached when listing tunnels
+	Annotations: map[string]string{offlineAnnotation: "true"},
+	Run:         runImport,
+}
+
+func init() {
+	importCmd.Flags().Bool("dry-run", false, "Show the tunnels that would be created without creating them")
+}
+
+func runImport(cmd *cobra.Command, args []string) {
+	tool := args[0]
+	if tool != importer.Ngrok && tool != importer.Cloudflared {
+		exitWithError(fmt.Errorf("first argument must be '%s' or '%s'", importer.Ngrok, importer.Cloudflared))
+	}
+	dryRun, _ := cmd.Flags().GetBool("dry-run")
+
+	path := importer.DefaultPath(tool)
+	if len(args) == 2 {
+		path = args[1]
+	}
+	if path == "" {
+		exitWithError(fmt.Errorf("no %s config found in its usual locations; pass its path, e.g. 'skyport import %s <config-file>'", tool, tool))
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		exitWithError(fmt.Errorf("failed to read %s config: %w", tool, err))
+	}
+	definitions, err := importer.Parse(tool, data)
+	if err != nil {
+		exitWithError(err)
+	}
+	if len(definitions) == 0 {
+		fmt.Printf(" No tunnels found in %s\n", path)
+		return
+	}
+
+	var authManager *auth.AuthManager
+	var token string
+	existing := make(map[string]bool) // Names and subdomains of your tunnels
+	if !dryRun {
+		authManager = auth.NewAuthManager(config.Load())
+		if !authManager.IsAuthenticated() {
+			exitWithError(apperr.ErrNotAuthenticated)
+		}
+		if token, err = authManager.GetValidToken(); err != nil {
+			exitWithError(explainError(err, apperr.ErrSessionExpired))
+		}
+		refuseReadOnly(token, "import tunnels")
+		list, err := listTunnels(authManager, token, true)
+		if err != nil {
+			exitWithError(explainError(err, apperr.ErrServerUnreachable))
+		}
+		for _, t := range list.Tunnels {
+			existing[t.Name] = true
+			existing[t.Subdomain] = true
+		}
+	}
+
+	fmt.Printf(" Tunnels in %s:\n", path)
+	var importable []importer.Definition
+	for _, d := range definitions {
+		subdomain := importSubdomain(d)
+		switch {
+		case d.Skipped != "":
+			fmt.Printf(" %s %s: skipped, %s\n", logger.WarningMark(), d.Name, d.Skipped)
+			continue
+		case !subdomainPattern.MatchString(subdomain):
+			fmt.Printf(" %s %s: skipped, '%s' is not a valid subdomain\n", logger.WarningMark(), d.Name, subdomain)
+			continue
+		case existing[d.Name] || existing[subdomain]:
+			fmt.Printf(" %s %s: skipped, you already have a tunnel with this name or subdomain\n", logger.WarningMark(), d.Name)
+			continue
+		}
+
+		fmt.Printf("   %s: %s %s %s\n", d.Name, subdomain, logger.Arrow(), d.Target)
+		if len(d.BasicAuth) > 0 {
+			fmt.Printf("     basic auth for %d user(s)\n", len(d.BasicAuth))
+		}
+		if len(d.Ignored) > 0 {
+			fmt.Printf("     not imported: %s\n", strings.Join(d.Ignored, ", "))
+		}
+		importable = append(importable, d)
+	}
+
+	if len(importable) == 0 {
+		fmt.Println(" Nothing to import.")
+		return
+	}
+	if dryRun {
+		fmt.Printf(" %d tunnel(s) would be created. Run without --dry-run to create them.\n", len(importable))
+		return
+	}
+	if !confirm(fmt.Sprintf(" Create %d tunnel(s)?", len(importable))) {
+		return
+	}
+
+	configManager := config.NewConfigManager()
+	created := 0
+	for _, d := range importable {
+		subdomain := importSubdomain(d)
+		creating := startProgress(fmt.Sprintf("Creating tunnel %s", d.Name))
+		tunnel, err := authManager.CreateTunnel(token, d.Name, subdomain, d.Port())
+		if errors.Is(err, auth.ErrSubdomainTaken) {
+			creating.Fail(fmt.Sprintf("Subdomain '%s' is already taken; create '%s' with another one using 'skyport tunnel create'", subdomain, d.Name))
+			continue
+		}
+		if err != nil {
+			creating.Stop()
+			exitWithError(explainError(err, apperr.ErrStartFailed.Withf("Failed to create tunnel '%s'", d.Name)))
+		}
+
+		settings, err := importSettings(d)
+		if err != nil {
+			fmt.Printf(" %s %s: %v\n", logger.WarningMark(), d.Name, err)
+		}
+		tunnel.Settings = settings
+		if err := configManager.AddTunnel(tunnel); err != nil {
+			exitWithError(fmt.Errorf("failed to save tunnel: %w", err))
+		}
+		creating.Done(fmt.Sprintf("Created tunnel '%s' (%s %s %s)", tunnel.Name, tunnel.Subdomain, logger.Arrow(), tunnel.LocalTarget()))
+		created++
+	}
+	// The new tunnels must show up in the next list
+	configManager.InvalidateTunnelCache()
+
+	if created > 0 {
+		fmt.Printf(" Imported %d tunnel(s). Start one with 'skyport tunnel run <name>'\n", created)
+	}
+}
+
+// importSubdomain returns the subdomain an imported tunnel is created with
+func importSubdomain(d importer.Definition) string {
+	if d.Subdomain != "" {
+		return d.Subdomain
+	}
+	return d.Name
+}
+
+// importSettings returns the local settings of an imported tunnel: its
+// target when it is not on localhost, and its basic auth users
+func importSettings(d importer.Definition) (*config.TunnelSettings, error) {
+	var settings *config.TunnelSettings
+	if d.Target != net.JoinHostPort("localhost", strconv.Itoa(d.Port())) {
+		settings = &config.TunnelSettings{Target: d.Target}
+	}
+
+	for _, credentials := range d.BasicAuth {
+		user, password, ok := strings.Cut(credentials, ":")
+		if !ok || user == "" {
+			return settings, fmt.Errorf("basic auth credentials must be user:password; set them with 'skyport tunnel basic-auth'")
+		}
+		hash, err := htpasswd.Hash(password)
+		if err != nil {
+			return settings, err
+		}
+		if settings == nil {
+			settings = &config.TunnelSettings{}
+		}
+		if settings.BasicAuth.Users == nil {
+			settings.BasicAuth.Users = make(map[string]string)
+		}
+		settings.BasicAuth.Enabled = true
+		settings.BasicAuth.Users[user] = hash
+	}
+	return settings, nil
+}
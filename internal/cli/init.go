@@ -0,0 +1,242 @@
+package cli
+
+import (
+	"fmt"
+	"log"
+	"runtime"
+	"skyport-agent/internal/apperr"
+	"skyport-agent/internal/auth"
+	"skyport-agent/internal/config"
+	"skyport-agent/internal/logger"
+	"skyport-agent/internal/service"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	// initTunnelPoll is how often the wizard checks for a tunnel created in
+	// the dashboard, and initTunnelWait how long it waits for one
+	initTunnelPoll = 5 * time.Second
+	initTunnelWait = 10 * time.Minute
+)
+
+var initCmd = &cobra.Command{
+	Use:   "init",
+	Short: "Set up SkyPort step by step",
+	Long: `Walk through setting up SkyPort: log in, pick your first tunnel (or create
+one in the dashboard), check that your local service is reachable and
+optionally start the tunnel automatically with the system service.
+
+Example:
+  skyport init
+  skyport init --tunnel web`,
+	Args: cobra.NoArgs,
+	Run:  runInit,
+}
+
+var initTunnel string
+
+func init() {
+	initCmd.Flags().StringVar(&initTunnel, "tunnel", "", "Tunnel to set up, instead of choosing one")
+}
+
+func runInit(cmd *cobra.Command, args []string) {
+	defaultConfig := config.Load()
+	authManager := auth.NewAuthManager(defaultConfig)
+
+	fmt.Println("Welcome to SkyPort! Let's get your first tunnel running.")
+
+	// Step 1: log in
+	fmt.Println("\nStep 1/4: Log in")
+	if userData := initCurrentUser(authManager); userData != nil {
+		fmt.Printf(" %s Logged in as %s\n", logger.SuccessMark(), userData.Name)
+	} else {
+		if !confirm(" You are not logged in. Log in now in your browser?") {
+			exitWithError(apperr.ErrNotAuthenticated)
+		}
+		runLogin(cmd, nil)
+	}
+
+	token, err := authManager.GetValidToken()
+	if err != nil {
+		exitWithError(explainError(err, apperr.ErrSessionExpired))
+	}
+
+	// Step 2: pick a tunnel
+	fmt.Println("\nStep 2/4: Choose a tunnel")
+	target := initChooseTunnel(authManager, token, defaultConfig)
+	fmt.Printf(" %s Using tunnel '%s' (%s.%s)\n", logger.SuccessMark(), target.Name, target.Subdomain, defaultConfig.TunnelDomain)
+
+	manager := service.NewManager(defaultConfig)
+	if err := manager.SyncTunnelsFromServer(); err != nil {
+		log.Printf(" Warning: Failed to sync tunnels from server: %v", err)
+	}
+	if appConfig, err := config.NewConfigManager().LoadConfig(); err == nil {
+		if localTunnel, exists := appConfig.Tunnels[target.ID]; exists {
+			target.Settings = localTunnel.Settings
+		}
+	}
+
+	// Step 3: check the local service
+	fmt.Println("\nStep 3/4: Check your local service")
+	initCheckLocalTarget(target)
+
+	// Step 4: auto-start
+	fmt.Println("\nStep 4/4: Start automatically")
+	initAutoStart(manager, target)
+
+	fmt.Println("\nYou're all set!")
+	fmt.Printf(" Start the tunnel now:  skyport tunnel run %s\n", target.Name)
+	fmt.Printf(" Your service will be at: http://%s.%s\n", target.Subdomain, defaultConfig.TunnelDomain)
+}
+
+// initCurrentUser returns the logged-in user, or nil if there is none or the
+// session is no longer valid
+func initCurrentUser(authManager *auth.AuthManager) *config.UserData {
+	if !authManager.IsAuthenticated() {
+		return nil
+	}
+	checking := startProgress("Checking your session")
+	userData, err := authManager.LoadCredentials()
+	checking.Stop()
+	if err != nil {
+		return nil
+	}
+	return userData
+}
+
+// initChooseTunnel picks the tunnel to set up: the one named with --tunnel,
+// the only one there is, or one the user chooses. With no tunnels yet, it
+// waits for one to be created in the dashboard.
+func initChooseTunnel(authManager *auth.AuthManager, token string, cfg *config.Config) *config.Tunnel {
+	list, err := listTunnels(authManager, token, true)
+	if err != nil {
+		exitWithError(explainError(err, apperr.ErrServerUnreachable))
+	}
+	tunnels := list.Tunnels
+
+	if initTunnel != "" {
+		t := findTunnel(tunnels, initTunnel)
+		if t == nil {
+			exitWithError(apperr.ErrTunnelNotFound.Withf("Tunnel '%s' not found", initTunnel))
+		}
+		return t
+	}
+
+	if len(tunnels) == 0 {
+		tunnels = initWaitForTunnel(authManager, token, cfg)
+	}
+	if len(tunnels) == 1 {
+		return &tunnels[0]
+	}
+
+	for i, t := range tunnels {
+		fmt.Printf("   %d) %s (%s.%s %s %s)\n", i+1, t.Name, t.Subdomain, cfg.TunnelDomain, logger.Arrow(), t.LocalTarget())
+	}
+	for {
+		answer, ok := promptLine(fmt.Sprintf(" Which tunnel? [1-%d]: ", len(tunnels)))
+		if !ok {
+			exitWithError(fmt.Errorf("choose a tunnel with --tunnel <name> when not running interactively"))
+		}
+		answer = strings.TrimSpace(answer)
+		if n, err := strconv.Atoi(answer); err == nil && n >= 1 && n <= len(tunnels) {
+			return &tunnels[n-1]
+		}
+		if t := findTunnel(tunnels, answer); t != nil {
+			return t
+		}
+		fmt.Printf(" Enter a number from 1 to %d, or a tunnel name\n", len(tunnels))
+	}
+}
+
+// initWaitForTunnel sends the user to the dashboard to create a tunnel and
+// waits until the server lists one
+func initWaitForTunnel(authManager *auth.AuthManager, token string, cfg *config.Config) []config.Tunnel {
+	dashboard := cfg.WebURL + "/dashboard"
+	fmt.Println(" You don't have any tunnels yet.")
+	fmt.Printf(" Create one at: %s\n", dashboard)
+	if confirm(" Open the dashboard in your browser?") {
+		if err := authManager.OpenURL(dashboard); err != nil {
+			fmt.Printf(" %s Could not open a browser: %v\n", logger.WarningMark(), err)
+		}
+	}
+
+	waiting := startProgress("Waiting for your first tunnel")
+	deadline := time.Now().Add(initTunnelWait)
+	for time.Now().Before(deadline) {
+		time.Sleep(initTunnelPoll)
+		tunnels, err := authManager.FetchTunnels(token)
+		if err == nil && len(tunnels) > 0 {
+			waiting.Stop()
+			return tunnels
+		}
+	}
+	waiting.Fail("No tunnel was created")
+	fmt.Println(" Run 'skyport init' again once you have created a tunnel")
+	exitWithError(apperr.ErrTunnelNotFound.Withf("No tunnels found"))
+	return nil
+}
+
+// initCheckLocalTarget checks that the tunnel's local service is reachable,
+// letting the user start it and check again
+func initCheckLocalTarget(t *config.Tunnel) {
+	for {
+		result, err := probeLocalTarget(t)
+		if err == nil {
+			fmt.Printf(" %s %s: %s\n", logger.SuccessMark(), t.LocalTarget(), result)
+			return
+		}
+
+		fmt.Printf(" %s %s: %v\n", logger.WarningMark(), t.LocalTarget(), err)
+		if t.StaticSettings().Dir == "" {
+			fmt.Printf(" Start your service on %s, or forward elsewhere with 'skyport tunnel target %s <address>'\n", t.LocalAddress(), t.Name)
+		}
+		answer, ok := promptLine(" Press Enter to check again, or type 'skip': ")
+		if !ok || strings.EqualFold(strings.TrimSpace(answer), "skip") {
+			fmt.Println(" Skipped; requests will fail until the local service is running")
+			return
+		}
+	}
+}
+
+// initAutoStart offers to connect the tunnel whenever the agent runs, and to
+// install the system service that runs the agent at boot
+func initAutoStart(manager *service.Manager, t *config.Tunnel) {
+	if !confirm(fmt.Sprintf(" Connect '%s' automatically whenever the SkyPort agent runs?", t.Name)) {
+		fmt.Printf(" Skipped; run it yourself with 'skyport tunnel run %s'\n", t.Name)
+		return
+	}
+	if err := manager.SetTunnelAutoStart(t.ID, true); err != nil {
+		fmt.Printf(" %s Failed to enable auto-start: %v\n", logger.ErrorMark(), err)
+		return
+	}
+	fmt.Printf(" %s Auto-start enabled for '%s'\n", logger.SuccessMark(), t.Name)
+
+	if runtime.GOOS != "linux" {
+		fmt.Println(" Keep the agent running with 'skyport daemon' to connect it")
+		return
+	}
+
+	systemdService := service.NewSystemdService()
+	if systemdService.IsInstalled() {
+		fmt.Printf(" %s The SkyPort service is installed and will connect it at boot\n", logger.SuccessMark())
+		return
+	}
+	if !confirm(" Install the SkyPort service so it connects at boot?") {
+		fmt.Println(" Install it later with 'skyport service install && skyport service start'")
+		return
+	}
+	if err := systemdService.Install(); err != nil {
+		fmt.Printf(" %s Failed to install the service: %v\n", logger.ErrorMark(), err)
+		fmt.Println(" Installing the service may need root; try 'sudo skyport service install'")
+		return
+	}
+	if err := systemdService.Start(); err != nil {
+		fmt.Printf(" %s Service installed but failed to start: %v\n", logger.WarningMark(), err)
+		return
+	}
+	fmt.Printf(" %s Service installed and started\n", logger.SuccessMark())
+}
@@ -0,0 +1,138 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"skyport-agent/internal/auth"
+	"skyport-agent/internal/config"
+	"skyport-agent/internal/logger"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var labelCmd = &cobra.Command{
+	Use:   "label [tunnel-name-or-id] [key=value...|remove <key>...|clear|show]",
+	Short: "Label tunnels, e.g. team=web or env=staging",
+	Long: `Set labels on a tunnel to organize and filter them with
+'skyport tunnel list --filter key=value'. Labels are stored on the SkyPort
+server when it supports them, so they follow you across machines; otherwise
+they are kept in the local config.
+
+Example:
+  skyport tunnel label api team=web env=staging
+  skyport tunnel label api remove env
+  skyport tunnel label api show
+  skyport tunnel list --filter env=staging`,
+	Args:        cobra.MinimumNArgs(2),
+	Annotations: map[string]string{offlineAnnotation: "true"},
+	Run:         runLabel,
+}
+
+func init() {
+	tunnelCmd.AddCommand(labelCmd)
+}
+
+func runLabel(cmd *cobra.Command, args []string) {
+	nameOrID, action, params := args[0], args[1], args[2:]
+
+	configManager := config.NewConfigManager()
+	tunnel := findLocalTunnel(configManager, nameOrID)
+
+	labels := make(map[string]string)
+	for key, value := range tunnel.TunnelLabels() {
+		labels[key] = value
+	}
+
+	switch action {
+	case "show":
+		printLabels(tunnel.Name, labels)
+		return
+	case "clear":
+		if len(params) != 0 {
+			exitWithError(fmt.Errorf("usage: skyport tunnel label %s clear", nameOrID))
+		}
+		labels = map[string]string{}
+	case "remove":
+		if len(params) == 0 {
+			exitWithError(fmt.Errorf("usage: skyport tunnel label %s remove <key>...", nameOrID))
+		}
+		for _, key := range params {
+			if _, ok := labels[key]; !ok {
+				fmt.Printf(" %s Tunnel '%s' has no label '%s'\n", logger.WarningMark(), tunnel.Name, key)
+			}
+			delete(labels, key)
+		}
+	default:
+		for _, label := range args[1:] {
+			key, value, err := config.ParseLabel(label)
+			if err != nil {
+				exitWithError(err)
+			}
+			labels[key] = value
+		}
+	}
+
+	synced, err := syncLabels(tunnel.ID, labels)
+	if err != nil {
+		logger.Debug("Keeping labels of tunnel %s locally: %v", tunnel.Name, err)
+	}
+	if err := configManager.SetTunnelLabels(tunnel.ID, labels, synced); err != nil {
+		exitWithError(fmt.Errorf("failed to update labels: %w", err))
+	}
+	// Listings must show the new labels
+	configManager.InvalidateTunnelCache()
+
+	printLabels(tunnel.Name, labels)
+	switch {
+	case synced:
+		fmt.Printf(" %s Saved on the SkyPort server\n", logger.SuccessMark())
+	case errors.Is(err, auth.ErrLabelsUnsupported):
+		fmt.Println(" The SkyPort server does not store labels; they are kept in the local config.")
+	default:
+		fmt.Println(" Not synced with the SkyPort server; labels are kept in the local config.")
+	}
+}
+
+// syncLabels stores a tunnel's labels on the server, and reports whether it
+// did
+func syncLabels(tunnelID string, labels map[string]string) (bool, error) {
+	authManager := auth.NewAuthManager(config.Load())
+	if !authManager.IsAuthenticated() {
+		return false, errors.New("not logged in")
+	}
+	token, err := authManager.GetValidToken()
+	if err != nil {
+		return false, err
+	}
+	if err := authManager.SetTunnelLabels(token, tunnelID, labels); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// printLabels shows a tunnel's labels
+func printLabels(tunnelName string, labels map[string]string) {
+	if len(labels) == 0 {
+		fmt.Printf(" Tunnel '%s' has no labels\n", tunnelName)
+		return
+	}
+	fmt.Printf(" Labels of tunnel '%s': %s\n", tunnelName, config.FormatLabels(labels))
+}
+
+// parseLabelFilters turns --filter values into a label selector. "key=value"
+// requires the label to have that value; a bare "key" only requires the label.
+func parseLabelFilters(filters []string) (map[string]string, error) {
+	selector := make(map[string]string)
+	for _, filter := range filters {
+		if !strings.Contains(filter, "=") {
+			filter += "="
+		}
+		key, value, err := config.ParseLabel(filter)
+		if err != nil {
+			return nil, err
+		}
+		selector[key] = value
+	}
+	return selector, nil
+}
@@ -0,0 +1,94 @@
+package cli
+
+import (
+	"fmt"
+	"skyport-agent/internal/config"
+	"skyport-agent/internal/devcert"
+	"skyport-agent/internal/logger"
+
+	"github.com/spf13/cobra"
+)
+
+var localHTTPSCmd = &cobra.Command{
+	Use:   "local-https [tunnel-name-or-id] [enable|disable]",
+	Short: "Also serve a tunnel's local service over HTTPS on a local port",
+	Long: `Serve a tunnel's local service over HTTPS on 127.0.0.1 while the tunnel runs,
+to test secure cookies and SameSite behavior locally. The tunnel itself is
+unchanged. The listener forwards to the tunnel's local target with the Host
+the browser used and X-Forwarded-Proto: https.
+
+Its certificate is issued by a development CA the agent creates in
+~/.skyport/dev-ca, and covers localhost and the domains mapped to the tunnel
+with 'skyport dev-domain'. Browsers accept it once the CA is trusted: --trust
+adds it to the system's trusted certificates (asking for your password
+through sudo). Firefox keeps its own certificates, where ca.pem must be
+imported by hand.
+
+Example:
+  skyport tunnel local-https myapp enable --trust
+  skyport tunnel local-https myapp enable --port 9443
+  skyport tunnel local-https myapp disable`,
+	Args:        cobra.ExactArgs(2),
+	Annotations: map[string]string{offlineAnnotation: "true"},
+	Run:         runLocalHTTPS,
+}
+
+func init() {
+	localHTTPSCmd.Flags().Int("port", 0, "Local port the HTTPS listener is on (default 8443)")
+	localHTTPSCmd.Flags().Bool("trust", false, "Add the development CA to the system's trusted certificates")
+	tunnelCmd.AddCommand(localHTTPSCmd)
+}
+
+func runLocalHTTPS(cmd *cobra.Command, args []string) {
+	nameOrID := args[0]
+	port, _ := cmd.Flags().GetInt("port")
+	trust, _ := cmd.Flags().GetBool("trust")
+
+	var enable bool
+	switch args[1] {
+	case "enable":
+		enable = true
+	case "disable":
+		enable = false
+	default:
+		exitWithError(fmt.Errorf("action must be 'enable' or 'disable'"))
+	}
+	if port < 0 || port > 65535 {
+		exitWithError(fmt.Errorf("invalid port %d", port))
+	}
+
+	configManager := config.NewConfigManager()
+	tunnel := findLocalTunnel(configManager, nameOrID)
+
+	if err := configManager.SetTunnelLocalHTTPS(tunnel.ID, enable, port); err != nil {
+		exitWithError(fmt.Errorf("failed to update local HTTPS setting: %w", err))
+	}
+
+	if !enable {
+		fmt.Printf(" Local HTTPS disabled for tunnel '%s'\n", tunnel.Name)
+		fmt.Println(" Takes effect the next time the tunnel connects.")
+		return
+	}
+
+	// Create the CA now, so it can be trusted before the tunnel runs
+	authority, err := devcert.Load()
+	if err != nil {
+		exitWithError(err)
+	}
+
+	tunnel = findLocalTunnel(configManager, tunnel.ID)
+	settings := tunnel.LocalHTTPSSettings()
+	fmt.Printf(" Local HTTPS enabled for tunnel '%s'\n", tunnel.Name)
+	fmt.Printf("   URL:            https://localhost:%d %s %s\n", settings.Port, logger.Arrow(), tunnel.LocalAddress())
+	fmt.Printf("   Development CA: %s\n", authority.CertPath())
+
+	if trust {
+		if err := authority.Trust(); err != nil {
+			exitWithError(err)
+		}
+		fmt.Printf(" %s The development CA is now trusted on this machine\n", logger.SuccessMark())
+	} else {
+		fmt.Println(" Run again with --trust to have browsers accept the certificate.")
+	}
+	fmt.Println(" Takes effect the next time the tunnel connects.")
+}
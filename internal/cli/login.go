@@ -59,7 +59,9 @@ func runLogin(cmd *cobra.Command, args []string) {
 	// Note: We always validate with server - no offline mode
 	// If server is down, user can't use tunnels anyway
 	if authManager.IsAuthenticated() {
+		checking := startProgress("Checking your session")
 		userData, err := authManager.LoadCredentials()
+		checking.Stop()
 		if err == nil {
 			fmt.Printf("Already logged in as %s!\n", userData.Name)
 			fmt.Println("Use 'skyport tunnel list' to see your tunnels")
@@ -73,26 +75,30 @@ func runLogin(cmd *cobra.Command, args []string) {
 	urlHandler := auth.NewURLHandler(authManager)
 	callbackURL, err := urlHandler.StartServer()
 	if err != nil {
-		log.Fatalf("Failed to start local callback server: %v", err)
+		exitWithError(fmt.Errorf("failed to start local callback server: %w", err))
 	}
 
 	// Open browser to login page with callback
 	if err := authManager.StartWebAuth(callbackURL); err != nil {
 		_ = urlHandler.Stop()
-		log.Fatalf("Failed to open browser for login: %v", err)
+		exitWithError(fmt.Errorf("failed to open browser for login: %w", err))
 	}
 
 	// Wait for token (5 minutes)
+	waiting := startProgress("Waiting for you to log in in your browser")
 	token, err := urlHandler.WaitForToken(5 * time.Minute)
 	_ = urlHandler.Stop()
 	if err != nil {
-		log.Fatalf("Authentication failed: %v", err)
+		waiting.Stop()
+		exitWithError(fmt.Errorf("authentication failed: %w", err))
 	}
 
-	// Validate and persist via auth manager (keyring + user.json)
+	// Validate and persist via auth manager (keyring or token file + user.json)
+	waiting.Step("Verifying your account")
 	userData, err := authManager.LoginWithToken(token)
+	waiting.Stop()
 	if err != nil {
-		log.Fatalf("Failed to process authentication token: %v", err)
+		exitWithError(fmt.Errorf("failed to process authentication token: %w", err))
 	}
 
 	// Also store token in app config for backward compatibility
@@ -125,8 +131,8 @@ func runLogout(cmd *cobra.Command, args []string) {
 	userName := userData.Name
 	userEmail := userData.Email
 
-	// Clear credentials from keyring and user.json
-	if err := authManager.ClearCredentials(); err != nil {
+	// Clear credentials from keyring, token file and user.json
+	if _, err := authManager.ClearCredentials(); err != nil {
 		log.Printf("Warning: Failed to clear some credentials: %v", err)
 	}
 
@@ -144,7 +150,7 @@ func runLogout(cmd *cobra.Command, args []string) {
 	fmt.Println("\nYou can log in again using 'skyport login'")
 }
 
-func performLogin(serverURL, email, password string) (string, *config.UserData, error) {
+func performLogin(cfg *config.Config, email, password string) (string, *config.UserData, error) {
 	loginReq := LoginRequest{
 		Email:    email,
 		Password: password,
@@ -155,8 +161,8 @@ func performLogin(serverURL, email, password string) (string, *config.UserData,
 		return "", nil, fmt.Errorf("failed to marshal login request: %w", err)
 	}
 
-	resp, err := http.Post(
-		fmt.Sprintf("%s/auth/login", serverURL),
+	resp, err := cfg.HTTPClient(30*time.Second).Post(
+		fmt.Sprintf("%s/auth/login", cfg.ServerURL),
 		"application/json",
 		bytes.NewBuffer(jsonData),
 	)
@@ -0,0 +1,58 @@
+package cli
+
+import (
+	"fmt"
+	"skyport-agent/internal/config"
+
+	"github.com/spf13/cobra"
+)
+
+var maintenanceCmd = &cobra.Command{
+	Use:   "maintenance [tunnel-name-or-id] [on|off]",
+	Short: "Answer every request with a maintenance page",
+	Long: `Put a tunnel in maintenance mode: every request is answered with 503 and a
+Retry-After header instead of being forwarded, so the local service can be
+restarted or migrated. Browsers get the maintenance.html page if the tunnel
+has custom pages (see 'skyport tunnel pages'), with the message as
+{{.Message}}.
+
+Example:
+  skyport tunnel maintenance myapp on --message "Back in 10 minutes"
+  skyport tunnel maintenance myapp off`,
+	Args:        cobra.ExactArgs(2),
+	Annotations: map[string]string{offlineAnnotation: "true"},
+	Run:         runMaintenance,
+}
+
+func init() {
+	maintenanceCmd.Flags().String("message", "", "Message shown to visitors")
+	tunnelCmd.AddCommand(maintenanceCmd)
+}
+
+func runMaintenance(cmd *cobra.Command, args []string) {
+	message, _ := cmd.Flags().GetString("message")
+
+	var enable bool
+	switch args[1] {
+	case "on":
+		enable = true
+	case "off":
+		enable = false
+	default:
+		exitWithError(fmt.Errorf("action must be 'on' or 'off'"))
+	}
+
+	configManager := config.NewConfigManager()
+	tunnel := findLocalTunnel(configManager, args[0])
+
+	if err := configManager.SetTunnelMaintenance(tunnel.ID, enable, message); err != nil {
+		exitWithError(fmt.Errorf("failed to update maintenance mode: %w", err))
+	}
+
+	if enable {
+		fmt.Printf(" Maintenance mode on for tunnel '%s'\n", tunnel.Name)
+	} else {
+		fmt.Printf(" Maintenance mode off for tunnel '%s'\n", tunnel.Name)
+	}
+	fmt.Println(" Takes effect the next time the tunnel connects.")
+}
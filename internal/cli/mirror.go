@@ -0,0 +1,72 @@
+package cli
+
+import (
+	"fmt"
+	"skyport-agent/internal/config"
+	"skyport-agent/internal/logger"
+
+	"github.com/spf13/cobra"
+)
+
+var mirrorCmd = &cobra.Command{
+	Use:   "mirror [tunnel-name-or-id] [enable|disable]",
+	Short: "Also expose a tunnel on a local port, with its filters applied",
+	Long: `Expose a tunnel on a local port on 127.0.0.1 while it runs. Requests to the
+port go through the same pipeline as requests through the tunnel: resource
+limits, IP and basic-auth gates, OIDC login, header rewrites, rate limits and
+the rest, before reaching the local service. Use it to test auth gates and
+rewrites without going through the public URL.
+
+Mirrored requests are written to the tunnel's access log from 127.0.0.1,
+but are not counted in its stats. WebSocket upgrades are not mirrored.
+
+Example:
+  skyport tunnel mirror myapp enable
+  skyport tunnel mirror myapp enable --port 7002
+  skyport tunnel mirror myapp disable`,
+	Args:        cobra.ExactArgs(2),
+	Annotations: map[string]string{offlineAnnotation: "true"},
+	Run:         runMirror,
+}
+
+func init() {
+	mirrorCmd.Flags().Int("port", 0, "Local port the mirror listens on (default 7001)")
+	tunnelCmd.AddCommand(mirrorCmd)
+}
+
+func runMirror(cmd *cobra.Command, args []string) {
+	nameOrID := args[0]
+	port, _ := cmd.Flags().GetInt("port")
+
+	var enable bool
+	switch args[1] {
+	case "enable":
+		enable = true
+	case "disable":
+		enable = false
+	default:
+		exitWithError(fmt.Errorf("action must be 'enable' or 'disable'"))
+	}
+	if port < 0 || port > 65535 {
+		exitWithError(fmt.Errorf("invalid port %d", port))
+	}
+
+	configManager := config.NewConfigManager()
+	tunnel := findLocalTunnel(configManager, nameOrID)
+
+	if err := configManager.SetTunnelMirror(tunnel.ID, enable, port); err != nil {
+		exitWithError(fmt.Errorf("failed to update mirror setting: %w", err))
+	}
+
+	if !enable {
+		fmt.Printf(" Local mirror disabled for tunnel '%s'\n", tunnel.Name)
+		fmt.Println(" Takes effect the next time the tunnel connects.")
+		return
+	}
+
+	tunnel = findLocalTunnel(configManager, tunnel.ID)
+	settings := tunnel.MirrorSettings()
+	fmt.Printf(" Local mirror enabled for tunnel '%s'\n", tunnel.Name)
+	fmt.Printf("   URL: http://localhost:%d %s %s\n", settings.Port, logger.Arrow(), tunnel.LocalAddress())
+	fmt.Println(" Takes effect the next time the tunnel connects.")
+}
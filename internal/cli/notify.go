@@ -0,0 +1,72 @@
+package cli
+
+import (
+	"fmt"
+	"skyport-agent/internal/config"
+	"skyport-agent/internal/logger"
+	"skyport-agent/internal/notify"
+	"sort"
+
+	"github.com/spf13/cobra"
+)
+
+var notifyCmd = &cobra.Command{
+	Use:   "notify",
+	Short: "Manage alert notifications",
+	Long: `Manage the alerts the agent sends when tunnels disconnect, reconnect or stop serving.
+
+Notifiers (Slack, Discord, email or raw webhooks) are configured under
+"settings.notifications" in ~/.skyport/skyport.json.`,
+	Annotations: map[string]string{offlineAnnotation: "true"},
+}
+
+var notifyTestCmd = &cobra.Command{
+	Use:   "test",
+	Short: "Send a test alert to every configured notifier",
+	Long: `Send a test alert to every configured notifier and report whether delivery succeeded.
+
+Example:
+  skyport notify test`,
+	Run: runNotifyTest,
+}
+
+func init() {
+	notifyCmd.AddCommand(notifyTestCmd)
+}
+
+func runNotifyTest(cmd *cobra.Command, args []string) {
+	cfg := config.Load()
+
+	dispatcher, err := notify.NewDispatcher(cfg.GetSettings().Notifications)
+	if err != nil {
+		exitWithError(fmt.Errorf("invalid notification settings: %w", err))
+	}
+
+	if !dispatcher.Enabled() {
+		fmt.Println(" No notifiers configured.")
+		fmt.Println(" Add them under \"settings.notifications.notifiers\" in ~/.skyport/skyport.json")
+		return
+	}
+
+	results := dispatcher.SendTest()
+
+	names := make([]string, 0, len(results))
+	for name := range results {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	failed := 0
+	for _, name := range names {
+		if err := results[name]; err != nil {
+			fmt.Printf(" %s %s: %v\n", logger.ErrorMark(), name, err)
+			failed++
+		} else {
+			fmt.Printf(" %s %s: delivered\n", logger.SuccessMark(), name)
+		}
+	}
+
+	if failed > 0 {
+		exitWithError(fmt.Errorf("%d of %d notifications failed", failed, len(names)))
+	}
+}
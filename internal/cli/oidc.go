@@ -0,0 +1,137 @@
+package cli
+
+import (
+	"fmt"
+	"net/url"
+	"skyport-agent/internal/config"
+	"skyport-agent/internal/logger"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var oidcCmd = &cobra.Command{
+	Use:   "oidc [tunnel-name-or-id] [enable|disable|show]",
+	Short: "Require visitors to sign in with an identity provider",
+	Long: `Put an OpenID Connect login (Google, GitHub via Dex, Okta, Auth0, Keycloak, ...)
+in front of a tunnel. Visitors without a session are redirected to the identity
+provider; after signing in, only the allowed emails or domains reach your local
+service, which receives the user's email in the X-Skyport-User-Email header.
+
+Register the callback URL printed by 'enable' with your identity provider.
+Visiting /_skyport/oidc/logout on the tunnel signs out.
+
+Example:
+  skyport tunnel oidc myapp enable --issuer https://accounts.google.com \
+    --client-id ID --client-secret SECRET --allow-domain example.com
+  skyport tunnel oidc myapp enable --allow-email alice@gmail.com
+  skyport tunnel oidc myapp show
+  skyport tunnel oidc myapp disable`,
+	Args:        cobra.ExactArgs(2),
+	Annotations: map[string]string{offlineAnnotation: "true"},
+	Run:         runOIDC,
+}
+
+func init() {
+	oidcCmd.Flags().String("issuer", "", "Identity provider issuer URL")
+	oidcCmd.Flags().String("client-id", "", "OAuth client ID registered with the provider")
+	oidcCmd.Flags().String("client-secret", "", "OAuth client secret (omit for public clients)")
+	oidcCmd.Flags().String("redirect-url", "", "Callback URL registered with the provider (default: the tunnel's public URL)")
+	oidcCmd.Flags().StringSlice("allow-email", nil, "Email allowed to sign in (repeatable)")
+	oidcCmd.Flags().StringSlice("allow-domain", nil, "Email domain allowed to sign in (repeatable)")
+	oidcCmd.Flags().StringSlice("remove", nil, "Allowed email or domain to remove")
+	oidcCmd.Flags().Duration("session-ttl", 0, "How long a sign-in lasts (default: keep current, initially 12h)")
+	tunnelCmd.AddCommand(oidcCmd)
+}
+
+func runOIDC(cmd *cobra.Command, args []string) {
+	configManager := config.NewConfigManager()
+	tunnel := findLocalTunnel(configManager, args[0])
+
+	var settings config.OIDCSettings
+	if tunnel.Settings != nil {
+		settings = tunnel.Settings.OIDC
+	}
+
+	switch args[1] {
+	case "show":
+		printOIDC(tunnel)
+		return
+	case "enable":
+		settings.Enabled = true
+	case "disable":
+		settings.Enabled = false
+	default:
+		exitWithError(fmt.Errorf("action must be 'enable', 'disable' or 'show'"))
+	}
+
+	if cmd.Flags().Changed("issuer") {
+		settings.Issuer, _ = cmd.Flags().GetString("issuer")
+	}
+	if cmd.Flags().Changed("client-id") {
+		settings.ClientID, _ = cmd.Flags().GetString("client-id")
+	}
+	if cmd.Flags().Changed("client-secret") {
+		settings.ClientSecret, _ = cmd.Flags().GetString("client-secret")
+	}
+	if cmd.Flags().Changed("redirect-url") {
+		settings.RedirectURL, _ = cmd.Flags().GetString("redirect-url")
+	}
+	if ttl, _ := cmd.Flags().GetDuration("session-ttl"); ttl > 0 {
+		settings.SessionTTL = config.Duration{Duration: ttl}
+	}
+
+	emails, _ := cmd.Flags().GetStringSlice("allow-email")
+	domains, _ := cmd.Flags().GetStringSlice("allow-domain")
+	remove, _ := cmd.Flags().GetStringSlice("remove")
+	settings.AllowedEmails = removeEntries(addEntries(settings.AllowedEmails, emails), remove)
+	settings.AllowedDomains = removeEntries(addEntries(settings.AllowedDomains, domains), remove)
+
+	if settings.Enabled {
+		if settings.Issuer == "" || settings.ClientID == "" {
+			exitWithError(fmt.Errorf("--issuer and --client-id are required to enable sign-in"))
+		}
+		if issuer, err := url.Parse(settings.Issuer); err != nil || (issuer.Scheme != "https" && issuer.Hostname() != "localhost") {
+			exitWithError(fmt.Errorf("issuer must be an https:// URL"))
+		}
+		if settings.RedirectURL == "" {
+			defaultConfig := config.Load()
+			settings.RedirectURL = fmt.Sprintf("http://%s.%s/_skyport/oidc/callback", tunnel.Subdomain, defaultConfig.TunnelDomain)
+		}
+	}
+
+	if err := configManager.SetTunnelOIDC(tunnel.ID, settings); err != nil {
+		exitWithError(fmt.Errorf("failed to update sign-in settings: %w", err))
+	}
+
+	if !settings.Enabled {
+		fmt.Printf(" Identity provider sign-in disabled for tunnel '%s'\n", tunnel.Name)
+	} else {
+		fmt.Printf(" %s Identity provider sign-in enabled for tunnel '%s'\n", logger.SuccessMark(), tunnel.Name)
+		printOIDC(findLocalTunnel(configManager, tunnel.ID))
+		if len(settings.AllowedEmails) == 0 && len(settings.AllowedDomains) == 0 {
+			fmt.Println(" Warning: no emails or domains are allowed yet; add them with --allow-email or --allow-domain")
+		}
+	}
+	fmt.Println(" Takes effect the next time the tunnel connects.")
+}
+
+func printOIDC(tunnel *config.Tunnel) {
+	settings := tunnel.OIDCSettings()
+	if !settings.Enabled {
+		fmt.Printf(" Identity provider sign-in is disabled for tunnel '%s'\n", tunnel.Name)
+		return
+	}
+
+	fmt.Printf(" Sign-in for tunnel '%s':\n", tunnel.Name)
+	fmt.Printf("   Issuer:       %s\n", settings.Issuer)
+	fmt.Printf("   Client ID:    %s\n", settings.ClientID)
+	fmt.Printf("   Callback URL: %s\n", settings.RedirectURL)
+	fmt.Printf("   Session:      %s\n", settings.SessionTTL.Duration)
+	if len(settings.AllowedEmails) > 0 {
+		fmt.Printf("   Emails:       %s\n", strings.Join(settings.AllowedEmails, ", "))
+	}
+	if len(settings.AllowedDomains) > 0 {
+		fmt.Printf("   Domains:      %s\n", strings.Join(settings.AllowedDomains, ", "))
+	}
+}
@@ -0,0 +1,84 @@
+package cli
+
+import (
+	"fmt"
+	"skyport-agent/internal/apperr"
+	"skyport-agent/internal/auth"
+	"skyport-agent/internal/config"
+	"skyport-agent/internal/logger"
+
+	"github.com/spf13/cobra"
+)
+
+var openCmd = &cobra.Command{
+	Use:   "open [tunnel-name-or-id]",
+	Short: "Open a tunnel's public URL in your browser",
+	Long: `Open a tunnel's public URL in your default browser, or print it with --print.
+
+The URL is resolved from the cached tunnel list when the server cannot be
+reached, so it also works offline once the tunnels have been listed.
+
+Examples:
+  skyport open myapp
+  curl "$(skyport open myapp --print)/health"`,
+	Args: cobra.ExactArgs(1),
+	Run:  runOpen,
+	// The cached tunnel list is enough to resolve the URL
+	Annotations: map[string]string{offlineAnnotation: "true"},
+}
+
+func init() {
+	openCmd.Flags().Bool("print", false, "Only print the URL, without opening a browser")
+	openCmd.Flags().BoolVar(&refreshTunnels, "refresh", false, "Fetch the tunnel list from the server instead of the local cache")
+}
+
+func runOpen(cmd *cobra.Command, args []string) {
+	printOnly, _ := cmd.Flags().GetBool("print")
+
+	defaultConfig := config.Load()
+	authManager := auth.NewAuthManager(defaultConfig)
+
+	if !authManager.IsAuthenticated() {
+		exitWithError(apperr.ErrNotAuthenticated)
+	}
+
+	token, err := authManager.GetValidToken()
+	if err != nil {
+		exitWithError(explainError(err, apperr.ErrSessionExpired))
+	}
+
+	// With --print only the URL goes to stdout, so it can be captured
+	var list *auth.TunnelList
+	if printOnly {
+		list, err = authManager.ListTunnels(token, refreshTunnels)
+	} else {
+		list, err = listTunnels(authManager, token, false)
+	}
+	if err != nil {
+		exitWithError(explainError(err, apperr.ErrServerUnreachable))
+	}
+
+	t := findTunnel(list.Tunnels, args[0])
+	if t == nil {
+		exitWithError(apperr.ErrTunnelNotFound.Withf("Tunnel '%s' not found", args[0]))
+	}
+
+	publicURL := tunnelPublicURL(defaultConfig, t)
+	if printOnly {
+		fmt.Println(publicURL)
+		return
+	}
+
+	if !t.IsActive {
+		fmt.Printf(" %s Tunnel '%s' is not running; start it with 'skyport tunnel run %s'\n", logger.WarningMark(), t.Name, t.Name)
+	}
+	fmt.Printf(" Opening %s\n", publicURL)
+	if err := authManager.OpenURL(publicURL); err != nil {
+		exitWithError(fmt.Errorf("failed to open a browser: %w; open %s yourself", err, publicURL))
+	}
+}
+
+// tunnelPublicURL is the address a tunnel is reachable at on the internet
+func tunnelPublicURL(cfg *config.Config, t *config.Tunnel) string {
+	return fmt.Sprintf("http://%s.%s", t.Subdomain, cfg.TunnelDomain)
+}
@@ -0,0 +1,104 @@
+package cli
+
+import (
+	"fmt"
+	"html/template"
+	"os"
+	"path/filepath"
+	"skyport-agent/internal/config"
+	"skyport-agent/internal/logger"
+	"skyport-agent/internal/tunnel"
+
+	"github.com/spf13/cobra"
+)
+
+var pagesCmd = &cobra.Command{
+	Use:   "pages [tunnel-name-or-id] [show|set|clear] [dir]",
+	Short: "Use your own HTML for the pages the agent shows visitors",
+	Long: `Replace the plain text pages the agent answers with itself by HTML templates
+from a directory. Each file is optional; missing ones keep the built-in text:
+
+  502.html          The local service could not be reached
+  maintenance.html  The tunnel is in maintenance mode (see 'skyport tunnel maintenance')
+  401.html          The visitor cancelled the basic auth prompt
+  login.html        Shown instead of redirecting straight to the identity provider;
+                    link to {{.LoginURL}} to start the login
+
+Templates use Go's html/template syntax and can use {{.Tunnel}}, {{.Status}},
+{{.Title}}, {{.Message}}, {{.RequestID}} and {{.Path}}. Other files in the
+directory, such as stylesheets and images, are served publicly under
+{{.Assets}} (/_skyport/pages/). Pages are only sent to browsers; API clients
+keep getting plain text.
+
+Example:
+  skyport tunnel pages myapp set ./pages
+  skyport tunnel pages myapp show
+  skyport tunnel pages myapp clear`,
+	Args:        cobra.RangeArgs(2, 3),
+	Annotations: map[string]string{offlineAnnotation: "true"},
+	Run:         runPages,
+}
+
+func init() {
+	tunnelCmd.AddCommand(pagesCmd)
+}
+
+func runPages(cmd *cobra.Command, args []string) {
+	configManager := config.NewConfigManager()
+	t := findLocalTunnel(configManager, args[0])
+
+	var dir string
+	switch args[1] {
+	case "show":
+		printPages(t)
+		return
+	case "set":
+		if len(args) != 3 {
+			exitWithError(fmt.Errorf("usage: skyport tunnel pages <tunnel> set <dir>"))
+		}
+		absDir, err := filepath.Abs(args[2])
+		if err != nil {
+			exitWithError(fmt.Errorf("invalid directory: %w", err))
+		}
+		info, err := os.Stat(absDir)
+		if err != nil || !info.IsDir() {
+			exitWithError(fmt.Errorf("'%s' is not a directory", args[2]))
+		}
+		dir = absDir
+	case "clear":
+	default:
+		exitWithError(fmt.Errorf("action must be 'show', 'set' or 'clear'"))
+	}
+
+	if err := configManager.SetTunnelPages(t.ID, dir); err != nil {
+		exitWithError(fmt.Errorf("failed to update pages: %w", err))
+	}
+
+	t = findLocalTunnel(configManager, t.ID)
+	printPages(t)
+	fmt.Println(" Takes effect the next time the tunnel connects.")
+}
+
+// printPages shows a tunnel's pages directory and which of the page
+// templates it has
+func printPages(t *config.Tunnel) {
+	if t.Settings == nil || t.Settings.Pages.Dir == "" {
+		fmt.Printf(" Tunnel '%s' uses the built-in pages\n", t.Name)
+		return
+	}
+
+	dir := t.Settings.Pages.Dir
+	fmt.Printf(" Pages for tunnel '%s' from %s:\n", t.Name, dir)
+	for _, name := range []string{tunnel.PageBadGateway, tunnel.PageMaintenance, tunnel.PageBasicAuth, tunnel.PageLogin} {
+		path := filepath.Join(dir, name)
+		if _, err := os.Stat(path); err != nil {
+			fmt.Printf("   %-18s built-in\n", name)
+			continue
+		}
+		if _, err := template.ParseFiles(path); err != nil {
+			fmt.Printf(" %s %-18s %v\n", logger.ErrorMark(), name, err)
+			continue
+		}
+		fmt.Printf(" %s %-18s custom\n", logger.SuccessMark(), name)
+	}
+}
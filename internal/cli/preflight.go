@@ -0,0 +1,101 @@
+package cli
+
+import (
+	"fmt"
+	"skyport-agent/internal/config"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var preflightCmd = &cobra.Command{
+	Use:   "preflight [tunnel-name-or-id] [tcp|http <path>|off|show]",
+	Short: "Check the local service is up before a tunnel connects",
+	Long: `Check the tunnel's local service before connecting it, so a tunnel whose
+service is down fails to start with a clear error instead of answering 502s
+publicly. 'tcp' checks that something accepts connections on the local port;
+'http' also requests a health path, which must answer 2xx or 3xx.
+
+Auto-start tunnels that fail the check are retried by the agent's health
+checks, and connected once the service is up.
+
+Example:
+  skyport tunnel preflight myapp tcp
+  skyport tunnel preflight myapp http /healthz --timeout 5s
+  skyport tunnel preflight myapp off`,
+	Args:        cobra.RangeArgs(2, 3),
+	Annotations: map[string]string{offlineAnnotation: "true"},
+	Run:         runPreflight,
+}
+
+func init() {
+	preflightCmd.Flags().Duration("timeout", 0, "How long each check may take (default: keep current, initially 2s)")
+	tunnelCmd.AddCommand(preflightCmd)
+}
+
+func runPreflight(cmd *cobra.Command, args []string) {
+	nameOrID, mode := args[0], args[1]
+
+	configManager := config.NewConfigManager()
+	tunnel := findLocalTunnel(configManager, nameOrID)
+
+	var preflight config.PreflightSettings
+	if tunnel.Settings != nil {
+		preflight = tunnel.Settings.Preflight
+	}
+
+	switch mode {
+	case "show":
+		printPreflight(tunnel)
+		return
+	case "off":
+		preflight.Enabled = false
+	case "tcp":
+		preflight.Enabled, preflight.Path = true, ""
+	case "http":
+		if len(args) != 3 || !strings.HasPrefix(args[2], "/") {
+			exitWithError(fmt.Errorf("usage: skyport tunnel preflight %s http /<health-path>", nameOrID))
+		}
+		preflight.Enabled, preflight.Path = true, args[2]
+	default:
+		exitWithError(fmt.Errorf("second argument must be 'tcp', 'http', 'off' or 'show'"))
+	}
+	if mode != "http" && len(args) == 3 {
+		exitWithError(fmt.Errorf("usage: skyport tunnel preflight %s %s", nameOrID, mode))
+	}
+
+	if cmd.Flags().Changed("timeout") {
+		timeout, _ := cmd.Flags().GetDuration("timeout")
+		if timeout < 100*time.Millisecond {
+			exitWithError(fmt.Errorf("timeout must be at least 100ms"))
+		}
+		preflight.Timeout = config.Duration{Duration: timeout}
+	}
+
+	if err := configManager.SetTunnelPreflight(tunnel.ID, preflight); err != nil {
+		exitWithError(fmt.Errorf("failed to update preflight check: %w", err))
+	}
+
+	if tunnel.Settings == nil {
+		tunnel.Settings = &config.TunnelSettings{}
+	}
+	tunnel.Settings.Preflight = preflight
+	printPreflight(tunnel)
+	fmt.Println(" Takes effect the next time the tunnel connects.")
+}
+
+// printPreflight shows what is checked before a tunnel connects
+func printPreflight(t *config.Tunnel) {
+	preflight := t.PreflightSettings()
+	switch {
+	case !preflight.Enabled:
+		fmt.Printf(" Tunnel '%s' connects without checking its local service\n", t.Name)
+	case preflight.Path == "":
+		fmt.Printf(" Tunnel '%s' connects only if %s accepts connections (timeout %v)\n",
+			t.Name, t.LocalAddress(), preflight.Timeout)
+	default:
+		fmt.Printf(" Tunnel '%s' connects only if GET http://%s%s answers 2xx or 3xx (timeout %v)\n",
+			t.Name, t.LocalAddress(), preflight.Path, preflight.Timeout)
+	}
+}
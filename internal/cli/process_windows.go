@@ -3,6 +3,7 @@
 package cli
 
 import (
+	"os"
 	"os/exec"
 	"syscall"
 )
@@ -17,3 +18,27 @@ func configureDaemonProcess(cmd *exec.Cmd) {
 		CreationFlags: 0x00000200 | 0x00000008,
 	}
 }
+
+// processRunning reports whether a process with the given PID exists
+func processRunning(pid int) bool {
+	// FindProcess opens a handle to the process on Windows, which fails if
+	// there is no such process
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	p.Release()
+	return true
+}
+
+// terminateProcess stops a process. Windows has no SIGTERM for detached
+// processes, so it is terminated immediately; the server is told the tunnel
+// stopped separately.
+func terminateProcess(pid int) error {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	defer p.Release()
+	return p.Kill()
+}
@@ -0,0 +1,140 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"skyport-agent/internal/logger"
+	"sync"
+	"time"
+)
+
+// spinnerFrames are drawn in turn while an operation runs
+var spinnerFrames = []string{"⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏"}
+
+const (
+	// progressDelay keeps operations that finish quickly from flashing a spinner
+	progressDelay = 300 * time.Millisecond
+	spinnerTick   = 100 * time.Millisecond
+)
+
+// progress shows a long-running operation: on a terminal as a spinner with
+// the current step and elapsed time, elsewhere (pipes, CI logs) as one plain
+// line per step. Nothing is shown for operations that finish quickly.
+type progress struct {
+	interactive bool
+	start       time.Time
+
+	mu    sync.Mutex
+	step  string
+	shown bool
+	done  chan struct{}
+	wg    sync.WaitGroup
+}
+
+// startProgress starts showing step. Finish with Done, Fail or Stop.
+func startProgress(step string) *progress {
+	p := &progress{
+		interactive: logger.IsTerminal(os.Stdout),
+		start:       time.Now(),
+		step:        step,
+		done:        make(chan struct{}),
+	}
+
+	p.wg.Add(1)
+	go p.spin()
+	return p
+}
+
+// Step replaces the step being shown
+func (p *progress) Step(step string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if step == p.step {
+		return
+	}
+	p.step = step
+	if p.shown && !p.interactive {
+		fmt.Printf(" %s...\n", step)
+	}
+}
+
+// Done ends the operation with a success message
+func (p *progress) Done(message string) {
+	p.finish(fmt.Sprintf(" %s %s (%s)", logger.SuccessMark(), message, p.elapsed()))
+}
+
+// Fail ends the operation with a failure message
+func (p *progress) Fail(message string) {
+	p.finish(fmt.Sprintf(" %s %s (%s)", logger.ErrorMark(), message, p.elapsed()))
+}
+
+// Stop ends the operation without printing anything, clearing the spinner
+func (p *progress) Stop() {
+	p.finish("")
+}
+
+func (p *progress) finish(line string) {
+	p.mu.Lock()
+	select {
+	case <-p.done:
+		p.mu.Unlock()
+		return // Already finished
+	default:
+		close(p.done)
+	}
+	p.mu.Unlock()
+	p.wg.Wait()
+
+	if p.shown && p.interactive {
+		fmt.Print("\r\033[K")
+	}
+	if line != "" {
+		fmt.Println(line)
+	}
+}
+
+func (p *progress) spin() {
+	defer p.wg.Done()
+
+	delay := time.NewTimer(progressDelay)
+	defer delay.Stop()
+	select {
+	case <-p.done:
+		return
+	case <-delay.C:
+	}
+
+	if !p.interactive {
+		p.mu.Lock()
+		fmt.Printf(" %s...\n", p.step)
+		p.shown = true
+		p.mu.Unlock()
+		<-p.done
+		return
+	}
+
+	ticker := time.NewTicker(spinnerTick)
+	defer ticker.Stop()
+
+	for frame := 0; ; frame++ {
+		p.mu.Lock()
+		fmt.Printf("\r\033[K %s %s... %s", spinnerFrames[frame%len(spinnerFrames)], p.step, p.elapsed())
+		p.shown = true
+		p.mu.Unlock()
+
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *progress) elapsed() string {
+	elapsed := time.Since(p.start)
+	if elapsed < 10*time.Second {
+		return elapsed.Round(100 * time.Millisecond).String()
+	}
+	return elapsed.Round(time.Second).String()
+}
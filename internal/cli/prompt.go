@@ -0,0 +1,67 @@
+package cli
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"skyport-agent/internal/logger"
+	"strings"
+)
+
+var (
+	// assumeYes answers yes to every confirmation prompt (--yes)
+	assumeYes bool
+	// nonInteractive never waits for input (--non-interactive). It is implied
+	// when stdin is not a terminal, e.g. in scripts and CI.
+	nonInteractive bool
+)
+
+// stdinReader is shared by all prompts so input buffered by one is not lost
+// to the next
+var stdinReader = bufio.NewReader(os.Stdin)
+
+// interactive reports whether the user can be asked questions
+func interactive() bool {
+	return !nonInteractive && logger.IsTerminal(os.Stdin)
+}
+
+// confirm asks a yes/no question, defaulting to no. With --yes it is answered
+// yes without asking; when nobody can answer it is declined with a hint.
+func confirm(question string) bool {
+	if assumeYes {
+		return true
+	}
+	if !interactive() {
+		fmt.Printf("%s [y/N]: no (not interactive; pass --yes to confirm)\n", question)
+		return false
+	}
+
+	fmt.Printf("%s [y/N]: ", question)
+	answer, err := stdinReader.ReadString('\n')
+	if err != nil {
+		fmt.Println()
+		return false
+	}
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
+	default:
+		return false
+	}
+}
+
+// promptLine asks for a line of input. It returns false when nobody can
+// answer, so callers can point to the flag that supplies the value instead.
+func promptLine(label string) (string, bool) {
+	if !interactive() {
+		return "", false
+	}
+
+	fmt.Print(label)
+	answer, err := stdinReader.ReadString('\n')
+	if err != nil && answer == "" {
+		fmt.Println()
+		return "", false
+	}
+	return strings.TrimRight(answer, "\r\n"), true
+}
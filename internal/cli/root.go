@@ -3,20 +3,29 @@ package cli
 import (
 	"fmt"
 	"os"
+	"skyport-agent/internal/apperr"
 	"skyport-agent/internal/config"
+	"skyport-agent/internal/crash"
+	"skyport-agent/internal/logger"
 	"skyport-agent/internal/network"
+	"skyport-agent/internal/service"
+	"skyport-agent/internal/usagestats"
+	"time"
 
 	"github.com/spf13/cobra"
 )
 
 var (
-	version = "1.0.0"
-	verbose bool
+	version  = "1.0.0"
+	verbose  bool
+	noColor  bool
+	debug    bool
+	instance string
 )
 
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
-	Use:   "skyport",
+	Use:   "skyport [tunnel-name-or-id]",
 	Short: "SkyPort - Secure tunnel client",
 	Long: `SkyPort is a secure tunnel client that allows you to expose local services 
 to the internet through encrypted tunnels.
@@ -25,22 +34,33 @@ Features:
 - Secure authentication via browser login
 - Easy tunnel management
 - Automatic background connections
-- HTTP/HTTPS/WebSocket support`,
+- HTTP/HTTPS/WebSocket support
+
+Shortcuts:
+  skyport ls             same as 'skyport tunnel list'
+  skyport up <tunnel>    same as 'skyport tunnel run <tunnel>'
+  skyport down <tunnel>  same as 'skyport tunnel stop <tunnel>'
+  skyport <tunnel>       same as 'skyport tunnel run <tunnel>'`,
 	Version: version,
+	Args:    rootTunnelArgs,
+	Run:     runRootTunnel,
+	// Suggest commands for typos within this distance, also in rootTunnelArgs
+	SuggestionsMinimumDistance: 2,
 	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		runningCommand, commandStarted = cmd, time.Now()
+
 		// Skip network check for commands that don't need it or handle it themselves
-		if cmd.Name() == "version" || cmd.Name() == "skyport" || cmd.Name() == "uninstall" || cmd.Name() == "daemon" {
+		if cmd.Name() == "version" || (!cmd.HasParent() && len(args) == 0) || cmd.Name() == "uninstall" || cmd.Name() == "daemon" || isOfflineCommand(cmd) || isStatusCheck(cmd) {
 			return nil
 		}
 
 		// Check network connectivity before running any command
 		cfg := config.Load()
-		if err := network.CheckConnectivity(cfg); err != nil {
-			fmt.Printf("Error: %v\n", err)
-			fmt.Println("\nPlease ensure:")
-			fmt.Println("  - You have an active internet connection")
-			fmt.Println("  - The SkyPort server is running")
-			os.Exit(1)
+		checking := startProgress("Checking connection to the SkyPort server")
+		err := network.CheckConnectivity(cfg)
+		checking.Stop()
+		if err != nil {
+			exitWithError(explainError(err, apperr.ErrServerUnreachable))
 		}
 
 		if verbose {
@@ -49,6 +69,23 @@ Features:
 
 		return nil
 	},
+	PersistentPostRun: func(cmd *cobra.Command, args []string) {
+		recordUsage(nil, 0)
+	},
+}
+
+// offlineAnnotation marks commands (and their subcommands) that work without
+// reaching the SkyPort server, so the connectivity check is skipped for them
+const offlineAnnotation = "skyport/offline"
+
+// isOfflineCommand reports whether the command or one of its parents is marked offline
+func isOfflineCommand(cmd *cobra.Command) bool {
+	for c := cmd; c != nil; c = c.Parent() {
+		if c.Annotations[offlineAnnotation] == "true" {
+			return true
+		}
+	}
+	return false
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
@@ -57,17 +94,49 @@ func Execute() error {
 }
 
 func init() {
+	crash.Version = version
+	usagestats.Version = version
+	service.Version = version
+
 	rootCmd.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "verbose output")
+	rootCmd.PersistentFlags().BoolVar(&noColor, "no-color", false, "disable colored output (also set by NO_COLOR)")
+	rootCmd.PersistentFlags().BoolVarP(&assumeYes, "yes", "y", false, "answer yes to all confirmation prompts")
+	rootCmd.PersistentFlags().BoolVar(&debug, "debug", false, "show the underlying cause of errors and debug logs")
+	rootCmd.PersistentFlags().BoolVar(&nonInteractive, "non-interactive", false, "never prompt for input (implied when stdin is not a terminal)")
+	rootCmd.PersistentFlags().StringVar(&instance, "instance", os.Getenv("SKYPORT_INSTANCE"), "agent instance to use, each with its own login, tunnels and service (also set by SKYPORT_INSTANCE)")
+
+	cobra.OnInitialize(func() {
+		if noColor {
+			logger.DisableColor()
+		}
+		if debug {
+			config.EnableDebugMode()
+		}
+		if err := config.SetInstance(instance); err != nil {
+			exitWithError(err)
+		}
+	})
 
 	// Add subcommands
+	rootCmd.AddCommand(initCmd)
 	rootCmd.AddCommand(loginCmd)
 	rootCmd.AddCommand(logoutCmd)
 	rootCmd.AddCommand(tunnelCmd)
+	rootCmd.AddCommand(openCmd)
 	rootCmd.AddCommand(daemonCmd)
 	rootCmd.AddCommand(serviceCmd)
+	rootCmd.AddCommand(devDomainCmd)
 	rootCmd.AddCommand(agentStatusCmd)
 	rootCmd.AddCommand(versionCmd)
 	rootCmd.AddCommand(uninstallAgentCmd)
+	rootCmd.AddCommand(notifyCmd)
+	rootCmd.AddCommand(supportCmd)
+	rootCmd.AddCommand(eventsCmd)
+	rootCmd.AddCommand(httpCmd)
+	rootCmd.AddCommand(discoverCmd)
+	rootCmd.AddCommand(importCmd)
+	rootCmd.AddCommand(telemetryCmd)
+	rootCmd.AddCommand(configCmd)
 }
 
 var versionCmd = &cobra.Command{
@@ -0,0 +1,126 @@
+package cli
+
+import (
+	"fmt"
+	"net/http"
+	"skyport-agent/internal/config"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var sanitizeCmd = &cobra.Command{
+	Use:   "sanitize [tunnel-name-or-id] [show|clear|authorization|cookies|deny] [args...]",
+	Short: "Strip credentials and internal headers before requests reach your service",
+	Long: `Choose which inbound headers are stripped before requests are forwarded to
+your local service. Hop-by-hop headers (Connection, Keep-Alive, Upgrade, ...)
+are always stripped. Authorization and all cookies are passed through unless
+you change that here.
+
+Basic auth and identity provider logins are checked before headers are
+stripped, and header rules ('skyport tunnel headers') apply after, so they can
+still set any header.
+
+Example:
+  skyport tunnel sanitize myapp authorization strip
+  skyport tunnel sanitize myapp cookies none
+  skyport tunnel sanitize myapp cookies allow session_id csrftoken
+  skyport tunnel sanitize myapp deny add X-Internal-* X-Debug-Token
+  skyport tunnel sanitize myapp deny remove X-Debug-Token
+  skyport tunnel sanitize myapp show`,
+	Args:        cobra.MinimumNArgs(2),
+	Annotations: map[string]string{offlineAnnotation: "true"},
+	Run:         runSanitize,
+}
+
+func init() {
+	tunnelCmd.AddCommand(sanitizeCmd)
+}
+
+func runSanitize(cmd *cobra.Command, args []string) {
+	nameOrID, action, params := args[0], args[1], args[2:]
+
+	configManager := config.NewConfigManager()
+	tunnel := findLocalTunnel(configManager, nameOrID)
+
+	var policy config.SanitizeSettings
+	if tunnel.Settings != nil {
+		policy = tunnel.Settings.Sanitize
+	}
+
+	switch action {
+	case "show":
+		printSanitizePolicy(tunnel.Name, tunnel.SanitizeSettings())
+		return
+	case "clear":
+		policy = config.SanitizeSettings{}
+	case "authorization":
+		if len(params) != 1 || (params[0] != "pass" && params[0] != "strip") {
+			exitWithError(fmt.Errorf("usage: skyport tunnel sanitize %s authorization pass|strip", nameOrID))
+		}
+		policy.StripAuthorization = params[0] == "strip"
+	case "cookies":
+		if len(params) == 0 {
+			exitWithError(fmt.Errorf("usage: skyport tunnel sanitize %s cookies all|none|allow <cookie>...", nameOrID))
+		}
+		switch params[0] {
+		case config.CookiesAll, config.CookiesNone:
+			policy.Cookies, policy.AllowCookies = params[0], nil
+		case config.CookiesAllow:
+			if len(params) < 2 {
+				exitWithError(fmt.Errorf("usage: skyport tunnel sanitize %s cookies allow <cookie>...", nameOrID))
+			}
+			policy.Cookies, policy.AllowCookies = config.CookiesAllow, params[1:]
+		default:
+			exitWithError(fmt.Errorf("cookie policy must be 'all', 'none' or 'allow'"))
+		}
+	case "deny":
+		if len(params) < 2 || (params[0] != "add" && params[0] != "remove") {
+			exitWithError(fmt.Errorf("usage: skyport tunnel sanitize %s deny add|remove <header>...", nameOrID))
+		}
+		var names []string
+		for _, name := range params[1:] {
+			names = append(names, http.CanonicalHeaderKey(name))
+		}
+		if params[0] == "add" {
+			policy.Deny = addEntries(policy.Deny, names)
+		} else {
+			policy.Deny = removeEntries(policy.Deny, names)
+		}
+	default:
+		exitWithError(fmt.Errorf("second argument must be 'show', 'clear', 'authorization', 'cookies' or 'deny'"))
+	}
+
+	if err := configManager.SetTunnelSanitize(tunnel.ID, policy); err != nil {
+		exitWithError(fmt.Errorf("failed to update header sanitization: %w", err))
+	}
+
+	if tunnel.Settings == nil {
+		tunnel.Settings = &config.TunnelSettings{}
+	}
+	tunnel.Settings.Sanitize = policy
+	printSanitizePolicy(tunnel.Name, tunnel.SanitizeSettings())
+	fmt.Println(" Takes effect the next time the tunnel connects.")
+}
+
+// printSanitizePolicy shows which inbound headers a tunnel strips
+func printSanitizePolicy(tunnelName string, policy config.SanitizeSettings) {
+	fmt.Printf(" Header sanitization for tunnel '%s':\n", tunnelName)
+	fmt.Println("   Hop-by-hop headers: stripped")
+	if policy.StripAuthorization {
+		fmt.Println("   Authorization:      stripped")
+	} else {
+		fmt.Println("   Authorization:      passed through")
+	}
+	switch policy.Cookies {
+	case config.CookiesNone:
+		fmt.Println("   Cookies:            stripped")
+	case config.CookiesAllow:
+		fmt.Printf("   Cookies:            only %s\n", strings.Join(policy.AllowCookies, ", "))
+	default:
+		fmt.Println("   Cookies:            passed through")
+	}
+	if len(policy.Deny) > 0 {
+		fmt.Printf("   Also stripped:      %s\n", strings.Join(policy.Deny, ", "))
+	}
+}
@@ -0,0 +1,209 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"skyport-agent/internal/config"
+	"skyport-agent/internal/journal"
+	"skyport-agent/internal/tunnel"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var scanCmd = &cobra.Command{
+	Use:   "scan [tunnel-name-or-id] [show|enable|disable|action|pattern|builtin|command|report] [args...]",
+	Short: "Block or redact responses containing secrets or personal data",
+	Long: `Scan response bodies for secrets and personal data before they leave through
+the tunnel. Textual bodies (HTML, JSON, JavaScript, XML, ...) are checked,
+gzip-compressed ones after decompressing them.
+
+Built-in patterns find private keys, AWS, GitHub, Slack, Stripe and Google
+credentials and card numbers; add your own as regular expressions. A scan
+command can also be run with the body on stdin. It prints nothing for a
+clean body, or {"findings": ["name", ...]} and, for redaction, the redacted
+body as {"findings": [...], "body": "..."}. If it fails the response is
+blocked, unless --fail-open is set.
+
+With the "block" action, responses with findings are answered 502 instead.
+With "redact", the matches are replaced with [REDACTED:<pattern>]. Every
+blocked or redacted response is recorded; 'report' lists them.
+
+Example:
+  skyport tunnel scan myapp enable
+  skyport tunnel scan myapp action redact
+  skyport tunnel scan myapp pattern add employee-id 'EMP-[0-9]{6}'
+  skyport tunnel scan myapp pattern remove employee-id
+  skyport tunnel scan myapp builtin off
+  skyport tunnel scan myapp command --timeout 2s -- /usr/local/bin/find-pii
+  skyport tunnel scan myapp command clear
+  skyport tunnel scan myapp report --since 7d`,
+	Args:        cobra.MinimumNArgs(2),
+	Annotations: map[string]string{offlineAnnotation: "true"},
+	Run:         runScan,
+}
+
+func init() {
+	scanCmd.Flags().Duration("timeout", 5*time.Second, "Maximum run time of the scan command per response")
+	scanCmd.Flags().Bool("fail-open", false, "Send the response unscanned if the scan command fails instead of blocking it")
+	scanCmd.Flags().String("since", "7d", "How far back 'report' looks (e.g. 1h, 7d)")
+	tunnelCmd.AddCommand(scanCmd)
+}
+
+func runScan(cmd *cobra.Command, args []string) {
+	nameOrID, action, params := args[0], args[1], args[2:]
+
+	configManager := config.NewConfigManager()
+	t := findLocalTunnel(configManager, nameOrID)
+
+	var scan config.ScanSettings
+	if t.Settings != nil {
+		scan = t.Settings.Scan
+	}
+
+	switch action {
+	case "show":
+		printScanSettings(t.Name, t.ScanSettings())
+		return
+	case "report":
+		since, _ := cmd.Flags().GetString("since")
+		printScanReport(t, since)
+		return
+	case "enable", "disable":
+		scan.Enabled = action == "enable"
+	case "action":
+		if len(params) != 1 || (params[0] != config.ScanActionBlock && params[0] != config.ScanActionRedact) {
+			exitWithError(fmt.Errorf("usage: skyport tunnel scan %s action block|redact", nameOrID))
+		}
+		scan.Action = params[0]
+	case "pattern":
+		switch {
+		case len(params) == 3 && params[0] == "add":
+			if _, err := regexp.Compile(params[2]); err != nil {
+				exitWithError(fmt.Errorf("invalid pattern: %w", err))
+			}
+			scan.Patterns = removeScanPattern(scan.Patterns, params[1])
+			scan.Patterns = append(scan.Patterns, config.ScanPattern{Name: params[1], Regex: params[2]})
+		case len(params) == 2 && params[0] == "remove":
+			patterns := removeScanPattern(scan.Patterns, params[1])
+			if len(patterns) == len(scan.Patterns) {
+				exitWithError(fmt.Errorf("no pattern named %s; run 'skyport tunnel scan %s show' to see them", params[1], nameOrID))
+			}
+			scan.Patterns = patterns
+		default:
+			exitWithError(fmt.Errorf("usage: skyport tunnel scan %s pattern add <name> <regex> | pattern remove <name>", nameOrID))
+		}
+	case "builtin":
+		if len(params) != 1 || (params[0] != "on" && params[0] != "off") {
+			exitWithError(fmt.Errorf("usage: skyport tunnel scan %s builtin on|off", nameOrID))
+		}
+		scan.SkipBuiltin = params[0] == "off"
+	case "command":
+		if len(params) == 0 {
+			exitWithError(fmt.Errorf("usage: skyport tunnel scan %s command [flags] -- <command> [args...] | command clear", nameOrID))
+		}
+		if len(params) == 1 && params[0] == "clear" {
+			scan.Command, scan.Args, scan.Timeout, scan.FailOpen = "", nil, config.Duration{}, false
+			break
+		}
+		if _, err := exec.LookPath(params[0]); err != nil {
+			fmt.Printf(" Warning: %v\n", err)
+		}
+		timeout, _ := cmd.Flags().GetDuration("timeout")
+		failOpen, _ := cmd.Flags().GetBool("fail-open")
+		scan.Command, scan.Args = params[0], params[1:]
+		scan.Timeout, scan.FailOpen = config.Duration{Duration: timeout}, failOpen
+	default:
+		exitWithError(fmt.Errorf("second argument must be 'show', 'enable', 'disable', 'action', 'pattern', 'builtin', 'command' or 'report'"))
+	}
+
+	if err := configManager.SetTunnelScan(t.ID, scan); err != nil {
+		exitWithError(fmt.Errorf("failed to update content scanning: %w", err))
+	}
+
+	t = findLocalTunnel(configManager, t.ID)
+	printScanSettings(t.Name, t.ScanSettings())
+	fmt.Println(" Takes effect the next time the tunnel connects.")
+}
+
+// removeScanPattern returns patterns without the one with the given name
+func removeScanPattern(patterns []config.ScanPattern, name string) []config.ScanPattern {
+	var kept []config.ScanPattern
+	for _, pattern := range patterns {
+		if pattern.Name != name {
+			kept = append(kept, pattern)
+		}
+	}
+	return kept
+}
+
+// printScanSettings shows a tunnel's content scanning settings
+func printScanSettings(tunnelName string, scan config.ScanSettings) {
+	if !scan.Enabled {
+		fmt.Printf(" Content scanning is disabled for tunnel '%s'\n", tunnelName)
+		return
+	}
+
+	fmt.Printf(" Content scanning for tunnel '%s':\n", tunnelName)
+	fmt.Printf("   Action:   %s\n", scan.Action)
+	if scan.SkipBuiltin {
+		fmt.Println("   Built-in: off")
+	} else {
+		fmt.Printf("   Built-in: %s\n", strings.Join(tunnel.BuiltinScanPatterns(), ", "))
+	}
+	for _, pattern := range scan.Patterns {
+		fmt.Printf("   Pattern:  %s = %s\n", pattern.Name, pattern.Regex)
+	}
+	if scan.Command != "" {
+		mode := "fail closed"
+		if scan.FailOpen {
+			mode = "fail open"
+		}
+		command := strings.TrimSpace(scan.Command + " " + strings.Join(scan.Args, " "))
+		fmt.Printf("   Command:  [%s, %s] %s\n", scan.Timeout.Duration, mode, command)
+	}
+}
+
+// printScanReport lists the tunnel's responses blocked or redacted by
+// content scanning, from the event journal
+func printScanReport(t *config.Tunnel, since string) {
+	period, err := parsePeriod(since)
+	if err != nil {
+		exitWithError(fmt.Errorf("invalid --since value: %w", err))
+	}
+
+	entries, err := journal.Read(journal.Filter{Since: time.Now().Add(-period), Tunnel: t.ID})
+	if err != nil {
+		exitWithError(fmt.Errorf("failed to read event journal: %w", err))
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	blocked, redacted := 0, 0
+	for _, entry := range entries {
+		switch entry.Type {
+		case tunnel.EventContentBlocked:
+			blocked++
+		case tunnel.EventContentRedacted:
+			redacted++
+		default:
+			continue
+		}
+		if blocked+redacted == 1 {
+			fmt.Fprintln(w, "TIME\tACTION\tREQUEST\tFINDINGS")
+		}
+		request, findings, _ := strings.Cut(entry.Reason, ": ")
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", entry.Time.Local().Format("2006-01-02 15:04:05"),
+			strings.TrimPrefix(entry.Type, "content_"), request, findings)
+	}
+	w.Flush()
+
+	if blocked+redacted == 0 {
+		fmt.Printf(" No responses blocked or redacted for tunnel '%s' in the last %s\n", t.Name, since)
+		return
+	}
+	fmt.Printf("\n %d blocked, %d redacted in the last %s\n", blocked, redacted, since)
+}
@@ -2,9 +2,16 @@ package cli
 
 import (
 	"fmt"
-	"log"
+	"os"
+	"skyport-agent/internal/apperr"
+	"skyport-agent/internal/config"
+	"skyport-agent/internal/control"
+	"skyport-agent/internal/logger"
 	"skyport-agent/internal/service"
+	"skyport-agent/internal/state"
 	"strings"
+	"text/tabwriter"
+	"time"
 
 	"github.com/spf13/cobra"
 )
@@ -19,7 +26,8 @@ var serviceCmd = &cobra.Command{
 - stop: Stop the agent service
 - restart: Restart the agent service
 - status: Show service status
-- logs: Show service logs`,
+- logs: Show service logs
+- render: Print the unit file install would write`,
 }
 
 var installCmd = &cobra.Command{
@@ -28,8 +36,24 @@ var installCmd = &cobra.Command{
 	Long: `Install the SkyPort agent as a systemd service that will:
 - Start automatically on system boot
 - Restart automatically if it crashes
-- Run in the background with full persistence`,
+- Run in the background with full persistence
+
+Run it with sudo from your own account: the unit file is written as root, but
+the daemon runs as you and uses your login and tunnels from ~/.skyport. It
+refuses to run as root unless --allow-root is given.
+
+On a machine shared by several users, --user installs a service per user
+instead, named skyport-agent@<user>. Each runs as its user with that user's
+own login and tunnels, so no daemon holds another user's credentials; every
+user logs in once with 'skyport login'. The other service commands take
+--user too, or --all-users for every per-user service installed.
+
+Use --dry-run to see what would be written where, without writing anything,
+and --now to also start the service and check that the agent comes up and is
+logged in.`,
 	Run: runInstall,
+	// Installing only writes the unit; the daemon connects once started
+	Annotations: map[string]string{offlineAnnotation: "true"},
 }
 
 var uninstallCmd = &cobra.Command{
@@ -67,6 +91,22 @@ var serviceStatusCmd = &cobra.Command{
 	Run:   runServiceStatus,
 }
 
+var renderCmd = &cobra.Command{
+	Use:   "render",
+	Short: "Print the systemd unit file 'service install' would write",
+	Long: `Print the systemd unit file 'service install' would write, to check it before
+installing. The unit can be customized in the "service" settings of the user
+the daemon runs as (Environment, After=, Restart= and Nice=), or replaced by a
+text/template file.
+
+Example:
+  skyport service render --template ~/skyport-agent.service.tmpl
+  skyport service render --user alice`,
+	Args:        cobra.NoArgs,
+	Run:         runRender,
+	Annotations: map[string]string{offlineAnnotation: "true"},
+}
+
 var logsCmd = &cobra.Command{
 	Use:   "logs",
 	Short: "Show SkyPort agent service logs",
@@ -82,106 +122,309 @@ func init() {
 	serviceCmd.AddCommand(serviceRestartCmd)
 	serviceCmd.AddCommand(serviceStatusCmd)
 	serviceCmd.AddCommand(logsCmd)
-}
+	serviceCmd.AddCommand(renderCmd)
 
-func runInstall(cmd *cobra.Command, args []string) {
-	fmt.Println("Installing SkyPort agent as system service...")
+	serviceCmd.PersistentFlags().StringSlice("user", nil, "Act on the per-user service of this user (repeatable), for machines shared by several users")
+	serviceCmd.PersistentFlags().Bool("all-users", false, "Act on every per-user service installed on this machine")
 
-	systemdService := service.NewSystemdService()
+	installCmd.Flags().Bool("dry-run", false, "Print the unit file and where it would be written, without installing")
+	installCmd.Flags().Bool("now", false, "Also start the service, and check that the agent comes up logged in")
+	installCmd.Flags().Bool("start", false, "Same as --now")
+	for _, cmd := range []*cobra.Command{installCmd, renderCmd} {
+		cmd.Flags().Bool("allow-root", false, "Let the daemon run as root")
+		cmd.Flags().String("template", "", "Render the unit from this text/template file instead of the settings")
+	}
+}
 
-	// Check if already installed
-	if systemdService.IsInstalled() {
-		fmt.Println("Service is already installed")
-		return
+// targetServices returns the services a service command acts on: the one
+// of the user running it, or with --user or --all-users the per-user
+// services of a shared machine
+func targetServices(cmd *cobra.Command) []*service.SystemdService {
+	users, _ := cmd.Flags().GetStringSlice("user")
+	allUsers, _ := cmd.Flags().GetBool("all-users")
+
+	switch {
+	case allUsers && len(users) > 0:
+		exitWithError(fmt.Errorf("use either --user or --all-users"))
+	case allUsers:
+		services, err := service.UserServices()
+		if err != nil {
+			exitWithError(err)
+		}
+		if len(services) == 0 {
+			exitWithError(fmt.Errorf("no per-user services are installed; install them with 'sudo skyport service install --user <name>'"))
+		}
+		return services
+	case len(users) == 0:
+		return []*service.SystemdService{service.NewSystemdService()}
 	}
 
-	// Install the service
-	if err := systemdService.Install(); err != nil {
-		log.Fatalf("Failed to install service: %v", err)
+	var services []*service.SystemdService
+	for _, username := range users {
+		systemdService, err := service.NewUserService(username)
+		if err != nil {
+			exitWithError(err)
+		}
+		services = append(services, systemdService)
 	}
+	return services
+}
 
-	fmt.Println("Service installed successfully!")
-	fmt.Println("Use 'skyport service start' to start the service")
-	fmt.Println("Use 'skyport service status' to check service status")
+// perUserServices reports whether a service command acts on per-user services
+func perUserServices(cmd *cobra.Command) bool {
+	users, _ := cmd.Flags().GetStringSlice("user")
+	allUsers, _ := cmd.Flags().GetBool("all-users")
+	return allUsers || len(users) > 0
 }
 
-func runUninstall(cmd *cobra.Command, args []string) {
-	fmt.Println("Uninstalling SkyPort agent system service...")
+// unitServices returns the services with the unit options given to
+// 'service install' or 'service render'
+func unitServices(cmd *cobra.Command) []*service.SystemdService {
+	allowRoot, _ := cmd.Flags().GetBool("allow-root")
+	template, _ := cmd.Flags().GetString("template")
 
-	systemdService := service.NewSystemdService()
+	services := targetServices(cmd)
+	for _, systemdService := range services {
+		systemdService.SetAllowRoot(allowRoot)
+		systemdService.SetTemplate(template)
+	}
+	return services
+}
 
-	// Check if installed
-	if !systemdService.IsInstalled() {
-		fmt.Println("Service is not installed")
+func runRender(cmd *cobra.Command, args []string) {
+	services := unitServices(cmd)
+	for _, systemdService := range services {
+		unit, err := systemdService.UnitFile()
+		if err != nil {
+			exitWithError(err)
+		}
+		if len(services) > 1 {
+			fmt.Printf("# %s\n", systemdService.UnitPath())
+		}
+		fmt.Print(unit)
+	}
+}
+
+func runInstall(cmd *cobra.Command, args []string) {
+	dryRun, _ := cmd.Flags().GetBool("dry-run")
+	services := unitServices(cmd)
+
+	if dryRun {
+		for _, systemdService := range services {
+			printInstallSummary(systemdService)
+			if err := systemdService.CheckInstall(); err != nil {
+				fmt.Printf(" %s Installing would fail: %v\n", logger.WarningMark(), err)
+			}
+			unit, err := systemdService.UnitFile()
+			if err != nil {
+				exitWithError(err)
+			}
+			fmt.Printf("\n# %s\n%s", systemdService.UnitPath(), unit)
+		}
 		return
 	}
 
-	// Uninstall the service
-	if err := systemdService.Uninstall(); err != nil {
-		log.Fatalf("Failed to uninstall service: %v", err)
+	startNow, _ := cmd.Flags().GetBool("now")
+	if start, _ := cmd.Flags().GetBool("start"); start {
+		startNow = true
 	}
 
-	fmt.Println("Service uninstalled successfully!")
+	for _, systemdService := range services {
+		printInstallSummary(systemdService)
+
+		// Check if already installed
+		if systemdService.IsInstalled() {
+			fmt.Printf("Service %s is already installed\n", systemdService.Name())
+			if startNow {
+				startAndVerify(systemdService)
+			}
+			continue
+		}
+
+		if err := systemdService.CheckInstall(); err != nil {
+			exitWithError(err)
+		}
+
+		fmt.Printf("Installing %s as system service...\n", systemdService.Name())
+
+		// Install the service
+		if err := systemdService.Install(); err != nil {
+			exitWithError(fmt.Errorf("failed to install service: %w", err))
+		}
+
+		fmt.Println("Service installed successfully!")
+		if startNow {
+			startAndVerify(systemdService)
+		}
+	}
+	if !startNow {
+		fmt.Println("Use 'skyport service start' to start the service")
+		fmt.Println("Use 'skyport service status' to check service status")
+	}
 }
 
-func runStart(cmd *cobra.Command, args []string) {
-	fmt.Println("Starting SkyPort agent service...")
+// startAndVerify starts the service and waits until its daemon answers on
+// its control socket and reports being logged in
+func startAndVerify(systemdService *service.SystemdService) {
+	if err := systemdService.Start(); err != nil {
+		exitWithError(fmt.Errorf("failed to start service: %w", err))
+	}
 
-	systemdService := service.NewSystemdService()
+	waiting := startProgress("Waiting for the agent to start")
+	status, err := waitForServiceDaemon(systemdService, serviceStartTimeout)
+	if err != nil {
+		waiting.Stop()
+		if logs, err := systemdService.GetLogs(20); err == nil {
+			fmt.Println(logs)
+		}
+		exitWithError(err)
+	}
 
-	// Check if installed
-	if !systemdService.IsInstalled() {
-		fmt.Println("Service is not installed. Run 'skyport service install' first")
-		return
+	if !status.Authenticated {
+		waiting.Stop()
+		notLoggedIn := apperr.ErrNotAuthenticated.Withf("Service %s is running (pid %d) but not logged in", systemdService.Name(), status.PID)
+		notLoggedIn.Hint = fmt.Sprintf("Run 'skyport login' as %s, then 'sudo skyport service restart'. If you are logged in, "+
+			"the login keyring may not be available to system services; check 'skyport service logs'", systemdService.User())
+		exitWithError(notLoggedIn)
 	}
+	waiting.Done(fmt.Sprintf("Service %s is running (pid %d) and logged in", systemdService.Name(), status.PID))
+}
 
-	// Start the service
-	if err := systemdService.Start(); err != nil {
-		log.Fatalf("Failed to start service: %v", err)
+// serviceStartTimeout is how long 'service install --now' waits for the
+// daemon to answer
+const serviceStartTimeout = 20 * time.Second
+
+// waitForServiceDaemon waits until the service's daemon serves its status
+// on its control socket. The daemon runs as the service user, so its socket
+// and admin token are looked up in that user's config directory.
+func waitForServiceDaemon(systemdService *service.SystemdService, timeout time.Duration) (*control.Status, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		if active, _ := systemdService.Status(); active == "failed" {
+			return nil, fmt.Errorf("service %s failed to start", systemdService.Name())
+		}
+
+		if pid := systemdService.MainPID(); pid > 0 {
+			appConfig, err := config.NewConfigManagerAt(systemdService.ConfigPath()).LoadConfig()
+			if err == nil && appConfig.AdminToken != "" {
+				var status control.Status
+				socket := control.SocketPath(state.DirIn(systemdService.ConfigPath()), pid)
+				if err := control.GetWithToken(socket, appConfig.AdminToken, "/v1/status", &status); err == nil {
+					return &status, nil
+				}
+			}
+		}
+
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("service %s did not come up within %v", systemdService.Name(), timeout)
+		}
+		time.Sleep(500 * time.Millisecond)
 	}
+}
 
-	fmt.Println("Service started successfully!")
+// printInstallSummary shows what 'service install' writes where and which
+// account the daemon runs as
+func printInstallSummary(systemdService *service.SystemdService) {
+	fmt.Println(" The SkyPort agent service will be installed as follows:")
+	fmt.Printf("   Unit file:  %s (written as root)\n", systemdService.UnitPath())
+	fmt.Printf("   Runs as:    %s\n", systemdService.User())
+	fmt.Printf("   Config:     %s (login and tunnels the daemon uses)\n", systemdService.ConfigPath())
+	fmt.Printf("   Then runs:  systemctl daemon-reload, systemctl enable %s\n", systemdService.Name())
+
+	if systemdService.RunsAsRoot() {
+		fmt.Printf(" %s The daemon will run as root; it has access to the whole system\n", logger.WarningMark())
+	}
+	if _, err := os.Stat(systemdService.ConfigPath()); os.IsNotExist(err) {
+		login := "skyport login"
+		if config.Instance() != "" {
+			login = "skyport --instance " + config.Instance() + " login"
+		}
+		fmt.Printf(" %s %s does not exist yet; run '%s' as %s before starting the service\n", logger.WarningMark(), systemdService.ConfigPath(), login, systemdService.User())
+	}
 }
 
-func runServiceStop(cmd *cobra.Command, args []string) {
-	fmt.Println("Stopping SkyPort agent service...")
+func runUninstall(cmd *cobra.Command, args []string) {
+	for _, systemdService := range targetServices(cmd) {
+		fmt.Printf("Uninstalling %s system service...\n", systemdService.Name())
 
-	systemdService := service.NewSystemdService()
+		// Check if installed
+		if !systemdService.IsInstalled() {
+			fmt.Println("Service is not installed")
+			continue
+		}
 
-	// Check if installed
-	if !systemdService.IsInstalled() {
-		fmt.Println("Service is not installed")
-		return
+		// Uninstall the service
+		if err := systemdService.Uninstall(); err != nil {
+			exitWithError(fmt.Errorf("failed to uninstall service: %w", err))
+		}
+
+		fmt.Println("Service uninstalled successfully!")
 	}
+}
+
+func runStart(cmd *cobra.Command, args []string) {
+	for _, systemdService := range targetServices(cmd) {
+		fmt.Printf("Starting %s service...\n", systemdService.Name())
+
+		// Check if installed
+		if !systemdService.IsInstalled() {
+			fmt.Println("Service is not installed. Run 'skyport service install' first")
+			continue
+		}
+
+		// Start the service
+		if err := systemdService.Start(); err != nil {
+			exitWithError(fmt.Errorf("failed to start service: %w", err))
+		}
 
-	// Stop the service
-	if err := systemdService.Stop(); err != nil {
-		log.Fatalf("Failed to stop service: %v", err)
+		fmt.Println("Service started successfully!")
 	}
+}
+
+func runServiceStop(cmd *cobra.Command, args []string) {
+	for _, systemdService := range targetServices(cmd) {
+		fmt.Printf("Stopping %s service...\n", systemdService.Name())
+
+		// Check if installed
+		if !systemdService.IsInstalled() {
+			fmt.Println("Service is not installed")
+			continue
+		}
+
+		// Stop the service
+		if err := systemdService.Stop(); err != nil {
+			exitWithError(fmt.Errorf("failed to stop service: %w", err))
+		}
 
-	fmt.Println("Service stopped successfully!")
+		fmt.Println("Service stopped successfully!")
+	}
 }
 
 func runServiceRestart(cmd *cobra.Command, args []string) {
-	fmt.Println("Restarting SkyPort agent service...")
+	for _, systemdService := range targetServices(cmd) {
+		fmt.Printf("Restarting %s service...\n", systemdService.Name())
 
-	systemdService := service.NewSystemdService()
+		// Check if installed
+		if !systemdService.IsInstalled() {
+			fmt.Println("Service is not installed. Run 'skyport service install' first")
+			continue
+		}
 
-	// Check if installed
-	if !systemdService.IsInstalled() {
-		fmt.Println("Service is not installed. Run 'skyport service install' first")
-		return
-	}
+		// Restart the service
+		if err := systemdService.Restart(); err != nil {
+			exitWithError(fmt.Errorf("failed to restart service: %w", err))
+		}
 
-	// Restart the service
-	if err := systemdService.Restart(); err != nil {
-		log.Fatalf("Failed to restart service: %v", err)
+		fmt.Println("Service restarted successfully!")
 	}
-
-	fmt.Println("Service restarted successfully!")
 }
 
 func runServiceStatus(cmd *cobra.Command, args []string) {
+	if perUserServices(cmd) {
+		printUserServicesStatus(targetServices(cmd))
+		return
+	}
+
 	systemdService := service.NewSystemdService()
 
 	// Check if installed
@@ -193,11 +436,11 @@ func runServiceStatus(cmd *cobra.Command, args []string) {
 	// Get service status
 	status, err := systemdService.Status()
 	if err != nil {
-		log.Fatalf("Failed to get service status: %v", err)
+		exitWithError(fmt.Errorf("failed to get service status: %w", err))
 	}
 
 	// Get network info
-	networkMonitor := service.NewNetworkMonitor()
+	networkMonitor := service.NewNetworkMonitor(config.Load())
 	networkInfo := networkMonitor.GetCurrentNetworkInfo()
 
 	// Display status
@@ -217,22 +460,36 @@ func runServiceStatus(cmd *cobra.Command, args []string) {
 	fmt.Println("  skyport service uninstall - Remove the service")
 }
 
-func runLogs(cmd *cobra.Command, args []string) {
-	systemdService := service.NewSystemdService()
-
-	// Check if installed
-	if !systemdService.IsInstalled() {
-		fmt.Println("Service is not installed")
-		return
+// printUserServicesStatus shows one line per user's service
+func printUserServicesStatus(services []*service.SystemdService) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "USER\tSERVICE\tSTATUS\tCONFIG")
+	for _, systemdService := range services {
+		status := "not installed"
+		if systemdService.IsInstalled() {
+			status, _ = systemdService.Status()
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", systemdService.User(), systemdService.Name(), status, systemdService.ConfigPath())
 	}
+	w.Flush()
+}
 
-	// Get service logs
-	logs, err := systemdService.GetLogs(50) // Last 50 lines
-	if err != nil {
-		log.Fatalf("Failed to get service logs: %v", err)
+func runLogs(cmd *cobra.Command, args []string) {
+	for _, systemdService := range targetServices(cmd) {
+		// Check if installed
+		if !systemdService.IsInstalled() {
+			fmt.Printf("Service %s is not installed\n", systemdService.Name())
+			continue
+		}
+
+		// Get service logs
+		logs, err := systemdService.GetLogs(50) // Last 50 lines
+		if err != nil {
+			exitWithError(fmt.Errorf("failed to get service logs: %w", err))
+		}
+
+		fmt.Printf("%s Service Logs:\n", systemdService.Name())
+		fmt.Println(strings.Repeat("-", 80))
+		fmt.Println(logs)
 	}
-
-	fmt.Println("SkyPort Agent Service Logs:")
-	fmt.Println(strings.Repeat("-", 80))
-	fmt.Println(logs)
 }
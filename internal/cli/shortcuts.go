@@ -0,0 +1,59 @@
+package cli
+
+import (
+	"fmt"
+	"skyport-agent/internal/apperr"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// shortcutCommand makes a top-level command that runs a tunnel subcommand,
+// such as "skyport up" for "skyport tunnel run". It shares the subcommand's
+// flags, including those of "tunnel" itself.
+func shortcutCommand(name string, target *cobra.Command) *cobra.Command {
+	use := name
+	if i := strings.Index(target.Use, " "); i >= 0 {
+		use += target.Use[i:]
+	}
+
+	shortcut := &cobra.Command{
+		Use:         use,
+		Short:       fmt.Sprintf("%s (same as 'skyport tunnel %s')", target.Short, target.Name()),
+		Args:        target.Args,
+		Run:         target.Run,
+		Annotations: target.Annotations,
+	}
+	shortcut.Flags().AddFlagSet(target.Flags())
+	shortcut.Flags().AddFlagSet(tunnelCmd.PersistentFlags())
+	return shortcut
+}
+
+// rootTunnelArgs accepts the tunnel named by "skyport <tunnel>". A mistyped
+// command is reported as one, before the connectivity check, rather than
+// looked up as a tunnel.
+func rootTunnelArgs(cmd *cobra.Command, args []string) error {
+	if err := cobra.MaximumNArgs(1)(cmd, args); err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		return nil
+	}
+
+	if suggestions := cmd.SuggestionsFor(args[0]); len(suggestions) > 0 {
+		unknown := apperr.ErrUnknownCommand.Withf("Unknown command '%s'", args[0])
+		unknown.Hint = fmt.Sprintf("Did you mean 'skyport %s'? To run a tunnel with this name, use 'skyport up %s'", suggestions[0], args[0])
+		exitWithError(unknown)
+	}
+	return nil
+}
+
+// runRootTunnel runs the tunnel named by "skyport <tunnel>", as "skyport
+// tunnel run <tunnel>" does, and shows help without one
+func runRootTunnel(cmd *cobra.Command, args []string) {
+	if group, _ := cmd.Flags().GetString("group"); len(args) == 0 && group == "" {
+		cmd.Help()
+		return
+	}
+	runTunnel(cmd, args)
+}
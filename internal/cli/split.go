@@ -0,0 +1,109 @@
+package cli
+
+import (
+	"fmt"
+	"net"
+	"skyport-agent/internal/config"
+	"skyport-agent/internal/logger"
+	"strconv"
+
+	"github.com/spf13/cobra"
+)
+
+var splitCmd = &cobra.Command{
+	Use:   "split [tunnel-name-or-id] [show|enable|disable]",
+	Short: "Send a share of a tunnel's requests to a second local port",
+	Long: `Canary a new build of your local service behind the same public URL: a
+percentage of requests is forwarded to an alternate local port instead of the
+tunnel's target. Those requests, and their responses, carry an
+X-Skyport-Variant: canary header. With --sticky each client IP always goes to
+the same target, so visitors don't flip between builds.
+
+Example:
+  skyport tunnel split myapp enable --port 4001 --percent 10
+  skyport tunnel split myapp enable --target 127.0.0.1:4001 --percent 50 --sticky
+  skyport tunnel split myapp show
+  skyport tunnel split myapp disable`,
+	Args:        cobra.ExactArgs(2),
+	Annotations: map[string]string{offlineAnnotation: "true"},
+	Run:         runSplit,
+}
+
+func init() {
+	splitCmd.Flags().Int("port", 0, "Local port of the alternate service")
+	splitCmd.Flags().String("target", "", "host:port of the alternate service, instead of --port")
+	splitCmd.Flags().Int("percent", 10, "Percentage of requests sent to the alternate service")
+	splitCmd.Flags().Bool("sticky", false, "Always send a client IP to the same service")
+	tunnelCmd.AddCommand(splitCmd)
+}
+
+func runSplit(cmd *cobra.Command, args []string) {
+	configManager := config.NewConfigManager()
+	tunnel := findLocalTunnel(configManager, args[0])
+
+	var split config.SplitSettings
+	if tunnel.Settings != nil {
+		split = tunnel.Settings.Split
+	}
+
+	switch args[1] {
+	case "show":
+		printSplit(tunnel)
+		return
+	case "enable":
+		port, _ := cmd.Flags().GetInt("port")
+		target, _ := cmd.Flags().GetString("target")
+		percent, _ := cmd.Flags().GetInt("percent")
+		sticky, _ := cmd.Flags().GetBool("sticky")
+
+		if (port == 0) == (target == "") {
+			exitWithError(fmt.Errorf("give the alternate service with either --port or --target"))
+		}
+		if port != 0 {
+			target = net.JoinHostPort("localhost", strconv.Itoa(port))
+		}
+		parsed, err := config.ParseTarget(target)
+		if err != nil {
+			exitWithError(fmt.Errorf("invalid target '%s': %w", target, err))
+		}
+		if percent < 0 || percent > 100 {
+			exitWithError(fmt.Errorf("percent must be between 0 and 100"))
+		}
+		if parsed == tunnel.LocalAddress() {
+			fmt.Printf(" %s %s is already the tunnel's target\n", logger.WarningMark(), parsed)
+		}
+		if tunnel.StaticSettings().Dir != "" {
+			fmt.Printf(" %s Tunnel '%s' serves a directory; requests are not split while it does\n", logger.WarningMark(), tunnel.Name)
+		}
+		split = config.SplitSettings{Enabled: true, Target: parsed, Percent: percent, Sticky: sticky}
+	case "disable":
+		split.Enabled = false
+	default:
+		exitWithError(fmt.Errorf("action must be 'show', 'enable' or 'disable'"))
+	}
+
+	if err := configManager.SetTunnelSplit(tunnel.ID, split); err != nil {
+		exitWithError(fmt.Errorf("failed to update traffic split: %w", err))
+	}
+
+	tunnel = findLocalTunnel(configManager, tunnel.ID)
+	printSplit(tunnel)
+	fmt.Println(" Takes effect the next time the tunnel connects.")
+}
+
+// printSplit shows where a tunnel's requests are sent
+func printSplit(tunnel *config.Tunnel) {
+	if tunnel.Settings == nil || !tunnel.Settings.Split.Enabled {
+		fmt.Printf(" Traffic split disabled for tunnel '%s'\n", tunnel.Name)
+		return
+	}
+
+	split := tunnel.Settings.Split
+	mode := "per request"
+	if split.Sticky {
+		mode = "sticky per client IP"
+	}
+	fmt.Printf(" Traffic split for tunnel '%s' (%s):\n", tunnel.Name, mode)
+	fmt.Printf("   %3d%% %s %s\n", 100-split.Percent, logger.Arrow(), tunnel.LocalAddress())
+	fmt.Printf("   %3d%% %s %s (X-Skyport-Variant: canary)\n", split.Percent, logger.Arrow(), split.Target)
+}
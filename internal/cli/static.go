@@ -0,0 +1,105 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"skyport-agent/internal/config"
+
+	"github.com/spf13/cobra"
+)
+
+var httpCmd = &cobra.Command{
+	Use:   "http [directory]",
+	Short: "Serve a local directory through a tunnel",
+	Long: `Share a static build without running a web server: the agent serves files from
+the directory itself, with index.html for directories and content types based on
+file extensions. Dotfiles are never served. The directory is remembered for the
+tunnel (also when run by the daemon) until you run 'skyport tunnel static <name> off'.
+
+Example:
+  skyport http ./dist --tunnel myapp
+  skyport http ./build --tunnel myapp --spa`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		tunnelName, _ := cmd.Flags().GetString("tunnel")
+		if tunnelName == "" {
+			exitWithError(fmt.Errorf("--tunnel is required; run 'skyport tunnel list' to see your tunnels"))
+		}
+
+		static := staticSettingsFromFlags(cmd, args[0])
+		configManager := config.NewConfigManager()
+		tunnel := findLocalTunnel(configManager, tunnelName)
+		if err := configManager.SetTunnelStatic(tunnel.ID, static); err != nil {
+			exitWithError(fmt.Errorf("failed to update tunnel: %w", err))
+		}
+
+		runTunnel(cmd, []string{tunnel.ID})
+	},
+}
+
+var staticCmd = &cobra.Command{
+	Use:   "static [tunnel-name-or-id] [directory|off]",
+	Short: "Serve a local directory instead of forwarding to a port",
+	Long: `Make a tunnel serve files from a local directory instead of forwarding requests
+to its local port, or go back to the port with 'off'. Use 'skyport http' to set
+the directory and start the tunnel in one step.
+
+Example:
+  skyport tunnel static myapp ./dist --spa
+  skyport tunnel static myapp off`,
+	Args:        cobra.ExactArgs(2),
+	Annotations: map[string]string{offlineAnnotation: "true"},
+	Run: func(cmd *cobra.Command, args []string) {
+		configManager := config.NewConfigManager()
+		tunnel := findLocalTunnel(configManager, args[0])
+
+		var static config.StaticSettings
+		if args[1] != "off" {
+			static = staticSettingsFromFlags(cmd, args[1])
+		}
+		if err := configManager.SetTunnelStatic(tunnel.ID, static); err != nil {
+			exitWithError(fmt.Errorf("failed to update tunnel: %w", err))
+		}
+
+		if static.Dir == "" {
+			fmt.Printf(" Tunnel '%s' forwards to %s\n", tunnel.Name, tunnel.LocalAddress())
+		} else {
+			fmt.Printf(" Tunnel '%s' serves %s\n", tunnel.Name, static.Dir)
+			if static.SPA {
+				fmt.Println("   Unknown paths fall back to index.html")
+			}
+		}
+		fmt.Println(" Takes effect the next time the tunnel connects.")
+	},
+}
+
+func init() {
+	for _, cmd := range []*cobra.Command{httpCmd, staticCmd} {
+		cmd.Flags().Bool("spa", false, "Serve index.html for paths without a matching file (client-side routing)")
+		cmd.Flags().Bool("listing", false, "List the contents of directories without an index.html")
+	}
+	httpCmd.Flags().String("tunnel", "", "Tunnel to serve the directory through (name or ID)")
+	httpCmd.Flags().Bool("background", false, "Run tunnel in background")
+	httpCmd.Flags().Bool("qr", false, "Show a QR code of the public URL once connected")
+
+	tunnelCmd.AddCommand(staticCmd)
+}
+
+// staticSettingsFromFlags resolves the directory to serve, exiting if it is
+// not a directory
+func staticSettingsFromFlags(cmd *cobra.Command, dir string) config.StaticSettings {
+	spa, _ := cmd.Flags().GetBool("spa")
+	listing, _ := cmd.Flags().GetBool("listing")
+
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		exitWithError(fmt.Errorf("invalid directory: %w", err))
+	}
+	info, err := os.Stat(absDir)
+	if err != nil || !info.IsDir() {
+		exitWithError(fmt.Errorf("'%s' is not a directory", dir))
+	}
+
+	return config.StaticSettings{Dir: absDir, SPA: spa, Listing: listing}
+}
@@ -2,8 +2,12 @@ package cli
 
 import (
 	"fmt"
+	"skyport-agent/internal/apperr"
 	"skyport-agent/internal/config"
+	"skyport-agent/internal/logger"
 	"skyport-agent/internal/service"
+	"skyport-agent/internal/state"
+	"sort"
 	"strings"
 	"time"
 
@@ -11,18 +15,54 @@ import (
 )
 
 var agentStatusCmd = &cobra.Command{
-	Use:   "status",
+	Use:   "status [--check [condition...]]",
 	Short: "Show SkyPort agent status and health information",
 	Long: `Show comprehensive status information about the SkyPort agent including:
 - Service status
 - Active tunnels
 - Health monitoring
 - Network information
-- System service status`,
-	Run: runAgentStatus,
+- System service status
+
+With --check, only check that tunnels are connected from this machine and
+exit with status 8 if any is not, for cron jobs and monitoring. Each condition
+is 'autostart' (every auto-start tunnel, the default), 'all' (every tunnel)
+or a tunnel name, ID or pattern; all of them must hold. The check reads the
+state published by the processes running the tunnels, so it works offline.
+
+Example:
+  skyport status --check
+  skyport status --check autostart api 'web-*' || notify-send "tunnel down"`,
+	Args: statusArgs,
+	Run:  runAgentStatus,
+}
+
+func init() {
+	agentStatusCmd.Flags().Bool("check", false, "Exit non-zero unless the given tunnels are connected")
+}
+
+// statusArgs accepts conditions only for --check
+func statusArgs(cmd *cobra.Command, args []string) error {
+	if check, _ := cmd.Flags().GetBool("check"); !check && len(args) > 0 {
+		return fmt.Errorf("conditions are only accepted with --check")
+	}
+	return nil
+}
+
+// isStatusCheck reports whether the command is 'skyport status --check',
+// which needs no connection to the server
+func isStatusCheck(cmd *cobra.Command) bool {
+	isAgentStatus := cmd.Name() == "status" && cmd.HasParent() && !cmd.Parent().HasParent()
+	check, _ := cmd.Flags().GetBool("check")
+	return isAgentStatus && check
 }
 
 func runAgentStatus(cmd *cobra.Command, args []string) {
+	if check, _ := cmd.Flags().GetBool("check"); check {
+		runStatusCheck(args)
+		return
+	}
+
 	fmt.Println("SkyPort Agent Status")
 	fmt.Println(strings.Repeat("=", 50))
 
@@ -42,7 +82,11 @@ func runAgentStatus(cmd *cobra.Command, args []string) {
 
 	// Check authentication
 	if manager.IsAuthenticated() {
-		fmt.Println("Authentication: Authenticated")
+		if manager.IsReadOnly() {
+			fmt.Println("Authentication: Authenticated (read-only token)")
+		} else {
+			fmt.Println("Authentication: Authenticated")
+		}
 
 		// Get tunnel list
 		tunnels, err := manager.GetTunnelList()
@@ -61,8 +105,8 @@ func runAgentStatus(cmd *cobra.Command, args []string) {
 					// Find tunnel details
 					for _, tunnel := range tunnels {
 						if tunnel.ID == tunnelID {
-							fmt.Printf("  - %s (%s.%s → localhost:%d)\n",
-								tunnel.Name, tunnel.Subdomain, defaultConfig.TunnelDomain, tunnel.LocalPort)
+							fmt.Printf("  - %s (%s.%s %s %s)\n",
+								tunnel.Name, tunnel.Subdomain, defaultConfig.TunnelDomain, logger.Arrow(), tunnel.LocalTarget())
 							break
 						}
 					}
@@ -116,3 +160,70 @@ func runAgentStatus(cmd *cobra.Command, args []string) {
 
 	fmt.Printf("\nStatus generated at: %s\n", time.Now().Format(time.RFC3339))
 }
+
+// runStatusCheck checks that the tunnels selected by the conditions are
+// connected, listing each one, and exits with ExitUnhealthy if any is not
+func runStatusCheck(conditions []string) {
+	appConfig, err := config.NewConfigManager().LoadConfig()
+	if err != nil {
+		exitWithError(fmt.Errorf("failed to load config: %w", err))
+	}
+	tunnels := make([]*config.Tunnel, 0, len(appConfig.Tunnels))
+	for _, t := range appConfig.Tunnels {
+		tunnels = append(tunnels, t)
+	}
+	sort.Slice(tunnels, func(i, j int) bool { return tunnels[i].Name < tunnels[j].Name })
+
+	if len(conditions) == 0 {
+		conditions = []string{"autostart"}
+	}
+
+	var checked []*config.Tunnel
+	seen := make(map[string]bool)
+	for _, condition := range conditions {
+		var selected []*config.Tunnel
+		switch condition {
+		case "autostart":
+			for _, t := range tunnels {
+				if t.AutoStart {
+					selected = append(selected, t)
+				}
+			}
+		case "all":
+			selected = tunnels
+		default:
+			if selected, err = selectTunnels(tunnels, []string{condition}); err != nil {
+				exitWithError(err)
+			}
+		}
+		for _, t := range selected {
+			if !seen[t.ID] {
+				seen[t.ID] = true
+				checked = append(checked, t)
+			}
+		}
+	}
+
+	if len(checked) == 0 {
+		fmt.Printf(" %s No tunnels to check\n", logger.SuccessMark())
+		return
+	}
+
+	// A state not refreshed for a few heartbeats means the process is gone
+	// or the tunnel disconnected
+	maxAge := 3 * config.Load().GetSettings().Intervals.PingInterval.Duration
+	down := 0
+	for _, t := range checked {
+		ts, err := state.ReadTunnel(t.ID, maxAge)
+		if err != nil {
+			down++
+			fmt.Printf(" %s %s is not connected\n", logger.ErrorMark(), t.Name)
+			continue
+		}
+		fmt.Printf(" %s %s is connected (pid %d, up %s)\n", logger.SuccessMark(), t.Name, ts.PID, time.Since(ts.ConnectedAt).Round(time.Second))
+	}
+
+	if down > 0 {
+		exitWithError(apperr.ErrCheckFailed.Withf("%d of %d tunnels not connected", down, len(checked)))
+	}
+}
@@ -0,0 +1,282 @@
+package cli
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"skyport-agent/internal/config"
+	"skyport-agent/internal/crash"
+	"skyport-agent/internal/logger"
+	"skyport-agent/internal/network"
+	"skyport-agent/internal/service"
+	"skyport-agent/internal/state"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var supportCmd = &cobra.Command{
+	Use:         "support",
+	Short:       "Collect diagnostics for bug reports",
+	Annotations: map[string]string{offlineAnnotation: "true"},
+}
+
+var supportBundleCmd = &cobra.Command{
+	Use:   "bundle",
+	Short: "Collect crash reports and diagnostics into a tarball",
+	Long: `Collect diagnostics into a .tar.gz file that can be attached to a bug report:
+the agent version and OS, a configuration summary, recent logs of background
+tunnels and the system service, the service status, the results of
+connectivity tests and any crash reports. Tokens, passwords and other
+secrets are redacted.
+
+Example:
+  skyport support bundle
+  skyport support bundle --output /tmp/skyport-support.tar.gz`,
+	Run: runSupportBundle,
+}
+
+var supportBundleOutput string
+
+func init() {
+	supportBundleCmd.Flags().StringVarP(&supportBundleOutput, "output", "o", "", "Path of the bundle to write (default: ./skyport-support-<timestamp>.tar.gz)")
+	supportCmd.AddCommand(supportBundleCmd)
+}
+
+func runSupportBundle(cmd *cobra.Command, args []string) {
+	output := supportBundleOutput
+	if output == "" {
+		output = fmt.Sprintf("skyport-support-%s.tar.gz", time.Now().Format("20060102-150405"))
+	}
+
+	bundle, err := newSupportBundle(output)
+	if err != nil {
+		exitWithError(fmt.Errorf("failed to create bundle: %w", err))
+	}
+
+	bundle.addFile("system.txt", supportSystemInfo())
+	bundle.addFile("config-summary.json", []byte(crash.ConfigSummary()))
+	bundle.addFile("service-status.txt", supportServiceStatus())
+
+	checking := startProgress("Testing connectivity")
+	bundle.addFile("connectivity.txt", supportConnectivity(config.Load()))
+	checking.Stop()
+
+	logs := 0
+	if processes, err := state.BackgroundProcesses(); err == nil {
+		for _, p := range processes {
+			if p.LogFile == "" {
+				continue
+			}
+			if data, err := readTail(p.LogFile, supportLogBytes); err == nil {
+				bundle.addFile(filepath.Join("logs", filepath.Base(p.LogFile)), []byte(logger.Redact(string(data))))
+				logs++
+			}
+		}
+	}
+	if systemdService := service.NewSystemdService(); systemdService.IsInstalled() {
+		if output, err := systemdService.GetLogs(supportServiceLogLines); err == nil {
+			bundle.addFile(filepath.Join("logs", "service.log"), []byte(logger.Redact(output)))
+			logs++
+		}
+	}
+
+	reports := 0
+	if dir, err := crash.ReportsDir(); err == nil {
+		entries, _ := os.ReadDir(dir)
+		for _, entry := range entries {
+			if entry.IsDir() {
+				continue
+			}
+			data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+			if err != nil {
+				continue
+			}
+			bundle.addFile(filepath.Join("crash", entry.Name()), data)
+			reports++
+		}
+	}
+
+	if err := bundle.close(); err != nil {
+		exitWithError(fmt.Errorf("failed to write bundle: %w", err))
+	}
+
+	fmt.Printf(" %s Support bundle written to %s\n", logger.SuccessMark(), output)
+	fmt.Printf("   Log files included: %d\n", logs)
+	fmt.Printf("   Crash reports included: %d\n", reports)
+	fmt.Println(" Secrets are redacted, but please review the bundle before sharing it")
+}
+
+const (
+	// supportLogBytes is how much of the end of each log file is included
+	supportLogBytes = 256 << 10
+	// supportServiceLogLines is how many lines of the service journal are included
+	supportServiceLogLines = 1000
+)
+
+// supportSystemInfo describes the agent build and the machine it runs on
+func supportSystemInfo() []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Generated:  %s\n", time.Now().Format(time.RFC3339))
+	fmt.Fprintf(&b, "Version:    %s\n", version)
+	fmt.Fprintf(&b, "Go:         %s\n", runtime.Version())
+	fmt.Fprintf(&b, "Platform:   %s/%s\n", runtime.GOOS, runtime.GOARCH)
+	fmt.Fprintf(&b, "CPUs:       %d\n", runtime.NumCPU())
+	fmt.Fprintf(&b, "Debug mode: %t\n", config.IsDebugMode())
+
+	// The distribution and release, where the OS describes itself
+	if data, err := os.ReadFile("/etc/os-release"); err == nil {
+		for _, line := range strings.Split(string(data), "\n") {
+			if value, ok := strings.CutPrefix(line, "PRETTY_NAME="); ok {
+				fmt.Fprintf(&b, "OS:         %s\n", strings.Trim(value, `"`))
+			}
+		}
+	}
+	if output, err := exec.Command("uname", "-srm").Output(); err == nil {
+		fmt.Fprintf(&b, "Kernel:     %s\n", strings.TrimSpace(string(output)))
+	}
+
+	// Whether a proxy is configured matters for connectivity; its address may
+	// carry credentials, so only its presence is recorded
+	for _, name := range []string{"HTTPS_PROXY", "HTTP_PROXY", "NO_PROXY"} {
+		if os.Getenv(name) != "" || os.Getenv(strings.ToLower(name)) != "" {
+			fmt.Fprintf(&b, "%-11s set\n", name+":")
+		}
+	}
+	return []byte(b.String())
+}
+
+// supportServiceStatus reports whether the system service is installed and running
+func supportServiceStatus() []byte {
+	var b strings.Builder
+	systemdService := service.NewSystemdService()
+	if !systemdService.IsInstalled() {
+		b.WriteString("Service: not installed\n")
+	} else {
+		status, _ := systemdService.Status()
+		fmt.Fprintf(&b, "Service: installed, %s\n", status)
+	}
+
+	if processes, err := state.BackgroundProcesses(); err == nil {
+		fmt.Fprintf(&b, "Background tunnel processes: %d\n", len(processes))
+		for _, p := range processes {
+			fmt.Fprintf(&b, "  pid %d: %s (started %s)\n", p.PID, p.TunnelName, p.StartedAt.Format(time.RFC3339))
+		}
+	}
+	return []byte(b.String())
+}
+
+// supportConnectivity runs the connectivity checks the agent relies on and
+// records each result with how long it took
+func supportConnectivity(cfg *config.Config) []byte {
+	var b strings.Builder
+	result := func(name string, start time.Time, err error) {
+		elapsed := time.Since(start).Round(time.Millisecond)
+		if err != nil {
+			fmt.Fprintf(&b, "FAIL  %-40s %8s  %s\n", name, elapsed, logger.Redact(err.Error()))
+		} else {
+			fmt.Fprintf(&b, "OK    %-40s %8s\n", name, elapsed)
+		}
+	}
+
+	for _, target := range cfg.ConnectivityTargets() {
+		start := time.Now()
+		result("target "+target, start, network.CheckTargets([]string{target}, 3*time.Second))
+	}
+
+	if serverURL, err := url.Parse(cfg.ServerURL); err == nil && serverURL.Hostname() != "" {
+		start := time.Now()
+		_, err := net.DefaultResolver.LookupHost(context.Background(), serverURL.Hostname())
+		result("DNS "+serverURL.Hostname(), start, err)
+	}
+
+	start := time.Now()
+	resp, err := cfg.HTTPClient(10 * time.Second).Get(cfg.ServerURL)
+	if err == nil {
+		resp.Body.Close()
+	}
+	result("HTTP "+cfg.ServerURL, start, err)
+	if err == nil {
+		fmt.Fprintf(&b, "      server answered %s\n", resp.Status)
+	}
+
+	return []byte(b.String())
+}
+
+// readTail returns up to maxBytes from the end of a file
+func readTail(path string, maxBytes int64) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() > maxBytes {
+		if _, err := file.Seek(-maxBytes, io.SeekEnd); err != nil {
+			return nil, err
+		}
+	}
+	return io.ReadAll(file)
+}
+
+// supportBundle writes files into a gzip-compressed tarball
+type supportBundle struct {
+	file *os.File
+	gz   *gzip.Writer
+	tw   *tar.Writer
+	err  error
+}
+
+func newSupportBundle(path string) (*supportBundle, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	gz := gzip.NewWriter(file)
+	return &supportBundle{file: file, gz: gz, tw: tar.NewWriter(gz)}, nil
+}
+
+// addFile adds a file to the bundle; the first error is reported by close
+func (b *supportBundle) addFile(name string, data []byte) {
+	if b.err != nil {
+		return
+	}
+
+	header := &tar.Header{
+		Name:    filepath.ToSlash(filepath.Join("skyport-support", name)),
+		Mode:    0600,
+		Size:    int64(len(data)),
+		ModTime: time.Now(),
+	}
+	if b.err = b.tw.WriteHeader(header); b.err != nil {
+		return
+	}
+	_, b.err = b.tw.Write(data)
+}
+
+func (b *supportBundle) close() error {
+	if err := b.tw.Close(); err != nil && b.err == nil {
+		b.err = err
+	}
+	if err := b.gz.Close(); err != nil && b.err == nil {
+		b.err = err
+	}
+	if err := b.file.Close(); err != nil && b.err == nil {
+		b.err = err
+	}
+	return b.err
+}
@@ -0,0 +1,134 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"skyport-agent/internal/apperr"
+	"skyport-agent/internal/config"
+	"skyport-agent/internal/control"
+	"skyport-agent/internal/localnet"
+	"skyport-agent/internal/logger"
+	"skyport-agent/internal/tunnel"
+	"strconv"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var switchCmd = &cobra.Command{
+	Use:   "switch [tunnel-name-or-id]",
+	Short: "Point a running tunnel at another local port without dropping requests",
+	Long: `Blue/green deploys for a local service: start the new build on another port,
+then switch the tunnel to it. The running tunnel sends new requests to the new
+port at once, and the command returns when the requests still in flight to the
+old port have finished (or --drain-timeout passes), so the old build can then
+be stopped. Open WebSocket connections stay on the old port until they close.
+
+The new target is also saved as the tunnel's target, so it is kept when the
+tunnel reconnects or is started again. If the tunnel is not running, only the
+setting is changed.
+
+Example:
+  skyport tunnel switch myapp --port 4001
+  skyport tunnel switch myapp --target 127.0.0.1:4001 --drain-timeout 1m`,
+	Args:        cobra.ExactArgs(1),
+	Annotations: map[string]string{offlineAnnotation: "true"},
+	Run:         runSwitch,
+}
+
+func init() {
+	switchCmd.Flags().Int("port", 0, "Local port to switch to")
+	switchCmd.Flags().String("target", "", "host:port to switch to, instead of --port")
+	switchCmd.Flags().Duration("drain-timeout", 30*time.Second, "Maximum time to wait for requests in flight to the old port")
+	switchCmd.Flags().Bool("force", false, "Switch even if nothing is listening on the new target")
+	tunnelCmd.AddCommand(switchCmd)
+}
+
+func runSwitch(cmd *cobra.Command, args []string) {
+	port, _ := cmd.Flags().GetInt("port")
+	target, _ := cmd.Flags().GetString("target")
+	drainTimeout, _ := cmd.Flags().GetDuration("drain-timeout")
+	force, _ := cmd.Flags().GetBool("force")
+
+	if (port == 0) == (target == "") {
+		exitWithError(fmt.Errorf("give the new target with either --port or --target"))
+	}
+	if port != 0 {
+		target = net.JoinHostPort("localhost", strconv.Itoa(port))
+	}
+	parsed, err := config.ParseTarget(target)
+	if err != nil {
+		exitWithError(fmt.Errorf("invalid target '%s': %w", target, err))
+	}
+
+	configManager := config.NewConfigManager()
+	t := findLocalTunnel(configManager, args[0])
+	if t.StaticSettings().Dir != "" {
+		exitWithError(fmt.Errorf("tunnel '%s' serves a directory, not a local port", t.Name))
+	}
+
+	// Switching to a port nothing listens on would fail every request
+	if !force {
+		conn, err := localnet.Dial("tcp", parsed, 2*time.Second)
+		if err != nil {
+			closed := apperr.ErrLocalPortClosed.Withf("Nothing is listening on %s", parsed).Wrap(err)
+			closed.Hint = "Start the new service first, or pass --force"
+			exitWithError(closed)
+		}
+		conn.Close()
+	}
+
+	result, err := switchRunningTunnel(t.ID, parsed, drainTimeout)
+	if err != nil {
+		exitWithError(err)
+	}
+
+	// The tunnel's own port is stored as the default target, which tries both
+	// 127.0.0.1 and ::1
+	saved := parsed
+	if port == t.LocalPort {
+		saved = ""
+	}
+	if err := configManager.SetTunnelTarget(t.ID, saved); err != nil {
+		exitWithError(fmt.Errorf("failed to update tunnel target: %w", err))
+	}
+
+	if result == nil {
+		t = findLocalTunnel(configManager, t.ID)
+		fmt.Printf(" Tunnel '%s' is not running on this machine; it will forward to %s when it connects\n", t.Name, t.LocalAddress())
+		return
+	}
+
+	fmt.Printf(" %s Tunnel '%s' switched: %s %s %s\n", logger.SuccessMark(), t.Name, result.Previous, logger.Arrow(), result.Target)
+	switch {
+	case result.TimedOut:
+		fmt.Printf(" %s Requests to %s were still in flight after %s\n", logger.WarningMark(), result.Previous, drainTimeout)
+	case result.Drained == 0:
+		fmt.Printf("   No requests were in flight to %s; it can be stopped.\n", result.Previous)
+	default:
+		fmt.Printf("   Drained %d requests in %s; %s can be stopped.\n", result.Drained, result.DrainTime.Round(time.Millisecond), result.Previous)
+	}
+}
+
+// switchRunningTunnel asks the process running the tunnel on this machine to
+// switch its target. Returns nil if no process is running it.
+func switchRunningTunnel(tunnelID, target string, drainTimeout time.Duration) (*tunnel.TargetSwitch, error) {
+	request := control.SwitchRequest{
+		TunnelID:     tunnelID,
+		Target:       target,
+		DrainTimeout: config.Duration{Duration: drainTimeout},
+	}
+	for _, socket := range control.Sockets() {
+		var result tunnel.TargetSwitch
+		err := control.Post(socket, "/v1/switch", request, &result)
+		if errors.Is(err, control.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to switch the running tunnel: %w", err)
+		}
+		return &result, nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,156 @@
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"skyport-agent/internal/apperr"
+	"skyport-agent/internal/config"
+	"skyport-agent/internal/logger"
+	"skyport-agent/internal/usagestats"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+var telemetryCmd = &cobra.Command{
+	Use:   "telemetry",
+	Short: "Manage anonymous usage reports",
+	Long: `Manage anonymous usage reports, which are off unless you turn them on.
+
+When on, the agent reports which commands are run, the names of the flags
+given (never their values), error categories, exit statuses and durations,
+with the agent version and platform. Arguments, tunnel names, hostnames,
+paths and error messages are never included. Events are queued in
+~/.skyport and sent to the SkyPort server at most once a day.
+
+Setting DO_NOT_TRACK or SKYPORT_NO_TELEMETRY turns reports off regardless.
+
+This is unrelated to OpenTelemetry export, which is configured under
+"settings.telemetry" in ~/.skyport/skyport.json.`,
+	Annotations: map[string]string{offlineAnnotation: "true"},
+}
+
+var telemetryOnCmd = &cobra.Command{
+	Use:   "on",
+	Short: "Turn anonymous usage reports on",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := config.NewConfigManager().SetUsageReports(true); err != nil {
+			exitWithError(err)
+		}
+		fmt.Printf(" %s Anonymous usage reports are on. Thank you!\n", logger.SuccessMark())
+		fmt.Println(" Use 'skyport telemetry show-last' to see what is sent")
+	},
+}
+
+var telemetryOffCmd = &cobra.Command{
+	Use:   "off",
+	Short: "Turn anonymous usage reports off and discard queued events",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := config.NewConfigManager().SetUsageReports(false); err != nil {
+			exitWithError(err)
+		}
+		usagestats.Discard()
+		fmt.Printf(" %s Anonymous usage reports are off\n", logger.SuccessMark())
+	},
+}
+
+var telemetryStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show whether anonymous usage reports are on",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		settings := config.NewConfigManager().GetUsageReports()
+		switch {
+		case settings.Enabled && !usagestats.Enabled():
+			fmt.Println(" Usage reports: off (DO_NOT_TRACK or SKYPORT_NO_TELEMETRY is set)")
+		case settings.Enabled:
+			fmt.Println(" Usage reports: on")
+			fmt.Printf(" Install ID:    %s\n", settings.InstallID)
+		default:
+			fmt.Println(" Usage reports: off")
+			fmt.Println(" Turn them on with 'skyport telemetry on'")
+			return
+		}
+
+		fmt.Printf(" Queued events: %d\n", len(usagestats.Queued()))
+		if last, err := usagestats.Last(); err == nil && last != nil {
+			fmt.Printf(" Last sent:     %s (%s ago)\n", last.SentAt.Format("2006-01-02 15:04:05"), time.Since(last.SentAt).Round(time.Second))
+		} else {
+			fmt.Println(" Last sent:     never")
+		}
+	},
+}
+
+var telemetryShowLastCmd = &cobra.Command{
+	Use:   "show-last",
+	Short: "Show the last usage report sent, exactly as it was sent",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		last, err := usagestats.Last()
+		if err != nil {
+			exitWithError(fmt.Errorf("failed to read the last usage report: %w", err))
+		}
+		if last == nil {
+			fmt.Println(" No usage report has been sent")
+		} else {
+			fmt.Printf(" Sent %s:\n\n", last.SentAt.Format("2006-01-02 15:04:05"))
+			data, _ := json.MarshalIndent(last.Report, "", "  ")
+			fmt.Println(string(data))
+		}
+
+		if queued := usagestats.Queued(); len(queued) > 0 {
+			fmt.Printf("\n %d event(s) queued for the next report:\n\n", len(queued))
+			data, _ := json.MarshalIndent(queued, "", "  ")
+			fmt.Println(string(data))
+		}
+	},
+}
+
+func init() {
+	telemetryCmd.AddCommand(telemetryOnCmd)
+	telemetryCmd.AddCommand(telemetryOffCmd)
+	telemetryCmd.AddCommand(telemetryStatusCmd)
+	telemetryCmd.AddCommand(telemetryShowLastCmd)
+}
+
+var (
+	// runningCommand and commandStarted describe the command being run, for
+	// its usage event
+	runningCommand *cobra.Command
+	commandStarted time.Time
+)
+
+// recordUsage queues a usage event for the command being run, if the user
+// opted in to usage reports. err is the error the command failed with, if any.
+func recordUsage(err error, exitCode int) {
+	if runningCommand == nil {
+		return
+	}
+	cmd := runningCommand
+	runningCommand = nil // Only the first outcome counts
+
+	event := usagestats.Event{
+		Command:  strings.TrimPrefix(strings.TrimPrefix(cmd.CommandPath(), cmd.Root().Name()), " "),
+		ExitCode: exitCode,
+		Duration: time.Since(commandStarted).Milliseconds(),
+		Day:      commandStarted.UTC().Format("2006-01-02"),
+	}
+	cmd.Flags().Visit(func(flag *pflag.Flag) {
+		event.Flags = append(event.Flags, flag.Name)
+	})
+	if err != nil {
+		// Only the category is reported; messages may contain names and paths
+		event.Error = "other"
+		var appErr *apperr.Error
+		if errors.As(err, &appErr) && appErr.Category != "" {
+			event.Error = appErr.Category
+		}
+	}
+
+	usagestats.Record(event)
+}
@@ -0,0 +1,257 @@
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"skyport-agent/internal/apperr"
+	"skyport-agent/internal/auth"
+	"skyport-agent/internal/config"
+	"skyport-agent/internal/logger"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// subdomainPattern matches the subdomains tunnels can be created with
+var subdomainPattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+var createCmd = &cobra.Command{
+	Use:   "create [name]",
+	Short: "Create a tunnel, optionally from a template",
+	Long: `Create a tunnel on the SkyPort server. With --from-template it gets the
+template's local port, auto-start and local settings (access control, header
+rules, CORS, cache and so on), so similar tunnels do not have to be set up one
+option at a time. The subdomain defaults to the tunnel's name. With --ttl the
+tunnel is stopped that long after it is created, and with --delete-on-expiry
+also deleted.
+
+Example:
+  skyport tunnel create shop-frontend --from-template react-dev
+  skyport tunnel create api-v2 --port 8081 --subdomain api-v2-staging
+  skyport tunnel create demo --port 3000 --ttl 1h --delete-on-expiry`,
+	Args: cobra.ExactArgs(1),
+	Run:  runCreate,
+}
+
+var templateCmd = &cobra.Command{
+	Use:   "template",
+	Short: "Manage templates new tunnels can be created from",
+	Long: `Manage tunnel templates, kept in the local config under "templates". A
+template holds a local port, auto-start and the tunnel's local settings; save
+one from a tunnel you have set up, or write it in ~/.skyport/skyport.json.
+
+Example:
+  skyport tunnel template save react-dev --from shop
+  skyport tunnel create blog --from-template react-dev
+  skyport tunnel template apply react-dev docs`,
+	Annotations: map[string]string{offlineAnnotation: "true"},
+}
+
+var templateSaveCmd = &cobra.Command{
+	Use:   "save <template> --from <tunnel-name-or-id>",
+	Short: "Save a tunnel's port, auto-start and local settings as a template",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		from, _ := cmd.Flags().GetString("from")
+		if from == "" {
+			exitWithError(fmt.Errorf("usage: skyport tunnel template save %s --from <tunnel-name-or-id>", args[0]))
+		}
+
+		configManager := config.NewConfigManager()
+		tunnel := findLocalTunnel(configManager, from)
+		template, err := config.TemplateFrom(tunnel)
+		if err != nil {
+			exitWithError(fmt.Errorf("failed to copy tunnel settings: %w", err))
+		}
+		if err := configManager.SetTemplate(args[0], template); err != nil {
+			exitWithError(err)
+		}
+		fmt.Printf(" %s Saved template '%s' from tunnel '%s'\n", logger.SuccessMark(), args[0], tunnel.Name)
+	},
+}
+
+var templateListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List tunnel templates",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		configManager := config.NewConfigManager()
+		names, err := configManager.TemplateNames()
+		if err != nil {
+			exitWithError(fmt.Errorf("failed to load config: %w", err))
+		}
+		if len(names) == 0 {
+			fmt.Println(" No tunnel templates. Save one with 'skyport tunnel template save <template> --from <tunnel>'")
+			return
+		}
+
+		for _, name := range names {
+			template, err := configManager.Template(name)
+			if err != nil {
+				continue
+			}
+			fmt.Printf(" %s: %s\n", name, describeTemplate(template))
+		}
+	},
+}
+
+var templateShowCmd = &cobra.Command{
+	Use:   "show <template>",
+	Short: "Print a template as JSON",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		template, err := config.NewConfigManager().Template(args[0])
+		if err != nil {
+			exitWithError(err)
+		}
+		data, _ := json.MarshalIndent(template, "", "  ")
+		fmt.Println(string(data))
+	},
+}
+
+var templateApplyCmd = &cobra.Command{
+	Use:   "apply <template> <tunnel-name-or-id>",
+	Short: "Replace an existing tunnel's auto-start and local settings with a template's",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		configManager := config.NewConfigManager()
+		tunnel := findLocalTunnel(configManager, args[1])
+		if err := configManager.ApplyTemplate(tunnel.ID, args[0]); err != nil {
+			exitWithError(err)
+		}
+		fmt.Printf(" %s Applied template '%s' to tunnel '%s'\n", logger.SuccessMark(), args[0], tunnel.Name)
+		fmt.Println(" Takes effect the next time the tunnel connects.")
+	},
+}
+
+var templateDeleteCmd = &cobra.Command{
+	Use:   "delete <template>",
+	Short: "Delete a template (tunnels created from it are kept)",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		configManager := config.NewConfigManager()
+		if _, err := configManager.Template(args[0]); err != nil {
+			exitWithError(err)
+		}
+		if err := configManager.SetTemplate(args[0], nil); err != nil {
+			exitWithError(fmt.Errorf("failed to delete template: %w", err))
+		}
+		fmt.Printf(" %s Deleted template '%s'\n", logger.SuccessMark(), args[0])
+	},
+}
+
+func init() {
+	createCmd.Flags().String("from-template", "", "Template to take the local port, auto-start and settings from")
+	createCmd.Flags().String("subdomain", "", "Subdomain the tunnel is served under (default: the tunnel's name)")
+	createCmd.Flags().Int("port", 0, "Local port to forward to (default: the template's)")
+	addTTLFlags(createCmd)
+	tunnelCmd.AddCommand(createCmd)
+
+	templateSaveCmd.Flags().String("from", "", "Tunnel to make the template from")
+	templateCmd.AddCommand(templateSaveCmd)
+	templateCmd.AddCommand(templateListCmd)
+	templateCmd.AddCommand(templateShowCmd)
+	templateCmd.AddCommand(templateApplyCmd)
+	templateCmd.AddCommand(templateDeleteCmd)
+	tunnelCmd.AddCommand(templateCmd)
+}
+
+func runCreate(cmd *cobra.Command, args []string) {
+	name := args[0]
+	templateName, _ := cmd.Flags().GetString("from-template")
+	subdomain, _ := cmd.Flags().GetString("subdomain")
+	port, _ := cmd.Flags().GetInt("port")
+	expiry := ttlExpiry(cmd)
+
+	configManager := config.NewConfigManager()
+	template := &config.TunnelTemplate{}
+	if templateName != "" {
+		var err error
+		if template, err = configManager.Template(templateName); err != nil {
+			notFound := apperr.ErrTunnelNotFound.Withf("Tunnel template '%s' not found", templateName)
+			notFound.Hint = "Use 'skyport tunnel template list' to see your templates"
+			exitWithError(notFound)
+		}
+	}
+
+	if port == 0 {
+		port = template.LocalPort
+	}
+	if port < 1 || port > 65535 {
+		exitWithError(fmt.Errorf("set the local port with --port, or use a template that has one"))
+	}
+	if subdomain == "" {
+		subdomain = strings.ToLower(name)
+	}
+	if !subdomainPattern.MatchString(subdomain) {
+		exitWithError(fmt.Errorf("invalid subdomain '%s': use lowercase letters, digits and dashes (set one with --subdomain)", subdomain))
+	}
+
+	defaultConfig := config.Load()
+	authManager := auth.NewAuthManager(defaultConfig)
+	if !authManager.IsAuthenticated() {
+		exitWithError(apperr.ErrNotAuthenticated)
+	}
+	token, err := authManager.GetValidToken()
+	if err != nil {
+		exitWithError(explainError(err, apperr.ErrSessionExpired))
+	}
+	refuseReadOnly(token, "create tunnels")
+
+	creating := startProgress(fmt.Sprintf("Creating tunnel %s", name))
+	tunnel, err := authManager.CreateTunnel(token, name, subdomain, port)
+	if err != nil {
+		creating.Stop()
+		if errors.Is(err, auth.ErrSubdomainTaken) {
+			taken := apperr.ErrSubdomainTaken.Withf("Subdomain '%s' is already taken", subdomain).Wrap(err)
+			taken.Hint = "Choose another one with --subdomain"
+			exitWithError(taken)
+		}
+		exitWithError(explainError(err, apperr.ErrStartFailed.Withf("Failed to create tunnel '%s'", name)))
+	}
+
+	if err := template.Apply(tunnel); err != nil {
+		exitWithError(fmt.Errorf("failed to copy template settings: %w", err))
+	}
+	if expiry != nil {
+		if tunnel.Settings == nil {
+			tunnel.Settings = &config.TunnelSettings{}
+		}
+		tunnel.Settings.Expiry = expiry
+	}
+	if err := configManager.AddTunnel(tunnel); err != nil {
+		exitWithError(fmt.Errorf("failed to save tunnel: %w", err))
+	}
+	// The new tunnel must show up in the next list
+	configManager.InvalidateTunnelCache()
+
+	creating.Done(fmt.Sprintf("Created tunnel '%s' (%s.%s %s %s)",
+		tunnel.Name, tunnel.Subdomain, defaultConfig.TunnelDomain, logger.Arrow(), tunnel.LocalTarget()))
+	if templateName != "" {
+		fmt.Printf(" Settings from template '%s': %s\n", templateName, describeTemplate(template))
+	}
+	if expiry != nil {
+		fmt.Printf(" Tunnel expires %s\n", describeExpiry(tunnel))
+	}
+	fmt.Printf(" Start it with 'skyport tunnel run %s'\n", tunnel.Name)
+}
+
+// describeTemplate summarizes what a template sets
+func describeTemplate(template *config.TunnelTemplate) string {
+	var parts []string
+	if template.LocalPort != 0 {
+		parts = append(parts, fmt.Sprintf("port %d", template.LocalPort))
+	}
+	if template.AutoStart {
+		parts = append(parts, "auto-start")
+	}
+	if template.Settings != nil {
+		parts = append(parts, "local settings")
+	}
+	if len(parts) == 0 {
+		return "no options"
+	}
+	return strings.Join(parts, ", ")
+}
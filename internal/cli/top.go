@@ -0,0 +1,144 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"skyport-agent/internal/control"
+	"skyport-agent/internal/logger"
+	"skyport-agent/internal/tunnel"
+	"skyport-agent/internal/usage"
+	"sort"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var topCmd = &cobra.Command{
+	Use:   "top",
+	Short: "Live view of tunnel traffic",
+	Long: `Show a live, top-like view of request rate, in-flight requests, bandwidth
+and errors for every tunnel running on this machine (daemon or 'tunnel run').
+Press Ctrl+C to exit.`,
+	Args:        cobra.NoArgs,
+	Annotations: map[string]string{offlineAnnotation: "true"},
+	Run:         runTop,
+}
+
+func init() {
+	topCmd.Flags().Duration("interval", 2*time.Second, "Refresh interval")
+	tunnelCmd.AddCommand(topCmd)
+}
+
+func runTop(cmd *cobra.Command, args []string) {
+	interval, _ := cmd.Flags().GetDuration("interval")
+	if interval < 500*time.Millisecond {
+		interval = 500 * time.Millisecond
+	}
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	previous := make(map[string]tunnel.TrafficStats)
+	previousAt := time.Time{}
+
+	for {
+		current := collectTrafficStats()
+		now := time.Now()
+		renderTop(current, previous, now.Sub(previousAt))
+
+		previous = make(map[string]tunnel.TrafficStats, len(current))
+		for _, stats := range current {
+			previous[stats.TunnelID] = stats
+		}
+		previousAt = now
+
+		select {
+		case <-sigChan:
+			fmt.Println()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// collectTrafficStats gathers stats from every process serving a control socket
+func collectTrafficStats() []tunnel.TrafficStats {
+	var all []tunnel.TrafficStats
+	for _, socket := range control.Sockets() {
+		var stats []tunnel.TrafficStats
+		if err := control.Get(socket, "/v1/stats", &stats); err != nil {
+			continue
+		}
+		all = append(all, stats...)
+	}
+
+	sort.Slice(all, func(i, j int) bool { return all[i].TunnelName < all[j].TunnelName })
+	return all
+}
+
+// renderTop redraws the screen. Rates are computed against the previous
+// sample, so the first frame shows totals only.
+func renderTop(current []tunnel.TrafficStats, previous map[string]tunnel.TrafficStats, elapsed time.Duration) {
+	// Clear the screen and move the cursor home. Piped output gets one
+	// frame after another instead of escape codes.
+	if logger.IsTerminal(os.Stdout) {
+		fmt.Print("\033[H\033[2J")
+	} else {
+		fmt.Println()
+	}
+	fmt.Printf("SkyPort tunnel top - %s\n\n", time.Now().Format("15:04:05"))
+
+	if len(current) == 0 {
+		fmt.Println(" No running tunnels found on this machine.")
+		fmt.Println(" Start one with 'skyport tunnel run <name>' or the daemon.")
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "TUNNEL\tSTATUS\tREQ/S\tIN-FLIGHT\tIN/S\tOUT/S\tREQUESTS\t5xx (15m)\tFORWARD p95\tRTT avg\tQUALITY")
+
+	for _, stats := range current {
+		reqRate, inRate, outRate := "-", "-", "-"
+		if prev, ok := previous[stats.TunnelID]; ok && elapsed > 0 {
+			seconds := elapsed.Seconds()
+			reqRate = fmt.Sprintf("%.1f", float64(stats.Requests-prev.Requests)/seconds)
+			inRate = usage.FormatBytes(int64(float64(stats.BytesIn-prev.BytesIn)/seconds)) + "/s"
+			outRate = usage.FormatBytes(int64(float64(stats.BytesOut-prev.BytesOut)/seconds)) + "/s"
+		}
+
+		status := "connected"
+		if !stats.Connected {
+			status = "down"
+		}
+
+		p95 := "-"
+		if stats.Forwarding.Count > 0 {
+			p95 = stats.Forwarding.P95.Round(100 * time.Microsecond).String()
+		}
+
+		rtt := "-"
+		if stats.RoundTrip.Count > 0 {
+			rtt = stats.RoundTrip.Mean.Round(100 * time.Microsecond).String()
+		}
+
+		quality := "-"
+		if stats.Connected {
+			quality = stats.Quality.String()
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%s\t%d\t%s\t%s\t%s\t%s\n",
+			stats.TunnelName, status, reqRate, stats.InFlight, inRate, outRate,
+			stats.Requests, formatErrorRate(stats.Errors), p95, rtt, quality)
+	}
+	w.Flush()
+
+	fmt.Printf("\n%s\n", strings.Repeat("-", 40))
+	fmt.Println(" Press Ctrl+C to exit")
+}
@@ -0,0 +1,85 @@
+package cli
+
+import (
+	"fmt"
+	"skyport-agent/internal/config"
+	"skyport-agent/internal/logger"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// addTTLFlags adds the flags that give a tunnel a limited lifetime
+func addTTLFlags(cmd *cobra.Command) {
+	cmd.Flags().Duration("ttl", 0, "Stop the tunnel after this long, e.g. 1h (at least 1m)")
+	cmd.Flags().Bool("delete-on-expiry", false, "With --ttl, also delete the tunnel from the server when it expires")
+}
+
+// ttlExpiry returns the expiry set with --ttl, or nil without it
+func ttlExpiry(cmd *cobra.Command) *config.ExpirySettings {
+	ttl, _ := cmd.Flags().GetDuration("ttl")
+	deleteOnExpiry, _ := cmd.Flags().GetBool("delete-on-expiry")
+	if !cmd.Flags().Changed("ttl") {
+		if deleteOnExpiry {
+			exitWithError(fmt.Errorf("--delete-on-expiry needs --ttl"))
+		}
+		return nil
+	}
+	if ttl < time.Minute {
+		exitWithError(fmt.Errorf("invalid --ttl %v: use a duration of at least 1m, such as 30m or 2h", ttl))
+	}
+	return &config.ExpirySettings{At: time.Now().Add(ttl), Delete: deleteOnExpiry}
+}
+
+// applyTTL sets a tunnel's expiry from --ttl before it is started. Without
+// --ttl, a tunnel whose expiry has passed is started again without one.
+func applyTTL(cmd *cobra.Command, t *config.Tunnel) {
+	expiry := ttlExpiry(cmd)
+	if expiry == nil && !t.Expired() {
+		return
+	}
+	if expiry == nil {
+		fmt.Printf(" %s Tunnel '%s' expired at %s; starting it without a time limit\n",
+			logger.WarningMark(), t.Name, t.ExpirySettings().At.Local().Format("2006-01-02 15:04"))
+	}
+
+	if err := config.NewConfigManager().SetTunnelExpiry(t.ID, expiry); err != nil {
+		exitWithError(fmt.Errorf("failed to update tunnel expiry: %w", err))
+	}
+	if t.Settings == nil {
+		t.Settings = &config.TunnelSettings{}
+	}
+	t.Settings.Expiry = expiry
+}
+
+// describeExpiry says when a tunnel expires, for display
+func describeExpiry(t *config.Tunnel) string {
+	expiry := t.ExpirySettings()
+	if expiry.At.IsZero() {
+		return "never"
+	}
+
+	var when string
+	if t.Expired() {
+		when = fmt.Sprintf("expired at %s", expiry.At.Local().Format("2006-01-02 15:04"))
+	} else {
+		when = fmt.Sprintf("in %s (at %s)", time.Until(expiry.At).Round(time.Second), expiry.At.Local().Format("15:04"))
+	}
+	if expiry.Delete {
+		when += ", then deleted"
+	}
+	return when
+}
+
+// expiryCountdown is the time left before a tunnel expires, for tables
+func expiryCountdown(t *config.Tunnel) string {
+	expiry := t.ExpirySettings()
+	switch {
+	case expiry.At.IsZero():
+		return "-"
+	case t.Expired():
+		return "expired"
+	default:
+		return time.Until(expiry.At).Round(time.Second).String()
+	}
+}
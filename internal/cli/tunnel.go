@@ -1,16 +1,33 @@
 package cli
 
 import (
+	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/exec"
 	"os/signal"
+	"path/filepath"
+	"regexp"
+	"skyport-agent/internal/accesslog"
+	"skyport-agent/internal/apperr"
 	"skyport-agent/internal/auth"
 	"skyport-agent/internal/config"
+	"skyport-agent/internal/control"
+	"skyport-agent/internal/headerfilter"
+	"skyport-agent/internal/htpasswd"
+	"skyport-agent/internal/ipfilter"
 	"skyport-agent/internal/logger"
+	"skyport-agent/internal/metrics"
+	"skyport-agent/internal/pathfilter"
+	"skyport-agent/internal/qr"
 	"skyport-agent/internal/service"
+	"skyport-agent/internal/state"
+	"skyport-agent/internal/usage"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
 	"syscall"
 	"text/tabwriter"
@@ -26,183 +43,1417 @@ var tunnelCmd = &cobra.Command{
 }
 
 var listCmd = &cobra.Command{
-	Use:   "list",
-	Short: "List all tunnels",
-	Long: `List all tunnels associated with your account.
+	Use:     "list",
+	Aliases: []string{"ls"},
+	Short:   "List all tunnels",
+	Long: `List all tunnels associated with your account. --filter shows only tunnels
+with a label ("key=value") or with a label key set ("key"); repeat it to
+require several labels.
 
 Example:
-  skyport tunnel list`,
+  skyport tunnel list
+  skyport tunnel list --filter env=staging --filter team=web
+  skyport tunnel list --json`,
 	Run: runList,
 }
 
 var runCmd = &cobra.Command{
-	Use:   "run [tunnel-name-or-id]",
-	Short: "Start a tunnel",
+	Use:     "run [tunnel-name-or-id...]",
+	Aliases: []string{"up"},
+	Short:   "Start a tunnel",
 	Long: `Start a tunnel by name or ID. The tunnel will run until stopped with Ctrl+C.
+Name several tunnels, or match names with a pattern such as 'web-*', to run
+them together, each after the tunnels it depends on.
+
+With --ci the command suits ephemeral preview tunnels in pipelines: it exits
+non-zero if the tunnel does not connect within --connect-timeout, writes the
+public URL to --url-file and to $GITHUB_OUTPUT (as "url") when set, and stops
+when its stdin is closed or the process that started it exits.
+
+With --ttl the agent stops the tunnel after that long, for something shared
+"just for an hour"; --delete-on-expiry also deletes it from the server.
 
 Examples:
   skyport tunnel run myapp
-  skyport tunnel run df35dc8d-fb0b-4abd-a75e-9609d83b3439`,
-	Args: cobra.ExactArgs(1),
+  skyport tunnel run df35dc8d-fb0b-4abd-a75e-9609d83b3439
+  skyport tunnel run myapp --qr
+  skyport tunnel run myapp --ttl 1h
+  skyport tunnel run myapp --ci --url-file preview-url.txt
+  skyport tunnel run api 'web-*'
+  skyport tunnel run --group staging`,
+	Args: runArgs,
 	Run:  runTunnel,
 }
 
 var statusCmd = &cobra.Command{
-	Use:   "status",
+	Use:   "status [name]",
 	Short: "Show tunnel status",
-	Long: `Show the status of all active tunnel connections.
+	Long: `Show the status of all active tunnel connections, or details of one tunnel:
+uptime, reconnects, the last disconnect, negotiated protocol features, whether
+its local target is reachable and its recent errors.
 
-Example:
-  skyport tunnel status`,
-	Run: runStatus,
+Examples:
+  skyport tunnel status
+  skyport tunnel status myapp`,
+	Args: cobra.MaximumNArgs(1),
+	Run:  runStatus,
 }
 
 // Note: Worker command removed - tunnels now run directly in foreground
 
 var stopCmd = &cobra.Command{
-	Use:   "stop [tunnel-name-or-id]",
-	Short: "Stop a running tunnel",
-	Args:  cobra.ExactArgs(1),
-	Run: func(cmd *cobra.Command, args []string) {
-		nameOrID := args[0]
+	Use:     "stop [tunnel-name-or-id...]",
+	Aliases: []string{"down"},
+	Short:   "Stop a running tunnel",
+	Long: `Stop tunnels by name or ID. A pattern such as 'web-*' stops the running
+tunnels whose names match.
 
-		// Resolve tunnel ID from server list
+Examples:
+  skyport tunnel stop myapp
+  skyport tunnel stop api 'web-*'`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		// Resolve tunnel IDs from server list
 		defaultConfig := config.Load()
 		authManager := auth.NewAuthManager(defaultConfig)
 		if !authManager.IsAuthenticated() {
-			fmt.Println(" You are not logged in. Please run 'skyport login' first.")
-			os.Exit(1)
+			exitWithError(apperr.ErrNotAuthenticated)
 		}
 		token, err := authManager.GetValidToken()
 		if err != nil {
-			fmt.Println(" Your session has expired. Please run 'skyport login' again.")
-			os.Exit(1)
+			exitWithError(explainError(err, apperr.ErrSessionExpired))
 		}
-		tunnels, err := authManager.FetchTunnels(token)
+		refuseReadOnly(token, "stop tunnels")
+		// Which of several tunnels are running must be current
+		list, err := listTunnels(authManager, token, manyTunnels(args))
 		if err != nil {
-			log.Fatalf(" Failed to get tunnel list: %v", err)
-		}
-		var tunnelID string
-		var tunnelName string
-		for _, t := range tunnels {
-			if t.ID == nameOrID || t.Name == nameOrID {
-				tunnelID = t.ID
-				tunnelName = t.Name
-				break
+			exitWithError(explainError(err, apperr.ErrServerUnreachable))
+		}
+		targets, err := selectTunnels(tunnelRefs(list.Tunnels), args)
+		if err != nil && list.Cached && !list.Stale {
+			// The tunnel may have been created since the list was cached
+			if list, err = listTunnels(authManager, token, true); err == nil {
+				targets, err = selectTunnels(tunnelRefs(list.Tunnels), args)
+			}
+		}
+		if err != nil {
+			exitWithError(err)
+		}
+
+		failed, attempted := 0, 0
+		for _, target := range targets {
+			// Several tunnels are only stopped if they are running
+			if manyTunnels(args) && !target.IsActive {
+				continue
+			}
+			attempted++
+			if !stopTunnel(defaultConfig, token, target) {
+				failed++
+			}
+		}
+		if attempted == 0 {
+			fmt.Printf(" %s None of the matching tunnels is running\n", logger.WarningMark())
+			return
+		}
+		config.NewConfigManager().InvalidateTunnelCache()
+		if failed > 0 {
+			exitWithError(fmt.Errorf("failed to stop %d of %d tunnels", failed, attempted))
+		}
+	},
+}
+
+// stopTunnel stops a tunnel's local background process and asks the server
+// to stop it, and reports whether it did
+func stopTunnel(defaultConfig *config.Config, token string, target *config.Tunnel) bool {
+	// First, kill any local background daemon processes for this tunnel
+	killBackgroundProcess(target.ID, target.Name)
+
+	// Then send stop request to server API
+	client := defaultConfig.HTTPClient(10 * time.Second)
+	req, err := http.NewRequest("POST", fmt.Sprintf("%s/tunnels/%s/stop", defaultConfig.ServerURL, target.ID), nil)
+	if err != nil {
+		exitWithError(fmt.Errorf("failed to create stop request: %w", err))
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		fmt.Printf(" %s Failed to stop tunnel '%s': %v\n", logger.ErrorMark(), target.Name, err)
+		return false
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		fmt.Printf(" %s Stopped tunnel '%s'\n", logger.SuccessMark(), target.Name)
+		return true
+	case http.StatusBadRequest:
+		fmt.Printf(" %s Tunnel '%s' is not currently active\n", logger.WarningMark(), target.Name)
+		return true
+	default:
+		fmt.Printf(" %s Failed to stop tunnel '%s' (status: %d)\n", logger.ErrorMark(), target.Name, resp.StatusCode)
+		return false
+	}
+}
+
+// refreshTunnels makes commands fetch the tunnel list from the server instead
+// of using the local cache
+var refreshTunnels bool
+
+func init() {
+	tunnelCmd.PersistentFlags().BoolVar(&refreshTunnels, "refresh", false, "Fetch the tunnel list from the server instead of the local cache")
+
+	listCmd.Flags().StringArray("filter", nil, "Only list tunnels with this label (key=value or key), repeatable")
+	listCmd.Flags().Bool("json", false, "Print the tunnels as JSON")
+	tunnelCmd.AddCommand(listCmd)
+	tunnelCmd.AddCommand(runCmd)
+	tunnelCmd.AddCommand(statusCmd)
+	tunnelCmd.AddCommand(stopCmd)
+
+	// Flags for "run"
+	runCmd.Flags().Bool("background", false, "Run tunnel in background")
+	runCmd.Flags().Bool("mdns", false, "Advertise the tunnel's public URL on the local network (mDNS/Bonjour)")
+	runCmd.Flags().Bool("qr", false, "Show a QR code of the public URL once connected")
+	runCmd.Flags().Bool("ci", false, "CI mode: fail if the tunnel does not connect in time, publish its URL, and stop when stdin closes or the parent exits")
+	runCmd.Flags().String("url-file", "", "Write the tunnel's public URL to this file once connected")
+	runCmd.Flags().Duration("connect-timeout", 60*time.Second, "How long --ci waits for the tunnel to connect")
+	runCmd.Flags().String("group", "", "Start the tunnels of a group, each after the tunnels it depends on (see 'skyport tunnel group')")
+	runCmd.Flags().Bool("force", false, "Take the tunnel over if it is already running on another machine")
+	runCmd.Flags().Bool("wait-for-port", false, "Connect only while something is listening on the local port, and disconnect when it closes")
+	addTTLFlags(runCmd)
+	// runCmd.Flags().Bool("auto-start", false, "Mark tunnel to auto-start on boot (requires service)")
+
+	// autostart subcommand
+	autostartCmd := &cobra.Command{
+		Use:   "autostart [tunnel-name-or-id...] [enable|disable]",
+		Short: "Enable or disable auto-start for tunnels",
+		Long: `Enable or disable auto-start for tunnels by name or ID, for names matching a
+pattern such as 'web-*', or with --all for every tunnel.
+
+Examples:
+  skyport tunnel autostart myapp enable
+  skyport tunnel autostart api 'web-*' enable
+  skyport tunnel autostart --all disable`,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if all, _ := cmd.Flags().GetBool("all"); all {
+				if len(args) != 1 {
+					return fmt.Errorf("--all takes only 'enable' or 'disable'")
+				}
+				return nil
+			}
+			return cobra.MinimumNArgs(2)(cmd, args)
+		},
+		Hidden: true, // Hide from help
+		Run: func(cmd *cobra.Command, args []string) {
+			namesOrIDs, action := args[:len(args)-1], args[len(args)-1]
+			all, _ := cmd.Flags().GetBool("all")
+
+			enable := false
+			switch action {
+			case "enable":
+				enable = true
+			case "disable":
+				enable = false
+			default:
+				exitWithError(fmt.Errorf("action must be 'enable' or 'disable'"))
+			}
+
+			defaultConfig := config.Load()
+			manager := service.NewManager(defaultConfig)
+
+			// Must be authenticated to resolve tunnel and persist
+			if !manager.IsAuthenticated() {
+				exitWithError(apperr.ErrNotAuthenticated)
+			}
+
+			// Sync tunnels so we have local IDs mapping
+			if err := manager.SyncTunnelsFromServer(); err != nil {
+				log.Printf(" Warning: Failed to sync tunnels from server: %v", err)
+			}
+
+			// Find tunnels by name, ID or pattern in local config
+			tunnels, err := manager.GetTunnelList()
+			if err != nil {
+				exitWithError(fmt.Errorf("failed to load tunnels: %w", err))
+			}
+			if all {
+				namesOrIDs = []string{"*"}
+			}
+			targets, err := selectTunnels(tunnels, namesOrIDs)
+			if err != nil {
+				exitWithError(err)
+			}
+
+			state := "disabled"
+			if enable {
+				state = "enabled"
+			}
+			for _, t := range targets {
+				if err := manager.SetTunnelAutoStart(t.ID, enable); err != nil {
+					exitWithError(fmt.Errorf("failed to update auto-start: %w", err))
+				}
+				fmt.Printf(" Auto-start %s for tunnel '%s'\n", state, t.Name)
+			}
+
+			if enable {
+				fmt.Println(" Note: To start on boot, install and start the service:")
+				fmt.Println("   skyport service install && skyport service start")
+			}
+		},
+	}
+	autostartCmd.Flags().Bool("all", false, "Apply to all of your tunnels")
+	tunnelCmd.AddCommand(autostartCmd)
+
+	accessLogCmd.Flags().String("format", "", "Log format: apache or json (default: keep current, initially apache)")
+	tunnelCmd.AddCommand(accessLogCmd)
+
+	samplingCmd.Flags().Float64("percent", 0, "Percentage of requests to record (default: keep current, initially 1)")
+	samplingCmd.Flags().Int("max-body", 0, "Truncate bodies to this many bytes (default: keep current, initially 4096)")
+	tunnelCmd.AddCommand(samplingCmd)
+
+	basicAuthCmd.Flags().String("user", "", "User to add or update (enable) or remove (remove-user)")
+	basicAuthCmd.Flags().String("password", "", "Password for --user (prompted for if omitted)")
+	basicAuthCmd.Flags().String("htpasswd", "", "htpasswd file with additional users ($apr1$ or {SHA} hashes)")
+	tunnelCmd.AddCommand(basicAuthCmd)
+	tunnelCmd.AddCommand(ipFilterCmd)
+	tunnelCmd.AddCommand(pathFilterCmd)
+	tunnelCmd.AddCommand(headerFilterCmd)
+
+	rateLimitCmd.Flags().Float64("per-client", -1, "Requests per second per client IP, 0 for no per-client limit (default: keep current, initially 10)")
+	rateLimitCmd.Flags().Float64("global", -1, "Requests per second across all clients, 0 for no global limit (default: keep current)")
+	rateLimitCmd.Flags().Int("burst", 0, "Requests a client can send at once (default: keep current, initially twice the rate)")
+	tunnelCmd.AddCommand(rateLimitCmd)
+	tunnelCmd.AddCommand(rewriteCmd)
+	tunnelCmd.AddCommand(headersCmd)
+
+	corsCmd.Flags().StringSlice("origin", nil, "Allowed origin (repeatable; default: keep current, initially any origin)")
+	corsCmd.Flags().Bool("credentials", false, "Allow cookies and auth headers on cross-origin requests")
+	tunnelCmd.AddCommand(corsCmd)
+
+	cacheCmd.Flags().String("ttl", "", "Cache every successful GET this long, or 0 to follow Cache-Control (default: keep current, initially 0)")
+	cacheCmd.Flags().Int("max-size", 0, "Memory used for cached responses in MB (default: keep current, initially 64)")
+	tunnelCmd.AddCommand(cacheCmd)
+
+	compressionCmd.Flags().Int("level", 0, "gzip level from 1 (fastest) to 9 (smallest) (default: keep current, initially 5)")
+	tunnelCmd.AddCommand(compressionCmd)
+	tunnelCmd.AddCommand(maxBodyCmd)
+	tunnelCmd.AddCommand(targetCmd)
+	tunnelCmd.AddCommand(idleCmd)
+
+	// Shortcuts: "skyport ls", "skyport up <tunnel>", "skyport down <tunnel>"
+	// and "skyport <tunnel>", which share the flags of the commands they run
+	rootCmd.AddCommand(shortcutCommand("ls", listCmd))
+	rootCmd.AddCommand(shortcutCommand("up", runCmd))
+	rootCmd.AddCommand(shortcutCommand("down", stopCmd))
+	rootCmd.Flags().AddFlagSet(runCmd.Flags())
+	rootCmd.Flags().AddFlagSet(tunnelCmd.PersistentFlags())
+}
+
+// findLocalTunnel resolves a tunnel by name or ID from the local config, exiting if it is unknown
+func findLocalTunnel(configManager *config.ConfigManager, nameOrID string) *config.Tunnel {
+	appConfig, err := configManager.LoadConfig()
+	if err != nil {
+		exitWithError(fmt.Errorf("failed to load config: %w", err))
+	}
+
+	for _, t := range appConfig.Tunnels {
+		if t.ID == nameOrID || t.Name == nameOrID {
+			return t
+		}
+	}
+
+	notFound := apperr.ErrTunnelNotFound.Withf("Tunnel '%s' not found", nameOrID)
+	notFound.Hint = "Run 'skyport tunnel list' to sync your tunnels"
+	exitWithError(notFound)
+	return nil
+}
+
+var samplingCmd = &cobra.Command{
+	Use:   "sampling [tunnel-name-or-id] [enable|disable]",
+	Short: "Record a percentage of requests to disk for analysis",
+	Long: `Record a random percentage of requests proxied through a tunnel, with headers and
+truncated bodies, to ~/.skyport/logs/samples/<tunnel>.jsonl. Authorization, cookie and
+API key headers are redacted. Files rotate at max_size_mb (default 50) keeping
+max_backups (default 3) old files.
+
+Example:
+  skyport tunnel sampling myapp enable --percent 5
+  skyport tunnel sampling myapp disable`,
+	Args:        cobra.ExactArgs(2),
+	Annotations: map[string]string{offlineAnnotation: "true"},
+	Run: func(cmd *cobra.Command, args []string) {
+		nameOrID := args[0]
+		percent, _ := cmd.Flags().GetFloat64("percent")
+		maxBody, _ := cmd.Flags().GetInt("max-body")
+
+		var enable bool
+		switch args[1] {
+		case "enable":
+			enable = true
+		case "disable":
+			enable = false
+		default:
+			exitWithError(fmt.Errorf("action must be 'enable' or 'disable'"))
+		}
+
+		if percent < 0 || percent > 100 {
+			exitWithError(fmt.Errorf("percent must be between 0 and 100"))
+		}
+
+		configManager := config.NewConfigManager()
+		tunnel := findLocalTunnel(configManager, nameOrID)
+
+		if err := configManager.SetTunnelSampling(tunnel.ID, enable, percent, maxBody); err != nil {
+			exitWithError(fmt.Errorf("failed to update sampling setting: %w", err))
+		}
+
+		if !enable {
+			fmt.Printf(" Request sampling disabled for tunnel '%s'\n", tunnel.Name)
+			return
+		}
+
+		tunnel = findLocalTunnel(configManager, tunnel.ID)
+		settings := tunnel.SamplingSettings()
+		path, _ := config.SamplesPath(tunnel)
+		fmt.Printf(" Sampling %.1f%% of requests for tunnel '%s'\n", settings.Percent, tunnel.Name)
+		fmt.Printf("   File: %s\n", path)
+		fmt.Println(" Takes effect the next time the tunnel connects.")
+	},
+}
+
+var basicAuthCmd = &cobra.Command{
+	Use:   "basic-auth [tunnel-name-or-id] [enable|disable|remove-user]",
+	Short: "Require a username and password for requests to a tunnel",
+	Long: `Make the agent reject requests without valid HTTP basic auth credentials with
+401 before they reach your local service. Users can be added one at a time
+(passwords are stored hashed in skyport.json) and/or read from an htpasswd file
+created with "htpasswd -m". bcrypt entries are not supported.
+
+Example:
+  skyport tunnel basic-auth myapp enable --user alice
+  skyport tunnel basic-auth myapp enable --htpasswd /etc/skyport/staging.htpasswd
+  skyport tunnel basic-auth myapp remove-user --user alice
+  skyport tunnel basic-auth myapp disable`,
+	Args:        cobra.ExactArgs(2),
+	Annotations: map[string]string{offlineAnnotation: "true"},
+	Run: func(cmd *cobra.Command, args []string) {
+		nameOrID := args[0]
+		user, _ := cmd.Flags().GetString("user")
+		password, _ := cmd.Flags().GetString("password")
+		htpasswdFile, _ := cmd.Flags().GetString("htpasswd")
+
+		configManager := config.NewConfigManager()
+		tunnel := findLocalTunnel(configManager, nameOrID)
+
+		switch args[1] {
+		case "enable":
+		case "disable":
+			if err := configManager.SetTunnelBasicAuth(tunnel.ID, false, "", "", ""); err != nil {
+				exitWithError(fmt.Errorf("failed to update basic auth setting: %w", err))
+			}
+			fmt.Printf(" Basic auth disabled for tunnel '%s'\n", tunnel.Name)
+			fmt.Println(" Takes effect the next time the tunnel connects.")
+			return
+		case "remove-user":
+			if user == "" {
+				exitWithError(fmt.Errorf("--user is required"))
+			}
+			if err := configManager.RemoveTunnelBasicAuthUser(tunnel.ID, user); err != nil {
+				exitWithError(fmt.Errorf("failed to remove user: %w", err))
+			}
+			fmt.Printf(" Removed user '%s' from tunnel '%s'\n", user, tunnel.Name)
+			fmt.Println(" Takes effect the next time the tunnel connects.")
+			return
+		default:
+			exitWithError(fmt.Errorf("action must be 'enable', 'disable' or 'remove-user'"))
+		}
+
+		if htpasswdFile != "" {
+			absPath, err := filepath.Abs(htpasswdFile)
+			if err != nil {
+				exitWithError(fmt.Errorf("invalid htpasswd path: %w", err))
+			}
+			users, skipped, err := htpasswd.Load(absPath)
+			if err != nil {
+				exitWithError(err)
+			}
+			if len(skipped) > 0 {
+				fmt.Printf(" Warning: unsupported password hashes will be ignored for: %s\n", strings.Join(skipped, ", "))
+			}
+			fmt.Printf(" Loaded %d user(s) from %s\n", len(users), absPath)
+			htpasswdFile = absPath
+		}
+
+		var passwordHash string
+		if user != "" {
+			if strings.Contains(user, ":") {
+				exitWithError(fmt.Errorf("user names cannot contain ':'"))
+			}
+			if password == "" {
+				answer, ok := promptLine(fmt.Sprintf(" Password for %s: ", user))
+				if !ok {
+					exitWithError(fmt.Errorf("pass the password with --password when not running interactively"))
+				}
+				password = answer
+			}
+			if password == "" {
+				exitWithError(fmt.Errorf("password cannot be empty"))
+			}
+
+			hash, err := htpasswd.Hash(password)
+			if err != nil {
+				exitWithError(fmt.Errorf("failed to hash password: %w", err))
+			}
+			passwordHash = hash
+		}
+
+		if err := configManager.SetTunnelBasicAuth(tunnel.ID, true, user, passwordHash, htpasswdFile); err != nil {
+			exitWithError(fmt.Errorf("failed to update basic auth setting: %w", err))
+		}
+
+		tunnel = findLocalTunnel(configManager, tunnel.ID)
+		settings := tunnel.BasicAuthSettings()
+		fmt.Printf(" Basic auth enabled for tunnel '%s' (%d configured user(s))\n", tunnel.Name, len(settings.Users))
+		if settings.HtpasswdFile != "" {
+			fmt.Printf("   htpasswd file: %s\n", settings.HtpasswdFile)
+		}
+		if len(settings.Users) == 0 && settings.HtpasswdFile == "" {
+			fmt.Println(" Warning: no users configured yet, all requests will be rejected. Add one with --user.")
+		}
+		fmt.Println(" Takes effect the next time the tunnel connects.")
+	},
+}
+
+var ipFilterCmd = &cobra.Command{
+	Use:   "ip-filter [tunnel-name-or-id] [show|allow|deny|remove|clear] [ip-or-cidr...]",
+	Short: "Restrict which client IPs can reach a tunnel",
+	Long: `Manage a tunnel's IP allow and deny lists. The agent checks the client IP
+forwarded by the server and answers 403 for rejected clients without contacting
+your local service. Deny entries always win; once the allow list has entries,
+only clients in it are accepted.
+
+Example:
+  skyport tunnel ip-filter myapp allow 203.0.113.0/24 2001:db8::/32
+  skyport tunnel ip-filter myapp deny 198.51.100.7
+  skyport tunnel ip-filter myapp remove 198.51.100.7
+  skyport tunnel ip-filter myapp show
+  skyport tunnel ip-filter myapp clear`,
+	Args:        cobra.MinimumNArgs(2),
+	Annotations: map[string]string{offlineAnnotation: "true"},
+	Run: func(cmd *cobra.Command, args []string) {
+		nameOrID := args[0]
+		action := args[1]
+		entries := args[2:]
+
+		configManager := config.NewConfigManager()
+		tunnel := findLocalTunnel(configManager, nameOrID)
+
+		var current config.IPFilterSettings
+		if tunnel.Settings != nil {
+			current = tunnel.Settings.IPFilter
+		}
+
+		// Normalize entries so "10.0.0.1/8" and "10.0.0.0/8" are the same range
+		normalized := make([]string, 0, len(entries))
+		for _, entry := range entries {
+			prefix, err := ipfilter.ParsePrefix(entry)
+			if err != nil {
+				exitWithError(err)
+			}
+			normalized = append(normalized, prefix.String())
+		}
+
+		allow, deny := current.Allow, current.Deny
+		switch action {
+		case "show":
+			printIPFilter(tunnel.Name, current)
+			return
+		case "allow", "deny":
+			if len(normalized) == 0 {
+				exitWithError(fmt.Errorf("specify at least one IP address or CIDR range"))
+			}
+			if action == "allow" {
+				allow = addEntries(allow, normalized)
+			} else {
+				deny = addEntries(deny, normalized)
+			}
+		case "remove":
+			if len(normalized) == 0 {
+				exitWithError(fmt.Errorf("specify at least one IP address or CIDR range"))
+			}
+			allow = removeEntries(allow, normalized)
+			deny = removeEntries(deny, normalized)
+		case "clear":
+			allow, deny = nil, nil
+		default:
+			exitWithError(fmt.Errorf("action must be 'show', 'allow', 'deny', 'remove' or 'clear'"))
+		}
+
+		if err := configManager.SetTunnelIPFilter(tunnel.ID, allow, deny); err != nil {
+			exitWithError(fmt.Errorf("failed to update IP filter: %w", err))
+		}
+
+		printIPFilter(tunnel.Name, config.IPFilterSettings{Allow: allow, Deny: deny})
+		fmt.Println(" Takes effect the next time the tunnel connects.")
+	},
+}
+
+var pathFilterCmd = &cobra.Command{
+	Use:   "path-filter [tunnel-name-or-id] [show|allow|deny|remove|clear] [rule...]",
+	Short: "Restrict which methods and paths can be reached through a tunnel",
+	Long: `Manage a tunnel's method and path allow and deny rules, to shrink the part
+of your app that is exposed. A rule is a path pattern, optionally after
+methods: '/admin/*' or 'POST /webhooks/*'. In patterns, * matches anything
+including slashes, and a trailing /* also matches the path itself.
+
+The agent answers 403 for rejected requests without contacting your local
+service, or 405 when the path is allowed but not with that method. Deny
+rules always win; once there are allow rules, only requests matching one of
+them are accepted. Quote rules that contain a space or *.
+
+Example:
+  skyport tunnel path-filter myapp deny '/admin/*'
+  skyport tunnel path-filter myapp allow 'POST /webhooks/*'
+  skyport tunnel path-filter myapp allow 'GET,HEAD /status'
+  skyport tunnel path-filter myapp remove '/admin/*'
+  skyport tunnel path-filter myapp show
+  skyport tunnel path-filter myapp clear`,
+	Args:        cobra.MinimumNArgs(2),
+	Annotations: map[string]string{offlineAnnotation: "true"},
+	Run: func(cmd *cobra.Command, args []string) {
+		nameOrID := args[0]
+		action := args[1]
+		entries := args[2:]
+
+		configManager := config.NewConfigManager()
+		tunnel := findLocalTunnel(configManager, nameOrID)
+
+		var current config.PathFilterSettings
+		if tunnel.Settings != nil {
+			current = tunnel.Settings.PathFilter
+		}
+
+		// Normalize rules so "post /hook" and "POST /hook" are the same rule
+		normalized := make([]string, 0, len(entries))
+		for _, entry := range entries {
+			rule, err := pathfilter.ParseRule(entry)
+			if err != nil {
+				exitWithError(err)
+			}
+			normalized = append(normalized, rule.String())
+		}
+
+		allow, deny := current.Allow, current.Deny
+		switch action {
+		case "show":
+			printPathFilter(tunnel.Name, current)
+			return
+		case "allow", "deny", "remove":
+			if len(normalized) == 0 {
+				exitWithError(fmt.Errorf("specify at least one rule, such as '/admin/*' or 'POST /webhooks/*'"))
+			}
+			switch action {
+			case "allow":
+				allow = addEntries(allow, normalized)
+			case "deny":
+				deny = addEntries(deny, normalized)
+			default:
+				allow = removeEntries(allow, normalized)
+				deny = removeEntries(deny, normalized)
+			}
+		case "clear":
+			allow, deny = nil, nil
+		default:
+			exitWithError(fmt.Errorf("action must be 'show', 'allow', 'deny', 'remove' or 'clear'"))
+		}
+
+		if err := configManager.SetTunnelPathFilter(tunnel.ID, allow, deny); err != nil {
+			exitWithError(fmt.Errorf("failed to update path filter: %w", err))
+		}
+
+		printPathFilter(tunnel.Name, config.PathFilterSettings{Allow: allow, Deny: deny})
+		fmt.Println(" Takes effect the next time the tunnel connects.")
+	},
+}
+
+var headerFilterCmd = &cobra.Command{
+	Use:   "header-filter [tunnel-name-or-id] [show|allow|deny|remove|clear] [rule...]",
+	Short: "Restrict which clients can reach a tunnel by country or user agent",
+	Long: `Manage a tunnel's allow and deny rules on the request headers the server
+forwards. A rule is '<header> <op> <value>':
+
+  country != IN,US        the client's country code is not IN or US
+  user-agent ~ (?i)curl   the User-Agent matches a regular expression
+  X-Api-Version = 2       any other header, compared without regard to case
+
+The operators are =, != (comma-separated values), ~ and !~ (regular
+expressions). A missing header has the empty value. The 'bots' rule matches
+the user agents of crawlers, headless browsers and HTTP libraries. The
+country is read from X-Skyport-Country, or from CF-IPCountry,
+CloudFront-Viewer-Country or X-Country-Code set by a proxy in front.
+
+The agent answers 403 for rejected requests without contacting your local
+service, and counts them per rule in the tunnel's stats. Deny rules always
+win; once there are allow rules, only requests matching one of them are
+accepted. Quote each rule.
+
+Example:
+  skyport tunnel header-filter myapp deny 'country != IN'
+  skyport tunnel header-filter myapp deny bots
+  skyport tunnel header-filter myapp remove bots
+  skyport tunnel header-filter myapp show
+  skyport tunnel header-filter myapp clear`,
+	Args:        cobra.MinimumNArgs(2),
+	Annotations: map[string]string{offlineAnnotation: "true"},
+	Run: func(cmd *cobra.Command, args []string) {
+		nameOrID := args[0]
+		action := args[1]
+		entries := args[2:]
+
+		configManager := config.NewConfigManager()
+		tunnel := findLocalTunnel(configManager, nameOrID)
+
+		var current config.HeaderFilterSettings
+		if tunnel.Settings != nil {
+			current = tunnel.Settings.HeaderFilter
+		}
+
+		// Normalize rules so "User-agent ~ curl" and "user-agent ~ curl" are the same rule
+		normalized := make([]string, 0, len(entries))
+		for _, entry := range entries {
+			rule, err := headerfilter.ParseRule(entry)
+			if err != nil {
+				exitWithError(err)
+			}
+			normalized = append(normalized, rule.String())
+		}
+
+		allow, deny := current.Allow, current.Deny
+		switch action {
+		case "show":
+			printHeaderFilter(tunnel.Name, current)
+			return
+		case "allow", "deny", "remove":
+			if len(normalized) == 0 {
+				exitWithError(fmt.Errorf("specify at least one rule, such as 'country != IN' or bots"))
+			}
+			switch action {
+			case "allow":
+				allow = addEntries(allow, normalized)
+			case "deny":
+				deny = addEntries(deny, normalized)
+			default:
+				allow = removeEntries(allow, normalized)
+				deny = removeEntries(deny, normalized)
 			}
+		case "clear":
+			allow, deny = nil, nil
+		default:
+			exitWithError(fmt.Errorf("action must be 'show', 'allow', 'deny', 'remove' or 'clear'"))
+		}
+
+		if err := configManager.SetTunnelHeaderFilter(tunnel.ID, allow, deny); err != nil {
+			exitWithError(fmt.Errorf("failed to update header filter: %w", err))
+		}
+
+		printHeaderFilter(tunnel.Name, config.HeaderFilterSettings{Allow: allow, Deny: deny})
+		fmt.Println(" Takes effect the next time the tunnel connects.")
+	},
+}
+
+var rateLimitCmd = &cobra.Command{
+	Use:   "rate-limit [tunnel-name-or-id] [enable|disable]",
+	Short: "Limit how fast clients can send requests through a tunnel",
+	Long: `Limit requests per second per client IP, and optionally across all clients, so
+a single crawler can't overload your local service. Requests over the limit are
+answered with 429 Too Many Requests (with Retry-After) by the agent.
+
+Example:
+  skyport tunnel rate-limit myapp enable --per-client 5 --global 50
+  skyport tunnel rate-limit myapp disable`,
+	Args:        cobra.ExactArgs(2),
+	Annotations: map[string]string{offlineAnnotation: "true"},
+	Run: func(cmd *cobra.Command, args []string) {
+		nameOrID := args[0]
+		perClient, _ := cmd.Flags().GetFloat64("per-client")
+		global, _ := cmd.Flags().GetFloat64("global")
+		burst, _ := cmd.Flags().GetInt("burst")
+
+		var enable bool
+		switch args[1] {
+		case "enable":
+			enable = true
+		case "disable":
+			enable = false
+		default:
+			exitWithError(fmt.Errorf("action must be 'enable' or 'disable'"))
+		}
+
+		configManager := config.NewConfigManager()
+		tunnel := findLocalTunnel(configManager, nameOrID)
+
+		if err := configManager.SetTunnelRateLimit(tunnel.ID, enable, perClient, global, burst); err != nil {
+			exitWithError(fmt.Errorf("failed to update rate limit: %w", err))
+		}
+
+		if !enable {
+			fmt.Printf(" Rate limiting disabled for tunnel '%s'\n", tunnel.Name)
+			fmt.Println(" Takes effect the next time the tunnel connects.")
+			return
+		}
+
+		tunnel = findLocalTunnel(configManager, tunnel.ID)
+		settings := tunnel.RateLimitSettings()
+		fmt.Printf(" Rate limiting enabled for tunnel '%s'\n", tunnel.Name)
+		if settings.PerClientRPS > 0 {
+			fmt.Printf("   Per client: %g req/s (burst %d)\n", settings.PerClientRPS, settings.PerClientBurst)
+		}
+		if settings.GlobalRPS > 0 {
+			fmt.Printf("   Global:     %g req/s (burst %d)\n", settings.GlobalRPS, settings.GlobalBurst)
+		}
+		fmt.Println(" Takes effect the next time the tunnel connects.")
+	},
+}
+
+var rewriteCmd = &cobra.Command{
+	Use:   "rewrite [tunnel-name-or-id] [show|strip-prefix|add-prefix|add-rule|clear] [args...]",
+	Short: "Rewrite request paths before they reach the local service",
+	Long: `Rewrite the path of requests before forwarding them, for apps mounted under a
+different base path locally than publicly. The prefix is stripped first, then
+added, then regex rules are applied in order. Query strings are not changed.
+
+Example:
+  skyport tunnel rewrite myapp strip-prefix /api        # /api/users -> /users
+  skyport tunnel rewrite myapp add-prefix /app          # /users -> /app/users
+  skyport tunnel rewrite myapp add-rule '^/v1/(.*)' '/legacy/$1'
+  skyport tunnel rewrite myapp show
+  skyport tunnel rewrite myapp clear`,
+	Args:        cobra.MinimumNArgs(2),
+	Annotations: map[string]string{offlineAnnotation: "true"},
+	Run: func(cmd *cobra.Command, args []string) {
+		nameOrID := args[0]
+		action := args[1]
+		params := args[2:]
+
+		configManager := config.NewConfigManager()
+		tunnel := findLocalTunnel(configManager, nameOrID)
+
+		var rewrite config.RewriteSettings
+		if tunnel.Settings != nil {
+			rewrite = tunnel.Settings.Rewrite
+		}
+
+		switch action {
+		case "show":
+			printRewrite(tunnel.Name, rewrite)
+			return
+		case "strip-prefix", "add-prefix":
+			if len(params) != 1 {
+				exitWithError(fmt.Errorf("usage: skyport tunnel rewrite %s %s <prefix>  (use \"\" to remove)", nameOrID, action))
+			}
+			prefix := params[0]
+			if prefix != "" && !strings.HasPrefix(prefix, "/") {
+				prefix = "/" + prefix
+			}
+			if action == "strip-prefix" {
+				rewrite.StripPrefix = prefix
+			} else {
+				rewrite.AddPrefix = prefix
+			}
+		case "add-rule":
+			if len(params) != 2 {
+				exitWithError(fmt.Errorf("usage: skyport tunnel rewrite %s add-rule <regex> <replacement>", nameOrID))
+			}
+			if _, err := regexp.Compile(params[0]); err != nil {
+				exitWithError(fmt.Errorf("invalid regular expression: %w", err))
+			}
+			rewrite.Rules = append(rewrite.Rules, config.RewriteRule{Match: params[0], Replace: params[1]})
+		case "clear":
+			rewrite = config.RewriteSettings{}
+		default:
+			exitWithError(fmt.Errorf("action must be 'show', 'strip-prefix', 'add-prefix', 'add-rule' or 'clear'"))
+		}
+
+		if err := configManager.SetTunnelRewrite(tunnel.ID, rewrite); err != nil {
+			exitWithError(fmt.Errorf("failed to update rewrite rules: %w", err))
+		}
+
+		printRewrite(tunnel.Name, rewrite)
+		fmt.Println(" Takes effect the next time the tunnel connects.")
+	},
+}
+
+var headersCmd = &cobra.Command{
+	Use:   "headers [tunnel-name-or-id] [show|clear|request|response] [add|set|remove] [header] [value]",
+	Short: "Add, override or strip request and response headers",
+	Long: `Manage header rules for a tunnel. Request rules apply to requests sent to your
+local service; response rules apply to responses sent back to clients. Headers
+are removed first, then set (replacing existing values), then added (only if
+not already present).
+
+Example:
+  skyport tunnel headers myapp request set X-Internal-Auth s3cret
+  skyport tunnel headers myapp response remove Server X-Powered-By
+  skyport tunnel headers myapp response add Cache-Control no-store
+  skyport tunnel headers myapp show
+  skyport tunnel headers myapp clear`,
+	Args:        cobra.MinimumNArgs(2),
+	Annotations: map[string]string{offlineAnnotation: "true"},
+	Run: func(cmd *cobra.Command, args []string) {
+		nameOrID := args[0]
+		direction := args[1]
+
+		configManager := config.NewConfigManager()
+		tunnel := findLocalTunnel(configManager, nameOrID)
+
+		var headers config.HeaderSettings
+		if tunnel.Settings != nil {
+			headers = tunnel.Settings.Headers
+		}
+
+		var rules *config.HeaderRules
+		switch direction {
+		case "show":
+			printHeaderRules(tunnel.Name, headers)
+			return
+		case "clear":
+			headers = config.HeaderSettings{}
+		case "request":
+			rules = &headers.Request
+		case "response":
+			rules = &headers.Response
+		default:
+			exitWithError(fmt.Errorf("second argument must be 'show', 'clear', 'request' or 'response'"))
+		}
+
+		if rules != nil {
+			if len(args) < 4 {
+				exitWithError(fmt.Errorf("usage: skyport tunnel headers %s %s add|set <header> <value> | remove <header>...", nameOrID, direction))
+			}
+
+			action, params := args[2], args[3:]
+			switch action {
+			case "add", "set":
+				if len(params) != 2 {
+					exitWithError(fmt.Errorf("usage: skyport tunnel headers %s %s %s <header> <value>", nameOrID, direction, action))
+				}
+				name := http.CanonicalHeaderKey(params[0])
+				if action == "add" {
+					if rules.Add == nil {
+						rules.Add = make(map[string]string)
+					}
+					rules.Add[name] = params[1]
+				} else {
+					if rules.Set == nil {
+						rules.Set = make(map[string]string)
+					}
+					rules.Set[name] = params[1]
+				}
+			case "remove":
+				for _, name := range params {
+					rules.Remove = addEntries(rules.Remove, []string{http.CanonicalHeaderKey(name)})
+				}
+			default:
+				exitWithError(fmt.Errorf("action must be 'add', 'set' or 'remove'"))
+			}
+		}
+
+		if err := configManager.SetTunnelHeaders(tunnel.ID, headers); err != nil {
+			exitWithError(fmt.Errorf("failed to update header filter: %w", err))
+		}
+
+		printHeaderRules(tunnel.Name, headers)
+		fmt.Println(" Takes effect the next time the tunnel connects.")
+	},
+}
+
+var corsCmd = &cobra.Command{
+	Use:   "cors [tunnel-name-or-id] [enable|disable]",
+	Short: "Answer CORS preflights and add CORS headers for a tunnel",
+	Long: `Let browsers call a tunneled API from another origin without changing the
+backend. The agent answers OPTIONS preflight requests itself and adds
+Access-Control-Allow-* headers to responses for allowed origins.
+
+Example:
+  skyport tunnel cors myapp enable
+  skyport tunnel cors myapp enable --origin http://localhost:5173 --credentials
+  skyport tunnel cors myapp disable`,
+	Args:        cobra.ExactArgs(2),
+	Annotations: map[string]string{offlineAnnotation: "true"},
+	Run: func(cmd *cobra.Command, args []string) {
+		nameOrID := args[0]
+		origins, _ := cmd.Flags().GetStringSlice("origin")
+		credentials, _ := cmd.Flags().GetBool("credentials")
+
+		var enable bool
+		switch args[1] {
+		case "enable":
+			enable = true
+		case "disable":
+			enable = false
+		default:
+			exitWithError(fmt.Errorf("action must be 'enable' or 'disable'"))
+		}
+
+		for i, origin := range origins {
+			origins[i] = strings.TrimSuffix(origin, "/")
+		}
+
+		configManager := config.NewConfigManager()
+		tunnel := findLocalTunnel(configManager, nameOrID)
+
+		if err := configManager.SetTunnelCORS(tunnel.ID, enable, origins, credentials); err != nil {
+			exitWithError(fmt.Errorf("failed to update CORS setting: %w", err))
+		}
+
+		if !enable {
+			fmt.Printf(" CORS handling disabled for tunnel '%s'\n", tunnel.Name)
+			fmt.Println(" Takes effect the next time the tunnel connects.")
+			return
+		}
+
+		tunnel = findLocalTunnel(configManager, tunnel.ID)
+		settings := tunnel.CORSSettings()
+		fmt.Printf(" CORS handling enabled for tunnel '%s'\n", tunnel.Name)
+		fmt.Printf("   Origins:     %s\n", strings.Join(settings.AllowOrigins, ", "))
+		fmt.Printf("   Credentials: %t\n", settings.AllowCredentials)
+		fmt.Println(" Takes effect the next time the tunnel connects.")
+	},
+}
+
+var cacheCmd = &cobra.Command{
+	Use:   "cache [tunnel-name-or-id] [enable|disable]",
+	Short: "Cache GET responses in the agent",
+	Long: `Serve repeated GET requests from an in-memory cache in the agent instead of
+your local service. By default responses are cached for their Cache-Control
+max-age; --ttl caches every successful response for a fixed time instead.
+Responses marked no-store or private, responses setting cookies and requests
+with cookies or credentials are never cached. Responses carry an
+X-Skyport-Cache: HIT or MISS header.
+
+Example:
+  skyport tunnel cache myapp enable
+  skyport tunnel cache myapp enable --ttl 5m --max-size 128
+  skyport tunnel cache myapp disable`,
+	Args:        cobra.ExactArgs(2),
+	Annotations: map[string]string{offlineAnnotation: "true"},
+	Run: func(cmd *cobra.Command, args []string) {
+		nameOrID := args[0]
+		ttlFlag, _ := cmd.Flags().GetString("ttl")
+		maxSize, _ := cmd.Flags().GetInt("max-size")
+
+		var enable bool
+		switch args[1] {
+		case "enable":
+			enable = true
+		case "disable":
+			enable = false
+		default:
+			exitWithError(fmt.Errorf("action must be 'enable' or 'disable'"))
+		}
+
+		ttl := time.Duration(-1)
+		if ttlFlag != "" {
+			parsed, err := time.ParseDuration(ttlFlag)
+			if ttlFlag == "0" {
+				parsed, err = 0, nil
+			}
+			if err != nil || parsed < 0 {
+				exitWithError(fmt.Errorf("invalid --ttl value: %s", ttlFlag))
+			}
+			ttl = parsed
+		}
+
+		configManager := config.NewConfigManager()
+		tunnel := findLocalTunnel(configManager, nameOrID)
+
+		if err := configManager.SetTunnelCache(tunnel.ID, enable, ttl, maxSize); err != nil {
+			exitWithError(fmt.Errorf("failed to update cache setting: %w", err))
+		}
+
+		if !enable {
+			fmt.Printf(" Response cache disabled for tunnel '%s'\n", tunnel.Name)
+			fmt.Println(" Takes effect the next time the tunnel connects.")
+			return
+		}
+
+		tunnel = findLocalTunnel(configManager, tunnel.ID)
+		settings := tunnel.CacheSettings()
+		fmt.Printf(" Response cache enabled for tunnel '%s'\n", tunnel.Name)
+		if settings.TTL.Duration > 0 {
+			fmt.Printf("   TTL:      %s (overrides Cache-Control max-age)\n", settings.TTL.Duration)
+		} else {
+			fmt.Println("   TTL:      from Cache-Control max-age")
+		}
+		fmt.Printf("   Max size: %d MB\n", settings.MaxSizeMB)
+		fmt.Println(" Takes effect the next time the tunnel connects.")
+	},
+}
+
+var compressionCmd = &cobra.Command{
+	Use:   "compression [tunnel-name-or-id] [enable|disable]",
+	Short: "Gzip responses in the agent before sending them over the tunnel",
+	Long: `Compress text, JSON, JavaScript, SVG and other compressible responses with gzip
+when the client accepts it and your local service didn't compress them, so fewer
+bytes cross a slow uplink. Responses under 1 KB are sent as is.
+
+Example:
+  skyport tunnel compression myapp enable
+  skyport tunnel compression myapp enable --level 9
+  skyport tunnel compression myapp disable`,
+	Args:        cobra.ExactArgs(2),
+	Annotations: map[string]string{offlineAnnotation: "true"},
+	Run: func(cmd *cobra.Command, args []string) {
+		nameOrID := args[0]
+		level, _ := cmd.Flags().GetInt("level")
+
+		var enable bool
+		switch args[1] {
+		case "enable":
+			enable = true
+		case "disable":
+			enable = false
+		default:
+			exitWithError(fmt.Errorf("action must be 'enable' or 'disable'"))
+		}
+
+		if level < 0 || level > 9 {
+			exitWithError(fmt.Errorf("level must be between 1 and 9"))
+		}
+
+		configManager := config.NewConfigManager()
+		tunnel := findLocalTunnel(configManager, nameOrID)
+
+		if err := configManager.SetTunnelCompression(tunnel.ID, enable, level); err != nil {
+			exitWithError(fmt.Errorf("failed to update compression setting: %w", err))
+		}
+
+		if !enable {
+			fmt.Printf(" Response compression disabled for tunnel '%s'\n", tunnel.Name)
+		} else {
+			tunnel = findLocalTunnel(configManager, tunnel.ID)
+			fmt.Printf(" Response compression enabled for tunnel '%s' (gzip level %d)\n", tunnel.Name, tunnel.CompressionSettings().Level)
+		}
+		fmt.Println(" Takes effect the next time the tunnel connects.")
+	},
+}
+
+var maxBodyCmd = &cobra.Command{
+	Use:   "max-body [tunnel-name-or-id] [size|off]",
+	Short: "Reject requests with bodies larger than a limit",
+	Long: `Set the largest request body the agent forwards to your local service. Larger
+requests, or requests declaring a larger Content-Length, are answered with
+413 Request Entity Too Large. Sizes accept B, KB, MB and GB suffixes.
+
+Example:
+  skyport tunnel max-body myapp 10MB
+  skyport tunnel max-body myapp off`,
+	Args:        cobra.ExactArgs(2),
+	Annotations: map[string]string{offlineAnnotation: "true"},
+	Run: func(cmd *cobra.Command, args []string) {
+		var maxBytes int64
+		if args[1] != "off" {
+			size, err := parseSize(args[1])
+			if err != nil || size <= 0 {
+				exitWithError(fmt.Errorf("invalid size '%s' (use e.g. 512KB, 10MB or off)", args[1]))
+			}
+			maxBytes = size
+		}
+
+		configManager := config.NewConfigManager()
+		tunnel := findLocalTunnel(configManager, args[0])
+
+		if err := configManager.SetTunnelMaxRequestBody(tunnel.ID, maxBytes); err != nil {
+			exitWithError(fmt.Errorf("failed to update request size limit: %w", err))
+		}
+
+		if maxBytes == 0 {
+			fmt.Printf(" No request size limit for tunnel '%s'\n", tunnel.Name)
+		} else {
+			fmt.Printf(" Requests over %s to tunnel '%s' will get 413\n", usage.FormatBytes(maxBytes), tunnel.Name)
+		}
+		fmt.Println(" Takes effect the next time the tunnel connects.")
+	},
+}
+
+var targetCmd = &cobra.Command{
+	Use:   "target [tunnel-name-or-id] [host:port|default]",
+	Short: "Forward a tunnel to a specific local address",
+	Long: `Set the address the agent forwards a tunnel's requests to. By default it is
+localhost and the tunnel's port, tried on both 127.0.0.1 and ::1 so services
+listening on only one of them are found. Use an address literal to pin the
+target, for example an IPv6-only service or another machine on your network.
+
+Example:
+  skyport tunnel target myapp [::1]:8080
+  skyport tunnel target myapp 192.168.1.20:3000
+  skyport tunnel target myapp default`,
+	Args:        cobra.ExactArgs(2),
+	Annotations: map[string]string{offlineAnnotation: "true"},
+	Run: func(cmd *cobra.Command, args []string) {
+		var target string
+		if args[1] != "default" {
+			parsed, err := config.ParseTarget(args[1])
+			if err != nil {
+				exitWithError(fmt.Errorf("invalid target '%s': %w", args[1], err))
+			}
+			target = parsed
+		}
+
+		configManager := config.NewConfigManager()
+		tunnel := findLocalTunnel(configManager, args[0])
+
+		if err := configManager.SetTunnelTarget(tunnel.ID, target); err != nil {
+			exitWithError(fmt.Errorf("failed to update tunnel target: %w", err))
+		}
+
+		tunnel = findLocalTunnel(configManager, tunnel.ID)
+		fmt.Printf(" Tunnel '%s' forwards to %s\n", tunnel.Name, tunnel.LocalAddress())
+		fmt.Println(" Takes effect the next time the tunnel connects.")
+	},
+}
+
+var idleCmd = &cobra.Command{
+	Use:   "idle [tunnel-name-or-id] [duration|off]",
+	Short: "Disconnect a tunnel when it gets no requests for a while",
+	Long: `Set how long a tunnel may go without requests before the agent disconnects it,
+so a forgotten tunnel does not stay exposed. The agent does not reconnect it:
+start it again with 'skyport tunnel run'. Configured alerts are sent as
+'tunnel_idle' events.
+
+Example:
+  skyport tunnel idle myapp 30m
+  skyport tunnel idle myapp 8h
+  skyport tunnel idle myapp off`,
+	Args:        cobra.ExactArgs(2),
+	Annotations: map[string]string{offlineAnnotation: "true"},
+	Run: func(cmd *cobra.Command, args []string) {
+		var timeout time.Duration
+		if args[1] != "off" {
+			parsed, err := time.ParseDuration(args[1])
+			if err != nil || parsed < time.Minute {
+				exitWithError(fmt.Errorf("invalid idle timeout '%s': use a duration of at least 1m, such as 30m or 8h, or 'off'", args[1]))
+			}
+			timeout = parsed
+		}
+
+		configManager := config.NewConfigManager()
+		tunnel := findLocalTunnel(configManager, args[0])
+
+		if err := configManager.SetTunnelIdleTimeout(tunnel.ID, timeout); err != nil {
+			exitWithError(fmt.Errorf("failed to update idle timeout: %w", err))
+		}
+
+		if timeout == 0 {
+			fmt.Printf(" Tunnel '%s' stays connected while idle\n", tunnel.Name)
+		} else {
+			fmt.Printf(" Tunnel '%s' will be disconnected after %v without requests\n", tunnel.Name, timeout)
+		}
+		fmt.Println(" Takes effect the next time the tunnel connects.")
+	},
+}
+
+// parseSize parses a byte size such as "512KB" or "10MB" (binary units)
+func parseSize(value string) (int64, error) {
+	value = strings.ToUpper(strings.TrimSpace(value))
+	multiplier := int64(1)
+	for _, unit := range []struct {
+		suffix string
+		size   int64
+	}{{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"G", 1 << 30}, {"M", 1 << 20}, {"K", 1 << 10}, {"B", 1}} {
+		if strings.HasSuffix(value, unit.suffix) {
+			value = strings.TrimSpace(strings.TrimSuffix(value, unit.suffix))
+			multiplier = unit.size
+			break
+		}
+	}
+
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, err
+	}
+	return int64(n * float64(multiplier)), nil
+}
+
+// printHeaderRules shows a tunnel's request and response header rules
+func printHeaderRules(tunnelName string, headers config.HeaderSettings) {
+	if headers.Request.Empty() && headers.Response.Empty() {
+		fmt.Printf(" No header restrictions for tunnel '%s'\n", tunnelName)
+		return
+	}
+
+	fmt.Printf(" Header filter for tunnel '%s':\n", tunnelName)
+	for _, section := range []struct {
+		label string
+		rules config.HeaderRules
+	}{{"Request", headers.Request}, {"Response", headers.Response}} {
+		if section.rules.Empty() {
+			continue
 		}
-		if tunnelID == "" {
-			fmt.Printf(" Tunnel '%s' not found.\n", nameOrID)
-			os.Exit(1)
+		fmt.Printf("   %s:\n", section.label)
+		for _, name := range section.rules.Remove {
+			fmt.Printf("     remove %s\n", name)
 		}
+		for _, name := range sortedKeys(section.rules.Set) {
+			fmt.Printf("     set    %s: %s\n", name, section.rules.Set[name])
+		}
+		for _, name := range sortedKeys(section.rules.Add) {
+			fmt.Printf("     add    %s: %s\n", name, section.rules.Add[name])
+		}
+	}
+}
 
-		// First, kill any local background daemon processes for this tunnel
-		killBackgroundProcess(tunnelID, tunnelName)
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
 
-		// Then send stop request to server API
-		client := &http.Client{Timeout: 10 * time.Second}
-		req, err := http.NewRequest("POST", fmt.Sprintf("%s/tunnels/%s/stop", defaultConfig.ServerURL, tunnelID), nil)
-		if err != nil {
-			log.Fatalf(" Failed to create stop request: %v", err)
-		}
-		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+// printRewrite shows a tunnel's path rewrite settings
+func printRewrite(tunnelName string, rewrite config.RewriteSettings) {
+	if rewrite.StripPrefix == "" && rewrite.AddPrefix == "" && len(rewrite.Rules) == 0 {
+		fmt.Printf(" No path rewriting for tunnel '%s'\n", tunnelName)
+		return
+	}
 
-		resp, err := client.Do(req)
-		if err != nil {
-			log.Fatalf(" Failed to stop tunnel: %v", err)
-		}
-		defer resp.Body.Close()
+	fmt.Printf(" Path rewriting for tunnel '%s':\n", tunnelName)
+	if rewrite.StripPrefix != "" {
+		fmt.Printf("   Strip prefix: %s\n", rewrite.StripPrefix)
+	}
+	if rewrite.AddPrefix != "" {
+		fmt.Printf("   Add prefix:   %s\n", rewrite.AddPrefix)
+	}
+	for i, rule := range rewrite.Rules {
+		fmt.Printf("   Rule %d:       %s -> %s\n", i+1, rule.Match, rule.Replace)
+	}
+}
 
-		if resp.StatusCode == http.StatusOK {
-			fmt.Printf(" ✓ Stopped tunnel '%s'\n", nameOrID)
-		} else if resp.StatusCode == http.StatusBadRequest {
-			fmt.Println(" ⚠ Tunnel is not currently active")
-		} else {
-			fmt.Printf(" ✗ Failed to stop tunnel (status: %d)\n", resp.StatusCode)
-		}
-	},
+// printIPFilter shows a tunnel's IP allow and deny lists
+func printIPFilter(tunnelName string, settings config.IPFilterSettings) {
+	if len(settings.Allow) == 0 && len(settings.Deny) == 0 {
+		fmt.Printf(" No IP restrictions for tunnel '%s'\n", tunnelName)
+		return
+	}
+
+	fmt.Printf(" IP filter for tunnel '%s':\n", tunnelName)
+	if len(settings.Allow) > 0 {
+		fmt.Printf("   Allow: %s\n", strings.Join(settings.Allow, ", "))
+	} else {
+		fmt.Println("   Allow: everyone not denied")
+	}
+	if len(settings.Deny) > 0 {
+		fmt.Printf("   Deny:  %s\n", strings.Join(settings.Deny, ", "))
+	}
 }
 
-func init() {
-	tunnelCmd.AddCommand(listCmd)
-	tunnelCmd.AddCommand(runCmd)
-	tunnelCmd.AddCommand(statusCmd)
-	tunnelCmd.AddCommand(stopCmd)
+// printPathFilter shows a tunnel's method and path allow and deny rules
+func printPathFilter(tunnelName string, settings config.PathFilterSettings) {
+	if len(settings.Allow) == 0 && len(settings.Deny) == 0 {
+		fmt.Printf(" No path restrictions for tunnel '%s'\n", tunnelName)
+		return
+	}
 
-	// Flags for "run"
-	runCmd.Flags().Bool("background", false, "Run tunnel in background")
-	// runCmd.Flags().Bool("auto-start", false, "Mark tunnel to auto-start on boot (requires service)")
+	fmt.Printf(" Path filter for tunnel '%s':\n", tunnelName)
+	if len(settings.Allow) > 0 {
+		fmt.Printf("   Allow: %s\n", strings.Join(settings.Allow, ", "))
+	} else {
+		fmt.Println("   Allow: every request not denied")
+	}
+	if len(settings.Deny) > 0 {
+		fmt.Printf("   Deny:  %s\n", strings.Join(settings.Deny, ", "))
+	}
+}
 
-	// autostart subcommand
-	autostartCmd := &cobra.Command{
-		Use:    "autostart [tunnel-name-or-id] [enable|disable]",
-		Short:  "Enable or disable auto-start for a tunnel",
-		Args:   cobra.ExactArgs(2),
-		Hidden: true, // Hide from help
-		Run: func(cmd *cobra.Command, args []string) {
-			nameOrID := args[0]
-			action := args[1]
+// printHeaderFilter shows a tunnel's header allow and deny rules
+func printHeaderFilter(tunnelName string, settings config.HeaderFilterSettings) {
+	if len(settings.Allow) == 0 && len(settings.Deny) == 0 {
+		fmt.Printf(" No header restrictions for tunnel '%s'\n", tunnelName)
+		return
+	}
 
-			defaultConfig := config.Load()
-			manager := service.NewManager(defaultConfig)
+	fmt.Printf(" Header filter for tunnel '%s':\n", tunnelName)
+	if len(settings.Allow) > 0 {
+		fmt.Printf("   Allow: %s\n", strings.Join(settings.Allow, "; "))
+	} else {
+		fmt.Println("   Allow: every request not denied")
+	}
+	if len(settings.Deny) > 0 {
+		fmt.Printf("   Deny:  %s\n", strings.Join(settings.Deny, "; "))
+	}
+}
 
-			// Must be authenticated to resolve tunnel and persist
-			if !manager.IsAuthenticated() {
-				fmt.Println(" You are not logged in. Please run 'skyport login' first.")
-				os.Exit(1)
-			}
+// addEntries appends entries that are not already in list
+func addEntries(list, entries []string) []string {
+	for _, entry := range entries {
+		if !slices.Contains(list, entry) {
+			list = append(list, entry)
+		}
+	}
+	return list
+}
 
-			// Sync tunnels so we have local IDs mapping
-			if err := manager.SyncTunnelsFromServer(); err != nil {
-				log.Printf(" Warning: Failed to sync tunnels from server: %v", err)
-			}
+// removeEntries returns list without entries
+func removeEntries(list, entries []string) []string {
+	var kept []string
+	for _, existing := range list {
+		if !slices.Contains(entries, existing) {
+			kept = append(kept, existing)
+		}
+	}
+	return kept
+}
 
-			// Find tunnel ID by name or ID in local config
-			tunnels, err := manager.GetTunnelList()
-			if err != nil {
-				log.Fatalf(" Failed to load tunnels: %v", err)
-			}
+var accessLogCmd = &cobra.Command{
+	Use:   "access-log [tunnel-name-or-id] [enable|disable]",
+	Short: "Enable or disable the request access log for a tunnel",
+	Long: `Record every request proxied through a tunnel (time, client IP, method, path,
+status, bytes and duration) to ~/.skyport/logs/access/<tunnel>.log.
+Logs rotate at max_size_mb (default 10) keeping max_backups (default 5) old files.
 
-			var tunnelID string
-			for _, t := range tunnels {
-				if t.ID == nameOrID || t.Name == nameOrID {
-					tunnelID = t.ID
-					break
-				}
-			}
-			if tunnelID == "" {
-				fmt.Printf(" Tunnel '%s' not found.\n", nameOrID)
-				os.Exit(1)
-			}
+Example:
+  skyport tunnel access-log myapp enable
+  skyport tunnel access-log myapp enable --format json
+  skyport tunnel access-log myapp disable`,
+	Args:        cobra.ExactArgs(2),
+	Annotations: map[string]string{offlineAnnotation: "true"},
+	Run: func(cmd *cobra.Command, args []string) {
+		nameOrID := args[0]
+		format, _ := cmd.Flags().GetString("format")
+
+		var enable bool
+		switch args[1] {
+		case "enable":
+			enable = true
+		case "disable":
+			enable = false
+		default:
+			exitWithError(fmt.Errorf("action must be 'enable' or 'disable'"))
+		}
 
-			enable := false
-			switch action {
-			case "enable":
-				enable = true
-			case "disable":
-				enable = false
-			default:
-				fmt.Println(" Action must be 'enable' or 'disable'")
-				os.Exit(1)
-			}
+		if format != "" && format != accesslog.FormatApache && format != accesslog.FormatJSON {
+			exitWithError(fmt.Errorf("format must be 'apache' or 'json'"))
+		}
 
-			if err := manager.SetTunnelAutoStart(tunnelID, enable); err != nil {
-				log.Fatalf(" Failed to update auto-start: %v", err)
-			}
+		configManager := config.NewConfigManager()
+		tunnel := findLocalTunnel(configManager, nameOrID)
 
-			state := "disabled"
-			if enable {
-				state = "enabled"
-			}
-			fmt.Printf(" Auto-start %s for tunnel '%s'\n", state, nameOrID)
+		if err := configManager.SetTunnelAccessLog(tunnel.ID, enable, format); err != nil {
+			exitWithError(fmt.Errorf("failed to update access log setting: %w", err))
+		}
 
-			if enable {
-				fmt.Println(" Note: To start on boot, install and start the service:")
-				fmt.Println("   skyport service install && skyport service start")
-			}
-		},
-	}
-	tunnelCmd.AddCommand(autostartCmd)
+		if !enable {
+			fmt.Printf(" Access log disabled for tunnel '%s'\n", tunnel.Name)
+			return
+		}
+
+		path, _ := config.AccessLogPath(tunnel)
+		fmt.Printf(" Access log enabled for tunnel '%s'\n", tunnel.Name)
+		fmt.Printf("   File: %s\n", path)
+		fmt.Println(" Takes effect the next time the tunnel connects.")
+	},
 }
 
 func runList(cmd *cobra.Command, args []string) {
@@ -216,15 +1467,13 @@ func runList(cmd *cobra.Command, args []string) {
 
 	// Check if user is authenticated using unified auth system
 	if !authManager.IsAuthenticated() {
-		fmt.Println(" You are not logged in. Please run 'skyport login' first.")
-		os.Exit(1)
+		exitWithError(apperr.ErrNotAuthenticated)
 	}
 
 	// Get user data from unified auth system
 	userData, err := authManager.LoadCredentials()
 	if err != nil {
-		fmt.Println(" Your session has expired. Please run 'skyport login' again.")
-		os.Exit(1)
+		exitWithError(explainError(err, apperr.ErrSessionExpired))
 	}
 
 	if verbose {
@@ -234,13 +1483,30 @@ func runList(cmd *cobra.Command, args []string) {
 	// Prefer server as source of truth for status
 	token, err := authManager.GetValidToken()
 	if err != nil {
-		fmt.Println(" Your session has expired. Please run 'skyport login' again.")
-		os.Exit(1)
+		exitWithError(explainError(err, apperr.ErrSessionExpired))
 	}
 
-	tunnelsFromServer, err := authManager.FetchTunnels(token)
+	list, err := listTunnels(authManager, token, false)
 	if err != nil {
-		log.Fatalf(" Failed to get tunnel list: %v", err)
+		exitWithError(explainError(err, apperr.ErrServerUnreachable))
+	}
+	tunnelsFromServer := withLocalSettings(list.Tunnels)
+
+	filters, _ := cmd.Flags().GetStringArray("filter")
+	selector, err := parseLabelFilters(filters)
+	if err != nil {
+		exitWithError(err)
+	}
+	var tunnels []config.Tunnel
+	for _, tunnel := range tunnelsFromServer {
+		if tunnel.MatchesLabels(selector) {
+			tunnels = append(tunnels, tunnel)
+		}
+	}
+
+	if asJSON, _ := cmd.Flags().GetBool("json"); asJSON {
+		printTunnelsJSON(tunnels)
+		return
 	}
 
 	if len(tunnelsFromServer) == 0 {
@@ -248,15 +1514,19 @@ func runList(cmd *cobra.Command, args []string) {
 		fmt.Printf("   Create tunnels at: %s/dashboard\n", defaultConfig.WebURL)
 		return
 	}
+	if len(tunnels) == 0 {
+		fmt.Printf(" No tunnels with labels %s.\n", config.FormatLabels(selector))
+		return
+	}
 
-	fmt.Printf(" Found %d tunnel(s):\n\n", len(tunnelsFromServer))
+	fmt.Printf(" Found %d tunnel(s):\n\n", len(tunnels))
 
 	// Create a table writer for nice formatting
 	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
-	fmt.Fprintln(w, "NAME\tSUBDOMAIN\tLOCAL PORT\tSTATUS")
-	fmt.Fprintln(w, "----\t---------\t----------\t------")
+	fmt.Fprintln(w, "NAME\tSUBDOMAIN\tLOCAL PORT\tSTATUS\tLABELS")
+	fmt.Fprintln(w, "----\t---------\t----------\t------\t------")
 
-	for _, tunnel := range tunnelsFromServer {
+	for _, tunnel := range tunnels {
 		status := " Stopped"
 		if tunnel.IsActive {
 			status = " Running"
@@ -267,11 +1537,12 @@ func runList(cmd *cobra.Command, args []string) {
 		// 	autoStart = "Yes"
 		// }
 
-		fmt.Fprintf(w, "%s\t%s\t%d\t%s\n",
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\n",
 			tunnel.Name,
 			tunnel.Subdomain,
 			tunnel.LocalPort,
-			status)
+			status,
+			config.FormatLabels(tunnel.TunnelLabels()))
 	}
 
 	w.Flush()
@@ -281,6 +1552,14 @@ func runList(cmd *cobra.Command, args []string) {
 }
 
 func runTunnel(cmd *cobra.Command, args []string) {
+	if group, _ := cmd.Flags().GetString("group"); group != "" {
+		runGroup(cmd, group)
+		return
+	}
+	if manyTunnels(args) {
+		runSelected(cmd, args)
+		return
+	}
 	tunnelNameOrID := args[0]
 
 	fmt.Printf(" Starting tunnel: %s\n", tunnelNameOrID)
@@ -291,98 +1570,139 @@ func runTunnel(cmd *cobra.Command, args []string) {
 
 	// Check if user is authenticated using unified auth system
 	if !authManager.IsAuthenticated() {
-		fmt.Println(" ✗ You are not logged in. Please run 'skyport login' first.")
-		os.Exit(1)
+		exitWithError(apperr.ErrNotAuthenticated)
 	}
 
 	// Get token for server communication
 	token, err := authManager.GetValidToken()
 	if err != nil {
-		fmt.Println(" ✗ Your session has expired. Please run 'skyport login' again.")
-		os.Exit(1)
+		exitWithError(explainError(err, apperr.ErrSessionExpired))
 	}
 
-	// Get tunnels from server to find target tunnel
-	tunnelsFromServer, err := authManager.FetchTunnels(token)
-	if err != nil {
-		if config.IsDebugMode() {
-			log.Fatalf(" Failed to get tunnel list: %v", err)
-		} else {
-			fmt.Println(" ✗ Failed to connect to SkyPort server")
-			fmt.Println(" Please check your internet connection and try again")
-			os.Exit(1)
+	// Get tunnels from server (or the local cache) to find target tunnel
+	list, err := listTunnels(authManager, token, false)
+	if err == nil && list.Cached && !list.Stale {
+		// Whether the tunnel exists and is already running must be current
+		if t := findTunnel(list.Tunnels, tunnelNameOrID); t == nil || t.IsActive {
+			list, err = listTunnels(authManager, token, true)
 		}
 	}
-
-	var targetTunnel *config.Tunnel
-	for _, tunnel := range tunnelsFromServer {
-		if tunnel.Name == tunnelNameOrID || tunnel.ID == tunnelNameOrID {
-			targetTunnel = &tunnel
-			break
-		}
+	if err != nil {
+		exitWithError(explainError(err, apperr.ErrServerUnreachable))
 	}
 
+	targetTunnel := findTunnel(list.Tunnels, tunnelNameOrID)
+
 	if targetTunnel == nil {
-		fmt.Printf(" ✗ Tunnel '%s' not found.\n", tunnelNameOrID)
-		fmt.Println(" Use 'skyport tunnel list' to see available tunnels")
-		os.Exit(1)
+		exitWithError(apperr.ErrTunnelNotFound.Withf("Tunnel '%s' not found", tunnelNameOrID))
 	}
 
 	// Check if tunnel is already running on server
+	force, _ := cmd.Flags().GetBool("force")
 	if targetTunnel.IsActive {
-		fmt.Printf(" ⚠ Tunnel '%s' is already running\n", targetTunnel.Name)
-		fmt.Println(" Use 'skyport tunnel stop", targetTunnel.Name, "' to stop it first")
-		os.Exit(1)
+		if !force {
+			exitWithError(tunnelRunningError(targetTunnel.Name, tunnelOwner(targetTunnel)))
+		}
+		if owner := describeOwner(tunnelOwner(targetTunnel)); owner != "" {
+			fmt.Printf(" Taking over tunnel '%s', running%s\n", targetTunnel.Name, owner)
+		} else {
+			fmt.Printf(" Taking over tunnel '%s'\n", targetTunnel.Name)
+		}
 	}
 
+	// Local-only settings, such as a static directory, live in the local config
+	if appConfig, err := config.NewConfigManager().LoadConfig(); err == nil {
+		if localTunnel, exists := appConfig.Tunnels[targetTunnel.ID]; exists {
+			targetTunnel.Settings = localTunnel.Settings
+		}
+	}
+	warnStoppedDependencies(targetTunnel, list.Tunnels)
+
 	// Start tunnel
-	fmt.Printf(" Connecting %s (%s.%s → localhost:%d)\n",
+	fmt.Printf(" Connecting %s (%s.%s %s %s)\n",
 		targetTunnel.Name,
 		targetTunnel.Subdomain,
 		defaultConfig.TunnelDomain,
-		targetTunnel.LocalPort)
+		logger.Arrow(),
+		targetTunnel.LocalTarget())
+
+	advertise, _ := cmd.Flags().GetBool("mdns")
+	if advertise {
+		defaultConfig.GetSettings().MDNS.Enabled = true
+	}
 
 	// Create service manager and sync tunnels from server first
 	manager := service.NewManager(defaultConfig)
 
 	// Sync tunnels from server to local config before connecting
-	if err := manager.SyncTunnelsFromServer(); err != nil {
+	syncing := startProgress("Syncing tunnels from the server")
+	err = manager.SyncTunnelsFromServer()
+	syncing.Stop()
+	if err != nil {
 		log.Printf(" Warning: Failed to sync tunnels from server: %v", err)
-		// Continue anyway - the tunnel data is already available from FetchTunnels
+		// Continue anyway - the tunnel data is already available from the tunnel list
 	}
 
 	// Check flags
 	runInBackground, _ := cmd.Flags().GetBool("background")
 	// setAutoStart, _ := cmd.Flags().GetBool("auto-start")
+	ciMode, _ := cmd.Flags().GetBool("ci")
+	urlFile, _ := cmd.Flags().GetString("url-file")
+	connectTimeout, _ := cmd.Flags().GetDuration("connect-timeout")
+
+	waitForPort, _ := cmd.Flags().GetBool("wait-for-port")
+
+	if ciMode && runInBackground {
+		exitWithError(fmt.Errorf("--ci and --background cannot be used together"))
+	}
+	if waitForPort && ciMode {
+		exitWithError(fmt.Errorf("--ci and --wait-for-port cannot be used together"))
+	}
+	if waitForPort && targetTunnel.StaticSettings().Dir != "" {
+		exitWithError(fmt.Errorf("tunnel '%s' serves a directory, so there is no local port to wait for", targetTunnel.Name))
+	}
+
+	applyTTL(cmd, targetTunnel)
+	if expiry := targetTunnel.ExpirySettings(); !expiry.At.IsZero() {
+		fmt.Printf(" Tunnel expires %s\n", describeExpiry(targetTunnel))
+	}
 
 	if runInBackground {
 		// Start a detached background process that connects this tunnel now
 		exe, err := os.Executable()
 		if err != nil {
-			if config.IsDebugMode() {
-				log.Fatalf(" Failed to resolve executable path: %v", err)
-			} else {
-				fmt.Println(" ✗ Failed to start tunnel")
-				fmt.Println(" Please contact SkyPort support if this issue persists")
-				os.Exit(1)
-			}
+			exitWithError(apperr.ErrStartFailed.Wrap(fmt.Errorf("failed to resolve executable path: %w", err)))
 		}
 
 		// Create log file for background process (always create for debugging if needed)
 		logDir := os.TempDir()
 		logFile := fmt.Sprintf("%s/skyport-tunnel-%s.log", logDir, targetTunnel.Name)
+		if config.Instance() != "" {
+			logFile = fmt.Sprintf("%s/skyport-%s-tunnel-%s.log", logDir, config.Instance(), targetTunnel.Name)
+		}
 		logFd, err := os.OpenFile(logFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
 		if err != nil {
-			if config.IsDebugMode() {
-				log.Fatalf(" Failed to create log file: %v", err)
-			} else {
-				fmt.Println(" ✗ Failed to start tunnel")
-				fmt.Println(" Please contact SkyPort support if this issue persists")
-				os.Exit(1)
-			}
+			exitWithError(apperr.ErrStartFailed.Wrap(fmt.Errorf("failed to create log file: %w", err)))
 		}
 
-		cmd := exec.Command(exe, "daemon", "--connect-tunnel", targetTunnel.ID, "--foreground")
+		daemonArgs := []string{"daemon", "--connect-tunnel", targetTunnel.ID, "--foreground"}
+		if advertise {
+			daemonArgs = append(daemonArgs, "--mdns")
+		}
+		if waitForPort {
+			daemonArgs = append(daemonArgs, "--wait-for-port")
+		}
+		if force {
+			daemonArgs = append(daemonArgs, "--take-over")
+		}
+		if os.Geteuid() == 0 {
+			// The tunnel was started as root on purpose, as in the foreground
+			daemonArgs = append(daemonArgs, "--allow-root")
+		}
+		if config.Instance() != "" {
+			daemonArgs = append(daemonArgs, "--instance", config.Instance())
+		}
+		cmd := exec.Command(exe, daemonArgs...)
 		cmd.Stdout = logFd
 		cmd.Stderr = logFd
 		cmd.Stdin = nil
@@ -390,20 +1710,25 @@ func runTunnel(cmd *cobra.Command, args []string) {
 
 		if err := cmd.Start(); err != nil {
 			logFd.Close()
-			if config.IsDebugMode() {
-				log.Fatalf(" Failed to start background process: %v", err)
-			} else {
-				fmt.Println(" ✗ Failed to start tunnel")
-				fmt.Println(" Please contact SkyPort support if this issue persists")
-				os.Exit(1)
-			}
+			exitWithError(apperr.ErrStartFailed.Wrap(fmt.Errorf("failed to start background process: %w", err)))
 		}
 
 		// Close the file descriptor in parent process (child process keeps it open)
 		logFd.Close()
 
+		// Record the process so 'tunnel stop' and 'tunnel status' can find it
+		if err := state.RegisterProcess(state.BackgroundProcess{
+			PID:        cmd.Process.Pid,
+			TunnelID:   targetTunnel.ID,
+			TunnelName: targetTunnel.Name,
+			StartedAt:  time.Now(),
+			LogFile:    logFile,
+		}); err != nil {
+			logger.Debug("Failed to record background process: %v", err)
+		}
+
 		// Show clean output to users
-		fmt.Printf(" ✓ Started background process (pid %d) for tunnel '%s'\n", cmd.Process.Pid, targetTunnel.Name)
+		fmt.Printf(" %s Started background process (pid %d) for tunnel '%s'\n", logger.SuccessMark(), cmd.Process.Pid, targetTunnel.Name)
 
 		// Only show log file location in debug mode
 		if config.IsDebugMode() {
@@ -415,29 +1740,90 @@ func runTunnel(cmd *cobra.Command, args []string) {
 		return
 	}
 
-	if err := manager.ConnectTunnel(targetTunnel.ID, false); err != nil {
-		if config.IsDebugMode() {
-			log.Fatalf(" Failed to start tunnel: %v", err)
-		} else {
-			fmt.Println(" ✗ Failed to start tunnel")
-			fmt.Println(" Please check that your local service is running and try again")
-			fmt.Println(" If the issue persists, contact SkyPort support")
-			os.Exit(1)
+	publicURL := fmt.Sprintf("http://%s.%s", targetTunnel.Subdomain, defaultConfig.TunnelDomain)
+	if force {
+		manager.AllowTakeover(targetTunnel.ID)
+	}
+
+	// With --wait-for-port the watch connects the tunnel once the port opens
+	var portChanges <-chan service.PortChange
+	if waitForPort {
+		portChanges, err = manager.WatchPort(targetTunnel.ID)
+		if err != nil {
+			exitWithError(apperr.ErrStartFailed.Wrap(err))
 		}
+		fmt.Printf(" Waiting for something to listen on %s before connecting\n", targetTunnel.LocalAddress())
+		fmt.Printf(" Your service will be at: %s\n", publicURL)
+	} else {
+		connecting := startProgress("Connecting to the SkyPort server")
+		if err := connectWithTimeout(manager, targetTunnel.ID, ciMode, connectTimeout); err != nil {
+			connecting.Stop()
+			explained := explainConnectError(err, targetTunnel)
+			if ciMode {
+				// CI logs are read after the fact, so always show the cause
+				explained.Message = fmt.Sprintf("%s: %v", explained.Message, err)
+			}
+			exitWithError(explained)
+		}
+
+		// The server now reports the tunnel as running
+		config.NewConfigManager().InvalidateTunnelCache()
+
+		connecting.Done(fmt.Sprintf("Tunnel '%s' started successfully", targetTunnel.Name))
+		fmt.Printf(" %s Access your service at: %s\n", logger.SuccessMark(), publicURL)
+	}
+	if showQR, _ := cmd.Flags().GetBool("qr"); showQR {
+		printQRCode(publicURL)
+	}
+	if advertise {
+		fmt.Printf(" %s Advertising on the local network as 'skyport: %s' (mDNS)\n", logger.SuccessMark(), targetTunnel.Name)
+	}
+	if err := writeCIOutputs(publicURL, urlFile); err != nil {
+		manager.Shutdown()
+		exitWithError(err)
+	}
+	if !ciMode {
+		fmt.Println(" Press Ctrl+C to stop the tunnel")
 	}
 
-	fmt.Printf(" ✓ Tunnel '%s' started successfully\n", targetTunnel.Name)
-	fmt.Printf(" ✓ Access your service at: http://%s.%s\n", targetTunnel.Subdomain, defaultConfig.TunnelDomain)
-	fmt.Println(" Press Ctrl+C to stop the tunnel")
+	// Reconnect straight away if the machine sleeps and wakes while running
+	manager.WatchForResume()
+
+	// Serve live stats for 'skyport tunnel top'
+	manager.StartControlServer()
 
 	// Keep the tunnel running until interrupted
 	// Set up signal handling for graceful shutdown
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
 
+	// In CI the tunnel also ends with the step that started it
+	var ciExit <-chan string
+	if ciMode {
+		ciExit = watchCIExit()
+	}
+
 	// Wait for interrupt signal
-	<-sigChan
-	fmt.Println("\n Stopping tunnel...")
+wait:
+	for {
+		select {
+		case <-sigChan:
+			fmt.Println("\n Stopping tunnel...")
+			break wait
+		case reason := <-ciExit:
+			fmt.Printf(" Stopping tunnel (%s)...\n", reason)
+			break wait
+		case event := <-manager.Stopped():
+			fmt.Printf(" Stopping tunnel (%s)...\n", event.Reason)
+			break wait
+		case change, ok := <-portChanges:
+			if !ok {
+				portChanges = nil
+				continue
+			}
+			printPortChange(targetTunnel, change)
+		}
+	}
 
 	// Disconnect the tunnel
 	if err := manager.DisconnectTunnel(targetTunnel.ID); err != nil {
@@ -445,8 +1831,147 @@ func runTunnel(cmd *cobra.Command, args []string) {
 			log.Printf(" Warning: Failed to disconnect tunnel: %v", err)
 		}
 	}
+	manager.Shutdown()
+	config.NewConfigManager().InvalidateTunnelCache()
+
+	fmt.Printf(" %s Tunnel stopped.\n", logger.SuccessMark())
+}
+
+// printPortChange reports a --wait-for-port tunnel following its local port
+func printPortChange(t *config.Tunnel, change service.PortChange) {
+	switch {
+	case change.Listening && change.Err != nil:
+		fmt.Printf(" %s Something is listening on %s, but the tunnel failed to connect: %v\n", logger.ErrorMark(), change.Address, change.Err)
+	case change.Listening:
+		// The server now reports the tunnel as running
+		config.NewConfigManager().InvalidateTunnelCache()
+		fmt.Printf(" %s %s is listening; tunnel '%s' connected\n", logger.SuccessMark(), change.Address, t.Name)
+	case change.Err != nil:
+		fmt.Printf(" %s Nothing is listening on %s, but the tunnel failed to disconnect: %v\n", logger.ErrorMark(), change.Address, change.Err)
+	default:
+		fmt.Printf(" %s Nothing is listening on %s; tunnel '%s' disconnected until it is back\n", logger.WarningMark(), change.Address, t.Name)
+	}
+}
+
+// printQRCode shows a URL as a QR code so it can be opened on a phone
+func printQRCode(url string) {
+	code, err := qr.Encode(url)
+	if err != nil {
+		fmt.Printf(" %s Cannot show a QR code: %v\n", logger.WarningMark(), err)
+		return
+	}
+	fmt.Println()
+	fmt.Print(code.Terminal())
+	fmt.Println()
+}
+
+// connectWithTimeout connects a tunnel, giving up after timeout when bounded
+// is set (the connection attempt is abandoned, as the process exits)
+func connectWithTimeout(manager *service.Manager, tunnelID string, bounded bool, timeout time.Duration) error {
+	if !bounded {
+		return manager.ConnectTunnel(tunnelID, false)
+	}
+
+	result := make(chan error, 1)
+	go func() {
+		result <- manager.ConnectTunnel(tunnelID, false)
+	}()
+
+	select {
+	case err := <-result:
+		return err
+	case <-time.After(timeout):
+		return fmt.Errorf("tunnel did not connect within %s", timeout)
+	}
+}
+
+// listTunnels returns the tunnel list, from the local cache while it is fresh
+// unless refresh or --refresh is given. If the server cannot be reached, an
+// older cached list is used with a warning.
+func listTunnels(authManager *auth.AuthManager, token string, refresh bool) (*auth.TunnelList, error) {
+	fetching := startProgress("Fetching your tunnels")
+	list, err := authManager.ListTunnels(token, refresh || refreshTunnels)
+	fetching.Stop()
+	if err != nil {
+		return nil, err
+	}
+	if list.Stale {
+		fmt.Printf(" %s SkyPort server unreachable; using the tunnel list from %s ago\n",
+			logger.WarningMark(), time.Since(list.FetchedAt).Round(time.Second))
+		logger.Debug("Failed to refresh tunnel list: %v", list.Err)
+	}
+	return list, nil
+}
+
+// withLocalSettings returns the tunnels with the settings kept for them in
+// the local config
+func withLocalSettings(tunnels []config.Tunnel) []config.Tunnel {
+	appConfig, err := config.NewConfigManager().LoadConfig()
+	if err != nil {
+		return tunnels
+	}
+
+	merged := make([]config.Tunnel, len(tunnels))
+	for i, tunnel := range tunnels {
+		if local, ok := appConfig.Tunnels[tunnel.ID]; ok && local.Settings != nil {
+			tunnel.Settings = local.Settings
+		}
+		merged[i] = tunnel
+	}
+	return merged
+}
+
+// listedTunnel is a tunnel as printed by 'tunnel list --json'
+type listedTunnel struct {
+	ID        string            `json:"id"`
+	Name      string            `json:"name"`
+	Subdomain string            `json:"subdomain"`
+	LocalPort int               `json:"local_port"`
+	Running   bool              `json:"running"`
+	Labels    map[string]string `json:"labels"`
+}
+
+// printTunnelsJSON prints tunnels as a JSON array
+func printTunnelsJSON(tunnels []config.Tunnel) {
+	listed := make([]listedTunnel, 0, len(tunnels))
+	for _, tunnel := range tunnels {
+		labels := tunnel.TunnelLabels()
+		if labels == nil {
+			labels = map[string]string{}
+		}
+		listed = append(listed, listedTunnel{
+			ID:        tunnel.ID,
+			Name:      tunnel.Name,
+			Subdomain: tunnel.Subdomain,
+			LocalPort: tunnel.LocalPort,
+			Running:   tunnel.IsActive,
+			Labels:    labels,
+		})
+	}
+	data, _ := json.MarshalIndent(listed, "", "  ")
+	fmt.Println(string(data))
+}
+
+// findTunnel finds a tunnel by name or ID
+func findTunnel(tunnels []config.Tunnel, nameOrID string) *config.Tunnel {
+	for i := range tunnels {
+		if tunnels[i].Name == nameOrID || tunnels[i].ID == nameOrID {
+			return &tunnels[i]
+		}
+	}
+	return nil
+}
 
-	fmt.Println(" ✓ Tunnel stopped.")
+// formatErrorRate renders an error summary as "errors/requests (rate)"
+func formatErrorRate(summary metrics.ErrorSummary) string {
+	if summary.Requests == 0 {
+		return "0/0"
+	}
+	rate := fmt.Sprintf("%d/%d (%.1f%%)", summary.ServerErrors, summary.Requests, 100*summary.ErrorRate())
+	if summary.BadGateway > 0 {
+		rate += fmt.Sprintf(", %d bad gateway", summary.BadGateway)
+	}
+	return rate
 }
 
 func runStatus(cmd *cobra.Command, args []string) {
@@ -460,20 +1985,24 @@ func runStatus(cmd *cobra.Command, args []string) {
 
 	// Check if user is authenticated using unified auth system
 	if !authManager.IsAuthenticated() {
-		fmt.Println(" You are not logged in. Please run 'skyport login' first.")
-		os.Exit(1)
+		exitWithError(apperr.ErrNotAuthenticated)
 	}
 
 	// Prefer server as source of truth for status
 	token, err := authManager.GetValidToken()
 	if err != nil {
-		fmt.Println(" Your session has expired. Please run 'skyport login' again.")
-		os.Exit(1)
+		exitWithError(explainError(err, apperr.ErrSessionExpired))
 	}
 
-	tunnelsFromServer, err := authManager.FetchTunnels(token)
+	list, err := listTunnels(authManager, token, false)
 	if err != nil {
-		log.Fatalf(" Failed to get tunnel list: %v", err)
+		exitWithError(explainError(err, apperr.ErrServerUnreachable))
+	}
+	tunnelsFromServer := withLocalSettings(list.Tunnels)
+
+	if len(args) == 1 {
+		printTunnelDetail(defaultConfig, tunnelsFromServer, args[0])
+		return
 	}
 
 	// Filter for active tunnels (server state)
@@ -487,63 +2016,144 @@ func runStatus(cmd *cobra.Command, args []string) {
 	if len(activeTunnels) == 0 {
 		fmt.Println(" No tunnels are currently running.")
 		fmt.Println(" Use 'skyport tunnel run <name>' to start a tunnel")
+		printBackgroundProcesses()
 		return
 	}
 
 	fmt.Printf(" Active tunnels (%d running):\n\n", len(activeTunnels))
 
 	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
-	fmt.Fprintln(w, "NAME\tSUBDOMAIN\tLOCAL PORT\tURL")
-	fmt.Fprintln(w, "----\t---------\t----------\t---")
+	fmt.Fprintln(w, "NAME\tSUBDOMAIN\tLOCAL PORT\tURL\tFORWARD p50/p95/p99\tRTT avg/min/max\tQUALITY\t5xx (15m)\tEXPIRES IN")
+	fmt.Fprintln(w, "----\t---------\t----------\t---\t-------------------\t---------------\t-------\t---------\t----------")
+
+	// Latency comes from the process running each tunnel on this machine;
+	// tunnels running elsewhere (or not yet reporting) show "-"
+	maxAge := 3 * defaultConfig.GetSettings().Intervals.PingInterval.Duration
+	recentErrors := make(map[string][]metrics.ErrorSample)
+	qualityIssues := make(map[string]metrics.ConnectionQuality)
 
 	for _, tunnel := range activeTunnels {
 		url := fmt.Sprintf("http://%s.%s", tunnel.Subdomain, defaultConfig.TunnelDomain)
-		fmt.Fprintf(w, "%s\t%s\t%d\t%s\n",
+
+		forwarding, roundTrip, quality, errors := "-", "-", "-", "-"
+		if ts, err := state.ReadTunnel(tunnel.ID, maxAge); err == nil {
+			forwarding = ts.Forwarding.String()
+			roundTrip = ts.RoundTrip.Range()
+			if ts.Quality.Grade != "" {
+				quality = ts.Quality.Grade
+			}
+			if ts.Quality.Reason != "" {
+				qualityIssues[tunnel.Name] = ts.Quality
+			}
+			errors = formatErrorRate(ts.Errors)
+			if len(ts.Errors.Recent) > 0 {
+				recentErrors[tunnel.Name] = ts.Errors.Recent
+			}
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\t%s\t%s\t%s\t%s\n",
 			tunnel.Name,
 			tunnel.Subdomain,
 			tunnel.LocalPort,
-			url)
+			url,
+			forwarding,
+			roundTrip,
+			quality,
+			errors,
+			expiryCountdown(&tunnel))
 	}
 
 	w.Flush()
 	fmt.Println()
+	fmt.Println("  FORWARD is time spent in your local service; RTT is the round trip to the SkyPort server")
+	fmt.Println("  QUALITY grades the connection to the server over the last 5 minutes")
+
+	for name, quality := range qualityIssues {
+		fmt.Printf("\n %s Connection to the server for %s is %s: %s\n", logger.WarningMark(), name, quality.Grade, quality.Reason)
+	}
+
+	for name, samples := range recentErrors {
+		fmt.Printf("\n Recent errors for %s:\n", name)
+		for i, sample := range samples {
+			if i == 3 {
+				break
+			}
+			fmt.Printf("   %s  %d %s %s: %s (request %s)\n",
+				sample.Time.Format("15:04:05"), sample.Status, sample.Method, sample.Path, sample.Message, sample.RequestID)
+		}
+	}
+	printBackgroundProcesses()
 	fmt.Println("  Use Ctrl+C in the terminal running the tunnel to stop it")
 }
 
-// killBackgroundProcess finds and kills any background daemon process for the given tunnel
+// killBackgroundProcess stops the background process recorded for the given
+// tunnel, if it is still running
 func killBackgroundProcess(tunnelID string, tunnelName string) {
-	// Use ps to find processes matching "skyport daemon --connect-tunnel <tunnelID>"
-	out, err := exec.Command("ps", "aux").Output()
+	p, err := state.FindProcess(tunnelID)
 	if err != nil {
-		logger.Debug("Failed to list processes: %v", err)
+		logger.Debug("Failed to read background process record: %v", err)
+		return
+	}
+	if p == nil {
 		return
 	}
 
-	lines := strings.Split(string(out), "\n")
-	for _, line := range lines {
-		// Look for our daemon process with the tunnel ID
-		if strings.Contains(line, "skyport") && strings.Contains(line, "daemon") &&
-			strings.Contains(line, "--connect-tunnel") && strings.Contains(line, tunnelID) {
-			// Extract PID (second field in ps aux output)
-			fields := strings.Fields(line)
-			if len(fields) < 2 {
-				continue
-			}
-			pid := fields[1]
+	if !backgroundProcessRunning(p) {
+		logger.Debug("Background process (pid %d) for tunnel '%s' has exited", p.PID, tunnelName)
+		state.UnregisterProcess(tunnelID, p.PID)
+		return
+	}
 
-			logger.Debug("Found background process (pid %s) for tunnel '%s', stopping it...", pid, tunnelName)
+	logger.Debug("Found background process (pid %d) for tunnel '%s', stopping it...", p.PID, tunnelName)
+	if err := terminateProcess(p.PID); err != nil {
+		logger.Debug("Failed to stop process %d: %v", p.PID, err)
+		return
+	}
 
-			// Kill the process
-			killCmd := exec.Command("kill", pid)
-			if err := killCmd.Run(); err != nil {
-				logger.Debug("Failed to stop process %s: %v", pid, err)
-			} else {
-				logger.Info("Stopped background process for tunnel '%s'", tunnelName)
-				// Give it a moment to terminate
-				time.Sleep(500 * time.Millisecond)
-			}
-		}
+	// Give it a moment to disconnect and exit
+	deadline := time.Now().Add(5 * time.Second)
+	for processRunning(p.PID) && time.Now().Before(deadline) {
+		time.Sleep(100 * time.Millisecond)
+	}
+	state.UnregisterProcess(tunnelID, p.PID)
+	logger.Info("Stopped background process for tunnel '%s'", tunnelName)
+}
+
+// backgroundProcessRunning reports whether a recorded background process is
+// still the skyport process that was started. Once started, the daemon
+// serves a control socket; a PID without one after the startup grace period
+// has been reused by an unrelated process.
+func backgroundProcessRunning(p *state.BackgroundProcess) bool {
+	if !processRunning(p.PID) {
+		return false
 	}
+	return control.Listening(p.PID) || time.Since(p.StartedAt) < time.Minute
 }
 
-// Note: PID file tracking removed - all tunnel state is now managed by the server
+// printBackgroundProcesses lists the background processes started with
+// 'tunnel run --background' that are still running, forgetting the rest
+func printBackgroundProcesses() {
+	processes, err := state.BackgroundProcesses()
+	if err != nil {
+		logger.Debug("Failed to read background process records: %v", err)
+		return
+	}
+
+	var running []state.BackgroundProcess
+	for _, p := range processes {
+		if backgroundProcessRunning(&p) {
+			running = append(running, p)
+		} else {
+			state.UnregisterProcess(p.TunnelID, p.PID)
+		}
+	}
+	if len(running) == 0 {
+		return
+	}
+
+	fmt.Printf("\n Background processes on this machine (%d):\n", len(running))
+	for _, p := range running {
+		fmt.Printf("   %s  pid %d, started %s ago\n", p.TunnelName, p.PID, time.Since(p.StartedAt).Round(time.Second))
+	}
+	fmt.Println("  Use 'skyport tunnel stop <name>' to stop a background tunnel")
+}
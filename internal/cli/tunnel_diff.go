@@ -0,0 +1,179 @@
+package cli
+
+import (
+	"fmt"
+	"skyport-agent/internal/apperr"
+	"skyport-agent/internal/auth"
+	"skyport-agent/internal/config"
+	"skyport-agent/internal/logger"
+	"sort"
+	"strconv"
+
+	"github.com/spf13/cobra"
+)
+
+var diffCmd = &cobra.Command{
+	Use:   "diff",
+	Short: "Compare the local tunnel config with the server",
+	Long: `Compare the tunnels in ~/.skyport/skyport.json with the ones on the SkyPort
+server: tunnels the server has that are not in the local config yet (+),
+tunnels deleted on the server that are still kept locally (-), and tunnels
+whose name, subdomain, local port or labels differ (~). Local overrides such
+as a target address or labels the server could not store are listed too.
+
+Syncing only adds and updates tunnels; --prune removes the local tunnels
+that were deleted on the server, along with their settings.
+
+Example:
+  skyport tunnel diff
+  skyport tunnel diff --prune`,
+	Args: cobra.NoArgs,
+	Run:  runDiff,
+}
+
+func init() {
+	diffCmd.Flags().Bool("prune", false, "Remove local tunnels that were deleted on the server")
+	tunnelCmd.AddCommand(diffCmd)
+}
+
+// tunnelChange is a field that differs between a tunnel in the local config
+// and on the server
+type tunnelChange struct {
+	field  string
+	local  string
+	server string
+}
+
+func runDiff(cmd *cobra.Command, args []string) {
+	prune, _ := cmd.Flags().GetBool("prune")
+
+	authManager := auth.NewAuthManager(config.Load())
+	if !authManager.IsAuthenticated() {
+		exitWithError(apperr.ErrNotAuthenticated)
+	}
+	token, err := authManager.GetValidToken()
+	if err != nil {
+		exitWithError(explainError(err, apperr.ErrSessionExpired))
+	}
+
+	// A cached list could hide deletions, so always ask the server
+	fetching := startProgress("Fetching your tunnels")
+	serverTunnels, err := authManager.FetchTunnels(token)
+	fetching.Stop()
+	if err != nil {
+		exitWithError(explainError(err, apperr.ErrServerUnreachable))
+	}
+
+	configManager := config.NewConfigManager()
+	appConfig, err := configManager.LoadConfig()
+	if err != nil {
+		exitWithError(fmt.Errorf("failed to load config: %w", err))
+	}
+
+	onServer := make(map[string]bool, len(serverTunnels))
+	differences := 0
+	for i := range serverTunnels {
+		server := &serverTunnels[i]
+		onServer[server.ID] = true
+
+		local, ok := appConfig.Tunnels[server.ID]
+		if !ok {
+			fmt.Printf(" + %s  only on the server (port %d); added locally on the next sync\n", server.Name, server.LocalPort)
+			differences++
+			continue
+		}
+
+		changes := diffTunnel(local, server)
+		overrides := localOverrides(local)
+		if len(changes) == 0 && len(overrides) == 0 {
+			continue
+		}
+		if len(changes) > 0 {
+			fmt.Printf(" ~ %s\n", server.Name)
+			differences++
+		} else {
+			fmt.Printf("   %s\n", server.Name)
+		}
+		for _, change := range changes {
+			fmt.Printf("     %s: %s locally, %s on the server\n", change.field, change.local, change.server)
+		}
+		for _, override := range overrides {
+			fmt.Printf("     local override: %s\n", override)
+		}
+	}
+
+	var stale []*config.Tunnel
+	for _, local := range appConfig.Tunnels {
+		if !onServer[local.ID] {
+			stale = append(stale, local)
+		}
+	}
+	sort.Slice(stale, func(i, j int) bool { return stale[i].Name < stale[j].Name })
+	for _, local := range stale {
+		fmt.Printf(" - %s  deleted on the server, still in the local config\n", local.Name)
+		differences++
+	}
+
+	if differences == 0 {
+		fmt.Printf(" %s The local config matches the server (%d tunnels)\n", logger.SuccessMark(), len(serverTunnels))
+		return
+	}
+	if len(stale) == 0 {
+		return
+	}
+	if !prune {
+		fmt.Printf(" Remove the %d deleted tunnel(s) from the local config with 'skyport tunnel diff --prune'\n", len(stale))
+		return
+	}
+
+	for _, local := range stale {
+		if err := configManager.RemoveTunnel(local.ID); err != nil {
+			exitWithError(fmt.Errorf("failed to remove tunnel '%s': %w", local.Name, err))
+		}
+		fmt.Printf(" %s Removed tunnel '%s' from the local config\n", logger.SuccessMark(), local.Name)
+	}
+	configManager.InvalidateTunnelCache()
+}
+
+// diffTunnel lists the server-owned fields of a tunnel that differ between
+// the local config and the server
+func diffTunnel(local, server *config.Tunnel) []tunnelChange {
+	var changes []tunnelChange
+	if local.Name != server.Name {
+		changes = append(changes, tunnelChange{"name", local.Name, server.Name})
+	}
+	if local.Subdomain != server.Subdomain {
+		changes = append(changes, tunnelChange{"subdomain", local.Subdomain, server.Subdomain})
+	}
+	if local.LocalPort != server.LocalPort {
+		changes = append(changes, tunnelChange{"local port", strconv.Itoa(local.LocalPort), strconv.Itoa(server.LocalPort)})
+	}
+	if localLabels, serverLabels := config.FormatLabels(local.Labels), config.FormatLabels(server.Labels); localLabels != serverLabels {
+		changes = append(changes, tunnelChange{"labels", orNone(localLabels), orNone(serverLabels)})
+	}
+	return changes
+}
+
+// localOverrides describes local settings that take the place of what the
+// server says about a tunnel
+func localOverrides(t *config.Tunnel) []string {
+	if t.Settings == nil {
+		return nil
+	}
+	var overrides []string
+	if t.Settings.Target != "" {
+		overrides = append(overrides, fmt.Sprintf("forwards to %s instead of port %d", t.Settings.Target, t.LocalPort))
+	}
+	if t.Settings.Labels != nil {
+		overrides = append(overrides, fmt.Sprintf("labels %s replace the server's", orNone(config.FormatLabels(t.Settings.Labels))))
+	}
+	return overrides
+}
+
+// orNone shows an empty value as "none"
+func orNone(value string) string {
+	if value == "" {
+		return "none"
+	}
+	return value
+}
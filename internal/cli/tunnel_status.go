@@ -0,0 +1,184 @@
+package cli
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"skyport-agent/internal/apperr"
+	"skyport-agent/internal/config"
+	"skyport-agent/internal/journal"
+	"skyport-agent/internal/logger"
+	"skyport-agent/internal/state"
+	"skyport-agent/internal/tunnel"
+	"sort"
+	"strings"
+	"time"
+)
+
+// printTunnelDetail shows everything known about one tunnel: its server
+// state, the runtime state published by the process running it on this
+// machine, its local target and its recent history
+func printTunnelDetail(cfg *config.Config, tunnels []config.Tunnel, nameOrID string) {
+	t := findTunnel(tunnels, nameOrID)
+	if t == nil {
+		exitWithError(apperr.ErrTunnelNotFound.Withf("Tunnel '%s' not found", nameOrID))
+	}
+
+	// Local-only settings, such as the target address, live in the local config
+	if appConfig, err := config.NewConfigManager().LoadConfig(); err == nil {
+		if localTunnel, exists := appConfig.Tunnels[t.ID]; exists {
+			t.Settings = localTunnel.Settings
+		}
+	}
+
+	maxAge := 3 * cfg.GetSettings().Intervals.PingInterval.Duration
+	ts, _ := state.ReadTunnel(t.ID, maxAge)
+
+	fmt.Printf(" Tunnel %s\n\n", t.Name)
+	fmt.Printf("   ID:               %s\n", t.ID)
+	fmt.Printf("   URL:              http://%s.%s\n", t.Subdomain, cfg.TunnelDomain)
+	fmt.Printf("   Local target:     %s (%s)\n", t.LocalTarget(), checkLocalTarget(t))
+	if split := splitTarget(t); split != nil {
+		fmt.Printf("   Canary target:    %s, %d%% of requests (%s)\n", split.LocalAddress(), t.Settings.Split.Percent, checkLocalTarget(split))
+	}
+
+	busy := waitingOnServer(t)
+	switch {
+	case ts != nil:
+		where := fmt.Sprintf("pid %d", ts.PID)
+		if ts.Region != "" {
+			where += ", region " + ts.Region
+		}
+		fmt.Printf("   Status:           Running (%s)\n", where)
+		fmt.Printf("   Connected since:  %s (%s ago)\n", ts.ConnectedAt.Format("2006-01-02 15:04:05"), time.Since(ts.ConnectedAt).Round(time.Second))
+		fmt.Printf("   Uptime:           %s\n", time.Since(ts.StartedAt).Round(time.Second))
+		fmt.Printf("   Reconnects:       %d\n", ts.Reconnects)
+	case busy != nil:
+		fmt.Printf("   Status:           Server busy, waiting to reconnect (%s ago: %s)\n",
+			time.Since(busy.Time).Round(time.Second), busy.Reason)
+	case t.IsActive:
+		fmt.Println("   Status:           Running (not on this machine, or not reporting)")
+	default:
+		fmt.Println("   Status:           Stopped")
+	}
+	if !t.ExpirySettings().At.IsZero() {
+		fmt.Printf("   Expires:          %s\n", describeExpiry(t))
+	}
+
+	if disconnect := lastDisconnect(t); disconnect != nil {
+		reason := disconnect.Reason
+		if reason == "" {
+			reason = "no reason recorded"
+		}
+		fmt.Printf("   Last disconnect:  %s (%s ago): %s\n",
+			disconnect.Time.Format("2006-01-02 15:04:05"), time.Since(disconnect.Time).Round(time.Second), reason)
+	}
+
+	if ts == nil {
+		return
+	}
+
+	features := "none"
+	if len(ts.Features) > 0 {
+		features = strings.Join(ts.Features, ", ")
+	}
+	fmt.Printf("   Protocol:         %s\n", features)
+	fmt.Printf("   Connection:       %s\n", ts.Quality)
+	fmt.Printf("   Forwarding:       %s (p50/p95/p99)\n", ts.Forwarding)
+	fmt.Printf("   Round trip:       %s (p50/p95/p99)\n", ts.RoundTrip)
+	fmt.Printf("                     %s (avg/min/max over %d heartbeats)\n", ts.RoundTrip.Range(), ts.RoundTrip.Count)
+	fmt.Printf("   5xx (15m):        %s\n", formatErrorRate(ts.Errors))
+	sessions := fmt.Sprintf("%d open", ts.Sessions)
+	if max := t.WebSocketSettings().MaxSessions; max > 0 {
+		sessions += fmt.Sprintf(" (max %d)", max)
+	}
+	fmt.Printf("   WebSockets:       %s\n", sessions)
+
+	if len(ts.Denied) > 0 {
+		fmt.Println("\n Denied by header filter:")
+		rules := make([]string, 0, len(ts.Denied))
+		for rule := range ts.Denied {
+			rules = append(rules, rule)
+		}
+		sort.Strings(rules)
+		for _, rule := range rules {
+			fmt.Printf("   %-20s %d\n", rule, ts.Denied[rule])
+		}
+	}
+
+	if len(ts.Errors.Recent) > 0 {
+		fmt.Println("\n Recent errors:")
+		for _, sample := range ts.Errors.Recent {
+			fmt.Printf("   %s  %d %s %s: %s (request %s)\n",
+				sample.Time.Format("15:04:05"), sample.Status, sample.Method, sample.Path, sample.Message, sample.RequestID)
+		}
+	}
+}
+
+// checkLocalTarget reports whether the tunnel's local service (or static
+// directory) is there to receive requests
+func checkLocalTarget(t *config.Tunnel) string {
+	result, err := probeLocalTarget(t)
+	if err != nil {
+		return logger.ErrorMark() + " " + err.Error()
+	}
+	return logger.SuccessMark() + " " + result
+}
+
+// probeLocalTarget checks the tunnel's local service (or static directory),
+// describing the result
+func probeLocalTarget(t *config.Tunnel) (string, error) {
+	if dir := t.StaticSettings().Dir; dir != "" {
+		info, err := os.Stat(dir)
+		if err != nil {
+			return "", err
+		}
+		if !info.IsDir() {
+			return "", fmt.Errorf("not a directory")
+		}
+		return "directory exists", nil
+	}
+
+	start := time.Now()
+	conn, err := net.DialTimeout("tcp", t.LocalAddress(), 2*time.Second)
+	if err != nil {
+		return "", fmt.Errorf("not reachable: %w", err)
+	}
+	conn.Close()
+	return fmt.Sprintf("reachable in %s", time.Since(start).Round(10*time.Microsecond)), nil
+}
+
+// splitTarget returns a copy of the tunnel targeting the alternate
+// service of its traffic split, or nil if it has none
+func splitTarget(t *config.Tunnel) *config.Tunnel {
+	if t.Settings == nil || !t.Settings.Split.Enabled || t.StaticSettings().Dir != "" {
+		return nil
+	}
+	settings := *t.Settings
+	settings.Target = settings.Split.Target
+	split := *t
+	split.Settings = &settings
+	return &split
+}
+
+// lastDisconnect returns the tunnel's most recent journaled disconnect
+func lastDisconnect(t *config.Tunnel) *journal.Entry {
+	entries, err := journal.Read(journal.Filter{Tunnel: t.ID, Type: tunnel.EventDisconnected})
+	if err != nil || len(entries) == 0 {
+		return nil
+	}
+	return &entries[len(entries)-1]
+}
+
+// waitingOnServer returns the tunnel's latest journal entry if it is waiting
+// out a busy server, or nil
+func waitingOnServer(t *config.Tunnel) *journal.Entry {
+	entries, err := journal.Read(journal.Filter{Tunnel: t.ID, Since: time.Now().Add(-15 * time.Minute)})
+	if err != nil || len(entries) == 0 {
+		return nil
+	}
+	if last := &entries[len(entries)-1]; last.Type == tunnel.EventServerBusy {
+		return last
+	}
+	return nil
+}
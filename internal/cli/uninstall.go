@@ -3,19 +3,19 @@ package cli
 import (
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"runtime"
+	"skyport-agent/internal/auth"
 	"skyport-agent/internal/config"
+	"skyport-agent/internal/logger"
 	"skyport-agent/internal/service"
 
 	"github.com/spf13/cobra"
 )
 
 var (
-	forceUninstall   bool
-	keepConfig       bool
-	skipConfirmation bool
+	forceUninstall bool
+	keepConfig     bool
 )
 
 var uninstallAgentCmd = &cobra.Command{
@@ -34,7 +34,6 @@ This is different from 'skyport service uninstall' which only removes the servic
 func init() {
 	uninstallAgentCmd.Flags().BoolVarP(&forceUninstall, "force", "f", false, "Force uninstall without confirmation")
 	uninstallAgentCmd.Flags().BoolVar(&keepConfig, "keep-config", false, "Keep configuration files and credentials")
-	uninstallAgentCmd.Flags().BoolVarP(&skipConfirmation, "yes", "y", false, "Skip all confirmation prompts")
 }
 
 func runCompleteUninstall(cmd *cobra.Command, args []string) {
@@ -52,13 +51,10 @@ func runUnixUninstall() {
 	fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
 	fmt.Println()
 
-	if !forceUninstall && !skipConfirmation {
+	if !forceUninstall {
 		fmt.Println("This will completely remove SkyPort from your system.")
 		fmt.Println()
-		fmt.Print("Are you sure you want to continue? [y/N]: ")
-		var response string
-		fmt.Scanln(&response)
-		if response != "y" && response != "Y" && response != "yes" {
+		if !confirm("Are you sure you want to continue?") {
 			fmt.Println("Uninstall cancelled.")
 			return
 		}
@@ -83,29 +79,27 @@ func runUnixUninstall() {
 		if err := systemdService.Uninstall(); err != nil {
 			fmt.Printf("   Warning: Failed to uninstall service: %v\n", err)
 		} else {
-			fmt.Println("   ✓ Service removed successfully")
+			fmt.Printf("   %s Service removed successfully\n", logger.SuccessMark())
 		}
 	} else {
-		fmt.Println("   ✓ No service installed")
+		fmt.Printf("   %s No service installed\n", logger.SuccessMark())
 	}
 
 	// Step 2: Remove configuration files
 	if !keepConfig {
 		fmt.Println()
 		fmt.Println("Step 2: Removing configuration files...")
+		clearStoredCredentials()
 		configDir, err := config.GetConfigDir()
 		if err == nil && dirExists(configDir) {
 			if err := os.RemoveAll(configDir); err != nil {
 				fmt.Printf("   Warning: Failed to remove config: %v\n", err)
 			} else {
-				fmt.Println("   ✓ Configuration removed")
+				fmt.Printf("   %s Configuration removed\n", logger.SuccessMark())
 			}
 		} else {
-			fmt.Println("   ✓ No configuration found")
+			fmt.Printf("   %s No configuration found\n", logger.SuccessMark())
 		}
-
-		// Clear keyring credentials
-		clearKeyring()
 	} else {
 		fmt.Println()
 		fmt.Println("Step 2: Skipping configuration removal (--keep-config)")
@@ -142,7 +136,7 @@ exit 0
 
 		fmt.Println()
 		fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
-		fmt.Println("✓ SkyPort Agent uninstalled successfully!")
+		fmt.Printf("%s SkyPort Agent uninstalled successfully!\n", logger.SuccessMark())
 		fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
 		fmt.Println()
 		fmt.Println("Final step: Remove the binary file")
@@ -161,7 +155,7 @@ exit 0
 
 	fmt.Println()
 	fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
-	fmt.Println("✓ Uninstall complete!")
+	fmt.Printf("%s Uninstall complete!\n", logger.SuccessMark())
 	fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
 	fmt.Println()
 	fmt.Println("Thank you for using SkyPort! 👋")
@@ -173,13 +167,10 @@ func runWindowsUninstall() {
 	fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
 	fmt.Println()
 
-	if !forceUninstall && !skipConfirmation {
+	if !forceUninstall {
 		fmt.Println("This will completely remove SkyPort from your system.")
 		fmt.Println()
-		fmt.Print("Are you sure you want to continue? [y/N]: ")
-		var response string
-		fmt.Scanln(&response)
-		if response != "y" && response != "Y" && response != "yes" {
+		if !confirm("Are you sure you want to continue?") {
 			fmt.Println("Uninstall cancelled.")
 			return
 		}
@@ -195,21 +186,22 @@ func runWindowsUninstall() {
 	// Step 1: Stop and remove Windows service
 	fmt.Println("Step 1: Checking Windows service...")
 	// TODO: Implement Windows service removal with proper check
-	fmt.Println("   ✓ Service check complete")
+	fmt.Printf("   %s Service check complete\n", logger.SuccessMark())
 
 	// Step 2: Remove configuration files
 	if !keepConfig {
 		fmt.Println()
 		fmt.Println("Step 2: Removing configuration files...")
+		clearStoredCredentials()
 		configDir, err := config.GetConfigDir()
 		if err == nil && dirExists(configDir) {
 			if err := os.RemoveAll(configDir); err != nil {
 				fmt.Printf("   Warning: Failed to remove config: %v\n", err)
 			} else {
-				fmt.Println("   ✓ Configuration removed")
+				fmt.Printf("   %s Configuration removed\n", logger.SuccessMark())
 			}
 		} else {
-			fmt.Println("   ✓ No configuration found")
+			fmt.Printf("   %s No configuration found\n", logger.SuccessMark())
 		}
 	}
 
@@ -234,7 +226,7 @@ exit
 	} else {
 		fmt.Println()
 		fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
-		fmt.Println("✓ SkyPort Agent uninstalled successfully!")
+		fmt.Printf("%s SkyPort Agent uninstalled successfully!\n", logger.SuccessMark())
 		fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
 		fmt.Println()
 		fmt.Println("Final step: Run the cleanup script")
@@ -248,15 +240,25 @@ exit
 
 	fmt.Println()
 	fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
-	fmt.Println("✓ Uninstall complete!")
+	fmt.Printf("%s Uninstall complete!\n", logger.SuccessMark())
 	fmt.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
 	fmt.Println()
 	fmt.Println("Thank you for using SkyPort! 👋")
 }
 
-func clearKeyring() {
-	cmd := exec.Command("secret-tool", "clear", "service", "skyport-agent")
-	cmd.Run() // Silent - don't show keyring messages
+// clearStoredCredentials removes the login token from the platform keyring
+// or the encrypted token file, and the account details, reporting what was
+// there to remove
+func clearStoredCredentials() {
+	removed, err := auth.NewAuthManager(config.Load()).ClearCredentials()
+	for _, what := range removed {
+		fmt.Printf("   %s Removed %s\n", logger.SuccessMark(), what)
+	}
+	if err != nil {
+		fmt.Printf("   Warning: Could not remove all stored credentials: %v\n", err)
+	} else if len(removed) == 0 {
+		fmt.Printf("   %s No stored credentials found\n", logger.SuccessMark())
+	}
 }
 
 func dirExists(path string) bool {
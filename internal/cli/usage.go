@@ -0,0 +1,105 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"skyport-agent/internal/usage"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var usageCmd = &cobra.Command{
+	Use:   "usage [tunnel-name-or-id]",
+	Short: "Show bytes transferred per tunnel per day",
+	Long: `Show how much traffic each tunnel has carried on this machine, per day.
+Counts include everything sent over the tunnel connection (requests, responses,
+WebSocket frames and protocol overhead) and are kept across restarts.
+
+Example:
+  skyport tunnel usage
+  skyport tunnel usage myapp --since 30d`,
+	Args:        cobra.MaximumNArgs(1),
+	Annotations: map[string]string{offlineAnnotation: "true"},
+	Run:         runUsage,
+}
+
+func init() {
+	usageCmd.Flags().String("since", "7d", "How far back to report (e.g. 24h, 7d, 30d)")
+	tunnelCmd.AddCommand(usageCmd)
+}
+
+func runUsage(cmd *cobra.Command, args []string) {
+	sinceFlag, _ := cmd.Flags().GetString("since")
+	period, err := parsePeriod(sinceFlag)
+	if err != nil {
+		exitWithError(fmt.Errorf("invalid --since value: %w", err))
+	}
+	since := time.Now().Add(-period)
+
+	records, err := usage.LoadAll()
+	if err != nil {
+		exitWithError(fmt.Errorf("failed to load usage: %w", err))
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "TUNNEL\tDAY\tIN\tOUT\tTOTAL\tREQUESTS")
+	fmt.Fprintln(w, "------\t---\t--\t---\t-----\t--------")
+
+	found := false
+	for _, record := range records {
+		if len(args) == 1 && record.TunnelID != args[0] && record.TunnelName != args[0] {
+			continue
+		}
+
+		var total usage.Day
+		days := record.Since(since)
+		for _, key := range days {
+			day := record.Days[key]
+			total.BytesIn += day.BytesIn
+			total.BytesOut += day.BytesOut
+			total.Requests += day.Requests
+
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d\n",
+				record.TunnelName, key,
+				usage.FormatBytes(day.BytesIn),
+				usage.FormatBytes(day.BytesOut),
+				usage.FormatBytes(day.BytesIn+day.BytesOut),
+				day.Requests)
+		}
+
+		if len(days) > 0 {
+			found = true
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d\n",
+				record.TunnelName, "total",
+				usage.FormatBytes(total.BytesIn),
+				usage.FormatBytes(total.BytesOut),
+				usage.FormatBytes(total.BytesIn+total.BytesOut),
+				total.Requests)
+		}
+	}
+
+	if !found {
+		fmt.Printf(" No traffic recorded since %s.\n", since.Format("2006-01-02"))
+		return
+	}
+
+	w.Flush()
+}
+
+// parsePeriod parses a duration that may also be given in days ("7d") or weeks ("2w")
+func parsePeriod(value string) (time.Duration, error) {
+	for suffix, unit := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if strings.HasSuffix(value, suffix) {
+			n, err := strconv.Atoi(strings.TrimSuffix(value, suffix))
+			if err != nil || n < 0 {
+				return 0, fmt.Errorf("%q is not a valid period", value)
+			}
+			return time.Duration(n) * unit, nil
+		}
+	}
+	return time.ParseDuration(value)
+}
@@ -0,0 +1,145 @@
+package cli
+
+import (
+	"fmt"
+	"skyport-agent/internal/config"
+	"skyport-agent/internal/usage"
+
+	"github.com/spf13/cobra"
+)
+
+var websocketCmd = &cobra.Command{
+	Use:   "websocket [tunnel-name-or-id] [show|set|off]",
+	Short: "Limit the WebSocket sessions proxied to a tunnel's local service",
+	Long: `Protect the agent and your local WebSocket server from abusive clients. A
+session is closed when either side sends a message larger than --max-message,
+or the client sends more than --rate messages per second (with bursts of
+--burst). The client gets a close frame with the limit's close code: 1009 and
+1008 by default, or any code from 3000-4999 your application understands.
+Sessions passing no messages for --idle-timeout are closed with 1001, freeing
+the connection to your local service. With --max-sessions, clients opening a
+session while that many are open get 503 Service Unavailable, so a crowd
+can't overwhelm a service running on a laptop.
+
+'set' changes only the limits given; 'off' removes all of them.
+
+Example:
+  skyport tunnel websocket myapp set --max-message 64KB --rate 20
+  skyport tunnel websocket myapp set --rate-code 4029
+  skyport tunnel websocket myapp set --idle-timeout 10m
+  skyport tunnel websocket myapp set --max-sessions 50
+  skyport tunnel websocket myapp show
+  skyport tunnel websocket myapp off`,
+	Args:        cobra.ExactArgs(2),
+	Annotations: map[string]string{offlineAnnotation: "true"},
+	Run:         runWebSocket,
+}
+
+func init() {
+	websocketCmd.Flags().String("max-message", "", "Largest message in either direction, e.g. 64KB (0 for no limit)")
+	websocketCmd.Flags().Float64("rate", 0, "Messages per second a client may send in a session (0 for no limit)")
+	websocketCmd.Flags().Int("burst", 0, "Messages a client may send at once (default: the rate)")
+	websocketCmd.Flags().Int("too-big-code", 0, "Close code for oversized messages (default 1009)")
+	websocketCmd.Flags().Int("rate-code", 0, "Close code for clients sending too fast (default 1008)")
+	websocketCmd.Flags().Duration("idle-timeout", 0, "Close sessions without messages for this long (0 for never)")
+	websocketCmd.Flags().Int("max-sessions", 0, "Sessions open at once; more are answered 503 (0 for no limit)")
+	tunnelCmd.AddCommand(websocketCmd)
+}
+
+func runWebSocket(cmd *cobra.Command, args []string) {
+	configManager := config.NewConfigManager()
+	tunnel := findLocalTunnel(configManager, args[0])
+
+	var settings config.WebSocketSettings
+	if tunnel.Settings != nil {
+		settings = tunnel.Settings.WebSocket
+	}
+
+	switch args[1] {
+	case "show":
+		printWebSocketLimits(tunnel)
+		return
+	case "set":
+		if cmd.Flags().NFlag() == 0 {
+			exitWithError(fmt.Errorf("give the limits to set, such as --max-message 64KB or --rate 20"))
+		}
+		if cmd.Flags().Changed("max-message") {
+			value, _ := cmd.Flags().GetString("max-message")
+			size, err := parseSize(value)
+			if err != nil || size < 0 {
+				exitWithError(fmt.Errorf("invalid size '%s' (use e.g. 64KB, 1MB or 0)", value))
+			}
+			settings.MaxMessageBytes = size
+		}
+		if cmd.Flags().Changed("rate") {
+			settings.MessagesPerSecond, _ = cmd.Flags().GetFloat64("rate")
+		}
+		if cmd.Flags().Changed("burst") {
+			settings.MessageBurst, _ = cmd.Flags().GetInt("burst")
+		}
+		if cmd.Flags().Changed("idle-timeout") {
+			settings.IdleTimeout.Duration, _ = cmd.Flags().GetDuration("idle-timeout")
+		}
+		if cmd.Flags().Changed("max-sessions") {
+			settings.MaxSessions, _ = cmd.Flags().GetInt("max-sessions")
+		}
+		for flag, code := range map[string]*int{"too-big-code": &settings.TooBigCloseCode, "rate-code": &settings.RateCloseCode} {
+			if !cmd.Flags().Changed(flag) {
+				continue
+			}
+			*code, _ = cmd.Flags().GetInt(flag)
+			if !config.ValidCloseCode(*code) {
+				exitWithError(fmt.Errorf("invalid close code %d: use 1000-1014 (except 1004-1006) or 3000-4999", *code))
+			}
+		}
+		if settings.MessagesPerSecond < 0 || settings.MessageBurst < 0 || settings.IdleTimeout.Duration < 0 || settings.MaxSessions < 0 {
+			exitWithError(fmt.Errorf("the rate, burst, idle timeout and max sessions can't be negative"))
+		}
+	case "off":
+		settings = config.WebSocketSettings{}
+	default:
+		exitWithError(fmt.Errorf("action must be 'show', 'set' or 'off'"))
+	}
+
+	if err := configManager.SetTunnelWebSocket(tunnel.ID, settings); err != nil {
+		exitWithError(fmt.Errorf("failed to update WebSocket limits: %w", err))
+	}
+
+	tunnel = findLocalTunnel(configManager, tunnel.ID)
+	printWebSocketLimits(tunnel)
+	fmt.Println(" Takes effect the next time the tunnel connects.")
+}
+
+// printWebSocketLimits shows the limits on a tunnel's WebSocket sessions
+func printWebSocketLimits(tunnel *config.Tunnel) {
+	settings := tunnel.WebSocketSettings()
+	fmt.Printf(" WebSocket limits for tunnel '%s':\n", tunnel.Name)
+
+	maxMessage := "no limit"
+	if settings.MaxMessageBytes > 0 {
+		maxMessage = fmt.Sprintf("%s (close code %d)", usage.FormatBytes(settings.MaxMessageBytes), settings.TooBigCloseCode)
+	}
+	fmt.Printf("   Max message:  %s\n", maxMessage)
+
+	rate := "no limit"
+	if settings.MessagesPerSecond > 0 {
+		rate = fmt.Sprintf("%g/s", settings.MessagesPerSecond)
+		if settings.MessageBurst > 0 {
+			rate += fmt.Sprintf(", bursts of %d", settings.MessageBurst)
+		}
+		rate += fmt.Sprintf(" (close code %d)", settings.RateCloseCode)
+	}
+	fmt.Printf("   Message rate: %s\n", rate)
+
+	idle := "never"
+	if settings.IdleTimeout.Duration > 0 {
+		idle = settings.IdleTimeout.Duration.String()
+	}
+	fmt.Printf("   Idle timeout: %s\n", idle)
+
+	maxSessions := "no limit"
+	if settings.MaxSessions > 0 {
+		maxSessions = fmt.Sprintf("%d open at once", settings.MaxSessions)
+	}
+	fmt.Printf("   Max sessions: %s\n", maxSessions)
+}
@@ -1,10 +1,14 @@
 package config
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"log"
 	"os"
 	"path/filepath"
+	"sort"
 	"time"
 )
 
@@ -18,9 +22,12 @@ var (
 
 // Config represents the application configuration
 type Config struct {
-	ServerURL    string `json:"server_url"`
-	WebURL       string `json:"web_url"`
-	TunnelDomain string `json:"tunnel_domain"`
+	ServerURL    string    `json:"server_url"`
+	WebURL       string    `json:"web_url"`
+	TunnelDomain string    `json:"tunnel_domain"`
+	Settings     *Settings `json:"-"`
+
+	tlsErr error // Why the TLS settings are invalid; see TLSError
 }
 
 // UserData represents user authentication data
@@ -32,13 +39,41 @@ type UserData struct {
 }
 
 // Load returns the application configuration
-// It first checks environment variables, then falls back to build-time defaults
+// It first checks environment variables, then falls back to build-time defaults.
+// Tuning settings are read from skyport.json; invalid settings fall back to
+// defaults, except the TLS policy: a valid one is kept, and an invalid one
+// stops the agent connecting to the server (see TLSError).
 func Load() *Config {
-	return &Config{
+	cfg := &Config{
 		ServerURL:    getEnv("SKYPORT_SERVER_URL", DefaultServerURL),
 		WebURL:       getEnv("SKYPORT_WEB_URL", DefaultWebURL),
 		TunnelDomain: getEnv("SKYPORT_TUNNEL_DOMAIN", DefaultTunnelDomain),
 	}
+
+	configManager := NewConfigManager()
+	settings, err := configManager.LoadSettings()
+	if err != nil {
+		settings = DefaultSettings()
+		tlsSettings, tlsErr := configManager.LoadTLSSettings()
+		if tlsErr != nil {
+			log.Printf("Warning: %v - not connecting to the server until the tls settings are fixed", tlsErr)
+			cfg.tlsErr = tlsErr
+		} else {
+			log.Printf("Warning: %v - using default settings", err)
+			settings.TLS = tlsSettings
+		}
+	}
+
+	cfg.Settings = settings
+	return cfg
+}
+
+// GetSettings returns the loaded settings, or the defaults if none were loaded
+func (c *Config) GetSettings() *Settings {
+	if c.Settings == nil {
+		return DefaultSettings()
+	}
+	return c.Settings
 }
 
 func getEnv(key, fallback string) string {
@@ -50,9 +85,41 @@ func getEnv(key, fallback string) string {
 
 // AppConfig represents the agent configuration
 type AppConfig struct {
-	UserToken string             `json:"user_token"`
-	Tunnels   map[string]*Tunnel `json:"tunnels"`
-	LastSync  time.Time          `json:"last_sync"`
+	UserToken   string             `json:"user_token"`
+	Tunnels     map[string]*Tunnel `json:"tunnels"`
+	LastSync    time.Time          `json:"last_sync"`
+	Settings    *Settings          `json:"settings,omitempty"`
+	TunnelCache *TunnelListCache   `json:"tunnel_cache,omitempty"`
+	// UsageReports is the user's choice about anonymous usage reports
+	UsageReports *UsageReportSettings `json:"usage_reports,omitempty"`
+	// AdminToken authenticates requests to the local control API. It is
+	// generated the first time it is needed.
+	AdminToken string `json:"admin_token,omitempty"`
+	// MachineID tells this agent's tunnel connections apart from other
+	// machines'. It is random and generated the first time it is needed.
+	MachineID string `json:"machine_id,omitempty"`
+	// Groups are named sets of tunnel IDs that are started together
+	Groups map[string][]string `json:"groups,omitempty"`
+	// Templates are named sets of options new tunnels can be created with
+	Templates map[string]*TunnelTemplate `json:"templates,omitempty"`
+}
+
+// UsageReportSettings records whether the user opted in to anonymous usage
+// reports. Reports are off unless the user turned them on.
+type UsageReportSettings struct {
+	Enabled bool `json:"enabled"`
+	// InstallID groups reports from one installation. It is random, not
+	// derived from the machine or account, and replaced on each opt-in.
+	InstallID string    `json:"install_id,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// TunnelListCache is the tunnel list as last returned by the server, so CLI
+// commands do not have to fetch it every time
+type TunnelListCache struct {
+	Tunnels   []Tunnel  `json:"tunnels"`
+	ETag      string    `json:"etag,omitempty"`
+	FetchedAt time.Time `json:"fetched_at"` // When the server last confirmed the list
 }
 
 // Tunnel represents a tunnel configuration
@@ -64,6 +131,19 @@ type Tunnel struct {
 	AuthToken string `json:"auth_token"`
 	IsActive  bool   `json:"is_active"`
 	AutoStart bool   `json:"auto_start"` // Auto-connect when agent starts
+
+	// Labels such as env=staging, as the server stores them
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// The machine a running tunnel is connected from and since when, if the
+	// server reports it
+	ConnectedHost      string    `json:"connected_host,omitempty"`
+	ConnectedMachineID string    `json:"connected_machine_id,omitempty"`
+	ConnectedAt        time.Time `json:"connected_at,omitempty"`
+
+	// Settings are local options that the server does not know about; they
+	// are preserved when tunnels are synced from the server
+	Settings *TunnelSettings `json:"settings,omitempty"`
 }
 
 // ConfigManager handles the agent configuration
@@ -79,13 +159,21 @@ func NewConfigManager() *ConfigManager {
 	}
 }
 
+// NewConfigManagerAt creates a config manager for the skyport.json in
+// configDir, such as another user's ~/.skyport
+func NewConfigManagerAt(configDir string) *ConfigManager {
+	return &ConfigManager{
+		configFile: filepath.Join(configDir, "skyport.json"),
+	}
+}
+
 // getConfigDir returns platform-specific config directory
 func getConfigDir() string {
 	var configDir string
 
 	// Use standard app data directories
 	if home, err := os.UserHomeDir(); err == nil {
-		configDir = filepath.Join(home, ".skyport")
+		configDir = ConfigDirFor(home, instance)
 	} else {
 		configDir = "."
 	}
@@ -95,54 +183,68 @@ func getConfigDir() string {
 	return configDir
 }
 
-// LoadConfig loads the application configuration
+// LoadConfig returns a copy of the application configuration, which the
+// caller may modify and pass to SaveConfig. The file is only read again
+// after it changed on disk.
 func (cm *ConfigManager) LoadConfig() (*AppConfig, error) {
-	if _, err := os.Stat(cm.configFile); os.IsNotExist(err) {
-		// Return empty config if file doesn't exist
-		return &AppConfig{
-			Tunnels:  make(map[string]*Tunnel),
-			LastSync: time.Now(),
-		}, nil
-	}
-
-	data, err := os.ReadFile(cm.configFile)
+	config, err := cm.store().snapshot()
 	if err != nil {
-		return nil, fmt.Errorf("failed to read config: %w", err)
-	}
-
-	var config AppConfig
-	if err := json.Unmarshal(data, &config); err != nil {
-		return nil, fmt.Errorf("failed to parse config: %w", err)
-	}
-
-	if config.Tunnels == nil {
-		config.Tunnels = make(map[string]*Tunnel)
+		return nil, err
 	}
-
-	return &config, nil
+	return config.clone()
 }
 
 // SaveConfig saves the application configuration
 func (cm *ConfigManager) SaveConfig(config *AppConfig) error {
-	config.LastSync = time.Now()
+	return cm.store().replace(config)
+}
 
-	data, err := json.MarshalIndent(config, "", "  ")
-	if err != nil {
-		return fmt.Errorf("failed to marshal config: %w", err)
-	}
+// update applies fn to the configuration and saves it, holding the config
+// lock so concurrent updates in this process cannot overwrite each other
+func (cm *ConfigManager) update(fn func(config *AppConfig) error) error {
+	return cm.store().update(fn)
+}
 
-	return os.WriteFile(cm.configFile, data, 0644)
+func (cm *ConfigManager) store() *store {
+	return storeFor(cm.configFile)
 }
 
-// SaveUserToken saves the user's authentication token
-func (cm *ConfigManager) SaveUserToken(token string) error {
+// LoadTunnelCache returns the cached tunnel list, or nil if there is none
+func (cm *ConfigManager) LoadTunnelCache() *TunnelListCache {
 	config, err := cm.LoadConfig()
 	if err != nil {
-		return err
+		return nil
 	}
+	return config.TunnelCache
+}
 
-	config.UserToken = token
-	return cm.SaveConfig(config)
+// SaveTunnelCache replaces the cached tunnel list
+func (cm *ConfigManager) SaveTunnelCache(cache *TunnelListCache) error {
+	return cm.update(func(config *AppConfig) error {
+		config.TunnelCache = cache
+		return nil
+	})
+}
+
+// InvalidateTunnelCache forgets the cached tunnel list, so the next command
+// that needs it asks the server
+func (cm *ConfigManager) InvalidateTunnelCache() error {
+	return cm.update(func(config *AppConfig) error {
+		if config.TunnelCache == nil {
+			return nil
+		}
+
+		config.TunnelCache = nil
+		return nil
+	})
+}
+
+// SaveUserToken saves the user's authentication token
+func (cm *ConfigManager) SaveUserToken(token string) error {
+	return cm.update(func(config *AppConfig) error {
+		config.UserToken = token
+		return nil
+	})
 }
 
 // GetUserToken gets the user's authentication token
@@ -155,37 +257,720 @@ func (cm *ConfigManager) GetUserToken() (string, error) {
 	return config.UserToken, nil
 }
 
-// SetTunnelAutoStart enables/disables auto-start for a tunnel
-func (cm *ConfigManager) SetTunnelAutoStart(tunnelID string, autoStart bool) error {
+// GetUsageReports returns the user's choice about anonymous usage reports
+func (cm *ConfigManager) GetUsageReports() UsageReportSettings {
 	config, err := cm.LoadConfig()
-	if err != nil {
-		return err
+	if err != nil || config.UsageReports == nil {
+		return UsageReportSettings{}
 	}
+	return *config.UsageReports
+}
+
+// SetUsageReports opts in to or out of anonymous usage reports. Opting in
+// picks a new install ID; opting out forgets it.
+func (cm *ConfigManager) SetUsageReports(enabled bool) error {
+	return cm.update(func(config *AppConfig) error {
+		settings := &UsageReportSettings{Enabled: enabled, UpdatedAt: time.Now()}
+		if enabled {
+			if config.UsageReports != nil && config.UsageReports.Enabled {
+				settings.InstallID = config.UsageReports.InstallID
+			}
+			if settings.InstallID == "" {
+				id := make([]byte, 16)
+				if _, err := rand.Read(id); err != nil {
+					return fmt.Errorf("failed to generate install ID: %w", err)
+				}
+				settings.InstallID = hex.EncodeToString(id)
+			}
+		}
+		config.UsageReports = settings
+		return nil
+	})
+}
 
-	if tunnel, exists := config.Tunnels[tunnelID]; exists {
-		tunnel.AutoStart = autoStart
-		return cm.SaveConfig(config)
+// AdminToken returns the token that authenticates requests to the local
+// control API, generating one the first time
+func (cm *ConfigManager) AdminToken() (string, error) {
+	if config, err := cm.LoadConfig(); err == nil && config.AdminToken != "" {
+		return config.AdminToken, nil
 	}
 
-	return fmt.Errorf("tunnel %s not found", tunnelID)
+	var token string
+	err := cm.update(func(config *AppConfig) error {
+		if config.AdminToken == "" {
+			secret := make([]byte, 32)
+			if _, err := rand.Read(secret); err != nil {
+				return fmt.Errorf("failed to generate admin token: %w", err)
+			}
+			config.AdminToken = hex.EncodeToString(secret)
+		}
+		token = config.AdminToken
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	// Other processes must see the token before requests can use it
+	return token, Flush()
 }
 
-// SetTunnelActive updates tunnel active status
-func (cm *ConfigManager) SetTunnelActive(tunnelID string, isActive bool) error {
-	config, err := cm.LoadConfig()
-	if err != nil {
-		return err
+// MachineID returns the ID this agent identifies its tunnel connections
+// with, generating one the first time
+func (cm *ConfigManager) MachineID() (string, error) {
+	if config, err := cm.LoadConfig(); err == nil && config.MachineID != "" {
+		return config.MachineID, nil
 	}
 
-	if tunnel, exists := config.Tunnels[tunnelID]; exists {
-		tunnel.IsActive = isActive
-		return cm.SaveConfig(config)
+	var machineID string
+	err := cm.update(func(config *AppConfig) error {
+		if config.MachineID == "" {
+			id := make([]byte, 8)
+			if _, err := rand.Read(id); err != nil {
+				return fmt.Errorf("failed to generate machine ID: %w", err)
+			}
+			config.MachineID = hex.EncodeToString(id)
+		}
+		machineID = config.MachineID
+		return nil
+	})
+	return machineID, err
+}
+
+// SetTunnelAutoStart enables/disables auto-start for a tunnel
+func (cm *ConfigManager) SetTunnelAutoStart(tunnelID string, autoStart bool) error {
+	return cm.update(func(config *AppConfig) error {
+		if tunnel, exists := config.Tunnels[tunnelID]; exists {
+			tunnel.AutoStart = autoStart
+			return nil
+		}
+
+		return fmt.Errorf("tunnel %s not found", tunnelID)
+	})
+}
+
+// SetTunnelAccessLog enables/disables the access log for a tunnel. An empty
+// format keeps the current one.
+func (cm *ConfigManager) SetTunnelAccessLog(tunnelID string, enabled bool, format string) error {
+	return cm.update(func(config *AppConfig) error {
+		tunnel, exists := config.Tunnels[tunnelID]
+		if !exists {
+			return fmt.Errorf("tunnel %s not found", tunnelID)
+		}
+
+		if tunnel.Settings == nil {
+			tunnel.Settings = &TunnelSettings{}
+		}
+		tunnel.Settings.AccessLog.Enabled = enabled
+		if format != "" {
+			tunnel.Settings.AccessLog.Format = format
+		}
+
+		return nil
+	})
+}
+
+// SetTunnelSampling enables/disables request sampling for a tunnel. Zero
+// percent or maxBody keep the current values.
+func (cm *ConfigManager) SetTunnelSampling(tunnelID string, enabled bool, percent float64, maxBody int) error {
+	return cm.update(func(config *AppConfig) error {
+		tunnel, exists := config.Tunnels[tunnelID]
+		if !exists {
+			return fmt.Errorf("tunnel %s not found", tunnelID)
+		}
+
+		if tunnel.Settings == nil {
+			tunnel.Settings = &TunnelSettings{}
+		}
+		tunnel.Settings.Sampling.Enabled = enabled
+		if percent > 0 {
+			tunnel.Settings.Sampling.Percent = percent
+		}
+		if maxBody > 0 {
+			tunnel.Settings.Sampling.MaxBodyBytes = maxBody
+		}
+
+		return nil
+	})
+}
+
+// SetTunnelBasicAuth updates basic auth for a tunnel. A non-empty user is
+// added (or has its hash replaced), and a non-empty htpasswdFile replaces the
+// configured file. Disabling keeps the users so auth can be re-enabled later.
+func (cm *ConfigManager) SetTunnelBasicAuth(tunnelID string, enabled bool, user, passwordHash, htpasswdFile string) error {
+	return cm.update(func(config *AppConfig) error {
+		tunnel, exists := config.Tunnels[tunnelID]
+		if !exists {
+			return fmt.Errorf("tunnel %s not found", tunnelID)
+		}
+
+		if tunnel.Settings == nil {
+			tunnel.Settings = &TunnelSettings{}
+		}
+		basicAuth := &tunnel.Settings.BasicAuth
+		basicAuth.Enabled = enabled
+		if user != "" {
+			if basicAuth.Users == nil {
+				basicAuth.Users = make(map[string]string)
+			}
+			basicAuth.Users[user] = passwordHash
+		}
+		if htpasswdFile != "" {
+			basicAuth.HtpasswdFile = htpasswdFile
+		}
+
+		return nil
+	})
+}
+
+// RemoveTunnelBasicAuthUser removes a user from a tunnel's basic auth users
+func (cm *ConfigManager) RemoveTunnelBasicAuthUser(tunnelID, user string) error {
+	return cm.update(func(config *AppConfig) error {
+		tunnel, exists := config.Tunnels[tunnelID]
+		if !exists {
+			return fmt.Errorf("tunnel %s not found", tunnelID)
+		}
+
+		if tunnel.Settings == nil || tunnel.Settings.BasicAuth.Users[user] == "" {
+			return fmt.Errorf("user %s not found", user)
+		}
+		delete(tunnel.Settings.BasicAuth.Users, user)
+
+		return nil
+	})
+}
+
+// SetTunnelIPFilter replaces a tunnel's IP allow and deny lists
+func (cm *ConfigManager) SetTunnelIPFilter(tunnelID string, allow, deny []string) error {
+	return cm.update(func(config *AppConfig) error {
+		tunnel, exists := config.Tunnels[tunnelID]
+		if !exists {
+			return fmt.Errorf("tunnel %s not found", tunnelID)
+		}
+
+		if tunnel.Settings == nil {
+			tunnel.Settings = &TunnelSettings{}
+		}
+		tunnel.Settings.IPFilter.Allow = allow
+		tunnel.Settings.IPFilter.Deny = deny
+
+		return nil
+	})
+}
+
+// SetTunnelPathFilter replaces a tunnel's method and path allow and deny
+// rules
+func (cm *ConfigManager) SetTunnelPathFilter(tunnelID string, allow, deny []string) error {
+	return cm.update(func(config *AppConfig) error {
+		tunnel, exists := config.Tunnels[tunnelID]
+		if !exists {
+			return fmt.Errorf("tunnel %s not found", tunnelID)
+		}
+
+		if tunnel.Settings == nil {
+			tunnel.Settings = &TunnelSettings{}
+		}
+		tunnel.Settings.PathFilter.Allow = allow
+		tunnel.Settings.PathFilter.Deny = deny
+
+		return nil
+	})
+}
+
+// SetTunnelHeaderFilter replaces a tunnel's header allow and deny rules
+func (cm *ConfigManager) SetTunnelHeaderFilter(tunnelID string, allow, deny []string) error {
+	return cm.update(func(config *AppConfig) error {
+		tunnel, exists := config.Tunnels[tunnelID]
+		if !exists {
+			return fmt.Errorf("tunnel %s not found", tunnelID)
+		}
+
+		if tunnel.Settings == nil {
+			tunnel.Settings = &TunnelSettings{}
+		}
+		tunnel.Settings.HeaderFilter.Allow = allow
+		tunnel.Settings.HeaderFilter.Deny = deny
+
+		return nil
+	})
+}
+
+// SetTunnelRateLimit enables/disables rate limiting for a tunnel. Negative
+// rates keep the current values; zero removes that limit.
+func (cm *ConfigManager) SetTunnelRateLimit(tunnelID string, enabled bool, perClientRPS, globalRPS float64, burst int) error {
+	return cm.update(func(config *AppConfig) error {
+		tunnel, exists := config.Tunnels[tunnelID]
+		if !exists {
+			return fmt.Errorf("tunnel %s not found", tunnelID)
+		}
+
+		if tunnel.Settings == nil {
+			tunnel.Settings = &TunnelSettings{}
+		}
+		rateLimit := &tunnel.Settings.RateLimit
+		rateLimit.Enabled = enabled
+		if perClientRPS >= 0 {
+			rateLimit.PerClientRPS = perClientRPS
+		}
+		if globalRPS >= 0 {
+			rateLimit.GlobalRPS = globalRPS
+		}
+		if burst > 0 {
+			rateLimit.PerClientBurst = burst
+		}
+
+		return nil
+	})
+}
+
+// SetTunnelRewrite replaces a tunnel's path rewrite settings
+func (cm *ConfigManager) SetTunnelRewrite(tunnelID string, rewrite RewriteSettings) error {
+	return cm.update(func(config *AppConfig) error {
+		tunnel, exists := config.Tunnels[tunnelID]
+		if !exists {
+			return fmt.Errorf("tunnel %s not found", tunnelID)
+		}
+
+		if tunnel.Settings == nil {
+			tunnel.Settings = &TunnelSettings{}
+		}
+		tunnel.Settings.Rewrite = rewrite
+
+		return nil
+	})
+}
+
+// SetTunnelHeaders replaces a tunnel's header rules
+func (cm *ConfigManager) SetTunnelHeaders(tunnelID string, headers HeaderSettings) error {
+	return cm.update(func(config *AppConfig) error {
+		tunnel, exists := config.Tunnels[tunnelID]
+		if !exists {
+			return fmt.Errorf("tunnel %s not found", tunnelID)
+		}
+
+		if tunnel.Settings == nil {
+			tunnel.Settings = &TunnelSettings{}
+		}
+		tunnel.Settings.Headers = headers
+
+		return nil
+	})
+}
+
+// SetTunnelSanitize replaces a tunnel's header sanitization policy
+func (cm *ConfigManager) SetTunnelSanitize(tunnelID string, sanitize SanitizeSettings) error {
+	return cm.update(func(config *AppConfig) error {
+		tunnel, exists := config.Tunnels[tunnelID]
+		if !exists {
+			return fmt.Errorf("tunnel %s not found", tunnelID)
+		}
+
+		if tunnel.Settings == nil {
+			tunnel.Settings = &TunnelSettings{}
+		}
+		tunnel.Settings.Sanitize = sanitize
+
+		return nil
+	})
+}
+
+// SetTunnelScan replaces a tunnel's content scanning settings
+func (cm *ConfigManager) SetTunnelScan(tunnelID string, scan ScanSettings) error {
+	return cm.update(func(config *AppConfig) error {
+		tunnel, exists := config.Tunnels[tunnelID]
+		if !exists {
+			return fmt.Errorf("tunnel %s not found", tunnelID)
+		}
+
+		if tunnel.Settings == nil {
+			tunnel.Settings = &TunnelSettings{}
+		}
+		tunnel.Settings.Scan = scan
+
+		return nil
+	})
+}
+
+// SetTunnelCORS enables/disables CORS handling for a tunnel. Non-empty
+// origins replace the allowed origins.
+func (cm *ConfigManager) SetTunnelCORS(tunnelID string, enabled bool, origins []string, allowCredentials bool) error {
+	return cm.update(func(config *AppConfig) error {
+		tunnel, exists := config.Tunnels[tunnelID]
+		if !exists {
+			return fmt.Errorf("tunnel %s not found", tunnelID)
+		}
+
+		if tunnel.Settings == nil {
+			tunnel.Settings = &TunnelSettings{}
+		}
+		tunnel.Settings.CORS.Enabled = enabled
+		if len(origins) > 0 {
+			tunnel.Settings.CORS.AllowOrigins = origins
+		}
+		if enabled {
+			tunnel.Settings.CORS.AllowCredentials = allowCredentials
+		}
+
+		return nil
+	})
+}
+
+// SetTunnelLocalHTTPS enables/disables the local HTTPS listener of a tunnel.
+// A non-positive port keeps the current one.
+func (cm *ConfigManager) SetTunnelLocalHTTPS(tunnelID string, enabled bool, port int) error {
+	return cm.update(func(config *AppConfig) error {
+		tunnel, exists := config.Tunnels[tunnelID]
+		if !exists {
+			return fmt.Errorf("tunnel %s not found", tunnelID)
+		}
+
+		if tunnel.Settings == nil {
+			tunnel.Settings = &TunnelSettings{}
+		}
+		tunnel.Settings.LocalHTTPS.Enabled = enabled
+		if port > 0 {
+			tunnel.Settings.LocalHTTPS.Port = port
+		}
+
+		return nil
+	})
+}
+
+// SetTunnelMirror enables/disables the local mirror port of a tunnel. A
+// non-positive port keeps the current one.
+func (cm *ConfigManager) SetTunnelMirror(tunnelID string, enabled bool, port int) error {
+	return cm.update(func(config *AppConfig) error {
+		tunnel, exists := config.Tunnels[tunnelID]
+		if !exists {
+			return fmt.Errorf("tunnel %s not found", tunnelID)
+		}
+
+		if tunnel.Settings == nil {
+			tunnel.Settings = &TunnelSettings{}
+		}
+		tunnel.Settings.Mirror.Enabled = enabled
+		if port > 0 {
+			tunnel.Settings.Mirror.Port = port
+		}
+
+		return nil
+	})
+}
+
+// SetTunnelPages sets the directory of a tunnel's custom pages. An empty dir
+// restores the built-in responses.
+func (cm *ConfigManager) SetTunnelPages(tunnelID string, dir string) error {
+	return cm.update(func(config *AppConfig) error {
+		tunnel, exists := config.Tunnels[tunnelID]
+		if !exists {
+			return fmt.Errorf("tunnel %s not found", tunnelID)
+		}
+
+		if tunnel.Settings == nil {
+			tunnel.Settings = &TunnelSettings{}
+		}
+		tunnel.Settings.Pages.Dir = dir
+
+		return nil
+	})
+}
+
+// SetTunnelMaintenance turns maintenance mode of a tunnel on or off
+func (cm *ConfigManager) SetTunnelMaintenance(tunnelID string, enabled bool, message string) error {
+	return cm.update(func(config *AppConfig) error {
+		tunnel, exists := config.Tunnels[tunnelID]
+		if !exists {
+			return fmt.Errorf("tunnel %s not found", tunnelID)
+		}
+
+		if tunnel.Settings == nil {
+			tunnel.Settings = &TunnelSettings{}
+		}
+		tunnel.Settings.Maintenance = MaintenanceSettings{Enabled: enabled, Message: message}
+
+		return nil
+	})
+}
+
+// SetTunnelSplit sets a tunnel's traffic split to an alternate local target
+func (cm *ConfigManager) SetTunnelSplit(tunnelID string, split SplitSettings) error {
+	return cm.update(func(config *AppConfig) error {
+		tunnel, exists := config.Tunnels[tunnelID]
+		if !exists {
+			return fmt.Errorf("tunnel %s not found", tunnelID)
+		}
+
+		if tunnel.Settings == nil {
+			tunnel.Settings = &TunnelSettings{}
+		}
+		tunnel.Settings.Split = split
+
+		return nil
+	})
+}
+
+// SetTunnelWebSocket sets the limits on a tunnel's proxied WebSocket sessions
+func (cm *ConfigManager) SetTunnelWebSocket(tunnelID string, websocket WebSocketSettings) error {
+	return cm.update(func(config *AppConfig) error {
+		tunnel, exists := config.Tunnels[tunnelID]
+		if !exists {
+			return fmt.Errorf("tunnel %s not found", tunnelID)
+		}
+
+		if tunnel.Settings == nil {
+			tunnel.Settings = &TunnelSettings{}
+		}
+		tunnel.Settings.WebSocket = websocket
+
+		return nil
+	})
+}
+
+// SetTunnelCache enables/disables the response cache for a tunnel. A negative
+// ttl or non-positive maxSizeMB keep the current values.
+func (cm *ConfigManager) SetTunnelCache(tunnelID string, enabled bool, ttl time.Duration, maxSizeMB int) error {
+	return cm.update(func(config *AppConfig) error {
+		tunnel, exists := config.Tunnels[tunnelID]
+		if !exists {
+			return fmt.Errorf("tunnel %s not found", tunnelID)
+		}
+
+		if tunnel.Settings == nil {
+			tunnel.Settings = &TunnelSettings{}
+		}
+		tunnel.Settings.Cache.Enabled = enabled
+		if ttl >= 0 {
+			tunnel.Settings.Cache.TTL = Duration{ttl}
+		}
+		if maxSizeMB > 0 {
+			tunnel.Settings.Cache.MaxSizeMB = maxSizeMB
+		}
+
+		return nil
+	})
+}
+
+// SetTunnelStatic sets the directory a tunnel serves. An empty dir makes the
+// tunnel forward to its local port again.
+func (cm *ConfigManager) SetTunnelStatic(tunnelID string, static StaticSettings) error {
+	return cm.update(func(config *AppConfig) error {
+		tunnel, exists := config.Tunnels[tunnelID]
+		if !exists {
+			return fmt.Errorf("tunnel %s not found", tunnelID)
+		}
+
+		if tunnel.Settings == nil {
+			tunnel.Settings = &TunnelSettings{}
+		}
+		tunnel.Settings.Static = static
+
+		return nil
+	})
+}
+
+// SetTunnelHooks replaces a tunnel's hooks
+func (cm *ConfigManager) SetTunnelHooks(tunnelID string, hooks []HookSettings) error {
+	return cm.update(func(config *AppConfig) error {
+		tunnel, exists := config.Tunnels[tunnelID]
+		if !exists {
+			return fmt.Errorf("tunnel %s not found", tunnelID)
+		}
+
+		if tunnel.Settings == nil {
+			tunnel.Settings = &TunnelSettings{}
+		}
+		tunnel.Settings.Hooks = hooks
+
+		return nil
+	})
+}
+
+// SetTunnelCompression enables/disables response compression for a tunnel.
+// A zero level keeps the current one.
+func (cm *ConfigManager) SetTunnelCompression(tunnelID string, enabled bool, level int) error {
+	return cm.update(func(config *AppConfig) error {
+		tunnel, exists := config.Tunnels[tunnelID]
+		if !exists {
+			return fmt.Errorf("tunnel %s not found", tunnelID)
+		}
+
+		if tunnel.Settings == nil {
+			tunnel.Settings = &TunnelSettings{}
+		}
+		tunnel.Settings.Compression.Enabled = enabled
+		if level != 0 {
+			tunnel.Settings.Compression.Level = level
+		}
+
+		return nil
+	})
+}
+
+// SetTunnelMaxRequestBody sets the largest request body forwarded for a
+// tunnel (0 removes the limit)
+func (cm *ConfigManager) SetTunnelMaxRequestBody(tunnelID string, maxBytes int64) error {
+	return cm.update(func(config *AppConfig) error {
+		tunnel, exists := config.Tunnels[tunnelID]
+		if !exists {
+			return fmt.Errorf("tunnel %s not found", tunnelID)
+		}
+
+		if tunnel.Settings == nil {
+			tunnel.Settings = &TunnelSettings{}
+		}
+		tunnel.Settings.Limits.MaxRequestBodyBytes = maxBytes
+
+		return nil
+	})
+}
+
+// SetTunnelOIDC replaces a tunnel's identity provider settings. A cookie
+// secret is generated if none is set, so sessions survive agent restarts.
+func (cm *ConfigManager) SetTunnelOIDC(tunnelID string, settings OIDCSettings) error {
+	return cm.update(func(config *AppConfig) error {
+		tunnel, exists := config.Tunnels[tunnelID]
+		if !exists {
+			return fmt.Errorf("tunnel %s not found", tunnelID)
+		}
+
+		if tunnel.Settings == nil {
+			tunnel.Settings = &TunnelSettings{}
+		}
+		if settings.CookieSecret == "" {
+			secret := make([]byte, 32)
+			if _, err := rand.Read(secret); err != nil {
+				return fmt.Errorf("failed to generate cookie secret: %w", err)
+			}
+			settings.CookieSecret = hex.EncodeToString(secret)
+		}
+		tunnel.Settings.OIDC = settings
+
+		return nil
+	})
+}
+
+// SetTunnelTarget sets the local address a tunnel forwards to (empty uses
+// localhost and the tunnel's port)
+func (cm *ConfigManager) SetTunnelTarget(tunnelID, target string) error {
+	return cm.update(func(config *AppConfig) error {
+		tunnel, exists := config.Tunnels[tunnelID]
+		if !exists {
+			return fmt.Errorf("tunnel %s not found", tunnelID)
+		}
+
+		if tunnel.Settings == nil {
+			tunnel.Settings = &TunnelSettings{}
+		}
+		tunnel.Settings.Target = target
+
+		return nil
+	})
+}
+
+// SetTunnelPreflight sets the checks a tunnel's local service must pass
+// before the tunnel connects
+func (cm *ConfigManager) SetTunnelPreflight(tunnelID string, preflight PreflightSettings) error {
+	return cm.update(func(config *AppConfig) error {
+		tunnel, exists := config.Tunnels[tunnelID]
+		if !exists {
+			return fmt.Errorf("tunnel %s not found", tunnelID)
+		}
+
+		if tunnel.Settings == nil {
+			tunnel.Settings = &TunnelSettings{}
+		}
+		tunnel.Settings.Preflight = preflight
+
+		return nil
+	})
+}
+
+// SetTunnelIdleTimeout sets how long a tunnel may go without requests before
+// it is disconnected (0 keeps it connected)
+func (cm *ConfigManager) SetTunnelIdleTimeout(tunnelID string, timeout time.Duration) error {
+	return cm.update(func(config *AppConfig) error {
+		tunnel, exists := config.Tunnels[tunnelID]
+		if !exists {
+			return fmt.Errorf("tunnel %s not found", tunnelID)
+		}
+
+		if tunnel.Settings == nil {
+			tunnel.Settings = &TunnelSettings{}
+		}
+		tunnel.Settings.IdleTimeout = Duration{timeout}
+
+		return nil
+	})
+}
+
+// SetTunnelExpiry sets when a tunnel is stopped, and whether it is then
+// deleted; nil removes the expiry
+func (cm *ConfigManager) SetTunnelExpiry(tunnelID string, expiry *ExpirySettings) error {
+	return cm.update(func(config *AppConfig) error {
+		tunnel, exists := config.Tunnels[tunnelID]
+		if !exists {
+			return fmt.Errorf("tunnel %s not found", tunnelID)
+		}
+
+		if tunnel.Settings == nil {
+			tunnel.Settings = &TunnelSettings{}
+		}
+		tunnel.Settings.Expiry = expiry
+
+		return nil
+	})
+}
+
+// RemoveTunnel deletes a tunnel from the local config, along with its group
+// memberships and the dependencies of other tunnels on it
+func (cm *ConfigManager) RemoveTunnel(tunnelID string) error {
+	return cm.update(func(config *AppConfig) error {
+		if _, exists := config.Tunnels[tunnelID]; !exists {
+			return fmt.Errorf("tunnel %s not found", tunnelID)
+		}
+		delete(config.Tunnels, tunnelID)
+
+		for group, members := range config.Groups {
+			members = removeID(members, tunnelID)
+			if len(members) == 0 {
+				delete(config.Groups, group)
+			} else {
+				config.Groups[group] = members
+			}
+		}
+		for _, t := range config.Tunnels {
+			if t.Settings != nil {
+				t.Settings.DependsOn = removeID(t.Settings.DependsOn, tunnelID)
+			}
+		}
+		return nil
+	})
+}
+
+// removeID returns ids without id
+func removeID(ids []string, id string) []string {
+	var kept []string
+	for _, other := range ids {
+		if other != id {
+			kept = append(kept, other)
+		}
 	}
+	return kept
+}
 
-	return fmt.Errorf("tunnel %s not found", tunnelID)
+// SetTunnelActive updates tunnel active status
+func (cm *ConfigManager) SetTunnelActive(tunnelID string, isActive bool) error {
+	return cm.update(func(config *AppConfig) error {
+		if tunnel, exists := config.Tunnels[tunnelID]; exists {
+			tunnel.IsActive = isActive
+			return nil
+		}
+
+		return fmt.Errorf("tunnel %s not found", tunnelID)
+	})
 }
 
-// GetAutoStartTunnels returns tunnels that should auto-start
+// GetAutoStartTunnels returns tunnels that should auto-start, each after the
+// tunnels it depends on
 func (cm *ConfigManager) GetAutoStartTunnels() ([]*Tunnel, error) {
 	config, err := cm.LoadConfig()
 	if err != nil {
@@ -199,6 +984,12 @@ func (cm *ConfigManager) GetAutoStartTunnels() ([]*Tunnel, error) {
 		}
 	}
 
+	// Start tunnels after the ones they depend on
+	sort.Slice(autoStartTunnels, func(i, j int) bool { return autoStartTunnels[i].Name < autoStartTunnels[j].Name })
+	if ordered, err := StartOrder(autoStartTunnels); err == nil {
+		autoStartTunnels = ordered
+	}
+
 	return autoStartTunnels, nil
 }
 
@@ -250,13 +1041,13 @@ func ClearUserData() error {
 	return os.Remove(configFile)
 }
 
-// GetConfigDir returns the configuration directory
+// GetConfigDir returns the configuration directory of the selected instance
 func GetConfigDir() (string, error) {
 	homeDir, err := os.UserHomeDir()
 	if err != nil {
 		return "", err
 	}
-	configDir := filepath.Join(homeDir, ".skyport")
+	configDir := ConfigDirFor(homeDir, instance)
 
 	// Create config directory if it doesn't exist
 	if err := os.MkdirAll(configDir, 0755); err != nil {
@@ -270,3 +1061,9 @@ func GetConfigDir() (string, error) {
 func IsDebugMode() bool {
 	return DebugMode == "true"
 }
+
+// EnableDebugMode turns on debug output in a build without it, as the
+// --debug flag does
+func EnableDebugMode() {
+	DebugMode = "true"
+}
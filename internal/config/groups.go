@@ -0,0 +1,179 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+)
+
+// localName matches the names of tunnel groups and templates
+var localName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]{0,63}$`)
+
+// StartOrder sorts tunnels so that each comes after the tunnels among them it
+// depends on, keeping the given order otherwise. Dependencies on tunnels that
+// are not in the list are ignored. It fails if the dependencies form a cycle.
+func StartOrder(tunnels []*Tunnel) ([]*Tunnel, error) {
+	byID := make(map[string]*Tunnel, len(tunnels))
+	for _, t := range tunnels {
+		byID[t.ID] = t
+	}
+
+	const (
+		visiting = 1
+		done     = 2
+	)
+	state := make(map[string]int, len(tunnels))
+	ordered := make([]*Tunnel, 0, len(tunnels))
+
+	var visit func(t *Tunnel, path []string) error
+	visit = func(t *Tunnel, path []string) error {
+		switch state[t.ID] {
+		case done:
+			return nil
+		case visiting:
+			return fmt.Errorf("tunnel dependencies form a cycle: %s", cyclePath(append(path, t.Name)))
+		}
+
+		state[t.ID] = visiting
+		for _, depID := range t.DependsOn() {
+			if dep, ok := byID[depID]; ok {
+				if err := visit(dep, append(path, t.Name)); err != nil {
+					return err
+				}
+			}
+		}
+		state[t.ID] = done
+		ordered = append(ordered, t)
+		return nil
+	}
+
+	for _, t := range tunnels {
+		if err := visit(t, nil); err != nil {
+			return nil, err
+		}
+	}
+	return ordered, nil
+}
+
+// cyclePath describes a dependency cycle, starting from the tunnel it returns to
+func cyclePath(path []string) string {
+	last := path[len(path)-1]
+	for i, name := range path {
+		if name == last {
+			path = path[i:]
+			break
+		}
+	}
+
+	description := path[0]
+	for _, name := range path[1:] {
+		description += " -> " + name
+	}
+	return description
+}
+
+// DependsOn returns the IDs of the tunnels this tunnel starts after
+func (t *Tunnel) DependsOn() []string {
+	if t.Settings == nil {
+		return nil
+	}
+	return t.Settings.DependsOn
+}
+
+// SetTunnelDependsOn sets the tunnels a tunnel starts after when they are
+// started together. It fails if that would create a dependency cycle.
+func (cm *ConfigManager) SetTunnelDependsOn(tunnelID string, dependsOn []string) error {
+	return cm.update(func(config *AppConfig) error {
+		tunnel, exists := config.Tunnels[tunnelID]
+		if !exists {
+			return fmt.Errorf("tunnel %s not found", tunnelID)
+		}
+		for _, depID := range dependsOn {
+			if _, exists := config.Tunnels[depID]; !exists {
+				return fmt.Errorf("tunnel %s not found", depID)
+			}
+			if depID == tunnelID {
+				return fmt.Errorf("tunnel %s cannot depend on itself", tunnel.Name)
+			}
+		}
+
+		if tunnel.Settings == nil {
+			tunnel.Settings = &TunnelSettings{}
+		}
+		previous := tunnel.Settings.DependsOn
+		tunnel.Settings.DependsOn = dependsOn
+
+		all := make([]*Tunnel, 0, len(config.Tunnels))
+		for _, t := range config.Tunnels {
+			all = append(all, t)
+		}
+		if _, err := StartOrder(all); err != nil {
+			tunnel.Settings.DependsOn = previous
+			return err
+		}
+		return nil
+	})
+}
+
+// GroupNames returns the names of the tunnel groups, sorted
+func (cm *ConfigManager) GroupNames() ([]string, error) {
+	config, err := cm.LoadConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(config.Groups))
+	for name := range config.Groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// GroupTunnels returns the tunnels of a group in start order. Members that
+// are no longer in the local config are left out.
+func (cm *ConfigManager) GroupTunnels(group string) ([]*Tunnel, error) {
+	config, err := cm.LoadConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	members, exists := config.Groups[group]
+	if !exists {
+		return nil, fmt.Errorf("tunnel group %s not found", group)
+	}
+
+	tunnels := make([]*Tunnel, 0, len(members))
+	for _, tunnelID := range members {
+		if t, exists := config.Tunnels[tunnelID]; exists {
+			tunnels = append(tunnels, t)
+		}
+	}
+	return StartOrder(tunnels)
+}
+
+// SetGroup replaces the tunnels of a group, creating it if needed. A group
+// without tunnels is deleted.
+func (cm *ConfigManager) SetGroup(group string, tunnelIDs []string) error {
+	if !localName.MatchString(group) {
+		return fmt.Errorf("invalid group name %q: use up to 64 letters, digits, dots, dashes and underscores", group)
+	}
+
+	return cm.update(func(config *AppConfig) error {
+		if len(tunnelIDs) == 0 {
+			delete(config.Groups, group)
+			return nil
+		}
+
+		for _, tunnelID := range tunnelIDs {
+			if _, exists := config.Tunnels[tunnelID]; !exists {
+				return fmt.Errorf("tunnel %s not found", tunnelID)
+			}
+		}
+		if config.Groups == nil {
+			config.Groups = make(map[string][]string)
+		}
+		config.Groups[group] = tunnelIDs
+		return nil
+	})
+}
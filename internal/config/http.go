@@ -0,0 +1,58 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// serverTransports holds one transport per TLS configuration, shared by
+// every client talking to the SkyPort server so connections are reused
+var (
+	transportsMu     sync.Mutex
+	serverTransports = make(map[string]*http.Transport)
+)
+
+// ServerTransport returns the shared HTTP transport for the SkyPort server:
+// proxies from the environment (HTTPS_PROXY, NO_PROXY), the configured TLS
+// settings, bounded dial and handshake times, and kept-alive connections
+func (c *Config) ServerTransport() *http.Transport {
+	key := fmt.Sprintf("%+v %v", c.GetSettings().TLS, c.tlsErr)
+
+	transportsMu.Lock()
+	defer transportsMu.Unlock()
+
+	if transport, ok := serverTransports[key]; ok {
+		return transport
+	}
+
+	dialer := &net.Dialer{Timeout: 10 * time.Second, KeepAlive: 30 * time.Second}
+	transport := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		TLSClientConfig:       c.TLSConfig(),
+		TLSHandshakeTimeout:   10 * time.Second,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          16,
+		MaxIdleConnsPerHost:   4,
+		IdleConnTimeout:       90 * time.Second,
+		ExpectContinueTimeout: time.Second,
+	}
+	if c.tlsErr != nil {
+		// Proxied connections are dialed here too
+		transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return nil, fmt.Errorf("refusing to connect to the server: %w", c.tlsErr)
+		}
+	}
+	serverTransports[key] = transport
+	return transport
+}
+
+// HTTPClient returns a client for requests to the SkyPort server, with an
+// overall timeout per request. Clients share ServerTransport.
+func (c *Config) HTTPClient(timeout time.Duration) *http.Client {
+	return &http.Client{Transport: c.ServerTransport(), Timeout: timeout}
+}
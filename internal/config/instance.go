@@ -0,0 +1,43 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+)
+
+// instance names the agent instance this process belongs to; "" is the
+// default one. Each instance has its own config directory, and with it its
+// own login, tunnels, control sockets and logs, so several can run on one
+// machine for different accounts or servers.
+var instance string
+
+// instanceName matches names that are safe in paths and systemd unit names
+var instanceName = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,31}$`)
+
+// SetInstance selects the agent instance, as the --instance flag does. An
+// empty name or "default" selects the default instance.
+func SetInstance(name string) error {
+	if name == "default" {
+		name = ""
+	}
+	if name != "" && !instanceName.MatchString(name) {
+		return fmt.Errorf("invalid instance name %q: use up to 32 lowercase letters, digits and dashes", name)
+	}
+	instance = name
+	return nil
+}
+
+// Instance returns the selected instance name, or "" for the default instance
+func Instance() string {
+	return instance
+}
+
+// ConfigDirFor returns the config directory of an instance ("" for the
+// default one) for the user with the given home directory
+func ConfigDirFor(home, instance string) string {
+	if instance == "" {
+		return filepath.Join(home, ".skyport")
+	}
+	return filepath.Join(home, ".skyport", "instances", instance)
+}
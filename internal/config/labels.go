@@ -0,0 +1,103 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var (
+	labelKey   = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9._/-]{0,62})$`)
+	labelValue = regexp.MustCompile(`^[A-Za-z0-9._-]{0,63}$`)
+)
+
+// TunnelLabels returns a tunnel's labels: the ones stored locally when the
+// server could not store them, otherwise the server's
+func (t *Tunnel) TunnelLabels() map[string]string {
+	if t.Settings != nil && t.Settings.Labels != nil {
+		return t.Settings.Labels
+	}
+	return t.Labels
+}
+
+// ParseLabel splits a "key=value" label and checks both parts
+func ParseLabel(label string) (string, string, error) {
+	key, value, ok := strings.Cut(label, "=")
+	if !ok {
+		return "", "", fmt.Errorf("invalid label %q: use key=value", label)
+	}
+	if err := validLabelKey(key); err != nil {
+		return "", "", err
+	}
+	if !labelValue.MatchString(value) {
+		return "", "", fmt.Errorf("invalid label value %q: use up to 63 letters, digits, '.', '_' or '-'", value)
+	}
+	return key, value, nil
+}
+
+func validLabelKey(key string) error {
+	if !labelKey.MatchString(key) {
+		return fmt.Errorf("invalid label key %q: use up to 63 letters, digits, '.', '_', '/' or '-'", key)
+	}
+	return nil
+}
+
+// MatchesLabels reports whether a tunnel has all the given labels. An empty
+// value matches any tunnel that has the key.
+func (t *Tunnel) MatchesLabels(selector map[string]string) bool {
+	labels := t.TunnelLabels()
+	for key, value := range selector {
+		actual, ok := labels[key]
+		if !ok || (value != "" && actual != value) {
+			return false
+		}
+	}
+	return true
+}
+
+// FormatLabels renders labels as "key=value" pairs sorted by key
+func FormatLabels(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		pairs = append(pairs, key+"="+labels[key])
+	}
+	return strings.Join(pairs, ",")
+}
+
+// SetTunnelLabels stores a tunnel's labels. When synced, the server holds
+// them and local labels are dropped; otherwise they are kept locally and
+// replace the server's.
+func (cm *ConfigManager) SetTunnelLabels(tunnelID string, labels map[string]string, synced bool) error {
+	return cm.update(func(config *AppConfig) error {
+		tunnel, exists := config.Tunnels[tunnelID]
+		if !exists {
+			return fmt.Errorf("tunnel %s not found", tunnelID)
+		}
+		for key := range labels {
+			if err := validLabelKey(key); err != nil {
+				return err
+			}
+		}
+
+		if synced {
+			tunnel.Labels = labels
+			if tunnel.Settings != nil {
+				tunnel.Settings.Labels = nil
+			}
+			return nil
+		}
+
+		if tunnel.Settings == nil {
+			tunnel.Settings = &TunnelSettings{}
+		}
+		tunnel.Settings.Labels = labels
+		return nil
+	})
+}
@@ -0,0 +1,43 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// systemd resource control values: a size with an optional K/M/G/T suffix, a
+// percentage of physical memory or "infinity" for MemoryMax, and a percentage
+// of one CPU for CPUQuota
+var (
+	memoryMaxPattern = regexp.MustCompile(`^([0-9]+[KMGT]?|[0-9]+(\.[0-9]+)?%|infinity)$`)
+	cpuQuotaPattern  = regexp.MustCompile(`^[0-9]+%$`)
+)
+
+func (r ResourceSettings) validate() error {
+	if r.MaxConcurrentRequests < 0 || r.MaxBufferMB < 0 || r.MaxSamplesMB < 0 || r.MaxLogMB < 0 {
+		return fmt.Errorf("resources limits must not be negative")
+	}
+	if r.MemoryMax != "" && !memoryMaxPattern.MatchString(r.MemoryMax) {
+		return fmt.Errorf("resources.memory_max must be a size such as 256M, a percentage or infinity (got %q)", r.MemoryMax)
+	}
+	if r.CPUQuota != "" && !cpuQuotaPattern.MatchString(r.CPUQuota) {
+		return fmt.Errorf("resources.cpu_quota must be a percentage such as 50%% (got %q)", r.CPUQuota)
+	}
+	return nil
+}
+
+// CapRetention fits a rotated file of sizeMB with the given number of
+// backups into totalMB (0 = no cap), dropping backups before shrinking the
+// file itself
+func CapRetention(sizeMB, backups, totalMB int) (int, int) {
+	if totalMB <= 0 {
+		return sizeMB, backups
+	}
+	for backups > 0 && sizeMB*(backups+1) > totalMB {
+		backups--
+	}
+	if sizeMB > totalMB {
+		sizeMB = totalMB
+	}
+	return sizeMB, backups
+}
@@ -0,0 +1,40 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// restartPolicies are the values systemd accepts for Restart=
+var restartPolicies = []string{"no", "always", "on-success", "on-failure", "on-abnormal", "on-abort", "on-watchdog"}
+
+// environmentName matches variable names systemd accepts in Environment=
+var environmentName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+func (s ServiceSettings) validate() error {
+	valid := false
+	for _, policy := range restartPolicies {
+		valid = valid || s.Restart == policy
+	}
+	if !valid {
+		return fmt.Errorf("service.restart must be one of %s (got %q)", strings.Join(restartPolicies, ", "), s.Restart)
+	}
+	if s.Nice < -20 || s.Nice > 19 {
+		return fmt.Errorf("service.nice must be between -20 and 19 (got %d)", s.Nice)
+	}
+	for name, value := range s.Environment {
+		if !environmentName.MatchString(name) {
+			return fmt.Errorf("service.environment name %q is not a valid variable name", name)
+		}
+		if strings.ContainsAny(value, "\r\n") {
+			return fmt.Errorf("service.environment value of %s must be a single line", name)
+		}
+	}
+	for _, unit := range s.After {
+		if unit == "" || strings.ContainsAny(unit, " \t\r\n") {
+			return fmt.Errorf("service.after entry %q must be a single unit name", unit)
+		}
+	}
+	return nil
+}
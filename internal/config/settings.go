@@ -0,0 +1,1137 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Duration is a time.Duration that is stored in JSON as a string like "15s"
+type Duration struct {
+	time.Duration
+}
+
+// MarshalJSON encodes the duration as a Go duration string
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// UnmarshalJSON accepts either a duration string ("30s", "2m") or a number of seconds
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+
+	switch v := value.(type) {
+	case string:
+		parsed, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q: %w", v, err)
+		}
+		d.Duration = parsed
+	case float64:
+		d.Duration = time.Duration(v * float64(time.Second))
+	default:
+		return fmt.Errorf("invalid duration: %s", string(data))
+	}
+
+	return nil
+}
+
+// Settings holds agent tuning options stored under "settings" in skyport.json
+type Settings struct {
+	Intervals     Intervals            `json:"intervals"`
+	Probes        ProbeSettings        `json:"probes"`
+	Connectivity  ConnectivitySettings `json:"connectivity"`
+	Notifications NotificationSettings `json:"notifications"`
+	Telemetry     TelemetrySettings    `json:"telemetry"`
+	TLS           TLSSettings          `json:"tls"`
+	Discovery     DiscoverySettings    `json:"discovery"`
+	MDNS          MDNSSettings         `json:"mdns"`
+	Resources     ResourceSettings     `json:"resources"`
+	Service       ServiceSettings      `json:"service"`
+	Credentials   CredentialSettings   `json:"credentials"`
+	Heartbeat     HeartbeatSettings    `json:"heartbeat"`
+
+	RemoteCommands RemoteCommandSettings `json:"remote_commands"`
+}
+
+// Commands the server can send to the daemon
+const (
+	RemoteStartTunnel        = "start_tunnel"
+	RemoteStopTunnel         = "stop_tunnel"
+	RemoteRefreshConfig      = "refresh_config"
+	RemoteCollectDiagnostics = "collect_diagnostics"
+)
+
+// RemoteCommandSettings controls which commands the server may send to the
+// daemon. None are allowed by default.
+type RemoteCommandSettings struct {
+	Allow   []string `json:"allow,omitempty"`   // Command names, or "all"
+	Tunnels []string `json:"tunnels,omitempty"` // Names or IDs start and stop may act on; empty means any
+}
+
+// Allows reports whether the server may send the given command
+func (r RemoteCommandSettings) Allows(command string) bool {
+	for _, allowed := range r.Allow {
+		if allowed == command || allowed == "all" {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsTunnel reports whether start and stop commands may act on a tunnel
+func (r RemoteCommandSettings) AllowsTunnel(t *Tunnel) bool {
+	if len(r.Tunnels) == 0 {
+		return true
+	}
+	for _, allowed := range r.Tunnels {
+		if allowed == t.Name || allowed == t.ID {
+			return true
+		}
+	}
+	return false
+}
+
+// HeartbeatSettings controls the health reports the daemon sends to the
+// server: agent version, OS, uptime and per-tunnel stats
+type HeartbeatSettings struct {
+	Disabled bool     `json:"disabled"`
+	Interval Duration `json:"interval"` // How often a report is sent
+}
+
+// CredentialSettings controls where the login token is stored
+type CredentialSettings struct {
+	// Storage is "keyring" (the default: the platform keyring, or an
+	// encrypted token file where the keyring is unavailable) or "file" (the
+	// encrypted token file only; Windows and macOS)
+	Storage string `json:"storage,omitempty"`
+}
+
+// ServiceSettings customizes the systemd unit 'skyport service install' writes
+type ServiceSettings struct {
+	Template    string            `json:"template,omitempty"`    // text/template file for the whole unit; relative to ~/.skyport
+	Environment map[string]string `json:"environment,omitempty"` // Extra Environment= variables
+	After       []string          `json:"after,omitempty"`       // Units to start after, besides network-online.target
+	Restart     string            `json:"restart,omitempty"`     // Restart= policy (default "always")
+	Nice        int               `json:"nice,omitempty"`        // Scheduling priority, -20 (highest) to 19
+}
+
+// ResourceSettings caps what the agent uses, so it behaves on small machines.
+// Zero means no limit.
+type ResourceSettings struct {
+	MaxConcurrentRequests int    `json:"max_concurrent_requests,omitempty"` // Across all tunnels; more are answered 503
+	MaxBufferMB           int    `json:"max_buffer_mb,omitempty"`           // Request and response bodies held in memory at once
+	MaxSamplesMB          int    `json:"max_samples_mb,omitempty"`          // Recorded requests kept per tunnel, rotated files included
+	MaxLogMB              int    `json:"max_log_mb,omitempty"`              // Access log kept per tunnel, rotated files included
+	MemoryMax             string `json:"memory_max,omitempty"`              // systemd MemoryMax= for the service, e.g. "256M"
+	CPUQuota              string `json:"cpu_quota,omitempty"`               // systemd CPUQuota= for the service, e.g. "50%"
+}
+
+// MDNSSettings controls advertising connected tunnels' public URLs to the
+// local network over mDNS/Bonjour
+type MDNSSettings struct {
+	Enabled bool `json:"enabled"`
+}
+
+// DiscoverySettings controls starting tunnels for containers that carry
+// skyport.* labels
+type DiscoverySettings struct {
+	Docker     bool     `json:"docker"`
+	DockerHost string   `json:"docker_host,omitempty"` // Default: DOCKER_HOST, then unix:///var/run/docker.sock
+	Interval   Duration `json:"interval"`              // How often running containers are checked
+}
+
+// TLSSettings controls connections to the SkyPort server: how its
+// certificate is verified, for self-hosted servers using an internal CA or a
+// self-signed certificate, and which TLS versions and cipher suites are
+// allowed, for regulated environments
+type TLSSettings struct {
+	CAFile             string   `json:"ca_file,omitempty"`              // PEM bundle trusted in addition to the system roots
+	InsecureSkipVerify bool     `json:"insecure_skip_verify,omitempty"` // Disables verification entirely; for testing only
+	MinVersion         string   `json:"min_version,omitempty"`          // "1.2" (default) or "1.3"
+	CipherSuites       []string `json:"cipher_suites,omitempty"`        // TLS 1.2 suites allowed, e.g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 (default: Go's secure suites)
+	FIPS               bool     `json:"fips,omitempty"`                 // Only FIPS 140-approved versions, suites and curves
+}
+
+// Intervals controls heartbeat, health check and maintenance timings
+type Intervals struct {
+	PingInterval        Duration `json:"ping_interval"`         // WebSocket ping frequency
+	ReadTimeout         Duration `json:"read_timeout"`          // Connection considered dead after this much silence
+	HealthCheckInterval Duration `json:"health_check_interval"` // Health monitor check frequency
+	MaintenanceInterval Duration `json:"maintenance_interval"`  // Tunnel sync and reconnect queue frequency
+	TunnelListTTL       Duration `json:"tunnel_list_ttl"`       // How long CLI commands reuse the cached tunnel list
+}
+
+// ProbeSettings controls end-to-end synthetic probes through the public tunnel URL
+type ProbeSettings struct {
+	Enabled bool     `json:"enabled"`
+	Scheme  string   `json:"scheme"`  // "http" or "https" for the public URL
+	Path    string   `json:"path"`    // Request path sent through the tunnel
+	Timeout Duration `json:"timeout"` // Per-probe request timeout
+}
+
+// ConnectivitySettings controls which endpoints are used to decide whether the
+// network is up. Targets are host:port pairs; when empty, the SkyPort server
+// itself is used so no third-party hosts are contacted.
+type ConnectivitySettings struct {
+	Targets []string `json:"targets,omitempty"`
+}
+
+// NotificationSettings configures alert delivery from the daemon
+type NotificationSettings struct {
+	Notifiers      []NotifierConfig `json:"notifiers,omitempty"`
+	Template       string           `json:"template,omitempty"` // Go text/template for the message body
+	RepeatInterval Duration         `json:"repeat_interval"`    // Identical alerts are suppressed for this long
+	Events         []string         `json:"events,omitempty"`   // Alert events to send; empty means all
+}
+
+// NotifierConfig describes one alert destination
+type NotifierConfig struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`               // "webhook", "slack", "discord" or "email"
+	URL      string `json:"url,omitempty"`      // Webhook URL (webhook, slack, discord)
+	Template string `json:"template,omitempty"` // Overrides the global template
+
+	// SMTP settings (email)
+	SMTPHost string   `json:"smtp_host,omitempty"`
+	SMTPPort int      `json:"smtp_port,omitempty"`
+	Username string   `json:"username,omitempty"`
+	Password string   `json:"password,omitempty"`
+	From     string   `json:"from,omitempty"`
+	To       []string `json:"to,omitempty"`
+}
+
+// TelemetrySettings configures OpenTelemetry export over OTLP/HTTP
+type TelemetrySettings struct {
+	Enabled        bool              `json:"enabled"`
+	Endpoint       string            `json:"endpoint,omitempty"`     // Collector base URL; /v1/traces and /v1/metrics are appended
+	Headers        map[string]string `json:"headers,omitempty"`      // Extra headers sent to the collector (e.g. API keys)
+	ServiceName    string            `json:"service_name,omitempty"` // service.name resource attribute
+	ExportInterval Duration          `json:"export_interval"`        // How often spans and metrics are pushed
+}
+
+// TunnelSettings holds local per-tunnel options stored under "settings" on each tunnel
+type TunnelSettings struct {
+	Target       string               `json:"target,omitempty"` // host:port requests are forwarded to, e.g. [::1]:8080 (default localhost:<local_port>)
+	AccessLog    AccessLogSettings    `json:"access_log"`
+	Sampling     SamplingSettings     `json:"sampling"`
+	BasicAuth    BasicAuthSettings    `json:"basic_auth"`
+	IPFilter     IPFilterSettings     `json:"ip_filter"`
+	PathFilter   PathFilterSettings   `json:"path_filter"`
+	HeaderFilter HeaderFilterSettings `json:"header_filter"`
+	RateLimit    RateLimitSettings    `json:"rate_limit"`
+	Rewrite      RewriteSettings      `json:"rewrite"`
+	Headers      HeaderSettings       `json:"headers"`
+	Sanitize     SanitizeSettings     `json:"sanitize"`
+	CORS         CORSSettings         `json:"cors"`
+	Cache        CacheSettings        `json:"cache"`
+	Static       StaticSettings       `json:"static"`
+	Hooks        []HookSettings       `json:"hooks,omitempty"`
+	Scan         ScanSettings         `json:"scan"`
+	Compression  CompressionSettings  `json:"compression"`
+	Limits       LimitSettings        `json:"limits"`
+	OIDC         OIDCSettings         `json:"oidc"`
+	IdleTimeout  Duration             `json:"idle_timeout"` // Disconnect after no requests for this long (0 = never)
+	Preflight    PreflightSettings    `json:"preflight"`
+	DependsOn    []string             `json:"depends_on,omitempty"` // IDs of tunnels this one starts after when started together
+	Labels       map[string]string    `json:"labels,omitempty"`     // Replace the server's labels when it cannot store them
+	Expiry       *ExpirySettings      `json:"expiry,omitempty"`
+	LocalHTTPS   LocalHTTPSSettings   `json:"local_https"`
+	Mirror       MirrorSettings       `json:"mirror"`
+	Pages        PagesSettings        `json:"pages"`
+	Maintenance  MaintenanceSettings  `json:"maintenance"`
+	Split        SplitSettings        `json:"split"`
+	WebSocket    WebSocketSettings    `json:"websocket"`
+}
+
+// LocalHTTPSSettings serve a tunnel's local service over HTTPS on a local
+// port while the tunnel runs, with a certificate from the agent's
+// development CA, to test secure cookies and SameSite locally
+type LocalHTTPSSettings struct {
+	Enabled bool `json:"enabled"`
+	Port    int  `json:"port,omitempty"` // Local port the HTTPS listener is on (default 8443)
+}
+
+// MirrorSettings expose a tunnel on a local port while it runs. Requests to
+// the port go through the same filters, limits and rewrites as requests
+// through the tunnel, to test auth gates and rewrites without the public URL.
+type MirrorSettings struct {
+	Enabled bool `json:"enabled"`
+	Port    int  `json:"port,omitempty"` // Local port the mirror listens on (default 7001)
+}
+
+// PagesSettings replace the pages the agent answers with itself (the 502
+// error page, maintenance page, basic auth prompt and identity provider
+// login page) with HTML templates from a directory, for visitors' browsers
+type PagesSettings struct {
+	Dir string `json:"dir,omitempty"` // Holds 502.html, maintenance.html, 401.html and login.html; each is optional
+}
+
+// MaintenanceSettings make the agent answer every request with 503 and the
+// maintenance page instead of forwarding it
+type MaintenanceSettings struct {
+	Enabled bool   `json:"enabled"`
+	Message string `json:"message,omitempty"` // Shown to visitors
+}
+
+// SplitSettings send a share of a tunnel's requests to a second local
+// target, to canary a new build of the local service behind the same URL
+type SplitSettings struct {
+	Enabled bool   `json:"enabled"`
+	Target  string `json:"target,omitempty"`  // host:port of the alternate service
+	Percent int    `json:"percent,omitempty"` // Share of requests sent to Target, 0-100
+	Sticky  bool   `json:"sticky,omitempty"`  // Always send a client IP to the same target
+}
+
+// ExpirySettings stop a tunnel at a fixed time, for tunnels shared "just for
+// an hour"
+type ExpirySettings struct {
+	At     time.Time `json:"at"`
+	Delete bool      `json:"delete,omitempty"` // Also delete the tunnel from the server
+}
+
+// PreflightSettings check the local service before a tunnel connects, so a
+// tunnel whose service is down fails to start instead of answering 502s
+type PreflightSettings struct {
+	Enabled bool     `json:"enabled"`
+	Path    string   `json:"path,omitempty"` // HTTP path that must answer 2xx or 3xx; empty only checks the port accepts connections
+	Timeout Duration `json:"timeout"`        // default 2s
+}
+
+// OIDCSettings puts an OpenID Connect login in front of a tunnel: visitors
+// without a session are redirected to the identity provider, and only the
+// allowed emails and domains reach the local service
+type OIDCSettings struct {
+	Enabled        bool     `json:"enabled"`
+	Issuer         string   `json:"issuer,omitempty"` // e.g. https://accounts.google.com
+	ClientID       string   `json:"client_id,omitempty"`
+	ClientSecret   string   `json:"client_secret,omitempty"`
+	RedirectURL    string   `json:"redirect_url,omitempty"` // Callback URL registered with the provider; default built from the request's Host
+	Scopes         []string `json:"scopes,omitempty"`       // Default: openid email profile
+	AllowedEmails  []string `json:"allowed_emails,omitempty"`
+	AllowedDomains []string `json:"allowed_domains,omitempty"`
+	SessionTTL     Duration `json:"session_ttl"`             // How long a login lasts (default 12h)
+	CookieSecret   string   `json:"cookie_secret,omitempty"` // Signs session cookies; generated on first enable
+}
+
+// LimitSettings bounds the requests the agent forwards to the local service
+type LimitSettings struct {
+	MaxRequestBodyBytes int64 `json:"max_request_body_bytes,omitempty"` // Larger requests get 413 (0 = unlimited)
+}
+
+// WebSocketSettings limit the WebSocket sessions the agent proxies to the
+// local service. A client breaking a limit has its session closed with the
+// limit's close code; idle sessions are closed with 1001 (going away).
+type WebSocketSettings struct {
+	MaxMessageBytes   int64    `json:"max_message_bytes,omitempty"`   // Largest message in either direction (0 = unlimited)
+	MessagesPerSecond float64  `json:"messages_per_second,omitempty"` // Messages a client may send per second, per session (0 = unlimited)
+	MessageBurst      int      `json:"message_burst,omitempty"`       // Messages a client may send at once (default: the rate)
+	TooBigCloseCode   int      `json:"too_big_close_code,omitempty"`  // Close code for oversized messages (default 1009)
+	RateCloseCode     int      `json:"rate_close_code,omitempty"`     // Close code for clients sending too fast (default 1008)
+	IdleTimeout       Duration `json:"idle_timeout"`                  // Close sessions without messages for this long (0 = never)
+	MaxSessions       int      `json:"max_sessions,omitempty"`        // Sessions open at once; further upgrades get 503 (0 = unlimited)
+}
+
+// CompressionSettings makes the agent gzip compressible responses that the
+// local service sent uncompressed, reducing bytes sent over the tunnel
+type CompressionSettings struct {
+	Enabled  bool `json:"enabled"`
+	MinBytes int  `json:"min_bytes,omitempty"` // Smaller responses are sent as is (default 1024)
+	Level    int  `json:"level,omitempty"`     // gzip level 1 (fastest) to 9 (smallest), default 5
+}
+
+// Hook phases
+const (
+	HookPhaseRequest  = "request"
+	HookPhaseResponse = "response"
+	HookPhaseBoth     = "both"
+)
+
+// HookSettings runs an external program for each request and/or response.
+// The program gets the exchange as JSON on stdin and may print JSON to modify
+// it, answer the request itself, or replace the response.
+type HookSettings struct {
+	Command  string   `json:"command"`
+	Args     []string `json:"args,omitempty"`
+	Phase    string   `json:"phase,omitempty"`     // "request" (default), "response" or "both"
+	Timeout  Duration `json:"timeout"`             // Default 5s
+	FailOpen bool     `json:"fail_open,omitempty"` // Continue unchanged if the hook fails instead of answering 502
+}
+
+// Content scanning actions
+const (
+	ScanActionBlock  = "block"
+	ScanActionRedact = "redact"
+)
+
+// ScanSettings check response bodies for secrets and personal data before
+// they leave through the tunnel. Responses matching a pattern, or flagged by
+// the scan command, are blocked or have the matches redacted.
+type ScanSettings struct {
+	Enabled     bool          `json:"enabled"`
+	Action      string        `json:"action,omitempty"`       // "block" (default) or "redact"
+	Patterns    []ScanPattern `json:"patterns,omitempty"`     // Checked in addition to the built-in patterns
+	SkipBuiltin bool          `json:"skip_builtin,omitempty"` // Only check the configured patterns and command
+	Command     string        `json:"command,omitempty"`      // Program given the body on stdin, printing JSON findings
+	Args        []string      `json:"args,omitempty"`
+	Timeout     Duration      `json:"timeout"`             // For the command; default 5s
+	FailOpen    bool          `json:"fail_open,omitempty"` // Send the response unscanned if the command fails instead of blocking it
+}
+
+// ScanPattern is a named regular expression response bodies are scanned for
+type ScanPattern struct {
+	Name  string `json:"name"`
+	Regex string `json:"regex"`
+}
+
+// StaticSettings makes the agent serve a local directory itself instead of
+// forwarding to the tunnel's local port
+type StaticSettings struct {
+	Dir     string `json:"dir,omitempty"`     // Absolute path of the directory to serve; empty forwards to local_port
+	SPA     bool   `json:"spa,omitempty"`     // Serve index.html for unknown extensionless paths (client-side routing)
+	Listing bool   `json:"listing,omitempty"` // List directories that have no index.html
+}
+
+// CacheSettings controls the agent's in-memory cache of GET responses, so
+// repeated requests for static assets don't reach the local service
+type CacheSettings struct {
+	Enabled    bool     `json:"enabled"`
+	TTL        Duration `json:"ttl"`                    // Cache every 200 response this long, or 0 to follow Cache-Control max-age
+	MaxSizeMB  int      `json:"max_size_mb,omitempty"`  // Least recently used responses are evicted beyond this
+	MaxEntryKB int      `json:"max_entry_kb,omitempty"` // Larger responses are not cached
+}
+
+// CORSSettings makes the agent answer CORS preflight requests and add CORS
+// headers to responses, so browsers can call a tunneled API from other origins
+type CORSSettings struct {
+	Enabled          bool     `json:"enabled"`
+	AllowOrigins     []string `json:"allow_origins,omitempty"`  // Origins allowed, or "*" for any
+	AllowMethods     []string `json:"allow_methods,omitempty"`  // Methods allowed in preflight responses
+	AllowHeaders     []string `json:"allow_headers,omitempty"`  // Request headers allowed (default: whatever the browser asks for)
+	ExposeHeaders    []string `json:"expose_headers,omitempty"` // Response headers readable by scripts
+	AllowCredentials bool     `json:"allow_credentials,omitempty"`
+	MaxAge           int      `json:"max_age,omitempty"` // Seconds browsers may cache a preflight response
+}
+
+// HeaderSettings adds, overrides or strips headers on requests sent to the
+// local service and on responses sent back to clients
+type HeaderSettings struct {
+	Request  HeaderRules `json:"request"`
+	Response HeaderRules `json:"response"`
+}
+
+// Cookie policies for SanitizeSettings
+const (
+	CookiesAll   = "all"   // Forward every cookie
+	CookiesNone  = "none"  // Strip the Cookie header
+	CookiesAllow = "allow" // Forward only the cookies in AllowCookies
+)
+
+// SanitizeSettings strips inbound headers before requests reach the local
+// service, so a dev server exposed through a tunnel doesn't see credentials or
+// internal headers it shouldn't. Hop-by-hop headers are always stripped.
+type SanitizeSettings struct {
+	StripAuthorization bool     `json:"strip_authorization,omitempty"` // Drop Authorization instead of passing it through
+	Cookies            string   `json:"cookies,omitempty"`             // "all" (default), "none" or "allow"
+	AllowCookies       []string `json:"allow_cookies,omitempty"`       // Cookies forwarded with "allow"
+	Deny               []string `json:"deny,omitempty"`                // More headers to strip; "X-Internal-*" matches a prefix
+}
+
+// HeaderRules are applied in order: remove, then set, then add
+type HeaderRules struct {
+	Add    map[string]string `json:"add,omitempty"`    // Added only if the header is not already present
+	Set    map[string]string `json:"set,omitempty"`    // Added, replacing any existing value
+	Remove []string          `json:"remove,omitempty"` // Stripped
+}
+
+// Empty reports whether there are no rules
+func (r HeaderRules) Empty() bool {
+	return len(r.Add) == 0 && len(r.Set) == 0 && len(r.Remove) == 0
+}
+
+// RewriteSettings changes the request path before it is forwarded, for apps
+// mounted under a different base path locally than publicly
+type RewriteSettings struct {
+	StripPrefix string        `json:"strip_prefix,omitempty"` // Removed from the start of matching paths
+	AddPrefix   string        `json:"add_prefix,omitempty"`   // Prepended to every path (after stripping)
+	Rules       []RewriteRule `json:"rules,omitempty"`        // Applied in order after the prefixes
+}
+
+// RewriteRule is a regular expression substitution on the request path.
+// Replace may refer to capture groups as $1 or ${name}.
+type RewriteRule struct {
+	Match   string `json:"match"`
+	Replace string `json:"replace"`
+}
+
+// RateLimitSettings limits how fast clients can send requests through a tunnel.
+// Requests over the limit are answered with 429 by the agent.
+type RateLimitSettings struct {
+	Enabled        bool    `json:"enabled"`
+	PerClientRPS   float64 `json:"per_client_rps,omitempty"`   // Requests per second per client IP (0 = unlimited)
+	PerClientBurst int     `json:"per_client_burst,omitempty"` // Requests a client can send at once
+	GlobalRPS      float64 `json:"global_rps,omitempty"`       // Requests per second across all clients (0 = unlimited)
+	GlobalBurst    int     `json:"global_burst,omitempty"`
+}
+
+// IPFilterSettings restricts which client IPs may reach a tunnel. Entries are
+// IP addresses or CIDR ranges; deny entries take precedence over allow entries.
+type IPFilterSettings struct {
+	Allow []string `json:"allow,omitempty"` // If set, only these clients are accepted
+	Deny  []string `json:"deny,omitempty"`  // These clients are always rejected
+}
+
+// PathFilterSettings restricts which requests may reach a tunnel by method
+// and path. Rules are a path pattern, optionally after methods, such as
+// "/admin/*" or "POST /webhooks/*"; deny rules take precedence over allow
+// rules.
+type PathFilterSettings struct {
+	Allow []string `json:"allow,omitempty"` // If set, only matching requests are accepted
+	Deny  []string `json:"deny,omitempty"`  // Matching requests are always rejected
+}
+
+// HeaderFilterSettings restricts which requests may reach a tunnel by the
+// headers the server forwards. Rules are "<header> <op> <value>", such as
+// "country != IN" or "user-agent ~ curl", or "bots"; deny rules take
+// precedence over allow rules.
+type HeaderFilterSettings struct {
+	Allow []string `json:"allow,omitempty"` // If set, only matching requests are accepted
+	Deny  []string `json:"deny,omitempty"`  // Matching requests are always rejected
+}
+
+// BasicAuthSettings makes the agent require HTTP basic auth on a tunnel before
+// requests reach the local service
+type BasicAuthSettings struct {
+	Enabled      bool              `json:"enabled"`
+	Realm        string            `json:"realm,omitempty"`
+	Users        map[string]string `json:"users,omitempty"`         // User name -> htpasswd-style password hash
+	HtpasswdFile string            `json:"htpasswd_file,omitempty"` // Additional users read from an htpasswd file
+}
+
+// AccessLogSettings controls the per-tunnel log of proxied requests
+type AccessLogSettings struct {
+	Enabled    bool   `json:"enabled"`
+	Format     string `json:"format,omitempty"`      // "apache" (combined log format) or "json"
+	MaxSizeMB  int    `json:"max_size_mb,omitempty"` // Rotate once the file grows past this size
+	MaxBackups int    `json:"max_backups,omitempty"` // Rotated files to keep
+}
+
+// SamplingSettings controls recording a percentage of requests, with headers
+// and truncated bodies, for later analysis
+type SamplingSettings struct {
+	Enabled       bool     `json:"enabled"`
+	Percent       float64  `json:"percent,omitempty"`        // Share of requests recorded, 0-100
+	MaxBodyBytes  int      `json:"max_body_bytes,omitempty"` // Bodies are truncated to this size
+	MaxSizeMB     int      `json:"max_size_mb,omitempty"`    // Rotate once the file grows past this size
+	MaxBackups    int      `json:"max_backups,omitempty"`    // Rotated files to keep
+	RedactHeaders []string `json:"redact_headers,omitempty"` // Redacted in addition to auth and cookie headers
+}
+
+// SamplingSettings returns the tunnel's sampling settings with defaults applied
+func (t *Tunnel) SamplingSettings() SamplingSettings {
+	var s SamplingSettings
+	if t.Settings != nil {
+		s = t.Settings.Sampling
+	}
+
+	if s.Percent <= 0 || s.Percent > 100 {
+		s.Percent = 1
+	}
+	if s.MaxBodyBytes <= 0 {
+		s.MaxBodyBytes = 4096
+	}
+	if s.MaxSizeMB <= 0 {
+		s.MaxSizeMB = 50
+	}
+	if s.MaxBackups <= 0 {
+		s.MaxBackups = 3
+	}
+	return s
+}
+
+// SamplesPath returns the file a tunnel's request samples are written to
+func SamplesPath(tunnel *Tunnel) (string, error) {
+	return tunnelLogPath(tunnel, "samples", ".jsonl")
+}
+
+// OIDCSettings returns the tunnel's identity provider settings with defaults applied
+func (t *Tunnel) OIDCSettings() OIDCSettings {
+	var s OIDCSettings
+	if t.Settings != nil {
+		s = t.Settings.OIDC
+	}
+
+	if len(s.Scopes) == 0 {
+		s.Scopes = []string{"openid", "email", "profile"}
+	}
+	if s.SessionTTL.Duration <= 0 {
+		s.SessionTTL = Duration{12 * time.Hour}
+	}
+	return s
+}
+
+// CompressionSettings returns the tunnel's compression settings with defaults applied
+func (t *Tunnel) CompressionSettings() CompressionSettings {
+	var s CompressionSettings
+	if t.Settings != nil {
+		s = t.Settings.Compression
+	}
+
+	if s.MinBytes <= 0 {
+		s.MinBytes = 1024
+	}
+	if s.Level < 1 || s.Level > 9 {
+		s.Level = 5
+	}
+	return s
+}
+
+// HookSettings returns the tunnel's hooks with defaults applied
+func (t *Tunnel) HookSettings() []HookSettings {
+	if t.Settings == nil {
+		return nil
+	}
+
+	hooks := make([]HookSettings, len(t.Settings.Hooks))
+	for i, hook := range t.Settings.Hooks {
+		if hook.Phase == "" {
+			hook.Phase = HookPhaseRequest
+		}
+		if hook.Timeout.Duration <= 0 {
+			hook.Timeout = Duration{5 * time.Second}
+		}
+		hooks[i] = hook
+	}
+	return hooks
+}
+
+// SanitizeSettings returns the tunnel's header sanitization settings with
+// defaults applied
+func (t *Tunnel) SanitizeSettings() SanitizeSettings {
+	var s SanitizeSettings
+	if t.Settings != nil {
+		s = t.Settings.Sanitize
+	}
+
+	if s.Cookies != CookiesNone && s.Cookies != CookiesAllow {
+		s.Cookies = CookiesAll
+	}
+	return s
+}
+
+// ScanSettings returns the tunnel's content scanning settings with defaults
+// applied
+func (t *Tunnel) ScanSettings() ScanSettings {
+	var s ScanSettings
+	if t.Settings != nil {
+		s = t.Settings.Scan
+	}
+
+	if s.Action != ScanActionRedact {
+		s.Action = ScanActionBlock
+	}
+	if s.Timeout.Duration <= 0 {
+		s.Timeout = Duration{5 * time.Second}
+	}
+	return s
+}
+
+// StaticSettings returns the tunnel's static directory settings
+func (t *Tunnel) StaticSettings() StaticSettings {
+	if t.Settings == nil {
+		return StaticSettings{}
+	}
+	return t.Settings.Static
+}
+
+// LocalHTTPSSettings returns the tunnel's local HTTPS settings with defaults
+// applied
+func (t *Tunnel) LocalHTTPSSettings() LocalHTTPSSettings {
+	var s LocalHTTPSSettings
+	if t.Settings != nil {
+		s = t.Settings.LocalHTTPS
+	}
+
+	if s.Port <= 0 {
+		s.Port = 8443
+	}
+	return s
+}
+
+// MirrorSettings returns the tunnel's local mirror settings with defaults
+// applied
+func (t *Tunnel) MirrorSettings() MirrorSettings {
+	var s MirrorSettings
+	if t.Settings != nil {
+		s = t.Settings.Mirror
+	}
+
+	if s.Port <= 0 {
+		s.Port = 7001
+	}
+	return s
+}
+
+// PreflightSettings returns the tunnel's pre-connect check settings with
+// defaults applied
+func (t *Tunnel) PreflightSettings() PreflightSettings {
+	var s PreflightSettings
+	if t.Settings != nil {
+		s = t.Settings.Preflight
+	}
+
+	if s.Timeout.Duration <= 0 {
+		s.Timeout.Duration = 2 * time.Second
+	}
+	return s
+}
+
+// IdleTimeout returns how long a tunnel may go without requests before the
+// agent disconnects it, or 0 if it stays connected
+func (t *Tunnel) IdleTimeout() time.Duration {
+	if t.Settings == nil || t.Settings.IdleTimeout.Duration < 0 {
+		return 0
+	}
+	return t.Settings.IdleTimeout.Duration
+}
+
+// ExpirySettings returns when a tunnel expires; a zero At means never
+func (t *Tunnel) ExpirySettings() ExpirySettings {
+	if t.Settings == nil || t.Settings.Expiry == nil {
+		return ExpirySettings{}
+	}
+	return *t.Settings.Expiry
+}
+
+// Expired reports whether a tunnel's expiry has passed
+func (t *Tunnel) Expired() bool {
+	expiry := t.ExpirySettings()
+	return !expiry.At.IsZero() && !time.Now().Before(expiry.At)
+}
+
+// LocalAddress returns the host:port a tunnel forwards requests to
+func (t *Tunnel) LocalAddress() string {
+	if t.Settings != nil && t.Settings.Target != "" {
+		return t.Settings.Target
+	}
+	return net.JoinHostPort("localhost", strconv.Itoa(t.LocalPort))
+}
+
+// LocalTarget describes where a tunnel's requests go, for display
+func (t *Tunnel) LocalTarget() string {
+	if dir := t.StaticSettings().Dir; dir != "" {
+		return dir
+	}
+	return t.LocalAddress()
+}
+
+// ParseTarget checks a local target address such as [::1]:8080,
+// 127.0.0.1:3000 or myhost:8080 and returns it in canonical form
+func ParseTarget(target string) (string, error) {
+	host, port, err := net.SplitHostPort(target)
+	if err != nil {
+		return "", fmt.Errorf("target must be host:port, with IPv6 addresses in brackets like [::1]:8080: %w", err)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return "", fmt.Errorf("invalid port %q", port)
+	}
+	if host == "" {
+		host = "localhost"
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+// WebSocketSettings returns the tunnel's WebSocket limits with defaults applied
+func (t *Tunnel) WebSocketSettings() WebSocketSettings {
+	var s WebSocketSettings
+	if t.Settings != nil {
+		s = t.Settings.WebSocket
+	}
+
+	if s.TooBigCloseCode == 0 {
+		s.TooBigCloseCode = 1009
+	}
+	if s.RateCloseCode == 0 {
+		s.RateCloseCode = 1008
+	}
+	return s
+}
+
+// ValidCloseCode reports whether a WebSocket close code may be sent in a
+// close frame: 1000-1014 except the reserved 1004-1006, or 3000-4999
+func ValidCloseCode(code int) bool {
+	switch {
+	case code >= 1000 && code <= 1014:
+		return code < 1004 || code > 1006
+	default:
+		return code >= 3000 && code <= 4999
+	}
+}
+
+// CacheSettings returns the tunnel's response cache settings with defaults applied
+func (t *Tunnel) CacheSettings() CacheSettings {
+	var s CacheSettings
+	if t.Settings != nil {
+		s = t.Settings.Cache
+	}
+
+	if s.MaxSizeMB <= 0 {
+		s.MaxSizeMB = 64
+	}
+	if s.MaxEntryKB <= 0 {
+		s.MaxEntryKB = 1024
+	}
+	return s
+}
+
+// CORSSettings returns the tunnel's CORS settings with defaults applied
+func (t *Tunnel) CORSSettings() CORSSettings {
+	var s CORSSettings
+	if t.Settings != nil {
+		s = t.Settings.CORS
+	}
+
+	if len(s.AllowOrigins) == 0 {
+		s.AllowOrigins = []string{"*"}
+	}
+	if len(s.AllowMethods) == 0 {
+		s.AllowMethods = []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}
+	}
+	if s.MaxAge <= 0 {
+		s.MaxAge = 600
+	}
+	return s
+}
+
+// RateLimitSettings returns the tunnel's rate limit settings with defaults applied
+func (t *Tunnel) RateLimitSettings() RateLimitSettings {
+	var s RateLimitSettings
+	if t.Settings != nil {
+		s = t.Settings.RateLimit
+	}
+
+	if s.PerClientRPS < 0 {
+		s.PerClientRPS = 0
+	}
+	if s.PerClientRPS == 0 && s.GlobalRPS <= 0 {
+		s.PerClientRPS = 10
+	}
+	if s.PerClientBurst <= 0 {
+		s.PerClientBurst = int(math.Ceil(s.PerClientRPS * 2))
+	}
+	if s.GlobalRPS < 0 {
+		s.GlobalRPS = 0
+	}
+	if s.GlobalBurst <= 0 {
+		s.GlobalBurst = int(math.Ceil(s.GlobalRPS * 2))
+	}
+	return s
+}
+
+// BasicAuthSettings returns the tunnel's basic auth settings with defaults applied
+func (t *Tunnel) BasicAuthSettings() BasicAuthSettings {
+	var s BasicAuthSettings
+	if t.Settings != nil {
+		s = t.Settings.BasicAuth
+	}
+
+	if s.Realm == "" {
+		s.Realm = "SkyPort"
+	}
+	return s
+}
+
+// AccessLogSettings returns the tunnel's access log settings with defaults applied
+func (t *Tunnel) AccessLogSettings() AccessLogSettings {
+	var s AccessLogSettings
+	if t.Settings != nil {
+		s = t.Settings.AccessLog
+	}
+
+	if s.Format == "" {
+		s.Format = "apache"
+	}
+	if s.MaxSizeMB <= 0 {
+		s.MaxSizeMB = 10
+	}
+	if s.MaxBackups <= 0 {
+		s.MaxBackups = 5
+	}
+	return s
+}
+
+// AccessLogPath returns the file a tunnel's access log is written to
+func AccessLogPath(tunnel *Tunnel) (string, error) {
+	return tunnelLogPath(tunnel, "access", ".log")
+}
+
+// tunnelLogPath returns ~/.skyport/logs/<kind>/<tunnel><ext>
+func tunnelLogPath(tunnel *Tunnel, kind, ext string) (string, error) {
+	configDir, err := GetConfigDir()
+	if err != nil {
+		return "", err
+	}
+
+	name := tunnel.Name
+	if name == "" || strings.ContainsAny(name, `/\:`) {
+		name = tunnel.ID
+	}
+	return filepath.Join(configDir, "logs", kind, name+ext), nil
+}
+
+// DefaultSettings returns the settings used when skyport.json does not override them
+func DefaultSettings() *Settings {
+	return &Settings{
+		Intervals: Intervals{
+			PingInterval:        Duration{15 * time.Second},
+			ReadTimeout:         Duration{60 * time.Second},
+			HealthCheckInterval: Duration{30 * time.Second},
+			MaintenanceInterval: Duration{60 * time.Second},
+			TunnelListTTL:       Duration{30 * time.Second},
+		},
+		Probes: ProbeSettings{
+			Enabled: false,
+			Scheme:  "http",
+			Path:    "/",
+			Timeout: Duration{10 * time.Second},
+		},
+		Notifications: NotificationSettings{
+			RepeatInterval: Duration{15 * time.Minute},
+		},
+		Telemetry: TelemetrySettings{
+			Endpoint:       "http://localhost:4318",
+			ServiceName:    "skyport-agent",
+			ExportInterval: Duration{10 * time.Second},
+		},
+		Discovery: DiscoverySettings{
+			Interval: Duration{10 * time.Second},
+		},
+		Service: ServiceSettings{
+			Restart: "always",
+		},
+		Heartbeat: HeartbeatSettings{
+			Interval: Duration{time.Minute},
+		},
+	}
+}
+
+// applyDefaults fills unset fields with their default values
+func (s *Settings) applyDefaults() {
+	defaults := DefaultSettings()
+
+	if s.Intervals.PingInterval.Duration == 0 {
+		s.Intervals.PingInterval = defaults.Intervals.PingInterval
+	}
+	if s.Intervals.ReadTimeout.Duration == 0 {
+		s.Intervals.ReadTimeout = defaults.Intervals.ReadTimeout
+	}
+	if s.Intervals.HealthCheckInterval.Duration == 0 {
+		s.Intervals.HealthCheckInterval = defaults.Intervals.HealthCheckInterval
+	}
+	if s.Intervals.MaintenanceInterval.Duration == 0 {
+		s.Intervals.MaintenanceInterval = defaults.Intervals.MaintenanceInterval
+	}
+	if s.Intervals.TunnelListTTL.Duration == 0 {
+		s.Intervals.TunnelListTTL = defaults.Intervals.TunnelListTTL
+	}
+	if s.Probes.Scheme == "" {
+		s.Probes.Scheme = defaults.Probes.Scheme
+	}
+	if s.Probes.Path == "" {
+		s.Probes.Path = defaults.Probes.Path
+	}
+	if s.Probes.Timeout.Duration == 0 {
+		s.Probes.Timeout = defaults.Probes.Timeout
+	}
+	if s.Notifications.RepeatInterval.Duration == 0 {
+		s.Notifications.RepeatInterval = defaults.Notifications.RepeatInterval
+	}
+	if s.Telemetry.Endpoint == "" {
+		s.Telemetry.Endpoint = defaults.Telemetry.Endpoint
+	}
+	if s.Telemetry.ServiceName == "" {
+		s.Telemetry.ServiceName = defaults.Telemetry.ServiceName
+	}
+	if s.Telemetry.ExportInterval.Duration == 0 {
+		s.Telemetry.ExportInterval = defaults.Telemetry.ExportInterval
+	}
+	if s.Discovery.Interval.Duration == 0 {
+		s.Discovery.Interval = defaults.Discovery.Interval
+	}
+	if s.Service.Restart == "" {
+		s.Service.Restart = defaults.Service.Restart
+	}
+	if s.Heartbeat.Interval.Duration == 0 {
+		s.Heartbeat.Interval = defaults.Heartbeat.Interval
+	}
+	for i := range s.Notifications.Notifiers {
+		n := &s.Notifications.Notifiers[i]
+		if n.Name == "" {
+			n.Name = fmt.Sprintf("%s-%d", n.Type, i+1)
+		}
+		if n.Type == "email" && n.SMTPPort == 0 {
+			n.SMTPPort = 587
+		}
+	}
+}
+
+// Validate checks that the settings are within sane bounds
+func (s *Settings) Validate() error {
+	iv := s.Intervals
+
+	if iv.PingInterval.Duration < time.Second || iv.PingInterval.Duration > 10*time.Minute {
+		return fmt.Errorf("intervals.ping_interval must be between 1s and 10m (got %v)", iv.PingInterval)
+	}
+	// The read deadline is only extended by pongs, so it must cover at least two pings
+	if iv.ReadTimeout.Duration < 2*iv.PingInterval.Duration {
+		return fmt.Errorf("intervals.read_timeout must be at least twice ping_interval (got %v, ping_interval %v)",
+			iv.ReadTimeout, iv.PingInterval)
+	}
+	if iv.HealthCheckInterval.Duration < 5*time.Second {
+		return fmt.Errorf("intervals.health_check_interval must be at least 5s (got %v)", iv.HealthCheckInterval)
+	}
+	if iv.MaintenanceInterval.Duration < 10*time.Second {
+		return fmt.Errorf("intervals.maintenance_interval must be at least 10s (got %v)", iv.MaintenanceInterval)
+	}
+	if iv.TunnelListTTL.Duration < time.Second || iv.TunnelListTTL.Duration > time.Hour {
+		return fmt.Errorf("intervals.tunnel_list_ttl must be between 1s and 1h (got %v)", iv.TunnelListTTL)
+	}
+
+	if s.Probes.Scheme != "http" && s.Probes.Scheme != "https" {
+		return fmt.Errorf("probes.scheme must be \"http\" or \"https\" (got %q)", s.Probes.Scheme)
+	}
+	if !strings.HasPrefix(s.Probes.Path, "/") {
+		return fmt.Errorf("probes.path must start with / (got %q)", s.Probes.Path)
+	}
+	if s.Probes.Timeout.Duration < time.Second {
+		return fmt.Errorf("probes.timeout must be at least 1s (got %v)", s.Probes.Timeout)
+	}
+
+	for _, n := range s.Notifications.Notifiers {
+		switch n.Type {
+		case "webhook", "slack", "discord":
+			if n.URL == "" {
+				return fmt.Errorf("notifier %q: url is required for type %s", n.Name, n.Type)
+			}
+		case "email":
+			if n.SMTPHost == "" || n.From == "" || len(n.To) == 0 {
+				return fmt.Errorf("notifier %q: smtp_host, from and to are required for type email", n.Name)
+			}
+		default:
+			return fmt.Errorf("notifier %q: unknown type %q (use webhook, slack, discord or email)", n.Name, n.Type)
+		}
+	}
+
+	if s.Telemetry.Enabled {
+		endpoint, err := url.Parse(s.Telemetry.Endpoint)
+		if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+			return fmt.Errorf("telemetry.endpoint must be an http(s) URL (got %q)", s.Telemetry.Endpoint)
+		}
+		if s.Telemetry.ExportInterval.Duration < time.Second {
+			return fmt.Errorf("telemetry.export_interval must be at least 1s (got %v)", s.Telemetry.ExportInterval)
+		}
+	}
+
+	for _, target := range s.Connectivity.Targets {
+		if _, _, err := net.SplitHostPort(target); err != nil {
+			return fmt.Errorf("connectivity.targets entry %q must be host:port: %w", target, err)
+		}
+	}
+
+	if s.Discovery.Docker && s.Discovery.Interval.Duration < 2*time.Second {
+		return fmt.Errorf("discovery.interval must be at least 2s (got %v)", s.Discovery.Interval)
+	}
+
+	if err := s.TLS.validate(); err != nil {
+		return err
+	}
+	if err := s.Resources.validate(); err != nil {
+		return err
+	}
+	if err := s.Service.validate(); err != nil {
+		return err
+	}
+	if storage := s.Credentials.Storage; storage != "" && storage != "keyring" && storage != "file" {
+		return fmt.Errorf("credentials.storage must be \"keyring\" or \"file\" (got %q)", storage)
+	}
+	if !s.Heartbeat.Disabled && s.Heartbeat.Interval.Duration < 10*time.Second {
+		return fmt.Errorf("heartbeat.interval must be at least 10s (got %v)", s.Heartbeat.Interval)
+	}
+	for _, command := range s.RemoteCommands.Allow {
+		switch command {
+		case "all", RemoteStartTunnel, RemoteStopTunnel, RemoteRefreshConfig, RemoteCollectDiagnostics:
+		default:
+			return fmt.Errorf("remote_commands.allow: unknown command %q (use %s, %s, %s, %s or all)", command,
+				RemoteStartTunnel, RemoteStopTunnel, RemoteRefreshConfig, RemoteCollectDiagnostics)
+		}
+	}
+
+	return nil
+}
+
+// ConnectivityTargets returns the host:port endpoints used for connectivity
+// checks, defaulting to the host of the SkyPort server
+func (c *Config) ConnectivityTargets() []string {
+	if targets := c.GetSettings().Connectivity.Targets; len(targets) > 0 {
+		return targets
+	}
+
+	serverURL, err := url.Parse(c.ServerURL)
+	if err != nil || serverURL.Hostname() == "" {
+		return nil
+	}
+
+	port := serverURL.Port()
+	if port == "" {
+		port = "80"
+		if serverURL.Scheme == "https" {
+			port = "443"
+		}
+	}
+
+	return []string{net.JoinHostPort(serverURL.Hostname(), port)}
+}
+
+// LoadSettings returns the settings from skyport.json with defaults applied
+func (cm *ConfigManager) LoadSettings() (*Settings, error) {
+	appConfig, err := cm.LoadConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	settings := appConfig.Settings
+	if settings == nil {
+		settings = &Settings{}
+	}
+	settings.applyDefaults()
+
+	if err := settings.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid settings in %s: %w", cm.configFile, err)
+	}
+
+	return settings, nil
+}
+
+// LoadTLSSettings returns the TLS settings from skyport.json on their own,
+// so the TLS policy can be kept when other settings are invalid. A config
+// that can't be read has no policy and returns the zero settings.
+func (cm *ConfigManager) LoadTLSSettings() (TLSSettings, error) {
+	appConfig, err := cm.LoadConfig()
+	if err != nil || appConfig.Settings == nil {
+		return TLSSettings{}, nil
+	}
+
+	if err := appConfig.Settings.TLS.validate(); err != nil {
+		return TLSSettings{}, fmt.Errorf("invalid settings in %s: %w", cm.configFile, err)
+	}
+	return appConfig.Settings.TLS, nil
+}
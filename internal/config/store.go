@@ -0,0 +1,362 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// store is the in-memory copy of a config file, shared by every
+// ConfigManager in the process. It is dropped when the file changes on disk
+// (another skyport process, or the user editing it), so reads only touch
+// the disk after a change; batched updates not yet written are re-applied
+// to the new contents. Updates replace the copy rather than modifying
+// it, so snapshots handed out are never written to.
+type store struct {
+	path string
+
+	mu     sync.Mutex
+	config *AppConfig // nil until loaded, and after the file changed
+	// written is the state of the file after our last write, so the
+	// watcher can tell our own writes from other processes'
+	written os.FileInfo
+	dirty   bool
+	flush   *time.Timer
+	// pending are the updates made since the last write, re-applied to the
+	// file's new contents if another process changes it before they are
+	// written
+	pending []func(*AppConfig) error
+
+	watcher *fsnotify.Watcher
+	// unwatched is set when no watcher could be started; the file's
+	// modification time and size are then checked on every read instead
+	unwatched bool
+	statAt    os.FileInfo
+}
+
+var (
+	storesMu sync.Mutex
+	stores   = make(map[string]*store)
+
+	// writeDelay batches writes in long-running processes; zero writes
+	// every update immediately
+	writeDelay time.Duration
+)
+
+func storeFor(path string) *store {
+	storesMu.Lock()
+	defer storesMu.Unlock()
+
+	s, ok := stores[path]
+	if !ok {
+		s = &store{path: path}
+		stores[path] = s
+	}
+	return s
+}
+
+// SetWriteDelay makes config updates wait up to delay before being written,
+// so bursts of updates (such as several tunnels connecting) are written once.
+// Processes that enable it must call Flush before exiting.
+func SetWriteDelay(delay time.Duration) {
+	storesMu.Lock()
+	defer storesMu.Unlock()
+	writeDelay = delay
+}
+
+// Flush writes any batched config updates to disk
+func Flush() error {
+	storesMu.Lock()
+	all := make([]*store, 0, len(stores))
+	for _, s := range stores {
+		all = append(all, s)
+	}
+	storesMu.Unlock()
+
+	var firstErr error
+	for _, s := range all {
+		s.mu.Lock()
+		err := s.writeLocked()
+		s.mu.Unlock()
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// snapshot returns the current config, which must not be modified
+func (s *store) snapshot() (*AppConfig, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.loadLocked()
+}
+
+// update applies fn to a copy of the config and makes the copy current. If
+// fn fails, nothing changes.
+func (s *store) update(fn func(*AppConfig) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, err := s.loadLocked()
+	if err != nil {
+		return err
+	}
+
+	next, err := current.clone()
+	if err != nil {
+		return err
+	}
+	if err := fn(next); err != nil {
+		return err
+	}
+	return s.replaceLocked(next, fn)
+}
+
+// replace makes a copy of config current
+func (s *store) replace(config *AppConfig) error {
+	copied, err := config.clone()
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.replaceLocked(copied, func(config *AppConfig) error {
+		replacement, err := copied.clone()
+		if err != nil {
+			return err
+		}
+		*config = *replacement
+		return nil
+	})
+}
+
+// replaceLocked makes config current. fn is the update that produced it,
+// kept until it is written.
+func (s *store) replaceLocked(config *AppConfig, fn func(*AppConfig) error) error {
+	config.LastSync = time.Now()
+	s.config = config
+	s.dirty = true
+	s.pending = append(s.pending, fn)
+
+	storesMu.Lock()
+	delay := writeDelay
+	storesMu.Unlock()
+
+	if delay == 0 {
+		return s.writeLocked()
+	}
+	if s.flush == nil {
+		s.flush = time.AfterFunc(delay, func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			if err := s.writeLocked(); err != nil {
+				log.Printf("Warning: failed to save config: %v", err)
+			}
+		})
+	}
+	return nil
+}
+
+func (s *store) loadLocked() (*AppConfig, error) {
+	if s.config == nil && s.watcher == nil && !s.unwatched {
+		s.watch()
+	}
+
+	if s.config != nil && s.unwatched {
+		if info, err := os.Stat(s.path); err != nil || !sameFile(info, s.statAt) {
+			s.reloadLocked()
+		}
+	}
+	if s.config != nil {
+		return s.config, nil
+	}
+
+	info, err := os.Stat(s.path)
+	if os.IsNotExist(err) {
+		// Empty config if the file doesn't exist yet
+		s.config = &AppConfig{
+			Tunnels:  make(map[string]*Tunnel),
+			LastSync: time.Now(),
+		}
+		s.statAt = nil
+		return s.config, nil
+	}
+
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+
+	var config AppConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse config: %w", err)
+	}
+	if config.Tunnels == nil {
+		config.Tunnels = make(map[string]*Tunnel)
+	}
+
+	s.config = &config
+	s.statAt = info
+	return s.config, nil
+}
+
+// writeLocked writes pending changes, replacing the file atomically so other
+// processes never read it half written
+func (s *store) writeLocked() error {
+	if s.flush != nil {
+		s.flush.Stop()
+		s.flush = nil
+	}
+	if !s.dirty {
+		return nil
+	}
+	if s.unwatched {
+		if info, err := os.Stat(s.path); err != nil || !sameFile(info, s.statAt) {
+			s.reloadLocked()
+		}
+	}
+
+	data, err := json.MarshalIndent(s.config, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal config: %w", err)
+	}
+
+	// Only the user may read it: it holds the login and admin tokens
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	s.dirty = false
+	s.pending = nil
+	if info, err := os.Stat(s.path); err == nil {
+		s.written = info
+		s.statAt = info
+	}
+	return nil
+}
+
+// watch starts watching the config file's directory (the file itself is
+// replaced on every write, which would end a watch on it)
+func (s *store) watch() {
+	watcher, err := fsnotify.NewWatcher()
+	if err == nil {
+		err = watcher.Add(filepath.Dir(s.path))
+		if err != nil {
+			watcher.Close()
+		}
+	}
+	if err != nil {
+		s.unwatched = true
+		return
+	}
+
+	s.watcher = watcher
+	go s.watchLoop(watcher)
+}
+
+func (s *store) watchLoop(watcher *fsnotify.Watcher) {
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			if filepath.Clean(event.Name) != s.path {
+				continue
+			}
+			s.changed()
+		case _, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			// Events may have been lost; fall back to checking the file
+			s.mu.Lock()
+			s.config = nil
+			s.unwatched = true
+			s.mu.Unlock()
+			watcher.Close()
+			return
+		}
+	}
+}
+
+// changed picks up the file's new contents after it changed, unless the
+// change is our own write
+func (s *store) changed() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if info, err := os.Stat(s.path); err == nil && sameFile(info, s.written) {
+		return
+	}
+	s.reloadLocked()
+}
+
+// reloadLocked drops the in-memory copy so the file is read again. Updates
+// not yet written are re-applied to the file's contents instead, so they are
+// not lost and don't undo the other process's changes; an update that no
+// longer applies (say, to a tunnel removed since) is dropped.
+func (s *store) reloadLocked() {
+	if !s.dirty {
+		s.config = nil
+		return
+	}
+
+	current, pending := s.config, s.pending
+	s.config = nil
+	merged, err := s.loadLocked()
+	if err != nil {
+		log.Printf("Warning: config changed on disk but could not be read, keeping this process's changes: %v", err)
+		s.config = current
+		return
+	}
+
+	var applied []func(*AppConfig) error
+	for _, fn := range pending {
+		next, err := merged.clone()
+		if err == nil {
+			err = fn(next)
+		}
+		if err != nil {
+			log.Printf("Warning: dropping a config update that conflicts with a change made on disk: %v", err)
+			continue
+		}
+		merged = next
+		applied = append(applied, fn)
+	}
+	merged.LastSync = time.Now()
+	s.config, s.pending = merged, applied
+}
+
+func sameFile(a, b os.FileInfo) bool {
+	return a != nil && b != nil && a.ModTime().Equal(b.ModTime()) && a.Size() == b.Size() && os.SameFile(a, b)
+}
+
+// clone returns a deep copy of the config
+func (c *AppConfig) clone() (*AppConfig, error) {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return nil, fmt.Errorf("failed to copy config: %w", err)
+	}
+	var copied AppConfig
+	if err := json.Unmarshal(data, &copied); err != nil {
+		return nil, fmt.Errorf("failed to copy config: %w", err)
+	}
+	if copied.Tunnels == nil {
+		copied.Tunnels = make(map[string]*Tunnel)
+	}
+	return &copied, nil
+}
@@ -0,0 +1,147 @@
+package config
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeExternally replaces a config file as another skyport process would
+func writeExternally(t *testing.T, path string, config *AppConfig) {
+	t.Helper()
+	data, err := json.Marshal(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path+".other", data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(path+".other", path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readFile(t *testing.T, path string) *AppConfig {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var config AppConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		t.Fatal(err)
+	}
+	return &config
+}
+
+func testStoreKeepsExternalWritesDuringBatch(t *testing.T, unwatched bool) {
+	SetWriteDelay(time.Hour)
+	defer SetWriteDelay(0)
+
+	path := filepath.Join(t.TempDir(), "skyport.json")
+	writeExternally(t, path, &AppConfig{Tunnels: map[string]*Tunnel{
+		"t1": {ID: "t1", Name: "daemon"},
+		"t2": {ID: "t2", Name: "cli"},
+	}})
+
+	s := &store{path: path, unwatched: unwatched}
+	defer func() {
+		if s.watcher != nil {
+			s.watcher.Close()
+		}
+	}()
+	if _, err := s.snapshot(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The daemon batches an update...
+	err := s.update(func(config *AppConfig) error {
+		config.Tunnels["t1"].IsActive = true
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// ...while the CLI changes another tunnel and adds one
+	time.Sleep(10 * time.Millisecond)
+	external := readFile(t, path)
+	external.Tunnels["t2"].AutoStart = true
+	external.Tunnels["t3"] = &Tunnel{ID: "t3", Name: "new"}
+	writeExternally(t, path, external)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		snapshot, err := s.snapshot()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if snapshot.Tunnels["t3"] != nil || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	s.mu.Lock()
+	err = s.writeLocked()
+	s.mu.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	written := readFile(t, path)
+	if !written.Tunnels["t1"].IsActive {
+		t.Error("the batched update was lost")
+	}
+	if written.Tunnels["t2"] == nil || !written.Tunnels["t2"].AutoStart {
+		t.Error("the external change to t2 was overwritten")
+	}
+	if written.Tunnels["t3"] == nil {
+		t.Error("the tunnel added externally was overwritten")
+	}
+}
+
+func TestStoreKeepsExternalWritesDuringBatch(t *testing.T) {
+	testStoreKeepsExternalWritesDuringBatch(t, false)
+}
+
+func TestStoreKeepsExternalWritesDuringBatchUnwatched(t *testing.T) {
+	testStoreKeepsExternalWritesDuringBatch(t, true)
+}
+
+func TestStoreDropsUpdatesThatNoLongerApply(t *testing.T) {
+	SetWriteDelay(time.Hour)
+	defer SetWriteDelay(0)
+
+	path := filepath.Join(t.TempDir(), "skyport.json")
+	writeExternally(t, path, &AppConfig{Tunnels: map[string]*Tunnel{"t1": {ID: "t1"}}})
+	s := &store{path: path, unwatched: true}
+
+	err := s.update(func(config *AppConfig) error {
+		tunnel, ok := config.Tunnels["t1"]
+		if !ok {
+			return os.ErrNotExist
+		}
+		tunnel.IsActive = true
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	writeExternally(t, path, &AppConfig{Tunnels: map[string]*Tunnel{"t2": {ID: "t2"}}})
+
+	s.mu.Lock()
+	err = s.writeLocked()
+	s.mu.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	written := readFile(t, path)
+	if written.Tunnels["t1"] != nil || written.Tunnels["t2"] == nil {
+		t.Errorf("tunnels after merge: %v", written.Tunnels)
+	}
+}
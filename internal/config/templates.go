@@ -0,0 +1,138 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// TunnelTemplate holds the options new tunnels can be created with, so
+// similar tunnels do not have to be set up one option at a time
+type TunnelTemplate struct {
+	LocalPort int             `json:"local_port,omitempty"`
+	AutoStart bool            `json:"auto_start,omitempty"`
+	Settings  *TunnelSettings `json:"settings,omitempty"`
+}
+
+// TemplateFrom makes a template of a tunnel's port, auto-start and local
+// settings. Dependencies name specific tunnels, so they are left out.
+func TemplateFrom(t *Tunnel) (*TunnelTemplate, error) {
+	settings, err := copySettings(t.Settings)
+	if err != nil {
+		return nil, err
+	}
+	// Dependencies and expiry belong to the tunnel, not to ones like it
+	if settings != nil {
+		settings.DependsOn = nil
+		settings.Expiry = nil
+	}
+	return &TunnelTemplate{LocalPort: t.LocalPort, AutoStart: t.AutoStart, Settings: settings}, nil
+}
+
+// Apply sets a tunnel's auto-start and local settings from the template,
+// replacing the settings it had. The local port is the server's to set.
+func (tt *TunnelTemplate) Apply(t *Tunnel) error {
+	settings, err := copySettings(tt.Settings)
+	if err != nil {
+		return err
+	}
+	if t.Settings != nil && settings != nil {
+		settings.DependsOn = t.Settings.DependsOn
+		settings.Expiry = t.Settings.Expiry
+	}
+	t.Settings = settings
+	t.AutoStart = tt.AutoStart
+	return nil
+}
+
+// copySettings returns a deep copy of settings
+func copySettings(settings *TunnelSettings) (*TunnelSettings, error) {
+	if settings == nil {
+		return nil, nil
+	}
+
+	data, err := json.Marshal(settings)
+	if err != nil {
+		return nil, err
+	}
+	var copied TunnelSettings
+	if err := json.Unmarshal(data, &copied); err != nil {
+		return nil, err
+	}
+	return &copied, nil
+}
+
+// Template returns a tunnel template by name
+func (cm *ConfigManager) Template(name string) (*TunnelTemplate, error) {
+	config, err := cm.LoadConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	template, exists := config.Templates[name]
+	if !exists {
+		return nil, fmt.Errorf("tunnel template %s not found", name)
+	}
+	return template, nil
+}
+
+// TemplateNames returns the names of the tunnel templates, sorted
+func (cm *ConfigManager) TemplateNames() ([]string, error) {
+	config, err := cm.LoadConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(config.Templates))
+	for name := range config.Templates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// SetTemplate saves a tunnel template, or deletes it when template is nil
+func (cm *ConfigManager) SetTemplate(name string, template *TunnelTemplate) error {
+	if !localName.MatchString(name) {
+		return fmt.Errorf("invalid template name %q: use up to 64 letters, digits, dots, dashes and underscores", name)
+	}
+
+	return cm.update(func(config *AppConfig) error {
+		if template == nil {
+			delete(config.Templates, name)
+			return nil
+		}
+
+		if config.Templates == nil {
+			config.Templates = make(map[string]*TunnelTemplate)
+		}
+		config.Templates[name] = template
+		return nil
+	})
+}
+
+// ApplyTemplate sets a local tunnel's auto-start and settings from a template
+func (cm *ConfigManager) ApplyTemplate(tunnelID, name string) error {
+	return cm.update(func(config *AppConfig) error {
+		template, exists := config.Templates[name]
+		if !exists {
+			return fmt.Errorf("tunnel template %s not found", name)
+		}
+		tunnel, exists := config.Tunnels[tunnelID]
+		if !exists {
+			return fmt.Errorf("tunnel %s not found", tunnelID)
+		}
+		return template.Apply(tunnel)
+	})
+}
+
+// AddTunnel stores a tunnel created on the server in the local config
+func (cm *ConfigManager) AddTunnel(tunnel *Tunnel) error {
+	return cm.update(func(config *AppConfig) error {
+		if config.Tunnels == nil {
+			config.Tunnels = make(map[string]*Tunnel)
+		}
+		config.Tunnels[tunnel.ID] = tunnel
+		return nil
+	})
+}
@@ -0,0 +1,152 @@
+package config
+
+import (
+	"crypto/fips140"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+)
+
+// insecureWarning makes sure disabled verification is logged once per process
+var insecureWarning sync.Once
+
+// fipsCipherSuites are the FIPS 140-approved TLS 1.2 suites: ECDHE key
+// exchange with AES-GCM
+var fipsCipherSuites = []uint16{
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+}
+
+// TLSError returns why the TLS settings in skyport.json are invalid, or nil.
+// Connections to the server are refused while it is set, rather than made
+// with a TLS policy other than the one the user asked for.
+func (c *Config) TLSError() error {
+	return c.tlsErr
+}
+
+// TLSConfig returns the TLS configuration for connections to the SkyPort
+// server, or nil when the defaults (system roots, full verification) apply
+func (c *Config) TLSConfig() *tls.Config {
+	settings := c.GetSettings().TLS
+	if settings.CAFile == "" && !settings.InsecureSkipVerify && settings.MinVersion == "" && len(settings.CipherSuites) == 0 && !settings.FIPS {
+		return nil
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if settings.MinVersion == "1.3" {
+		tlsConfig.MinVersion = tls.VersionTLS13
+	}
+	if len(settings.CipherSuites) > 0 {
+		// Validated when settings are loaded
+		tlsConfig.CipherSuites, _ = cipherSuiteIDs(settings.CipherSuites)
+	}
+	if settings.FIPS {
+		tlsConfig.CipherSuites = fipsCipherSuites
+		tlsConfig.CurvePreferences = []tls.CurveID{tls.CurveP256, tls.CurveP384}
+		if !fips140.Enabled() {
+			// TLS 1.3 suites cannot be restricted outside of the Go
+			// cryptographic module's FIPS 140-3 mode (GODEBUG=fips140=on)
+			tlsConfig.MaxVersion = tls.VersionTLS12
+		}
+	}
+	if settings.InsecureSkipVerify {
+		insecureWarning.Do(func() {
+			log.Printf("Warning: TLS certificate verification is disabled (settings.tls.insecure_skip_verify); connections to the server can be intercepted")
+		})
+		tlsConfig.InsecureSkipVerify = true
+	}
+	if settings.CAFile != "" {
+		pool, err := loadCAFile(settings.CAFile)
+		if err != nil {
+			// Validated when settings are loaded, so this only happens if the
+			// file changed since; the system roots are still used
+			log.Printf("Warning: tls.ca_file: %v", err)
+		} else {
+			tlsConfig.RootCAs = pool
+		}
+	}
+	return tlsConfig
+}
+
+// validate checks the CA bundle and the version and cipher suite policy
+func (t TLSSettings) validate() error {
+	if t.CAFile != "" {
+		if _, err := loadCAFile(t.CAFile); err != nil {
+			return fmt.Errorf("tls.ca_file: %w", err)
+		}
+	}
+
+	switch t.MinVersion {
+	case "", "1.2", "1.3":
+	default:
+		return fmt.Errorf("tls.min_version must be 1.2 or 1.3 (got %q)", t.MinVersion)
+	}
+
+	if len(t.CipherSuites) > 0 {
+		if t.MinVersion == "1.3" {
+			return fmt.Errorf("tls.cipher_suites only applies to TLS 1.2, but tls.min_version is 1.3")
+		}
+		if t.FIPS {
+			return fmt.Errorf("tls.cipher_suites cannot be combined with tls.fips, which chooses the suites")
+		}
+		if _, err := cipherSuiteIDs(t.CipherSuites); err != nil {
+			return fmt.Errorf("tls.cipher_suites: %w", err)
+		}
+	}
+
+	if t.FIPS && t.MinVersion == "1.3" && !fips140.Enabled() {
+		return fmt.Errorf("tls.fips with tls.min_version 1.3 requires running with GODEBUG=fips140=on")
+	}
+	return nil
+}
+
+// cipherSuiteIDs resolves TLS 1.2 cipher suite names. Suites Go considers
+// insecure and TLS 1.3 suites, which cannot be configured, are rejected.
+func cipherSuiteIDs(names []string) ([]uint16, error) {
+	known := make(map[string]*tls.CipherSuite)
+	for _, suite := range tls.CipherSuites() {
+		known[suite.Name] = suite
+	}
+
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		suite, ok := known[strings.ToUpper(strings.TrimSpace(name))]
+		if !ok {
+			return nil, fmt.Errorf("unknown or insecure cipher suite %q", name)
+		}
+		tls12 := false
+		for _, version := range suite.SupportedVersions {
+			if version == tls.VersionTLS12 {
+				tls12 = true
+			}
+		}
+		if !tls12 {
+			return nil, fmt.Errorf("cipher suite %q is TLS 1.3 only, and TLS 1.3 suites cannot be restricted", name)
+		}
+		ids = append(ids, suite.ID)
+	}
+	return ids, nil
+}
+
+// loadCAFile returns the system roots plus the certificates in a PEM bundle
+func loadCAFile(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA bundle: %w", err)
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no PEM certificates found in %s", path)
+	}
+	return pool, nil
+}
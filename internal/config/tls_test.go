@@ -0,0 +1,75 @@
+package config
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// writeSettings writes a skyport.json with the given settings block into a
+// fresh home directory
+func writeSettings(t *testing.T, settings string) {
+	t.Helper()
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	dir := ConfigDirFor(home, instance)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "skyport.json"), []byte(`{"settings": `+settings+`}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInvalidTLSPolicyNeverConnects(t *testing.T) {
+	for name, settings := range map[string]string{
+		"min version":  `{"tls": {"min_version": "1.1"}}`,
+		"cipher suite": `{"tls": {"cipher_suites": ["TLS_NOT_A_SUITE"]}}`,
+		"fips":         `{"tls": {"fips": true, "cipher_suites": ["TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"]}}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			var connections atomic.Int64
+			server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			server.Config.ConnState = func(c net.Conn, state http.ConnState) {
+				if state == http.StateNew {
+					connections.Add(1)
+				}
+			}
+			server.StartTLS()
+			defer server.Close()
+
+			writeSettings(t, settings)
+			cfg := Load()
+			if cfg.TLSError() == nil {
+				t.Fatal("invalid TLS settings were accepted")
+			}
+
+			client := cfg.HTTPClient(5 * time.Second)
+			if _, err := client.Get(server.URL); err == nil {
+				t.Error("request succeeded with an invalid TLS policy")
+			}
+			if n := connections.Load(); n != 0 {
+				t.Errorf("server got %d connections with an invalid TLS policy", n)
+			}
+		})
+	}
+}
+
+func TestValidTLSPolicyKeptWhenOtherSettingsInvalid(t *testing.T) {
+	writeSettings(t, `{"tls": {"fips": true}, "heartbeat": {"interval": "1s"}}`)
+	cfg := Load()
+	if err := cfg.TLSError(); err != nil {
+		t.Fatalf("valid TLS settings rejected: %v", err)
+	}
+	if !cfg.GetSettings().TLS.FIPS {
+		t.Error("FIPS setting dropped when falling back to default settings")
+	}
+	if tlsConfig := cfg.TLSConfig(); tlsConfig == nil || len(tlsConfig.CipherSuites) != len(fipsCipherSuites) {
+		t.Error("FIPS cipher suites not applied")
+	}
+}
@@ -0,0 +1,308 @@
+// Package control exposes a local HTTP API over a Unix domain socket from
+// every process that hosts tunnels (the daemon and foreground `tunnel run`),
+// so CLI commands can query and change live state. Sockets live in the runtime state
+// directory, which is only accessible to the current user, and every request
+// must carry the admin token from the config as a bearer token, so other
+// local users and processes cannot use the API where file permissions do not
+// protect the socket.
+package control
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"skyport-agent/internal/config"
+	"skyport-agent/internal/crash"
+	"skyport-agent/internal/logger"
+	"skyport-agent/internal/state"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// socketSuffix identifies control sockets in the state directory
+const socketSuffix = ".sock"
+
+// Status is served at /v1/status, so installers can check that an agent
+// came up and is logged in
+type Status struct {
+	PID           int  `json:"pid"`
+	Authenticated bool `json:"authenticated"`
+	Tunnels       int  `json:"tunnels"` // Tunnels currently connected
+}
+
+// SwitchRequest is posted to /v1/switch to point a running tunnel at another
+// local address, waiting up to DrainTimeout for requests in flight to the
+// previous one
+type SwitchRequest struct {
+	TunnelID     string          `json:"tunnel_id"`
+	Target       string          `json:"target"`
+	DrainTimeout config.Duration `json:"drain_timeout"`
+}
+
+// SocketPath returns the control socket of the skyport process with the
+// given PID in a runtime state directory
+func SocketPath(stateDir string, pid int) string {
+	return filepath.Join(stateDir, "control-"+strconv.Itoa(pid)+socketSuffix)
+}
+
+// Server serves the control API for the current process
+type Server struct {
+	path     string
+	mux      *http.ServeMux
+	listener net.Listener
+	server   *http.Server
+}
+
+// NewServer creates a control server; register handlers before calling Start
+func NewServer() *Server {
+	return &Server{mux: http.NewServeMux()}
+}
+
+// HandleJSON registers a GET endpoint that responds with the JSON encoding of fn's result
+func (s *Server) HandleJSON(path string, fn func() interface{}) {
+	s.mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(fn())
+	})
+}
+
+// ErrNotFound is returned by an action handler when the process does not have
+// what the request refers to, such as a tunnel it is not running. The API
+// answers 404, and Post returns it.
+var ErrNotFound = errors.New("not found")
+
+// HandleAction registers a POST endpoint that passes the JSON request body to
+// fn and responds with the JSON encoding of fn's result. Errors are sent as
+// text, with 404 for ErrNotFound and 400 otherwise.
+func (s *Server) HandleAction(path string, fn func(request json.RawMessage) (interface{}, error)) {
+	s.mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var request json.RawMessage
+		if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&request); err != nil {
+			http.Error(w, "invalid request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		result, err := fn(request)
+		if errors.Is(err, ErrNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	})
+}
+
+// Start listens on this process's control socket and serves in the background
+func (s *Server) Start() error {
+	dir, err := state.Dir()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+	os.Chmod(dir, 0700) // In case it was created with looser permissions
+
+	token, err := config.NewConfigManager().AdminToken()
+	if err != nil {
+		return err
+	}
+
+	s.path = SocketPath(dir, os.Getpid())
+	os.Remove(s.path) // Left over from a previous process with the same PID
+
+	listener, err := net.Listen("unix", s.path)
+	if err != nil {
+		return fmt.Errorf("failed to listen on control socket: %w", err)
+	}
+	os.Chmod(s.path, 0600)
+
+	s.listener = listener
+	s.server = &http.Server{Handler: requireToken(token, s.mux), ReadHeaderTimeout: 5 * time.Second}
+
+	go func() {
+		defer crash.Recover("control server")
+		if err := s.server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			logger.Debug("Control server stopped: %v", err)
+		}
+	}()
+
+	logger.Debug("Control socket listening at %s", s.path)
+	return nil
+}
+
+// Stop closes the control socket
+func (s *Server) Stop() {
+	if s == nil || s.server == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	s.server.Shutdown(ctx)
+	os.Remove(s.path)
+}
+
+// requireToken rejects requests that do not carry the admin token
+func requireToken(token string, next http.Handler) http.Handler {
+	expected := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Listening reports whether the skyport process with the given PID is
+// serving its control socket, which tells a live skyport process apart from
+// an unrelated one that was given a reused PID
+func Listening(pid int) bool {
+	dir, err := state.Dir()
+	if err != nil {
+		return false
+	}
+	conn, err := net.DialTimeout("unix", SocketPath(dir, pid), time.Second)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// Sockets returns the control sockets of running skyport processes. Sockets
+// that no longer accept connections are removed.
+func Sockets() []string {
+	dir, err := state.Dir()
+	if err != nil {
+		return nil
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+
+	var sockets []string
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.Name(), "control-") || !strings.HasSuffix(entry.Name(), socketSuffix) {
+			continue
+		}
+
+		path := filepath.Join(dir, entry.Name())
+		conn, err := net.DialTimeout("unix", path, time.Second)
+		if err != nil {
+			os.Remove(path) // Stale socket from a process that exited uncleanly
+			continue
+		}
+		conn.Close()
+		sockets = append(sockets, path)
+	}
+	return sockets
+}
+
+// Get fetches an endpoint from a control socket and decodes the JSON response into out
+func Get(socket, path string, out interface{}) error {
+	token, err := config.NewConfigManager().AdminToken()
+	if err != nil {
+		return err
+	}
+	return GetWithToken(socket, token, path, out)
+}
+
+// GetWithToken is Get for a process with another admin token, such as an
+// agent running as another user
+func GetWithToken(socket, token, path string, out interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, "http://skyport"+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := socketClient(socket, 5*time.Second).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("control API returned status %d", resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// Post sends in as JSON to an action endpoint of a control socket and decodes
+// the JSON response into out. Actions may take a while, such as waiting for
+// requests to drain, so the timeout is longer than Get's. A 404 is returned
+// as ErrNotFound and other failures with the error text the process sent.
+func Post(socket, path string, in, out interface{}) error {
+	token, err := config.NewConfigManager().AdminToken()
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, "http://skyport"+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := socketClient(socket, 2*time.Minute).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return json.NewDecoder(resp.Body).Decode(out)
+	case http.StatusNotFound:
+		return ErrNotFound
+	default:
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		if text := strings.TrimSpace(string(message)); text != "" {
+			return errors.New(text)
+		}
+		return fmt.Errorf("control API returned status %d", resp.StatusCode)
+	}
+}
+
+// socketClient returns an HTTP client that connects to a control socket
+func socketClient(socket string, timeout time.Duration) *http.Client {
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socket)
+			},
+		},
+	}
+}
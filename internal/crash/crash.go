@@ -0,0 +1,147 @@
+package crash
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"skyport-agent/internal/config"
+	"skyport-agent/internal/logger"
+	"strings"
+	"time"
+)
+
+// Version is the agent version recorded in crash reports (set by the cli package)
+var Version = "unknown"
+
+// ReportsDir returns the directory crash reports are written to
+func ReportsDir() (string, error) {
+	configDir, err := config.GetConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(configDir, "crash"), nil
+}
+
+// Recover is deferred at the top of long-running goroutines. On panic it
+// writes a crash report and exits with status 2 so that the service manager
+// restarts the agent in a clean state rather than leaving it half-working.
+func Recover(component string) {
+	r := recover()
+	if r == nil {
+		return
+	}
+
+	stack := debug.Stack()
+	path, err := WriteReport(component, r, stack)
+	if err != nil {
+		logger.Error("Agent crashed in %s: %v (failed to write crash report: %v)", component, r, err)
+	} else {
+		logger.Error("Agent crashed in %s: %v", component, r)
+		logger.Plain("  Crash report: %s", path)
+		logger.Plain("  Run 'skyport support bundle' to collect it for a bug report")
+	}
+
+	os.Exit(2)
+}
+
+// WriteReport writes a crash report with the panic value, stack trace, recent
+// log lines and a redacted configuration summary, returning its path
+func WriteReport(component string, panicValue interface{}, stack []byte) (string, error) {
+	dir, err := ReportsDir()
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	path := filepath.Join(dir, fmt.Sprintf("crash-%s.txt", now.Format("20060102-150405")))
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "SkyPort Agent crash report\n")
+	fmt.Fprintf(&b, "Time:      %s\n", now.Format(time.RFC3339))
+	fmt.Fprintf(&b, "Version:   %s\n", Version)
+	fmt.Fprintf(&b, "Platform:  %s/%s (%s)\n", runtime.GOOS, runtime.GOARCH, runtime.Version())
+	fmt.Fprintf(&b, "Component: %s\n", component)
+	fmt.Fprintf(&b, "Panic:     %v\n", panicValue)
+
+	b.WriteString("\n== Stack trace ==\n")
+	b.Write(stack)
+
+	b.WriteString("\n== Recent log ==\n")
+	for _, line := range logger.Recent() {
+		b.WriteString(line)
+		b.WriteString("\n")
+	}
+
+	b.WriteString("\n== Configuration (secrets redacted) ==\n")
+	b.WriteString(ConfigSummary())
+	b.WriteString("\n")
+
+	if err := os.WriteFile(path, []byte(b.String()), 0600); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// ConfigSummary returns the effective configuration as indented JSON with
+// tokens, passwords, webhook URLs and collector headers redacted
+func ConfigSummary() string {
+	cfg := config.Load()
+
+	summary := map[string]interface{}{
+		"server_url":    cfg.ServerURL,
+		"web_url":       cfg.WebURL,
+		"tunnel_domain": cfg.TunnelDomain,
+		"debug_mode":    config.IsDebugMode(),
+	}
+
+	settings := *cfg.GetSettings()
+	notifiers := make([]config.NotifierConfig, len(settings.Notifications.Notifiers))
+	for i, n := range settings.Notifications.Notifiers {
+		n.URL = redact(n.URL)
+		n.Password = redact(n.Password)
+		notifiers[i] = n
+	}
+	settings.Notifications.Notifiers = notifiers
+	headers := make(map[string]string, len(settings.Telemetry.Headers))
+	for name, value := range settings.Telemetry.Headers {
+		headers[name] = redact(value)
+	}
+	settings.Telemetry.Headers = headers
+	summary["settings"] = settings
+
+	if appConfig, err := config.NewConfigManager().LoadConfig(); err == nil {
+		tunnels := make([]map[string]interface{}, 0, len(appConfig.Tunnels))
+		for _, t := range appConfig.Tunnels {
+			tunnels = append(tunnels, map[string]interface{}{
+				"id":         t.ID,
+				"name":       t.Name,
+				"subdomain":  t.Subdomain,
+				"local_port": t.LocalPort,
+				"auto_start": t.AutoStart,
+				"is_active":  t.IsActive,
+			})
+		}
+		summary["tunnels"] = tunnels
+		summary["user_token"] = redact(appConfig.UserToken)
+	}
+
+	data, err := json.MarshalIndent(summary, "", "  ")
+	if err != nil {
+		return fmt.Sprintf("failed to build config summary: %v", err)
+	}
+	return string(data)
+}
+
+// redact hides a secret while keeping whether it was set visible
+func redact(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return "[REDACTED]"
+}
@@ -0,0 +1,195 @@
+// Package devcert issues certificates for local HTTPS from a development CA
+// kept in the agent's config directory. Browsers accept them once the CA is
+// trusted on the machine, which Trust does with the platform's tools.
+package devcert
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"skyport-agent/internal/config"
+	"strings"
+	"time"
+)
+
+const (
+	caValidity   = 10 * 365 * 24 * time.Hour
+	leafValidity = 30 * 24 * time.Hour
+)
+
+// Authority is the development CA
+type Authority struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	path string // PEM file of the CA certificate
+}
+
+// Load returns the development CA, creating it on first use
+func Load() (*Authority, error) {
+	configDir, err := config.GetConfigDir()
+	if err != nil {
+		return nil, err
+	}
+	dir := filepath.Join(configDir, "dev-ca")
+	certPath := filepath.Join(dir, "ca.pem")
+	keyPath := filepath.Join(dir, "ca-key.pem")
+
+	certPEM, certErr := os.ReadFile(certPath)
+	keyPEM, keyErr := os.ReadFile(keyPath)
+	if errors.Is(certErr, os.ErrNotExist) && errors.Is(keyErr, os.ErrNotExist) {
+		return create(dir, certPath, keyPath)
+	}
+	if certErr != nil {
+		return nil, fmt.Errorf("failed to read development CA: %w", certErr)
+	}
+	if keyErr != nil {
+		return nil, fmt.Errorf("failed to read development CA key: %w", keyErr)
+	}
+
+	pair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("invalid development CA in %s: %w", dir, err)
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid development CA in %s: %w", dir, err)
+	}
+	key, ok := pair.PrivateKey.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("invalid development CA in %s: unsupported key type", dir)
+	}
+	return &Authority{cert: cert, key: key, path: certPath}, nil
+}
+
+// create generates the CA, readable only by the user
+func create(dir, certPath, keyPath string) (*Authority, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	host, _ := os.Hostname()
+	template := &x509.Certificate{
+		SerialNumber:          serialNumber(),
+		Subject:               pkix.Name{Organization: []string{"SkyPort development CA"}, CommonName: "SkyPort dev CA " + host},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(caValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create development CA: %w", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return nil, fmt.Errorf("failed to save development CA key: %w", err)
+	}
+	if err := os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		return nil, fmt.Errorf("failed to save development CA: %w", err)
+	}
+	return &Authority{cert: cert, key: key, path: certPath}, nil
+}
+
+// CertPath is the PEM file of the CA certificate, to trust it by hand
+func (a *Authority) CertPath() string {
+	return a.path
+}
+
+// Issue returns a certificate for the given host names and IP addresses
+func (a *Authority) Issue(hosts []string) (*tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: serialNumber(),
+		Subject:      pkix.Name{Organization: []string{"SkyPort development certificate"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(leafValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, a.cert, &key.PublicKey, a.key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to issue development certificate: %w", err)
+	}
+	return &tls.Certificate{Certificate: [][]byte{der, a.cert.Raw}, PrivateKey: key}, nil
+}
+
+// Trust adds the CA to the system's trusted certificates with the platform's
+// tools, through sudo where needed. Firefox keeps its own trust store, where
+// the CA must be imported by hand.
+func (a *Authority) Trust() error {
+	var commands [][]string
+	switch runtime.GOOS {
+	case "darwin":
+		commands = [][]string{{"sudo", "security", "add-trusted-cert", "-d", "-r", "trustRoot", "-k", "/Library/Keychains/System.keychain", a.path}}
+	case "windows":
+		commands = [][]string{{"certutil", "-user", "-addstore", "-f", "Root", a.path}}
+	default:
+		if _, err := exec.LookPath("update-ca-certificates"); err == nil {
+			commands = [][]string{
+				{"sudo", "cp", a.path, "/usr/local/share/ca-certificates/skyport-dev-ca.crt"},
+				{"sudo", "update-ca-certificates"},
+			}
+		} else if _, err := exec.LookPath("update-ca-trust"); err == nil {
+			commands = [][]string{
+				{"sudo", "cp", a.path, "/etc/pki/ca-trust/source/anchors/skyport-dev-ca.pem"},
+				{"sudo", "update-ca-trust"},
+			}
+		} else {
+			return fmt.Errorf("no update-ca-certificates or update-ca-trust found; add %s to your system's trusted certificates by hand", a.path)
+		}
+	}
+
+	for _, args := range commands {
+		cmd := exec.Command(args[0], args[1:]...)
+		cmd.Stdin = os.Stdin
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("failed to trust the development CA: '%s' failed: %w", strings.Join(args, " "), err)
+		}
+	}
+	return nil
+}
+
+// serialNumber returns a random certificate serial number
+func serialNumber() *big.Int {
+	serial, _ := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	return serial
+}
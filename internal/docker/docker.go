@@ -0,0 +1,214 @@
+// Package docker is a minimal client for the Docker Engine API, used to
+// discover running containers (typically docker-compose services) that ask
+// for a tunnel through skyport.* labels.
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Labels read from containers
+const (
+	LabelSubdomain = "skyport.subdomain" // Subdomain of the tunnel to use
+	LabelTunnel    = "skyport.tunnel"    // Or the tunnel's name
+	LabelPort      = "skyport.port"      // Container port to forward to
+
+	labelComposeProject = "com.docker.compose.project"
+	labelComposeService = "com.docker.compose.service"
+)
+
+// defaultHost is the Docker daemon socket used when DOCKER_HOST is not set
+const defaultHost = "unix:///var/run/docker.sock"
+
+// Client talks to the Docker daemon
+type Client struct {
+	http *http.Client
+	base string
+}
+
+// NewClient connects to a Docker daemon address such as
+// unix:///var/run/docker.sock or tcp://127.0.0.1:2375. An empty host uses
+// DOCKER_HOST, then the default socket.
+func NewClient(host string) (*Client, error) {
+	if host == "" {
+		host = os.Getenv("DOCKER_HOST")
+	}
+	if host == "" {
+		host = defaultHost
+	}
+
+	scheme, address, ok := strings.Cut(host, "://")
+	if !ok {
+		return nil, fmt.Errorf("invalid docker host %q", host)
+	}
+
+	switch scheme {
+	case "unix":
+		transport := &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, "unix", address)
+			},
+		}
+		return &Client{http: &http.Client{Transport: transport, Timeout: 10 * time.Second}, base: "http://docker"}, nil
+	case "tcp", "http":
+		return &Client{http: &http.Client{Timeout: 10 * time.Second}, base: "http://" + address}, nil
+	default:
+		return nil, fmt.Errorf("unsupported docker host scheme %q (use unix:// or tcp://)", scheme)
+	}
+}
+
+// Port is a container port and where it is published on the host
+type Port struct {
+	IP          string `json:"IP"`
+	PrivatePort int    `json:"PrivatePort"`
+	PublicPort  int    `json:"PublicPort"`
+	Type        string `json:"Type"`
+}
+
+// Container is the subset of the container list response the agent uses
+type Container struct {
+	ID              string            `json:"Id"`
+	Names           []string          `json:"Names"`
+	Labels          map[string]string `json:"Labels"`
+	State           string            `json:"State"`
+	Ports           []Port            `json:"Ports"`
+	NetworkSettings struct {
+		Networks map[string]struct {
+			IPAddress string `json:"IPAddress"`
+		} `json:"Networks"`
+	} `json:"NetworkSettings"`
+}
+
+// Name returns the container's name without the leading slash
+func (c Container) Name() string {
+	if len(c.Names) == 0 {
+		return c.ID[:min(12, len(c.ID))]
+	}
+	return strings.TrimPrefix(c.Names[0], "/")
+}
+
+// LabeledContainers lists running containers that have a skyport.port label
+func (c *Client) LabeledContainers(ctx context.Context) ([]Container, error) {
+	filters, _ := json.Marshal(map[string][]string{"label": {LabelPort}})
+	endpoint := c.base + "/containers/json?filters=" + url.QueryEscape(string(filters))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach docker daemon: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, fmt.Errorf("docker daemon returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	var containers []Container
+	if err := json.NewDecoder(resp.Body).Decode(&containers); err != nil {
+		return nil, fmt.Errorf("failed to decode container list: %w", err)
+	}
+	return containers, nil
+}
+
+// Service is a container that asked for a tunnel
+type Service struct {
+	ContainerID string
+	Container   string // Container name
+	Project     string // docker-compose project, if any
+	Service     string // docker-compose service, if any
+	Subdomain   string
+	Tunnel      string
+	Port        int    // Container port
+	Target      string // host:port the agent can reach the port at
+}
+
+// Describe names the service for logs, preferring its compose name
+func (s Service) Describe() string {
+	if s.Project != "" && s.Service != "" {
+		return s.Project + "/" + s.Service
+	}
+	return s.Container
+}
+
+// Services turns labelled containers into services, sorted by container
+// name. Containers with incomplete or unusable labels are reported in errs.
+func Services(containers []Container) (services []Service, errs []error) {
+	for _, c := range containers {
+		service := Service{
+			ContainerID: c.ID,
+			Container:   c.Name(),
+			Project:     c.Labels[labelComposeProject],
+			Service:     c.Labels[labelComposeService],
+			Subdomain:   c.Labels[LabelSubdomain],
+			Tunnel:      c.Labels[LabelTunnel],
+		}
+
+		if service.Subdomain == "" && service.Tunnel == "" {
+			errs = append(errs, fmt.Errorf("%s: set %s or %s", service.Describe(), LabelSubdomain, LabelTunnel))
+			continue
+		}
+		port, err := strconv.Atoi(c.Labels[LabelPort])
+		if err != nil || port < 1 || port > 65535 {
+			errs = append(errs, fmt.Errorf("%s: invalid %s %q", service.Describe(), LabelPort, c.Labels[LabelPort]))
+			continue
+		}
+		service.Port = port
+
+		target, err := reachableAddress(c, port)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", service.Describe(), err))
+			continue
+		}
+		service.Target = target
+
+		services = append(services, service)
+	}
+
+	sort.Slice(services, func(i, j int) bool { return services[i].Container < services[j].Container })
+	return services, errs
+}
+
+// reachableAddress returns where the agent can reach a container port: the
+// published host port if there is one, otherwise the container's own IP
+// (which only works when the agent runs on the Docker host's network)
+func reachableAddress(c Container, port int) (string, error) {
+	for _, p := range c.Ports {
+		if p.PrivatePort != port || p.PublicPort == 0 || (p.Type != "" && p.Type != "tcp") {
+			continue
+		}
+		if p.IP == "" || p.IP == "0.0.0.0" || p.IP == "::" {
+			return net.JoinHostPort("localhost", strconv.Itoa(p.PublicPort)), nil
+		}
+		return net.JoinHostPort(p.IP, strconv.Itoa(p.PublicPort)), nil
+	}
+
+	networks := make([]string, 0, len(c.NetworkSettings.Networks))
+	for name := range c.NetworkSettings.Networks {
+		networks = append(networks, name)
+	}
+	sort.Strings(networks)
+	for _, name := range networks {
+		if ip := c.NetworkSettings.Networks[name].IPAddress; ip != "" {
+			return net.JoinHostPort(ip, strconv.Itoa(port)), nil
+		}
+	}
+
+	return "", fmt.Errorf("port %d is not published and the container has no IP address", port)
+}
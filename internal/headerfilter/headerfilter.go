@@ -0,0 +1,185 @@
+// Package headerfilter matches requests against allow and deny rules on the
+// headers the server forwards, such as the client's country code or user
+// agent.
+package headerfilter
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// Country is the pseudo-header rules use for the client's country code,
+// looked up in CountryHeaders
+const Country = "country"
+
+// Bots is a rule matching the user agents of crawlers and scripted clients
+const Bots = "bots"
+
+// CountryHeaders carry the client's ISO country code, in order of
+// preference: the SkyPort server's, then those of common proxies in front of
+// it
+var CountryHeaders = []string{"X-Skyport-Country", "CF-IPCountry", "CloudFront-Viewer-Country", "X-Country-Code"}
+
+// botPattern matches the user agents of well-known crawlers, headless
+// browsers and HTTP libraries
+var botPattern = regexp.MustCompile(`(?i)bot\b|bot/|crawl|spider|slurp|scrapy|headless|python-requests|python-urllib|go-http-client|libwww|httpclient|okhttp`)
+
+// Rule operators
+const (
+	opEqual    = "="
+	opNotEqual = "!="
+	opMatch    = "~"
+	opNotMatch = "!~"
+)
+
+// Rule compares one request header with values or a regular expression.
+// With = and != values are a comma-separated list compared without regard
+// to case; with ~ and !~ the value is a regular expression. A missing header
+// has the empty value, so "country != IN" also matches requests without a
+// country.
+type Rule struct {
+	Header string
+	Op     string
+	Value  string
+
+	values []string
+	regexp *regexp.Regexp
+}
+
+// ParseRule parses a rule written as "<header> <op> <value>", such as
+// "country != IN,US" or "user-agent ~ (?i)curl", or the "bots" shorthand
+func ParseRule(entry string) (Rule, error) {
+	entry = strings.TrimSpace(entry)
+	if strings.EqualFold(entry, Bots) {
+		return Rule{Header: Bots, regexp: botPattern}, nil
+	}
+
+	header, rest, _ := strings.Cut(entry, " ")
+	op, value, _ := strings.Cut(strings.TrimSpace(rest), " ")
+	value = strings.TrimSpace(value)
+	if header == "" || value == "" {
+		return Rule{}, fmt.Errorf("invalid rule %q: use <header> <op> <value>, such as 'country != IN' or 'user-agent ~ curl'", entry)
+	}
+
+	rule := Rule{Header: http.CanonicalHeaderKey(header), Op: op, Value: value}
+	if strings.EqualFold(header, Country) {
+		rule.Header = Country
+	}
+	switch op {
+	case opEqual, opNotEqual:
+		for _, v := range strings.Split(value, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				rule.values = append(rule.values, v)
+			}
+		}
+	case opMatch, opNotMatch:
+		compiled, err := regexp.Compile(value)
+		if err != nil {
+			return Rule{}, fmt.Errorf("invalid pattern in rule %q: %w", entry, err)
+		}
+		rule.regexp = compiled
+	default:
+		return Rule{}, fmt.Errorf("invalid operator %q in rule %q: use =, !=, ~ or !~", op, entry)
+	}
+	return rule, nil
+}
+
+// String returns the rule in the form ParseRule accepts
+func (r Rule) String() string {
+	if r.Header == Bots {
+		return Bots
+	}
+	return r.Header + " " + r.Op + " " + r.Value
+}
+
+// Matches reports whether a request matches the rule. get returns a request
+// header's value, or "" if it is missing.
+func (r Rule) Matches(get func(name string) string) bool {
+	if r.Header == Bots {
+		return r.regexp.MatchString(get("User-Agent"))
+	}
+
+	value := get(r.Header)
+	if r.Header == Country {
+		value = ""
+		for _, name := range CountryHeaders {
+			if value = get(name); value != "" {
+				break
+			}
+		}
+	}
+	value = strings.TrimSpace(value)
+
+	switch r.Op {
+	case opEqual, opNotEqual:
+		found := false
+		for _, v := range r.values {
+			if strings.EqualFold(v, value) {
+				found = true
+				break
+			}
+		}
+		return found == (r.Op == opEqual)
+	default:
+		return r.regexp.MatchString(value) == (r.Op == opMatch)
+	}
+}
+
+// Filter decides whether a request may reach a tunnel. Requests matching a
+// deny rule are always rejected; when there are allow rules, only requests
+// matching one of them are accepted.
+type Filter struct {
+	allow []Rule
+	deny  []Rule
+}
+
+// NotAllowed is reported as the rule rejecting requests that match no allow
+// rule
+const NotAllowed = "not allowed"
+
+// New builds a filter from allow and deny rules
+func New(allow, deny []string) (*Filter, error) {
+	allowRules, err := parseList(allow)
+	if err != nil {
+		return nil, err
+	}
+	denyRules, err := parseList(deny)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Filter{allow: allowRules, deny: denyRules}, nil
+}
+
+// Check reports whether a request is rejected, and the deny rule that
+// rejected it or NotAllowed
+func (f *Filter) Check(get func(name string) string) (bool, string) {
+	for _, rule := range f.deny {
+		if rule.Matches(get) {
+			return true, rule.String()
+		}
+	}
+	if len(f.allow) == 0 {
+		return false, ""
+	}
+	for _, rule := range f.allow {
+		if rule.Matches(get) {
+			return false, ""
+		}
+	}
+	return true, NotAllowed
+}
+
+func parseList(entries []string) ([]Rule, error) {
+	rules := make([]Rule, 0, len(entries))
+	for _, entry := range entries {
+		rule, err := ParseRule(entry)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
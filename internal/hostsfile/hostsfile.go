@@ -0,0 +1,243 @@
+// Package hostsfile maps local development domains to addresses in the
+// system hosts file. Entries it adds are marked with a comment, and only
+// those are ever changed or removed.
+package hostsfile
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// marker ends every line added by the agent, followed by the tunnel's name
+const marker = "# skyport dev-domain"
+
+// Entry maps a domain to the address of a tunnel's local service
+type Entry struct {
+	Domain string
+	IP     string
+	Tunnel string // Name of the tunnel the domain is for
+}
+
+var domainPattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?(\.[a-z0-9]([a-z0-9-]*[a-z0-9])?)+$`)
+
+// Path returns the location of the system hosts file
+func Path() string {
+	if runtime.GOOS == "windows" {
+		root := os.Getenv("SystemRoot")
+		if root == "" {
+			root = `C:\Windows`
+		}
+		return filepath.Join(root, "System32", "drivers", "etc", "hosts")
+	}
+	return "/etc/hosts"
+}
+
+// ValidDomain checks that a domain can be mapped: lowercase labels with at
+// least one dot, such as myapp.test or shop.localhost
+func ValidDomain(domain string) error {
+	if !domainPattern.MatchString(domain) || len(domain) > 253 {
+		return fmt.Errorf("'%s' is not a valid domain: use lowercase letters, digits, dashes and dots, e.g. myapp.test", domain)
+	}
+	return nil
+}
+
+// Entries returns the entries the agent added
+func Entries(data []byte) []Entry {
+	var entries []Entry
+	for _, line := range strings.Split(string(data), "\n") {
+		if entry, ok := parseEntry(line); ok {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// Add adds an entry, replacing one the agent added earlier for the same
+// domain. A domain mapped by someone else is left alone and reported.
+func Add(data []byte, entry Entry) ([]byte, error) {
+	lines := strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	if len(data) == 0 {
+		lines = nil
+	}
+
+	var kept []string
+	for _, line := range lines {
+		if existing, ok := parseEntry(line); ok && existing.Domain == entry.Domain {
+			continue
+		}
+		if mapsDomain(line, entry.Domain) {
+			return nil, fmt.Errorf("%s is already in the hosts file and was not added by skyport; remove it from %s first", entry.Domain, Path())
+		}
+		kept = append(kept, line)
+	}
+
+	kept = append(kept, fmt.Sprintf("%s\t%s\t%s %s", entry.IP, entry.Domain, marker, entry.Tunnel))
+	return []byte(strings.Join(kept, "\n") + "\n"), nil
+}
+
+// Remove removes the entry the agent added for a domain, and reports
+// whether there was one
+func Remove(data []byte, domain string) ([]byte, bool) {
+	lines := strings.Split(string(data), "\n")
+	kept := lines[:0]
+	removed := false
+	for _, line := range lines {
+		if entry, ok := parseEntry(line); ok && entry.Domain == domain {
+			removed = true
+			continue
+		}
+		kept = append(kept, line)
+	}
+	return []byte(strings.Join(kept, "\n")), removed
+}
+
+// CanWrite reports whether the hosts file can be replaced without elevated
+// rights
+func CanWrite(path string) bool {
+	tmp, err := os.CreateTemp(filepath.Dir(resolve(path)), tempPattern)
+	if err != nil {
+		return false
+	}
+	tmp.Close()
+	os.Remove(tmp.Name())
+	return true
+}
+
+// tempPattern names the temporary files new hosts files are written to
+const tempPattern = ".hosts-skyport-*"
+
+// Write replaces the hosts file, keeping its permissions. The new contents
+// are written to a temporary file next to it and renamed over it, so the
+// hosts file is never left half written. A hosts file that cannot be renamed
+// over, such as one bind mounted into a container, is overwritten in place
+// instead. Where that needs elevated rights, it is done with sudo, which may
+// prompt for a password on the terminal.
+func Write(path string, data []byte) error {
+	path = resolve(path)
+	mode := fs.FileMode(0644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	err := replace(path, data, mode)
+	if err == nil || !errors.Is(err, fs.ErrPermission) {
+		return err
+	}
+	if runtime.GOOS == "windows" {
+		return fmt.Errorf("%w; run the command from an Administrator prompt", err)
+	}
+	if _, lookErr := exec.LookPath("sudo"); lookErr != nil {
+		return fmt.Errorf("%w; run the command as root", err)
+	}
+
+	// The same steps as replace, as root
+	tmp := filepath.Join(filepath.Dir(path), strings.Replace(tempPattern, "*", strconv.Itoa(os.Getpid()), 1))
+	script := `cat > "$1" && chmod "$2" "$1" && sync && { mv -f "$1" "$3" 2>/dev/null || { cat "$1" > "$3" && sync && rm -f "$1"; }; } || { rm -f "$1"; exit 1; }`
+	cmd := exec.Command("sudo", "sh", "-c", script, "sh", tmp, fmt.Sprintf("%o", mode), path)
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Stdout = io.Discard
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to update %s with sudo: %w", path, err)
+	}
+	return nil
+}
+
+// rename is os.Rename, replaceable in tests
+var rename = os.Rename
+
+// replace writes data to a temporary file in path's directory, syncs it to
+// disk and renames it over path. If path is a mount point or on another
+// device than its directory, it is overwritten in place.
+func replace(path string, data []byte, mode fs.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), tempPattern)
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), mode)
+	}
+	if err == nil {
+		err = rename(tmp.Name(), path)
+		if errors.Is(err, syscall.EBUSY) || errors.Is(err, syscall.EXDEV) {
+			os.Remove(tmp.Name())
+			err = overwrite(path, data)
+		}
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// overwrite writes data over path's contents and syncs it to disk, keeping
+// the file itself, with its links and security labels
+func overwrite(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_TRUNC, 0)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// resolve returns the file a symlinked hosts file points to, which is the
+// one to replace
+func resolve(path string) string {
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		return resolved
+	}
+	return path
+}
+
+// parseEntry parses a line the agent added
+func parseEntry(line string) (Entry, bool) {
+	fields, comment, ok := strings.Cut(line, marker)
+	if !ok {
+		return Entry{}, false
+	}
+	parts := strings.Fields(fields)
+	if len(parts) != 2 {
+		return Entry{}, false
+	}
+	return Entry{IP: parts[0], Domain: parts[1], Tunnel: strings.TrimSpace(comment)}, true
+}
+
+// mapsDomain reports whether a hosts file line maps the domain
+func mapsDomain(line, domain string) bool {
+	fields, _, _ := strings.Cut(line, "#")
+	parts := strings.Fields(fields)
+	if len(parts) < 2 {
+		return false
+	}
+	for _, name := range parts[1:] {
+		if strings.EqualFold(name, domain) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,98 @@
+package hostsfile
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestWriteReplacesFileKeepingMode(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "hosts")
+	if err := os.WriteFile(path, []byte("127.0.0.1 localhost\n"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(dir, "hosts-link")
+	if err := os.Symlink(path, link); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := Add([]byte("127.0.0.1 localhost\n"), Entry{Domain: "app.test", IP: "127.0.0.1", Tunnel: "app"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Write(link, data); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(data) {
+		t.Errorf("hosts file is %q, want %q", got, data)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0640 {
+		t.Errorf("hosts file mode is %o, want 640", info.Mode().Perm())
+	}
+	if info, err := os.Lstat(link); err != nil || info.Mode()&os.ModeSymlink == 0 {
+		t.Error("symlink to the hosts file was replaced")
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("temporary files left behind: %v", entries)
+	}
+}
+
+func TestWriteOverwritesBindMountedFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "hosts")
+	if err := os.WriteFile(path, []byte("127.0.0.1 localhost\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// A hard link stands in for the outside of the bind mount: it only sees
+	// the change if the file is written in place
+	outside := filepath.Join(dir, "outside")
+	if err := os.Link(path, outside); err != nil {
+		t.Fatal(err)
+	}
+
+	// Renaming over a bind mounted file fails
+	defer func(orig func(string, string) error) { rename = orig }(rename)
+	rename = func(oldpath, newpath string) error {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: syscall.EBUSY}
+	}
+
+	data, err := Add([]byte("127.0.0.1 localhost\n"), Entry{Domain: "app.test", IP: "127.0.0.1", Tunnel: "app"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Write(path, data); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := os.ReadFile(outside)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(data) {
+		t.Errorf("hosts file is %q, want %q", got, data)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("temporary files left behind: %v", entries)
+	}
+}
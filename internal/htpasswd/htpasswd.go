@@ -0,0 +1,186 @@
+// Package htpasswd reads Apache htpasswd files and verifies passwords against
+// their hashes. Only the salted MD5 ($apr1$) and SHA-1 ({SHA}) formats are
+// supported; bcrypt and crypt(3) entries are skipped when loading.
+package htpasswd
+
+import (
+	"bufio"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+const (
+	apr1Prefix = "$apr1$"
+	shaPrefix  = "{SHA}"
+)
+
+// itoa64 is the alphabet used by MD5-crypt to encode salts and digests
+const itoa64 = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// Users maps user names to password hashes
+type Users map[string]string
+
+// Load reads an htpasswd file. Entries in unsupported formats are left out
+// and reported in skipped.
+func Load(path string) (users Users, skipped []string, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open htpasswd file: %w", err)
+	}
+	defer file.Close()
+
+	return Parse(file)
+}
+
+// Parse reads htpasswd entries ("user:hash", one per line) from r
+func Parse(r io.Reader) (users Users, skipped []string, err error) {
+	users = make(Users)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		name, hash, ok := strings.Cut(line, ":")
+		if !ok || name == "" {
+			continue
+		}
+		if !Supported(hash) {
+			skipped = append(skipped, name)
+			continue
+		}
+		users[name] = hash
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, fmt.Errorf("failed to read htpasswd file: %w", err)
+	}
+
+	return users, skipped, nil
+}
+
+// Supported reports whether hash is in a format Verify understands
+func Supported(hash string) bool {
+	return strings.HasPrefix(hash, apr1Prefix) || strings.HasPrefix(hash, shaPrefix)
+}
+
+// Verify checks a user's password against the stored hashes
+func (u Users) Verify(name, password string) bool {
+	hash, exists := u[name]
+	if !exists {
+		return false
+	}
+	return Verify(hash, password)
+}
+
+// Verify reports whether password matches hash
+func Verify(hash, password string) bool {
+	var computed string
+	switch {
+	case strings.HasPrefix(hash, apr1Prefix):
+		salt, _, _ := strings.Cut(strings.TrimPrefix(hash, apr1Prefix), "$")
+		computed = apr1(password, salt)
+	case strings.HasPrefix(hash, shaPrefix):
+		sum := sha1.Sum([]byte(password))
+		computed = shaPrefix + base64.StdEncoding.EncodeToString(sum[:])
+	default:
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(computed), []byte(hash)) == 1
+}
+
+// Hash returns an $apr1$ hash of password with a random salt, as produced by
+// "htpasswd -m"
+func Hash(password string) (string, error) {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate salt: %w", err)
+	}
+
+	salt := make([]byte, len(buf))
+	for i, b := range buf {
+		salt[i] = itoa64[int(b)%len(itoa64)]
+	}
+	return apr1(password, string(salt)), nil
+}
+
+// apr1 computes Apache's variant of the MD5-crypt algorithm
+func apr1(password, salt string) string {
+	if len(salt) > 8 {
+		salt = salt[:8]
+	}
+	pw := []byte(password)
+
+	alternate := md5.New()
+	alternate.Write(pw)
+	alternate.Write([]byte(salt))
+	alternate.Write(pw)
+	altSum := alternate.Sum(nil)
+
+	ctx := md5.New()
+	ctx.Write(pw)
+	ctx.Write([]byte(apr1Prefix))
+	ctx.Write([]byte(salt))
+	for i := len(pw); i > 0; i -= 16 {
+		ctx.Write(altSum[:min(i, 16)])
+	}
+	for i := len(pw); i > 0; i >>= 1 {
+		if i&1 == 1 {
+			ctx.Write([]byte{0})
+		} else {
+			ctx.Write(pw[:1])
+		}
+	}
+	final := ctx.Sum(nil)
+
+	// 1000 rounds to slow down brute forcing
+	for i := 0; i < 1000; i++ {
+		round := md5.New()
+		if i&1 == 1 {
+			round.Write(pw)
+		} else {
+			round.Write(final)
+		}
+		if i%3 != 0 {
+			round.Write([]byte(salt))
+		}
+		if i%7 != 0 {
+			round.Write(pw)
+		}
+		if i&1 == 1 {
+			round.Write(final)
+		} else {
+			round.Write(pw)
+		}
+		final = round.Sum(nil)
+	}
+
+	var out strings.Builder
+	out.WriteString(apr1Prefix)
+	out.WriteString(salt)
+	out.WriteByte('$')
+	for _, group := range [][3]int{{0, 6, 12}, {1, 7, 13}, {2, 8, 14}, {3, 9, 15}, {4, 10, 5}} {
+		v := uint(final[group[0]])<<16 | uint(final[group[1]])<<8 | uint(final[group[2]])
+		encode64(&out, v, 4)
+	}
+	encode64(&out, uint(final[11]), 2)
+
+	return out.String()
+}
+
+// encode64 writes the low 6*n bits of v using the crypt alphabet, least
+// significant first
+func encode64(out *strings.Builder, v uint, n int) {
+	for ; n > 0; n-- {
+		out.WriteByte(itoa64[v&0x3f])
+		v >>= 6
+	}
+}
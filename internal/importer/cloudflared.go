@@ -0,0 +1,70 @@
+package importer
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// cloudflaredConfig is the part of cloudflared's config.yml that routes
+// host names to local services
+type cloudflaredConfig struct {
+	Ingress []cloudflaredRule `yaml:"ingress"`
+}
+
+// cloudflaredRule is an ingress rule. The last rule is a catch-all without a
+// host name, typically answering http_status:404.
+type cloudflaredRule struct {
+	Hostname      string                 `yaml:"hostname"`
+	Path          string                 `yaml:"path"`
+	Service       string                 `yaml:"service"`
+	OriginRequest map[string]interface{} `yaml:"originRequest"`
+}
+
+func parseCloudflared(data []byte) ([]Definition, error) {
+	var cfg cloudflaredConfig
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse cloudflared config: %w", err)
+	}
+
+	var definitions []Definition
+	for _, rule := range cfg.Ingress {
+		if rule.Hostname == "" {
+			// The catch-all rule has no tunnel of its own
+			continue
+		}
+
+		label := firstLabel(rule.Hostname)
+		d := Definition{Name: tunnelName(label)}
+		if d.Name == "" {
+			d.Name = tunnelName(rule.Hostname)
+		}
+		if rule.Path != "" {
+			d.Skipped = "path-based rules are not supported"
+			definitions = append(definitions, d)
+			continue
+		}
+		if !strings.Contains(rule.Service, "://") {
+			// Such as http_status:404, hello_world or a unix socket
+			d.Skipped = fmt.Sprintf("service %q is not supported", rule.Service)
+			definitions = append(definitions, d)
+			continue
+		}
+		target, err := parseAddr(rule.Service)
+		if err != nil {
+			d.Skipped = err.Error()
+			definitions = append(definitions, d)
+			continue
+		}
+		d.Target = target
+
+		for option := range rule.OriginRequest {
+			d.Ignored = append(d.Ignored, "originRequest."+option)
+		}
+		sort.Strings(d.Ignored)
+		definitions = append(definitions, d)
+	}
+	return definitions, nil
+}
@@ -0,0 +1,157 @@
+// Package importer reads tunnel definitions from the configuration files of
+// other tunnel tools (ngrok and cloudflared), so they can be recreated as
+// SkyPort tunnels.
+package importer
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// Tool names accepted by Parse and DefaultPath
+const (
+	Ngrok       = "ngrok"
+	Cloudflared = "cloudflared"
+)
+
+// Definition is a tunnel found in another tool's configuration
+type Definition struct {
+	Name      string   // Tunnel name, usable as a subdomain
+	Subdomain string   // Subdomain the tool served it under, or "" to use the name
+	Target    string   // host:port requests are forwarded to
+	BasicAuth []string // "user:password" credentials the tool required
+	Skipped   string   // Why the definition cannot be imported, or ""
+	Ignored   []string // Options that have no SkyPort equivalent
+}
+
+// Port returns the port of the definition's target
+func (d Definition) Port() int {
+	_, port, _ := net.SplitHostPort(d.Target)
+	n, _ := strconv.Atoi(port)
+	return n
+}
+
+// Parse reads the definitions in a configuration file of the given tool
+func Parse(tool string, data []byte) ([]Definition, error) {
+	switch tool {
+	case Ngrok:
+		return parseNgrok(data)
+	case Cloudflared:
+		return parseCloudflared(data)
+	default:
+		return nil, fmt.Errorf("unknown tool %q: use %s or %s", tool, Ngrok, Cloudflared)
+	}
+}
+
+// DefaultPath returns the first configuration file of the given tool that
+// exists in its usual locations, or "" if there is none
+func DefaultPath(tool string) string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+
+	var candidates []string
+	switch tool {
+	case Ngrok:
+		switch runtime.GOOS {
+		case "darwin":
+			candidates = append(candidates, filepath.Join(home, "Library", "Application Support", "ngrok", "ngrok.yml"))
+		case "windows":
+			candidates = append(candidates, filepath.Join(os.Getenv("LOCALAPPDATA"), "ngrok", "ngrok.yml"))
+		}
+		candidates = append(candidates,
+			filepath.Join(home, ".config", "ngrok", "ngrok.yml"),
+			filepath.Join(home, ".ngrok2", "ngrok.yml"))
+	case Cloudflared:
+		candidates = append(candidates,
+			filepath.Join(home, ".cloudflared", "config.yml"),
+			filepath.Join(home, ".cloudflared", "config.yaml"),
+			filepath.Join("/etc", "cloudflared", "config.yml"),
+			filepath.Join("/etc", "cloudflared", "config.yaml"))
+	}
+
+	for _, path := range candidates {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+// parseAddr turns an address such as 8080, localhost:8080 or
+// http://127.0.0.1:8080 into host:port
+func parseAddr(addr string) (string, error) {
+	addr = strings.TrimSpace(addr)
+	if addr == "" {
+		return "", fmt.Errorf("no address")
+	}
+	if n, err := strconv.Atoi(addr); err == nil {
+		if n < 1 || n > 65535 {
+			return "", fmt.Errorf("invalid port %d", n)
+		}
+		return net.JoinHostPort("localhost", addr), nil
+	}
+
+	if strings.Contains(addr, "://") {
+		u, err := url.Parse(addr)
+		if err != nil {
+			return "", fmt.Errorf("invalid address %q: %w", addr, err)
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return "", fmt.Errorf("%s services are not supported", u.Scheme)
+		}
+		if u.Path != "" && u.Path != "/" {
+			return "", fmt.Errorf("paths in addresses are not supported")
+		}
+		addr = u.Host
+		if u.Port() == "" {
+			port := "80"
+			if u.Scheme == "https" {
+				port = "443"
+			}
+			addr = net.JoinHostPort(u.Hostname(), port)
+		}
+	}
+
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", fmt.Errorf("invalid address %q: %w", addr, err)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return "", fmt.Errorf("invalid port %q", port)
+	}
+	if host == "" {
+		host = "localhost"
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+// nonNameChars are replaced when making a tunnel name from another tool's
+var nonNameChars = regexp.MustCompile(`[^a-z0-9]+`)
+
+// tunnelName makes a name usable as a subdomain
+func tunnelName(name string) string {
+	name = strings.Trim(nonNameChars.ReplaceAllString(strings.ToLower(name), "-"), "-")
+	if len(name) > 63 {
+		name = strings.TrimRight(name[:63], "-")
+	}
+	return name
+}
+
+// firstLabel returns the first label of a host name, or "" for a bare domain
+func firstLabel(hostname string) string {
+	hostname = strings.TrimSuffix(strings.ToLower(hostname), ".")
+	label, rest, ok := strings.Cut(hostname, ".")
+	if !ok || !strings.Contains(rest, ".") || strings.Contains(label, "*") {
+		return ""
+	}
+	return label
+}
@@ -0,0 +1,142 @@
+package importer
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ngrokConfig is the part of ngrok.yml that defines tunnels: "tunnels" in
+// agent config version 2, and also "endpoints" in version 3
+type ngrokConfig struct {
+	Tunnels   map[string]map[string]interface{} `yaml:"tunnels"`
+	Endpoints []map[string]interface{}          `yaml:"endpoints"`
+}
+
+// ngrokTunnelOptions are the tunnel options that carry over to SkyPort
+var ngrokTunnelOptions = map[string]bool{
+	"proto": true, "addr": true, "subdomain": true, "hostname": true, "domain": true,
+	"auth": true, "basic_auth": true, "schemes": true, "bind_tls": true, "inspect": true,
+}
+
+func parseNgrok(data []byte) ([]Definition, error) {
+	var cfg ngrokConfig
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse ngrok config: %w", err)
+	}
+
+	names := make([]string, 0, len(cfg.Tunnels))
+	for name := range cfg.Tunnels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var definitions []Definition
+	for _, name := range names {
+		definitions = append(definitions, ngrokTunnel(name, cfg.Tunnels[name]))
+	}
+	for i, endpoint := range cfg.Endpoints {
+		definitions = append(definitions, ngrokEndpoint(i, endpoint))
+	}
+	return definitions, nil
+}
+
+// ngrokTunnel converts a tunnel of the "tunnels" section
+func ngrokTunnel(name string, options map[string]interface{}) Definition {
+	d := Definition{Name: tunnelName(name)}
+
+	if proto := stringOption(options, "proto"); proto != "" && proto != "http" {
+		d.Skipped = fmt.Sprintf("%s tunnels are not supported", proto)
+		return d
+	}
+	target, err := parseAddr(stringOption(options, "addr"))
+	if err != nil {
+		d.Skipped = err.Error()
+		return d
+	}
+	d.Target = target
+
+	if subdomain := stringOption(options, "subdomain"); subdomain != "" {
+		d.Subdomain = tunnelName(subdomain)
+	} else if hostname := stringOption(options, "hostname"); hostname != "" {
+		d.Subdomain = tunnelName(firstLabel(hostname))
+	} else if domain := stringOption(options, "domain"); domain != "" {
+		d.Subdomain = tunnelName(firstLabel(domain))
+	}
+
+	if auth := stringOption(options, "auth"); auth != "" {
+		d.BasicAuth = append(d.BasicAuth, auth)
+	}
+	d.BasicAuth = append(d.BasicAuth, stringsOption(options, "basic_auth")...)
+
+	for option := range options {
+		if !ngrokTunnelOptions[option] {
+			d.Ignored = append(d.Ignored, option)
+		}
+	}
+	sort.Strings(d.Ignored)
+	return d
+}
+
+// ngrokEndpoint converts an endpoint of the "endpoints" section
+func ngrokEndpoint(index int, options map[string]interface{}) Definition {
+	d := Definition{Name: tunnelName(stringOption(options, "name"))}
+
+	u, err := url.Parse(stringOption(options, "url"))
+	unsupported := err == nil && u.Scheme != "" && u.Scheme != "http" && u.Scheme != "https"
+	if err == nil && !unsupported && u.Host != "" {
+		d.Subdomain = tunnelName(firstLabel(u.Hostname()))
+	}
+	if d.Name == "" {
+		d.Name = d.Subdomain
+	}
+	if d.Name == "" {
+		d.Name = fmt.Sprintf("endpoint-%d", index+1)
+	}
+	if unsupported {
+		d.Skipped = fmt.Sprintf("%s endpoints are not supported", u.Scheme)
+		return d
+	}
+
+	upstream, _ := options["upstream"].(map[string]interface{})
+	target, err := parseAddr(stringOption(upstream, "url"))
+	if err != nil {
+		d.Skipped = err.Error()
+		return d
+	}
+	d.Target = target
+
+	for option := range options {
+		if option != "name" && option != "url" && option != "upstream" && option != "description" && option != "metadata" {
+			d.Ignored = append(d.Ignored, option)
+		}
+	}
+	sort.Strings(d.Ignored)
+	return d
+}
+
+// stringOption returns a scalar option as a string, or ""
+func stringOption(options map[string]interface{}, key string) string {
+	switch value := options[key].(type) {
+	case string:
+		return value
+	case int:
+		return fmt.Sprint(value)
+	default:
+		return ""
+	}
+}
+
+// stringsOption returns an option that is a list of strings
+func stringsOption(options map[string]interface{}, key string) []string {
+	list, _ := options[key].([]interface{})
+	var values []string
+	for _, item := range list {
+		if s, ok := item.(string); ok {
+			values = append(values, s)
+		}
+	}
+	return values
+}
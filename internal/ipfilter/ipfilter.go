@@ -0,0 +1,93 @@
+// Package ipfilter matches client addresses against allow and deny lists of
+// IP addresses and CIDR ranges.
+package ipfilter
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// Filter decides whether a client address may reach a tunnel. Addresses in
+// the deny list are always rejected; when the allow list is non-empty only
+// addresses in it are accepted.
+type Filter struct {
+	allow []netip.Prefix
+	deny  []netip.Prefix
+}
+
+// New builds a filter from allow and deny entries
+func New(allow, deny []string) (*Filter, error) {
+	allowPrefixes, err := parseList(allow)
+	if err != nil {
+		return nil, err
+	}
+	denyPrefixes, err := parseList(deny)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Filter{allow: allowPrefixes, deny: denyPrefixes}, nil
+}
+
+// ParsePrefix parses an IP address ("203.0.113.7") or CIDR range
+// ("203.0.113.0/24"). A bare address is treated as a single-address range.
+func ParsePrefix(entry string) (netip.Prefix, error) {
+	entry = strings.TrimSpace(entry)
+	if strings.Contains(entry, "/") {
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("invalid CIDR range %q", entry)
+		}
+		// Client addresses are unmapped before matching, so IPv4-mapped
+		// ranges must be too
+		if prefix.Addr().Is4In6() && prefix.Bits() >= 96 {
+			prefix = netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96)
+		}
+		return prefix.Masked(), nil
+	}
+
+	addr, err := netip.ParseAddr(entry)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid IP address %q", entry)
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// Allowed reports whether the client at ip may pass. An unparseable or
+// empty address is only allowed when there are no lists at all, since it
+// can't be shown to be outside the deny list.
+func (f *Filter) Allowed(ip string) bool {
+	addr, err := netip.ParseAddr(strings.TrimSpace(ip))
+	if err != nil {
+		return len(f.allow) == 0 && len(f.deny) == 0
+	}
+	addr = addr.Unmap()
+
+	if contains(f.deny, addr) {
+		return false
+	}
+	return len(f.allow) == 0 || contains(f.allow, addr)
+}
+
+func parseList(entries []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(entries))
+	for _, entry := range entries {
+		prefix, err := ParsePrefix(entry)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	return prefixes, nil
+}
+
+func contains(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
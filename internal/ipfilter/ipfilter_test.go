@@ -0,0 +1,44 @@
+package ipfilter
+
+import "testing"
+
+func TestAllowedRejectsUnparseableAddressWithAnyList(t *testing.T) {
+	denyOnly, err := New(nil, []string{"203.0.113.66"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ip := range []string{"x", "", "203.0.113"} {
+		if denyOnly.Allowed(ip) {
+			t.Errorf("deny-only filter allowed unparseable address %q", ip)
+		}
+	}
+	if !denyOnly.Allowed("198.51.100.1") {
+		t.Error("deny-only filter rejected an address outside the deny list")
+	}
+
+	none, err := New(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !none.Allowed("x") {
+		t.Error("filter without lists rejected an unparseable address")
+	}
+}
+
+func TestAllowedAllowList(t *testing.T) {
+	filter, err := New([]string{"203.0.113.0/24"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]bool{
+		"203.0.113.7":        true,
+		"::ffff:203.0.113.7": true,
+		"198.51.100.1":       false,
+		"x":                  false,
+	}
+	for ip, want := range tests {
+		if got := filter.Allowed(ip); got != want {
+			t.Errorf("Allowed(%q) = %v, want %v", ip, got, want)
+		}
+	}
+}
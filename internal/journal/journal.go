@@ -0,0 +1,164 @@
+// Package journal keeps a persistent, size-bounded record of tunnel lifecycle
+// and authentication events, shared by all skyport processes on the machine.
+package journal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"skyport-agent/internal/config"
+	"skyport-agent/internal/logger"
+	"sync"
+	"time"
+)
+
+// maxJournalSize bounds the journal file; once exceeded, the oldest half is dropped
+const maxJournalSize = 1024 * 1024
+
+// Account and agent event types. Tunnel events use the tunnel package's
+// event names.
+const (
+	EventLogin  = "login"
+	EventLogout = "logout"
+
+	// EventRemoteCommand is a command sent by the server, run or refused
+	EventRemoteCommand = "remote_command"
+)
+
+// Entry is one journaled event
+type Entry struct {
+	Time     time.Time `json:"time"`
+	Type     string    `json:"type"`
+	Tunnel   string    `json:"tunnel,omitempty"`
+	TunnelID string    `json:"tunnel_id,omitempty"`
+	Reason   string    `json:"reason,omitempty"`
+	Attempt  int       `json:"attempt,omitempty"`
+	PID      int       `json:"pid"`
+}
+
+// Filter selects entries when reading the journal
+type Filter struct {
+	Since  time.Time
+	Tunnel string // Name or ID; empty matches all
+	Type   string // Empty matches all
+}
+
+var mutex sync.Mutex
+
+// Path returns the journal file location
+func Path() (string, error) {
+	configDir, err := config.GetConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(configDir, "events.jsonl"), nil
+}
+
+// Record appends an event. Failures are logged, never returned, so journaling
+// can't interfere with the operation being recorded.
+func Record(entry Entry) {
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+	entry.PID = os.Getpid()
+
+	if err := appendEntry(entry); err != nil {
+		logger.Debug("Failed to write event journal: %v", err)
+	}
+}
+
+func appendEntry(entry Entry) error {
+	path, err := Path()
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(append(data, '\n'))
+	info, statErr := file.Stat()
+	file.Close()
+	if err != nil {
+		return err
+	}
+
+	if statErr == nil && info.Size() > maxJournalSize {
+		return trim(path)
+	}
+	return nil
+}
+
+// trim drops the oldest entries so the journal is about half its maximum size
+func trim(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	cut := len(data) - maxJournalSize/2
+	if cut <= 0 {
+		return nil
+	}
+	// Start at the next full line
+	if i := bytes.IndexByte(data[cut:], '\n'); i >= 0 {
+		cut += i + 1
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data[cut:], 0600); err != nil {
+		return fmt.Errorf("failed to trim event journal: %w", err)
+	}
+	return os.Rename(tmp, path)
+}
+
+// Read returns the entries matching the filter, oldest first
+func Read(filter Filter) ([]Entry, error) {
+	path, err := Path()
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []Entry
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue // Partially written or trimmed line
+		}
+
+		if entry.Time.Before(filter.Since) {
+			continue
+		}
+		if filter.Tunnel != "" && entry.Tunnel != filter.Tunnel && entry.TunnelID != filter.Tunnel {
+			continue
+		}
+		if filter.Type != "" && entry.Type != filter.Type {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, scanner.Err()
+}
@@ -0,0 +1,75 @@
+// Package localnet dials the local services tunnels forward to. "localhost"
+// is tried on both the IPv4 and the IPv6 loopback address, so a service
+// listening on only one of them is reached whichever one the system resolves
+// localhost to.
+package localnet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+var dialer = &net.Dialer{Timeout: 10 * time.Second, KeepAlive: 30 * time.Second}
+
+// preferred remembers, per localhost port, the loopback address that last
+// accepted a connection so it is tried first next time
+var preferred sync.Map
+
+// Transport is the HTTP transport for requests to local services
+var Transport = &http.Transport{
+	DialContext:         DialContext,
+	MaxIdleConnsPerHost: 16,
+	IdleConnTimeout:     90 * time.Second,
+}
+
+// DialContext connects to a local address. Addresses other than localhost
+// (IP literals such as [::1]:8080, or other hosts) are dialed as given.
+func DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil || !strings.EqualFold(host, "localhost") {
+		return dialer.DialContext(ctx, network, address)
+	}
+
+	var errs []error
+	for _, ip := range loopbacks(network, port) {
+		conn, err := dialer.DialContext(ctx, network, net.JoinHostPort(ip, port))
+		if err == nil {
+			preferred.Store(port, ip)
+			return conn, nil
+		}
+		errs = append(errs, err)
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, fmt.Errorf("nothing is listening on localhost:%s (tried IPv4 and IPv6): %w", port, errors.Join(errs...))
+}
+
+// Dial connects to a local address with a timeout
+func Dial(network, address string, timeout time.Duration) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return DialContext(ctx, network, address)
+}
+
+// loopbacks returns the loopback addresses to try for a port, the one that
+// worked last time first
+func loopbacks(network, port string) []string {
+	switch network {
+	case "tcp4":
+		return []string{"127.0.0.1"}
+	case "tcp6":
+		return []string{"::1"}
+	}
+
+	if last, ok := preferred.Load(port); ok && last == "::1" {
+		return []string{"::1", "127.0.0.1"}
+	}
+	return []string{"127.0.0.1", "::1"}
+}
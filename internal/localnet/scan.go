@@ -0,0 +1,175 @@
+package localnet
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"io"
+	"net"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultScanPorts are the ports development servers commonly listen on
+const DefaultScanPorts = "3000-3010,4000,4200,4321,5000,5173,5174,5500,8000,8008,8080,8081,8088,8888,9000"
+
+// scanWorkers bounds the ports probed at once
+const scanWorkers = 64
+
+var titlePattern = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+
+// Service is something found listening on a localhost port
+type Service struct {
+	Port   int
+	HTTP   bool   // Answered an HTTP request
+	Status int    // Status of GET /, if HTTP
+	Server string // Server or X-Powered-By header, if HTTP
+	Title  string // <title> of the page at /, if HTML
+}
+
+// Describe names the service as well as it identified itself
+func (s Service) Describe() string {
+	if !s.HTTP {
+		return "not HTTP"
+	}
+	var parts []string
+	if s.Title != "" {
+		parts = append(parts, strconv.Quote(s.Title))
+	}
+	if s.Server != "" {
+		parts = append(parts, s.Server)
+	}
+	parts = append(parts, fmt.Sprintf("HTTP %d", s.Status))
+	return strings.Join(parts, ", ")
+}
+
+// ParsePorts reads a port list such as "3000-3010,8080"
+func ParsePorts(spec string) ([]int, error) {
+	seen := make(map[int]bool)
+	var ports []int
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		low, high, isRange := strings.Cut(part, "-")
+		first, err := parsePort(low)
+		if err != nil {
+			return nil, err
+		}
+		last := first
+		if isRange {
+			if last, err = parsePort(high); err != nil {
+				return nil, err
+			}
+			if last < first {
+				return nil, fmt.Errorf("invalid port range %q", part)
+			}
+		}
+		for port := first; port <= last; port++ {
+			if !seen[port] {
+				seen[port] = true
+				ports = append(ports, port)
+			}
+		}
+	}
+	if len(ports) == 0 {
+		return nil, fmt.Errorf("no ports in %q", spec)
+	}
+	sort.Ints(ports)
+	return ports, nil
+}
+
+func parsePort(value string) (int, error) {
+	port, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil || port < 1 || port > 65535 {
+		return 0, fmt.Errorf("invalid port %q", value)
+	}
+	return port, nil
+}
+
+// Scan checks which of the ports something listens on at localhost, and
+// asks each listener for / to identify it. Each probe takes at most timeout.
+func Scan(ctx context.Context, ports []int, timeout time.Duration) []Service {
+	jobs := make(chan int)
+	var mu sync.Mutex
+	var found []Service
+
+	var wg sync.WaitGroup
+	for i := 0; i < scanWorkers && i < len(ports); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for port := range jobs {
+				if service, ok := probe(ctx, port, timeout); ok {
+					mu.Lock()
+					found = append(found, service)
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+
+feed:
+	for _, port := range ports {
+		select {
+		case jobs <- port:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	sort.Slice(found, func(i, j int) bool { return found[i].Port < found[j].Port })
+	return found
+}
+
+// probe checks one port, and reports whether something listens on it
+func probe(ctx context.Context, port int, timeout time.Duration) (Service, bool) {
+	address := net.JoinHostPort("localhost", strconv.Itoa(port))
+	conn, err := Dial("tcp", address, timeout)
+	if err != nil {
+		return Service{}, false
+	}
+	conn.Close()
+
+	service := Service{Port: port}
+	requestCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(requestCtx, http.MethodGet, "http://"+address+"/", nil)
+	if err != nil {
+		return service, true
+	}
+	req.Header.Set("User-Agent", "skyport-discover")
+
+	client := &http.Client{
+		Transport: Transport,
+		// The page at / is what identifies the service, not where it sends us
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return service, true
+	}
+	defer resp.Body.Close()
+
+	service.HTTP = true
+	service.Status = resp.StatusCode
+	service.Server = resp.Header.Get("Server")
+	if service.Server == "" {
+		service.Server = resp.Header.Get("X-Powered-By")
+	}
+	if strings.Contains(resp.Header.Get("Content-Type"), "html") {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		if match := titlePattern.FindSubmatch(body); match != nil {
+			service.Title = strings.Join(strings.Fields(html.UnescapeString(string(match[1]))), " ")
+		}
+	}
+	return service, true
+}
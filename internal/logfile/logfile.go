@@ -0,0 +1,118 @@
+// Package logfile provides an append-only file that rotates by size. Rotated
+// files are kept as name.1 (newest) through name.N (oldest).
+package logfile
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+)
+
+// RotatingFile is safe for concurrent use
+type RotatingFile struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	mutex sync.Mutex
+	file  *os.File
+	size  int64
+}
+
+// Open opens (or creates) a rotating file. A maxSize of 0 disables rotation.
+func Open(path string, maxSize int64, maxBackups int) (*RotatingFile, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create log directory: %w", err)
+	}
+
+	f := &RotatingFile{
+		path:       path,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+	}
+	if err := f.openFile(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Path returns the file being written to
+func (f *RotatingFile) Path() string {
+	return f.path
+}
+
+// Write appends data, rotating first if it would push the file past maxSize
+func (f *RotatingFile) Write(data []byte) (int, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.file == nil {
+		return 0, fmt.Errorf("log file is closed")
+	}
+
+	if f.maxSize > 0 && f.size > 0 && f.size+int64(len(data)) > f.maxSize {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := f.file.Write(data)
+	f.size += int64(n)
+	return n, err
+}
+
+// Close closes the file
+func (f *RotatingFile) Close() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+func (f *RotatingFile) openFile() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to stat log file: %w", err)
+	}
+
+	f.file = file
+	f.size = info.Size()
+	return nil
+}
+
+// rotate shifts name.N-1 -> name.N ... name -> name.1 and reopens name
+func (f *RotatingFile) rotate() error {
+	f.file.Close()
+	f.file = nil
+
+	if f.maxBackups > 0 {
+		os.Remove(f.backupName(f.maxBackups))
+		for i := f.maxBackups - 1; i >= 1; i-- {
+			os.Rename(f.backupName(i), f.backupName(i+1))
+		}
+		if err := os.Rename(f.path, f.backupName(1)); err != nil {
+			return fmt.Errorf("failed to rotate log file: %w", err)
+		}
+	} else if err := os.Remove(f.path); err != nil {
+		return fmt.Errorf("failed to rotate log file: %w", err)
+	}
+
+	return f.openFile()
+}
+
+func (f *RotatingFile) backupName(n int) string {
+	return f.path + "." + strconv.Itoa(n)
+}
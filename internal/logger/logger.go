@@ -10,37 +10,41 @@ import (
 func Debug(format string, args ...interface{}) {
 	if config.IsDebugMode() {
 		message := fmt.Sprintf(format, args...)
-		log.Printf("[DEBUG] %s", message)
+		log.Printf("[DEBUG] %s", message) // Redacted by the log output
 	}
 }
 
 // Info logs informational messages (always shown)
 func Info(format string, args ...interface{}) {
-	message := fmt.Sprintf(format, args...)
-	fmt.Printf("✓ %s\n", message)
+	message := Redact(fmt.Sprintf(format, args...))
+	remember("INFO", message)
+	fmt.Printf("%s %s\n", SuccessMark(), message)
 }
 
 // Warning logs warning messages (always shown)
 func Warning(format string, args ...interface{}) {
-	message := fmt.Sprintf(format, args...)
-	fmt.Printf("⚠ %s\n", message)
+	message := Redact(fmt.Sprintf(format, args...))
+	remember("WARN", message)
+	fmt.Printf("%s %s\n", WarningMark(), message)
 }
 
 // Error logs error messages (always shown)
 func Error(format string, args ...interface{}) {
-	message := fmt.Sprintf(format, args...)
-	fmt.Printf("✗ %s\n", message)
+	message := Redact(fmt.Sprintf(format, args...))
+	remember("ERROR", message)
+	fmt.Printf("%s %s\n", ErrorMark(), message)
 }
 
 // Success logs success messages (always shown)
 func Success(format string, args ...interface{}) {
-	message := fmt.Sprintf(format, args...)
-	fmt.Printf("✓ %s\n", message)
+	message := Redact(fmt.Sprintf(format, args...))
+	remember("INFO", message)
+	fmt.Printf("%s %s\n", SuccessMark(), message)
 }
 
 // Plain prints a plain message without any prefix (always shown)
 func Plain(format string, args ...interface{}) {
-	fmt.Printf(format+"\n", args...)
+	fmt.Println(Redact(fmt.Sprintf(format, args...)))
 }
 
 // ErrorWithDetails logs an error with detailed information in debug mode
@@ -0,0 +1,89 @@
+package logger
+
+import (
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// redacted replaces each secret
+const redacted = "[REDACTED]"
+
+// minSecretLength keeps short values, which could be ordinary words, from
+// being registered as secrets
+const minSecretLength = 8
+
+// secretPatterns match secrets that can end up in log lines. The first group,
+// if any, is kept so the redacted line still shows what was there.
+var secretPatterns = []*regexp.Regexp{
+	// key=value, "key": "value", Key:value (%+v of a struct) and
+	// Key:[value] (a header map) with secret-looking keys
+	regexp.MustCompile(`(?i)((?:token|password|passwd|secret|api[_-]?key|authorization|cookie|tunnel-auth)"?\s*[:=]\s*[\["]?(?:(?:bearer|basic)\s+)?)[^"\s,&;\]}]+`),
+	// Authorization headers
+	regexp.MustCompile(`(?i)(bearer\s+)[A-Za-z0-9._~+/=-]+`),
+	regexp.MustCompile(`(?i)(basic\s+)[A-Za-z0-9+/=]{8,}`),
+	// JWTs anywhere, such as in query strings
+	regexp.MustCompile(`()eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`),
+	// Credentials in URLs
+	regexp.MustCompile(`(://[^/\s:@]+:)[^/\s@]+@`),
+}
+
+var (
+	secretsMu sync.RWMutex
+	// secrets are values known to be secret, such as the login token and
+	// tunnel auth tokens, masked wherever they appear
+	secrets = map[string]bool{}
+)
+
+// AddSecret registers a value, such as a token, to be masked in everything
+// logged from now on
+func AddSecret(secret string) {
+	if len(secret) < minSecretLength {
+		return
+	}
+	secretsMu.Lock()
+	secrets[secret] = true
+	secretsMu.Unlock()
+}
+
+// Redact hides tokens, passwords and other secrets in free text such as log
+// lines and log files
+func Redact(text string) string {
+	for _, pattern := range secretPatterns {
+		text = pattern.ReplaceAllStringFunc(text, func(match string) string {
+			groups := pattern.FindStringSubmatch(match)
+			keep := ""
+			if len(groups) > 1 {
+				keep = groups[1]
+			}
+			if strings.HasPrefix(strings.TrimLeft(match[len(keep):], "["), "REDACTED") {
+				return match // Already masked
+			}
+			masked := keep + redacted
+			if match[len(match)-1] == '@' {
+				masked += "@"
+			}
+			return masked
+		})
+	}
+
+	secretsMu.RLock()
+	for secret := range secrets {
+		text = strings.ReplaceAll(text, secret, redacted)
+	}
+	secretsMu.RUnlock()
+	return text
+}
+
+// redactWriter redacts everything written through it
+type redactWriter struct {
+	w io.Writer
+}
+
+func (r redactWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(r.w, Redact(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
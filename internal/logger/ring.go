@@ -0,0 +1,70 @@
+package logger
+
+import (
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ringSize is the number of recent log lines kept in memory for crash reports
+const ringSize = 200
+
+// ringBuffer keeps the most recent log lines
+type ringBuffer struct {
+	mu    sync.Mutex
+	lines []string
+	next  int
+	full  bool
+}
+
+var recent = &ringBuffer{lines: make([]string, ringSize)}
+
+func init() {
+	// Capture standard library log output (used throughout the service package)
+	// as well, with secrets masked before it is written anywhere
+	log.SetOutput(redactWriter{io.MultiWriter(os.Stderr, recent)})
+}
+
+// add records a line with a timestamp
+func (r *ringBuffer) add(line string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.lines[r.next] = line
+	r.next = (r.next + 1) % len(r.lines)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// Write implements io.Writer for the standard logger
+func (r *ringBuffer) Write(p []byte) (int, error) {
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		r.add(line)
+	}
+	return len(p), nil
+}
+
+// snapshot returns the buffered lines, oldest first
+func (r *ringBuffer) snapshot() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.full {
+		return append([]string(nil), r.lines[:r.next]...)
+	}
+	return append(append([]string(nil), r.lines[r.next:]...), r.lines[:r.next]...)
+}
+
+// remember records a message printed by this package
+func remember(level, message string) {
+	recent.add(time.Now().Format("2006/01/02 15:04:05") + " " + level + " " + message)
+}
+
+// Recent returns the most recent log lines, oldest first
+func Recent() []string {
+	return recent.snapshot()
+}
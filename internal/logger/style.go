@@ -0,0 +1,87 @@
+package logger
+
+import (
+	"os"
+)
+
+// ANSI color codes for the status symbols
+const (
+	colorReset  = "\033[0m"
+	colorRed    = "\033[31m"
+	colorGreen  = "\033[32m"
+	colorYellow = "\033[33m"
+)
+
+var (
+	// unicodeOutput is whether stdout is a terminal that can be trusted to
+	// render symbols. Pipes and CI logs get ASCII instead.
+	unicodeOutput = IsTerminal(os.Stdout)
+	// colorOutput additionally honours NO_COLOR (https://no-color.org)
+	colorOutput = unicodeOutput && os.Getenv("NO_COLOR") == ""
+)
+
+// mark is a status symbol with its ASCII fallback
+type mark struct {
+	symbol string
+	ascii  string
+	color  string
+}
+
+var (
+	successMark = mark{symbol: "✓", ascii: "+", color: colorGreen}
+	errorMark   = mark{symbol: "✗", ascii: "x", color: colorRed}
+	warningMark = mark{symbol: "⚠", ascii: "!", color: colorYellow}
+	arrowMark   = mark{symbol: "→", ascii: "->"}
+)
+
+func (m mark) String() string {
+	if !unicodeOutput {
+		return m.ascii
+	}
+	if colorOutput && m.color != "" {
+		return m.color + m.symbol + colorReset
+	}
+	return m.symbol
+}
+
+// DisableColor turns off colored output, as the --no-color flag does
+func DisableColor() {
+	colorOutput = false
+}
+
+// SuccessMark returns the symbol that starts a success line
+func SuccessMark() string {
+	return successMark.String()
+}
+
+// ErrorMark returns the symbol that starts an error line
+func ErrorMark() string {
+	return errorMark.String()
+}
+
+// WarningMark returns the symbol that starts a warning line
+func WarningMark() string {
+	return warningMark.String()
+}
+
+// Arrow returns the symbol between a tunnel's public URL and its local target
+func Arrow() string {
+	return arrowMark.String()
+}
+
+// IsTerminal reports whether f is an interactive terminal, which can render
+// symbols and colors and redraw a line in place
+func IsTerminal(f *os.File) bool {
+	if os.Getenv("TERM") == "dumb" {
+		return false
+	}
+	info, err := f.Stat()
+	if err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+	// The null device is a character device too, but nobody is there
+	if null, err := os.Stat(os.DevNull); err == nil && os.SameFile(info, null) {
+		return false
+	}
+	return true
+}
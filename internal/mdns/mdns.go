@@ -0,0 +1,332 @@
+// Package mdns advertises services on the local network with multicast DNS
+// (RFC 6762) and DNS service discovery (RFC 6763). It only answers queries
+// for the services it advertises; it is not a resolver.
+package mdns
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+)
+
+const (
+	typePTR = 12
+	typeTXT = 16
+	typeSRV = 33
+	typeANY = 255
+
+	classIN = 1
+	// cacheFlush marks records this responder is the only source of
+	cacheFlush = 0x8000
+
+	// ttl is how long (in seconds) peers may cache the advertised records
+	ttl = 120
+
+	// servicesName lists the advertised service types for DNS-SD browsers
+	servicesName = "_services._dns-sd._udp.local."
+)
+
+var groupAddr = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+// Service is an advertised DNS-SD service instance
+type Service struct {
+	Instance string   // Human-readable instance name, e.g. "skyport: myapp"
+	Type     string   // Service type, e.g. "_http._tcp"
+	Host     string   // Host clients connect to
+	Port     int      // Port clients connect to
+	Text     []string // TXT record entries, e.g. "path=/"
+}
+
+func (s Service) typeName() string {
+	return s.Type + ".local."
+}
+
+func (s Service) instanceName() string {
+	// Dots inside the instance label must not split it into several labels
+	return strings.ReplaceAll(s.Instance, ".", "\\.") + "." + s.typeName()
+}
+
+// Responder answers mDNS queries for a set of services
+type Responder struct {
+	conn     *net.UDPConn
+	mu       sync.Mutex
+	services []Service
+	closed   bool
+}
+
+// NewResponder joins the mDNS multicast group and starts answering queries
+func NewResponder() (*Responder, error) {
+	conn, err := net.ListenMulticastUDP("udp4", nil, groupAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to join mDNS group: %w", err)
+	}
+
+	r := &Responder{conn: conn}
+	go r.serve()
+	return r, nil
+}
+
+// SetServices replaces the advertised services, announcing new ones and
+// withdrawing the ones that are gone
+func (r *Responder) SetServices(services []Service) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return
+	}
+
+	var removed []Service
+	for _, old := range r.services {
+		if !containsService(services, old) {
+			removed = append(removed, old)
+		}
+	}
+	r.services = services
+
+	if len(removed) > 0 {
+		r.send(encodeResponse(0, removed, 0), groupAddr)
+	}
+	if len(services) > 0 {
+		r.send(encodeResponse(0, services, ttl), groupAddr)
+	}
+}
+
+// Close withdraws all services and leaves the multicast group
+func (r *Responder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+
+	if len(r.services) > 0 {
+		r.send(encodeResponse(0, r.services, 0), groupAddr)
+	}
+	return r.conn.Close()
+}
+
+func (r *Responder) serve() {
+	buf := make([]byte, 9000)
+	for {
+		n, from, err := r.conn.ReadFromUDP(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+
+		id, questions, err := parseQuery(buf[:n])
+		if err != nil {
+			continue
+		}
+
+		r.mu.Lock()
+		matched := r.match(questions)
+		if len(matched) > 0 {
+			if from.Port != groupAddr.Port {
+				// Legacy unicast query (RFC 6762 section 6.7): answer the
+				// sender directly, echoing its ID, with a short TTL
+				r.send(encodeResponse(id, matched, 10), from)
+			} else {
+				r.send(encodeResponse(0, matched, ttl), groupAddr)
+			}
+		}
+		r.mu.Unlock()
+	}
+}
+
+// match returns the services a query asks about
+func (r *Responder) match(questions []question) []Service {
+	var matched []Service
+	for _, service := range r.services {
+		for _, q := range questions {
+			if q.qtype != typePTR && q.qtype != typeSRV && q.qtype != typeTXT && q.qtype != typeANY {
+				continue
+			}
+			if strings.EqualFold(q.name, service.typeName()) ||
+				strings.EqualFold(q.name, service.instanceName()) ||
+				strings.EqualFold(q.name, servicesName) {
+				matched = append(matched, service)
+				break
+			}
+		}
+	}
+	return matched
+}
+
+func (r *Responder) send(packet []byte, to *net.UDPAddr) {
+	r.conn.WriteToUDP(packet, to)
+}
+
+func containsService(services []Service, s Service) bool {
+	for _, other := range services {
+		if other.instanceName() == s.instanceName() && other.Host == s.Host && other.Port == s.Port {
+			return true
+		}
+	}
+	return false
+}
+
+type question struct {
+	name  string
+	qtype uint16
+}
+
+// parseQuery decodes the questions of an mDNS query; responses are rejected
+func parseQuery(msg []byte) (uint16, []question, error) {
+	if len(msg) < 12 {
+		return 0, nil, errors.New("short message")
+	}
+	id := binary.BigEndian.Uint16(msg[0:2])
+	flags := binary.BigEndian.Uint16(msg[2:4])
+	if flags&0x8000 != 0 {
+		return 0, nil, errors.New("not a query")
+	}
+
+	count := int(binary.BigEndian.Uint16(msg[4:6]))
+	offset := 12
+	questions := make([]question, 0, count)
+	for i := 0; i < count; i++ {
+		name, next, err := readName(msg, offset)
+		if err != nil {
+			return 0, nil, err
+		}
+		if next+4 > len(msg) {
+			return 0, nil, errors.New("truncated question")
+		}
+		questions = append(questions, question{name: name, qtype: binary.BigEndian.Uint16(msg[next : next+2])})
+		offset = next + 4
+	}
+	return id, questions, nil
+}
+
+// readName decodes a possibly compressed domain name at offset, returning it
+// with a trailing dot and the offset just past it
+func readName(msg []byte, offset int) (string, int, error) {
+	var labels []string
+	end := -1
+	for jumps := 0; ; {
+		if offset >= len(msg) {
+			return "", 0, errors.New("name out of bounds")
+		}
+		length := int(msg[offset])
+		switch {
+		case length == 0:
+			if end < 0 {
+				end = offset + 1
+			}
+			return strings.Join(labels, ".") + ".", end, nil
+		case length&0xC0 == 0xC0:
+			if offset+1 >= len(msg) || jumps > 10 {
+				return "", 0, errors.New("bad compression pointer")
+			}
+			if end < 0 {
+				end = offset + 2
+			}
+			offset = int(binary.BigEndian.Uint16(msg[offset:offset+2]) & 0x3FFF)
+			jumps++
+		default:
+			if offset+1+length > len(msg) {
+				return "", 0, errors.New("label out of bounds")
+			}
+			label := string(msg[offset+1 : offset+1+length])
+			labels = append(labels, strings.ReplaceAll(label, ".", "\\."))
+			offset += 1 + length
+		}
+	}
+}
+
+// encodeResponse builds a response carrying the PTR, SRV and TXT records of
+// the services. A zero recordTTL withdraws them.
+func encodeResponse(id uint16, services []Service, recordTTL uint32) []byte {
+	msg := make([]byte, 12)
+	binary.BigEndian.PutUint16(msg[0:2], id)
+	binary.BigEndian.PutUint16(msg[2:4], 0x8400) // Response, authoritative
+
+	answers := 0
+	seenTypes := make(map[string]bool)
+	for _, service := range services {
+		if !seenTypes[service.typeName()] {
+			seenTypes[service.typeName()] = true
+			msg = appendRecord(msg, servicesName, typePTR, classIN, recordTTL, appendName(nil, service.typeName()))
+			answers++
+		}
+
+		msg = appendRecord(msg, service.typeName(), typePTR, classIN, recordTTL, appendName(nil, service.instanceName()))
+
+		srv := make([]byte, 6)
+		binary.BigEndian.PutUint16(srv[4:6], uint16(service.Port))
+		srv = appendName(srv, strings.TrimSuffix(service.Host, ".")+".")
+		msg = appendRecord(msg, service.instanceName(), typeSRV, classIN|cacheFlush, recordTTL, srv)
+
+		var txt []byte
+		for _, entry := range service.Text {
+			if len(entry) > 255 {
+				entry = entry[:255]
+			}
+			txt = append(txt, byte(len(entry)))
+			txt = append(txt, entry...)
+		}
+		if len(txt) == 0 {
+			txt = []byte{0}
+		}
+		msg = appendRecord(msg, service.instanceName(), typeTXT, classIN|cacheFlush, recordTTL, txt)
+		answers += 3
+	}
+
+	binary.BigEndian.PutUint16(msg[6:8], uint16(answers))
+	return msg
+}
+
+func appendRecord(msg []byte, name string, rtype, class uint16, recordTTL uint32, data []byte) []byte {
+	msg = appendName(msg, name)
+	var fixed [10]byte
+	binary.BigEndian.PutUint16(fixed[0:2], rtype)
+	binary.BigEndian.PutUint16(fixed[2:4], class)
+	binary.BigEndian.PutUint32(fixed[4:8], recordTTL)
+	binary.BigEndian.PutUint16(fixed[8:10], uint16(len(data)))
+	msg = append(msg, fixed[:]...)
+	return append(msg, data...)
+}
+
+// appendName encodes a dotted name (with "\." for dots inside labels) as DNS labels
+func appendName(msg []byte, name string) []byte {
+	for _, label := range splitLabels(name) {
+		if len(label) > 63 {
+			label = label[:63]
+		}
+		msg = append(msg, byte(len(label)))
+		msg = append(msg, label...)
+	}
+	return append(msg, 0)
+}
+
+func splitLabels(name string) []string {
+	var labels []string
+	var current strings.Builder
+	for i := 0; i < len(name); i++ {
+		switch {
+		case name[i] == '\\' && i+1 < len(name) && name[i+1] == '.':
+			current.WriteByte('.')
+			i++
+		case name[i] == '.':
+			if current.Len() > 0 {
+				labels = append(labels, current.String())
+			}
+			current.Reset()
+		default:
+			current.WriteByte(name[i])
+		}
+	}
+	if current.Len() > 0 {
+		labels = append(labels, current.String())
+	}
+	return labels
+}
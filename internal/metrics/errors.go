@@ -0,0 +1,122 @@
+package metrics
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// errorWindow is how far back error rates are computed
+	errorWindow = 15 * time.Minute
+	// errorBucket is the granularity of the rolling window
+	errorBucket = time.Minute
+	// maxErrorSamples is how many recent error messages are kept
+	maxErrorSamples = 10
+)
+
+// ErrorSample is one failed request
+type ErrorSample struct {
+	Time      time.Time `json:"time"`
+	RequestID string    `json:"request_id,omitempty"`
+	Method    string    `json:"method"`
+	Path      string    `json:"path"`
+	Status    int       `json:"status"`
+	Message   string    `json:"message,omitempty"`
+}
+
+// ErrorSummary reports error counts over the rolling window plus recent samples
+type ErrorSummary struct {
+	Window       time.Duration `json:"window"`
+	Requests     int           `json:"requests"`
+	ServerErrors int           `json:"server_errors"` // Any 5xx, including 502s
+	BadGateway   int           `json:"bad_gateway"`   // 502s: the local service was unreachable or failed
+	Recent       []ErrorSample `json:"recent,omitempty"`
+}
+
+// ErrorRate returns the fraction of requests that were 5xx
+func (s ErrorSummary) ErrorRate() float64 {
+	if s.Requests == 0 {
+		return 0
+	}
+	return float64(s.ServerErrors) / float64(s.Requests)
+}
+
+type errorCounts struct {
+	start        time.Time
+	requests     int
+	serverErrors int
+	badGateway   int
+}
+
+// ErrorTracker keeps rolling request and error counts in one-minute buckets
+type ErrorTracker struct {
+	mu      sync.Mutex
+	buckets []errorCounts
+	recent  []ErrorSample
+}
+
+// NewErrorTracker creates an empty tracker
+func NewErrorTracker() *ErrorTracker {
+	return &ErrorTracker{buckets: make([]errorCounts, int(errorWindow/errorBucket))}
+}
+
+// Record counts a completed request. message describes the failure for 5xx responses.
+func (et *ErrorTracker) Record(requestID, method, path string, status int, message string) {
+	now := time.Now()
+
+	et.mu.Lock()
+	defer et.mu.Unlock()
+
+	bucket := et.bucket(now)
+	bucket.requests++
+	if status < http.StatusInternalServerError {
+		return
+	}
+
+	bucket.serverErrors++
+	if status == http.StatusBadGateway {
+		bucket.badGateway++
+	}
+
+	if message == "" {
+		message = http.StatusText(status)
+	}
+	et.recent = append(et.recent, ErrorSample{Time: now, RequestID: requestID, Method: method, Path: path, Status: status, Message: message})
+	if len(et.recent) > maxErrorSamples {
+		et.recent = et.recent[len(et.recent)-maxErrorSamples:]
+	}
+}
+
+// bucket returns the bucket for t, resetting it if it holds an older minute;
+// must be called with the lock held
+func (et *ErrorTracker) bucket(t time.Time) *errorCounts {
+	start := t.Truncate(errorBucket)
+	b := &et.buckets[int(start.Unix()/int64(errorBucket/time.Second))%len(et.buckets)]
+	if !b.start.Equal(start) {
+		*b = errorCounts{start: start}
+	}
+	return b
+}
+
+// Summary returns counts over the window and the recent error samples, newest first
+func (et *ErrorTracker) Summary() ErrorSummary {
+	cutoff := time.Now().Add(-errorWindow)
+
+	et.mu.Lock()
+	defer et.mu.Unlock()
+
+	summary := ErrorSummary{Window: errorWindow}
+	for _, b := range et.buckets {
+		if b.start.After(cutoff) {
+			summary.Requests += b.requests
+			summary.ServerErrors += b.serverErrors
+			summary.BadGateway += b.badGateway
+		}
+	}
+
+	for i := len(et.recent) - 1; i >= 0; i-- {
+		summary.Recent = append(summary.Recent, et.recent[i])
+	}
+	return summary
+}
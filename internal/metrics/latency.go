@@ -0,0 +1,116 @@
+package metrics
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// latencyWindow is how many recent samples percentiles are computed over
+const latencyWindow = 512
+
+// Percentiles summarises recent latency samples
+type Percentiles struct {
+	Count int           `json:"count"`
+	P50   time.Duration `json:"p50"`
+	P95   time.Duration `json:"p95"`
+	P99   time.Duration `json:"p99"`
+	Mean  time.Duration `json:"mean"`
+	Min   time.Duration `json:"min"`
+	Max   time.Duration `json:"max"`
+}
+
+// String formats the percentiles as "p50/p95/p99", or "-" with no samples
+func (p Percentiles) String() string {
+	if p.Count == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%s/%s/%s", formatLatency(p.P50), formatLatency(p.P95), formatLatency(p.P99))
+}
+
+// Range formats the samples as "avg/min/max", or "-" with no samples
+func (p Percentiles) Range() string {
+	if p.Count == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%s/%s/%s", formatLatency(p.Mean), formatLatency(p.Min), formatLatency(p.Max))
+}
+
+func formatLatency(d time.Duration) string {
+	switch {
+	case d < time.Millisecond:
+		return d.Round(time.Microsecond).String()
+	case d < time.Second:
+		return d.Round(100 * time.Microsecond).String()
+	default:
+		return d.Round(10 * time.Millisecond).String()
+	}
+}
+
+// LatencyTracker keeps the most recent latency samples for percentile queries
+type LatencyTracker struct {
+	mu      sync.Mutex
+	samples []time.Duration
+	next    int
+	full    bool
+}
+
+// NewLatencyTracker creates an empty tracker
+func NewLatencyTracker() *LatencyTracker {
+	return &LatencyTracker{samples: make([]time.Duration, latencyWindow)}
+}
+
+// Record adds a sample, evicting the oldest once the window is full
+func (lt *LatencyTracker) Record(d time.Duration) {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+
+	lt.samples[lt.next] = d
+	lt.next = (lt.next + 1) % len(lt.samples)
+	if lt.next == 0 {
+		lt.full = true
+	}
+}
+
+// Percentiles returns p50/p95/p99, the mean, min and max over the current
+// window
+func (lt *LatencyTracker) Percentiles() Percentiles {
+	lt.mu.Lock()
+	n := lt.next
+	if lt.full {
+		n = len(lt.samples)
+	}
+	sorted := make([]time.Duration, n)
+	copy(sorted, lt.samples[:n])
+	lt.mu.Unlock()
+
+	if n == 0 {
+		return Percentiles{}
+	}
+
+	var total time.Duration
+	for _, d := range sorted {
+		total += d
+	}
+
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return Percentiles{
+		Count: n,
+		P50:   percentile(sorted, 50),
+		P95:   percentile(sorted, 95),
+		P99:   percentile(sorted, 99),
+		Mean:  total / time.Duration(n),
+		Min:   sorted[0],
+		Max:   sorted[n-1],
+	}
+}
+
+// percentile uses the nearest-rank method on sorted samples
+func percentile(sorted []time.Duration, p int) time.Duration {
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
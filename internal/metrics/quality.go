@@ -0,0 +1,228 @@
+package metrics
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// qualityWindow is how far back connection quality is judged
+	qualityWindow = 5 * time.Minute
+	// maxQualityEvents bounds the heartbeats and stalls kept for the window
+	maxQualityEvents = 256
+	// minQualitySamples is how many heartbeats are needed to grade a connection
+	minQualitySamples = 3
+)
+
+// Connection quality grades
+const (
+	QualityUnknown = "unknown"
+	QualityGood    = "good"
+	QualityFair    = "fair"
+	QualityPoor    = "poor"
+)
+
+// Thresholds between the grades. A connection is graded by its worst signal.
+const (
+	goodRoundTrip = 150 * time.Millisecond
+	fairRoundTrip = 400 * time.Millisecond
+	goodJitter    = 50 * time.Millisecond
+	fairJitter    = 150 * time.Millisecond
+	fairLossRate  = 0.05
+)
+
+// ConnectionQuality summarises the health of a tunnel's connection to the
+// server over the last few minutes
+type ConnectionQuality struct {
+	Grade     string        `json:"grade"`
+	Reason    string        `json:"reason,omitempty"`
+	RoundTrip time.Duration `json:"round_trip"` // Median heartbeat RTT
+	Jitter    time.Duration `json:"jitter"`     // Mean change between consecutive RTTs
+	// Connect is the TCP handshake time of the current connection, a
+	// baseline for the network path that excludes the server's own delays
+	Connect    time.Duration `json:"connect,omitempty"`
+	Heartbeats int           `json:"heartbeats"`
+	Lost       int           `json:"lost_heartbeats"` // Not answered before the next heartbeat
+	Stalls     int           `json:"write_stalls"`    // Writes to the server that blocked
+}
+
+// String formats the grade with its reason, e.g. "fair: jitter 80ms"
+func (q ConnectionQuality) String() string {
+	if q.Grade == "" {
+		return QualityUnknown
+	}
+	if q.Reason == "" {
+		return q.Grade
+	}
+	return fmt.Sprintf("%s: %s", q.Grade, q.Reason)
+}
+
+type heartbeat struct {
+	at   time.Time
+	rtt  time.Duration
+	lost bool
+}
+
+// QualityTracker grades a connection from heartbeat round trips, heartbeats
+// that went unanswered and writes that stalled
+type QualityTracker struct {
+	mu          sync.Mutex
+	heartbeats  []heartbeat
+	stalls      []time.Time
+	outstanding bool
+	connect     time.Duration
+}
+
+// NewQualityTracker creates an empty tracker
+func NewQualityTracker() *QualityTracker {
+	return &QualityTracker{}
+}
+
+// Connected starts tracking a new connection whose TCP handshake took
+// connect (zero if unknown)
+func (qt *QualityTracker) Connected(connect time.Duration) {
+	qt.mu.Lock()
+	defer qt.mu.Unlock()
+
+	qt.connect = connect
+	qt.outstanding = false
+}
+
+// PingSent records a heartbeat. If the previous one is still unanswered, it
+// is counted as lost and PingSent returns true.
+func (qt *QualityTracker) PingSent() bool {
+	qt.mu.Lock()
+	defer qt.mu.Unlock()
+
+	lost := qt.outstanding
+	if lost {
+		qt.addHeartbeat(heartbeat{at: time.Now(), lost: true})
+	}
+	qt.outstanding = true
+	return lost
+}
+
+// PongReceived records a heartbeat's round trip time
+func (qt *QualityTracker) PongReceived(rtt time.Duration) {
+	qt.mu.Lock()
+	defer qt.mu.Unlock()
+
+	qt.outstanding = false
+	qt.addHeartbeat(heartbeat{at: time.Now(), rtt: rtt})
+}
+
+// Stalled records a write to the server that blocked
+func (qt *QualityTracker) Stalled() {
+	qt.mu.Lock()
+	defer qt.mu.Unlock()
+
+	if len(qt.stalls) == maxQualityEvents {
+		qt.stalls = append(qt.stalls[:0], qt.stalls[1:]...)
+	}
+	qt.stalls = append(qt.stalls, time.Now())
+}
+
+// Quality grades the connection over the recent window
+func (qt *QualityTracker) Quality() ConnectionQuality {
+	cutoff := time.Now().Add(-qualityWindow)
+
+	qt.mu.Lock()
+	var rtts []time.Duration
+	q := ConnectionQuality{Connect: qt.connect}
+	for _, hb := range qt.heartbeats {
+		if hb.at.Before(cutoff) {
+			continue
+		}
+		q.Heartbeats++
+		if hb.lost {
+			q.Lost++
+		} else {
+			rtts = append(rtts, hb.rtt)
+		}
+	}
+	for _, at := range qt.stalls {
+		if !at.Before(cutoff) {
+			q.Stalls++
+		}
+	}
+	qt.mu.Unlock()
+
+	// Jitter is taken in arrival order, before sorting for the median
+	for i := 1; i < len(rtts); i++ {
+		q.Jitter += absDuration(rtts[i] - rtts[i-1])
+	}
+	if len(rtts) > 1 {
+		q.Jitter /= time.Duration(len(rtts) - 1)
+	}
+	if len(rtts) > 0 {
+		sort.Slice(rtts, func(i, j int) bool { return rtts[i] < rtts[j] })
+		q.RoundTrip = percentile(rtts, 50)
+	}
+
+	q.Grade, q.Reason = q.grade()
+	return q
+}
+
+// grade returns the worst grade any signal earns, with the signals that
+// earned it
+func (q ConnectionQuality) grade() (string, string) {
+	if q.Heartbeats < minQualitySamples {
+		return QualityUnknown, ""
+	}
+
+	var poor, fair []string
+	add := func(value, fairAt, poorAt float64, reason string) {
+		switch {
+		case value >= poorAt:
+			poor = append(poor, reason)
+		case value >= fairAt:
+			fair = append(fair, reason)
+		}
+	}
+
+	lossRate := float64(q.Lost) / float64(q.Heartbeats)
+	add(lossRate, fairLossRate/2, fairLossRate, fmt.Sprintf("%d of %d heartbeats lost", q.Lost, q.Heartbeats))
+	add(float64(q.Stalls), 1, 3, fmt.Sprintf("%d write stalls", q.Stalls))
+	add(float64(q.Jitter), float64(goodJitter), float64(fairJitter), "jitter "+formatLatency(q.Jitter))
+	add(float64(q.RoundTrip), float64(goodRoundTrip), float64(fairRoundTrip), q.roundTripReason())
+
+	switch {
+	case len(poor) > 0:
+		return QualityPoor, strings.Join(poor, ", ")
+	case len(fair) > 0:
+		return QualityFair, strings.Join(fair, ", ")
+	default:
+		return QualityGood, ""
+	}
+}
+
+// roundTripReason describes a slow round trip, attributing it to the server
+// when the TCP handshake (which the server's application never sees) was fast
+func (q ConnectionQuality) roundTripReason() string {
+	reason := "RTT " + formatLatency(q.RoundTrip)
+	if q.Connect > 0 && q.Connect < q.RoundTrip/3 {
+		return reason + " from a slow server (TCP connect " + formatLatency(q.Connect) + ")"
+	}
+	if q.Connect > 0 {
+		return reason + " over a slow network (TCP connect " + formatLatency(q.Connect) + ")"
+	}
+	return reason
+}
+
+// addHeartbeat records a heartbeat, dropping the oldest once the limit is reached
+func (qt *QualityTracker) addHeartbeat(hb heartbeat) {
+	if len(qt.heartbeats) == maxQualityEvents {
+		qt.heartbeats = append(qt.heartbeats[:0], qt.heartbeats[1:]...)
+	}
+	qt.heartbeats = append(qt.heartbeats, hb)
+}
+
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
@@ -2,32 +2,42 @@ package network
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net"
-	"net/http"
 	"skyport-agent/internal/config"
 	"time"
 )
 
+var (
+	// ErrNoInternet means none of the connectivity targets could be reached
+	ErrNoInternet = errors.New("no internet connection")
+	// ErrServerUnreachable means the internet works but the SkyPort server does not answer
+	ErrServerUnreachable = errors.New("SkyPort server is not reachable")
+)
+
 // CheckConnectivity verifies if the agent can reach the SkyPort server
 func CheckConnectivity(cfg *config.Config) error {
 	// First check basic internet connectivity
-	if err := checkInternetConnection(); err != nil {
-		return fmt.Errorf("no internet connection")
+	if err := CheckTargets(cfg.ConnectivityTargets(), 3*time.Second); err != nil {
+		return fmt.Errorf("%w: %v", ErrNoInternet, err)
 	}
 
 	// Then check if we can reach the SkyPort server
-	if err := checkServerReachability(cfg.ServerURL); err != nil {
-		return fmt.Errorf("SkyPort server is not reachable")
+	if err := checkServerReachability(cfg); err != nil {
+		return fmt.Errorf("%w: %v", ErrServerUnreachable, err)
 	}
 
 	return nil
 }
 
-// checkInternetConnection does a quick DNS lookup to verify internet connectivity
-func checkInternetConnection() error {
-	// Try to resolve a well-known domain
-	timeout := 3 * time.Second
+// CheckTargets verifies connectivity to at least one of the given host:port
+// targets. Hostnames only need to resolve (a cheap DNS round trip); IP
+// literals are dialed over TCP since resolving them proves nothing.
+func CheckTargets(targets []string, timeout time.Duration) error {
+	if len(targets) == 0 {
+		return fmt.Errorf("no connectivity targets configured")
+	}
 
 	// Use custom resolver with timeout
 	resolver := &net.Resolver{
@@ -43,27 +53,35 @@ func checkInternetConnection() error {
 	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
 
-	// Try multiple reliable DNS targets
-	targets := []string{"google.com", "github.com", "1.1.1.1"}
-
 	for _, target := range targets {
-		_, err := resolver.LookupHost(ctx, target)
-		if err == nil {
-			return nil // Successfully resolved, internet is working
+		host, _, err := net.SplitHostPort(target)
+		if err != nil {
+			continue
+		}
+
+		if net.ParseIP(host) != nil {
+			conn, err := net.DialTimeout("tcp", target, timeout)
+			if err == nil {
+				conn.Close()
+				return nil
+			}
+			continue
+		}
+
+		if _, err := resolver.LookupHost(ctx, host); err == nil {
+			return nil // Successfully resolved, network is working
 		}
 	}
 
-	return fmt.Errorf("unable to resolve DNS - check your internet connection")
+	return fmt.Errorf("unable to reach any connectivity target - check your internet connection")
 }
 
 // checkServerReachability verifies the SkyPort server is accessible
-func checkServerReachability(serverURL string) error {
-	client := &http.Client{
-		Timeout: 5 * time.Second,
-	}
+func checkServerReachability(cfg *config.Config) error {
+	client := cfg.HTTPClient(5 * time.Second)
 
 	// Try to reach the server (any endpoint, we just want to know it's up)
-	resp, err := client.Get(serverURL)
+	resp, err := client.Get(cfg.ServerURL)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,111 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/smtp"
+	"skyport-agent/internal/config"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var httpClient = &http.Client{Timeout: 10 * time.Second}
+
+// postJSON posts a JSON payload and treats any non-2xx response as an error
+func postJSON(url string, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal payload: %w", err)
+	}
+
+	resp, err := httpClient.Post(url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	return nil
+}
+
+// webhookNotifier posts the raw alert as JSON, plus the rendered text
+type webhookNotifier struct {
+	name string
+	url  string
+}
+
+func (n *webhookNotifier) Name() string { return n.name }
+
+func (n *webhookNotifier) Send(alert Alert, text string) error {
+	return postJSON(n.url, struct {
+		Alert
+		Text string `json:"text"`
+	}{alert, text})
+}
+
+// slackNotifier posts to a Slack incoming webhook
+type slackNotifier struct {
+	name string
+	url  string
+}
+
+func (n *slackNotifier) Name() string { return n.name }
+
+func (n *slackNotifier) Send(alert Alert, text string) error {
+	return postJSON(n.url, map[string]string{"text": text})
+}
+
+// discordNotifier posts to a Discord channel webhook
+type discordNotifier struct {
+	name string
+	url  string
+}
+
+func (n *discordNotifier) Name() string { return n.name }
+
+func (n *discordNotifier) Send(alert Alert, text string) error {
+	// Discord rejects messages over 2000 characters
+	if len(text) > 2000 {
+		text = text[:1997] + "..."
+	}
+	return postJSON(n.url, map[string]string{"content": text})
+}
+
+// emailNotifier sends alerts over SMTP (STARTTLS is used when offered)
+type emailNotifier struct {
+	config config.NotifierConfig
+}
+
+func (n *emailNotifier) Name() string { return n.config.Name }
+
+func (n *emailNotifier) Send(alert Alert, text string) error {
+	addr := net.JoinHostPort(n.config.SMTPHost, strconv.Itoa(n.config.SMTPPort))
+
+	var auth smtp.Auth
+	if n.config.Username != "" {
+		auth = smtp.PlainAuth("", n.config.Username, n.config.Password, n.config.SMTPHost)
+	}
+
+	subject := "[SkyPort] " + alert.Title
+	if alert.Tunnel != "" {
+		subject += " (" + alert.Tunnel + ")"
+	}
+
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "From: %s\r\n", n.config.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(n.config.To, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", subject)
+	fmt.Fprintf(&msg, "Date: %s\r\n", alert.Time.Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(text)
+	msg.WriteString("\r\n")
+
+	return smtp.SendMail(addr, auth, n.config.From, n.config.To, []byte(msg.String()))
+}
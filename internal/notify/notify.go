@@ -0,0 +1,187 @@
+package notify
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"skyport-agent/internal/config"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// Alert events
+const (
+	EventTunnelDisconnected = "tunnel_disconnected"
+	EventTunnelReconnected  = "tunnel_reconnected"
+	EventTunnelNotServing   = "tunnel_not_serving"
+	EventLocalServiceDown   = "local_service_down"
+	EventTunnelIdle         = "tunnel_idle"
+	EventTunnelExpired      = "tunnel_expired"
+	EventTunnelDeleted      = "tunnel_deleted"
+	EventTunnelTakenOver    = "tunnel_taken_over"
+	EventTest               = "test"
+)
+
+// DefaultTemplate is used when no template is configured
+const DefaultTemplate = `[SkyPort] {{.Title}}{{if .Tunnel}} ({{.Tunnel}}){{end}} on {{.Host}}: {{.Message}}` +
+	`{{if .Suppressed}} ({{.Suppressed}} similar alerts suppressed){{end}}`
+
+// Alert is a single notification. All fields are available to templates.
+type Alert struct {
+	Event      string    `json:"event"`
+	Tunnel     string    `json:"tunnel,omitempty"`
+	Title      string    `json:"title"`
+	Message    string    `json:"message"`
+	Host       string    `json:"host"`
+	Time       time.Time `json:"time"`
+	Suppressed int       `json:"suppressed,omitempty"` // Repeats dropped by rate limiting since the last delivery
+}
+
+// Notifier delivers a rendered alert to one destination
+type Notifier interface {
+	Name() string
+	Send(alert Alert, text string) error
+}
+
+// Dispatcher renders alerts and fans them out to the configured notifiers,
+// rate limiting repeats of the same alert
+type Dispatcher struct {
+	notifiers []Notifier
+	templates map[string]*template.Template // By notifier name
+	repeat    time.Duration
+	events    map[string]bool
+
+	mu         sync.Mutex
+	lastSent   map[string]time.Time
+	suppressed map[string]int
+}
+
+// NewDispatcher builds a dispatcher from the notification settings
+func NewDispatcher(settings config.NotificationSettings) (*Dispatcher, error) {
+	d := &Dispatcher{
+		templates:  make(map[string]*template.Template),
+		repeat:     settings.RepeatInterval.Duration,
+		lastSent:   make(map[string]time.Time),
+		suppressed: make(map[string]int),
+	}
+
+	if len(settings.Events) > 0 {
+		d.events = make(map[string]bool)
+		for _, event := range settings.Events {
+			d.events[event] = true
+		}
+	}
+
+	globalTemplate := settings.Template
+	if globalTemplate == "" {
+		globalTemplate = DefaultTemplate
+	}
+
+	for _, cfg := range settings.Notifiers {
+		notifier, err := newNotifier(cfg)
+		if err != nil {
+			return nil, err
+		}
+
+		text := cfg.Template
+		if text == "" {
+			text = globalTemplate
+		}
+		tmpl, err := template.New(cfg.Name).Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("notifier %q: invalid template: %w", cfg.Name, err)
+		}
+
+		d.notifiers = append(d.notifiers, notifier)
+		d.templates[notifier.Name()] = tmpl
+	}
+
+	return d, nil
+}
+
+// newNotifier creates the notifier for a config entry
+func newNotifier(cfg config.NotifierConfig) (Notifier, error) {
+	switch cfg.Type {
+	case "webhook":
+		return &webhookNotifier{name: cfg.Name, url: cfg.URL}, nil
+	case "slack":
+		return &slackNotifier{name: cfg.Name, url: cfg.URL}, nil
+	case "discord":
+		return &discordNotifier{name: cfg.Name, url: cfg.URL}, nil
+	case "email":
+		return &emailNotifier{config: cfg}, nil
+	default:
+		return nil, fmt.Errorf("notifier %q: unknown type %q", cfg.Name, cfg.Type)
+	}
+}
+
+// Enabled reports whether any notifiers are configured
+func (d *Dispatcher) Enabled() bool {
+	return d != nil && len(d.notifiers) > 0
+}
+
+// Notify sends an alert in the background. Repeats of the same event for the
+// same tunnel within the repeat interval are counted but not sent.
+func (d *Dispatcher) Notify(alert Alert) {
+	if !d.Enabled() {
+		return
+	}
+	if d.events != nil && !d.events[alert.Event] {
+		return
+	}
+
+	key := alert.Event + "|" + alert.Tunnel
+
+	d.mu.Lock()
+	if last, ok := d.lastSent[key]; ok && time.Since(last) < d.repeat {
+		d.suppressed[key]++
+		d.mu.Unlock()
+		return
+	}
+	d.lastSent[key] = time.Now()
+	alert.Suppressed = d.suppressed[key]
+	delete(d.suppressed, key)
+	d.mu.Unlock()
+
+	go func() {
+		for name, err := range d.send(alert) {
+			if err != nil {
+				log.Printf("Failed to send %s alert via %s: %v", alert.Event, name, err)
+			}
+		}
+	}()
+}
+
+// SendTest sends a test alert to every notifier synchronously, bypassing rate
+// limiting and event filters, and returns the result per notifier
+func (d *Dispatcher) SendTest() map[string]error {
+	return d.send(Alert{
+		Event:   EventTest,
+		Title:   "Test alert",
+		Message: "If you can read this, SkyPort alerts are delivered to this channel.",
+	})
+}
+
+// send renders and delivers an alert to all notifiers
+func (d *Dispatcher) send(alert Alert) map[string]error {
+	if alert.Time.IsZero() {
+		alert.Time = time.Now()
+	}
+	if alert.Host == "" {
+		alert.Host, _ = os.Hostname()
+	}
+
+	results := make(map[string]error)
+	for _, notifier := range d.notifiers {
+		var buf bytes.Buffer
+		if err := d.templates[notifier.Name()].Execute(&buf, alert); err != nil {
+			results[notifier.Name()] = fmt.Errorf("failed to render template: %w", err)
+			continue
+		}
+		results[notifier.Name()] = notifier.Send(alert, strings.TrimSpace(buf.String()))
+	}
+	return results
+}
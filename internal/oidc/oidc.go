@@ -0,0 +1,267 @@
+// Package oidc implements the parts of the OpenID Connect authorization code
+// flow the agent needs to put a login in front of a tunnel: provider
+// discovery, PKCE, code exchange and the userinfo lookup, plus HMAC-signed
+// values for session cookies.
+package oidc
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// discoveryPath is appended to the issuer URL to find the provider's endpoints
+const discoveryPath = "/.well-known/openid-configuration"
+
+// ErrInvalidValue is returned by Verify for values that were not signed with
+// the key, are malformed or have expired
+var ErrInvalidValue = errors.New("invalid or expired signed value")
+
+// UserInfo is the subset of the userinfo response the agent uses
+type UserInfo struct {
+	Subject       string `json:"sub"`
+	Email         string `json:"email"`
+	EmailVerified *bool  `json:"email_verified,omitempty"`
+	Name          string `json:"name,omitempty"`
+}
+
+// endpoints are the provider URLs read from its discovery document
+type endpoints struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint"`
+}
+
+// Provider is an OpenID Connect identity provider and the client registered
+// with it. Endpoints are discovered on first use.
+type Provider struct {
+	issuer       string
+	clientID     string
+	clientSecret string
+	scopes       []string
+	client       *http.Client
+
+	mu        sync.Mutex
+	endpoints *endpoints
+}
+
+// NewProvider creates a provider for an issuer URL such as
+// https://accounts.google.com
+func NewProvider(issuer, clientID, clientSecret string, scopes []string) *Provider {
+	return &Provider{
+		issuer:       strings.TrimSuffix(issuer, "/"),
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		scopes:       scopes,
+		client:       &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// discover fetches the provider's discovery document, caching it once it has
+// been read successfully
+func (p *Provider) discover(ctx context.Context) (*endpoints, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.endpoints != nil {
+		return p.endpoints, nil
+	}
+
+	var doc endpoints
+	if err := p.getJSON(ctx, p.issuer+discoveryPath, "", &doc); err != nil {
+		return nil, fmt.Errorf("failed to discover provider endpoints: %w", err)
+	}
+	if strings.TrimSuffix(doc.Issuer, "/") != p.issuer {
+		return nil, fmt.Errorf("provider reports issuer %q, expected %q", doc.Issuer, p.issuer)
+	}
+	if doc.AuthorizationEndpoint == "" || doc.TokenEndpoint == "" || doc.UserinfoEndpoint == "" {
+		return nil, fmt.Errorf("provider discovery document is missing endpoints")
+	}
+
+	p.endpoints = &doc
+	return p.endpoints, nil
+}
+
+// AuthCodeURL returns the URL to send a visitor to for login. The verifier
+// must be kept (e.g. in a signed cookie) and passed to Exchange.
+func (p *Provider) AuthCodeURL(ctx context.Context, redirectURI, state, verifier string) (string, error) {
+	ep, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	params := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.clientID},
+		"redirect_uri":          {redirectURI},
+		"scope":                 {strings.Join(p.scopes, " ")},
+		"state":                 {state},
+		"code_challenge":        {challenge(verifier)},
+		"code_challenge_method": {"S256"},
+	}
+
+	separator := "?"
+	if strings.Contains(ep.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return ep.AuthorizationEndpoint + separator + params.Encode(), nil
+}
+
+// Exchange trades an authorization code for an access token
+func (p *Provider) Exchange(ctx context.Context, code, redirectURI, verifier string) (string, error) {
+	ep, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURI},
+		"client_id":     {p.clientID},
+		"code_verifier": {verifier},
+	}
+	if p.clientSecret != "" {
+		form.Set("client_secret", p.clientSecret)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, ep.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("failed to create token request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+		TokenType   string `json:"token_type"`
+	}
+	if err := p.doJSON(req, &token); err != nil {
+		return "", fmt.Errorf("failed to exchange authorization code: %w", err)
+	}
+	if token.AccessToken == "" {
+		return "", fmt.Errorf("failed to exchange authorization code: no access token in response")
+	}
+	return token.AccessToken, nil
+}
+
+// UserInfo fetches the signed-in user's claims with an access token
+func (p *Provider) UserInfo(ctx context.Context, accessToken string) (*UserInfo, error) {
+	ep, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var info UserInfo
+	if err := p.getJSON(ctx, ep.UserinfoEndpoint, accessToken, &info); err != nil {
+		return nil, fmt.Errorf("failed to fetch user info: %w", err)
+	}
+	return &info, nil
+}
+
+// getJSON GETs a URL, optionally with a bearer token, and decodes the response
+func (p *Provider) getJSON(ctx context.Context, url, bearer string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if bearer != "" {
+		req.Header.Set("Authorization", "Bearer "+bearer)
+	}
+	return p.doJSON(req, v)
+}
+
+// doJSON sends a request and decodes a successful JSON response
+func (p *Provider) doJSON(req *http.Request, v any) error {
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s: %s", req.URL.Host, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return json.Unmarshal(body, v)
+}
+
+// RandomString returns a URL-safe random string suitable for states and PKCE
+// verifiers
+func RandomString() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// challenge derives the S256 PKCE code challenge from a verifier
+func challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// Sign returns value with its expiry and an HMAC-SHA256 signature, encoded so
+// it can be stored in a cookie. The purpose (such as "session") is signed
+// with it, so a value signed for one use is never accepted for another.
+func Sign(key []byte, purpose, value string, expires time.Time) string {
+	payload := strconv.FormatInt(expires.Unix(), 10) + "|" + purpose + "|" + value
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(payload))
+
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." +
+		base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks a value produced by Sign for purpose and returns the original
+// value if the signature matches and it has not expired
+func Verify(key []byte, purpose, signed string, now time.Time) (string, error) {
+	encodedPayload, encodedMAC, ok := strings.Cut(signed, ".")
+	if !ok {
+		return "", ErrInvalidValue
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil {
+		return "", ErrInvalidValue
+	}
+	sum, err := base64.RawURLEncoding.DecodeString(encodedMAC)
+	if err != nil {
+		return "", ErrInvalidValue
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write(payload)
+	if !hmac.Equal(sum, mac.Sum(nil)) {
+		return "", ErrInvalidValue
+	}
+
+	expiresAt, rest, ok := strings.Cut(string(payload), "|")
+	if !ok {
+		return "", ErrInvalidValue
+	}
+	signedFor, value, ok := strings.Cut(rest, "|")
+	if !ok || signedFor != purpose {
+		return "", ErrInvalidValue
+	}
+	expires, err := strconv.ParseInt(expiresAt, 10, 64)
+	if err != nil || now.Unix() >= expires {
+		return "", ErrInvalidValue
+	}
+	return value, nil
+}
@@ -0,0 +1,167 @@
+// Package pathfilter matches requests against allow and deny rules on the
+// HTTP method and path, such as "/admin/*" or "POST /webhooks/*".
+package pathfilter
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Rule matches requests by method and path pattern. In a pattern, * matches
+// any characters including slashes, and a trailing /* also matches the path
+// without it, so /admin/* covers /admin itself.
+type Rule struct {
+	Methods []string // Upper-case methods; empty matches any method
+	Pattern string
+
+	regexp *regexp.Regexp
+}
+
+var methodPattern = regexp.MustCompile(`^[A-Z]+$`)
+
+// ParseRule parses a rule written as an optional comma-separated list of
+// methods followed by a path pattern: "/admin/*", "POST /webhooks/*" or
+// "GET,HEAD /docs/*"
+func ParseRule(entry string) (Rule, error) {
+	fields := strings.Fields(entry)
+	var rule Rule
+	switch len(fields) {
+	case 1:
+		rule.Pattern = fields[0]
+	case 2:
+		for _, method := range strings.Split(strings.ToUpper(fields[0]), ",") {
+			if !methodPattern.MatchString(method) {
+				return Rule{}, fmt.Errorf("invalid method %q in rule %q", method, entry)
+			}
+			rule.Methods = append(rule.Methods, method)
+		}
+		rule.Pattern = fields[1]
+	default:
+		return Rule{}, fmt.Errorf("invalid rule %q: use a path pattern such as /admin/*, optionally after methods such as POST", entry)
+	}
+	if !strings.HasPrefix(rule.Pattern, "/") {
+		return Rule{}, fmt.Errorf("invalid rule %q: path patterns start with /", entry)
+	}
+
+	expr := regexp.QuoteMeta(rule.Pattern)
+	if strings.HasSuffix(expr, `/\*`) {
+		expr = strings.TrimSuffix(expr, `/\*`) + `(/.*)?`
+	}
+	rule.regexp = regexp.MustCompile("^" + strings.ReplaceAll(expr, `\*`, ".*") + "$")
+	return rule, nil
+}
+
+// String returns the rule in the form ParseRule accepts
+func (r Rule) String() string {
+	if len(r.Methods) == 0 {
+		return r.Pattern
+	}
+	return strings.Join(r.Methods, ",") + " " + r.Pattern
+}
+
+func (r Rule) matchesPath(p string) bool {
+	return r.regexp.MatchString(p)
+}
+
+func (r Rule) matchesMethod(method string) bool {
+	if len(r.Methods) == 0 {
+		return true
+	}
+	for _, m := range r.Methods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+// Filter decides whether a request may reach a tunnel. Requests matching a
+// deny rule are always rejected; when there are allow rules, only requests
+// matching one of them are accepted.
+type Filter struct {
+	allow []Rule
+	deny  []Rule
+}
+
+// New builds a filter from allow and deny rules
+func New(allow, deny []string) (*Filter, error) {
+	allowRules, err := parseList(allow)
+	if err != nil {
+		return nil, err
+	}
+	denyRules, err := parseList(deny)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Filter{allow: allowRules, deny: denyRules}, nil
+}
+
+// Check returns the status a request is rejected with, or 0 if it may pass:
+// 405 with the methods allowed on the path when only its method is not
+// allowed, and 403 otherwise. The path is matched decoded and cleaned, so
+// encodings and dot segments can't get around a rule.
+func (f *Filter) Check(method, target string) (status int, allowed []string) {
+	p, err := cleanPath(target)
+	if err != nil {
+		return http.StatusForbidden, nil
+	}
+	method = strings.ToUpper(method)
+
+	for _, rule := range f.deny {
+		if rule.matchesPath(p) && rule.matchesMethod(method) {
+			return http.StatusForbidden, nil
+		}
+	}
+	if len(f.allow) == 0 {
+		return 0, nil
+	}
+
+	methods := make(map[string]bool)
+	for _, rule := range f.allow {
+		if !rule.matchesPath(p) {
+			continue
+		}
+		if rule.matchesMethod(method) {
+			return 0, nil
+		}
+		for _, m := range rule.Methods {
+			methods[m] = true
+		}
+	}
+	if len(methods) == 0 {
+		return http.StatusForbidden, nil
+	}
+	for m := range methods {
+		allowed = append(allowed, m)
+	}
+	sort.Strings(allowed)
+	return http.StatusMethodNotAllowed, allowed
+}
+
+// cleanPath returns the decoded, cleaned path of a request target
+func cleanPath(target string) (string, error) {
+	p, _, _ := strings.Cut(target, "?")
+	p, err := url.PathUnescape(p)
+	if err != nil {
+		return "", err
+	}
+	return path.Clean("/" + p), nil
+}
+
+func parseList(entries []string) ([]Rule, error) {
+	rules := make([]Rule, 0, len(entries))
+	for _, entry := range entries {
+		rule, err := ParseRule(entry)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
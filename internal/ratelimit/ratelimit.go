@@ -0,0 +1,119 @@
+// Package ratelimit implements token bucket rate limiting per client key with
+// an optional global cap across all clients.
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often buckets of idle clients are dropped
+const sweepInterval = time.Minute
+
+// bucket is a token bucket refilled continuously at rate tokens per second
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// take refills the bucket and removes one token if available. Otherwise it
+// returns how long until a token will be.
+func (b *bucket) take(now time.Time, rate, burst float64) (bool, time.Duration) {
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / rate * float64(time.Second))
+}
+
+// Limiter limits requests per client and, optionally, in total
+type Limiter struct {
+	mutex       sync.Mutex
+	clientRate  float64
+	clientBurst float64
+	globalRate  float64
+	globalBurst float64
+	global      *bucket
+	clients     map[string]*bucket
+	lastSweep   time.Time
+}
+
+// New creates a limiter allowing clientRate requests per second per client
+// with bursts of clientBurst, and globalRate requests per second overall with
+// bursts of globalBurst. A zero rate disables that limit; a burst below one
+// defaults to the rate rounded up.
+func New(clientRate float64, clientBurst int, globalRate float64, globalBurst int) *Limiter {
+	l := &Limiter{
+		clientRate:  clientRate,
+		clientBurst: burstFor(clientRate, clientBurst),
+		globalRate:  globalRate,
+		globalBurst: burstFor(globalRate, globalBurst),
+		clients:     make(map[string]*bucket),
+		lastSweep:   time.Now(),
+	}
+	if globalRate > 0 {
+		l.global = &bucket{tokens: l.globalBurst, last: time.Now()}
+	}
+	return l
+}
+
+func burstFor(rate float64, burst int) float64 {
+	if burst >= 1 {
+		return float64(burst)
+	}
+	return math.Max(1, math.Ceil(rate))
+}
+
+// Allow reports whether a request from client may proceed. If not, it also
+// returns how long the client should wait before retrying.
+func (l *Limiter) Allow(client string) (bool, time.Duration) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := time.Now()
+	l.sweep(now)
+
+	var clientBucket *bucket
+	if l.clientRate > 0 {
+		clientBucket = l.clients[client]
+		if clientBucket == nil {
+			clientBucket = &bucket{tokens: l.clientBurst, last: now}
+			l.clients[client] = clientBucket
+		}
+		if ok, wait := clientBucket.take(now, l.clientRate, l.clientBurst); !ok {
+			return false, wait
+		}
+	}
+
+	if l.global != nil {
+		if ok, wait := l.global.take(now, l.globalRate, l.globalBurst); !ok {
+			// Don't charge the client for a request that was not let through
+			if clientBucket != nil {
+				clientBucket.tokens++
+			}
+			return false, wait
+		}
+	}
+
+	return true, 0
+}
+
+// sweep drops buckets that have refilled completely, since a fresh bucket
+// behaves the same. Must be called with the mutex held.
+func (l *Limiter) sweep(now time.Time) {
+	if l.clientRate <= 0 || now.Sub(l.lastSweep) < sweepInterval {
+		return
+	}
+	l.lastSweep = now
+
+	full := time.Duration(l.clientBurst / l.clientRate * float64(time.Second))
+	for client, b := range l.clients {
+		if now.Sub(b.last) >= full {
+			delete(l.clients, client)
+		}
+	}
+}
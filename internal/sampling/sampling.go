@@ -0,0 +1,122 @@
+// Package sampling records a random subset of proxied requests, with headers
+// and truncated bodies, to a rotating JSON lines file for later analysis.
+package sampling
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"math/rand"
+	"net/http"
+	"skyport-agent/internal/logfile"
+	"time"
+	"unicode/utf8"
+)
+
+// redactedHeaders are never written to sample files
+var redactedHeaders = []string{
+	"Authorization",
+	"Proxy-Authorization",
+	"Cookie",
+	"Set-Cookie",
+	"X-Api-Key",
+	"X-Auth-Token",
+	"X-Tunnel-Auth",
+}
+
+// Message is one side of a sampled exchange
+type Message struct {
+	Headers       map[string]string `json:"headers,omitempty"`
+	Body          string            `json:"body,omitempty"`
+	BodyEncoding  string            `json:"body_encoding,omitempty"` // "base64" for binary bodies
+	BodySize      int               `json:"body_size"`
+	BodyTruncated bool              `json:"body_truncated,omitempty"`
+}
+
+// Sample is one recorded request/response exchange
+type Sample struct {
+	Time       time.Time `json:"time"`
+	Method     string    `json:"method"`
+	URL        string    `json:"url"`
+	Status     int       `json:"status"`
+	DurationMs float64   `json:"duration_ms"`
+	Error      string    `json:"error,omitempty"`
+	Request    Message   `json:"request"`
+	Response   Message   `json:"response"`
+}
+
+// Sampler decides which requests to record and writes them out
+type Sampler struct {
+	rate    float64 // Fraction of requests recorded, 0..1
+	maxBody int
+	redact  map[string]bool
+	file    *logfile.RotatingFile
+}
+
+// Open creates a sampler recording percent% of requests to path. extraRedact
+// lists additional header names to redact.
+func Open(path string, percent float64, maxBody int, maxSize int64, maxBackups int, extraRedact []string) (*Sampler, error) {
+	file, err := logfile.Open(path, maxSize, maxBackups)
+	if err != nil {
+		return nil, err
+	}
+
+	redact := make(map[string]bool)
+	for _, name := range append(redactedHeaders, extraRedact...) {
+		redact[http.CanonicalHeaderKey(name)] = true
+	}
+
+	return &Sampler{
+		rate:    percent / 100,
+		maxBody: maxBody,
+		redact:  redact,
+		file:    file,
+	}, nil
+}
+
+// ShouldSample makes the per-request sampling decision
+func (s *Sampler) ShouldSample() bool {
+	return s != nil && rand.Float64() < s.rate
+}
+
+// Record writes a sampled exchange
+func (s *Sampler) Record(sample Sample) error {
+	data, err := json.Marshal(sample)
+	if err != nil {
+		return err
+	}
+	_, err = s.file.Write(append(data, '\n'))
+	return err
+}
+
+// Message builds one side of a sample, redacting sensitive headers and
+// truncating the body
+func (s *Sampler) Message(headers map[string]string, body []byte) Message {
+	msg := Message{BodySize: len(body)}
+
+	if len(headers) > 0 {
+		msg.Headers = make(map[string]string, len(headers))
+		for name, value := range headers {
+			if s.redact[http.CanonicalHeaderKey(name)] {
+				value = "[REDACTED]"
+			}
+			msg.Headers[name] = value
+		}
+	}
+
+	if len(body) > s.maxBody {
+		body = body[:s.maxBody]
+		msg.BodyTruncated = true
+	}
+	if utf8.Valid(body) {
+		msg.Body = string(body)
+	} else {
+		msg.Body = base64.StdEncoding.EncodeToString(body)
+		msg.BodyEncoding = "base64"
+	}
+	return msg
+}
+
+// Close closes the sample file
+func (s *Sampler) Close() error {
+	return s.file.Close()
+}
@@ -0,0 +1,213 @@
+package service
+
+import (
+	"context"
+	"log"
+	"skyport-agent/internal/config"
+	"skyport-agent/internal/crash"
+	"skyport-agent/internal/docker"
+	"sync"
+	"time"
+)
+
+// DockerDiscovery keeps tunnels in sync with running containers labelled
+// skyport.subdomain (or skyport.tunnel) and skyport.port: matching tunnels
+// are pointed at the container and connected while it runs, and
+// disconnected once it is gone.
+type DockerDiscovery struct {
+	manager  *Manager
+	client   *docker.Client
+	interval time.Duration
+	ctx      context.Context
+	cancel   context.CancelFunc
+	mu       sync.Mutex
+	running  bool
+
+	// started maps tunnels connected by discovery to their container ID
+	started map[string]string
+	// reported suppresses repeating the same problem every interval
+	reported map[string]bool
+}
+
+// NewDockerDiscovery creates the discovery loop, or returns nil if the Docker
+// host setting is invalid
+func NewDockerDiscovery(manager *Manager) *DockerDiscovery {
+	settings := manager.config.GetSettings().Discovery
+
+	client, err := docker.NewClient(settings.DockerHost)
+	if err != nil {
+		log.Printf("Docker discovery disabled: %v", err)
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &DockerDiscovery{
+		manager:  manager,
+		client:   client,
+		interval: settings.Interval.Duration,
+		ctx:      ctx,
+		cancel:   cancel,
+		started:  make(map[string]string),
+		reported: make(map[string]bool),
+	}
+}
+
+// Start begins watching containers
+func (dd *DockerDiscovery) Start() {
+	dd.mu.Lock()
+	defer dd.mu.Unlock()
+
+	if dd.running {
+		return
+	}
+	dd.running = true
+
+	go dd.loop()
+	log.Println("Docker discovery started")
+}
+
+// Stop stops watching containers. Tunnels it started are left to the
+// manager's shutdown.
+func (dd *DockerDiscovery) Stop() {
+	dd.mu.Lock()
+	defer dd.mu.Unlock()
+
+	if !dd.running {
+		return
+	}
+	dd.running = false
+	dd.cancel()
+
+	log.Println("Docker discovery stopped")
+}
+
+func (dd *DockerDiscovery) loop() {
+	defer crash.Recover("docker discovery")
+
+	ticker := time.NewTicker(dd.interval)
+	defer ticker.Stop()
+
+	for {
+		dd.sync()
+
+		select {
+		case <-dd.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sync reconciles tunnels with the currently running labelled containers
+func (dd *DockerDiscovery) sync() {
+	if !dd.manager.IsAuthenticated() {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(dd.ctx, 10*time.Second)
+	defer cancel()
+
+	containers, err := dd.client.LabeledContainers(ctx)
+	if err != nil {
+		dd.reportOnce("docker", "Docker discovery: %v", err)
+		return
+	}
+	delete(dd.reported, "docker")
+
+	services, problems := docker.Services(containers)
+	for _, problem := range problems {
+		dd.reportOnce(problem.Error(), "Docker discovery: ignoring %v", problem)
+	}
+
+	tunnels, err := dd.manager.GetTunnelList()
+	if err != nil {
+		log.Printf("Docker discovery: failed to load tunnels: %v", err)
+		return
+	}
+
+	seen := make(map[string]bool)
+	for _, service := range services {
+		tunnel := MatchTunnel(tunnels, service)
+		if tunnel == nil {
+			dd.reportOnce("missing:"+service.Subdomain+service.Tunnel,
+				"Docker discovery: no tunnel matches %s (subdomain %q, name %q); create it in the SkyPort dashboard",
+				service.Describe(), service.Subdomain, service.Tunnel)
+			continue
+		}
+		if seen[tunnel.ID] {
+			dd.reportOnce("duplicate:"+tunnel.ID, "Docker discovery: tunnel %s is claimed by more than one container; using the first", tunnel.Name)
+			continue
+		}
+		seen[tunnel.ID] = true
+		dd.ensureConnected(tunnel, service)
+	}
+
+	// Containers that stopped take their tunnels down with them
+	for tunnelID := range dd.started {
+		if seen[tunnelID] {
+			continue
+		}
+		delete(dd.started, tunnelID)
+		if err := dd.manager.DisconnectTunnel(tunnelID); err != nil {
+			log.Printf("Docker discovery: failed to disconnect tunnel %s: %v", tunnelID, err)
+			continue
+		}
+		log.Printf("Docker discovery: container gone, disconnected tunnel %s", tunnelID)
+	}
+}
+
+// ensureConnected points a tunnel at a service's address and connects it,
+// reconnecting if the address changed
+func (dd *DockerDiscovery) ensureConnected(tunnel *config.Tunnel, service docker.Service) {
+	connected := dd.manager.IsTunnelConnected(tunnel.ID)
+
+	if tunnel.LocalAddress() != service.Target {
+		if err := dd.manager.configManager.SetTunnelTarget(tunnel.ID, service.Target); err != nil {
+			log.Printf("Docker discovery: failed to update tunnel %s: %v", tunnel.Name, err)
+			return
+		}
+		if connected {
+			// Connections keep the target they were opened with
+			if err := dd.manager.DisconnectTunnel(tunnel.ID); err != nil {
+				log.Printf("Docker discovery: failed to disconnect tunnel %s: %v", tunnel.Name, err)
+				return
+			}
+			connected = false
+		}
+	}
+
+	if !connected {
+		if err := dd.manager.ConnectTunnel(tunnel.ID, false); err != nil {
+			dd.reportOnce("connect:"+tunnel.ID, "Docker discovery: failed to connect tunnel %s for %s: %v", tunnel.Name, service.Describe(), err)
+			return
+		}
+		delete(dd.reported, "connect:"+tunnel.ID)
+		log.Printf("Docker discovery: connected tunnel %s to %s (%s)", tunnel.Name, service.Describe(), service.Target)
+	}
+	dd.started[tunnel.ID] = service.ContainerID
+}
+
+// reportOnce logs a problem the first time it is seen
+func (dd *DockerDiscovery) reportOnce(key, format string, args ...interface{}) {
+	if dd.reported[key] {
+		return
+	}
+	dd.reported[key] = true
+	log.Printf(format, args...)
+}
+
+// MatchTunnel finds the tunnel a discovered service asks for, by subdomain
+// or, failing that, by name
+func MatchTunnel(tunnels []*config.Tunnel, service docker.Service) *config.Tunnel {
+	for _, tunnel := range tunnels {
+		if service.Subdomain != "" && tunnel.Subdomain == service.Subdomain {
+			return tunnel
+		}
+	}
+	for _, tunnel := range tunnels {
+		if service.Tunnel != "" && tunnel.Name == service.Tunnel {
+			return tunnel
+		}
+	}
+	return nil
+}
@@ -4,25 +4,28 @@ import (
 	"context"
 	"fmt"
 	"log"
-	"net"
 	"os"
 	"os/signal"
+	"skyport-agent/internal/crash"
+	"skyport-agent/internal/localnet"
+	"skyport-agent/internal/network"
+	"skyport-agent/internal/notify"
 	"sync"
 	"syscall"
 	"time"
 )
 
 // HealthMonitor manages tunnel health and auto-recovery
+// Reconnection itself is owned by each tunnel's supervisor in the tunnel
+// package; the health monitor only observes and nudges it.
 type HealthMonitor struct {
-	manager         *Manager
-	healthTicker    *time.Ticker
-	reconnectTicker *time.Ticker
-	ctx             context.Context
-	cancel          context.CancelFunc
-	mu              sync.RWMutex
-	lastHealth      map[string]time.Time
-	reconnectQueue  map[string]int // retry count
-	maxRetries      int
+	manager      *Manager
+	healthTicker *time.Ticker
+	ctx          context.Context
+	cancel       context.CancelFunc
+	mu           sync.RWMutex
+	lastHealth   map[string]time.Time
+	lastProbe    map[string]*ProbeResult
 }
 
 // NewHealthMonitor creates a new health monitor
@@ -30,26 +33,21 @@ func NewHealthMonitor(manager *Manager) *HealthMonitor {
 	ctx, cancel := context.WithCancel(context.Background())
 
 	return &HealthMonitor{
-		manager:        manager,
-		ctx:            ctx,
-		cancel:         cancel,
-		lastHealth:     make(map[string]time.Time),
-		reconnectQueue: make(map[string]int),
-		maxRetries:     5,
+		manager:    manager,
+		ctx:        ctx,
+		cancel:     cancel,
+		lastHealth: make(map[string]time.Time),
+		lastProbe:  make(map[string]*ProbeResult),
 	}
 }
 
 // Start begins health monitoring
 func (hm *HealthMonitor) Start() {
-	// Health check every 30 seconds
-	hm.healthTicker = time.NewTicker(30 * time.Second)
-
-	// Reconnection attempts every 60 seconds
-	hm.reconnectTicker = time.NewTicker(60 * time.Second)
+	// Health check every 30 seconds by default
+	hm.healthTicker = time.NewTicker(hm.manager.config.GetSettings().Intervals.HealthCheckInterval.Duration)
 
 	// Start monitoring goroutines
 	go hm.healthCheckLoop()
-	go hm.reconnectLoop()
 	go hm.signalHandler()
 
 	log.Println("Health monitor started")
@@ -60,15 +58,14 @@ func (hm *HealthMonitor) Stop() {
 	if hm.healthTicker != nil {
 		hm.healthTicker.Stop()
 	}
-	if hm.reconnectTicker != nil {
-		hm.reconnectTicker.Stop()
-	}
 	hm.cancel()
 	log.Println("Health monitor stopped")
 }
 
 // healthCheckLoop performs periodic health checks
 func (hm *HealthMonitor) healthCheckLoop() {
+	defer crash.Recover("health monitor")
+
 	for {
 		select {
 		case <-hm.ctx.Done():
@@ -79,16 +76,9 @@ func (hm *HealthMonitor) healthCheckLoop() {
 	}
 }
 
-// reconnectLoop handles reconnection attempts
-func (hm *HealthMonitor) reconnectLoop() {
-	for {
-		select {
-		case <-hm.ctx.Done():
-			return
-		case <-hm.reconnectTicker.C:
-			hm.processReconnectQueue()
-		}
-	}
+// CheckNow runs a health check immediately instead of waiting for the next tick
+func (hm *HealthMonitor) CheckNow() {
+	hm.performHealthCheck()
 }
 
 // performHealthCheck checks the health of all active tunnels
@@ -103,21 +93,26 @@ func (hm *HealthMonitor) performHealthCheck() {
 		// Check if tunnel is actually connected
 		if !hm.manager.IsTunnelConnected(tunnelID) {
 			log.Printf("Health check: Tunnel %s is disconnected", tunnelID)
-			hm.scheduleReconnect(tunnelID)
+			hm.manager.RequestReconnect(tunnelID)
 			continue
 		}
 
-		// Check local service health
+		// Check local service health - reconnecting the tunnel would not help here
 		if !hm.checkLocalServiceHealth(tunnelID) {
 			log.Printf("Health check: Local service for tunnel %s is not responding", tunnelID)
-			hm.scheduleReconnect(tunnelID)
+			hm.manager.sendAlert(notify.EventLocalServiceDown, hm.tunnelName(tunnelID),
+				"Local service down", "The local service is not accepting connections")
 			continue
 		}
 
 		// Check network connectivity
 		if !hm.checkNetworkConnectivity() {
 			log.Printf("Health check: Network connectivity issues detected")
-			hm.scheduleReconnect(tunnelID)
+			continue
+		}
+
+		// Verify the public URL actually reaches the local service
+		if !hm.checkEndToEnd(tunnelID) {
 			continue
 		}
 
@@ -129,26 +124,31 @@ func (hm *HealthMonitor) performHealthCheck() {
 
 // checkLocalServiceHealth checks if the local service is responding
 func (hm *HealthMonitor) checkLocalServiceHealth(tunnelID string) bool {
-	// Get tunnel config to find local port
+	// Get tunnel config to find the local address
 	tunnels, err := hm.manager.GetTunnelList()
 	if err != nil {
 		return false
 	}
 
-	var localPort int
+	var localAddr string
 	for _, tunnel := range tunnels {
 		if tunnel.ID == tunnelID {
-			localPort = tunnel.LocalPort
+			// Tunnels serving a directory are healthy while it exists
+			if dir := tunnel.StaticSettings().Dir; dir != "" {
+				info, err := os.Stat(dir)
+				return err == nil && info.IsDir()
+			}
+			localAddr = tunnel.LocalAddress()
 			break
 		}
 	}
 
-	if localPort == 0 {
+	if localAddr == "" {
 		return false
 	}
 
 	// Try to connect to local service
-	conn, err := net.DialTimeout("tcp", fmt.Sprintf("localhost:%d", localPort), 5*time.Second)
+	conn, err := localnet.Dial("tcp", localAddr, 5*time.Second)
 	if err != nil {
 		return false
 	}
@@ -157,41 +157,57 @@ func (hm *HealthMonitor) checkLocalServiceHealth(tunnelID string) bool {
 	return true
 }
 
-// checkNetworkConnectivity checks basic network connectivity
-func (hm *HealthMonitor) checkNetworkConnectivity() bool {
-	// Try to resolve a well-known domain
-	_, err := net.LookupHost("google.com")
-	return err == nil
-}
-
-// scheduleReconnect schedules a tunnel for reconnection
-func (hm *HealthMonitor) scheduleReconnect(tunnelID string) {
-	hm.reconnectQueue[tunnelID]++
-	log.Printf("Scheduled reconnection for tunnel %s (attempt %d)", tunnelID, hm.reconnectQueue[tunnelID])
-}
+// checkEndToEnd runs the optional synthetic probe through the public URL and
+// flags tunnels that are connected but not serving. Returns true when probes
+// are disabled or the probe succeeded.
+func (hm *HealthMonitor) checkEndToEnd(tunnelID string) bool {
+	cfg := hm.manager.config
+	if !cfg.GetSettings().Probes.Enabled {
+		return true
+	}
 
-// processReconnectQueue processes the reconnection queue
-func (hm *HealthMonitor) processReconnectQueue() {
-	hm.mu.Lock()
-	defer hm.mu.Unlock()
+	tunnels, err := hm.manager.GetTunnelList()
+	if err != nil {
+		return true
+	}
 
-	for tunnelID, retryCount := range hm.reconnectQueue {
-		if retryCount > hm.maxRetries {
-			log.Printf("Max retries reached for tunnel %s, removing from queue", tunnelID)
-			delete(hm.reconnectQueue, tunnelID)
+	for _, tunnel := range tunnels {
+		if tunnel.ID != tunnelID {
 			continue
 		}
 
-		// Attempt reconnection
-		if err := hm.manager.ConnectTunnel(tunnelID, false); err != nil {
-			log.Printf("Reconnection failed for tunnel %s: %v", tunnelID, err)
-			// Increment retry count
-			hm.reconnectQueue[tunnelID]++
-		} else {
-			log.Printf("Successfully reconnected tunnel %s", tunnelID)
-			delete(hm.reconnectQueue, tunnelID)
+		result := probeTunnel(cfg, tunnel.Subdomain)
+		hm.lastProbe[tunnelID] = result
+
+		if !result.Serving {
+			log.Printf("Health check: Tunnel %s is connected but not serving (%s): %s",
+				tunnel.Name, result.URL, result.Error)
+			hm.manager.sendAlert(notify.EventTunnelNotServing, tunnel.Name, "Tunnel not serving",
+				fmt.Sprintf("%s: %s", result.URL, result.Error))
+			return false
+		}
+		return true
+	}
+
+	return true
+}
+
+// tunnelName returns a tunnel's name for messages, falling back to its ID
+func (hm *HealthMonitor) tunnelName(tunnelID string) string {
+	tunnels, err := hm.manager.GetTunnelList()
+	if err == nil {
+		for _, tunnel := range tunnels {
+			if tunnel.ID == tunnelID {
+				return tunnel.Name
+			}
 		}
 	}
+	return tunnelID
+}
+
+// checkNetworkConnectivity checks basic network connectivity
+func (hm *HealthMonitor) checkNetworkConnectivity() bool {
+	return network.CheckTargets(hm.manager.config.ConnectivityTargets(), 5*time.Second) == nil
 }
 
 // signalHandler handles system signals for graceful shutdown
@@ -251,7 +267,7 @@ func (hm *HealthMonitor) GetHealthStatus() map[string]interface{} {
 
 	status := map[string]interface{}{
 		"active_tunnels":    len(hm.manager.GetActiveTunnels()),
-		"reconnect_queue":   len(hm.reconnectQueue),
+		"reconnect_queue":   len(hm.manager.GetReconnectingTunnels()),
 		"last_health_check": time.Now(),
 		"tunnel_health":     make(map[string]interface{}),
 	}
@@ -260,11 +276,52 @@ func (hm *HealthMonitor) GetHealthStatus() map[string]interface{} {
 	for tunnelID, lastHealth := range hm.lastHealth {
 		status["tunnel_health"].(map[string]interface{})[tunnelID] = map[string]interface{}{
 			"last_healthy": lastHealth,
-			"is_healthy":   time.Since(lastHealth) < 2*time.Minute,
+			"is_healthy":   time.Since(lastHealth) < 4*hm.manager.config.GetSettings().Intervals.HealthCheckInterval.Duration,
 		}
 	}
 
-	return status
-}
+	// Add latency percentiles for connected tunnels
+	for tunnelID, latency := range hm.manager.GetLatencyStats() {
+		health, ok := status["tunnel_health"].(map[string]interface{})[tunnelID].(map[string]interface{})
+		if !ok {
+			health = map[string]interface{}{}
+			status["tunnel_health"].(map[string]interface{})[tunnelID] = health
+		}
+		health["forwarding_latency"] = latency.Forwarding.String()
+		health["round_trip_time"] = latency.RoundTrip.String()
+		health["round_trip_avg_min_max"] = latency.RoundTrip.Range()
+	}
+
+	// Add rolling error counts and the most recent errors
+	for tunnelID, errors := range hm.manager.GetErrorStats() {
+		health, ok := status["tunnel_health"].(map[string]interface{})[tunnelID].(map[string]interface{})
+		if !ok {
+			health = map[string]interface{}{}
+			status["tunnel_health"].(map[string]interface{})[tunnelID] = health
+		}
+		health["errors"] = errors
+	}
+
+	// Add how well each tunnel's connection to the server is doing
+	for tunnelID, quality := range hm.manager.GetConnectionQuality() {
+		health, ok := status["tunnel_health"].(map[string]interface{})[tunnelID].(map[string]interface{})
+		if !ok {
+			health = map[string]interface{}{}
+			status["tunnel_health"].(map[string]interface{})[tunnelID] = health
+		}
+		health["connection_quality"] = quality
+	}
 
+	// Add end-to-end probe results, including tunnels that never passed
+	for tunnelID, probe := range hm.lastProbe {
+		health, ok := status["tunnel_health"].(map[string]interface{})[tunnelID].(map[string]interface{})
+		if !ok {
+			health = map[string]interface{}{"is_healthy": false}
+			status["tunnel_health"].(map[string]interface{})[tunnelID] = health
+		}
+		health["serving"] = probe.Serving
+		health["probe"] = probe
+	}
 
+	return status
+}
@@ -0,0 +1,88 @@
+package service
+
+import (
+	"errors"
+	"os"
+	"runtime"
+	"skyport-agent/internal/auth"
+	"skyport-agent/internal/crash"
+	"skyport-agent/internal/logger"
+	"time"
+)
+
+// Version is the agent version reported in heartbeats (set by the cli package)
+var Version = "unknown"
+
+// runHeartbeats reports the agent's health to the server every heartbeat
+// interval until the manager stops, unless heartbeats are turned off or the
+// server does not accept them
+func (am *Manager) runHeartbeats() {
+	defer crash.Recover("heartbeat")
+
+	settings := am.config.GetSettings().Heartbeat
+	if settings.Disabled {
+		return
+	}
+
+	ticker := time.NewTicker(settings.Interval.Duration)
+	defer ticker.Stop()
+
+	for {
+		if err := am.sendHeartbeat(); errors.Is(err, auth.ErrHeartbeatUnsupported) {
+			logger.Debug("Heartbeats stopped: %v", err)
+			return
+		} else if err != nil {
+			logger.Debug("Failed to send heartbeat: %v", err)
+		}
+
+		select {
+		case <-am.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sendHeartbeat sends one heartbeat with the current tunnel stats. Nothing
+// is sent while logged out.
+func (am *Manager) sendHeartbeat() error {
+	if !am.authManager.IsAuthenticated() {
+		return nil
+	}
+	token, err := am.authManager.GetValidToken()
+	if err != nil {
+		return err
+	}
+	return am.authManager.SendHeartbeat(token, am.heartbeat())
+}
+
+// heartbeat describes the agent and its tunnels
+func (am *Manager) heartbeat() *auth.Heartbeat {
+	host, _ := os.Hostname()
+	machineID, _ := am.configManager.MachineID()
+
+	heartbeat := &auth.Heartbeat{
+		MachineID: machineID,
+		Host:      host,
+		Version:   Version,
+		OS:        runtime.GOOS,
+		Arch:      runtime.GOARCH,
+		Uptime:    int64(time.Since(am.startedAt).Seconds()),
+		Tunnels:   []auth.HeartbeatTunnel{},
+		SentAt:    time.Now(),
+	}
+	for _, stats := range am.tunnelManager.GetTrafficStats() {
+		heartbeat.Tunnels = append(heartbeat.Tunnels, auth.HeartbeatTunnel{
+			ID:           stats.TunnelID,
+			Name:         stats.TunnelName,
+			Connected:    stats.Connected,
+			Requests:     stats.Requests,
+			BytesIn:      stats.BytesIn,
+			BytesOut:     stats.BytesOut,
+			ServerErrors: stats.Errors.ServerErrors,
+			Quality:      stats.Quality.Grade,
+			RoundTripMS:  stats.RoundTrip.P50.Milliseconds(),
+		})
+	}
+	return heartbeat
+}
@@ -2,25 +2,44 @@ package service
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"log"
+	"os"
 	"skyport-agent/internal/auth"
 	"skyport-agent/internal/config"
+	"skyport-agent/internal/control"
+	"skyport-agent/internal/crash"
+	"skyport-agent/internal/journal"
 	"skyport-agent/internal/logger"
+	"skyport-agent/internal/metrics"
+	"skyport-agent/internal/notify"
 	"skyport-agent/internal/tunnel"
 	"sync"
 	"time"
 )
 
+// configWriteDelay is how long the background manager batches config updates
+const configWriteDelay = 500 * time.Millisecond
+
 // Manager handles all background tasks automatically and silently
 // User never needs to run any commands - everything just works
 type Manager struct {
+	config         *config.Config
 	authManager    *auth.AuthManager
 	tunnelManager  *tunnel.TunnelManager
 	configManager  *config.ConfigManager
 	urlHandler     *auth.URLHandler
 	healthMonitor  *HealthMonitor
 	networkMonitor *NetworkMonitor
+	sleepMonitor   *SleepMonitor
+	discovery      *DockerDiscovery
+	lan            *lanAdvertiser
+	alerts         *notify.Dispatcher
+	control        *control.Server
+	stopped        chan tunnel.Event // Tunnels stopped by the agent or the server, for foreground runs
+	watched        map[string]bool   // Tunnels whose connection follows their local port
+	startedAt      time.Time         // When the background manager started, for heartbeats
 	ctx            context.Context
 	cancel         context.CancelFunc
 	isRunning      bool
@@ -32,9 +51,12 @@ func NewManager(cfg *config.Config) *Manager {
 	ctx, cancel := context.WithCancel(context.Background())
 
 	manager := &Manager{
+		config:        cfg,
 		authManager:   auth.NewAuthManager(cfg),
 		tunnelManager: tunnel.NewTunnelManager(cfg),
 		configManager: config.NewConfigManager(),
+		stopped:       make(chan tunnel.Event, 1),
+		watched:       make(map[string]bool),
 		ctx:           ctx,
 		cancel:        cancel,
 		isRunning:     false,
@@ -42,7 +64,22 @@ func NewManager(cfg *config.Config) *Manager {
 
 	// Initialize monitors
 	manager.healthMonitor = NewHealthMonitor(manager)
-	manager.networkMonitor = NewNetworkMonitor()
+	manager.networkMonitor = NewNetworkMonitor(cfg)
+	manager.sleepMonitor = NewSleepMonitor(manager.HandleResume)
+	if cfg.GetSettings().MDNS.Enabled {
+		manager.lan = newLANAdvertiser(manager)
+	}
+	if cfg.GetSettings().Discovery.Docker {
+		manager.discovery = NewDockerDiscovery(manager)
+	}
+
+	// Initialize alert delivery
+	alerts, err := notify.NewDispatcher(cfg.GetSettings().Notifications)
+	if err != nil {
+		log.Printf("Alerts disabled: %v", err)
+	}
+	manager.alerts = alerts
+	manager.tunnelManager.OnEvent(manager.handleTunnelEvent)
 
 	return manager
 }
@@ -57,13 +94,26 @@ func (am *Manager) StartSilently() {
 	}
 
 	am.isRunning = true
+	am.startedAt = time.Now()
+
+	// Tunnels connecting and disconnecting together update skyport.json in
+	// bursts; write each burst once
+	config.SetWriteDelay(configWriteDelay)
 
 	// Start monitors
 	am.healthMonitor.Start()
 	am.networkMonitor.Start()
+	am.sleepMonitor.Start()
+	if am.discovery != nil {
+		am.discovery.Start()
+	}
+
+	am.StartControlServer()
 
 	// Start background manager silently
 	go am.runBackgroundTasks()
+	go am.runHeartbeats()
+	am.tunnelManager.OnCommand(am.handleRemoteCommand)
 }
 
 // StopSilently stops all background processes
@@ -82,6 +132,12 @@ func (am *Manager) StopSilently() {
 	if am.networkMonitor != nil {
 		am.networkMonitor.Stop()
 	}
+	if am.sleepMonitor != nil {
+		am.sleepMonitor.Stop()
+	}
+	if am.discovery != nil {
+		am.discovery.Stop()
+	}
 
 	// Stop URL handler if running
 	if am.urlHandler != nil {
@@ -94,10 +150,63 @@ func (am *Manager) StopSilently() {
 
 	// Disconnect all active tunnels gracefully
 	am.disconnectAllTunnels()
+
+	am.Shutdown()
+}
+
+// Shutdown closes the control socket and flushes telemetry and traffic
+// counters. Foreground tunnels, which never start the background manager,
+// call it directly.
+func (am *Manager) Shutdown() {
+	if am.lan != nil {
+		am.lan.close()
+	}
+	am.control.Stop()
+	am.tunnelManager.Shutdown()
+	if err := config.Flush(); err != nil {
+		logger.Error("Failed to save config: %v", err)
+	}
+}
+
+// StartControlServer exposes live tunnel stats on this process's control
+// socket, and lets 'skyport tunnel switch' change a running tunnel's target
+func (am *Manager) StartControlServer() {
+	if am.control != nil {
+		return
+	}
+
+	server := control.NewServer()
+	server.HandleJSON("/v1/stats", func() interface{} {
+		return am.tunnelManager.GetTrafficStats()
+	})
+	server.HandleJSON("/v1/status", func() interface{} {
+		return control.Status{
+			PID:           os.Getpid(),
+			Authenticated: am.authManager.IsAuthenticated(),
+			Tunnels:       len(am.GetActiveTunnels()),
+		}
+	})
+	server.HandleAction("/v1/switch", func(body json.RawMessage) (interface{}, error) {
+		var request control.SwitchRequest
+		if err := json.Unmarshal(body, &request); err != nil {
+			return nil, err
+		}
+		if !am.tunnelManager.IsConnected(request.TunnelID) {
+			return nil, control.ErrNotFound
+		}
+		return am.tunnelManager.SwitchTarget(request.TunnelID, request.Target, request.DrainTimeout.Duration)
+	})
+
+	if err := server.Start(); err != nil {
+		logger.Warning("Control socket unavailable, live stats disabled: %v", err)
+		return
+	}
+	am.control = server
 }
 
 // runBackgroundTasks runs all background management tasks
 func (am *Manager) runBackgroundTasks() {
+	defer crash.Recover("background tasks")
 	defer func() {
 		am.mutex.Lock()
 		am.isRunning = false
@@ -107,8 +216,8 @@ func (am *Manager) runBackgroundTasks() {
 	// Start with auto-connecting tunnels if user is logged in
 	am.autoConnectTunnels()
 
-	// Main background loop - runs every 60 seconds
-	ticker := time.NewTicker(60 * time.Second)
+	// Main background loop - runs every maintenance interval (60 seconds by default)
+	ticker := time.NewTicker(am.config.GetSettings().Intervals.MaintenanceInterval.Duration)
 	defer ticker.Stop()
 
 	for {
@@ -148,8 +257,9 @@ func (am *Manager) autoConnectTunnels() {
 
 	// Connect each auto-start tunnel silently with auto-reconnect
 	for _, simpleTunnel := range autoStartTunnels {
-		// Skip if already connected
-		if am.tunnelManager.IsConnected(simpleTunnel.ID) {
+		// Skip if already connected, connected when its local port opens, or
+		// expired
+		if am.tunnelManager.IsConnected(simpleTunnel.ID) || am.isWatched(simpleTunnel.ID) || simpleTunnel.Expired() {
 			continue
 		}
 
@@ -159,6 +269,7 @@ func (am *Manager) autoConnectTunnels() {
 			Subdomain: simpleTunnel.Subdomain,
 			LocalPort: simpleTunnel.LocalPort,
 			AuthToken: simpleTunnel.AuthToken,
+			Settings:  simpleTunnel.Settings,
 		}
 
 		log.Printf("Auto-connecting tunnel: %s", tunnel.Name)
@@ -187,6 +298,11 @@ func (am *Manager) performBackgroundMaintenance() {
 
 	// 3. Update tunnel status in config
 	am.updateTunnelStatus()
+
+	// 4. Re-announce tunnels on the LAN so peers that joined late see them
+	if am.lan != nil {
+		am.lan.refresh()
+	}
 }
 
 // SyncTunnelsFromServer syncs tunnel list from server to local config
@@ -232,6 +348,10 @@ func (am *Manager) updateLocalTunnelsFromServer(serverTunnels []config.Tunnel) e
 	// Add/update tunnels from server
 	for _, serverTunnel := range serverTunnels {
 		tunnelCopy := serverTunnel // Create a copy
+		// Local-only settings are not part of the server's view of the tunnel
+		if existing, ok := appConfig.Tunnels[tunnelCopy.ID]; ok && tunnelCopy.Settings == nil {
+			tunnelCopy.Settings = existing.Settings
+		}
 		appConfig.Tunnels[tunnelCopy.ID] = &tunnelCopy
 	}
 
@@ -251,33 +371,27 @@ func (am *Manager) healthCheckAndReconnect() {
 		return
 	}
 
-	_, err = am.authManager.GetValidToken()
+	token, err := am.authManager.GetValidToken()
 	if err != nil {
 		return
 	}
 
-	// Check each auto-start tunnel
+	// Make sure every auto-start tunnel has a supervisor keeping it connected.
+	// Supervised tunnels reconnect on their own, so they are left alone.
 	for _, simpleTunnel := range autoStartTunnels {
-		if !am.tunnelManager.IsConnected(simpleTunnel.ID) {
-			// Tunnel should be connected but isn't - reconnect it
-			log.Printf("Health check: Reconnecting tunnel %s", simpleTunnel.Name)
-
-			// TODO: Fix config.Tunnel type issue
-			// tunnel := config.Tunnel{
-			// 	ID:        simpleTunnel.ID,
-			// 	Name:      simpleTunnel.Name,
-			// 	Subdomain: simpleTunnel.Subdomain,
-			// 	LocalPort: simpleTunnel.LocalPort,
-			// 	AuthToken: simpleTunnel.AuthToken,
-			// }
-
-			// if err := am.tunnelManager.ConnectTunnel(tunnel, token); err != nil {
-			if false {
-				log.Printf("Health check: Failed to reconnect %s: %v", simpleTunnel.Name, err)
-			} else {
-				log.Printf("Health check: Reconnected tunnel %s", simpleTunnel.Name)
-				am.configManager.SetTunnelActive(simpleTunnel.ID, true)
-			}
+		// Idle-stopped and expired tunnels stay down until they are started
+		// again, and watched ones follow their local port
+		if am.tunnelManager.IsSupervised(simpleTunnel.ID) || am.tunnelManager.IsStopped(simpleTunnel.ID) ||
+			simpleTunnel.Expired() || am.isWatched(simpleTunnel.ID) {
+			continue
+		}
+
+		log.Printf("Health check: Reconnecting tunnel %s", simpleTunnel.Name)
+		if err := am.tunnelManager.ConnectTunnelWithRetry(simpleTunnel, token, true); err != nil {
+			log.Printf("Health check: Failed to reconnect %s: %v", simpleTunnel.Name, err)
+		} else {
+			log.Printf("Health check: Reconnected tunnel %s", simpleTunnel.Name)
+			am.configManager.SetTunnelActive(simpleTunnel.ID, true)
 		}
 	}
 }
@@ -311,6 +425,12 @@ func (am *Manager) disconnectAllTunnels() {
 	}
 }
 
+// AllowTakeover makes the next connection of a tunnel take it over from
+// another machine it is running on
+func (am *Manager) AllowTakeover(tunnelID string) {
+	am.tunnelManager.AllowTakeover(tunnelID)
+}
+
 // ConnectTunnel connects a tunnel and optionally sets auto-start
 func (am *Manager) ConnectTunnel(tunnelID string, setAutoStart bool) error {
 	if !am.authManager.IsAuthenticated() {
@@ -345,6 +465,7 @@ func (am *Manager) ConnectTunnel(tunnelID string, setAutoStart bool) error {
 		Subdomain: simpleTunnel.Subdomain,
 		LocalPort: simpleTunnel.LocalPort,
 		AuthToken: simpleTunnel.AuthToken,
+		Settings:  simpleTunnel.Settings,
 	}
 
 	logger.Debug("Connecting tunnel: %s (ID: %s, Port: %d)", tunnel.Name, tunnel.ID, tunnel.LocalPort)
@@ -377,6 +498,186 @@ func (am *Manager) DisconnectTunnel(tunnelID string) error {
 	return nil
 }
 
+// ReconnectTunnel re-establishes a connected tunnel immediately, keeping the
+// old connection alive until the new one is up when the server allows it
+func (am *Manager) ReconnectTunnel(tunnelID string) error {
+	token, err := am.authManager.GetValidToken()
+	if err != nil {
+		return fmt.Errorf("failed to get auth token: %w", err)
+	}
+
+	return am.tunnelManager.ReconnectTunnel(tunnelID, token)
+}
+
+// RequestReconnect asks a supervised tunnel to retry its connection now
+func (am *Manager) RequestReconnect(tunnelID string) bool {
+	return am.tunnelManager.RequestReconnect(tunnelID)
+}
+
+// GetLatencyStats returns forwarding latency and round-trip percentiles for connected tunnels
+func (am *Manager) GetLatencyStats() map[string]tunnel.LatencyStats {
+	return am.tunnelManager.GetLatencyStats()
+}
+
+// GetConnectionQuality grades the server connection of each connected tunnel
+func (am *Manager) GetConnectionQuality() map[string]metrics.ConnectionQuality {
+	return am.tunnelManager.GetConnectionQuality()
+}
+
+// GetErrorStats returns rolling 5xx counts and recent errors for connected tunnels
+func (am *Manager) GetErrorStats() map[string]metrics.ErrorSummary {
+	return am.tunnelManager.GetErrorStats()
+}
+
+// GetReconnectingTunnels returns supervised tunnels that are currently being retried
+func (am *Manager) GetReconnectingTunnels() map[string]int {
+	return am.tunnelManager.GetReconnectingTunnels()
+}
+
+// handleTunnelEvent turns tunnel lifecycle events into alerts
+func (am *Manager) handleTunnelEvent(event tunnel.Event) {
+	journal.Record(journal.Entry{
+		Time:     event.Time,
+		Type:     event.Type,
+		Tunnel:   event.TunnelName,
+		TunnelID: event.TunnelID,
+		Reason:   event.Reason,
+		Attempt:  event.Attempt,
+	})
+
+	if am.lan != nil {
+		switch event.Type {
+		case tunnel.EventConnected, tunnel.EventReconnected, tunnel.EventDisconnected:
+			// Handlers run on the tunnel's goroutine, which may hold its locks
+			go am.lan.refresh()
+		}
+	}
+
+	switch event.Type {
+	case tunnel.EventDisconnected:
+		if event.UserInitiated {
+			return
+		}
+		am.sendAlert(notify.EventTunnelDisconnected, event.TunnelName, "Tunnel disconnected", event.Reason)
+	case tunnel.EventReconnected:
+		am.sendAlert(notify.EventTunnelReconnected, event.TunnelName, "Tunnel reconnected",
+			fmt.Sprintf("Connection restored after %d attempt(s)", event.Attempt))
+	case tunnel.EventIdleStopped:
+		am.configManager.SetTunnelActive(event.TunnelID, false)
+		am.sendAlert(notify.EventTunnelIdle, event.TunnelName, "Tunnel stopped while idle",
+			fmt.Sprintf("Disconnected after %s; start it again with 'skyport tunnel run %s'", event.Reason, event.TunnelName))
+		am.notifyStopped(event)
+	case tunnel.EventExpired:
+		am.configManager.SetTunnelActive(event.TunnelID, false)
+		message := fmt.Sprintf("Stopped: %s", event.Reason)
+		if expiry := am.expirySettings(event.TunnelID); expiry.Delete {
+			if err := am.deleteTunnel(event.TunnelID); err != nil {
+				log.Printf("Failed to delete expired tunnel %s: %v", event.TunnelName, err)
+				message += fmt.Sprintf("; it could not be deleted: %v", err)
+			} else {
+				event.Reason += ", deleted"
+				message += "; the tunnel was deleted"
+			}
+		}
+		am.sendAlert(notify.EventTunnelExpired, event.TunnelName, "Tunnel expired", message)
+		am.notifyStopped(event)
+	case tunnel.EventServerUpdated:
+		// Handlers run on the tunnel's goroutine, which the reconnect replaces
+		go am.applyServerUpdate(event)
+	case tunnel.EventServerDeleted:
+		am.forgetDeletedTunnel(event)
+		am.sendAlert(notify.EventTunnelDeleted, event.TunnelName, "Tunnel deleted",
+			"The tunnel was deleted on the server and has been disconnected")
+		am.notifyStopped(event)
+	case tunnel.EventTakenOver:
+		am.configManager.SetTunnelActive(event.TunnelID, false)
+		am.sendAlert(notify.EventTunnelTakenOver, event.TunnelName, "Tunnel taken over",
+			fmt.Sprintf("Disconnected: %s; take it back with 'skyport tunnel run %s --force'", event.Reason, event.TunnelName))
+		am.notifyStopped(event)
+	}
+}
+
+// notifyStopped hands a tunnel stopped by the agent to Stopped, unless a
+// stop is already waiting there
+func (am *Manager) notifyStopped(event tunnel.Event) {
+	select {
+	case am.stopped <- event:
+	default:
+	}
+}
+
+// Stopped delivers the event for each tunnel disconnected by its idle
+// timeout, because it expired, because it was deleted on the server or
+// because another machine took it over
+func (am *Manager) Stopped() <-chan tunnel.Event {
+	return am.stopped
+}
+
+// expirySettings returns a tunnel's expiry from the local config
+func (am *Manager) expirySettings(tunnelID string) config.ExpirySettings {
+	appConfig, err := am.configManager.LoadConfig()
+	if err != nil {
+		return config.ExpirySettings{}
+	}
+	t, exists := appConfig.Tunnels[tunnelID]
+	if !exists {
+		return config.ExpirySettings{}
+	}
+	return t.ExpirySettings()
+}
+
+// deleteTunnel deletes an expired tunnel from the server and the local config
+func (am *Manager) deleteTunnel(tunnelID string) error {
+	token, err := am.authManager.GetValidToken()
+	if err != nil {
+		return err
+	}
+	if err := am.authManager.DeleteTunnel(token, tunnelID); err != nil {
+		return err
+	}
+	am.configManager.InvalidateTunnelCache()
+	return am.configManager.RemoveTunnel(tunnelID)
+}
+
+// sendAlert delivers an alert through the configured notifiers (if any)
+func (am *Manager) sendAlert(event, tunnelName, title, message string) {
+	am.alerts.Notify(notify.Alert{
+		Event:   event,
+		Tunnel:  tunnelName,
+		Title:   title,
+		Message: message,
+	})
+}
+
+// HandleResume is called after the machine wakes from sleep. Connections that
+// were open before the suspend are almost certainly dead, so instead of waiting
+// for their read deadlines to expire every tunnel is reconnected immediately
+// and a health check is run.
+func (am *Manager) HandleResume(sleptFor time.Duration) {
+	logger.Debug("Reconnecting tunnels after resume (slept ~%v)", sleptFor.Round(time.Second))
+
+	for _, tunnelID := range am.tunnelManager.GetActiveTunnels() {
+		if err := am.ReconnectTunnel(tunnelID); err != nil {
+			log.Printf("Resume: Failed to reconnect tunnel %s: %v", tunnelID, err)
+		}
+	}
+
+	// Tunnels that were already down skip the rest of their backoff
+	for tunnelID := range am.tunnelManager.GetReconnectingTunnels() {
+		am.tunnelManager.RequestReconnect(tunnelID)
+	}
+
+	if am.healthMonitor != nil {
+		am.healthMonitor.CheckNow()
+	}
+}
+
+// WatchForResume reconnects tunnels after system sleep for callers that use
+// the manager without starting its background tasks (foreground tunnel runs)
+func (am *Manager) WatchForResume() {
+	am.sleepMonitor.Start()
+}
+
 // SetTunnelAutoStart enables/disables auto-start for a tunnel
 func (am *Manager) SetTunnelAutoStart(tunnelID string, autoStart bool) error {
 	return am.configManager.SetTunnelAutoStart(tunnelID, autoStart)
@@ -428,7 +729,8 @@ func (am *Manager) OnUserLogout() error {
 	am.disconnectAllTunnels()
 
 	// Clear credentials using auth manager (keyring + user.json)
-	return am.authManager.ClearCredentials()
+	_, err := am.authManager.ClearCredentials()
+	return err
 }
 
 // IsAuthenticated returns whether user is authenticated
@@ -436,6 +738,13 @@ func (am *Manager) IsAuthenticated() bool {
 	return am.authManager.IsAuthenticated()
 }
 
+// IsReadOnly reports whether the login token is read-only, so tunnels can be
+// served but not created, deleted or stopped
+func (am *Manager) IsReadOnly() bool {
+	token, err := am.authManager.GetValidToken()
+	return err == nil && auth.IsReadOnlyToken(token)
+}
+
 // StartWebAuth starts the web authentication process
 func (am *Manager) StartWebAuth() error {
 	// Start a local callback server and get the callback URL
@@ -0,0 +1,81 @@
+package service
+
+import (
+	"errors"
+	"net"
+	"skyport-agent/internal/auth"
+	"skyport-agent/internal/config"
+	"skyport-agent/internal/tunnel"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/zalando/go-keyring"
+)
+
+// closedPort returns a local port nothing listens on
+func closedPort(t *testing.T) int {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+	return port
+}
+
+// login stores a login token with the given claims in a fresh home
+func login(t *testing.T, claims jwt.MapClaims) {
+	t.Helper()
+	t.Setenv("HOME", t.TempDir())
+	// Unreachable, so the stored login is trusted without validation
+	t.Setenv("SKYPORT_SERVER_URL", "http://127.0.0.1:1")
+
+	keyring.MockInit()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := keyring.Set(auth.KeyringService, "default", token); err != nil {
+		t.Fatal(err)
+	}
+	if err := config.SaveUserData(&config.UserData{ID: "u1", Email: "dev@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConnectTunnelPassesLocalSettings(t *testing.T) {
+	login(t, jwt.MapClaims{"type": "agent"})
+
+	// The preflight check is a local setting: if it reaches the tunnel
+	// manager, the closed local port fails the connection before any dial
+	tunnels := map[string]*config.Tunnel{
+		"t1": {
+			ID:        "t1",
+			Name:      "myapp",
+			Subdomain: "myapp",
+			LocalPort: closedPort(t),
+			Settings: &config.TunnelSettings{
+				Preflight: config.PreflightSettings{Enabled: true, Timeout: config.Duration{Duration: time.Second}},
+			},
+		},
+	}
+	configManager := config.NewConfigManager()
+	if err := configManager.SaveConfig(&config.AppConfig{Tunnels: tunnels}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Without its settings the tunnel would be dialed, retrying for a while
+	manager := NewManager(config.Load())
+	result := make(chan error, 1)
+	go func() { result <- manager.ConnectTunnel("t1", false) }()
+	select {
+	case err := <-result:
+		if !errors.Is(err, tunnel.ErrLocalNotListening) {
+			t.Fatalf("ConnectTunnel did not apply the tunnel's preflight setting: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("ConnectTunnel dialed the server instead of applying the tunnel's preflight setting")
+	}
+}
@@ -0,0 +1,88 @@
+package service
+
+import (
+	"log"
+	"net/url"
+	"skyport-agent/internal/mdns"
+	"strconv"
+	"sync"
+)
+
+// mdnsServiceTypes are the DNS-SD types each tunnel is advertised under:
+// generic web browsers for Bonjour and a SkyPort-specific type
+var mdnsServiceTypes = []string{"_http._tcp", "_skyport._tcp"}
+
+// lanAdvertiser publishes the public URLs of connected tunnels on the local
+// network so teammates and phones can find them with a Bonjour browser
+type lanAdvertiser struct {
+	manager   *Manager
+	mu        sync.Mutex
+	responder *mdns.Responder
+}
+
+func newLANAdvertiser(manager *Manager) *lanAdvertiser {
+	return &lanAdvertiser{manager: manager}
+}
+
+// refresh advertises exactly the currently connected tunnels
+func (la *lanAdvertiser) refresh() {
+	la.mu.Lock()
+	defer la.mu.Unlock()
+
+	if la.responder == nil {
+		responder, err := mdns.NewResponder()
+		if err != nil {
+			log.Printf("LAN advertisement disabled: %v", err)
+			return
+		}
+		la.responder = responder
+	}
+
+	tunnels, err := la.manager.GetTunnelList()
+	if err != nil {
+		return
+	}
+
+	var services []mdns.Service
+	for _, tunnel := range tunnels {
+		if !la.manager.IsTunnelConnected(tunnel.ID) {
+			continue
+		}
+
+		publicURL := publicURL(la.manager.config, tunnel.Subdomain)
+		parsed, err := url.Parse(publicURL)
+		if err != nil {
+			continue
+		}
+		host, port := parsed.Hostname(), parsed.Port()
+		if port == "" {
+			port = "80"
+			if parsed.Scheme == "https" {
+				port = "443"
+			}
+		}
+		portNumber, _ := strconv.Atoi(port)
+
+		for _, serviceType := range mdnsServiceTypes {
+			services = append(services, mdns.Service{
+				Instance: "skyport: " + tunnel.Name,
+				Type:     serviceType,
+				Host:     host,
+				Port:     portNumber,
+				Text:     []string{"url=" + publicURL, "path=/", "tunnel=" + tunnel.Name},
+			})
+		}
+	}
+	la.responder.SetServices(services)
+}
+
+// close withdraws all advertisements
+func (la *lanAdvertiser) close() {
+	la.mu.Lock()
+	defer la.mu.Unlock()
+
+	if la.responder != nil {
+		la.responder.Close()
+		la.responder = nil
+	}
+}
@@ -0,0 +1,47 @@
+//go:build darwin || freebsd || netbsd || openbsd
+
+package service
+
+import (
+	"context"
+	"syscall"
+)
+
+// watchNetworkEvents listens on a PF_ROUTE socket, which receives a message
+// for every interface, address and routing table change, and calls notify for
+// each one until ctx is cancelled
+func watchNetworkEvents(ctx context.Context, notify func()) error {
+	fd, err := syscall.Socket(syscall.AF_ROUTE, syscall.SOCK_RAW, syscall.AF_UNSPEC)
+	if err != nil {
+		return err
+	}
+	defer syscall.Close(fd)
+
+	// Wake up periodically so cancellation is noticed
+	timeout := syscall.Timeval{Sec: 1}
+	if err := syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &timeout); err != nil {
+		return err
+	}
+
+	buf := make([]byte, 8*1024)
+	for {
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		n, err := syscall.Read(fd, buf)
+		if err != nil {
+			if err == syscall.EAGAIN || err == syscall.EINTR {
+				continue
+			}
+			return err
+		}
+
+		messages, err := syscall.ParseRoutingMessage(buf[:n])
+		if err != nil || len(messages) == 0 {
+			continue
+		}
+
+		notify()
+	}
+}
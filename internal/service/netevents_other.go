@@ -0,0 +1,14 @@
+//go:build !linux && !windows && !darwin && !freebsd && !netbsd && !openbsd
+
+package service
+
+import (
+	"context"
+	"errors"
+)
+
+// watchNetworkEvents is not supported on this platform; the network monitor
+// falls back to polling
+func watchNetworkEvents(ctx context.Context, notify func()) error {
+	return errors.New("network change notifications not supported on this platform")
+}
@@ -0,0 +1,33 @@
+//go:build windows
+
+package service
+
+import (
+	"context"
+	"syscall"
+)
+
+var procNotifyAddrChange = syscall.NewLazyDLL("iphlpapi.dll").NewProc("NotifyAddrChange")
+
+// watchNetworkEvents blocks on NotifyAddrChange, which returns whenever an IPv4
+// address is added, removed or changed, and calls notify for each change.
+// The blocking call cannot be interrupted, so cancellation is noticed after
+// the next change.
+func watchNetworkEvents(ctx context.Context, notify func()) error {
+	if err := procNotifyAddrChange.Find(); err != nil {
+		return err
+	}
+
+	for {
+		// With NULL handle and overlapped arguments the call is synchronous
+		ret, _, _ := procNotifyAddrChange.Call(0, 0)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if ret != 0 {
+			return syscall.Errno(ret)
+		}
+
+		notify()
+	}
+}
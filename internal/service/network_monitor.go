@@ -5,12 +5,15 @@ import (
 	"fmt"
 	"log"
 	"net"
+	"skyport-agent/internal/config"
+	"skyport-agent/internal/crash"
 	"sync"
 	"time"
 )
 
 // NetworkMonitor detects network changes and triggers reconnections
 type NetworkMonitor struct {
+	config        *config.Config
 	ctx           context.Context
 	cancel        context.CancelFunc
 	mu            sync.RWMutex
@@ -30,10 +33,11 @@ type NetworkChange struct {
 }
 
 // NewNetworkMonitor creates a new network monitor
-func NewNetworkMonitor() *NetworkMonitor {
+func NewNetworkMonitor(cfg *config.Config) *NetworkMonitor {
 	ctx, cancel := context.WithCancel(context.Background())
 
 	return &NetworkMonitor{
+		config:     cfg,
 		ctx:        ctx,
 		cancel:     cancel,
 		changeChan: make(chan NetworkChange, 10),
@@ -54,8 +58,10 @@ func (nm *NetworkMonitor) Start() {
 	// Get initial network state
 	nm.updateNetworkState()
 
-	// Start monitoring goroutine
+	// Start monitoring goroutines - OS change notifications give near-instant
+	// detection, polling remains as a fallback for missed or unsupported events
 	go nm.monitorLoop()
+	go nm.eventLoop()
 
 	log.Println("Network monitor started")
 }
@@ -83,6 +89,8 @@ func (nm *NetworkMonitor) GetChangeChannel() <-chan NetworkChange {
 
 // monitorLoop continuously monitors network changes
 func (nm *NetworkMonitor) monitorLoop() {
+	defer crash.Recover("network monitor")
+
 	ticker := time.NewTicker(10 * time.Second)
 	defer ticker.Stop()
 
@@ -96,6 +104,41 @@ func (nm *NetworkMonitor) monitorLoop() {
 	}
 }
 
+// eventLoop reacts to OS network change notifications. Events arrive in bursts
+// (link down, address removed, address added, route added...) so checks are
+// debounced briefly to let the new state settle.
+func (nm *NetworkMonitor) eventLoop() {
+	defer crash.Recover("network events")
+
+	const settleDelay = 500 * time.Millisecond
+
+	events := make(chan struct{}, 1)
+	go func() {
+		err := watchNetworkEvents(nm.ctx, func() {
+			select {
+			case events <- struct{}{}:
+			default:
+			}
+		})
+		if err != nil {
+			log.Printf("Network change notifications unavailable, using polling only: %v", err)
+		}
+	}()
+
+	var settle <-chan time.Time
+	for {
+		select {
+		case <-nm.ctx.Done():
+			return
+		case <-events:
+			settle = time.After(settleDelay)
+		case <-settle:
+			settle = nil
+			nm.checkNetworkChanges()
+		}
+	}
+}
+
 // checkNetworkChanges checks for network changes
 func (nm *NetworkMonitor) checkNetworkChanges() {
 	nm.mu.Lock()
@@ -211,14 +254,10 @@ func (nm *NetworkMonitor) GetCurrentNetworkInfo() map[string]interface{} {
 	}
 }
 
-// TestConnectivity tests network connectivity to various endpoints
+// TestConnectivity tests network connectivity to the configured endpoints
+// (the SkyPort server unless connectivity.targets is set)
 func (nm *NetworkMonitor) TestConnectivity() map[string]bool {
-	endpoints := []string{
-		"google.com:80",
-		"github.com:443",
-		"1.1.1.1:53",
-		"8.8.8.8:53",
-	}
+	endpoints := nm.config.ConnectivityTargets()
 
 	results := make(map[string]bool)
 
@@ -0,0 +1,142 @@
+package service
+
+import (
+	"fmt"
+	"net"
+	"skyport-agent/internal/config"
+	"skyport-agent/internal/crash"
+	"skyport-agent/internal/logger"
+	"time"
+)
+
+const (
+	// portWatchInterval is how often a watched tunnel's local port is checked
+	portWatchInterval = time.Second
+
+	// portClosedChecks is how many checks in a row must find the port closed
+	// before the tunnel is disconnected, so one failed dial does not drop it
+	portClosedChecks = 2
+)
+
+// PortChange reports a watched tunnel being connected because its local port
+// opened, or disconnected because it closed
+type PortChange struct {
+	TunnelID  string
+	Address   string
+	Listening bool
+	Err       error // Connecting or disconnecting the tunnel failed
+}
+
+// WatchPort connects a tunnel only while something is listening on its local
+// target, and disconnects it when the port closes. The watch also reconnects
+// the tunnel if its connection drops, and ends when the manager shuts down or
+// the tunnel is stopped by its idle timeout or expiry. Changes are delivered on the
+// returned channel, which is closed when the watch ends; they are dropped when
+// it is not read.
+func (am *Manager) WatchPort(tunnelID string) (<-chan PortChange, error) {
+	appConfig, err := am.configManager.LoadConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	t, exists := appConfig.Tunnels[tunnelID]
+	if !exists {
+		return nil, fmt.Errorf("tunnel %s not found", tunnelID)
+	}
+	if t.StaticSettings().Dir != "" {
+		return nil, fmt.Errorf("tunnel %s serves a directory, not a local port", t.Name)
+	}
+
+	am.mutex.Lock()
+	if am.watched[tunnelID] {
+		am.mutex.Unlock()
+		return nil, fmt.Errorf("tunnel %s is already watched", t.Name)
+	}
+	am.watched[tunnelID] = true
+	am.mutex.Unlock()
+
+	changes := make(chan PortChange, 4)
+	go am.watchPort(t, changes)
+	return changes, nil
+}
+
+// isWatched reports whether a tunnel is connected and disconnected by a port
+// watch, so it is left alone by auto-connect and health checks
+func (am *Manager) isWatched(tunnelID string) bool {
+	am.mutex.RLock()
+	defer am.mutex.RUnlock()
+
+	return am.watched[tunnelID]
+}
+
+// watchPort polls a tunnel's local port until the manager stops
+func (am *Manager) watchPort(t *config.Tunnel, changes chan<- PortChange) {
+	defer crash.Recover("port watch")
+	defer func() {
+		am.mutex.Lock()
+		delete(am.watched, t.ID)
+		am.mutex.Unlock()
+		close(changes)
+	}()
+
+	ticker := time.NewTicker(portWatchInterval)
+	defer ticker.Stop()
+
+	address := t.LocalAddress()
+	report := func(change PortChange) {
+		change.TunnelID, change.Address = t.ID, address
+		select {
+		case changes <- change:
+		default:
+		}
+	}
+
+	closedChecks := 0
+	connectFailed := false
+	for {
+		if am.tunnelManager.IsStopped(t.ID) || t.Expired() {
+			return
+		}
+
+		// Follow the target if it was switched while the tunnel runs
+		if live := am.tunnelManager.TargetAddress(t.ID); live != "" {
+			address = live
+		}
+		up := am.tunnelManager.IsConnected(t.ID) || am.tunnelManager.IsSupervised(t.ID)
+		if portListening(address) {
+			closedChecks = 0
+			if !up {
+				logger.Debug("Local port %s for tunnel %s is open, connecting", address, t.Name)
+				err := am.ConnectTunnel(t.ID, false)
+				// A tunnel that keeps failing to connect is reported once
+				if err == nil || !connectFailed {
+					report(PortChange{Listening: true, Err: err})
+				}
+				connectFailed = err != nil
+			}
+		} else if up {
+			closedChecks++
+			if closedChecks >= portClosedChecks {
+				closedChecks = 0
+				logger.Info("Local port %s for tunnel %s closed, disconnecting until it is back", address, t.Name)
+				report(PortChange{Listening: false, Err: am.DisconnectTunnel(t.ID)})
+			}
+		}
+
+		select {
+		case <-am.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// portListening reports whether something accepts TCP connections on address
+func portListening(address string) bool {
+	conn, err := net.DialTimeout("tcp", address, portWatchInterval/2)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
@@ -0,0 +1,68 @@
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"skyport-agent/internal/config"
+	"time"
+)
+
+// ProbeHeader marks synthetic probe requests so they can be told apart from
+// real traffic by the local service and the agent
+const ProbeHeader = "X-Skyport-Probe"
+
+// ProbeResult is the outcome of the most recent end-to-end probe for a tunnel
+type ProbeResult struct {
+	URL       string        `json:"url"`
+	Serving   bool          `json:"serving"`
+	Status    int           `json:"status,omitempty"`
+	Error     string        `json:"error,omitempty"`
+	Latency   time.Duration `json:"latency"`
+	CheckedAt time.Time     `json:"checked_at"`
+}
+
+// publicURL builds the public URL for a tunnel subdomain using the probe scheme
+func publicURL(cfg *config.Config, subdomain string) string {
+	return fmt.Sprintf("%s://%s.%s", cfg.GetSettings().Probes.Scheme, subdomain, cfg.TunnelDomain)
+}
+
+// probeTunnel issues a request through the public URL of a tunnel and reports
+// whether the tunnel is actually serving traffic. Any response from the local
+// service counts as serving; gateway errors mean the server could not reach
+// the agent or the agent could not reach the local service.
+func probeTunnel(cfg *config.Config, subdomain string) *ProbeResult {
+	settings := cfg.GetSettings().Probes
+	result := &ProbeResult{
+		URL:       publicURL(cfg, subdomain) + settings.Path,
+		CheckedAt: time.Now(),
+	}
+
+	req, err := http.NewRequest(http.MethodGet, result.URL, nil)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	req.Header.Set(ProbeHeader, "1")
+
+	// Public URLs are served by the SkyPort server, so its TLS settings apply
+	client := cfg.HTTPClient(settings.Timeout.Duration)
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	result.Latency = time.Since(start)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	defer resp.Body.Close()
+
+	result.Status = resp.StatusCode
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		result.Error = fmt.Sprintf("public URL returned %s", resp.Status)
+	default:
+		result.Serving = true
+	}
+
+	return result
+}
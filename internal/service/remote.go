@@ -0,0 +1,141 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"skyport-agent/internal/auth"
+	"skyport-agent/internal/config"
+	"skyport-agent/internal/journal"
+	"skyport-agent/internal/logger"
+	"skyport-agent/internal/tunnel"
+)
+
+// Diagnostics is what the collect_diagnostics command sends back: the
+// heartbeat's view of the agent plus its recent log lines
+type Diagnostics struct {
+	Heartbeat  *auth.Heartbeat `json:"heartbeat"`
+	Reconnects map[string]int  `json:"reconnecting,omitempty"` // Attempts per tunnel being retried
+	Logs       []string        `json:"logs"`
+}
+
+// handleRemoteCommand runs a command sent by the server, if the local
+// remote_commands settings allow it. Every command is logged and journaled,
+// whether it ran or not.
+func (am *Manager) handleRemoteCommand(command tunnel.Command) tunnel.CommandResult {
+	result := am.runRemoteCommand(command)
+
+	outcome := "done"
+	if result.Error != "" {
+		outcome = result.Error
+	}
+	logger.Info("Server command %s %s (via %s): %s", command.Name, command.Tunnel, command.Via, outcome)
+	journal.Record(journal.Entry{
+		Type:   journal.EventRemoteCommand,
+		Tunnel: command.Tunnel,
+		Reason: fmt.Sprintf("%s: %s", command.Name, outcome),
+	})
+	return result
+}
+
+func (am *Manager) runRemoteCommand(command tunnel.Command) tunnel.CommandResult {
+	policy := am.config.GetSettings().RemoteCommands
+	if !policy.Allows(command.Name) {
+		return refused(http.StatusForbidden, "%s is not allowed by remote_commands.allow in this agent's settings", command.Name)
+	}
+
+	switch command.Name {
+	case config.RemoteStartTunnel, config.RemoteStopTunnel:
+		t, err := am.remoteCommandTunnel(command.Tunnel)
+		if err != nil {
+			return refused(http.StatusNotFound, "%v", err)
+		}
+		if !policy.AllowsTunnel(t) {
+			return refused(http.StatusForbidden, "tunnel %s is not in remote_commands.tunnels in this agent's settings", t.Name)
+		}
+		if command.Name == config.RemoteStartTunnel {
+			return am.remoteStart(t)
+		}
+		return am.remoteStop(t)
+
+	case config.RemoteRefreshConfig:
+		if err := am.SyncTunnelsFromServer(); err != nil {
+			return refused(http.StatusBadGateway, "%v", err)
+		}
+		am.configManager.InvalidateTunnelCache()
+		return tunnel.CommandResult{Status: http.StatusOK}
+
+	case config.RemoteCollectDiagnostics:
+		body, err := json.Marshal(Diagnostics{
+			Heartbeat:  am.heartbeat(),
+			Reconnects: am.GetReconnectingTunnels(),
+			Logs:       logger.Recent(),
+		})
+		if err != nil {
+			return refused(http.StatusInternalServerError, "%v", err)
+		}
+		return tunnel.CommandResult{Status: http.StatusOK, Body: body}
+
+	default:
+		return refused(http.StatusNotImplemented, "unknown command %q", command.Name)
+	}
+}
+
+// remoteCommandTunnel finds the tunnel a command acts on by name or ID,
+// syncing from the server first if it is not known locally
+func (am *Manager) remoteCommandTunnel(nameOrID string) (*config.Tunnel, error) {
+	if nameOrID == "" {
+		return nil, fmt.Errorf("no tunnel given")
+	}
+	for attempt := 0; attempt < 2; attempt++ {
+		appConfig, err := am.configManager.LoadConfig()
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range appConfig.Tunnels {
+			if t.ID == nameOrID || t.Name == nameOrID {
+				return t, nil
+			}
+		}
+		if attempt == 0 {
+			if err := am.SyncTunnelsFromServer(); err != nil {
+				return nil, fmt.Errorf("tunnel %s not found: %w", nameOrID, err)
+			}
+		}
+	}
+	return nil, fmt.Errorf("tunnel %s not found", nameOrID)
+}
+
+// remoteStart connects a tunnel, reconnecting it automatically if it
+// auto-starts
+func (am *Manager) remoteStart(t *config.Tunnel) tunnel.CommandResult {
+	if am.tunnelManager.IsConnected(t.ID) {
+		return tunnel.CommandResult{Status: http.StatusOK}
+	}
+	if err := am.ConnectTunnel(t.ID, t.AutoStart); err != nil {
+		return refused(http.StatusBadGateway, "%v", err)
+	}
+	return tunnel.CommandResult{Status: http.StatusOK}
+}
+
+// remoteStop disconnects a tunnel. It stays down, even if it auto-starts,
+// until it is started again. Like a local stop, it is refused when the login
+// token is read-only.
+func (am *Manager) remoteStop(t *config.Tunnel) tunnel.CommandResult {
+	if am.IsReadOnly() {
+		return refused(http.StatusForbidden, "%v: cannot stop tunnel %s", auth.ErrReadOnlyToken, t.Name)
+	}
+	if !am.tunnelManager.IsConnected(t.ID) {
+		return tunnel.CommandResult{Status: http.StatusOK}
+	}
+	if err := am.tunnelManager.StopTunnel(t.ID, "stopped by a server command"); err != nil {
+		return refused(http.StatusInternalServerError, "%v", err)
+	}
+	am.configManager.SetTunnelActive(t.ID, false)
+	return tunnel.CommandResult{Status: http.StatusOK}
+}
+
+// refused is a failed command's result
+func refused(status int, format string, args ...interface{}) tunnel.CommandResult {
+	return tunnel.CommandResult{Status: status, Error: fmt.Sprintf(format, args...)}
+}
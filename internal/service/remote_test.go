@@ -0,0 +1,19 @@
+package service
+
+import (
+	"net/http"
+	"skyport-agent/internal/config"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestRemoteStopRefusedWithReadOnlyToken(t *testing.T) {
+	login(t, jwt.MapClaims{"type": "agent", "read_only": true})
+
+	manager := NewManager(config.Load())
+	result := manager.remoteStop(&config.Tunnel{ID: "t1", Name: "myapp"})
+	if result.Status != http.StatusForbidden {
+		t.Fatalf("stop_tunnel with a read-only token returned %d (%q), want %d", result.Status, result.Error, http.StatusForbidden)
+	}
+}
@@ -0,0 +1,84 @@
+package service
+
+import (
+	"fmt"
+	"skyport-agent/internal/config"
+	"skyport-agent/internal/crash"
+	"skyport-agent/internal/logger"
+	"skyport-agent/internal/tunnel"
+	"strings"
+)
+
+// applyServerUpdate fetches a tunnel changed in the dashboard and reconnects
+// it if the change affects its live connection
+func (am *Manager) applyServerUpdate(event tunnel.Event) {
+	defer crash.Recover("tunnel update")
+
+	if err := am.SyncTunnelsFromServer(); err != nil {
+		logger.Warning("Tunnel %s was changed on the server, but fetching the change failed: %v", event.TunnelName, err)
+		return
+	}
+	am.configManager.InvalidateTunnelCache()
+
+	appConfig, err := am.configManager.LoadConfig()
+	if err != nil {
+		return
+	}
+	updated, exists := appConfig.Tunnels[event.TunnelID]
+	if !exists {
+		return
+	}
+	live, connected := am.tunnelManager.ConnectedTunnel(event.TunnelID)
+	if !connected {
+		return
+	}
+
+	changes := tunnelChanges(&live, updated)
+	if len(changes) == 0 {
+		logger.Debug("Update of tunnel %s does not affect its connection", updated.Name)
+		return
+	}
+	logger.Info("Tunnel %s was changed on the server (%s), reconnecting", live.Name, strings.Join(changes, ", "))
+
+	token, err := am.authManager.GetValidToken()
+	if err != nil {
+		logger.Warning("Failed to reconnect tunnel %s: %v", updated.Name, err)
+		return
+	}
+	if err := am.tunnelManager.UpdateTunnel(updated, token); err != nil {
+		logger.Warning("Failed to reconnect tunnel %s: %v", updated.Name, err)
+		am.configManager.SetTunnelActive(updated.ID, false)
+		return
+	}
+	am.configManager.SetTunnelActive(updated.ID, true)
+	if updated.Subdomain != live.Subdomain {
+		logger.Info("Tunnel %s is now at http://%s.%s", updated.Name, updated.Subdomain, am.config.TunnelDomain)
+	}
+}
+
+// tunnelChanges describes the changes that need a tunnel to reconnect
+func tunnelChanges(live, updated *config.Tunnel) []string {
+	var changes []string
+	if updated.Name != live.Name {
+		changes = append(changes, fmt.Sprintf("renamed to %s", updated.Name))
+	}
+	if updated.Subdomain != live.Subdomain {
+		changes = append(changes, fmt.Sprintf("subdomain %s %s %s", live.Subdomain, logger.Arrow(), updated.Subdomain))
+	}
+	if updated.LocalAddress() != live.LocalAddress() {
+		changes = append(changes, fmt.Sprintf("local target %s %s %s", live.LocalAddress(), logger.Arrow(), updated.LocalAddress()))
+	}
+	if updated.AuthToken != live.AuthToken {
+		changes = append(changes, "new tunnel token")
+	}
+	return changes
+}
+
+// forgetDeletedTunnel removes a tunnel deleted on the server from the local
+// config, so it is not started again
+func (am *Manager) forgetDeletedTunnel(event tunnel.Event) {
+	am.configManager.InvalidateTunnelCache()
+	if err := am.configManager.RemoveTunnel(event.TunnelID); err != nil {
+		logger.Debug("Failed to remove deleted tunnel %s: %v", event.TunnelName, err)
+	}
+}
@@ -0,0 +1,96 @@
+package service
+
+import (
+	"context"
+	"log"
+	"skyport-agent/internal/crash"
+	"sync"
+	"time"
+)
+
+const (
+	// sleepCheckInterval is how often the sleep monitor samples the clock
+	sleepCheckInterval = 5 * time.Second
+	// sleepJumpThreshold is how far the wall clock must run ahead of the
+	// check interval before a suspend is assumed
+	sleepJumpThreshold = 15 * time.Second
+)
+
+// SleepMonitor detects system suspend/resume using a clock jump heuristic.
+// The monotonic clock (used by tickers) stops while the machine sleeps but the
+// wall clock keeps going, so after a resume the wall time between two ticks is
+// much larger than the tick interval. This works on every platform without OS
+// specific power notifications.
+type SleepMonitor struct {
+	ctx      context.Context
+	cancel   context.CancelFunc
+	mu       sync.Mutex
+	running  bool
+	onResume func(sleptFor time.Duration)
+}
+
+// NewSleepMonitor creates a sleep monitor that calls onResume after each wake-up
+func NewSleepMonitor(onResume func(sleptFor time.Duration)) *SleepMonitor {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &SleepMonitor{
+		ctx:      ctx,
+		cancel:   cancel,
+		onResume: onResume,
+	}
+}
+
+// Start begins watching for suspend/resume
+func (sm *SleepMonitor) Start() {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	if sm.running {
+		return
+	}
+	sm.running = true
+
+	go sm.monitorLoop()
+}
+
+// Stop stops watching for suspend/resume
+func (sm *SleepMonitor) Stop() {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	if !sm.running {
+		return
+	}
+	sm.running = false
+	sm.cancel()
+}
+
+// monitorLoop compares wall clock progress against the ticker interval
+func (sm *SleepMonitor) monitorLoop() {
+	defer crash.Recover("sleep monitor")
+
+	ticker := time.NewTicker(sleepCheckInterval)
+	defer ticker.Stop()
+
+	// Round(0) strips the monotonic reading so Sub uses wall clock time
+	last := time.Now().Round(0)
+
+	for {
+		select {
+		case <-sm.ctx.Done():
+			return
+		case <-ticker.C:
+			now := time.Now().Round(0)
+			elapsed := now.Sub(last)
+			last = now
+
+			if elapsed > sleepCheckInterval+sleepJumpThreshold {
+				sleptFor := elapsed - sleepCheckInterval
+				log.Printf("System resume detected (asleep for ~%v)", sleptFor.Round(time.Second))
+				if sm.onResume != nil {
+					go sm.onResume(sleptFor)
+				}
+			}
+		}
+	}
+}
@@ -4,52 +4,173 @@ import (
 	"fmt"
 	"os"
 	"os/exec"
+	"os/user"
 	"path/filepath"
+	"skyport-agent/internal/config"
+	"sort"
 	"strconv"
 	"strings"
 )
 
+// unitDir is where systemd looks for system units
+const unitDir = "/etc/systemd/system"
+
 // SystemdService manages systemd service integration
 type SystemdService struct {
 	serviceName string
 	user        string
+	group       string
+	uid         string
 	execPath    string
 	configPath  string
+	allowRoot   bool
+	// template replaces the unit template from the settings, if set
+	template string
 }
 
-// NewSystemdService creates a new systemd service manager
+// NewSystemdService creates a new systemd service manager. The daemon runs as
+// the user who invoked sudo, or as the current user without sudo, with that
+// user's own home directory.
 func NewSystemdService() *SystemdService {
-	// Get the actual user, even when running with sudo
-	user := os.Getenv("SUDO_USER")
-	if user == "" {
-		user = os.Getenv("USER")
+	account, err := user.Current()
+	if sudoUser := os.Getenv("SUDO_USER"); sudoUser != "" && os.Geteuid() == 0 {
+		// SUDO_USER is only trusted when sudo actually made us root
+		if invoker, lookupErr := user.Lookup(sudoUser); lookupErr == nil {
+			account, err = invoker, nil
+		}
 	}
-	if user == "" || user == "root" {
-		user = "root"
+	if err != nil {
+		home, _ := os.UserHomeDir()
+		account = &user.User{Username: os.Getenv("USER"), Uid: strconv.Itoa(os.Geteuid()), Gid: strconv.Itoa(os.Getegid()), HomeDir: home}
 	}
 
-	execPath, _ := os.Executable()
+	return newSystemdService(account, baseServiceName())
+}
+
+// NewUserService creates the service manager for one user's agent on a
+// shared machine, named skyport-agent@<user>. Several users can each have
+// one: every daemon runs as its user with that user's own login and tunnels
+// from their ~/.skyport, so no daemon holds another user's credentials.
+func NewUserService(username string) (*SystemdService, error) {
+	account, err := user.Lookup(username)
+	if err != nil {
+		return nil, fmt.Errorf("unknown user %s: %w", username, err)
+	}
+	return newSystemdService(account, baseServiceName()+"@"+account.Username), nil
+}
+
+// UserServices returns the per-user services installed on this machine,
+// sorted by user
+func UserServices() ([]*SystemdService, error) {
+	prefix := baseServiceName() + "@"
+	paths, err := filepath.Glob(filepath.Join(unitDir, prefix+"*.service"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+
+	var services []*SystemdService
+	for _, path := range paths {
+		username := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(path), prefix), ".service")
+		s, err := NewUserService(username)
+		if err != nil {
+			// The account is gone, but its service can still be stopped and removed
+			s = newSystemdService(&user.User{Username: username}, prefix+username)
+		}
+		services = append(services, s)
+	}
+	return services, nil
+}
+
+// baseServiceName is the service name of the agent instance in use. Each
+// instance is its own service.
+func baseServiceName() string {
+	serviceName := "skyport-agent"
+	if instance := config.Instance(); instance != "" {
+		serviceName += "-" + instance
+	}
+	return serviceName
+}
 
-	// Get home directory for the actual user (not root)
-	var configPath string
-	if user != "root" {
-		configPath = filepath.Join("/home", user, ".skyport")
-	} else {
-		configPath = filepath.Join(os.Getenv("HOME"), ".skyport")
+// newSystemdService creates the service manager for a daemon running as account
+func newSystemdService(account *user.User, serviceName string) *SystemdService {
+	group := account.Username
+	if primary, err := user.LookupGroupId(account.Gid); err == nil {
+		group = primary.Name
 	}
 
+	execPath, _ := os.Executable()
+
 	return &SystemdService{
-		serviceName: "skyport-agent",
-		user:        user,
+		serviceName: serviceName,
+		user:        account.Username,
+		group:       group,
+		uid:         account.Uid,
 		execPath:    execPath,
-		configPath:  configPath,
+		configPath:  config.ConfigDirFor(account.HomeDir, config.Instance()),
 	}
 }
 
+// SetAllowRoot lets the installed daemon run as root, which it otherwise
+// refuses to do
+func (s *SystemdService) SetAllowRoot(allow bool) {
+	s.allowRoot = allow
+}
+
+// SetTemplate renders the unit from a text/template file instead of the one
+// in the settings or the built-in unit
+func (s *SystemdService) SetTemplate(path string) {
+	s.template = path
+}
+
+// Name is the systemd service name
+func (s *SystemdService) Name() string {
+	return s.serviceName
+}
+
+// User is the account the daemon runs as
+func (s *SystemdService) User() string {
+	return s.user
+}
+
+// RunsAsRoot reports whether the daemon would run as root
+func (s *SystemdService) RunsAsRoot() bool {
+	return s.uid == "0"
+}
+
+// ConfigPath is the directory the daemon reads its login and tunnels from
+func (s *SystemdService) ConfigPath() string {
+	return s.configPath
+}
+
+// UnitPath is where the unit file is written
+func (s *SystemdService) UnitPath() string {
+	return filepath.Join(unitDir, s.serviceName+".service")
+}
+
+// CheckInstall reports why the service cannot be installed as configured:
+// writing the unit needs root, and the daemon itself should not run as root
+func (s *SystemdService) CheckInstall() error {
+	if os.Geteuid() != 0 {
+		return fmt.Errorf("writing %s requires root; run 'sudo skyport service install'", s.UnitPath())
+	}
+	if s.RunsAsRoot() && !s.allowRoot {
+		return fmt.Errorf("the daemon would run as root; run 'sudo skyport service install' from your own account so it runs as you, or pass --allow-root")
+	}
+	return nil
+}
+
 // Install installs the systemd service
 func (s *SystemdService) Install() error {
-	serviceContent := s.generateServiceFile()
-	servicePath := fmt.Sprintf("/etc/systemd/system/%s.service", s.serviceName)
+	if err := s.CheckInstall(); err != nil {
+		return err
+	}
+
+	serviceContent, err := s.UnitFile()
+	if err != nil {
+		return err
+	}
+	servicePath := s.UnitPath()
 
 	// Write service file
 	if err := os.WriteFile(servicePath, []byte(serviceContent), 0644); err != nil {
@@ -78,8 +199,7 @@ func (s *SystemdService) Uninstall() error {
 	exec.Command("systemctl", "disable", s.serviceName).Run()
 
 	// Remove service file
-	servicePath := fmt.Sprintf("/etc/systemd/system/%s.service", s.serviceName)
-	os.Remove(servicePath)
+	os.Remove(s.UnitPath())
 
 	// Reload systemd
 	exec.Command("systemctl", "daemon-reload").Run()
@@ -106,16 +226,27 @@ func (s *SystemdService) Restart() error {
 func (s *SystemdService) Status() (string, error) {
 	cmd := exec.Command("systemctl", "is-active", s.serviceName)
 	output, err := cmd.Output()
+	// is-active fails for every state but active, and still prints it
+	status := strings.TrimSpace(string(output))
+	if status == "" {
+		status = "inactive"
+	}
+	return status, err
+}
+
+// MainPID returns the PID of the service's daemon, or 0 if it is not running
+func (s *SystemdService) MainPID() int {
+	output, err := exec.Command("systemctl", "show", "--property=MainPID", "--value", s.serviceName).Output()
 	if err != nil {
-		return "inactive", err
+		return 0
 	}
-	return strings.TrimSpace(string(output)), nil
+	pid, _ := strconv.Atoi(strings.TrimSpace(string(output)))
+	return pid
 }
 
 // IsInstalled checks if the service is installed
 func (s *SystemdService) IsInstalled() bool {
-	servicePath := fmt.Sprintf("/etc/systemd/system/%s.service", s.serviceName)
-	_, err := os.Stat(servicePath)
+	_, err := os.Stat(s.UnitPath())
 	return err == nil
 }
 
@@ -134,41 +265,3 @@ func (s *SystemdService) GetLogs(lines int) (string, error) {
 	}
 	return string(output), nil
 }
-
-// generateServiceFile generates the systemd service file content
-func (s *SystemdService) generateServiceFile() string {
-	return fmt.Sprintf(`[Unit]
-Description=SkyPort Agent - Secure tunnel client
-Documentation=https://github.com/your-org/skyport
-After=network-online.target
-Wants=network-online.target
-
-[Service]
-Type=simple
-User=%s
-Group=%s
-ExecStart=%s daemon
-ExecReload=/bin/kill -HUP $MAINPID
-Restart=always
-RestartSec=5
-StartLimitInterval=60s
-StartLimitBurst=3
-
-# Environment
-Environment=SKYPORT_CONFIG_DIR=%s
-Environment=SKYPORT_LOG_LEVEL=info
-
-# Security
-NoNewPrivileges=true
-PrivateTmp=true
-ReadWritePaths=%s
-
-# Logging
-StandardOutput=journal
-StandardError=journal
-SyslogIdentifier=skyport-agent
-
-[Install]
-WantedBy=multi-user.target
-`, s.user, s.user, s.execPath, s.configPath, s.configPath)
-}
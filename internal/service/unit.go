@@ -0,0 +1,163 @@
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"skyport-agent/internal/config"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// defaultUnitTemplate is the unit written when no template is configured
+const defaultUnitTemplate = `[Unit]
+Description=SkyPort Agent - Secure tunnel client
+Documentation=https://github.com/your-org/skyport
+After={{join .After " "}}
+Wants=network-online.target
+
+[Service]
+Type=simple
+User={{.User}}
+Group={{.Group}}
+ExecStart={{.ExecStart}}
+ExecReload=/bin/kill -HUP $MAINPID
+Restart={{.Restart}}
+RestartSec=5
+StartLimitInterval=60s
+StartLimitBurst=3
+{{- if .Nice}}
+Nice={{.Nice}}
+{{- end}}
+
+# Environment
+{{- range .Environment}}
+Environment={{.}}
+{{- end}}
+
+# Security
+NoNewPrivileges=true
+PrivateTmp=true
+ReadWritePaths={{.ConfigDir}}
+{{- if or .MemoryMax .CPUQuota}}
+
+# Resource limits
+{{- if .MemoryMax}}
+MemoryMax={{.MemoryMax}}
+{{- end}}
+{{- if .CPUQuota}}
+CPUQuota={{.CPUQuota}}
+{{- end}}
+{{- end}}
+
+# Logging
+StandardOutput=journal
+StandardError=journal
+SyslogIdentifier={{.Name}}
+
+[Install]
+WantedBy=multi-user.target
+`
+
+// UnitData is what unit templates are rendered with
+type UnitData struct {
+	Name        string   // Service name: skyport-agent, or skyport-agent-<instance>
+	User        string   // Account the daemon runs as
+	Group       string   // The account's primary group
+	ExecStart   string   // Command line that starts the daemon
+	ConfigDir   string   // The instance's config directory, e.g. ~/.skyport
+	After       []string // Units the agent starts after
+	Restart     string   // Restart= policy
+	Nice        int      // Scheduling priority; 0 leaves the default
+	Environment []string // Quoted KEY=value assignments, sorted by key
+	MemoryMax   string   // MemoryMax=, or empty
+	CPUQuota    string   // CPUQuota=, or empty
+}
+
+// UnitFile renders the systemd unit from the settings of the user the daemon
+// runs as, with their template if they configured one
+func (s *SystemdService) UnitFile() (string, error) {
+	settings, err := config.NewConfigManagerAt(s.configPath).LoadSettings()
+	if err != nil {
+		return "", err
+	}
+
+	text := defaultUnitTemplate
+	templatePath := s.template
+	if templatePath == "" {
+		templatePath = settings.Service.Template
+		// Relative paths in the settings are relative to the config directory
+		if templatePath != "" && !filepath.IsAbs(templatePath) {
+			templatePath = filepath.Join(s.configPath, templatePath)
+		}
+	}
+	if templatePath != "" {
+		data, err := os.ReadFile(templatePath)
+		if err != nil {
+			return "", fmt.Errorf("failed to read unit template: %w", err)
+		}
+		text = string(data)
+	}
+
+	tmpl, err := template.New("unit").Funcs(template.FuncMap{"join": strings.Join}).Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("invalid unit template %s: %w", templatePath, err)
+	}
+
+	var unit bytes.Buffer
+	if err := tmpl.Execute(&unit, s.unitData(settings)); err != nil {
+		return "", fmt.Errorf("failed to render unit template %s: %w", templatePath, err)
+	}
+	return unit.String(), nil
+}
+
+// unitData collects the values the unit is rendered with
+func (s *SystemdService) unitData(settings *config.Settings) UnitData {
+	execStart := s.execPath + " daemon"
+	if s.allowRoot {
+		execStart += " --allow-root"
+	}
+
+	environment := map[string]string{
+		"SKYPORT_CONFIG_DIR": s.configPath,
+		"SKYPORT_LOG_LEVEL":  "info",
+	}
+	if instance := config.Instance(); instance != "" {
+		execStart += " --instance " + instance
+		environment["SKYPORT_INSTANCE"] = instance
+	}
+	for key, value := range settings.Service.Environment {
+		environment[key] = value
+	}
+	keys := make([]string, 0, len(environment))
+	for key := range environment {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	data := UnitData{
+		Name:      s.serviceName,
+		User:      s.user,
+		Group:     s.group,
+		ExecStart: execStart,
+		ConfigDir: s.configPath,
+		After:     append([]string{"network-online.target"}, settings.Service.After...),
+		Restart:   settings.Service.Restart,
+		Nice:      settings.Service.Nice,
+		MemoryMax: settings.Resources.MemoryMax,
+		CPUQuota:  settings.Resources.CPUQuota,
+	}
+	for _, key := range keys {
+		data.Environment = append(data.Environment, quoteUnitValue(key+"="+environment[key]))
+	}
+	return data
+}
+
+// quoteUnitValue quotes an Environment= assignment, escaping the characters
+// systemd would otherwise interpret
+func quoteUnitValue(value string) string {
+	return strings.ReplaceAll(strconv.Quote(value), "%", "%%")
+}
@@ -0,0 +1,125 @@
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// BackgroundProcess records a detached process started by
+// `skyport tunnel run --background`, so that it can be found again without
+// searching the process list
+type BackgroundProcess struct {
+	PID        int       `json:"pid"`
+	TunnelID   string    `json:"tunnel_id"`
+	TunnelName string    `json:"tunnel_name"`
+	StartedAt  time.Time `json:"started_at"`
+	LogFile    string    `json:"log_file,omitempty"`
+}
+
+const processPrefix = "background-"
+
+func processPath(tunnelID string) (string, error) {
+	dir, err := Dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, processPrefix+tunnelID+".json"), nil
+}
+
+// RegisterProcess records a background process, replacing any previous
+// record for the same tunnel
+func RegisterProcess(p BackgroundProcess) error {
+	path, err := processPath(p.TunnelID)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal process record: %w", err)
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write process record: %w", err)
+	}
+	return os.Rename(tmp, path)
+}
+
+// FindProcess returns the background process recorded for a tunnel, or nil
+// if there is none. The process may have exited since it was recorded.
+func FindProcess(tunnelID string) (*BackgroundProcess, error) {
+	path, err := processPath(tunnelID)
+	if err != nil {
+		return nil, err
+	}
+	return readProcess(path)
+}
+
+// BackgroundProcesses returns all recorded background processes, oldest first
+func BackgroundProcesses() ([]BackgroundProcess, error) {
+	dir, err := Dir()
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var processes []BackgroundProcess
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.Name(), processPrefix) || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		p, err := readProcess(filepath.Join(dir, entry.Name()))
+		if err != nil || p == nil {
+			continue
+		}
+		processes = append(processes, *p)
+	}
+
+	sort.Slice(processes, func(i, j int) bool { return processes[i].StartedAt.Before(processes[j].StartedAt) })
+	return processes, nil
+}
+
+func readProcess(path string) (*BackgroundProcess, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var p BackgroundProcess
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("failed to parse process record: %w", err)
+	}
+	return &p, nil
+}
+
+// UnregisterProcess removes a tunnel's background process record if it
+// belongs to pid, so a process exiting late cannot remove its successor's
+func UnregisterProcess(tunnelID string, pid int) {
+	path, err := processPath(tunnelID)
+	if err != nil {
+		return
+	}
+	if p, err := readProcess(path); err == nil && p != nil && p.PID == pid {
+		os.Remove(path)
+	}
+}
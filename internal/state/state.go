@@ -0,0 +1,113 @@
+// Package state shares runtime information between the process running a
+// tunnel and CLI commands such as `skyport tunnel status`, which run in a
+// separate process. Each running tunnel publishes a small JSON file.
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"skyport-agent/internal/config"
+	"skyport-agent/internal/metrics"
+	"time"
+)
+
+// TunnelState is the runtime information published for a connected tunnel
+type TunnelState struct {
+	TunnelID   string    `json:"tunnel_id"`
+	TunnelName string    `json:"tunnel_name"`
+	PID        int       `json:"pid"`
+	Region     string    `json:"region,omitempty"` // Server endpoint the tunnel is connected to
+	UpdatedAt  time.Time `json:"updated_at"`
+	// StartedAt is the tunnel's first connection in this process and
+	// ConnectedAt its current one; they differ after reconnects
+	StartedAt   time.Time                 `json:"started_at"`
+	ConnectedAt time.Time                 `json:"connected_at"`
+	Reconnects  int64                     `json:"reconnects"`
+	Features    []string                  `json:"features,omitempty"` // Protocol features negotiated with the server
+	Forwarding  metrics.Percentiles       `json:"forwarding_latency"` // Local service request time
+	RoundTrip   metrics.Percentiles       `json:"round_trip_time"`    // WebSocket ping/pong RTT to the server
+	Errors      metrics.ErrorSummary      `json:"errors"`
+	Quality     metrics.ConnectionQuality `json:"connection_quality"`
+	Denied      map[string]int64          `json:"denied,omitempty"`   // Requests rejected by each header filter rule
+	Sessions    int64                     `json:"websocket_sessions"` // Open proxied WebSocket sessions
+}
+
+// Dir returns the directory runtime state files are kept in
+func Dir() (string, error) {
+	configDir, err := config.GetConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return DirIn(configDir), nil
+}
+
+// DirIn returns the runtime state directory of the agent with the given
+// config directory
+func DirIn(configDir string) string {
+	return filepath.Join(configDir, "run")
+}
+
+func tunnelPath(tunnelID string) (string, error) {
+	dir, err := Dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "tunnel-"+tunnelID+".json"), nil
+}
+
+// WriteTunnel publishes a tunnel's state, replacing the previous one atomically
+func WriteTunnel(ts TunnelState) error {
+	path, err := tunnelPath(ts.TunnelID)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+
+	data, err := json.MarshalIndent(ts, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal tunnel state: %w", err)
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write tunnel state: %w", err)
+	}
+	return os.Rename(tmp, path)
+}
+
+// ReadTunnel returns a tunnel's published state. States older than maxAge are
+// treated as missing, since the process that wrote them may have died.
+func ReadTunnel(tunnelID string, maxAge time.Duration) (*TunnelState, error) {
+	path, err := tunnelPath(tunnelID)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var ts TunnelState
+	if err := json.Unmarshal(data, &ts); err != nil {
+		return nil, fmt.Errorf("failed to parse tunnel state: %w", err)
+	}
+
+	if time.Since(ts.UpdatedAt) > maxAge {
+		return nil, fmt.Errorf("tunnel state is stale (updated %v ago)", time.Since(ts.UpdatedAt).Round(time.Second))
+	}
+
+	return &ts, nil
+}
+
+// RemoveTunnel deletes a tunnel's published state
+func RemoveTunnel(tunnelID string) {
+	if path, err := tunnelPath(tunnelID); err == nil {
+		os.Remove(path)
+	}
+}
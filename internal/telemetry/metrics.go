@@ -0,0 +1,177 @@
+package telemetry
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// durationBounds are the histogram bucket boundaries for request durations, in milliseconds
+var durationBounds = []float64{5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000}
+
+// Metric names
+const (
+	MetricRequests        = "skyport.proxy.requests"
+	MetricRequestDuration = "skyport.proxy.request.duration"
+	MetricRoundTrip       = "skyport.tunnel.round_trip"
+	MetricLostHeartbeats  = "skyport.tunnel.heartbeats.lost"
+	MetricWriteStalls     = "skyport.tunnel.write_stalls"
+)
+
+// counterUnits gives the unit of each counter
+var counterUnits = map[string]string{
+	MetricRequests:       "{request}",
+	MetricLostHeartbeats: "{heartbeat}",
+	MetricWriteStalls:    "{stall}",
+}
+
+type series struct {
+	attributes []keyValue
+	count      uint64
+	sum        float64
+	min, max   float64  // Histograms only
+	buckets    []uint64 // Histograms only
+}
+
+// observe adds a value in milliseconds to a histogram
+func (s *series) observe(ms float64) {
+	if s.count == 0 || ms < s.min {
+		s.min = ms
+	}
+	if s.count == 0 || ms > s.max {
+		s.max = ms
+	}
+	s.count++
+	s.sum += ms
+	s.buckets[sort.SearchFloat64s(durationBounds, ms)]++
+}
+
+// metricSet holds cumulative counters and histograms keyed by name and attributes
+type metricSet struct {
+	counters   map[string]map[string]*series
+	histograms map[string]map[string]*series
+}
+
+type metricSnapshot struct {
+	name      string
+	unit      string
+	histogram bool
+	series    []series
+}
+
+func newMetricSet() *metricSet {
+	return &metricSet{
+		counters:   make(map[string]map[string]*series),
+		histograms: make(map[string]map[string]*series),
+	}
+}
+
+// RecordRequest counts a proxied request and records its duration
+func (p *Provider) RecordRequest(tunnelName, method string, status int, duration time.Duration) {
+	if p == nil {
+		return
+	}
+
+	attrs := map[string]string{
+		"skyport.tunnel":            tunnelName,
+		"http.request.method":       method,
+		"http.response.status_code": strconv.Itoa(status),
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.metrics.series(p.metrics.counters, MetricRequests, attrs, false).count++
+
+	p.metrics.series(p.metrics.histograms, MetricRequestDuration, attrs, true).
+		observe(float64(duration.Microseconds()) / 1000)
+}
+
+// RecordRoundTrip records a heartbeat round trip to the tunnel server
+func (p *Provider) RecordRoundTrip(tunnelName string, rtt time.Duration) {
+	if p == nil {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.metrics.series(p.metrics.histograms, MetricRoundTrip, map[string]string{"skyport.tunnel": tunnelName}, true).
+		observe(float64(rtt.Microseconds()) / 1000)
+}
+
+// RecordLostHeartbeat counts a heartbeat the tunnel server did not answer in time
+func (p *Provider) RecordLostHeartbeat(tunnelName string) {
+	p.count(MetricLostHeartbeats, tunnelName)
+}
+
+// RecordWriteStall counts a write to the tunnel server that blocked
+func (p *Provider) RecordWriteStall(tunnelName string) {
+	p.count(MetricWriteStalls, tunnelName)
+}
+
+func (p *Provider) count(name, tunnelName string) {
+	if p == nil {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.metrics.series(p.metrics.counters, name, map[string]string{"skyport.tunnel": tunnelName}, false).count++
+}
+
+// series returns the series for a metric and attribute set, creating it if needed
+func (m *metricSet) series(metrics map[string]map[string]*series, name string, attrs map[string]string, histogram bool) *series {
+	keys := make([]string, 0, len(attrs))
+	for key := range attrs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var id strings.Builder
+	kvs := make([]keyValue, 0, len(keys))
+	for _, key := range keys {
+		id.WriteString(key + "=" + attrs[key] + ";")
+		kvs = append(kvs, attr(key, attrs[key]))
+	}
+
+	if metrics[name] == nil {
+		metrics[name] = make(map[string]*series)
+	}
+	s, exists := metrics[name][id.String()]
+	if !exists {
+		s = &series{attributes: kvs}
+		if histogram {
+			s.buckets = make([]uint64, len(durationBounds)+1)
+		}
+		metrics[name][id.String()] = s
+	}
+	return s
+}
+
+// snapshot copies the current values; must be called with the provider lock held
+func (m *metricSet) snapshot() []metricSnapshot {
+	var snapshots []metricSnapshot
+
+	for name, byAttrs := range m.counters {
+		snap := metricSnapshot{name: name, unit: counterUnits[name]}
+		for _, s := range byAttrs {
+			snap.series = append(snap.series, *s)
+		}
+		snapshots = append(snapshots, snap)
+	}
+
+	for name, byAttrs := range m.histograms {
+		snap := metricSnapshot{name: name, unit: "ms", histogram: true}
+		for _, s := range byAttrs {
+			copied := *s
+			copied.buckets = append([]uint64(nil), s.buckets...)
+			snap.series = append(snap.series, copied)
+		}
+		snapshots = append(snapshots, snap)
+	}
+
+	return snapshots
+}
@@ -0,0 +1,191 @@
+package telemetry
+
+import (
+	"encoding/hex"
+	"skyport-agent/internal/crash"
+	"strconv"
+	"time"
+)
+
+// The types below mirror the OTLP/JSON encoding of the OpenTelemetry protocol.
+// 64-bit integers are encoded as strings and IDs as hex, as the spec requires.
+
+type keyValue struct {
+	Key   string   `json:"key"`
+	Value anyValue `json:"value"`
+}
+
+type anyValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+}
+
+type resource struct {
+	Attributes []keyValue `json:"attributes"`
+}
+
+type scope struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID           string     `json:"traceId"`
+	SpanID            string     `json:"spanId"`
+	ParentSpanID      string     `json:"parentSpanId,omitempty"`
+	Name              string     `json:"name"`
+	Kind              int        `json:"kind"`
+	StartTimeUnixNano string     `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string     `json:"endTimeUnixNano"`
+	Attributes        []keyValue `json:"attributes,omitempty"`
+	Status            otlpStatus `json:"status"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"` // 0 unset, 2 error
+	Message string `json:"message,omitempty"`
+}
+
+type dataPoint struct {
+	Attributes        []keyValue `json:"attributes,omitempty"`
+	StartTimeUnixNano string     `json:"startTimeUnixNano"`
+	TimeUnixNano      string     `json:"timeUnixNano"`
+	AsInt             string     `json:"asInt,omitempty"`
+	Count             string     `json:"count,omitempty"`
+	Sum               *float64   `json:"sum,omitempty"`
+	Min               *float64   `json:"min,omitempty"`
+	Max               *float64   `json:"max,omitempty"`
+	BucketCounts      []string   `json:"bucketCounts,omitempty"`
+	ExplicitBounds    []float64  `json:"explicitBounds,omitempty"`
+}
+
+type otlpMetric struct {
+	Name      string         `json:"name"`
+	Unit      string         `json:"unit,omitempty"`
+	Sum       *otlpSum       `json:"sum,omitempty"`
+	Histogram *otlpHistogram `json:"histogram,omitempty"`
+}
+
+type otlpSum struct {
+	DataPoints             []dataPoint `json:"dataPoints"`
+	AggregationTemporality int         `json:"aggregationTemporality"`
+	IsMonotonic            bool        `json:"isMonotonic"`
+}
+
+type otlpHistogram struct {
+	DataPoints             []dataPoint `json:"dataPoints"`
+	AggregationTemporality int         `json:"aggregationTemporality"`
+}
+
+// aggregationCumulative is OTLP's AGGREGATION_TEMPORALITY_CUMULATIVE
+const aggregationCumulative = 2
+
+func attr(key string, value interface{}) keyValue {
+	kv := keyValue{Key: key}
+	switch v := value.(type) {
+	case string:
+		kv.Value.StringValue = &v
+	case int:
+		s := strconv.Itoa(v)
+		kv.Value.IntValue = &s
+	case int64:
+		s := strconv.FormatInt(v, 10)
+		kv.Value.IntValue = &s
+	case float64:
+		kv.Value.DoubleValue = &v
+	case bool:
+		kv.Value.BoolValue = &v
+	}
+	return kv
+}
+
+func unixNano(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+func (p *Provider) scope() scope {
+	return scope{Name: "skyport-agent", Version: crash.Version}
+}
+
+func (p *Provider) tracesPayload(spans []*Span) interface{} {
+	encoded := make([]otlpSpan, 0, len(spans))
+	for _, s := range spans {
+		span := otlpSpan{
+			TraceID:           hex.EncodeToString(s.context.TraceID[:]),
+			SpanID:            hex.EncodeToString(s.context.SpanID[:]),
+			Name:              s.name,
+			Kind:              s.kind,
+			StartTimeUnixNano: unixNano(s.start),
+			EndTimeUnixNano:   unixNano(s.end),
+			Attributes:        s.attributes,
+		}
+		if s.parentID != [8]byte{} {
+			span.ParentSpanID = hex.EncodeToString(s.parentID[:])
+		}
+		if s.errMessage != "" {
+			span.Status = otlpStatus{Code: 2, Message: s.errMessage}
+		}
+		encoded = append(encoded, span)
+	}
+
+	return map[string]interface{}{
+		"resourceSpans": []interface{}{
+			map[string]interface{}{
+				"resource": resource{Attributes: p.resource},
+				"scopeSpans": []interface{}{
+					map[string]interface{}{"scope": p.scope(), "spans": encoded},
+				},
+			},
+		},
+	}
+}
+
+func (p *Provider) metricsPayload(snapshots []metricSnapshot) interface{} {
+	now := unixNano(time.Now())
+	start := unixNano(p.started)
+
+	metrics := make([]otlpMetric, 0, len(snapshots))
+	for _, snap := range snapshots {
+		metric := otlpMetric{Name: snap.name, Unit: snap.unit}
+		points := make([]dataPoint, 0, len(snap.series))
+
+		for _, s := range snap.series {
+			point := dataPoint{Attributes: s.attributes, StartTimeUnixNano: start, TimeUnixNano: now}
+			if snap.histogram {
+				sum, minimum, maximum := s.sum, s.min, s.max
+				point.Count = strconv.FormatUint(s.count, 10)
+				point.Sum = &sum
+				if s.count > 0 {
+					point.Min, point.Max = &minimum, &maximum
+				}
+				point.ExplicitBounds = durationBounds
+				for _, b := range s.buckets {
+					point.BucketCounts = append(point.BucketCounts, strconv.FormatUint(b, 10))
+				}
+			} else {
+				point.AsInt = strconv.FormatUint(s.count, 10)
+			}
+			points = append(points, point)
+		}
+
+		if snap.histogram {
+			metric.Histogram = &otlpHistogram{DataPoints: points, AggregationTemporality: aggregationCumulative}
+		} else {
+			metric.Sum = &otlpSum{DataPoints: points, AggregationTemporality: aggregationCumulative, IsMonotonic: true}
+		}
+		metrics = append(metrics, metric)
+	}
+
+	return map[string]interface{}{
+		"resourceMetrics": []interface{}{
+			map[string]interface{}{
+				"resource": resource{Attributes: p.resource},
+				"scopeMetrics": []interface{}{
+					map[string]interface{}{"scope": p.scope(), "metrics": metrics},
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,162 @@
+// Package telemetry exports traces and metrics for the proxy path to an
+// OpenTelemetry collector using OTLP/HTTP with JSON encoding. It implements
+// just the subset of the OpenTelemetry data model the agent needs, so no SDK
+// dependency is pulled in.
+package telemetry
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"skyport-agent/internal/config"
+	"skyport-agent/internal/crash"
+	"skyport-agent/internal/logger"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxQueuedSpans bounds memory use when the collector is unreachable
+const maxQueuedSpans = 2048
+
+// Provider collects spans and metrics and periodically pushes them to the collector.
+// A nil *Provider is valid and records nothing, so callers don't need to check
+// whether telemetry is enabled.
+type Provider struct {
+	endpoint string
+	headers  map[string]string
+	resource []keyValue
+	interval time.Duration
+	client   *http.Client
+	started  time.Time
+
+	mu      sync.Mutex
+	spans   []*Span
+	metrics *metricSet
+
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+// New starts a provider for the given settings. Returns nil if telemetry is disabled.
+func New(settings config.TelemetrySettings) *Provider {
+	if !settings.Enabled {
+		return nil
+	}
+
+	hostname, _ := os.Hostname()
+
+	p := &Provider{
+		endpoint: strings.TrimRight(settings.Endpoint, "/"),
+		headers:  settings.Headers,
+		resource: []keyValue{
+			attr("service.name", settings.ServiceName),
+			attr("service.version", crash.Version),
+			attr("host.name", hostname),
+		},
+		interval: settings.ExportInterval.Duration,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		started:  time.Now(),
+		metrics:  newMetricSet(),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+
+	go p.exportLoop()
+
+	logger.Debug("Telemetry export enabled (%s)", p.endpoint)
+	return p
+}
+
+// Shutdown stops the export loop after pushing anything still buffered
+func (p *Provider) Shutdown() {
+	if p == nil {
+		return
+	}
+
+	p.once.Do(func() {
+		close(p.stop)
+		<-p.done
+	})
+}
+
+func (p *Provider) exportLoop() {
+	defer crash.Recover("telemetry export")
+	defer close(p.done)
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.stop:
+			p.export()
+			return
+		case <-ticker.C:
+			p.export()
+		}
+	}
+}
+
+// export pushes buffered spans and the current metric values
+func (p *Provider) export() {
+	p.mu.Lock()
+	spans := p.spans
+	p.spans = nil
+	metrics := p.metrics.snapshot()
+	p.mu.Unlock()
+
+	if len(spans) > 0 {
+		if err := p.post("/v1/traces", p.tracesPayload(spans)); err != nil {
+			logger.Debug("Failed to export %d spans: %v", len(spans), err)
+		}
+	}
+
+	if len(metrics) > 0 {
+		if err := p.post("/v1/metrics", p.metricsPayload(metrics)); err != nil {
+			logger.Debug("Failed to export metrics: %v", err)
+		}
+	}
+}
+
+// enqueue buffers a finished span for the next export
+func (p *Provider) enqueue(span *Span) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.spans) >= maxQueuedSpans {
+		// Drop the oldest span rather than grow without bound
+		p.spans = p.spans[1:]
+	}
+	p.spans = append(p.spans, span)
+}
+
+func (p *Provider) post(path string, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to encode payload: %w", err)
+	}
+
+	req, err := http.NewRequest("POST", p.endpoint+path, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range p.headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("collector returned status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package telemetry
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// TraceparentHeader is the W3C Trace Context header used to propagate traces
+const TraceparentHeader = "traceparent"
+
+// Span kinds, as defined by OTLP
+const (
+	SpanKindInternal = 1
+	SpanKindServer   = 2
+	SpanKindClient   = 3
+)
+
+// SpanContext identifies a span within a trace
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+}
+
+// IsValid reports whether the context has non-zero IDs
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != [16]byte{} && sc.SpanID != [8]byte{}
+}
+
+// Traceparent formats the context as a W3C traceparent header value
+func (sc SpanContext) Traceparent() string {
+	return fmt.Sprintf("00-%s-%s-01", hex.EncodeToString(sc.TraceID[:]), hex.EncodeToString(sc.SpanID[:]))
+}
+
+// ParseTraceparent parses a W3C traceparent header. An invalid or empty
+// header yields a zero SpanContext, which starts a new trace.
+func ParseTraceparent(header string) SpanContext {
+	var sc SpanContext
+
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) < 4 || len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return SpanContext{}
+	}
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return SpanContext{}
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return SpanContext{}
+	}
+	return sc
+}
+
+// Span is a timed operation. A nil *Span is valid and ignores all calls.
+type Span struct {
+	provider   *Provider
+	name       string
+	kind       int
+	context    SpanContext
+	parentID   [8]byte
+	start      time.Time
+	end        time.Time
+	attributes []keyValue
+	errMessage string
+}
+
+// StartSpan starts a span. A zero parent starts a new trace.
+func (p *Provider) StartSpan(name string, kind int, parent SpanContext) *Span {
+	if p == nil {
+		return nil
+	}
+
+	span := &Span{
+		provider: p,
+		name:     name,
+		kind:     kind,
+		start:    time.Now(),
+	}
+
+	if parent.IsValid() {
+		span.context.TraceID = parent.TraceID
+		span.parentID = parent.SpanID
+	} else {
+		rand.Read(span.context.TraceID[:])
+	}
+	rand.Read(span.context.SpanID[:])
+
+	return span
+}
+
+// Context returns the span's context for starting children or propagation
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.context
+}
+
+// SetAttribute records a string, int, float64 or bool attribute on the span
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.attributes = append(s.attributes, attr(key, value))
+}
+
+// SetError marks the span as failed
+func (s *Span) SetError(message string) {
+	if s == nil {
+		return
+	}
+	s.errMessage = message
+}
+
+// End finishes the span and queues it for export
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.end = time.Now()
+	s.provider.enqueue(s)
+}
@@ -0,0 +1,70 @@
+package tunnel
+
+import (
+	"bytes"
+	"encoding/json"
+	"sync"
+)
+
+// maxPooledBuffer keeps buffers grown by unusually large messages (such as
+// big response bodies) out of the pools, so they are not held forever
+const maxPooledBuffer = 1 << 20
+
+// framePool holds buffers that frames read from the server are decoded from
+var framePool = sync.Pool{
+	New: func() interface{} { return new(bytes.Buffer) },
+}
+
+func getFrameBuffer() *bytes.Buffer {
+	return framePool.Get().(*bytes.Buffer)
+}
+
+func putFrameBuffer(buf *bytes.Buffer) {
+	if buf.Cap() > maxPooledBuffer {
+		return
+	}
+	buf.Reset()
+	framePool.Put(buf)
+}
+
+// messageEncoder is a JSON encoder bound to its own output buffer, so both
+// can be reused for the next message
+type messageEncoder struct {
+	buf bytes.Buffer
+	enc *json.Encoder
+	// release returns the encoder to the pool. It is made once per encoder
+	// so handing it out does not allocate.
+	release func()
+}
+
+// encoderPool holds messageEncoders. Its New is set in init, as the
+// encoders' release refers back to the pool.
+var encoderPool sync.Pool
+
+func init() {
+	encoderPool.New = func() interface{} {
+		e := &messageEncoder{}
+		e.enc = json.NewEncoder(&e.buf)
+		e.release = func() {
+			if e.buf.Cap() > maxPooledBuffer {
+				return
+			}
+			e.buf.Reset()
+			encoderPool.Put(e)
+		}
+		return e
+	}
+}
+
+// encodeMessage encodes a message as JSON. The returned bytes are only valid
+// until release is called.
+func encodeMessage(message *TunnelMessage) (data []byte, release func(), err error) {
+	e := encoderPool.Get().(*messageEncoder)
+	if err := e.enc.Encode(message); err != nil {
+		e.release()
+		return nil, nil, err
+	}
+
+	// Encode terminates the value with a newline, which json.Marshal does not
+	return bytes.TrimSuffix(e.buf.Bytes(), []byte("\n")), e.release, nil
+}
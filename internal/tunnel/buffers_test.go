@@ -0,0 +1,61 @@
+package tunnel
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// benchmarkResponse is a typical proxied response: a few headers and a
+// 5KB body
+func benchmarkResponse() *TunnelMessage {
+	return &TunnelMessage{
+		Type:   "http_response",
+		ID:     "req-123",
+		Status: 200,
+		Headers: map[string]string{
+			"Content-Type":   "text/html; charset=utf-8",
+			"Cache-Control":  "no-cache",
+			"X-Request-Id":   "6f1c2d3e-4a5b-6c7d-8e9f-0a1b2c3d4e5f",
+			"Content-Length": "5120",
+		},
+		Body:      []byte(strings.Repeat("<p>skyport</p>", 5120/14)),
+		Timestamp: 1700000000,
+	}
+}
+
+func BenchmarkEncodeMessage(b *testing.B) {
+	message := benchmarkResponse()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_, release, err := encodeMessage(message)
+		if err != nil {
+			b.Fatal(err)
+		}
+		release()
+	}
+}
+
+func BenchmarkDecodeMessage(b *testing.B) {
+	data, err := json.Marshal(benchmarkResponse())
+	if err != nil {
+		b.Fatal(err)
+	}
+	atp := &AgentTunnelProtocol{}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		// As the read loop does: read the frame into a pooled buffer, which
+		// goes back to the pool once the message is decoded
+		frame := getFrameBuffer()
+		if _, err := frame.ReadFrom(bytes.NewReader(data)); err != nil {
+			b.Fatal(err)
+		}
+		_, err := atp.decodeMessage(frame.Bytes())
+		putFrameBuffer(frame)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
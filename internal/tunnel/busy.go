@@ -0,0 +1,113 @@
+package tunnel
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"skyport-agent/internal/config"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// maxRetryAfter caps how long the server can ask the agent to wait, so a
+// bad value cannot park a tunnel for hours
+const maxRetryAfter = 10 * time.Minute
+
+// ErrServerBusy is returned when the server is overloaded or rate limiting
+// the agent and asked it to retry later
+var ErrServerBusy = errors.New("server is busy")
+
+// ServerBusyError reports a server that asked the agent to retry later
+type ServerBusyError struct {
+	Status     int           // HTTP status of the handshake, or 0 for a closed connection
+	RetryAfter time.Duration // How long the server asked to wait; 0 if it did not say
+}
+
+func (e *ServerBusyError) Error() string {
+	what := ErrServerBusy.Error()
+	if e.Status == http.StatusTooManyRequests {
+		what = "server is rate limiting connections"
+	}
+	if e.RetryAfter > 0 {
+		return fmt.Sprintf("%s, retry after %v", what, e.RetryAfter)
+	}
+	return what
+}
+
+func (e *ServerBusyError) Unwrap() error {
+	return ErrServerBusy
+}
+
+// delay returns how long to wait before the next attempt: the server's
+// Retry-After if it is longer than the backoff delay
+func (e *ServerBusyError) delay(backoff time.Duration) time.Duration {
+	if e.RetryAfter > backoff {
+		return e.RetryAfter
+	}
+	return backoff
+}
+
+// busyResponse returns a ServerBusyError for a 429 or 503 handshake
+// response, or nil for any other
+func busyResponse(resp *http.Response) *ServerBusyError {
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+		return nil
+	}
+	return &ServerBusyError{
+		Status:     resp.StatusCode,
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+	}
+}
+
+// busyClose returns a ServerBusyError when the server closed the connection
+// with "try again later" (1013), or nil. The close reason may carry the
+// delay as "retry-after=30" or a plain number of seconds.
+func busyClose(err error) *ServerBusyError {
+	var closeErr *websocket.CloseError
+	if !errors.As(err, &closeErr) || closeErr.Code != websocket.CloseTryAgainLater {
+		return nil
+	}
+	value := strings.TrimSpace(closeErr.Text)
+	if _, after, ok := strings.Cut(strings.ToLower(value), "retry-after="); ok {
+		value = strings.TrimSpace(after)
+	}
+	return &ServerBusyError{RetryAfter: parseRetryAfter(value, time.Now())}
+}
+
+// parseRetryAfter reads a Retry-After value, either seconds or an HTTP date,
+// capped at maxRetryAfter. It returns 0 if the value is missing or invalid.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+
+	var delay time.Duration
+	if seconds, err := strconv.Atoi(value); err == nil {
+		delay = time.Duration(seconds) * time.Second
+	} else if at, err := http.ParseTime(value); err == nil {
+		delay = at.Sub(now)
+	}
+
+	switch {
+	case delay < 0:
+		return 0
+	case delay > maxRetryAfter:
+		return maxRetryAfter
+	}
+	return delay
+}
+
+// emitServerBusy reports a tunnel waiting out a busy server
+func (tm *TunnelManager) emitServerBusy(tunnel *config.Tunnel, busy *ServerBusyError, delay time.Duration, attempt int) {
+	tm.emit(Event{
+		Type:       EventServerBusy,
+		TunnelID:   tunnel.ID,
+		TunnelName: tunnel.Name,
+		Reason:     fmt.Sprintf("%s; retrying in %v", busy.Error(), delay.Round(time.Second)),
+		Attempt:    attempt,
+	})
+}
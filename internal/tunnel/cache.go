@@ -0,0 +1,184 @@
+package tunnel
+
+import (
+	"net/http"
+	"skyport-agent/internal/cache"
+	"skyport-agent/internal/config"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CacheStatusHeader tells clients whether a response came from the agent's
+// cache (HIT) or the local service (MISS)
+const CacheStatusHeader = "X-Skyport-Cache"
+
+// cachedResponse is a stored response and the request header values it was
+// stored for, per the response's Vary header
+type cachedResponse struct {
+	status  int
+	headers map[string]string
+	body    []byte
+	vary    map[string]string
+	stored  time.Time
+}
+
+// responseCache serves repeated GET requests from memory instead of the local
+// service
+type responseCache struct {
+	cache         *cache.Cache
+	ttl           time.Duration // Forced TTL, or 0 to follow Cache-Control
+	maxEntryBytes int64
+}
+
+func newResponseCache(tunnel *config.Tunnel) *responseCache {
+	settings := tunnel.CacheSettings()
+	if !settings.Enabled {
+		return nil
+	}
+
+	return &responseCache{
+		cache:         cache.New(int64(settings.MaxSizeMB) * 1024 * 1024),
+		ttl:           settings.TTL.Duration,
+		maxEntryBytes: int64(settings.MaxEntryKB) * 1024,
+	}
+}
+
+// cacheableRequest reports whether a request's response may be shared with
+// other clients. Requests carrying credentials are never cached.
+func cacheableRequest(message *TunnelMessage) bool {
+	return message.Method == http.MethodGet &&
+		headerValue(message.Headers, "Authorization") == "" &&
+		headerValue(message.Headers, "Cookie") == ""
+}
+
+func cacheKey(message *TunnelMessage) string {
+	return message.Method + " " + message.URL
+}
+
+// lookupFilter answers requests from the cache when a fresh matching response
+// is stored
+func (rc *responseCache) lookupFilter(message *TunnelMessage) *TunnelMessage {
+	if !cacheableRequest(message) {
+		return nil
+	}
+	requestDirectives := strings.ToLower(headerValue(message.Headers, "Cache-Control"))
+	if strings.Contains(requestDirectives, "no-cache") || strings.Contains(requestDirectives, "no-store") {
+		return nil
+	}
+
+	value, found := rc.cache.Get(cacheKey(message))
+	if !found {
+		return nil
+	}
+	cached := value.(*cachedResponse)
+	for name, value := range cached.vary {
+		if headerValue(message.Headers, name) != value {
+			return nil
+		}
+	}
+
+	headers := make(map[string]string, len(cached.headers)+2)
+	for name, value := range cached.headers {
+		headers[name] = value
+	}
+	headers[CacheStatusHeader] = "HIT"
+	headers["Age"] = strconv.Itoa(int(time.Since(cached.stored).Seconds()))
+
+	return &TunnelMessage{
+		Type:      "http_response",
+		ID:        message.ID,
+		Status:    cached.status,
+		Headers:   headers,
+		Body:      cached.body,
+		Timestamp: time.Now().Unix(),
+	}
+}
+
+// storeFilter caches successful responses to cacheable requests and marks
+// them as cache misses
+func (rc *responseCache) storeFilter(request, response *TunnelMessage) {
+	if !cacheableRequest(request) || headerValue(response.Headers, CacheStatusHeader) != "" {
+		return
+	}
+	if response.Headers == nil {
+		response.Headers = make(map[string]string)
+	}
+	response.Headers[CacheStatusHeader] = "MISS"
+
+	if response.Status != http.StatusOK || response.Error != "" || headerValue(response.Headers, "Set-Cookie") != "" {
+		return
+	}
+	ttl := rc.ttlFor(headerValue(response.Headers, "Cache-Control"))
+	if ttl <= 0 {
+		return
+	}
+
+	vary := make(map[string]string)
+	for _, name := range strings.Split(headerValue(response.Headers, "Vary"), ",") {
+		name = strings.TrimSpace(name)
+		if name == "*" {
+			return
+		}
+		if name != "" {
+			vary[name] = headerValue(request.Headers, name)
+		}
+	}
+
+	size := int64(len(response.Body))
+	headers := make(map[string]string, len(response.Headers))
+	for name, value := range response.Headers {
+		// Per-request headers are added again when serving a hit
+		if strings.EqualFold(name, CacheStatusHeader) || strings.EqualFold(name, RequestIDHeader) {
+			continue
+		}
+		headers[name] = value
+		size += int64(len(name) + len(value))
+	}
+	if size > rc.maxEntryBytes {
+		return
+	}
+
+	rc.cache.Put(cacheKey(request), &cachedResponse{
+		status:  response.Status,
+		headers: headers,
+		body:    response.Body,
+		vary:    vary,
+		stored:  time.Now(),
+	}, size, ttl)
+}
+
+// ttlFor returns how long a response may be cached given its Cache-Control
+// header. no-store and private always prevent caching; otherwise a forced TTL
+// takes precedence over s-maxage and max-age.
+func (rc *responseCache) ttlFor(cacheControl string) time.Duration {
+	directives := make(map[string]string)
+	for _, directive := range strings.Split(strings.ToLower(cacheControl), ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(directive), "=")
+		directives[name] = strings.Trim(value, `"`)
+	}
+
+	if _, noStore := directives["no-store"]; noStore {
+		return 0
+	}
+	if _, private := directives["private"]; private {
+		return 0
+	}
+	if rc.ttl > 0 {
+		return rc.ttl
+	}
+	if _, noCache := directives["no-cache"]; noCache {
+		return 0
+	}
+
+	for _, name := range []string{"s-maxage", "max-age"} {
+		if value, exists := directives[name]; exists {
+			seconds, err := strconv.Atoi(value)
+			if err != nil {
+				return 0
+			}
+			return time.Duration(seconds) * time.Second
+		}
+	}
+	return 0
+}
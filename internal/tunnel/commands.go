@@ -0,0 +1,114 @@
+package tunnel
+
+import (
+	"net/http"
+	"skyport-agent/internal/config"
+	"skyport-agent/internal/crash"
+	"skyport-agent/internal/logger"
+	"time"
+)
+
+// FeatureCommands lets the server send commands to the agent over a tunnel's
+// connection, to manage a headless agent from the dashboard
+const FeatureCommands = "commands"
+
+const (
+	// messageAgentCommand carries a command from the server. The command name
+	// is in its "command" header and the tunnel it acts on, if any, in its
+	// "tunnel" header.
+	messageAgentCommand = "agent_command"
+
+	// messageCommandResult answers a command, with the command's ID, an
+	// HTTP-style status and, for diagnostics, a JSON body
+	messageCommandResult = "agent_command_result"
+)
+
+// Command is an instruction from the server
+type Command struct {
+	ID     string
+	Name   string // One of the config.Remote* commands
+	Tunnel string // Name or ID of the tunnel to act on, for start and stop
+	Via    string // Name of the tunnel whose connection carried the command
+}
+
+// CommandResult is the agent's answer to a command
+type CommandResult struct {
+	Status int // HTTP-style: 200 done, 403 not allowed by local policy, 404 unknown tunnel...
+	Error  string
+	Body   []byte
+}
+
+// OnCommand registers the handler for commands from the server. Without
+// one, commands are refused. The handler runs on its own goroutine.
+func (tm *TunnelManager) OnCommand(handler func(Command) CommandResult) {
+	tm.mutex.Lock()
+	defer tm.mutex.Unlock()
+
+	tm.commandHandler = handler
+}
+
+// handleCommand runs a command from the server and sends back its result
+func (tm *TunnelManager) handleCommand(tunnel config.Tunnel, message *TunnelMessage) {
+	command := Command{
+		ID:     message.ID,
+		Name:   headerValue(message.Headers, "command"),
+		Tunnel: headerValue(message.Headers, "tunnel"),
+		Via:    tunnel.Name,
+	}
+
+	tm.mutex.RLock()
+	handler := tm.commandHandler
+	tm.mutex.RUnlock()
+
+	go func() {
+		defer crash.Recover("server command")
+
+		result := CommandResult{Status: http.StatusNotImplemented, Error: "this agent does not accept commands"}
+		if handler != nil {
+			result = handler(command)
+		}
+		tm.replyToCommand(tunnel.ID, command, result)
+	}()
+}
+
+// replyToCommand sends a command's result over the connection that carried
+// it or, if the command closed that one, over any other connected tunnel
+func (tm *TunnelManager) replyToCommand(tunnelID string, command Command, result CommandResult) {
+	reply := &TunnelMessage{
+		Type:      messageCommandResult,
+		ID:        command.ID,
+		Status:    result.Status,
+		Error:     result.Error,
+		Body:      result.Body,
+		Timestamp: time.Now().Unix(),
+	}
+	if result.Body != nil {
+		reply.Headers = map[string]string{"Content-Type": "application/json"}
+	}
+
+	tunnelConn := tm.getConnection(tunnelID)
+	if tunnelConn == nil {
+		for _, otherID := range tm.GetActiveTunnels() {
+			if tunnelConn = tm.getConnection(otherID); tunnelConn != nil {
+				break
+			}
+		}
+	}
+	if tunnelConn == nil {
+		logger.Debug("No connection left to answer command %s (%s)", command.ID, command.Name)
+		return
+	}
+	if err := tunnelConn.Protocol.sendMessage(reply); err != nil {
+		logger.Debug("Failed to answer command %s (%s): %v", command.ID, command.Name, err)
+	}
+}
+
+// StopTunnel disconnects a tunnel and keeps it disconnected, as the idle
+// timeout does, until it is started again
+func (tm *TunnelManager) StopTunnel(tunnelID, reason string) error {
+	tm.mutex.Lock()
+	tm.stopped[tunnelID] = true
+	tm.mutex.Unlock()
+
+	return tm.disconnectTunnel(tunnelID, reason)
+}
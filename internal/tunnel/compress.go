@@ -0,0 +1,105 @@
+package tunnel
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"skyport-agent/internal/config"
+	"strconv"
+	"strings"
+)
+
+// compressibleTypes are the content types worth compressing, matched by prefix
+var compressibleTypes = []string{
+	"text/",
+	"application/json",
+	"application/javascript",
+	"application/xml",
+	"application/xhtml+xml",
+	"application/rss+xml",
+	"application/atom+xml",
+	"application/manifest+json",
+	"application/wasm",
+	"image/svg+xml",
+}
+
+// compressionFilter gzips compressible responses the local service sent
+// uncompressed, when the client accepts gzip. Returns nil if compression is
+// disabled.
+func compressionFilter(tunnel *config.Tunnel) responseFilter {
+	settings := tunnel.CompressionSettings()
+	if !settings.Enabled {
+		return nil
+	}
+
+	return func(request, response *TunnelMessage) {
+		if len(response.Body) < settings.MinBytes ||
+			response.Status == http.StatusPartialContent ||
+			headerValue(response.Headers, "Content-Encoding") != "" ||
+			!acceptsGzip(headerValue(request.Headers, "Accept-Encoding")) ||
+			!compressible(headerValue(response.Headers, "Content-Type")) {
+			return
+		}
+
+		var buf bytes.Buffer
+		writer, err := gzip.NewWriterLevel(&buf, settings.Level)
+		if err != nil {
+			return
+		}
+		writer.Write(response.Body)
+		if err := writer.Close(); err != nil || buf.Len() >= len(response.Body) {
+			return
+		}
+
+		response.Body = buf.Bytes()
+		response.Headers["Content-Encoding"] = "gzip"
+		removeHeader(response.Headers, "Content-Length")
+		response.Headers["Content-Length"] = strconv.Itoa(buf.Len())
+
+		vary := headerValue(response.Headers, "Vary")
+		if !strings.Contains(strings.ToLower(vary), "accept-encoding") {
+			removeHeader(response.Headers, "Vary")
+			if vary != "" {
+				vary += ", "
+			}
+			response.Headers["Vary"] = vary + "Accept-Encoding"
+		}
+
+		// The compressed body is no longer byte-for-byte what a strong ETag
+		// identified
+		if etag := headerValue(response.Headers, "ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			removeHeader(response.Headers, "ETag")
+			response.Headers["ETag"] = "W/" + etag
+		}
+	}
+}
+
+// acceptsGzip reports whether an Accept-Encoding header allows gzip
+func acceptsGzip(acceptEncoding string) bool {
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		coding, params, _ := strings.Cut(part, ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding != "gzip" && coding != "*" {
+			continue
+		}
+
+		// "gzip;q=0" explicitly refuses gzip
+		name, value, found := strings.Cut(strings.TrimSpace(params), "=")
+		if found && strings.TrimSpace(name) == "q" {
+			q, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			return err == nil && q > 0
+		}
+		return true
+	}
+	return false
+}
+
+func compressible(contentType string) bool {
+	contentType = strings.ToLower(contentType)
+	for _, prefix := range compressibleTypes {
+		if strings.HasPrefix(contentType, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,122 @@
+package tunnel
+
+import (
+	"net/http"
+	"skyport-agent/internal/config"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// corsPolicy answers CORS preflight requests and adds CORS headers to
+// responses on behalf of the local service
+type corsPolicy struct {
+	settings  config.CORSSettings
+	anyOrigin bool
+}
+
+func newCORSPolicy(tunnel *config.Tunnel) *corsPolicy {
+	settings := tunnel.CORSSettings()
+	if !settings.Enabled {
+		return nil
+	}
+	return &corsPolicy{
+		settings:  settings,
+		anyOrigin: slices.Contains(settings.AllowOrigins, "*"),
+	}
+}
+
+// allowedOrigin returns the value for Access-Control-Allow-Origin, or "" if
+// the origin is not allowed
+func (p *corsPolicy) allowedOrigin(origin string) string {
+	if origin == "" {
+		return ""
+	}
+	if p.anyOrigin {
+		// Browsers reject "*" on credentialed requests, so echo the origin
+		if p.settings.AllowCredentials {
+			return origin
+		}
+		return "*"
+	}
+	for _, allowed := range p.settings.AllowOrigins {
+		if strings.EqualFold(allowed, origin) {
+			return origin
+		}
+	}
+	return ""
+}
+
+// preflightFilter answers OPTIONS preflight requests from allowed origins
+// without forwarding them
+func (p *corsPolicy) preflightFilter(message *TunnelMessage) *TunnelMessage {
+	requestMethod := headerValue(message.Headers, "Access-Control-Request-Method")
+	if message.Method != http.MethodOptions || requestMethod == "" {
+		return nil
+	}
+	origin := p.allowedOrigin(headerValue(message.Headers, "Origin"))
+	if origin == "" {
+		return nil
+	}
+
+	allowHeaders := strings.Join(p.settings.AllowHeaders, ", ")
+	if len(p.settings.AllowHeaders) == 0 {
+		allowHeaders = headerValue(message.Headers, "Access-Control-Request-Headers")
+	}
+
+	headers := map[string]string{
+		"Access-Control-Allow-Origin":  origin,
+		"Access-Control-Allow-Methods": strings.Join(p.settings.AllowMethods, ", "),
+		"Access-Control-Max-Age":       strconv.Itoa(p.settings.MaxAge),
+		"Vary":                         "Origin",
+	}
+	if allowHeaders != "" {
+		headers["Access-Control-Allow-Headers"] = allowHeaders
+	}
+	if p.settings.AllowCredentials {
+		headers["Access-Control-Allow-Credentials"] = "true"
+	}
+
+	return &TunnelMessage{
+		Type:      "http_response",
+		ID:        message.ID,
+		Status:    http.StatusNoContent,
+		Headers:   headers,
+		Timestamp: time.Now().Unix(),
+	}
+}
+
+// addHeaders adds CORS headers to responses to cross-origin requests,
+// replacing any the local service set
+func (p *corsPolicy) addHeaders(request, response *TunnelMessage) {
+	origin := p.allowedOrigin(headerValue(request.Headers, "Origin"))
+	if origin == "" {
+		return
+	}
+
+	if response.Headers == nil {
+		response.Headers = make(map[string]string)
+	}
+	for _, name := range []string{"Access-Control-Allow-Origin", "Access-Control-Allow-Credentials", "Access-Control-Expose-Headers"} {
+		removeHeader(response.Headers, name)
+	}
+
+	response.Headers["Access-Control-Allow-Origin"] = origin
+	if origin != "*" {
+		vary := headerValue(response.Headers, "Vary")
+		if !strings.Contains(strings.ToLower(vary), "origin") {
+			removeHeader(response.Headers, "Vary")
+			if vary != "" {
+				vary += ", "
+			}
+			response.Headers["Vary"] = vary + "Origin"
+		}
+	}
+	if p.settings.AllowCredentials {
+		response.Headers["Access-Control-Allow-Credentials"] = "true"
+	}
+	if len(p.settings.ExposeHeaders) > 0 {
+		response.Headers["Access-Control-Expose-Headers"] = strings.Join(p.settings.ExposeHeaders, ", ")
+	}
+}
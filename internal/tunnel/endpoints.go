@@ -0,0 +1,302 @@
+package tunnel
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"skyport-agent/internal/config"
+	"skyport-agent/internal/logger"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// endpointsPath is where the server lists its tunnel ingress endpoints.
+	// Servers without one (a 404) are dialed at ServerURL as before.
+	endpointsPath = "/tunnel/endpoints"
+
+	// endpointRefresh is how often the endpoint list is fetched and probed again
+	endpointRefresh = 10 * time.Minute
+	// endpointCooldown is how long an endpoint that failed is tried last
+	endpointCooldown = time.Minute
+	// endpointProbeTimeout bounds each latency probe
+	endpointProbeTimeout = 3 * time.Second
+)
+
+// Endpoint is a tunnel ingress the server advertises, usually one per region
+type Endpoint struct {
+	Region string `json:"region"`
+	URL    string `json:"url"` // Base URL; /tunnel/connect is appended
+}
+
+// String names the endpoint by region, or by URL if it has none
+func (e Endpoint) String() string {
+	if e.Region != "" {
+		return e.Region
+	}
+	return e.URL
+}
+
+type endpointState struct {
+	Endpoint
+	latency  time.Duration // TCP connect time of the last probe; zero if it failed
+	failedAt time.Time
+}
+
+// endpointSelector picks the tunnel endpoint to dial: the one with the lowest
+// latency, skipping endpoints that recently failed so tunnels fail over to
+// another region while theirs is unreachable
+type endpointSelector struct {
+	config *config.Config
+
+	mu         sync.Mutex
+	endpoints  []*endpointState
+	fetchedAt  time.Time
+	refreshing chan struct{} // Closed when the refresh in progress ends; nil if none is
+}
+
+func newEndpointSelector(cfg *config.Config) *endpointSelector {
+	return &endpointSelector{config: cfg}
+}
+
+// candidates returns the endpoints to try, best first
+func (es *endpointSelector) candidates() []Endpoint {
+	es.refreshIfStale()
+
+	es.mu.Lock()
+	defer es.mu.Unlock()
+
+	ordered := make([]*endpointState, len(es.endpoints))
+	copy(ordered, es.endpoints)
+
+	now := time.Now()
+	sort.SliceStable(ordered, func(i, j int) bool {
+		a, b := ordered[i], ordered[j]
+		aFailed := now.Sub(a.failedAt) < endpointCooldown
+		bFailed := now.Sub(b.failedAt) < endpointCooldown
+		if aFailed != bFailed {
+			return !aFailed
+		}
+		if aFailed {
+			return a.failedAt.Before(b.failedAt)
+		}
+		// Endpoints that could not be probed keep the server's order, after
+		// the ones that could
+		if (a.latency == 0) != (b.latency == 0) {
+			return a.latency != 0
+		}
+		return a.latency < b.latency
+	})
+
+	result := make([]Endpoint, len(ordered))
+	for i, ep := range ordered {
+		result[i] = ep.Endpoint
+	}
+	return result
+}
+
+// failed moves an endpoint to the back of the list for a while
+func (es *endpointSelector) failed(endpoint Endpoint) {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+
+	for _, ep := range es.endpoints {
+		if ep.URL == endpoint.URL {
+			ep.failedAt = time.Now()
+		}
+	}
+}
+
+// refreshIfStale refreshes the endpoint list when there is none yet or it is
+// older than endpointRefresh. The fetch and probes run without holding the
+// lock, and one refresh runs at a time: meanwhile other callers use the old
+// list, or wait for the refresh if there is none.
+func (es *endpointSelector) refreshIfStale() {
+	es.mu.Lock()
+	if es.endpoints != nil && time.Since(es.fetchedAt) <= endpointRefresh {
+		es.mu.Unlock()
+		return
+	}
+	if done := es.refreshing; done != nil {
+		wait := es.endpoints == nil
+		es.mu.Unlock()
+		if wait {
+			<-done
+		}
+		return
+	}
+	done := make(chan struct{})
+	es.refreshing = done
+	es.mu.Unlock()
+
+	states := es.load()
+
+	es.mu.Lock()
+	es.swap(states)
+	es.refreshing = nil
+	es.mu.Unlock()
+	close(done)
+}
+
+// load fetches the endpoint list and probes each endpoint, returning nil if
+// the server does not list any
+func (es *endpointSelector) load() []*endpointState {
+	endpoints, err := es.fetch()
+	if err != nil {
+		logger.Debug("Using %s for tunnels: %v", es.config.ServerURL, err)
+	}
+
+	states := make([]*endpointState, len(endpoints))
+	for i, endpoint := range endpoints {
+		states[i] = &endpointState{Endpoint: endpoint}
+	}
+
+	// A single endpoint has nothing to be compared with
+	if len(states) > 1 {
+		var wg sync.WaitGroup
+		for _, ep := range states {
+			wg.Add(1)
+			go func(ep *endpointState) {
+				defer wg.Done()
+				ep.latency = probeEndpoint(ep.URL)
+			}(ep)
+		}
+		wg.Wait()
+
+		for _, ep := range states {
+			if ep.latency > 0 {
+				logger.Debug("Tunnel endpoint %s: %v", ep, ep.latency.Round(time.Millisecond))
+			} else {
+				logger.Debug("Tunnel endpoint %s: unreachable", ep)
+			}
+		}
+	}
+	return states
+}
+
+// swap replaces the endpoint list with freshly loaded states, keeping when
+// each endpoint last failed. If the server listed none, the list from the
+// last successful fetch is kept, or the server's own URL becomes the only
+// endpoint. Called with es.mu held.
+func (es *endpointSelector) swap(states []*endpointState) {
+	es.fetchedAt = time.Now()
+
+	if len(states) == 0 {
+		if es.endpoints == nil {
+			es.endpoints = []*endpointState{{Endpoint: Endpoint{URL: es.config.ServerURL}}}
+		}
+		return
+	}
+
+	previous := make(map[string]*endpointState, len(es.endpoints))
+	for _, ep := range es.endpoints {
+		previous[ep.URL] = ep
+	}
+	for _, ep := range states {
+		if old, ok := previous[ep.URL]; ok {
+			ep.failedAt = old.failedAt
+		}
+	}
+	es.endpoints = states
+}
+
+// fetch asks the server for its tunnel endpoints
+func (es *endpointSelector) fetch() ([]Endpoint, error) {
+	client := es.config.HTTPClient(5 * time.Second)
+	resp, err := client.Get(es.config.ServerURL + endpointsPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch tunnel endpoints: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch tunnel endpoints: status %d", resp.StatusCode)
+	}
+
+	var body struct {
+		Endpoints []Endpoint `json:"endpoints"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("failed to parse tunnel endpoints: %w", err)
+	}
+
+	var endpoints []Endpoint
+	for _, endpoint := range body.Endpoints {
+		if _, err := endpointAddress(endpoint.URL); err != nil {
+			logger.Debug("Ignoring tunnel endpoint %q: %v", endpoint.URL, err)
+			continue
+		}
+		endpoint.URL = strings.TrimSuffix(endpoint.URL, "/")
+		endpoints = append(endpoints, endpoint)
+	}
+	return endpoints, nil
+}
+
+// probeEndpoint measures how long a TCP connection to the endpoint takes,
+// returning zero if it could not be reached
+func probeEndpoint(rawURL string) time.Duration {
+	address, err := endpointAddress(rawURL)
+	if err != nil {
+		return 0
+	}
+
+	start := time.Now()
+	conn, err := net.DialTimeout("tcp", address, endpointProbeTimeout)
+	if err != nil {
+		return 0
+	}
+	latency := time.Since(start)
+	conn.Close()
+	return latency
+}
+
+// endpointAddress returns the host:port an endpoint URL connects to
+func endpointAddress(rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	if u.Hostname() == "" {
+		return "", fmt.Errorf("missing host")
+	}
+
+	port := u.Port()
+	if port == "" {
+		switch u.Scheme {
+		case "https", "wss":
+			port = "443"
+		case "http", "ws":
+			port = "80"
+		default:
+			return "", fmt.Errorf("unsupported scheme %q", u.Scheme)
+		}
+	}
+	return net.JoinHostPort(u.Hostname(), port), nil
+}
+
+// connectionLost reports whether a read error means the server became
+// unreachable, as opposed to it closing the connection
+func connectionLost(err error) bool {
+	var closeErr *websocket.CloseError
+	if errors.As(err, &closeErr) {
+		return closeErr.Code == websocket.CloseAbnormalClosure
+	}
+	return true
+}
+
+// connectURL returns the WebSocket URL tunnels connect to at an endpoint
+func (e Endpoint) connectURL() string {
+	wsURL := strings.Replace(e.URL, "http://", "ws://", 1)
+	wsURL = strings.Replace(wsURL, "https://", "wss://", 1)
+	return wsURL + "/tunnel/connect"
+}
@@ -0,0 +1,69 @@
+package tunnel
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"skyport-agent/internal/config"
+)
+
+func TestEndpointRefreshDoesNotBlockSelection(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		fmt.Fprintf(w, `{"endpoints":[{"region":"eu","url":"http://%s"},{"region":"us","url":"http://127.0.0.1:1"}]}`, r.Host)
+	}))
+	defer server.Close()
+	defer func() {
+		select {
+		case <-release:
+		default:
+			close(release)
+		}
+	}()
+
+	// A stale list, so the next selection refreshes it
+	es := newEndpointSelector(&config.Config{ServerURL: server.URL})
+	old := Endpoint{URL: server.URL}
+	es.endpoints = []*endpointState{{Endpoint: old}}
+
+	refreshed := make(chan []Endpoint, 1)
+	go func() { refreshed <- es.candidates() }()
+	for {
+		es.mu.Lock()
+		refreshing := es.refreshing != nil
+		es.mu.Unlock()
+		if refreshing {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// While the fetch hangs, other tunnels still get the old list
+	selected := make(chan []Endpoint, 1)
+	go func() {
+		es.failed(old)
+		selected <- es.candidates()
+	}()
+	select {
+	case got := <-selected:
+		if len(got) != 1 || got[0] != old {
+			t.Errorf("candidates during a refresh = %v, want the old list", got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("endpoint selection waited for the refresh")
+	}
+
+	close(release)
+	got := <-refreshed
+	if len(got) != 2 {
+		t.Fatalf("candidates after the refresh = %v, want both listed endpoints", got)
+	}
+	// The endpoint that failed during the refresh is still tried last
+	if got[1].Region != "eu" {
+		t.Errorf("candidates after the refresh = %v, want the failed eu endpoint last", got)
+	}
+}
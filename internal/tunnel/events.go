@@ -0,0 +1,79 @@
+package tunnel
+
+import (
+	"errors"
+	"skyport-agent/internal/config"
+	"time"
+)
+
+// Tunnel lifecycle event types
+const (
+	EventConnected      = "connected"
+	EventDisconnected   = "disconnected"
+	EventReconnecting   = "reconnecting"
+	EventReconnected    = "reconnected"
+	EventAuthFailed     = "auth_failed"
+	EventIdleStopped    = "idle_stopped"    // Disconnected by the tunnel's idle timeout
+	EventExpired        = "expired"         // Disconnected because the tunnel's expiry passed
+	EventServerUpdated  = "server_updated"  // The tunnel was changed in the dashboard
+	EventServerDeleted  = "server_deleted"  // The tunnel was deleted in the dashboard and disconnected
+	EventTakenOver      = "taken_over"      // Another machine took the tunnel over and it was disconnected
+	EventServerBusy     = "server_busy"     // The server is overloaded or rate limiting; waiting before retrying
+	EventTargetSwitched = "target_switched" // The running tunnel's local target was switched
+
+	// Responses content scanning found secrets or personal data in
+	EventContentBlocked  = "content_blocked"
+	EventContentRedacted = "content_redacted"
+)
+
+// Event describes a change in a tunnel's connection state
+type Event struct {
+	Type       string    `json:"type"`
+	TunnelID   string    `json:"tunnel_id"`
+	TunnelName string    `json:"tunnel_name"`
+	Reason     string    `json:"reason,omitempty"`
+	Attempt    int       `json:"attempt,omitempty"`
+	Time       time.Time `json:"time"`
+
+	// UserInitiated is set on disconnects requested locally (stop, shutdown)
+	UserInitiated bool `json:"user_initiated,omitempty"`
+}
+
+// emitConnectFailure reports a failed connection attempt that the server
+// rejected for authentication reasons
+func (tm *TunnelManager) emitConnectFailure(tunnel *config.Tunnel, err error) {
+	if errors.Is(err, ErrUnauthorized) {
+		tm.emit(Event{
+			Type:       EventAuthFailed,
+			TunnelID:   tunnel.ID,
+			TunnelName: tunnel.Name,
+			Reason:     err.Error(),
+		})
+	}
+}
+
+// OnEvent registers a handler that is called for every tunnel lifecycle event.
+// Handlers run synchronously on the goroutine that produced the event, so they
+// should hand off anything slow.
+func (tm *TunnelManager) OnEvent(handler func(Event)) {
+	tm.mutex.Lock()
+	defer tm.mutex.Unlock()
+
+	tm.eventHandlers = append(tm.eventHandlers, handler)
+}
+
+// emit delivers an event to all registered handlers
+func (tm *TunnelManager) emit(event Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	tm.mutex.RLock()
+	handlers := make([]func(Event), len(tm.eventHandlers))
+	copy(handlers, tm.eventHandlers)
+	tm.mutex.RUnlock()
+
+	for _, handler := range handlers {
+		handler(event)
+	}
+}
@@ -0,0 +1,44 @@
+package tunnel
+
+import (
+	"errors"
+	"fmt"
+	"skyport-agent/internal/config"
+	"skyport-agent/internal/logger"
+	"time"
+)
+
+// ErrTunnelExpired is returned when connecting a tunnel whose expiry has
+// passed
+var ErrTunnelExpired = errors.New("tunnel has expired")
+
+// checkExpiry refuses to connect a tunnel whose expiry has passed
+func checkExpiry(tunnel *config.Tunnel) error {
+	if tunnel.Expired() {
+		return fmt.Errorf("%w (at %s)", ErrTunnelExpired, tunnel.ExpirySettings().At.Local().Format(time.Kitchen))
+	}
+	return nil
+}
+
+// stopIfExpired disconnects a tunnel whose expiry has passed, without
+// reconnecting it, and reports whether it did
+func (tm *TunnelManager) stopIfExpired(tunnelConn *TunnelConnection) bool {
+	tunnel := tunnelConn.Tunnel
+	if !tunnel.Expired() {
+		return false
+	}
+
+	reason := fmt.Sprintf("expired at %s", tunnel.ExpirySettings().At.Local().Format(time.Kitchen))
+	logger.Info("Tunnel %s expired, disconnecting it", tunnel.Name)
+
+	if err := tm.disconnectTunnel(tunnel.ID, reason); err != nil {
+		logger.Debug("Failed to stop expired tunnel %s: %v", tunnel.Name, err)
+	}
+	tm.emit(Event{
+		Type:       EventExpired,
+		TunnelID:   tunnel.ID,
+		TunnelName: tunnel.Name,
+		Reason:     reason,
+	})
+	return true
+}
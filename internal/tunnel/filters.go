@@ -0,0 +1,344 @@
+package tunnel
+
+import (
+	"encoding/base64"
+	"fmt"
+	"math"
+	"net/http"
+	"skyport-agent/internal/config"
+	"skyport-agent/internal/headerfilter"
+	"skyport-agent/internal/htpasswd"
+	"skyport-agent/internal/ipfilter"
+	"skyport-agent/internal/logger"
+	"skyport-agent/internal/pathfilter"
+	"skyport-agent/internal/ratelimit"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// requestFilter checks or rewrites a request before it is forwarded to the
+// local service. It returns the response to send instead, or nil to let the
+// (possibly modified) request through.
+type requestFilter func(message *TunnelMessage) *TunnelMessage
+
+// responseFilter modifies a response before it is sent back through the tunnel
+type responseFilter func(request, response *TunnelMessage)
+
+// buildFilters returns the request and response filters enabled for a
+// tunnel, in the order they are applied. Filters answer browsers with the
+// tunnel's custom pages, count what they reject in stats and report what
+// they block through emit.
+func buildFilters(tunnel *config.Tunnel, pages *pageSet, stats *tunnelStats, emit func(Event)) ([]requestFilter, []responseFilter) {
+	var requestFilters []requestFilter
+	var responseFilters []responseFilter
+
+	cors := newCORSPolicy(tunnel)
+	responseCache := newResponseCache(tunnel)
+	requestHooks, responseHooks := hookFilters(tunnel)
+
+	if filter := ipFilter(tunnel); filter != nil {
+		requestFilters = append(requestFilters, filter)
+	}
+	if filter := headerFilter(tunnel, stats); filter != nil {
+		requestFilters = append(requestFilters, filter)
+	}
+	if filter := pathFilter(tunnel); filter != nil {
+		requestFilters = append(requestFilters, filter)
+	}
+	if filter := bodySizeFilter(tunnel); filter != nil {
+		requestFilters = append(requestFilters, filter)
+	}
+	if filter := rateLimitFilter(tunnel); filter != nil {
+		requestFilters = append(requestFilters, filter)
+	}
+	// Page assets are public so the maintenance and login pages can use them
+	if filter := pages.assetFilter(); filter != nil {
+		requestFilters = append(requestFilters, filter)
+	}
+	if filter := maintenanceFilter(tunnel, pages); filter != nil {
+		requestFilters = append(requestFilters, filter)
+	}
+	// Preflight requests carry no credentials, so they are answered before
+	// basic auth is checked
+	if cors != nil {
+		requestFilters = append(requestFilters, cors.preflightFilter)
+	}
+	if filter := basicAuthFilter(tunnel, pages); filter != nil {
+		requestFilters = append(requestFilters, filter)
+	}
+	if filter := oidcFilter(tunnel, pages); filter != nil {
+		requestFilters = append(requestFilters, filter)
+	}
+	// The cache is keyed on the public URL, so it is consulted before paths
+	// are rewritten
+	if responseCache != nil {
+		requestFilters = append(requestFilters, responseCache.lookupFilter)
+	}
+	if filter := rewriteFilter(tunnel); filter != nil {
+		requestFilters = append(requestFilters, filter)
+	}
+	// Sanitizing runs after basic auth and OIDC, which need the credentials it
+	// may strip, and before header rules, so those can still set any header
+	requestFilters = append(requestFilters, sanitizeFilter(tunnel))
+	if filter := requestHeaderFilter(tunnel); filter != nil {
+		requestFilters = append(requestFilters, filter)
+	}
+	// Request hooks see the request exactly as it will be forwarded
+	requestFilters = append(requestFilters, requestHooks...)
+
+	// Responses are cached as the local service sent them; everything after
+	// the cache also runs on cache hits
+	if responseCache != nil {
+		responseFilters = append(responseFilters, responseCache.storeFilter)
+	}
+	responseFilters = append(responseFilters, responseHooks...)
+	// Scanning sees the body as it will be sent, whether from the local
+	// service, the cache or a hook
+	if filter := scanFilter(tunnel, emit); filter != nil {
+		responseFilters = append(responseFilters, filter)
+	}
+	if cors != nil {
+		responseFilters = append(responseFilters, cors.addHeaders)
+	}
+	// Header rules run after the other filters so they can override anything
+	// added before
+	if filter := responseHeaderFilter(tunnel); filter != nil {
+		responseFilters = append(responseFilters, filter)
+	}
+	// Compression works on the final body and headers
+	if filter := compressionFilter(tunnel); filter != nil {
+		responseFilters = append(responseFilters, filter)
+	}
+
+	return requestFilters, responseFilters
+}
+
+// filterRequest runs the request through the protocol's filters and returns
+// the first rejection, or nil if the request may be forwarded
+func (atp *AgentTunnelProtocol) filterRequest(message *TunnelMessage) *TunnelMessage {
+	for _, filter := range atp.filters {
+		if response := filter(message); response != nil {
+			return response
+		}
+	}
+	return nil
+}
+
+// filterResponse runs the response through the protocol's response filters
+func (atp *AgentTunnelProtocol) filterResponse(request, response *TunnelMessage) {
+	for _, filter := range atp.responseFilters {
+		filter(request, response)
+	}
+}
+
+// ipFilter rejects clients outside the tunnel's allow list or inside its deny
+// list. Returns nil if no lists are configured.
+func ipFilter(tunnel *config.Tunnel) requestFilter {
+	if tunnel.Settings == nil {
+		return nil
+	}
+	settings := tunnel.Settings.IPFilter
+	if len(settings.Allow) == 0 && len(settings.Deny) == 0 {
+		return nil
+	}
+
+	filter, err := ipfilter.New(settings.Allow, settings.Deny)
+	if err != nil {
+		// Fail closed rather than exposing a tunnel the user meant to restrict
+		logger.Warning("IP filter for tunnel %s is invalid, rejecting all requests: %v", tunnel.Name, err)
+		return func(message *TunnelMessage) *TunnelMessage {
+			return rejectResponse(message.ID, http.StatusForbidden, "Forbidden")
+		}
+	}
+
+	return func(message *TunnelMessage) *TunnelMessage {
+		if filter.Allowed(clientIP(message.Headers)) {
+			return nil
+		}
+		return rejectResponse(message.ID, http.StatusForbidden, "Forbidden")
+	}
+}
+
+// headerFilter rejects requests by the headers the server forwards,
+// such as the client's country or user agent, counting rejections per rule.
+// Returns nil if there are no rules.
+func headerFilter(tunnel *config.Tunnel, stats *tunnelStats) requestFilter {
+	if tunnel.Settings == nil {
+		return nil
+	}
+	settings := tunnel.Settings.HeaderFilter
+	if len(settings.Allow) == 0 && len(settings.Deny) == 0 {
+		return nil
+	}
+
+	filter, err := headerfilter.New(settings.Allow, settings.Deny)
+	if err != nil {
+		// The broken rule may be the one turning away a country or a bot
+		logger.Warning("Header rules for tunnel %s are invalid, rejecting all requests: %v", tunnel.Name, err)
+		return func(message *TunnelMessage) *TunnelMessage {
+			stats.requestDenied("invalid rules")
+			return rejectResponse(message.ID, http.StatusForbidden, "Forbidden")
+		}
+	}
+
+	return func(message *TunnelMessage) *TunnelMessage {
+		denied, rule := filter.Check(func(name string) string {
+			return headerValue(message.Headers, name)
+		})
+		if !denied {
+			return nil
+		}
+		stats.requestDenied(rule)
+		return rejectResponse(message.ID, http.StatusForbidden, "Forbidden")
+	}
+}
+
+// pathFilter answers 403 to requests the tunnel's method and path rules
+// reject, or 405 when only the method is not allowed on the path. Returns nil
+// if there are no rules.
+func pathFilter(tunnel *config.Tunnel) requestFilter {
+	if tunnel.Settings == nil {
+		return nil
+	}
+	settings := tunnel.Settings.PathFilter
+	if len(settings.Allow) == 0 && len(settings.Deny) == 0 {
+		return nil
+	}
+
+	filter, err := pathfilter.New(settings.Allow, settings.Deny)
+	if err != nil {
+		// The broken rule may be the one keeping /admin private
+		logger.Warning("Path filter for tunnel %s is invalid, rejecting all requests: %v", tunnel.Name, err)
+		return func(message *TunnelMessage) *TunnelMessage {
+			return rejectResponse(message.ID, http.StatusForbidden, "Forbidden")
+		}
+	}
+
+	return func(message *TunnelMessage) *TunnelMessage {
+		status, allowed := filter.Check(message.Method, message.URL)
+		switch status {
+		case 0:
+			return nil
+		case http.StatusMethodNotAllowed:
+			response := rejectResponse(message.ID, status, "Method Not Allowed")
+			response.Headers["Allow"] = strings.Join(allowed, ", ")
+			return response
+		default:
+			return rejectResponse(message.ID, status, "Forbidden")
+		}
+	}
+}
+
+// bodySizeFilter answers 413 to requests whose body, or declared
+// Content-Length, exceeds the tunnel's limit. Returns nil if there is no limit.
+func bodySizeFilter(tunnel *config.Tunnel) requestFilter {
+	if tunnel.Settings == nil || tunnel.Settings.Limits.MaxRequestBodyBytes <= 0 {
+		return nil
+	}
+
+	maxBytes := tunnel.Settings.Limits.MaxRequestBodyBytes
+	return func(message *TunnelMessage) *TunnelMessage {
+		declared, _ := strconv.ParseInt(headerValue(message.Headers, "Content-Length"), 10, 64)
+		if int64(len(message.Body)) <= maxBytes && declared <= maxBytes {
+			return nil
+		}
+
+		return rejectResponse(message.ID, http.StatusRequestEntityTooLarge, "Request Entity Too Large")
+	}
+}
+
+// rateLimitFilter answers 429 to clients sending requests faster than the
+// tunnel's rate limit. Clients are told apart by the address the server saw,
+// so changing X-Forwarded-For doesn't get a new bucket. Returns nil if rate
+// limiting is disabled.
+func rateLimitFilter(tunnel *config.Tunnel) requestFilter {
+	settings := tunnel.RateLimitSettings()
+	if !settings.Enabled {
+		return nil
+	}
+
+	limiter := ratelimit.New(settings.PerClientRPS, settings.PerClientBurst, settings.GlobalRPS, settings.GlobalBurst)
+	return func(message *TunnelMessage) *TunnelMessage {
+		ok, wait := limiter.Allow(clientIP(message.Headers))
+		if ok {
+			return nil
+		}
+
+		response := rejectResponse(message.ID, http.StatusTooManyRequests, "Too Many Requests")
+		response.Headers["Retry-After"] = strconv.Itoa(int(math.Ceil(wait.Seconds())))
+		return response
+	}
+}
+
+// basicAuthFilter requires HTTP basic auth credentials matching the tunnel's
+// configured users, showing the 401 page if the visitor cancels the prompt.
+// Returns nil if basic auth is disabled.
+func basicAuthFilter(tunnel *config.Tunnel, pages *pageSet) requestFilter {
+	settings := tunnel.BasicAuthSettings()
+	if !settings.Enabled {
+		return nil
+	}
+
+	users := make(htpasswd.Users)
+	if settings.HtpasswdFile != "" {
+		fileUsers, skipped, err := htpasswd.Load(settings.HtpasswdFile)
+		if err != nil {
+			logger.Warning("Basic auth for tunnel %s: %v", tunnel.Name, err)
+		}
+		if len(skipped) > 0 {
+			logger.Warning("Basic auth for tunnel %s: ignoring users with unsupported password hashes (use htpasswd -m): %s",
+				tunnel.Name, strings.Join(skipped, ", "))
+		}
+		for name, hash := range fileUsers {
+			users[name] = hash
+		}
+	}
+	for name, hash := range settings.Users {
+		users[name] = hash
+	}
+
+	// With no usable users every request is rejected, which is safer than
+	// silently exposing the service
+	if len(users) == 0 {
+		logger.Warning("Basic auth is enabled for tunnel %s but no users are configured; all requests will be rejected", tunnel.Name)
+	}
+
+	challenge := fmt.Sprintf("Basic realm=%q, charset=\"UTF-8\"", settings.Realm)
+	return func(message *TunnelMessage) *TunnelMessage {
+		if name, password, ok := parseBasicAuth(headerValue(message.Headers, "Authorization")); ok && users.Verify(name, password) {
+			return nil
+		}
+
+		response := rejectResponse(message.ID, http.StatusUnauthorized, "Unauthorized")
+		response.Headers["WWW-Authenticate"] = challenge
+		pages.render(PageBasicAuth, message, response, pageData{})
+		return response
+	}
+}
+
+// parseBasicAuth decodes an "Authorization: Basic ..." header value
+func parseBasicAuth(header string) (name, password string, ok bool) {
+	scheme, encoded, found := strings.Cut(header, " ")
+	if !found || !strings.EqualFold(scheme, "Basic") {
+		return "", "", false
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return "", "", false
+	}
+	return strings.Cut(string(decoded), ":")
+}
+
+// rejectResponse builds a response for a request the agent refuses to forward
+func rejectResponse(requestID string, status int, message string) *TunnelMessage {
+	return &TunnelMessage{
+		Type:      "http_response",
+		ID:        requestID,
+		Status:    status,
+		Headers:   map[string]string{"Content-Type": "text/plain; charset=utf-8"},
+		Body:      []byte(message + "\n"),
+		Timestamp: time.Now().Unix(),
+	}
+}
@@ -0,0 +1,70 @@
+package tunnel
+
+import (
+	"fmt"
+	"net/http"
+	"skyport-agent/internal/config"
+	"testing"
+)
+
+func TestClientIPIgnoresSpoofedForwardedFor(t *testing.T) {
+	tests := []struct {
+		headers map[string]string
+		want    string
+	}{
+		{map[string]string{"X-Real-IP": "198.51.100.9", "X-Forwarded-For": "203.0.113.7, 198.51.100.9"}, "198.51.100.9"},
+		{map[string]string{"X-Forwarded-For": "203.0.113.7, 198.51.100.9"}, "198.51.100.9"},
+		{map[string]string{"x-forwarded-for": "198.51.100.9"}, "198.51.100.9"},
+		{map[string]string{}, ""},
+	}
+	for _, test := range tests {
+		if got := clientIP(test.headers); got != test.want {
+			t.Errorf("clientIP(%v) = %q, want %q", test.headers, got, test.want)
+		}
+	}
+}
+
+func TestIPFilterCannotBeBypassedWithForwardedFor(t *testing.T) {
+	allowList := ipFilter(&config.Tunnel{Name: "t", Settings: &config.TunnelSettings{
+		IPFilter: config.IPFilterSettings{Allow: []string{"203.0.113.0/24"}},
+	}})
+	spoofed := &TunnelMessage{ID: "1", Headers: map[string]string{
+		"X-Forwarded-For": "203.0.113.7, 198.51.100.9",
+		"X-Real-IP":       "198.51.100.9",
+	}}
+	if response := allowList(spoofed); response == nil || response.Status != http.StatusForbidden {
+		t.Errorf("allow list passed a client spoofing X-Forwarded-For: %+v", response)
+	}
+
+	denyList := ipFilter(&config.Tunnel{Name: "t", Settings: &config.TunnelSettings{
+		IPFilter: config.IPFilterSettings{Deny: []string{"198.51.100.9"}},
+	}})
+	garbage := &TunnelMessage{ID: "2", Headers: map[string]string{"X-Forwarded-For": "x"}}
+	if response := denyList(garbage); response == nil || response.Status != http.StatusForbidden {
+		t.Errorf("deny list passed a request with an unparseable client address: %+v", response)
+	}
+	allowed := &TunnelMessage{ID: "3", Headers: map[string]string{"X-Real-IP": "203.0.113.7"}}
+	if response := denyList(allowed); response != nil {
+		t.Errorf("deny list rejected a client outside it: %+v", response)
+	}
+}
+
+func TestRateLimitCannotBeBypassedByRotatingForwardedFor(t *testing.T) {
+	limit := rateLimitFilter(&config.Tunnel{Name: "t", Settings: &config.TunnelSettings{
+		RateLimit: config.RateLimitSettings{Enabled: true, PerClientRPS: 1, PerClientBurst: 2},
+	}})
+
+	limited := false
+	for i := 0; i < 5; i++ {
+		message := &TunnelMessage{ID: "r", Headers: map[string]string{
+			"X-Forwarded-For": fmt.Sprintf("10.0.0.%d, 198.51.100.9", i),
+			"X-Real-IP":       "198.51.100.9",
+		}}
+		if response := limit(message); response != nil && response.Status == http.StatusTooManyRequests {
+			limited = true
+		}
+	}
+	if !limited {
+		t.Error("a client rotating X-Forwarded-For was never rate limited")
+	}
+}
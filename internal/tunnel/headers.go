@@ -0,0 +1,64 @@
+package tunnel
+
+import (
+	"net/http"
+	"skyport-agent/internal/config"
+	"strings"
+)
+
+// requestHeaderFilter applies the tunnel's request header rules to requests
+// sent to the local service. Returns nil if there are none.
+func requestHeaderFilter(tunnel *config.Tunnel) requestFilter {
+	if tunnel.Settings == nil || tunnel.Settings.Headers.Request.Empty() {
+		return nil
+	}
+
+	rules := tunnel.Settings.Headers.Request
+	return func(message *TunnelMessage) *TunnelMessage {
+		applyHeaderRules(&message.Headers, rules)
+		return nil
+	}
+}
+
+// responseHeaderFilter applies the tunnel's response header rules to
+// responses sent back to clients. Returns nil if there are none.
+func responseHeaderFilter(tunnel *config.Tunnel) responseFilter {
+	if tunnel.Settings == nil || tunnel.Settings.Headers.Response.Empty() {
+		return nil
+	}
+
+	rules := tunnel.Settings.Headers.Response
+	return func(request, response *TunnelMessage) {
+		applyHeaderRules(&response.Headers, rules)
+	}
+}
+
+// applyHeaderRules removes, then sets, then adds headers. Header names are
+// matched case-insensitively.
+func applyHeaderRules(headers *map[string]string, rules config.HeaderRules) {
+	if *headers == nil {
+		*headers = make(map[string]string)
+	}
+
+	for _, name := range rules.Remove {
+		removeHeader(*headers, name)
+	}
+	for name, value := range rules.Set {
+		removeHeader(*headers, name)
+		(*headers)[http.CanonicalHeaderKey(name)] = value
+	}
+	for name, value := range rules.Add {
+		if headerValue(*headers, name) == "" {
+			(*headers)[http.CanonicalHeaderKey(name)] = value
+		}
+	}
+}
+
+// removeHeader deletes a header from a tunnel message case-insensitively
+func removeHeader(headers map[string]string, name string) {
+	for key := range headers {
+		if strings.EqualFold(key, name) {
+			delete(headers, key)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package tunnel
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"skyport-agent/internal/config"
+	"skyport-agent/internal/logger"
+	"time"
+)
+
+// hookHTTPMessage is a request or response as exchanged with hook programs.
+// Bodies are base64 encoded in JSON.
+type hookHTTPMessage struct {
+	Method  string            `json:"method,omitempty"`
+	URL     string            `json:"url,omitempty"`
+	Status  int               `json:"status,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    []byte            `json:"body,omitempty"`
+}
+
+// hookInput is written to a hook's stdin
+type hookInput struct {
+	Phase    string           `json:"phase"` // "request" or "response"
+	Tunnel   string           `json:"tunnel"`
+	ClientIP string           `json:"client_ip,omitempty"`
+	Request  hookHTTPMessage  `json:"request"`
+	Response *hookHTTPMessage `json:"response,omitempty"`
+}
+
+// hookOutput is read from a hook's stdout. Empty output lets the exchange
+// continue unchanged. In the request phase, a response answers the request
+// without forwarding it (e.g. to deny it); in the response phase it replaces
+// the response.
+type hookOutput struct {
+	Request  *hookHTTPMessage `json:"request,omitempty"`
+	Response *hookHTTPMessage `json:"response,omitempty"`
+}
+
+// hookFilters returns request and response filters running the tunnel's
+// hook programs
+func hookFilters(tunnel *config.Tunnel) ([]requestFilter, []responseFilter) {
+	var requestFilters []requestFilter
+	var responseFilters []responseFilter
+
+	for _, hook := range tunnel.HookSettings() {
+		if hook.Command == "" {
+			continue
+		}
+		hook := hook
+		tunnelName := tunnel.Name
+
+		if hook.Phase == config.HookPhaseRequest || hook.Phase == config.HookPhaseBoth {
+			requestFilters = append(requestFilters, func(message *TunnelMessage) *TunnelMessage {
+				output, err := runHook(hook, hookInput{
+					Phase:    config.HookPhaseRequest,
+					Tunnel:   tunnelName,
+					ClientIP: clientIP(message.Headers),
+					Request:  toHookMessage(message),
+				})
+				if err != nil {
+					logger.Warning("Request hook %s failed for tunnel %s: %v", hook.Command, tunnelName, err)
+					if hook.FailOpen {
+						return nil
+					}
+					return rejectResponse(message.ID, http.StatusBadGateway, "Request hook failed")
+				}
+
+				if output.Response != nil {
+					return fromHookResponse(message.ID, output.Response)
+				}
+				if output.Request != nil {
+					applyHookRequest(message, output.Request)
+				}
+				return nil
+			})
+		}
+
+		if hook.Phase == config.HookPhaseResponse || hook.Phase == config.HookPhaseBoth {
+			responseFilters = append(responseFilters, func(request, response *TunnelMessage) {
+				hookResponse := toHookMessage(response)
+				output, err := runHook(hook, hookInput{
+					Phase:    config.HookPhaseResponse,
+					Tunnel:   tunnelName,
+					ClientIP: clientIP(request.Headers),
+					Request:  toHookMessage(request),
+					Response: &hookResponse,
+				})
+				if err != nil {
+					logger.Warning("Response hook %s failed for tunnel %s: %v", hook.Command, tunnelName, err)
+					if !hook.FailOpen {
+						*response = *rejectResponse(response.ID, http.StatusBadGateway, "Response hook failed")
+					}
+					return
+				}
+
+				if output.Response != nil {
+					*response = *fromHookResponse(response.ID, output.Response)
+				}
+			})
+		}
+	}
+
+	return requestFilters, responseFilters
+}
+
+// runHook runs a hook program with the input as JSON on stdin and parses its
+// stdout
+func runHook(hook config.HookSettings, input hookInput) (*hookOutput, error) {
+	data, err := json.Marshal(input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode hook input: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), hook.Timeout.Duration)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, hook.Command, hook.Args...)
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Env = append(os.Environ(), "SKYPORT_TUNNEL="+input.Tunnel, "SKYPORT_HOOK_PHASE="+input.Phase)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, fmt.Errorf("timed out after %s", hook.Timeout.Duration)
+		}
+		return nil, fmt.Errorf("%w: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+
+	var output hookOutput
+	if len(bytes.TrimSpace(stdout.Bytes())) == 0 {
+		return &output, nil
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &output); err != nil {
+		return nil, fmt.Errorf("invalid hook output: %w", err)
+	}
+	return &output, nil
+}
+
+func toHookMessage(message *TunnelMessage) hookHTTPMessage {
+	return hookHTTPMessage{
+		Method:  message.Method,
+		URL:     message.URL,
+		Status:  message.Status,
+		Headers: message.Headers,
+		Body:    message.Body,
+	}
+}
+
+// applyHookRequest replaces the parts of the request the hook returned
+func applyHookRequest(message *TunnelMessage, request *hookHTTPMessage) {
+	if request.Method != "" {
+		message.Method = request.Method
+	}
+	if request.URL != "" {
+		message.URL = request.URL
+	}
+	if request.Headers != nil {
+		message.Headers = request.Headers
+	}
+	if request.Body != nil {
+		message.Body = request.Body
+	}
+}
+
+// fromHookResponse builds the response to send from one returned by a hook
+func fromHookResponse(requestID string, response *hookHTTPMessage) *TunnelMessage {
+	status := response.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	headers := response.Headers
+	if headers == nil {
+		headers = make(map[string]string)
+	}
+
+	return &TunnelMessage{
+		Type:      "http_response",
+		ID:        requestID,
+		Status:    status,
+		Headers:   headers,
+		Body:      response.Body,
+		Timestamp: time.Now().Unix(),
+	}
+}
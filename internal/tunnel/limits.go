@@ -0,0 +1,105 @@
+package tunnel
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"skyport-agent/internal/config"
+	"sync"
+)
+
+// resourceLimiter caps the requests all tunnels of a manager handle at once
+// and the request and response bodies they hold in memory. A nil limiter
+// admits everything.
+type resourceLimiter struct {
+	maxRequests int
+	maxBytes    int64
+
+	mu       sync.Mutex
+	requests int
+	bytes    int64
+}
+
+// newResourceLimiter returns a limiter for the configured caps, or nil if
+// none are set
+func newResourceLimiter(settings config.ResourceSettings) *resourceLimiter {
+	if settings.MaxConcurrentRequests <= 0 && settings.MaxBufferMB <= 0 {
+		return nil
+	}
+	return &resourceLimiter{
+		maxRequests: settings.MaxConcurrentRequests,
+		maxBytes:    int64(settings.MaxBufferMB) * 1024 * 1024,
+	}
+}
+
+// reservation is what a limiter holds for one admitted request. A nil
+// reservation holds nothing and reads bodies without a limit.
+type reservation struct {
+	limiter *resourceLimiter
+	bytes   int64
+}
+
+// admit reserves a request slot and memory for its body. If either limit
+// would be exceeded, it returns the 503 response to send instead.
+func (l *resourceLimiter) admit(message *TunnelMessage) (*reservation, *TunnelMessage) {
+	if l == nil {
+		return nil, nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	size := int64(len(message.Body))
+	if (l.maxRequests > 0 && l.requests >= l.maxRequests) || !l.fitsLocked(size) {
+		response := rejectResponse(message.ID, http.StatusServiceUnavailable, "Service Unavailable")
+		response.Headers["Retry-After"] = "1"
+		return nil, response
+	}
+	l.requests++
+	l.bytes += size
+	return &reservation{limiter: l, bytes: size}, nil
+}
+
+func (l *resourceLimiter) fitsLocked(size int64) bool {
+	return l.maxBytes <= 0 || l.bytes+size <= l.maxBytes
+}
+
+// readBody reads a response body into memory reserved for the request. It
+// fails rather than hold more than the buffer limit allows.
+func (r *reservation) readBody(body io.Reader) ([]byte, error) {
+	if r == nil || r.limiter.maxBytes <= 0 {
+		return io.ReadAll(body)
+	}
+	l := r.limiter
+
+	l.mu.Lock()
+	available := l.maxBytes - l.bytes
+	l.mu.Unlock()
+
+	data, err := io.ReadAll(io.LimitReader(body, available+1))
+	if err != nil {
+		return nil, err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	size := int64(len(data))
+	if !l.fitsLocked(size) {
+		return nil, fmt.Errorf("response is larger than the agent's free buffer memory (resources.max_buffer_mb)")
+	}
+	l.bytes += size
+	r.bytes += size
+	return data, nil
+}
+
+// release returns the request's slot and memory to the limiter
+func (r *reservation) release() {
+	if r == nil {
+		return
+	}
+
+	r.limiter.mu.Lock()
+	defer r.limiter.mu.Unlock()
+	r.limiter.requests--
+	r.limiter.bytes -= r.bytes
+}
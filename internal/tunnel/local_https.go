@@ -0,0 +1,65 @@
+package tunnel
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"skyport-agent/internal/config"
+	"skyport-agent/internal/devcert"
+	"skyport-agent/internal/hostsfile"
+	"skyport-agent/internal/localnet"
+	"strconv"
+	"time"
+)
+
+// newLocalHTTPSServer builds the HTTPS reverse proxy in front of the
+// tunnel's local target, following it when it is switched. Its certificate
+// covers localhost and the domains mapped to the tunnel with
+// 'skyport dev-domain'.
+func newLocalHTTPSServer(tunnel *config.Tunnel, target *localTarget, port int) (*http.Server, error) {
+	if tunnel.StaticSettings().Dir != "" {
+		return nil, fmt.Errorf("the tunnel serves a directory, not a local service")
+	}
+
+	authority, err := devcert.Load()
+	if err != nil {
+		return nil, err
+	}
+	hosts := []string{"localhost", "127.0.0.1", "::1"}
+	if data, err := os.ReadFile(hostsfile.Path()); err == nil {
+		for _, entry := range hostsfile.Entries(data) {
+			if entry.Tunnel == tunnel.Name {
+				hosts = append(hosts, entry.Domain)
+			}
+		}
+	}
+	cert, err := authority.Issue(hosts)
+	if err != nil {
+		return nil, err
+	}
+
+	proxy := &httputil.ReverseProxy{
+		Rewrite: func(r *httputil.ProxyRequest) {
+			r.SetURL(&url.URL{Scheme: "http", Host: target.address()})
+			// The local service sees the name and scheme the browser used,
+			// for absolute URLs and secure cookies
+			r.Out.Host = r.In.Host
+			r.SetXForwarded()
+		},
+		Transport: localnet.Transport,
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			http.Error(w, fmt.Sprintf("Failed to connect to local service: %v", err), http.StatusBadGateway)
+		},
+	}
+
+	return &http.Server{
+		Addr:              net.JoinHostPort("127.0.0.1", strconv.Itoa(port)),
+		Handler:           proxy,
+		TLSConfig:         &tls.Config{Certificates: []tls.Certificate{*cert}, MinVersion: tls.VersionTLS12},
+		ReadHeaderTimeout: 10 * time.Second,
+	}, nil
+}
@@ -0,0 +1,93 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"skyport-agent/internal/config"
+	"skyport-agent/internal/crash"
+	"skyport-agent/internal/logger"
+	"time"
+)
+
+// startLocalServers starts the listeners a tunnel is also served on locally
+// while it runs: local HTTPS and the mirror port, where enabled. They are
+// started once per tunnel, so reconnects keep the same listeners, and are
+// stopped with stopLocalServers. A listener that can't be started is
+// reported and skipped; the tunnel itself is unaffected.
+func (tm *TunnelManager) startLocalServers(tunnel *config.Tunnel) {
+	tm.localServersMutex.Lock()
+	defer tm.localServersMutex.Unlock()
+	if _, exists := tm.localServers[tunnel.ID]; exists {
+		return
+	}
+
+	var servers []*http.Server
+	if settings := tunnel.LocalHTTPSSettings(); settings.Enabled {
+		server, err := newLocalHTTPSServer(tunnel, tm.targetFor(tunnel), settings.Port)
+		if err == nil {
+			err = serveLocal(tunnel, server)
+		}
+		if err != nil {
+			logger.Warning("Local HTTPS disabled for tunnel %s: %v", tunnel.Name, err)
+		} else {
+			servers = append(servers, server)
+			logger.Info("Tunnel %s is also served at https://localhost:%d", tunnel.Name, settings.Port)
+		}
+	}
+	if settings := tunnel.MirrorSettings(); settings.Enabled {
+		server := tm.newMirrorServer(tunnel, settings.Port)
+		if err := serveLocal(tunnel, server); err != nil {
+			logger.Warning("Local mirror disabled for tunnel %s: %v", tunnel.Name, err)
+		} else {
+			servers = append(servers, server)
+			logger.Info("Tunnel %s is mirrored at http://localhost:%d", tunnel.Name, settings.Port)
+		}
+	}
+
+	if len(servers) > 0 {
+		tm.localServers[tunnel.ID] = servers
+	}
+}
+
+// serveLocal binds a local listener and serves it in the background, over
+// TLS if the server has a TLS config
+func serveLocal(tunnel *config.Tunnel, server *http.Server) error {
+	listener, err := net.Listen("tcp", server.Addr)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		defer crash.Recover("local server")
+		var err error
+		if server.TLSConfig != nil {
+			err = server.ServeTLS(listener, "", "")
+		} else {
+			err = server.Serve(listener)
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Warning("Local listener %s for tunnel %s stopped: %v", server.Addr, tunnel.Name, err)
+		}
+	}()
+	return nil
+}
+
+// stopLocalServers stops the tunnel's local listeners, if it has any
+func (tm *TunnelManager) stopLocalServers(tunnelID string) {
+	tm.localServersMutex.Lock()
+	servers := tm.localServers[tunnelID]
+	delete(tm.localServers, tunnelID)
+	tm.localServersMutex.Unlock()
+
+	// Called while disconnecting, so requests in flight finish in the background
+	for _, server := range servers {
+		go func(server *http.Server) {
+			defer crash.Recover("local server")
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			server.Shutdown(ctx)
+		}(server)
+	}
+}
@@ -0,0 +1,108 @@
+package tunnel
+
+import (
+	"skyport-agent/internal/accesslog"
+	"skyport-agent/internal/config"
+	"skyport-agent/internal/logger"
+	"skyport-agent/internal/sampling"
+)
+
+// tunnelLogs holds the optional per-tunnel request logs. Either field may be
+// nil when that log is disabled.
+type tunnelLogs struct {
+	access  *accesslog.Logger
+	samples *sampling.Sampler
+}
+
+// logsFor returns the tunnel's request logs, opening them on first use.
+// Returns nil if no request logging is enabled for the tunnel.
+func (tm *TunnelManager) logsFor(tunnel *config.Tunnel) *tunnelLogs {
+	tm.logsMutex.Lock()
+	defer tm.logsMutex.Unlock()
+
+	if logs, exists := tm.logs[tunnel.ID]; exists {
+		return logs
+	}
+
+	resources := tm.config.GetSettings().Resources
+	logs := &tunnelLogs{
+		access:  openAccessLog(tunnel, resources.MaxLogMB),
+		samples: openSampler(tunnel, resources.MaxSamplesMB),
+	}
+	if logs.access == nil && logs.samples == nil {
+		return nil
+	}
+
+	tm.logs[tunnel.ID] = logs
+	return logs
+}
+
+// closeLogs closes a tunnel's request logs if any are open
+func (tm *TunnelManager) closeLogs(tunnelID string) {
+	tm.logsMutex.Lock()
+	logs, exists := tm.logs[tunnelID]
+	delete(tm.logs, tunnelID)
+	tm.logsMutex.Unlock()
+
+	if !exists {
+		return
+	}
+	if logs.access != nil {
+		logs.access.Close()
+	}
+	if logs.samples != nil {
+		logs.samples.Close()
+	}
+}
+
+// openAccessLog opens the tunnel's access log, keeping at most maxTotalMB of
+// it (0 = as configured), or returns nil if it is disabled or can't be opened
+func openAccessLog(tunnel *config.Tunnel, maxTotalMB int) *accesslog.Logger {
+	settings := tunnel.AccessLogSettings()
+	if !settings.Enabled {
+		return nil
+	}
+
+	path, err := config.AccessLogPath(tunnel)
+	if err != nil {
+		logger.Warning("Access log disabled for tunnel %s: %v", tunnel.Name, err)
+		return nil
+	}
+
+	maxSizeMB, maxBackups := config.CapRetention(settings.MaxSizeMB, settings.MaxBackups, maxTotalMB)
+	accessLog, err := accesslog.Open(path, settings.Format, int64(maxSizeMB)*1024*1024, maxBackups)
+	if err != nil {
+		logger.Warning("Access log disabled for tunnel %s: %v", tunnel.Name, err)
+		return nil
+	}
+
+	logger.Debug("Access log for tunnel %s: %s", tunnel.Name, path)
+	return accessLog
+}
+
+// openSampler opens the tunnel's request sample file, keeping at most
+// maxTotalMB of samples (0 = as configured), or returns nil if sampling is
+// disabled or the file can't be opened
+func openSampler(tunnel *config.Tunnel, maxTotalMB int) *sampling.Sampler {
+	settings := tunnel.SamplingSettings()
+	if !settings.Enabled {
+		return nil
+	}
+
+	path, err := config.SamplesPath(tunnel)
+	if err != nil {
+		logger.Warning("Request sampling disabled for tunnel %s: %v", tunnel.Name, err)
+		return nil
+	}
+
+	maxSizeMB, maxBackups := config.CapRetention(settings.MaxSizeMB, settings.MaxBackups, maxTotalMB)
+	sampler, err := sampling.Open(path, settings.Percent, settings.MaxBodyBytes,
+		int64(maxSizeMB)*1024*1024, maxBackups, settings.RedactHeaders)
+	if err != nil {
+		logger.Warning("Request sampling disabled for tunnel %s: %v", tunnel.Name, err)
+		return nil
+	}
+
+	logger.Debug("Sampling %.1f%% of requests for tunnel %s to %s", settings.Percent, tunnel.Name, path)
+	return sampler
+}
@@ -2,72 +2,391 @@ package tunnel
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
 	"skyport-agent/internal/config"
+	"skyport-agent/internal/crash"
 	"skyport-agent/internal/logger"
+	"skyport-agent/internal/state"
+	"skyport-agent/internal/telemetry"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/gorilla/websocket"
 )
 
+// ErrAlreadyConnected is returned when connecting a tunnel that already has a live connection
+var ErrAlreadyConnected = errors.New("already connected")
+
+// ErrUnauthorized is returned when the server rejects the tunnel's credentials
+var ErrUnauthorized = errors.New("tunnel authentication rejected")
+
+// ErrSubdomainInUse is returned when the server already has a connection for
+// the tunnel's subdomain
+var ErrSubdomainInUse = errors.New("subdomain already in use")
+
 type TunnelManager struct {
 	config        *config.Config
 	activeTunnels map[string]*TunnelConnection
+	supervisors   map[string]*tunnelSupervisor
+	// connecting holds tunnels ConnectTunnel is dialing, set to false when
+	// the tunnel is disconnected before the dial returns
+	connecting    map[string]bool
+	eventHandlers []func(Event)
+	telemetry     *telemetry.Provider
+	endpoints     *endpointSelector
 	mutex         sync.RWMutex
+
+	// Request logs are shared by all connections of a tunnel (a make-before-break
+	// reconnect briefly has two) and closed when the tunnel is disconnected
+	logs      map[string]*tunnelLogs
+	logsMutex sync.Mutex
+
+	stats      map[string]*tunnelStats
+	statsMutex sync.Mutex
+
+	// localServers holds the listeners tunnels are also served on locally:
+	// local HTTPS and the mirror port
+	localServers      map[string][]*http.Server
+	localServersMutex sync.Mutex
+
+	// targets holds the local address each connected tunnel forwards to,
+	// which 'skyport tunnel switch' can change while it runs
+	targets      map[string]*localTarget
+	targetsMutex sync.Mutex
+
+	// limits caps requests and buffered bodies across all tunnels
+	limits *resourceLimiter
+
+	// stopped holds tunnels disconnected by their idle timeout or taken over
+	// by another machine, until they are connected again
+	stopped map[string]bool
+
+	// resumes hold responses across reconnects of tunnels whose server
+	// supports FeatureResume
+	resumes     map[string]*resumeBuffer
+	resumeMutex sync.Mutex
+
+	// takeovers holds tunnels allowed to take over their connection from
+	// another machine, until they are disconnected
+	takeovers map[string]bool
+
+	// commandHandler runs commands sent by the server (see OnCommand)
+	commandHandler func(Command) CommandResult
 }
 
 type TunnelConnection struct {
-	Tunnel     config.Tunnel
-	Connection *websocket.Conn
-	Protocol   *AgentTunnelProtocol
-	Context    context.Context
-	Cancel     context.CancelFunc
-	Status     string
+	Tunnel      config.Tunnel
+	Connection  *websocket.Conn
+	Protocol    *AgentTunnelProtocol
+	Context     context.Context
+	Cancel      context.CancelFunc
+	Status      string
+	Features    map[string]bool // Protocol features advertised by the server
+	Endpoint    Endpoint        // Server endpoint (region) the connection is to
+	ConnectedAt time.Time
+
+	// exited is closed once handleTunnelConnection has returned and the
+	// connection is no longer the tunnel's active one
+	exited chan struct{}
+
+	closeMutex    sync.Mutex
+	closeReason   string
+	userInitiated bool
+	// busy is set when the server closed the connection asking the agent to
+	// retry later
+	busy *ServerBusyError
+}
+
+// setCloseReason records why the connection ended; the first reason wins
+func (tc *TunnelConnection) setCloseReason(reason string, userInitiated bool) {
+	tc.closeMutex.Lock()
+	defer tc.closeMutex.Unlock()
+
+	if tc.closeReason == "" {
+		tc.closeReason = reason
+		tc.userInitiated = userInitiated
+	}
+}
+
+// setBusy records that the server closed the connection because it is busy
+func (tc *TunnelConnection) setBusy(busy *ServerBusyError) {
+	tc.closeMutex.Lock()
+	defer tc.closeMutex.Unlock()
+
+	tc.busy = busy
+}
+
+// serverBusy returns why the server asked to retry later, if it closed the
+// connection for that reason
+func (tc *TunnelConnection) serverBusy() *ServerBusyError {
+	tc.closeMutex.Lock()
+	defer tc.closeMutex.Unlock()
+
+	return tc.busy
+}
+
+// getCloseReason returns why the connection ended
+func (tc *TunnelConnection) getCloseReason() (string, bool) {
+	tc.closeMutex.Lock()
+	defer tc.closeMutex.Unlock()
+
+	return tc.closeReason, tc.userInitiated
+}
+
+// SupportsFeature reports whether the server advertised the given protocol feature
+func (tc *TunnelConnection) SupportsFeature(feature string) bool {
+	return tc.Features[feature]
+}
+
+// featureList returns the negotiated protocol features, sorted
+func (tc *TunnelConnection) featureList() []string {
+	features := make([]string, 0, len(tc.Features))
+	for feature := range tc.Features {
+		features = append(features, feature)
+	}
+	sort.Strings(features)
+	return features
 }
 
 func NewTunnelManager(cfg *config.Config) *TunnelManager {
 	return &TunnelManager{
 		config:        cfg,
 		activeTunnels: make(map[string]*TunnelConnection),
+		supervisors:   make(map[string]*tunnelSupervisor),
+		connecting:    make(map[string]bool),
+		logs:          make(map[string]*tunnelLogs),
+		localServers:  make(map[string][]*http.Server),
+		targets:       make(map[string]*localTarget),
+		stats:         make(map[string]*tunnelStats),
+		telemetry:     telemetry.New(cfg.GetSettings().Telemetry),
+		endpoints:     newEndpointSelector(cfg),
+		limits:        newResourceLimiter(cfg.GetSettings().Resources),
+		stopped:       make(map[string]bool),
+		takeovers:     make(map[string]bool),
+		resumes:       make(map[string]*resumeBuffer),
+	}
+}
+
+// Shutdown flushes buffered telemetry and traffic counters. Call it once the
+// manager is no longer used.
+func (tm *TunnelManager) Shutdown() {
+	tm.statsMutex.Lock()
+	tunnelIDs := make([]string, 0, len(tm.stats))
+	for tunnelID := range tm.stats {
+		tunnelIDs = append(tunnelIDs, tunnelID)
+	}
+	tm.statsMutex.Unlock()
+
+	for _, tunnelID := range tunnelIDs {
+		tm.flushUsage(tunnelID)
 	}
+
+	tm.telemetry.Shutdown()
 }
 
 func (tm *TunnelManager) ConnectTunnel(tunnel *config.Tunnel, token string) error {
 	tm.mutex.Lock()
-	defer tm.mutex.Unlock()
-
 	// Check if tunnel is already connected
 	if _, exists := tm.activeTunnels[tunnel.ID]; exists {
-		return fmt.Errorf("tunnel %s is already connected", tunnel.Name)
+		tm.mutex.Unlock()
+		return fmt.Errorf("tunnel %s is %w", tunnel.Name, ErrAlreadyConnected)
+	}
+	if _, exists := tm.connecting[tunnel.ID]; exists {
+		tm.mutex.Unlock()
+		return fmt.Errorf("tunnel %s is being connected: %w", tunnel.Name, ErrAlreadyConnected)
 	}
+	tm.connecting[tunnel.ID] = true
+	tm.mutex.Unlock()
 
-	// Create connection context
-	ctx, cancel := context.WithCancel(context.Background())
+	// Dial without the lock, so a slow handshake does not hold up every
+	// other tunnel
+	dialed, err := tm.dialTunnelServer(tunnel, token)
+
+	tm.mutex.Lock()
+	defer tm.mutex.Unlock()
 
-	// Connect to tunnel server - convert HTTP URL to WebSocket URL
-	serverURL := strings.Replace(tm.config.ServerURL, "http://", "ws://", 1)
-	serverURL = strings.Replace(serverURL, "https://", "wss://", 1)
-	serverURL = serverURL + "/tunnel/connect"
+	wanted := tm.connecting[tunnel.ID]
+	delete(tm.connecting, tunnel.ID)
+	if err != nil {
+		return err
+	}
+	if !wanted {
+		dialed.conn.Close()
+		return fmt.Errorf("tunnel %s was disconnected while connecting", tunnel.Name)
+	}
+
+	tunnelConn := tm.newTunnelConnection(*tunnel, dialed)
+	tm.activeTunnels[tunnel.ID] = tunnelConn
+
+	// Start tunnel handler in background
+	go tm.handleTunnelConnection(tunnelConn)
+
+	return nil
+}
+
+// ReconnectTunnel replaces a tunnel's WebSocket connection without waiting for
+// it to fail. When the server supports make-before-break, the new connection is
+// established before the old one is closed so in-flight traffic keeps flowing;
+// otherwise the old connection is closed and a new one is dialed immediately.
+func (tm *TunnelManager) ReconnectTunnel(tunnelID string, token string) error {
+	tm.mutex.RLock()
+	oldConn, exists := tm.activeTunnels[tunnelID]
+	tm.mutex.RUnlock()
+
+	if !exists {
+		return fmt.Errorf("tunnel not connected")
+	}
+
+	tunnel := oldConn.Tunnel
+
+	if !oldConn.SupportsFeature(FeatureMakeBeforeBreak) {
+		logger.Debug("Server does not support make-before-break for tunnel %s, reconnecting directly", tunnel.Name)
+
+		// Supervised tunnels are redialed by their supervisor, skipping its backoff
+		if tm.IsSupervised(tunnelID) {
+			tm.dropConnection(oldConn, "Reconnecting")
+			tm.RequestReconnect(tunnelID)
+			return nil
+		}
+
+		if err := tm.DisconnectTunnel(tunnelID); err != nil {
+			logger.Debug("Failed to disconnect tunnel %s before reconnect: %v", tunnel.Name, err)
+		}
+		return tm.ConnectTunnel(&tunnel, token)
+	}
+
+	// Dial the replacement while the old connection keeps serving requests
+	dialed, err := tm.dialTunnelServer(&tunnel, token)
+	if err != nil {
+		return err
+	}
+
+	tm.mutex.Lock()
+	if tm.activeTunnels[tunnelID] != oldConn {
+		// Disconnected or replaced while dialing
+		tm.mutex.Unlock()
+		dialed.conn.Close()
+		return fmt.Errorf("tunnel %s changed while reconnecting", tunnel.Name)
+	}
+	newConn := tm.newTunnelConnection(tunnel, dialed)
+	tm.activeTunnels[tunnelID] = newConn
+	tm.mutex.Unlock()
+
+	go tm.handleTunnelConnection(newConn)
+
+	// Only now retire the old connection, once it has finished what it was
+	// handling
+	go tm.retireConnection(oldConn)
+	logger.Debug("Tunnel %s switched to new connection (make-before-break)", tunnel.Name)
+
+	return nil
+}
+
+// retireTimeout bounds how long a connection replaced by make-before-break
+// is kept open for the requests and WebSocket sessions it is handling
+var retireTimeout = 30 * time.Second
+
+// retireConnection closes a connection replaced by make-before-break once
+// the requests and WebSocket sessions it was handling have finished, or
+// retireTimeout has passed. New requests already go to its replacement.
+func (tm *TunnelManager) retireConnection(tunnelConn *TunnelConnection) {
+	defer crash.Recover("retire connection")
+
+	select {
+	case <-tunnelConn.Protocol.drained():
+	case <-tunnelConn.exited:
+		// Already gone
+		return
+	case <-time.After(retireTimeout):
+		logger.Debug("Tunnel %s: closing replaced connection with requests still in flight after %s",
+			tunnelConn.Tunnel.Name, retireTimeout)
+	}
+	tm.closeConnection(tunnelConn, "Replaced by new connection")
+}
+
+// dialTunnelServer opens the WebSocket connection for a tunnel, trying the
+// server's endpoints best first, and returns it with the protocol features
+// advertised in the handshake response
+func (tm *TunnelManager) dialTunnelServer(tunnel *config.Tunnel, token string) (*dialedConn, error) {
+	endpoints := tm.endpoints.candidates()
+
+	var lastErr error
+	for i, endpoint := range endpoints {
+		dialed, err := tm.dialEndpoint(endpoint, tunnel, token)
+		if err == nil {
+			if i > 0 {
+				logger.Info("Tunnel %s connected via %s (failed over from %s)", tunnel.Name, endpoint, endpoints[0])
+			}
+			return dialed, nil
+		}
+
+		// Credentials rejected (or a subdomain taken, or the tunnel running
+		// elsewhere) in one region are in all of them
+		if errors.Is(err, ErrUnauthorized) || errors.Is(err, ErrSubdomainInUse) || errors.Is(err, ErrRunningElsewhere) {
+			return nil, err
+		}
+
+		tm.endpoints.failed(endpoint)
+		lastErr = err
+		if i+1 < len(endpoints) {
+			logger.Warning("Tunnel %s could not connect via %s, trying %s: %v", tunnel.Name, endpoint, endpoints[i+1], err)
+		}
+	}
+	return nil, lastErr
+}
+
+// dialedConn is a freshly dialed tunnel connection and what was negotiated on it
+type dialedConn struct {
+	conn     *websocket.Conn
+	features map[string]bool
+	endpoint Endpoint
+}
+
+// dialEndpoint opens the WebSocket connection for a tunnel at one endpoint
+func (tm *TunnelManager) dialEndpoint(endpoint Endpoint, tunnel *config.Tunnel, token string) (*dialedConn, error) {
+	if err := tm.config.TLSError(); err != nil {
+		return nil, fmt.Errorf("refusing to connect to tunnel server: %w", err)
+	}
+
+	// Keep both tokens out of logs, such as errors that echo the handshake
+	logger.AddSecret(token)
+	logger.AddSecret(tunnel.AuthToken)
 
 	// Create headers with authentication
 	headers := http.Header{}
 	headers.Add("Authorization", fmt.Sprintf("Bearer %s", token))
 	headers.Add("X-Tunnel-ID", tunnel.ID)
 	headers.Add("X-Tunnel-Auth", tunnel.AuthToken)
+	headers.Add(FeaturesHeader, strings.Join(agentFeatures, ","))
+	tm.handshakeHeaders(headers, tunnel.ID)
+	resumeHeaders(headers)
 
 	// Create custom dialer with TCP keepalive enabled
 	// This is critical for maintaining long-lived connections through NAT/firewalls
 	dialer := &websocket.Dialer{
 		NetDial: func(network, addr string) (net.Conn, error) {
-			// Dial with timeout
-			conn, err := net.DialTimeout(network, addr, 30*time.Second)
+			// Dial with timeout, timing the TCP handshake itself (after the
+			// DNS lookup) as a baseline for connection quality
+			var connectStart atomic.Int64
+			netDialer := &net.Dialer{
+				Timeout: 30 * time.Second,
+				Control: func(network, address string, c syscall.RawConn) error {
+					connectStart.Store(time.Now().UnixNano())
+					return nil
+				},
+			}
+			conn, err := netDialer.Dial(network, addr)
 			if err != nil {
 				return nil, err
 			}
+			connect := time.Since(time.Unix(0, connectStart.Load()))
 
 			// Enable TCP keepalive to maintain connection through NAT/firewalls
 			if tcpConn, ok := conn.(*net.TCPConn); ok {
@@ -89,160 +408,228 @@ func (tm *TunnelManager) ConnectTunnel(tunnel *config.Tunnel, token string) erro
 				tcpConn.SetWriteBuffer(64 * 1024)
 			}
 
-			return conn, nil
+			// Lets the message writer coalesce frames into one write
+			return &batchConn{Conn: conn, connect: connect}, nil
 		},
+		// Same proxy and TLS settings as the server's HTTP API
+		Proxy:            http.ProxyFromEnvironment,
 		HandshakeTimeout: 45 * time.Second,
+		TLSClientConfig:  tm.config.TLSConfig(),
 		// Enable compression for better performance over slow connections
 		EnableCompression: true,
 	}
 
 	// Connect WebSocket using custom dialer
-	conn, _, err := dialer.Dial(serverURL, headers)
+	conn, resp, err := dialer.Dial(endpoint.connectURL(), headers)
 	if err != nil {
-		cancel()
-		return fmt.Errorf("failed to connect to tunnel server: %w", err)
+		if resp != nil && (resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden) {
+			return nil, fmt.Errorf("failed to connect to tunnel server: %w (status %d)", ErrUnauthorized, resp.StatusCode)
+		}
+		if resp != nil {
+			if busy := busyResponse(resp); busy != nil {
+				return nil, fmt.Errorf("failed to connect to tunnel server: %w", busy)
+			}
+		}
+		if resp != nil && resp.StatusCode == http.StatusConflict {
+			if elsewhere := parseConflict(resp); elsewhere != nil {
+				return nil, fmt.Errorf("failed to connect to tunnel server: %w", elsewhere)
+			}
+			return nil, fmt.Errorf("failed to connect to tunnel server: %w", ErrSubdomainInUse)
+		}
+		return nil, fmt.Errorf("failed to connect to tunnel server: %w", err)
 	}
 
 	logger.Debug("Tunnel %s connected with TCP keepalive enabled", tunnel.Name)
 
-	// Create tunnel protocol handler
-	protocol := NewAgentTunnelProtocol(conn, tunnel.ID, tunnel.LocalPort)
-
-	// Create tunnel connection
-	tunnelConn := &TunnelConnection{
-		Tunnel:     *tunnel,
-		Connection: conn,
-		Protocol:   protocol,
-		Context:    ctx,
-		Cancel:     cancel,
-		Status:     "connected",
+	var features map[string]bool
+	if resp != nil {
+		features = parseFeatures(resp.Header.Get(FeaturesHeader))
 	}
 
-	tm.activeTunnels[tunnel.ID] = tunnelConn
+	return &dialedConn{conn: conn, features: features, endpoint: endpoint}, nil
+}
 
-	// Start tunnel handler in background
-	go tm.handleTunnelConnection(tunnelConn)
+// newTunnelConnection wraps a freshly dialed WebSocket in a TunnelConnection
+func (tm *TunnelManager) newTunnelConnection(tunnel config.Tunnel, dialed *dialedConn) *TunnelConnection {
+	ctx, cancel := context.WithCancel(context.Background())
+	conn := dialed.conn
+
+	protocol := NewAgentTunnelProtocol(conn, tunnel.ID, tunnel.LocalAddress())
+	protocol.target = tm.targetFor(&tunnel)
+	protocol.logs = tm.logsFor(&tunnel)
+	tm.startLocalServers(&tunnel)
+	protocol.SetTelemetry(tm.telemetry, tunnel.Name)
+	protocol.stats = tm.statsFor(&tunnel)
+	protocol.pages = loadPages(&tunnel)
+	protocol.filters, protocol.responseFilters = buildFilters(&tunnel, protocol.pages, protocol.stats, tm.emit)
+	protocol.static = staticHandler(&tunnel)
+	protocol.split = newTrafficSplit(&tunnel)
+	protocol.websocket = tunnel.WebSocketSettings()
+	protocol.limits = tm.limits
+	protocol.serverMessage = func(message *TunnelMessage) {
+		tm.handleServerMessage(tunnel, message)
+	}
+	if dialed.features[FeatureResume] {
+		protocol.resume = tm.resumeFor(tunnel.ID)
+	}
+	protocol.writer.stalled = func() {
+		protocol.stats.writeStalled()
+		tm.telemetry.RecordWriteStall(tunnel.Name)
+	}
 
-	return nil
+	var connect time.Duration
+	if batch := batchConnOf(conn); batch != nil {
+		connect = batch.connect
+	}
+	protocol.stats.connected(connect)
+
+	return &TunnelConnection{
+		Tunnel:      tunnel,
+		Connection:  conn,
+		Protocol:    protocol,
+		Context:     ctx,
+		Cancel:      cancel,
+		Status:      "connected",
+		Features:    dialed.features,
+		Endpoint:    dialed.endpoint,
+		ConnectedAt: time.Now(),
+		exited:      make(chan struct{}),
+	}
 }
 
 // ConnectTunnelWithRetry connects a tunnel with automatic reconnection on failure
-// This provides resilience against network interruptions and server restarts
+// This provides resilience against network interruptions and server restarts.
+// With autoReconnect the tunnel is handed to its supervisor, which keeps it
+// connected for as long as it runs; otherwise a limited number of attempts is
+// made with jittered backoff. Nothing is attempted if the tunnel has expired
+// or its preflight check fails.
 func (tm *TunnelManager) ConnectTunnelWithRetry(tunnel *config.Tunnel, token string, autoReconnect bool) error {
+	if err := checkExpiry(tunnel); err != nil {
+		return err
+	}
+
+	// A local service that is down fails the start rather than the requests
+	if err := Preflight(tunnel); err != nil {
+		return err
+	}
+
+	tm.resetIdle(tunnel)
+
+	if autoReconnect {
+		if err := tm.Supervise(tunnel, token); err != nil {
+			return fmt.Errorf("initial connection failed, retrying in background: %w", err)
+		}
+		logger.Debug("Tunnel %s connected successfully (supervised)", tunnel.Name)
+		return nil
+	}
+
 	maxRetries := 5
-	baseDelay := 2 * time.Second
-	maxDelay := 60 * time.Second
+	backoff := NewBackoff()
 
-	attempt := 0
 	for {
 		// Attempt to connect
 		err := tm.ConnectTunnel(tunnel, token)
 		if err == nil {
 			logger.Debug("Tunnel %s connected successfully", tunnel.Name)
-
-			// If auto-reconnect is enabled, monitor for disconnection and reconnect
-			if autoReconnect {
-				go tm.monitorAndReconnect(tunnel, token)
-			}
 			return nil
 		}
 
-		// Check if it's a "already connected" error
-		if strings.Contains(err.Error(), "already connected") {
+		if errors.Is(err, ErrAlreadyConnected) {
 			return err
 		}
+		tm.emitConnectFailure(tunnel, err)
 
-		attempt++
-		if attempt >= maxRetries && !autoReconnect {
+		if backoff.Attempts()+1 >= maxRetries {
 			return fmt.Errorf("failed to connect tunnel after %d attempts: %w", maxRetries, err)
 		}
 
-		// Calculate exponential backoff delay
-		multiplier := 1 << uint(attempt-1) // 2^(attempt-1)
-		delay := time.Duration(int64(baseDelay) * int64(multiplier))
-		if delay > maxDelay {
-			delay = maxDelay
+		delay := backoff.Next()
+		var busy *ServerBusyError
+		if errors.As(err, &busy) {
+			delay = busy.delay(delay)
+			tm.emitServerBusy(tunnel, busy, delay, backoff.Attempts())
 		}
-
 		logger.Warning("Failed to connect tunnel %s (attempt %d): %v. Retrying in %v...",
-			tunnel.Name, attempt, err, delay)
+			tunnel.Name, backoff.Attempts(), err, delay.Round(100*time.Millisecond))
 
 		// Wait before retrying
 		time.Sleep(delay)
-
-		// Reset attempt counter after max retries to continue trying with max delay
-		if autoReconnect && attempt >= maxRetries {
-			attempt = maxRetries - 1
-		}
 	}
 }
 
-// monitorAndReconnect monitors a tunnel connection and automatically reconnects if it disconnects
-func (tm *TunnelManager) monitorAndReconnect(tunnel *config.Tunnel, token string) {
-	checkInterval := 5 * time.Second
-	ticker := time.NewTicker(checkInterval)
-	defer ticker.Stop()
+func (tm *TunnelManager) DisconnectTunnel(tunnelID string) error {
+	return tm.disconnectTunnel(tunnelID, "User initiated shutdown")
+}
 
-	for {
-		<-ticker.C
-
-		// Check if tunnel is still connected
-		if !tm.IsConnected(tunnel.ID) {
-			logger.Warning("Tunnel %s disconnected, attempting to reconnect...", tunnel.Name)
-
-			// Try to reconnect with exponential backoff
-			baseDelay := 2 * time.Second
-			maxDelay := 60 * time.Second
-			attempt := 0
-			maxReconnectAttempts := 10
-
-			for attempt < maxReconnectAttempts {
-				attempt++
-
-				// Calculate exponential backoff delay
-				multiplier := 1 << uint(attempt-1) // 2^(attempt-1)
-				delay := time.Duration(int64(baseDelay) * int64(multiplier))
-				if delay > maxDelay {
-					delay = maxDelay
-				}
+// disconnectTunnel closes a tunnel for good, recording reason as a locally
+// requested disconnect
+func (tm *TunnelManager) disconnectTunnel(tunnelID, reason string) error {
+	// A user-initiated disconnect must not be undone by the supervisor
+	tm.stopSupervisor(tunnelID)
 
-				logger.Info("Reconnection attempt %d for tunnel %s...", attempt, tunnel.Name)
+	return tm.removeTunnel(tunnelID, reason)
+}
 
-				err := tm.ConnectTunnel(tunnel, token)
-				if err == nil {
-					logger.Info("Tunnel %s reconnected successfully", tunnel.Name)
-					return // Exit this goroutine, a new one will be started
-				}
+// removeTunnel closes a tunnel's connection and forgets everything kept for
+// it, leaving its supervisor, if any, alone
+func (tm *TunnelManager) removeTunnel(tunnelID, reason string) error {
+	tm.mutex.Lock()
+	tunnelConn, exists := tm.activeTunnels[tunnelID]
+	if !exists {
+		defer tm.mutex.Unlock()
+		if _, connecting := tm.connecting[tunnelID]; connecting {
+			// ConnectTunnel drops the connection once its dial returns
+			tm.connecting[tunnelID] = false
+			return nil
+		}
+		return fmt.Errorf("tunnel not connected")
+	}
 
-				if strings.Contains(err.Error(), "already connected") {
-					logger.Debug("Tunnel %s is already connected", tunnel.Name)
-					return
-				}
+	// Remove from active tunnels, then close it without holding up other
+	// tunnels
+	delete(tm.activeTunnels, tunnelID)
+	delete(tm.takeovers, tunnelID)
+	tm.mutex.Unlock()
 
-				logger.Warning("Reconnection attempt %d failed for tunnel %s: %v. Retrying in %v...",
-					attempt, tunnel.Name, err, delay)
+	tunnelConn.setCloseReason(reason, true)
+	tm.closeConnection(tunnelConn, reason)
 
-				time.Sleep(delay)
-			}
+	tm.closeLogs(tunnelID)
+	tm.stopLocalServers(tunnelID)
+	tm.forgetTarget(tunnelID)
+	tm.forgetResume(tunnelID)
+	tm.flushUsage(tunnelID)
+	state.RemoveTunnel(tunnelID)
 
-			logger.Error("Failed to reconnect tunnel %s after %d attempts. Giving up.",
-				tunnel.Name, maxReconnectAttempts)
-			return
-		}
-	}
+	return nil
 }
 
-func (tm *TunnelManager) DisconnectTunnel(tunnelID string) error {
+// dropConnection closes a connection and removes it from the active set without
+// stopping the tunnel's supervisor
+func (tm *TunnelManager) dropConnection(tunnelConn *TunnelConnection, reason string) {
 	tm.mutex.Lock()
-	defer tm.mutex.Unlock()
-
-	tunnelConn, exists := tm.activeTunnels[tunnelID]
-	if !exists {
-		return fmt.Errorf("tunnel not connected")
+	if tm.activeTunnels[tunnelConn.Tunnel.ID] == tunnelConn {
+		delete(tm.activeTunnels, tunnelConn.Tunnel.ID)
 	}
+	tm.mutex.Unlock()
+
+	tm.closeConnection(tunnelConn, reason)
+}
+
+// getConnection returns the live connection for a tunnel, or nil
+func (tm *TunnelManager) getConnection(tunnelID string) *TunnelConnection {
+	tm.mutex.RLock()
+	defer tm.mutex.RUnlock()
+
+	return tm.activeTunnels[tunnelID]
+}
+
+// closeConnection sends a close frame and tears down a single tunnel connection
+func (tm *TunnelManager) closeConnection(tunnelConn *TunnelConnection, reason string) {
+	tunnelConn.setCloseReason(reason, false)
 
 	// Send WebSocket close frame for graceful shutdown
-	closeMessage := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "User initiated shutdown")
+	closeMessage := websocket.FormatCloseMessage(websocket.CloseNormalClosure, reason)
 	err := tunnelConn.Connection.WriteControl(
 		websocket.CloseMessage,
 		closeMessage,
@@ -258,11 +645,6 @@ func (tm *TunnelManager) DisconnectTunnel(tunnelID string) error {
 	// Cancel context and close connection
 	tunnelConn.Cancel()
 	tunnelConn.Connection.Close()
-
-	// Remove from active tunnels
-	delete(tm.activeTunnels, tunnelID)
-
-	return nil
 }
 
 func (tm *TunnelManager) GetTunnelStatus(tunnelID string) string {
@@ -295,40 +677,80 @@ func (tm *TunnelManager) GetActiveTunnels() []string {
 }
 
 func (tm *TunnelManager) handleTunnelConnection(tunnelConn *TunnelConnection) {
+	defer crash.Recover("tunnel connection")
 	defer func() {
-		// Cancel context first to stop all goroutines
-		tunnelConn.Cancel()
+		// Remove from active tunnels first so anyone woken by the cancellation
+		// below sees the tunnel as disconnected. The entry may already point at
+		// a replacement connection.
 		tm.mutex.Lock()
-		delete(tm.activeTunnels, tunnelConn.Tunnel.ID)
+		current := tm.activeTunnels[tunnelConn.Tunnel.ID]
+		replaced := current != nil && current != tunnelConn
+		if current == tunnelConn {
+			delete(tm.activeTunnels, tunnelConn.Tunnel.ID)
+		}
 		tm.mutex.Unlock()
-		tunnelConn.Connection.Close()
+		close(tunnelConn.exited)
+		// Cancel context to stop all goroutines
+		tunnelConn.Cancel()
+		tunnelConn.Protocol.Close()
 		logger.Debug("Tunnel %s connection handler cleaned up", tunnelConn.Tunnel.Name)
+
+		// A connection retired by make-before-break is not a disconnect
+		if !replaced {
+			state.RemoveTunnel(tunnelConn.Tunnel.ID)
+			tm.flushUsage(tunnelConn.Tunnel.ID)
+
+			reason, userInitiated := tunnelConn.getCloseReason()
+			tm.emit(Event{
+				Type:          EventDisconnected,
+				TunnelID:      tunnelConn.Tunnel.ID,
+				TunnelName:    tunnelConn.Tunnel.Name,
+				Reason:        reason,
+				UserInitiated: userInitiated,
+			})
+		}
 	}()
 
+	tm.emit(Event{
+		Type:       EventConnected,
+		TunnelID:   tunnelConn.Tunnel.ID,
+		TunnelName: tunnelConn.Tunnel.Name,
+	})
+
+	readTimeout := tm.config.GetSettings().Intervals.ReadTimeout.Duration
+
 	// Set up pong handler to extend read deadline when server responds to our pings
 	tunnelConn.Connection.SetPongHandler(func(appData string) error {
-		// Extend read deadline by 60 seconds (allowing for 4 missed pings at 15s intervals)
-		tunnelConn.Connection.SetReadDeadline(time.Now().Add(60 * time.Second))
+		// Extend read deadline (by default 60s, allowing for 4 missed pings at 15s intervals)
+		tunnelConn.Connection.SetReadDeadline(time.Now().Add(readTimeout))
+		tm.recordRoundTrip(tunnelConn, appData)
 		return nil
 	})
 
-	// Set initial read deadline (60 seconds allows time for first ping/pong exchange)
-	if err := tunnelConn.Connection.SetReadDeadline(time.Now().Add(60 * time.Second)); err != nil {
+	// Set initial read deadline (allows time for first ping/pong exchange)
+	if err := tunnelConn.Connection.SetReadDeadline(time.Now().Add(readTimeout)); err != nil {
 		logger.Error("Failed to set initial read deadline for tunnel %s: %v", tunnelConn.Tunnel.Name, err)
 		return
 	}
 
 	// Send heartbeat periodically using WebSocket control frame pings
 	go tm.sendHeartbeat(tunnelConn)
+	tm.publishState(tunnelConn)
+	go tm.resumeResponses(tunnelConn)
 
 	for {
 		select {
 		case <-tunnelConn.Context.Done():
 			return
 		default:
-			// Read message from server
-			_, message, err := tunnelConn.Connection.ReadMessage()
+			// Read message from server into a pooled buffer
+			frame := getFrameBuffer()
+			_, reader, err := tunnelConn.Connection.NextReader()
+			if err == nil {
+				_, err = frame.ReadFrom(reader)
+			}
 			if err != nil {
+				putFrameBuffer(frame)
 				// Log the actual error that caused disconnect
 				if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
 					logger.Debug("Tunnel %s closed gracefully: %v", tunnelConn.Tunnel.Name, err)
@@ -338,26 +760,35 @@ func (tm *TunnelManager) handleTunnelConnection(tunnelConn *TunnelConnection) {
 					// Connection errors during Ctrl+C or network issues - debug only
 					logger.Debug("Tunnel %s connection error: %v", tunnelConn.Tunnel.Name, err)
 				}
+				// The server dropping off the network (rather than closing the
+				// connection) sends the reconnect to another endpoint first
+				if tunnelConn.Context.Err() == nil && connectionLost(err) {
+					tm.endpoints.failed(tunnelConn.Endpoint)
+				}
+				if busy := busyClose(err); busy != nil {
+					tunnelConn.setBusy(busy)
+				}
+				tunnelConn.setCloseReason(err.Error(), false)
 				tunnelConn.Status = "error"
 				return
 			}
 
 			// Extend read deadline on successful read (application-level messages)
-			tunnelConn.Connection.SetReadDeadline(time.Now().Add(60 * time.Second))
+			tunnelConn.Connection.SetReadDeadline(time.Now().Add(readTimeout))
 
 			// Handle tunnel protocol messages
-			go func() {
-				if err := tunnelConn.Protocol.HandleTunnelMessage(message); err != nil {
-					logger.Debug("Failed to handle tunnel message: %v", err)
-					tunnelConn.Status = "error"
-				}
-			}()
+			tunnelConn.Protocol.receiveFrame(frame, func(err error) {
+				logger.Debug("Failed to handle tunnel message: %v", err)
+				tunnelConn.Status = "error"
+			})
 		}
 	}
 }
 
 func (tm *TunnelManager) sendHeartbeat(tunnelConn *TunnelConnection) {
-	ticker := time.NewTicker(15 * time.Second) // Send heartbeat every 15 seconds
+	defer crash.Recover("tunnel heartbeat")
+
+	ticker := time.NewTicker(tm.config.GetSettings().Intervals.PingInterval.Duration)
 	defer ticker.Stop()
 
 	for {
@@ -367,17 +798,76 @@ func (tm *TunnelManager) sendHeartbeat(tunnelConn *TunnelConnection) {
 		case <-ticker.C:
 			// Use WebSocket control frame ping instead of JSON message
 			// This is more efficient and properly integrated with the WebSocket protocol
+			// The payload carries the send time, echoed back in the pong for RTT
+			tm.recordPing(tunnelConn)
 			err := tunnelConn.Connection.WriteControl(
 				websocket.PingMessage,
-				[]byte{},
+				pingPayload(),
 				time.Now().Add(10*time.Second),
 			)
 			if err != nil {
 				logger.Error("Failed to send heartbeat for tunnel %s: %v", tunnelConn.Tunnel.Name, err)
+				tm.endpoints.failed(tunnelConn.Endpoint)
+				tunnelConn.setCloseReason(fmt.Sprintf("heartbeat failed: %v", err), false)
 				tunnelConn.Status = "error"
 				tunnelConn.Cancel() // Cancel context to trigger cleanup
+				// End the read loop now rather than when its read times out
+				tunnelConn.Connection.Close()
+				return
+			}
+
+			tm.publishState(tunnelConn)
+			tm.flushUsage(tunnelConn.Tunnel.ID)
+
+			if tm.stopIfExpired(tunnelConn) || tm.stopIfIdle(tunnelConn) {
 				return
 			}
 		}
 	}
 }
+
+// stopIfIdle disconnects a tunnel that has had no requests for its idle
+// timeout, without reconnecting it, and reports whether it did
+func (tm *TunnelManager) stopIfIdle(tunnelConn *TunnelConnection) bool {
+	timeout := tunnelConn.Tunnel.IdleTimeout()
+	if timeout <= 0 || tunnelConn.Protocol.stats.idleFor() < timeout {
+		return false
+	}
+
+	tunnel := tunnelConn.Tunnel
+	reason := fmt.Sprintf("no requests for %v", timeout)
+	logger.Info("Tunnel %s had no requests for %v, disconnecting it", tunnel.Name, timeout)
+
+	tm.mutex.Lock()
+	tm.stopped[tunnel.ID] = true
+	tm.mutex.Unlock()
+
+	if err := tm.disconnectTunnel(tunnel.ID, reason); err != nil {
+		logger.Debug("Failed to stop idle tunnel %s: %v", tunnel.Name, err)
+	}
+	tm.emit(Event{
+		Type:       EventIdleStopped,
+		TunnelID:   tunnel.ID,
+		TunnelName: tunnel.Name,
+		Reason:     reason,
+	})
+	return true
+}
+
+// resetIdle restarts a tunnel's idle timeout when it is started
+func (tm *TunnelManager) resetIdle(tunnel *config.Tunnel) {
+	tm.mutex.Lock()
+	delete(tm.stopped, tunnel.ID)
+	tm.mutex.Unlock()
+
+	tm.statsFor(tunnel).touch()
+}
+
+// IsStopped reports whether a tunnel was disconnected by its idle timeout or
+// taken over by another machine, and has not been connected since
+func (tm *TunnelManager) IsStopped(tunnelID string) bool {
+	tm.mutex.RLock()
+	defer tm.mutex.RUnlock()
+
+	return tm.stopped[tunnelID]
+}
@@ -0,0 +1,118 @@
+package tunnel
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"skyport-agent/internal/config"
+
+	"github.com/gorilla/websocket"
+)
+
+// replacedConnection returns a tunnel connection to a test server and a
+// channel closed when the server sees the connection end
+func replacedConnection(t *testing.T) (*TunnelConnection, <-chan struct{}) {
+	t.Helper()
+	closed := make(chan struct{})
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &TunnelConnection{
+		Tunnel:     config.Tunnel{ID: "t1", Name: "test"},
+		Connection: conn,
+		Protocol:   NewAgentTunnelProtocol(conn, "t1", "127.0.0.1:1"),
+		Context:    ctx,
+		Cancel:     cancel,
+		exited:     make(chan struct{}),
+	}, closed
+}
+
+func TestReplacedConnectionFinishesRequestsBeforeClosing(t *testing.T) {
+	tunnelConn, closed := replacedConnection(t)
+	tunnelConn.Protocol.beginRequest()
+
+	tm := &TunnelManager{}
+	go tm.retireConnection(tunnelConn)
+
+	select {
+	case <-closed:
+		t.Fatal("connection closed with a request in flight")
+	case <-time.After(300 * time.Millisecond):
+	}
+
+	tunnelConn.Protocol.endRequest()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("connection not closed once its requests finished")
+	}
+}
+
+func TestReplacedConnectionClosedAfterRetireTimeout(t *testing.T) {
+	defer func(timeout time.Duration) { retireTimeout = timeout }(retireTimeout)
+	retireTimeout = 100 * time.Millisecond
+
+	tunnelConn, closed := replacedConnection(t)
+	tunnelConn.Protocol.beginRequest()
+
+	tm := &TunnelManager{}
+	go tm.retireConnection(tunnelConn)
+
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("connection not closed after the retire timeout")
+	}
+}
+
+func TestDisconnectDoesNotHoldUpOtherTunnels(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	tunnelConn, closed := replacedConnection(t)
+	tm := testManager()
+	tm.activeTunnels["t1"] = tunnelConn
+
+	done := make(chan error, 1)
+	go func() { done <- tm.DisconnectTunnel("t1") }()
+
+	// Closing the connection waits for the server to see the close frame;
+	// other tunnels must be usable meanwhile
+	time.Sleep(20 * time.Millisecond)
+	start := time.Now()
+	if tm.IsConnected("t1") {
+		t.Error("tunnel still reported connected while disconnecting")
+	}
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Errorf("status call waited %s for the disconnect", elapsed)
+	}
+
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("connection not closed")
+	}
+}
@@ -0,0 +1,121 @@
+package tunnel
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"skyport-agent/internal/config"
+	"skyport-agent/internal/telemetry"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// mirrorMaxBody caps request bodies read from the mirror port
+const mirrorMaxBody = 32 << 20
+
+// newMirrorServer builds the local mirror of a tunnel. Its requests are
+// answered by the tunnel's current connection, through the same pipeline as
+// requests from the server, so they see the same filters and rewrites
+// across reconnects.
+func (tm *TunnelManager) newMirrorServer(tunnel *config.Tunnel, port int) *http.Server {
+	tunnelID := tunnel.ID
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tunnelConn := tm.getConnection(tunnelID)
+		if tunnelConn == nil || tunnelConn.Protocol == nil {
+			http.Error(w, "Tunnel is not connected", http.StatusServiceUnavailable)
+			return
+		}
+		if r.Header.Get("Upgrade") != "" {
+			http.Error(w, "Upgrades such as WebSocket are not mirrored", http.StatusNotImplemented)
+			return
+		}
+
+		message, err := mirrorMessage(r)
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			http.Error(w, fmt.Sprintf("Failed to read request: %v", err), http.StatusBadRequest)
+			return
+		}
+		tunnelConn.Protocol.handleMirrorRequest(message, w)
+	})
+
+	return &http.Server{
+		Addr:              net.JoinHostPort("127.0.0.1", strconv.Itoa(port)),
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+}
+
+// mirrorMessage converts a request to the mirror port into the message the
+// server would send for it
+func mirrorMessage(r *http.Request) (*TunnelMessage, error) {
+	body, err := io.ReadAll(http.MaxBytesReader(nil, r.Body, mirrorMaxBody))
+	if err != nil {
+		return nil, err
+	}
+
+	headers := make(map[string]string, len(r.Header)+3)
+	for name, values := range r.Header {
+		headers[name] = strings.Join(values, ", ")
+	}
+	headers["Host"] = r.Host
+	headers["X-Forwarded-Proto"] = "http"
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		headers["X-Forwarded-For"] = host
+		headers["X-Real-IP"] = host
+	}
+
+	return &TunnelMessage{
+		Type:      "http_request",
+		Method:    r.Method,
+		URL:       r.URL.RequestURI(),
+		Headers:   headers,
+		Body:      body,
+		Timestamp: time.Now().Unix(),
+	}, nil
+}
+
+// handleMirrorRequest answers a request from the tunnel's mirror port
+// through the same pipeline as handleHTTPRequest. It is written to the
+// access log, from the loopback address, but kept out of the tunnel's stats,
+// samples and metrics, which describe public traffic.
+func (atp *AgentTunnelProtocol) handleMirrorRequest(message *TunnelMessage, w http.ResponseWriter) {
+	start := time.Now()
+	requestID := ensureRequestID(message)
+	message.ID = "mirror-" + requestID
+
+	span := atp.telemetry.StartSpan("mirror.request", telemetry.SpanKindServer, telemetry.SpanContext{})
+	span.SetAttribute("http.request.method", message.Method)
+	span.SetAttribute("url.path", message.URL)
+	span.SetAttribute("skyport.tunnel", atp.tunnelName)
+	span.SetAttribute("http.request.id", requestID)
+	defer span.End()
+
+	response, reserved, _ := atp.respond(message, span, requestID)
+	defer reserved.release()
+
+	atp.logAccess(message, response, start)
+	span.SetAttribute("http.response.status_code", response.Status)
+
+	for name, value := range response.Headers {
+		switch http.CanonicalHeaderKey(name) {
+		case "Content-Length", "Transfer-Encoding", "Connection":
+			// Set by the server for the body actually written
+		default:
+			w.Header().Set(name, value)
+		}
+	}
+	status := response.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	w.WriteHeader(status)
+	w.Write(response.Body)
+}
@@ -0,0 +1,286 @@
+package tunnel
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"skyport-agent/internal/config"
+	"skyport-agent/internal/logger"
+	"skyport-agent/internal/oidc"
+	"strings"
+	"time"
+)
+
+const (
+	// oidcCallbackPath receives the identity provider's redirect after login
+	oidcCallbackPath = "/_skyport/oidc/callback"
+	// oidcLogoutPath clears the session cookie
+	oidcLogoutPath = "/_skyport/oidc/logout"
+
+	sessionCookie = "_skyport_session"
+	stateCookie   = "_skyport_oidc_state"
+
+	// Purposes the cookies are signed for, so neither is accepted as the other
+	sessionPurpose = "session"
+	statePurpose   = "state"
+
+	// loginTimeout bounds how long a visitor has to complete the login at the
+	// identity provider
+	loginTimeout = 10 * time.Minute
+
+	// UserEmailHeader carries the signed-in user's email to the local service
+	UserEmailHeader = "X-Skyport-User-Email"
+)
+
+// oidcGate requires visitors to sign in with the tunnel's identity provider
+type oidcGate struct {
+	tunnelName     string
+	pages          *pageSet
+	provider       *oidc.Provider
+	key            []byte
+	ttl            time.Duration
+	redirectURL    string
+	allowedEmails  map[string]bool
+	allowedDomains map[string]bool
+}
+
+// oidcFilter returns a filter that only lets requests with a valid session
+// through, or nil if the gate is disabled
+func oidcFilter(tunnel *config.Tunnel, pages *pageSet) requestFilter {
+	settings := tunnel.OIDCSettings()
+	if !settings.Enabled {
+		return nil
+	}
+
+	if settings.Issuer == "" || settings.ClientID == "" || settings.CookieSecret == "" {
+		// No one could sign in, and letting everyone in would defeat the gate
+		logger.Warning("Identity provider for tunnel %s is not fully configured, rejecting all requests", tunnel.Name)
+		return func(message *TunnelMessage) *TunnelMessage {
+			return rejectResponse(message.ID, http.StatusForbidden, "Forbidden")
+		}
+	}
+	if len(settings.AllowedEmails) == 0 && len(settings.AllowedDomains) == 0 {
+		logger.Warning("Identity provider for tunnel %s has no allowed emails or domains; every signed-in user will be rejected", tunnel.Name)
+	}
+
+	gate := &oidcGate{
+		tunnelName:     tunnel.Name,
+		pages:          pages,
+		provider:       oidc.NewProvider(settings.Issuer, settings.ClientID, settings.ClientSecret, settings.Scopes),
+		key:            []byte(settings.CookieSecret),
+		ttl:            settings.SessionTTL.Duration,
+		redirectURL:    settings.RedirectURL,
+		allowedEmails:  make(map[string]bool),
+		allowedDomains: make(map[string]bool),
+	}
+	for _, email := range settings.AllowedEmails {
+		gate.allowedEmails[strings.ToLower(email)] = true
+	}
+	for _, domain := range settings.AllowedDomains {
+		gate.allowedDomains[strings.ToLower(strings.TrimPrefix(domain, "@"))] = true
+	}
+	return gate.filter
+}
+
+func (g *oidcGate) filter(message *TunnelMessage) *TunnelMessage {
+	path, query, _ := strings.Cut(message.URL, "?")
+
+	switch path {
+	case oidcCallbackPath:
+		return g.callback(message, query)
+	case oidcLogoutPath:
+		response := redirectResponse(message.ID, "/")
+		response.Headers["Set-Cookie"] = g.cookie(message, sessionCookie, "", -1)
+		return response
+	}
+
+	if cookie := requestCookie(message.Headers, sessionCookie); cookie != "" {
+		if email, err := oidc.Verify(g.key, sessionPurpose, cookie, time.Now()); err == nil && g.allowed(email) {
+			// The session cookie is only meaningful to the agent, and the
+			// email header must not be spoofable by the client
+			removeRequestCookie(message.Headers, sessionCookie)
+			removeHeader(message.Headers, UserEmailHeader)
+			message.Headers[UserEmailHeader] = email
+			return nil
+		}
+	}
+
+	// Only page loads can follow a redirect to the login page; API calls and
+	// WebSocket upgrades get a plain 401
+	if message.Method != http.MethodGet || !strings.Contains(headerValue(message.Headers, "Accept"), "text/html") {
+		return rejectResponse(message.ID, http.StatusUnauthorized, "Unauthorized")
+	}
+	return g.login(message)
+}
+
+// login redirects the visitor to the identity provider, or shows the login
+// page linking to it, remembering the requested URL, state and PKCE verifier
+// in a short-lived signed cookie
+func (g *oidcGate) login(message *TunnelMessage) *TunnelMessage {
+	state := oidc.RandomString()
+	verifier := oidc.RandomString()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	authURL, err := g.provider.AuthCodeURL(ctx, g.redirectURI(message), state, verifier)
+	if err != nil {
+		logger.Warning("Identity provider for tunnel %s: %v", g.tunnelName, err)
+		return rejectResponse(message.ID, http.StatusBadGateway, "Bad Gateway")
+	}
+
+	value := oidc.Sign(g.key, statePurpose, state+"|"+verifier+"|"+message.URL, time.Now().Add(loginTimeout))
+	var response *TunnelMessage
+	if g.pages.has(PageLogin) {
+		response = rejectResponse(message.ID, http.StatusUnauthorized, "Unauthorized")
+		g.pages.render(PageLogin, message, response, pageData{LoginURL: authURL})
+	} else {
+		response = redirectResponse(message.ID, authURL)
+	}
+	response.Headers["Set-Cookie"] = g.cookie(message, stateCookie, value, int(loginTimeout.Seconds()))
+	return response
+}
+
+// callback completes the login: it checks the state, exchanges the code,
+// looks up the user and issues the session cookie
+func (g *oidcGate) callback(message *TunnelMessage, query string) *TunnelMessage {
+	params, _ := url.ParseQuery(query)
+	if errCode := params.Get("error"); errCode != "" {
+		return rejectResponse(message.ID, http.StatusForbidden, "Login failed: "+errCode)
+	}
+
+	stored, err := oidc.Verify(g.key, statePurpose, requestCookie(message.Headers, stateCookie), time.Now())
+	if err != nil {
+		return rejectResponse(message.ID, http.StatusBadRequest, "Login expired, please try again")
+	}
+	parts := strings.SplitN(stored, "|", 3)
+	if len(parts) != 3 || subtle.ConstantTimeCompare([]byte(parts[0]), []byte(params.Get("state"))) != 1 {
+		return rejectResponse(message.ID, http.StatusBadRequest, "Login expired, please try again")
+	}
+	verifier, returnTo := parts[1], parts[2]
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	accessToken, err := g.provider.Exchange(ctx, params.Get("code"), g.redirectURI(message), verifier)
+	if err != nil {
+		logger.Warning("Identity provider for tunnel %s: %v", g.tunnelName, err)
+		return rejectResponse(message.ID, http.StatusBadGateway, "Bad Gateway")
+	}
+	user, err := g.provider.UserInfo(ctx, accessToken)
+	if err != nil {
+		logger.Warning("Identity provider for tunnel %s: %v", g.tunnelName, err)
+		return rejectResponse(message.ID, http.StatusBadGateway, "Bad Gateway")
+	}
+
+	email := strings.ToLower(user.Email)
+	if email == "" || (user.EmailVerified != nil && !*user.EmailVerified) || !g.allowed(email) {
+		logger.Info("Tunnel %s: denied login for %q", g.tunnelName, user.Email)
+		return rejectResponse(message.ID, http.StatusForbidden, "Forbidden")
+	}
+
+	// Only redirect within the tunnel, never to another host
+	if !strings.HasPrefix(returnTo, "/") || strings.HasPrefix(returnTo, "//") {
+		returnTo = "/"
+	}
+	response := redirectResponse(message.ID, returnTo)
+	response.Headers["Set-Cookie"] = g.cookie(message, sessionCookie, oidc.Sign(g.key, sessionPurpose, email, time.Now().Add(g.ttl)), int(g.ttl.Seconds()))
+	return response
+}
+
+// allowed reports whether an email is in the allowed emails or domains. Only
+// a single plain address (user@domain) can be allowed.
+func (g *oidcGate) allowed(email string) bool {
+	email = strings.ToLower(email)
+	if !validEmail(email) {
+		return false
+	}
+	if g.allowedEmails[email] {
+		return true
+	}
+	_, domain, found := strings.Cut(email, "@")
+	return found && g.allowedDomains[domain]
+}
+
+// emailPattern matches a plain lowercase address: characters providers use
+// in the local part, and a dotted domain
+var emailPattern = regexp.MustCompile(`^[a-z0-9._%+'-]+@[a-z0-9]([a-z0-9-]*[a-z0-9])?(\.[a-z0-9]([a-z0-9-]*[a-z0-9])?)+$`)
+
+// validEmail reports whether email is exactly one plain address, with no
+// display name, comment, URL or other text around it
+func validEmail(email string) bool {
+	return emailPattern.MatchString(email)
+}
+
+// cookie formats a Set-Cookie value scoped to the tunnel host. A negative
+// maxAge deletes the cookie.
+func (g *oidcGate) cookie(message *TunnelMessage, name, value string, maxAge int) string {
+	c := &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(g.redirectURI(message), "https://"),
+		SameSite: http.SameSiteLaxMode,
+	}
+	return c.String()
+}
+
+// redirectURI returns the configured callback URL, or the callback path on
+// the host and scheme the visitor used
+func (g *oidcGate) redirectURI(message *TunnelMessage) string {
+	if g.redirectURL != "" {
+		return g.redirectURL
+	}
+
+	scheme := "http"
+	if headerValue(message.Headers, "X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s%s", scheme, headerValue(message.Headers, "Host"), oidcCallbackPath)
+}
+
+// redirectResponse builds a 302 response to location
+func redirectResponse(requestID, location string) *TunnelMessage {
+	response := rejectResponse(requestID, http.StatusFound, "Found")
+	response.Headers["Location"] = location
+	response.Headers["Cache-Control"] = "no-store"
+	return response
+}
+
+// requestCookie returns the value of a cookie sent with the request
+func requestCookie(headers map[string]string, name string) string {
+	cookies, err := http.ParseCookie(headerValue(headers, "Cookie"))
+	if err != nil {
+		return ""
+	}
+	for _, c := range cookies {
+		if c.Name == name {
+			return c.Value
+		}
+	}
+	return ""
+}
+
+// removeRequestCookie drops a cookie from the request's Cookie header
+func removeRequestCookie(headers map[string]string, name string) {
+	cookies, err := http.ParseCookie(headerValue(headers, "Cookie"))
+	if err != nil {
+		return
+	}
+
+	var kept []string
+	for _, c := range cookies {
+		if c.Name != name {
+			kept = append(kept, c.String())
+		}
+	}
+	removeHeader(headers, "Cookie")
+	if len(kept) > 0 {
+		headers["Cookie"] = strings.Join(kept, "; ")
+	}
+}
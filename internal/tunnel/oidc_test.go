@@ -0,0 +1,66 @@
+package tunnel
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"skyport-agent/internal/oidc"
+)
+
+func testGate() *oidcGate {
+	return &oidcGate{
+		tunnelName:     "test",
+		key:            []byte("cookie-secret"),
+		ttl:            time.Hour,
+		allowedEmails:  map[string]bool{},
+		allowedDomains: map[string]bool{"company.com": true},
+	}
+}
+
+func sessionRequest(cookie string) *TunnelMessage {
+	return &TunnelMessage{
+		ID:      "r1",
+		Method:  http.MethodPost,
+		URL:     "/",
+		Headers: map[string]string{"Cookie": sessionCookie + "=" + cookie},
+	}
+}
+
+func TestOIDCStateCookieIsNotASession(t *testing.T) {
+	gate := testGate()
+
+	// The state cookie login sets for GET /?x@company.com
+	state := oidc.Sign(gate.key, statePurpose, "S|V|/?x@company.com", time.Now().Add(time.Minute))
+	message := sessionRequest(state)
+	response := gate.filter(message)
+	if response == nil || response.Status != http.StatusUnauthorized {
+		t.Fatalf("state cookie accepted as a session; forwarded with %s: %q", UserEmailHeader, message.Headers[UserEmailHeader])
+	}
+}
+
+func TestOIDCSessionMustBeOneEmailAddress(t *testing.T) {
+	gate := testGate()
+
+	for _, value := range []string{
+		"S|V|/?x@company.com",
+		"a@b@company.com",
+		"Alice <alice@company.com>",
+		"alice@company.com, bob@company.com",
+		"@company.com",
+	} {
+		session := oidc.Sign(gate.key, sessionPurpose, value, time.Now().Add(time.Minute))
+		if response := gate.filter(sessionRequest(session)); response == nil {
+			t.Errorf("session for %q accepted", value)
+		}
+	}
+
+	session := oidc.Sign(gate.key, sessionPurpose, "alice@company.com", time.Now().Add(time.Minute))
+	message := sessionRequest(session)
+	if response := gate.filter(message); response != nil {
+		t.Fatalf("valid session rejected with %d", response.Status)
+	}
+	if got := message.Headers[UserEmailHeader]; got != "alice@company.com" {
+		t.Errorf("%s is %q", UserEmailHeader, got)
+	}
+}
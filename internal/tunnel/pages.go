@@ -0,0 +1,168 @@
+package tunnel
+
+import (
+	"bytes"
+	"errors"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"skyport-agent/internal/config"
+	"skyport-agent/internal/logger"
+	"strconv"
+	"strings"
+)
+
+// Custom page templates, looked up in a tunnel's pages directory
+const (
+	PageBadGateway  = "502.html"         // The local service could not be reached
+	PageMaintenance = "maintenance.html" // The tunnel is in maintenance mode
+	PageBasicAuth   = "401.html"         // Shown when the basic auth prompt is cancelled
+	PageLogin       = "login.html"       // Shown before redirecting to the identity provider
+)
+
+// pageTemplates are the pages that can be customized
+var pageTemplates = []string{PageBadGateway, PageMaintenance, PageBasicAuth, PageLogin}
+
+// pagesAssetPath serves the other files of the pages directory, such as
+// stylesheets and logos, without authentication
+const pagesAssetPath = "/_skyport/pages/"
+
+// pageData is what page templates are rendered with
+type pageData struct {
+	Tunnel    string
+	Status    int
+	Title     string // Status text, e.g. "Bad Gateway"
+	Message   string
+	RequestID string
+	Path      string // The requested path
+	LoginURL  string // Login page only: the identity provider's login URL
+	Assets    string // URL prefix of the other files in the pages directory
+}
+
+// pageSet holds a tunnel's custom page templates. A nil *pageSet, or one
+// without a given template, leaves the built-in responses in place.
+type pageSet struct {
+	tunnelName string
+	dir        string
+	templates  map[string]*template.Template
+}
+
+// loadPages parses the templates in the tunnel's pages directory, or returns
+// nil if it has none. Templates that fail to parse are reported and skipped.
+func loadPages(tunnel *config.Tunnel) *pageSet {
+	if tunnel.Settings == nil || tunnel.Settings.Pages.Dir == "" {
+		return nil
+	}
+	dir := tunnel.Settings.Pages.Dir
+
+	pages := &pageSet{tunnelName: tunnel.Name, dir: dir, templates: make(map[string]*template.Template)}
+	for _, name := range pageTemplates {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err == nil {
+			pages.templates[name], err = template.New(name).Parse(string(data))
+		}
+		if err != nil {
+			logger.Warning("Ignoring page %s for tunnel %s: %v", name, tunnel.Name, err)
+			delete(pages.templates, name)
+		}
+	}
+	return pages
+}
+
+// isPageTemplate reports whether name is one of the page templates
+func isPageTemplate(name string) bool {
+	for _, page := range pageTemplates {
+		if strings.EqualFold(name, page) {
+			return true
+		}
+	}
+	return false
+}
+
+// has reports whether there is a custom page with the name
+func (p *pageSet) has(name string) bool {
+	return p != nil && p.templates[name] != nil
+}
+
+// render replaces the body of a response to a browser with the named page.
+// Other clients, such as API calls, keep the plain text response.
+func (p *pageSet) render(name string, request, response *TunnelMessage, data pageData) {
+	if !p.has(name) || !strings.Contains(headerValue(request.Headers, "Accept"), "text/html") {
+		return
+	}
+
+	data.Tunnel = p.tunnelName
+	data.Status = response.Status
+	data.Title = http.StatusText(response.Status)
+	data.RequestID = headerValue(request.Headers, RequestIDHeader)
+	data.Path, _, _ = strings.Cut(request.URL, "?")
+	data.Assets = pagesAssetPath
+
+	var body bytes.Buffer
+	if err := p.templates[name].Execute(&body, data); err != nil {
+		logger.Warning("Failed to render page %s for tunnel %s: %v", name, p.tunnelName, err)
+		return
+	}
+	if response.Headers == nil {
+		response.Headers = make(map[string]string)
+	}
+	response.Body = body.Bytes()
+	removeHeader(response.Headers, "Content-Length")
+	response.Headers["Content-Type"] = "text/html; charset=utf-8"
+	response.Headers["Cache-Control"] = "no-store"
+}
+
+// assetFilter serves the other files of the pages directory under
+// pagesAssetPath, so pages can load them before the visitor is let in.
+// Returns nil if there are no custom pages.
+func (p *pageSet) assetFilter() requestFilter {
+	if p == nil {
+		return nil
+	}
+	files := http.StripPrefix(strings.TrimSuffix(pagesAssetPath, "/"), http.FileServer(staticFS{root: http.Dir(p.dir)}))
+
+	return func(message *TunnelMessage) *TunnelMessage {
+		if !strings.HasPrefix(message.URL, pagesAssetPath) {
+			return nil
+		}
+		if message.Method != http.MethodGet && message.Method != http.MethodHead {
+			return rejectResponse(message.ID, http.StatusMethodNotAllowed, "Method Not Allowed")
+		}
+		// The templates themselves are not assets
+		urlPath, _, _ := strings.Cut(message.URL, "?")
+		if decoded, err := url.PathUnescape(urlPath); err == nil {
+			urlPath = decoded
+		}
+		if isPageTemplate(path.Base(urlPath)) {
+			return rejectResponse(message.ID, http.StatusNotFound, "Not Found")
+		}
+		return serveHandler(files, message)
+	}
+}
+
+// maintenanceFilter answers every request with 503 and the maintenance page
+// while the tunnel is in maintenance mode. Returns nil otherwise.
+func maintenanceFilter(tunnel *config.Tunnel, pages *pageSet) requestFilter {
+	if tunnel.Settings == nil || !tunnel.Settings.Maintenance.Enabled {
+		return nil
+	}
+	message := tunnel.Settings.Maintenance.Message
+
+	return func(request *TunnelMessage) *TunnelMessage {
+		text := "Service Unavailable"
+		if message != "" {
+			text += ": " + message
+		}
+		response := rejectResponse(request.ID, http.StatusServiceUnavailable, text)
+		response.Headers["Retry-After"] = strconv.Itoa(300)
+		pages.render(PageMaintenance, request, response, pageData{Message: message})
+		return response
+	}
+}
@@ -0,0 +1,58 @@
+package tunnel
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"skyport-agent/internal/config"
+)
+
+// ErrLocalNotListening is returned when a tunnel's preflight check finds
+// nothing listening on its local target
+var ErrLocalNotListening = errors.New("nothing is listening")
+
+// ErrLocalUnhealthy is returned when a tunnel's preflight health path does not
+// answer with success
+var ErrLocalUnhealthy = errors.New("local service is not healthy")
+
+// Preflight checks that a tunnel's local service is up before the tunnel
+// connects: that its target accepts connections and, with a health path, that
+// the path answers 2xx or 3xx. It passes when the check is disabled or the
+// tunnel serves a directory.
+func Preflight(tunnel *config.Tunnel) error {
+	check := tunnel.PreflightSettings()
+	if !check.Enabled || tunnel.StaticSettings().Dir != "" {
+		return nil
+	}
+
+	address := tunnel.LocalAddress()
+	conn, err := net.DialTimeout("tcp", address, check.Timeout.Duration)
+	if err != nil {
+		return fmt.Errorf("%w on %s: %v", ErrLocalNotListening, address, err)
+	}
+	conn.Close()
+
+	if check.Path == "" {
+		return nil
+	}
+
+	client := &http.Client{
+		Timeout: check.Timeout.Duration,
+		// A redirect counts as an answer; it is not followed
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	healthURL := fmt.Sprintf("http://%s%s", address, check.Path)
+	resp, err := client.Get(healthURL)
+	if err != nil {
+		return fmt.Errorf("%w: GET %s failed: %v", ErrLocalUnhealthy, healthURL, err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("%w: GET %s returned %s", ErrLocalUnhealthy, healthURL, resp.Status)
+	}
+	return nil
+}
@@ -2,11 +2,19 @@ package tunnel
 
 import (
 	"bytes"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
-	"io"
+	"net"
 	"net/http"
+	"skyport-agent/internal/accesslog"
+	"skyport-agent/internal/config"
+	"skyport-agent/internal/crash"
+	"skyport-agent/internal/localnet"
 	"skyport-agent/internal/logger"
+	"skyport-agent/internal/sampling"
+	"skyport-agent/internal/telemetry"
 	"strconv"
 	"strings"
 	"sync"
@@ -15,6 +23,40 @@ import (
 	"github.com/gorilla/websocket"
 )
 
+// FeaturesHeader carries the comma-separated protocol features supported by each
+// side. The agent sends it on the WebSocket handshake and the server echoes the
+// subset it supports in the handshake response.
+const FeaturesHeader = "X-Skyport-Features"
+
+const (
+	// FeatureMakeBeforeBreak allows a second connection for the same tunnel to
+	// be opened before the first one is closed
+	FeatureMakeBeforeBreak = "make-before-break"
+)
+
+// agentFeatures lists the protocol features this agent supports
+var agentFeatures = []string{
+	FeatureMakeBeforeBreak,
+	FeatureResume,
+	FeatureCommands,
+}
+
+// parseFeatures parses a FeaturesHeader value into a feature set
+func parseFeatures(header string) map[string]bool {
+	features := make(map[string]bool)
+	for _, feature := range strings.Split(header, ",") {
+		feature = strings.TrimSpace(feature)
+		if feature != "" {
+			features[feature] = true
+		}
+	}
+	return features
+}
+
+// RequestIDHeader correlates a proxied request across the client, the access
+// log and the local service
+const RequestIDHeader = "X-Request-ID"
+
 // TunnelMessage represents a message in the tunnel protocol
 type TunnelMessage struct {
 	Type      string            `json:"type"`
@@ -28,38 +70,129 @@ type TunnelMessage struct {
 	Timestamp int64             `json:"timestamp"`
 }
 
+// clone returns a copy of the message that can be modified without affecting
+// the original's headers
+func (m *TunnelMessage) clone() *TunnelMessage {
+	c := *m
+	c.Headers = make(map[string]string, len(m.Headers))
+	for name, value := range m.Headers {
+		c.Headers[name] = value
+	}
+	return &c
+}
+
 // AgentTunnelProtocol handles the agent side of tunnel protocol
 type AgentTunnelProtocol struct {
 	conn       *websocket.Conn
-	localPort  int
+	target     *localTarget
 	tunnelID   string
-	writeMutex sync.Mutex
+	writer     *messageWriter
+	logs       *tunnelLogs
+	telemetry  *telemetry.Provider
+	tunnelName string
+	stats      *tunnelStats
+	filters    []requestFilter
+	// responseFilters modify every response, including agent-generated ones
+	responseFilters []responseFilter
+	// static serves requests from a local directory instead of target
+	static http.Handler
+	// pages are the tunnel's custom error and login pages, or nil
+	pages *pageSet
+	// split sends a share of requests to an alternate local target, or nil
+	split *trafficSplit
+	// websocket limits the proxied WebSocket sessions, which are kept in
+	// sessions by upgrade request ID
+	websocket     config.WebSocketSettings
+	sessions      map[string]*wsSession
+	sessionsMutex sync.Mutex
+	// requests counts the requests and upgrades being handled; idle, once
+	// set by drained, is closed when neither they nor sessions remain. Both
+	// are guarded by sessionsMutex.
+	requests int
+	idle     chan struct{}
+	// limits are shared by every tunnel of the manager
+	limits *resourceLimiter
+	// resume holds responses the connection dropped before they were sent,
+	// when the server supports FeatureResume
+	resume *resumeBuffer
+	// serverMessage handles the server reporting the tunnel was changed or
+	// deleted
+	serverMessage func(*TunnelMessage)
 }
 
-func NewAgentTunnelProtocol(conn *websocket.Conn, tunnelID string, localPort int) *AgentTunnelProtocol {
+// NewAgentTunnelProtocol creates the protocol handler for a tunnel forwarding
+// to localAddr (host:port)
+func NewAgentTunnelProtocol(conn *websocket.Conn, tunnelID string, localAddr string) *AgentTunnelProtocol {
 	return &AgentTunnelProtocol{
-		conn:      conn,
-		localPort: localPort,
-		tunnelID:  tunnelID,
+		conn:     conn,
+		target:   newLocalTarget(localAddr),
+		tunnelID: tunnelID,
+		writer:   newMessageWriter(conn),
 	}
 }
 
+// SetTelemetry traces proxied requests and records request metrics for the
+// named tunnel (nil disables it)
+func (atp *AgentTunnelProtocol) SetTelemetry(provider *telemetry.Provider, tunnelName string) {
+	atp.telemetry = provider
+	atp.tunnelName = tunnelName
+}
+
 // HandleTunnelMessage processes messages received from the server
 func (atp *AgentTunnelProtocol) HandleTunnelMessage(messageBytes []byte) error {
+	message, err := atp.decodeMessage(messageBytes)
+	if err != nil {
+		return err
+	}
+	return atp.dispatch(message)
+}
+
+// receiveFrame handles a message read into a pooled buffer, returning the
+// buffer to the pool as soon as the message is decoded. WebSocket data is
+// queued on its session before the connection's next frame is read, keeping
+// each session's messages in order; other messages are handled concurrently.
+// failed is called with errors from handling a message.
+func (atp *AgentTunnelProtocol) receiveFrame(frame *bytes.Buffer, failed func(error)) {
+	message, err := atp.decodeMessage(frame.Bytes())
+	putFrameBuffer(frame)
+	if err != nil {
+		failed(err)
+		return
+	}
+
+	if message.Type == "websocket_data" {
+		atp.queueWebSocketData(message)
+		return
+	}
+	go func() {
+		defer crash.Recover("tunnel message handler")
+		if err := atp.dispatch(message); err != nil {
+			failed(err)
+		}
+	}()
+}
+
+func (atp *AgentTunnelProtocol) decodeMessage(messageBytes []byte) (*TunnelMessage, error) {
+	atp.stats.received(len(messageBytes))
+
 	var message TunnelMessage
 	if err := json.Unmarshal(messageBytes, &message); err != nil {
-		return fmt.Errorf("failed to unmarshal tunnel message: %w", err)
+		return nil, fmt.Errorf("failed to unmarshal tunnel message: %w", err)
 	}
+	return &message, nil
+}
 
+func (atp *AgentTunnelProtocol) dispatch(message *TunnelMessage) error {
 	switch message.Type {
 	case "http_request":
-		return atp.handleHTTPRequest(&message)
+		return atp.handleHTTPRequest(message)
 	case "websocket_upgrade":
-		return atp.handleWebSocketUpgrade(&message)
+		return atp.handleWebSocketUpgrade(message)
 	case "websocket_data":
-		return atp.handleWebSocketData(&message)
+		atp.queueWebSocketData(message)
+		return nil
 	case "ping":
-		return atp.handlePing(&message)
+		return atp.handlePing(message)
 	case "pong":
 		// Server acknowledged our ping - connection is alive (silent)
 		return nil
@@ -82,6 +215,11 @@ func (atp *AgentTunnelProtocol) HandleTunnelMessage(messageBytes []byte) error {
 	case "connected":
 		// Tunnel connection confirmed by server (silent)
 		return nil
+	case messageTunnelUpdated, messageTunnelDeleted, messageTakenOver, messageAgentCommand:
+		if atp.serverMessage != nil {
+			atp.serverMessage(message)
+		}
+		return nil
 	default:
 		logger.Debug("Unknown tunnel message type: %s", message.Type)
 	}
@@ -90,31 +228,152 @@ func (atp *AgentTunnelProtocol) HandleTunnelMessage(messageBytes []byte) error {
 }
 
 func (atp *AgentTunnelProtocol) handleHTTPRequest(message *TunnelMessage) error {
+	atp.beginRequest()
+	defer atp.endRequest()
+
+	start := time.Now()
+	atp.stats.requestStarted()
+	requestID := ensureRequestID(message)
+
+	// Trace the request from tunnel receive to response send, continuing the
+	// server's trace if it propagated one
+	parent := telemetry.ParseTraceparent(headerValue(message.Headers, telemetry.TraceparentHeader))
+	span := atp.telemetry.StartSpan("tunnel.request", telemetry.SpanKindServer, parent)
+	span.SetAttribute("http.request.method", message.Method)
+	span.SetAttribute("url.path", message.URL)
+	span.SetAttribute("skyport.tunnel", atp.tunnelName)
+	span.SetAttribute("http.request.id", requestID)
+	defer span.End()
+
+	response, reserved, forwardDuration := atp.respond(message, span, requestID)
+	defer reserved.release()
+
+	atp.logAccess(message, response, start)
+	atp.sampleRequest(message, response, start)
+	atp.stats.requestFinished(message, response, forwardDuration)
+	atp.telemetry.RecordRequest(atp.tunnelName, message.Method, response.Status, time.Since(start))
+	span.SetAttribute("http.response.status_code", response.Status)
+
+	sendSpan := atp.telemetry.StartSpan("tunnel.response.send", telemetry.SpanKindInternal, span.Context())
+	sendSpan.SetAttribute("http.response.body.size", len(response.Body))
+	err := atp.sendMessage(response)
+	if err != nil && atp.resume != nil && atp.resume.hold(response) {
+		// Sent on the next connection if the tunnel reconnects in time
+		logger.Debug("Holding response to %s %s until tunnel %s reconnects", message.Method, message.URL, atp.tunnelName)
+		sendSpan.SetAttribute("skyport.response.held", true)
+		err = nil
+	}
+	if err != nil {
+		sendSpan.SetError(err.Error())
+	}
+	sendSpan.End()
+
+	return err
+}
+
+// respond runs a request through the tunnel's middleware pipeline and
+// returns the response to send: resource limits, request filters, the local
+// service and response filters. The caller releases the reservation once the
+// response is sent.
+func (atp *AgentTunnelProtocol) respond(message *TunnelMessage, span *telemetry.Span, requestID string) (*TunnelMessage, *reservation, time.Duration) {
+	// Filters may rewrite the request sent to the local service, while logs
+	// keep recording what the client sent. Rejected requests never reach the
+	// local service.
+	// Requests beyond the agent's resource limits are answered 503 before
+	// any other work is done for them
+	var forwardDuration time.Duration
+	forwarded := message.clone()
+	reserved, response := atp.limits.admit(message)
+	if response == nil {
+		response = atp.filterRequest(forwarded)
+	}
+	canary := false
+	if response == nil {
+		// Requests are counted against the address they were sent to, so
+		// switching the target can wait for them
+		target := atp.target.acquire()
+		var addr string
+		addr, canary = atp.split.route(forwarded, target.addr)
+		localSpan := atp.telemetry.StartSpan("local.request", telemetry.SpanKindClient, span.Context())
+		if host, port, err := net.SplitHostPort(addr); err == nil {
+			localSpan.SetAttribute("server.address", host)
+			if n, err := strconv.Atoi(port); err == nil {
+				localSpan.SetAttribute("server.port", n)
+			}
+		}
+		forwardStart := time.Now()
+		response = atp.forwardHTTPRequest(addr, forwarded, localSpan.Context(), reserved)
+		forwardDuration = time.Since(forwardStart)
+		atp.target.release(target)
+		localSpan.SetAttribute("http.response.status_code", response.Status)
+		if response.Error != "" {
+			localSpan.SetError(response.Error)
+			span.SetError(response.Error)
+		}
+		localSpan.End()
+	}
+	if canary {
+		if response.Headers == nil {
+			response.Headers = make(map[string]string)
+		}
+		response.Headers[VariantHeader] = VariantCanary
+	}
+
+	atp.filterResponse(message, response)
+
+	// Echo the request ID so clients can quote it, and include it in agent
+	// generated errors so they can be matched to the access log
+	if headerValue(response.Headers, RequestIDHeader) == "" {
+		if response.Headers == nil {
+			response.Headers = make(map[string]string)
+		}
+		response.Headers[RequestIDHeader] = requestID
+	}
+	if response.Error != "" {
+		response.Body = []byte(fmt.Sprintf("%s\nRequest ID: %s\n", response.Error, requestID))
+		atp.pages.render(PageBadGateway, message, response, pageData{Message: response.Error})
+	}
+
+	return response, reserved, forwardDuration
+}
+
+// forwardHTTPRequest performs a tunneled request against the local service at
+// addr and builds the response message to send back. A valid trace context is propagated
+// to the local service in the traceparent header. The response body is read
+// into memory reserved for the request.
+func (atp *AgentTunnelProtocol) forwardHTTPRequest(addr string, message *TunnelMessage, trace telemetry.SpanContext, reserved *reservation) *TunnelMessage {
+	if atp.static != nil {
+		return atp.serveStatic(message)
+	}
+
 	// Create HTTP request to local service
-	targetURL := fmt.Sprintf("http://localhost:%d%s", atp.localPort, message.URL)
+	targetURL := fmt.Sprintf("http://%s%s", addr, message.URL)
 
 	req, err := http.NewRequest(message.Method, targetURL, bytes.NewReader(message.Body))
 	if err != nil {
-		return atp.sendErrorResponse(message.ID, fmt.Sprintf("Failed to create request: %v", err))
+		return errorResponse(message.ID, fmt.Sprintf("Failed to create request: %v", err))
 	}
 
 	// Set headers
 	for name, value := range message.Headers {
 		req.Header.Set(name, value)
 	}
+	if trace.IsValid() {
+		req.Header.Set(telemetry.TraceparentHeader, trace.Traceparent())
+	}
 
 	// Make request to local service
-	client := &http.Client{Timeout: 30 * time.Second}
+	client := &http.Client{Transport: localnet.Transport, Timeout: 30 * time.Second}
 	resp, err := client.Do(req)
 	if err != nil {
-		return atp.sendErrorResponse(message.ID, fmt.Sprintf("Failed to connect to local service: %v", err))
+		return errorResponse(message.ID, fmt.Sprintf("Failed to connect to local service: %v", err))
 	}
 	defer resp.Body.Close()
 
 	// Read response body
-	body, err := io.ReadAll(resp.Body)
+	body, err := reserved.readBody(resp.Body)
 	if err != nil {
-		return atp.sendErrorResponse(message.ID, fmt.Sprintf("Failed to read response: %v", err))
+		return errorResponse(message.ID, fmt.Sprintf("Failed to read response: %v", err))
 	}
 
 	// Convert response headers
@@ -123,8 +382,7 @@ func (atp *AgentTunnelProtocol) handleHTTPRequest(message *TunnelMessage) error
 		headers[name] = strings.Join(values, ", ")
 	}
 
-	// Send response back through tunnel
-	response := &TunnelMessage{
+	return &TunnelMessage{
 		Type:      "http_response",
 		ID:        message.ID,
 		Status:    resp.StatusCode,
@@ -132,13 +390,129 @@ func (atp *AgentTunnelProtocol) handleHTTPRequest(message *TunnelMessage) error
 		Body:      body,
 		Timestamp: time.Now().Unix(),
 	}
+}
+
+// logAccess writes a proxied request to the tunnel's access log, if enabled
+func (atp *AgentTunnelProtocol) logAccess(request, response *TunnelMessage, start time.Time) {
+	if atp.logs == nil || atp.logs.access == nil {
+		return
+	}
 
-	return atp.sendMessage(response)
+	entry := accesslog.Entry{
+		Time:      start,
+		RequestID: headerValue(request.Headers, RequestIDHeader),
+		ClientIP:  clientIP(request.Headers),
+		Method:    request.Method,
+		Path:      request.URL,
+		Status:    response.Status,
+		Bytes:     len(response.Body),
+		Duration:  time.Since(start),
+		Referer:   headerValue(request.Headers, "Referer"),
+		UserAgent: headerValue(request.Headers, "User-Agent"),
+	}
+	if err := atp.logs.access.Log(entry); err != nil {
+		logger.Debug("Failed to write access log entry: %v", err)
+	}
+}
+
+// sampleRequest records the exchange to the tunnel's sample file if this
+// request is picked by the sampling rate
+func (atp *AgentTunnelProtocol) sampleRequest(request, response *TunnelMessage, start time.Time) {
+	if atp.logs == nil || !atp.logs.samples.ShouldSample() {
+		return
+	}
+
+	sampler := atp.logs.samples
+	sample := sampling.Sample{
+		Time:       start,
+		Method:     request.Method,
+		URL:        request.URL,
+		Status:     response.Status,
+		DurationMs: float64(time.Since(start).Microseconds()) / 1000,
+		Error:      response.Error,
+		Request:    sampler.Message(request.Headers, request.Body),
+		Response:   sampler.Message(response.Headers, response.Body),
+	}
+	if err := sampler.Record(sample); err != nil {
+		logger.Debug("Failed to write request sample: %v", err)
+	}
+}
+
+// ensureRequestID returns the request's X-Request-ID, generating one and
+// adding it to the headers forwarded to the local service if the client
+// didn't send one
+func ensureRequestID(message *TunnelMessage) string {
+	if id := headerValue(message.Headers, RequestIDHeader); id != "" {
+		return id
+	}
+
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	id := hex.EncodeToString(buf)
+
+	if message.Headers == nil {
+		message.Headers = make(map[string]string)
+	}
+	message.Headers[RequestIDHeader] = id
+	return id
+}
+
+// clientIP returns the client address the server saw the request come from,
+// or "" if it is not available. Visitors can send their own X-Forwarded-For,
+// so only X-Real-IP, which the server sets, or the last hop, which the server
+// appended, can be trusted.
+func clientIP(headers map[string]string) string {
+	if realIP := strings.TrimSpace(headerValue(headers, "X-Real-IP")); realIP != "" {
+		return realIP
+	}
+	if forwarded := headerValue(headers, "X-Forwarded-For"); forwarded != "" {
+		hops := strings.Split(forwarded, ",")
+		return strings.TrimSpace(hops[len(hops)-1])
+	}
+	return ""
+}
+
+// headerValue looks up a header in a tunnel message case-insensitively
+func headerValue(headers map[string]string, name string) string {
+	for key, value := range headers {
+		if strings.EqualFold(key, name) {
+			return value
+		}
+	}
+	return ""
+}
+
+// localDialer connects WebSocket upgrades to the local service
+var localDialer = &websocket.Dialer{
+	NetDialContext:   localnet.DialContext,
+	HandshakeTimeout: 45 * time.Second,
 }
 
 func (atp *AgentTunnelProtocol) handleWebSocketUpgrade(message *TunnelMessage) error {
+	// Counted until the session is open, when it is counted as a session
+	atp.beginRequest()
+	defer atp.endRequest()
+
+	atp.stats.touch()
+	message = message.clone()
+	if response := atp.filterRequest(message); response != nil {
+		response.Type = "websocket_upgrade_response"
+		return atp.sendMessage(response)
+	}
+
+	// Keep a burst of clients from overwhelming the local service; the place
+	// is released by removeSession, or below if the session never opens
+	if !atp.stats.sessionOpened(atp.websocket.MaxSessions) {
+		logger.Debug("Rejecting WebSocket upgrade %s on tunnel %s: %d sessions already open", message.ID, atp.tunnelName, atp.websocket.MaxSessions)
+		response := rejectResponse(message.ID, http.StatusServiceUnavailable, "Too many WebSocket sessions, try again later")
+		response.Type = "websocket_upgrade_response"
+		response.Headers["Retry-After"] = "30"
+		return atp.sendMessage(response)
+	}
+
 	// Create WebSocket connection to local service
-	localURL := fmt.Sprintf("ws://localhost:%d%s", atp.localPort, message.URL)
+	addr, _ := atp.split.route(message, atp.target.address())
+	localURL := fmt.Sprintf("ws://%s%s", addr, message.URL)
 
 	// Convert headers for WebSocket dial
 	header := http.Header{}
@@ -147,9 +521,10 @@ func (atp *AgentTunnelProtocol) handleWebSocketUpgrade(message *TunnelMessage) e
 	}
 
 	// Connect to local WebSocket service
-	localConn, resp, err := websocket.DefaultDialer.Dial(localURL, header)
+	localConn, resp, err := localDialer.Dial(localURL, header)
 	if err != nil {
 		logger.Debug("Failed to connect to local WebSocket at %s: %v", localURL, err)
+		atp.stats.sessionClosed()
 		// Send upgrade failure response
 		response := &TunnelMessage{
 			Type:      "websocket_upgrade_response",
@@ -178,52 +553,17 @@ func (atp *AgentTunnelProtocol) handleWebSocketUpgrade(message *TunnelMessage) e
 		Timestamp: time.Now().Unix(),
 	}
 
+	session := atp.openSession(message.ID, localConn)
 	if err := atp.sendMessage(response); err != nil {
+		// The client never got the session; stop its goroutines and the
+		// local connection along with it
+		atp.closeSession(session, websocket.CloseGoingAway, "tunnel disconnected", true, false)
+		atp.removeSession(message.ID)
 		return err
 	}
 
 	// Handle WebSocket data forwarding
-	return atp.handleWebSocketForwarding(message.ID, localConn)
-}
-
-func (atp *AgentTunnelProtocol) handleWebSocketData(message *TunnelMessage) error {
-	// This would be implemented to forward WebSocket data
-	logger.Debug("Received WebSocket data for %s: %d bytes", message.ID, len(message.Body))
-	return nil
-}
-
-func (atp *AgentTunnelProtocol) handleWebSocketForwarding(requestID string, localConn *websocket.Conn) error {
-	// Forward messages between tunnel and local WebSocket
-	done := make(chan struct{})
-
-	// Forward from local to tunnel
-	go func() {
-		defer close(done)
-		for {
-			messageType, data, err := localConn.ReadMessage()
-			if err != nil {
-				logger.Debug("Local WebSocket read error: %v", err)
-				return
-			}
-
-			tunnelMsg := &TunnelMessage{
-				Type:      "websocket_data",
-				ID:        requestID,
-				Body:      data,
-				Headers:   map[string]string{"message_type": strconv.Itoa(messageType)},
-				Timestamp: time.Now().Unix(),
-			}
-
-			if err := atp.sendMessage(tunnelMsg); err != nil {
-				logger.Debug("Failed to forward WebSocket message to tunnel: %v", err)
-				return
-			}
-		}
-	}()
-
-	// Wait for either side to close
-	<-done
-	return nil
+	return atp.handleWebSocketForwarding(session)
 }
 
 func (atp *AgentTunnelProtocol) handlePing(message *TunnelMessage) error {
@@ -236,8 +576,9 @@ func (atp *AgentTunnelProtocol) handlePing(message *TunnelMessage) error {
 	return atp.sendMessage(pongMessage)
 }
 
-func (atp *AgentTunnelProtocol) sendErrorResponse(requestID, errorMsg string) error {
-	response := &TunnelMessage{
+// errorResponse builds a 502 response for a request that could not be served locally
+func errorResponse(requestID, errorMsg string) *TunnelMessage {
+	return &TunnelMessage{
 		Type:      "http_response",
 		ID:        requestID,
 		Status:    http.StatusBadGateway,
@@ -246,24 +587,23 @@ func (atp *AgentTunnelProtocol) sendErrorResponse(requestID, errorMsg string) er
 		Error:     errorMsg,
 		Timestamp: time.Now().Unix(),
 	}
-	return atp.sendMessage(response)
 }
 
+// sendMessage queues a message for the connection's writer and waits until
+// it has been written
 func (atp *AgentTunnelProtocol) sendMessage(message *TunnelMessage) error {
-	atp.writeMutex.Lock()
-	defer atp.writeMutex.Unlock()
-
-	data, err := json.Marshal(message)
+	data, release, err := encodeMessage(message)
 	if err != nil {
 		return fmt.Errorf("failed to marshal message: %w", err)
 	}
 
-	// Set write deadline to prevent hanging on dead connections
-	if err := atp.conn.SetWriteDeadline(time.Now().Add(10 * time.Second)); err != nil {
-		return fmt.Errorf("failed to set write deadline: %w", err)
+	size := len(data)
+	if err := atp.writer.write(data, release); err != nil {
+		return err
 	}
 
-	return atp.conn.WriteMessage(websocket.TextMessage, data)
+	atp.stats.sent(size)
+	return nil
 }
 
 // SendPing sends a ping message to the server (JSON-based, deprecated)
@@ -278,8 +618,52 @@ func (atp *AgentTunnelProtocol) SendPing() error {
 	return atp.sendMessage(pingMessage)
 }
 
-// Close closes the tunnel protocol connection
+// beginRequest counts a request as being handled on the connection
+func (atp *AgentTunnelProtocol) beginRequest() {
+	atp.sessionsMutex.Lock()
+	defer atp.sessionsMutex.Unlock()
+
+	atp.requests++
+}
+
+// endRequest counts a request begun with beginRequest as finished
+func (atp *AgentTunnelProtocol) endRequest() {
+	atp.sessionsMutex.Lock()
+	defer atp.sessionsMutex.Unlock()
+
+	atp.requests--
+	atp.notifyIdleLocked()
+}
+
+// drained returns a channel closed once no request is being handled and no
+// WebSocket session is open on the connection, for retiring a connection
+// that no longer receives new work
+func (atp *AgentTunnelProtocol) drained() <-chan struct{} {
+	atp.sessionsMutex.Lock()
+	defer atp.sessionsMutex.Unlock()
+
+	idle := make(chan struct{})
+	atp.idle = idle
+	atp.notifyIdleLocked()
+	return idle
+}
+
+// notifyIdleLocked closes the channel returned by drained once the
+// connection is idle. Call it with sessionsMutex held.
+func (atp *AgentTunnelProtocol) notifyIdleLocked() {
+	if atp.idle != nil && atp.requests == 0 && len(atp.sessions) == 0 {
+		close(atp.idle)
+		atp.idle = nil
+	}
+}
+
+// Close stops the message writer and closes the tunnel protocol connection,
+// along with the WebSocket sessions proxied over it
 func (atp *AgentTunnelProtocol) Close() error {
+	atp.closeSessions()
+	if atp.writer != nil {
+		atp.writer.close()
+	}
 	if atp.conn != nil {
 		return atp.conn.Close()
 	}
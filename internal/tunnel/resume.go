@@ -0,0 +1,147 @@
+package tunnel
+
+import (
+	"net/http"
+	"skyport-agent/internal/crash"
+	"skyport-agent/internal/logger"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// FeatureResume lets a tunnel ride out a short reconnect: the server holds
+// requests that arrive while the tunnel is down and sends them on the new
+// connection, and accepts responses to requests of the old connection there
+const FeatureResume = "resume"
+
+const (
+	// ResumeWindowHeader tells the server how many seconds to hold requests
+	// for a reconnecting tunnel before answering them with an error
+	ResumeWindowHeader = "X-Skyport-Resume-Window"
+
+	// ResumeRequestsHeader tells the server how many requests to hold for a
+	// reconnecting tunnel at most
+	ResumeRequestsHeader = "X-Skyport-Resume-Requests"
+)
+
+const (
+	// resumeWindow is how long a reconnect may take before held requests and
+	// responses are given up
+	resumeWindow = 10 * time.Second
+
+	// resumeMaxResponses and resumeMaxBytes bound the responses held for a
+	// tunnel while it reconnects
+	resumeMaxResponses = 64
+	resumeMaxBytes     = 8 << 20
+)
+
+// resumeHeaders asks the server to hold requests while the tunnel reconnects
+func resumeHeaders(headers http.Header) {
+	headers.Add(ResumeWindowHeader, strconv.Itoa(int(resumeWindow/time.Second)))
+	headers.Add(ResumeRequestsHeader, strconv.Itoa(resumeMaxResponses))
+}
+
+// heldResponse is a response whose connection dropped before it was sent
+type heldResponse struct {
+	message *TunnelMessage
+	size    int
+	heldAt  time.Time
+}
+
+// resumeBuffer holds a tunnel's responses that could not be sent because its
+// connection dropped, until the next connection delivers them. It is shared
+// by all connections of a tunnel.
+type resumeBuffer struct {
+	mu    sync.Mutex
+	held  []heldResponse
+	bytes int
+}
+
+// hold keeps a response for the next connection, and reports whether there
+// was room for it
+func (b *resumeBuffer) hold(message *TunnelMessage) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.dropExpired()
+	size := len(message.Body)
+	if len(b.held) >= resumeMaxResponses || b.bytes+size > resumeMaxBytes {
+		return false
+	}
+	b.held = append(b.held, heldResponse{message: message, size: size, heldAt: time.Now()})
+	b.bytes += size
+	return true
+}
+
+// take removes and returns the responses still within the resume window
+func (b *resumeBuffer) take() []*TunnelMessage {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.dropExpired()
+	messages := make([]*TunnelMessage, 0, len(b.held))
+	for _, held := range b.held {
+		messages = append(messages, held.message)
+	}
+	b.held, b.bytes = nil, 0
+	return messages
+}
+
+// dropExpired forgets responses the server has stopped waiting for
+func (b *resumeBuffer) dropExpired() {
+	kept := b.held[:0]
+	for _, held := range b.held {
+		if time.Since(held.heldAt) < resumeWindow {
+			kept = append(kept, held)
+		} else {
+			b.bytes -= held.size
+		}
+	}
+	b.held = kept
+}
+
+// resumeFor returns the buffer holding a tunnel's responses across reconnects
+func (tm *TunnelManager) resumeFor(tunnelID string) *resumeBuffer {
+	tm.resumeMutex.Lock()
+	defer tm.resumeMutex.Unlock()
+
+	buffer, exists := tm.resumes[tunnelID]
+	if !exists {
+		buffer = &resumeBuffer{}
+		tm.resumes[tunnelID] = buffer
+	}
+	return buffer
+}
+
+// forgetResume drops the responses held for a tunnel that is not coming back
+func (tm *TunnelManager) forgetResume(tunnelID string) {
+	tm.resumeMutex.Lock()
+	defer tm.resumeMutex.Unlock()
+
+	delete(tm.resumes, tunnelID)
+}
+
+// resumeResponses sends the responses held while the tunnel reconnected on
+// its new connection
+func (tm *TunnelManager) resumeResponses(tunnelConn *TunnelConnection) {
+	defer crash.Recover("resume responses")
+
+	protocol := tunnelConn.Protocol
+	if protocol.resume == nil {
+		return
+	}
+
+	messages := protocol.resume.take()
+	delivered := 0
+	for _, message := range messages {
+		if err := protocol.sendMessage(message); err != nil {
+			// The new connection dropped too; hold it for the next one
+			protocol.resume.hold(message)
+			continue
+		}
+		delivered++
+	}
+	if delivered > 0 {
+		logger.Info("Tunnel %s delivered %d response(s) held while it reconnected", tunnelConn.Tunnel.Name, delivered)
+	}
+}
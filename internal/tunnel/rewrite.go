@@ -0,0 +1,68 @@
+package tunnel
+
+import (
+	"regexp"
+	"skyport-agent/internal/config"
+	"skyport-agent/internal/logger"
+	"strings"
+)
+
+// pathRule is a compiled regex substitution on the request path
+type pathRule struct {
+	pattern *regexp.Regexp
+	replace string
+}
+
+// rewriteFilter rewrites request paths according to the tunnel's rewrite
+// settings: the prefix is stripped, then added, then the regex rules are
+// applied in order. The query string is left untouched. Returns nil if no
+// rewriting is configured.
+func rewriteFilter(tunnel *config.Tunnel) requestFilter {
+	if tunnel.Settings == nil {
+		return nil
+	}
+	settings := tunnel.Settings.Rewrite
+
+	stripPrefix := strings.TrimSuffix(settings.StripPrefix, "/")
+	addPrefix := strings.TrimSuffix(settings.AddPrefix, "/")
+
+	var rules []pathRule
+	for _, rule := range settings.Rules {
+		pattern, err := regexp.Compile(rule.Match)
+		if err != nil {
+			logger.Warning("Ignoring invalid rewrite rule %q for tunnel %s: %v", rule.Match, tunnel.Name, err)
+			continue
+		}
+		rules = append(rules, pathRule{pattern: pattern, replace: rule.Replace})
+	}
+
+	if stripPrefix == "" && addPrefix == "" && len(rules) == 0 {
+		return nil
+	}
+
+	return func(message *TunnelMessage) *TunnelMessage {
+		path, query, hasQuery := strings.Cut(message.URL, "?")
+
+		if stripPrefix != "" && (path == stripPrefix || strings.HasPrefix(path, stripPrefix+"/")) {
+			path = strings.TrimPrefix(path, stripPrefix)
+		}
+		if addPrefix != "" {
+			path = addPrefix + path
+		}
+		for _, rule := range rules {
+			path = rule.pattern.ReplaceAllString(path, rule.replace)
+		}
+		if !strings.HasPrefix(path, "/") {
+			path = "/" + path
+		}
+
+		if hasQuery {
+			path += "?" + query
+		}
+		if path != message.URL {
+			logger.Debug("Rewrote %s to %s", message.URL, path)
+			message.URL = path
+		}
+		return nil
+	}
+}
@@ -0,0 +1,101 @@
+package tunnel
+
+import (
+	"net/http"
+	"skyport-agent/internal/config"
+	"strings"
+)
+
+// hopByHopHeaders describe a single connection and are never forwarded by a
+// proxy (RFC 9110, section 7.6.1)
+var hopByHopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Proxy-Connection",
+	"TE",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// sanitizeFilter strips hop-by-hop headers, and the headers the tunnel's
+// sanitize policy denies, from requests before they are forwarded. It always
+// applies: hop-by-hop headers are stripped even without a policy.
+func sanitizeFilter(tunnel *config.Tunnel) requestFilter {
+	policy := tunnel.SanitizeSettings()
+
+	var exact, prefixes []string
+	for _, name := range policy.Deny {
+		if prefix, ok := strings.CutSuffix(name, "*"); ok {
+			prefixes = append(prefixes, strings.ToLower(prefix))
+		} else {
+			exact = append(exact, name)
+		}
+	}
+	allowCookies := make(map[string]bool)
+	for _, name := range policy.AllowCookies {
+		allowCookies[name] = true
+	}
+
+	return func(message *TunnelMessage) *TunnelMessage {
+		if message.Headers == nil {
+			return nil
+		}
+
+		// Headers listed in Connection are hop-by-hop as well
+		for _, name := range strings.Split(headerValue(message.Headers, "Connection"), ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				removeHeader(message.Headers, name)
+			}
+		}
+		for _, name := range hopByHopHeaders {
+			removeHeader(message.Headers, name)
+		}
+
+		if policy.StripAuthorization {
+			removeHeader(message.Headers, "Authorization")
+		}
+		switch policy.Cookies {
+		case config.CookiesNone:
+			removeHeader(message.Headers, "Cookie")
+		case config.CookiesAllow:
+			keepRequestCookies(message.Headers, allowCookies)
+		}
+
+		for _, name := range exact {
+			removeHeader(message.Headers, name)
+		}
+		for name := range message.Headers {
+			lower := strings.ToLower(name)
+			for _, prefix := range prefixes {
+				if strings.HasPrefix(lower, prefix) {
+					delete(message.Headers, name)
+					break
+				}
+			}
+		}
+		return nil
+	}
+}
+
+// keepRequestCookies drops every cookie not in allowed from the request's
+// Cookie header
+func keepRequestCookies(headers map[string]string, allowed map[string]bool) {
+	cookies, err := http.ParseCookie(headerValue(headers, "Cookie"))
+	removeHeader(headers, "Cookie")
+	if err != nil {
+		return // Forward no cookies rather than ones that could not be checked
+	}
+
+	var kept []string
+	for _, c := range cookies {
+		if allowed[c.Name] {
+			kept = append(kept, c.String())
+		}
+	}
+	if len(kept) > 0 {
+		headers["Cookie"] = strings.Join(kept, "; ")
+	}
+}
@@ -0,0 +1,272 @@
+package tunnel
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"regexp"
+	"skyport-agent/internal/config"
+	"skyport-agent/internal/logger"
+	"strconv"
+	"strings"
+)
+
+// maxScanBytes bounds a decompressed body held for scanning
+const maxScanBytes = 32 << 20
+
+// scanPattern is a compiled pattern response bodies are scanned for
+type scanPattern struct {
+	name   string
+	regexp *regexp.Regexp
+	valid  func(match []byte) bool // Rules out false positives, if set
+}
+
+// builtinScanPatterns find common credentials and card numbers
+var builtinScanPatterns = []scanPattern{
+	{name: "private-key", regexp: regexp.MustCompile(`-----BEGIN (?:[A-Z0-9]+ )*PRIVATE KEY-----`)},
+	{name: "aws-access-key", regexp: regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`)},
+	{name: "github-token", regexp: regexp.MustCompile(`\b(?:gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{22,})\b`)},
+	{name: "slack-token", regexp: regexp.MustCompile(`\bxox[abposr]-[A-Za-z0-9-]{10,}`)},
+	{name: "stripe-secret-key", regexp: regexp.MustCompile(`\b[rs]k_live_[A-Za-z0-9]{16,}`)},
+	{name: "google-api-key", regexp: regexp.MustCompile(`\bAIza[0-9A-Za-z_-]{35}\b`)},
+	{name: "credit-card", regexp: regexp.MustCompile(`\b(?:4\d{3}|5[1-5]\d{2}|2[2-7]\d{2}|3[47]\d{2}|6(?:011|5\d{2}))(?:[ -]?\d){9,15}\b`), valid: luhnValid},
+}
+
+// BuiltinScanPatterns returns the names of the patterns content scanning
+// checks unless told to skip them
+func BuiltinScanPatterns() []string {
+	names := make([]string, len(builtinScanPatterns))
+	for i, pattern := range builtinScanPatterns {
+		names[i] = pattern.name
+	}
+	return names
+}
+
+// scanOutput is read from a scan command's stdout. Empty output means the
+// body is clean.
+type scanOutput struct {
+	Findings []string `json:"findings"`
+	Body     *string  `json:"body,omitempty"` // Redacted body, used when the action is "redact"
+}
+
+// scanFilter checks textual response bodies for secrets and personal data
+// before they are sent. Responses with findings are answered 502 instead, or
+// have the matches redacted, and each one is reported as an event. Returns
+// nil if scanning is disabled.
+func scanFilter(tunnel *config.Tunnel, emit func(Event)) responseFilter {
+	settings := tunnel.ScanSettings()
+	if !settings.Enabled {
+		return nil
+	}
+	tunnelID, tunnelName := tunnel.ID, tunnel.Name
+
+	var patterns []scanPattern
+	if !settings.SkipBuiltin {
+		patterns = append(patterns, builtinScanPatterns...)
+	}
+	invalid := false
+	for _, pattern := range settings.Patterns {
+		compiled, err := regexp.Compile(pattern.Regex)
+		if err != nil {
+			// Skipping it could let through the secret it was written to catch
+			logger.Warning("Content scan pattern %s for tunnel %s is invalid, blocking all scanned responses: %v", pattern.Name, tunnelName, err)
+			invalid = true
+			continue
+		}
+		patterns = append(patterns, scanPattern{name: pattern.Name, regexp: compiled})
+	}
+
+	return func(request, response *TunnelMessage) {
+		body, ok := scannableBody(response)
+		if !ok {
+			return
+		}
+
+		findings, redacted := scanBody(patterns, body, settings.Action == config.ScanActionRedact)
+		if invalid {
+			findings = append(findings, "invalid-pattern")
+			redacted = nil
+		}
+		if settings.Command != "" && (len(findings) == 0 || redacted != nil) {
+			input := body
+			if redacted != nil {
+				input = redacted
+			}
+			output, err := runScanCommand(settings, tunnelName, request, response, input)
+			if err != nil {
+				logger.Warning("Content scan command %s failed for tunnel %s: %v", settings.Command, tunnelName, err)
+				if !settings.FailOpen {
+					findings, redacted = append(findings, "scan-command-failed"), nil
+				}
+			} else if len(output.Findings) > 0 {
+				findings = append(findings, output.Findings...)
+				redacted = nil
+				if output.Body != nil && settings.Action == config.ScanActionRedact {
+					redacted = []byte(*output.Body)
+				}
+			}
+		}
+		if len(findings) == 0 {
+			return
+		}
+
+		path, _, _ := strings.Cut(request.URL, "?")
+		event := Event{
+			TunnelID:   tunnelID,
+			TunnelName: tunnelName,
+			Reason:     fmt.Sprintf("%s %s: %s", request.Method, path, strings.Join(uniqueStrings(findings), ", ")),
+		}
+		if redacted != nil {
+			event.Type = EventContentRedacted
+			replaceBody(response, redacted)
+			logger.Warning("Redacted response to %s", event.Reason)
+		} else {
+			event.Type = EventContentBlocked
+			*response = *rejectResponse(response.ID, http.StatusBadGateway, "Response blocked by content scanning")
+			logger.Warning("Blocked response to %s", event.Reason)
+		}
+		if emit != nil {
+			emit(event)
+		}
+	}
+}
+
+// scanBody returns the names of the patterns found in the body and, when
+// redacting, the body with the matches replaced. Without findings the
+// redacted body is nil.
+func scanBody(patterns []scanPattern, body []byte, redact bool) ([]string, []byte) {
+	var findings []string
+	redacted := body
+	for _, pattern := range patterns {
+		found := false
+		redacted = pattern.regexp.ReplaceAllFunc(redacted, func(match []byte) []byte {
+			if pattern.valid != nil && !pattern.valid(match) {
+				return match
+			}
+			found = true
+			return []byte("[REDACTED:" + pattern.name + "]")
+		})
+		if found {
+			findings = append(findings, pattern.name)
+		}
+	}
+	if len(findings) == 0 || !redact {
+		return findings, nil
+	}
+	return findings, redacted
+}
+
+// runScanCommand runs the scan command with the body on stdin
+func runScanCommand(settings config.ScanSettings, tunnelName string, request, response *TunnelMessage, body []byte) (*scanOutput, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), settings.Timeout.Duration)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, settings.Command, settings.Args...)
+	cmd.Stdin = bytes.NewReader(body)
+	cmd.Env = append(os.Environ(),
+		"SKYPORT_TUNNEL="+tunnelName,
+		"SKYPORT_METHOD="+request.Method,
+		"SKYPORT_URL="+request.URL,
+		"SKYPORT_STATUS="+strconv.Itoa(response.Status),
+		"SKYPORT_CONTENT_TYPE="+headerValue(response.Headers, "Content-Type"),
+	)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, fmt.Errorf("timed out after %s", settings.Timeout.Duration)
+		}
+		return nil, fmt.Errorf("%w: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+
+	var output scanOutput
+	if len(bytes.TrimSpace(stdout.Bytes())) == 0 {
+		return &output, nil
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &output); err != nil {
+		return nil, fmt.Errorf("invalid scan command output: %w", err)
+	}
+	return &output, nil
+}
+
+// scannableBody returns a response's body as text to scan, decompressing
+// gzip. Binary and otherwise encoded bodies are not scanned.
+func scannableBody(response *TunnelMessage) ([]byte, bool) {
+	if len(response.Body) == 0 {
+		return nil, false
+	}
+	contentType := headerValue(response.Headers, "Content-Type")
+	if contentType != "" && !compressible(contentType) && !strings.HasPrefix(strings.ToLower(contentType), "application/x-www-form-urlencoded") {
+		return nil, false
+	}
+
+	switch strings.ToLower(headerValue(response.Headers, "Content-Encoding")) {
+	case "", "identity":
+		return response.Body, true
+	case "gzip":
+		reader, err := gzip.NewReader(bytes.NewReader(response.Body))
+		if err != nil {
+			return nil, false
+		}
+		body, err := io.ReadAll(io.LimitReader(reader, maxScanBytes))
+		if err != nil {
+			return nil, false
+		}
+		return body, true
+	default:
+		logger.Debug("Not scanning response with Content-Encoding %s", headerValue(response.Headers, "Content-Encoding"))
+		return nil, false
+	}
+}
+
+// replaceBody replaces a response's body with uncompressed content
+func replaceBody(response *TunnelMessage, body []byte) {
+	response.Body = body
+	removeHeader(response.Headers, "Content-Encoding")
+	removeHeader(response.Headers, "Content-Length")
+	removeHeader(response.Headers, "ETag")
+	response.Headers["Content-Length"] = strconv.Itoa(len(body))
+}
+
+// luhnValid reports whether a run of digits, spaces and dashes passes the
+// Luhn check card numbers use
+func luhnValid(match []byte) bool {
+	sum, digits := 0, 0
+	for i := len(match) - 1; i >= 0; i-- {
+		c := match[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if digits%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		digits++
+	}
+	return digits >= 13 && sum%10 == 0
+}
+
+// uniqueStrings returns the strings in order without repeats
+func uniqueStrings(values []string) []string {
+	seen := make(map[string]bool, len(values))
+	var unique []string
+	for _, value := range values {
+		if !seen[value] {
+			seen[value] = true
+			unique = append(unique, value)
+		}
+	}
+	return unique
+}
@@ -0,0 +1,72 @@
+package tunnel
+
+import (
+	"fmt"
+	"skyport-agent/internal/config"
+	"skyport-agent/internal/logger"
+)
+
+// Messages the server pushes when a tunnel is changed in the dashboard
+const (
+	messageTunnelUpdated = "tunnel_updated"
+	messageTunnelDeleted = "tunnel_deleted"
+)
+
+// handleServerMessage reacts to the server reporting that a connected
+// tunnel was changed, deleted or taken over by another machine. A deleted or
+// taken over tunnel is disconnected right away;
+// an update is reported as an event, for the owner of the tunnel's
+// configuration to fetch and apply with UpdateTunnel. Commands the server
+// sends are run as well (see OnCommand).
+func (tm *TunnelManager) handleServerMessage(tunnel config.Tunnel, message *TunnelMessage) {
+	switch message.Type {
+	case messageTunnelUpdated:
+		logger.Debug("Server reports tunnel %s was updated", tunnel.Name)
+		tm.emit(Event{
+			Type:       EventServerUpdated,
+			TunnelID:   tunnel.ID,
+			TunnelName: tunnel.Name,
+			Reason:     "updated on the server",
+		})
+	case messageTunnelDeleted:
+		reason := "deleted on the server"
+		logger.Warning("Tunnel %s was deleted on the server, disconnecting it", tunnel.Name)
+		if err := tm.disconnectTunnel(tunnel.ID, reason); err != nil {
+			logger.Debug("Failed to disconnect deleted tunnel %s: %v", tunnel.Name, err)
+		}
+		tm.emit(Event{
+			Type:       EventServerDeleted,
+			TunnelID:   tunnel.ID,
+			TunnelName: tunnel.Name,
+			Reason:     reason,
+		})
+	case messageTakenOver:
+		tm.takenOver(tunnel, message)
+	case messageAgentCommand:
+		tm.handleCommand(tunnel, message)
+	}
+}
+
+// ConnectedTunnel returns the configuration a tunnel is connected with
+func (tm *TunnelManager) ConnectedTunnel(tunnelID string) (config.Tunnel, bool) {
+	tunnelConn := tm.getConnection(tunnelID)
+	if tunnelConn == nil {
+		return config.Tunnel{}, false
+	}
+	return tunnelConn.Tunnel, true
+}
+
+// UpdateTunnel reconnects a connected tunnel with a changed configuration,
+// such as a new local port or subdomain. A supervised tunnel stays
+// supervised.
+func (tm *TunnelManager) UpdateTunnel(tunnel *config.Tunnel, token string) error {
+	supervised := tm.IsSupervised(tunnel.ID)
+	if err := tm.disconnectTunnel(tunnel.ID, "updated on the server"); err != nil {
+		return fmt.Errorf("tunnel %s is not connected", tunnel.Name)
+	}
+
+	if supervised {
+		return tm.Supervise(tunnel, token)
+	}
+	return tm.ConnectTunnel(tunnel, token)
+}
@@ -0,0 +1,63 @@
+package tunnel
+
+import (
+	"hash/fnv"
+	"math/rand"
+	"skyport-agent/internal/config"
+)
+
+// VariantHeader tags requests sent to the alternate target of a traffic
+// split, and their responses, with VariantCanary
+const (
+	VariantHeader = "X-Skyport-Variant"
+	VariantCanary = "canary"
+)
+
+// trafficSplit sends a share of a tunnel's requests to an alternate local
+// target. A nil *trafficSplit sends everything to the tunnel's target.
+type trafficSplit struct {
+	target  string
+	percent int
+	sticky  bool
+}
+
+// newTrafficSplit returns the tunnel's traffic split, or nil if it has none.
+// Tunnels serving a static directory are not split.
+func newTrafficSplit(tunnel *config.Tunnel) *trafficSplit {
+	if tunnel.Settings == nil || tunnel.StaticSettings().Dir != "" {
+		return nil
+	}
+	settings := tunnel.Settings.Split
+	if !settings.Enabled || settings.Target == "" || settings.Percent <= 0 {
+		return nil
+	}
+	return &trafficSplit{target: settings.Target, percent: min(settings.Percent, 100), sticky: settings.Sticky}
+}
+
+// route returns the address to forward the request to, tagging it if it
+// goes to the alternate target. The client can't pick the variant: a
+// VariantHeader it sent is removed.
+func (s *trafficSplit) route(message *TunnelMessage, primary string) (addr string, canary bool) {
+	if s == nil {
+		return primary, false
+	}
+	removeHeader(message.Headers, VariantHeader)
+
+	var n int
+	if ip := clientIP(message.Headers); s.sticky && ip != "" {
+		h := fnv.New32a()
+		h.Write([]byte(ip))
+		n = int(h.Sum32() % 100)
+	} else {
+		n = rand.Intn(100)
+	}
+	if n >= s.percent {
+		return primary, false
+	}
+
+	if message.Headers == nil {
+		message.Headers = make(map[string]string)
+	}
+	message.Headers[VariantHeader] = VariantCanary
+	return s.target, true
+}
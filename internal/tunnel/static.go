@@ -0,0 +1,130 @@
+package tunnel
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"net/http"
+	"path"
+	"skyport-agent/internal/config"
+	"strings"
+	"time"
+)
+
+// staticFS serves files from a directory, hiding dotfiles (.git, .env) and,
+// unless listings are enabled, directories without an index.html
+type staticFS struct {
+	root    http.Dir
+	listing bool
+}
+
+func (sfs staticFS) Open(name string) (http.File, error) {
+	for _, segment := range strings.Split(name, "/") {
+		if strings.HasPrefix(segment, ".") && segment != "." {
+			return nil, fs.ErrNotExist
+		}
+	}
+
+	file, err := sfs.root.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if !sfs.listing {
+		info, err := file.Stat()
+		if err == nil && info.IsDir() {
+			index, err := sfs.root.Open(path.Join(name, "index.html"))
+			if err != nil {
+				file.Close()
+				return nil, fs.ErrNotExist
+			}
+			index.Close()
+		}
+	}
+
+	return file, nil
+}
+
+// staticHandler serves a tunnel's static directory, or returns nil if the
+// tunnel forwards to a local port. With SPA fallback, paths that don't match
+// a file are answered with the root index.html so client-side routing works.
+func staticHandler(tunnel *config.Tunnel) http.Handler {
+	settings := tunnel.StaticSettings()
+	if settings.Dir == "" {
+		return nil
+	}
+
+	files := staticFS{root: http.Dir(settings.Dir), listing: settings.Listing}
+	fileServer := http.FileServer(files)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if settings.SPA && (r.Method == http.MethodGet || r.Method == http.MethodHead) {
+			file, err := files.Open(path.Clean("/" + r.URL.Path))
+			if errors.Is(err, fs.ErrNotExist) && path.Ext(r.URL.Path) == "" {
+				r.URL.Path = "/"
+			}
+			if err == nil {
+				file.Close()
+			}
+		}
+		fileServer.ServeHTTP(w, r)
+	})
+}
+
+// responseBuffer is an http.ResponseWriter that collects a response in memory
+type responseBuffer struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (rb *responseBuffer) Header() http.Header {
+	return rb.header
+}
+
+func (rb *responseBuffer) WriteHeader(status int) {
+	if rb.status == 0 {
+		rb.status = status
+	}
+}
+
+func (rb *responseBuffer) Write(data []byte) (int, error) {
+	rb.WriteHeader(http.StatusOK)
+	return rb.body.Write(data)
+}
+
+// serveStatic answers a tunneled request from the tunnel's static directory
+func (atp *AgentTunnelProtocol) serveStatic(message *TunnelMessage) *TunnelMessage {
+	return serveHandler(atp.static, message)
+}
+
+// serveHandler answers a tunneled request with an in-process HTTP handler
+func serveHandler(handler http.Handler, message *TunnelMessage) *TunnelMessage {
+	req, err := http.NewRequest(message.Method, message.URL, bytes.NewReader(message.Body))
+	if err != nil {
+		return errorResponse(message.ID, "Failed to create request: "+err.Error())
+	}
+	for name, value := range message.Headers {
+		req.Header.Set(name, value)
+	}
+
+	buffer := &responseBuffer{header: make(http.Header)}
+	handler.ServeHTTP(buffer, req)
+	if buffer.status == 0 {
+		buffer.status = http.StatusOK
+	}
+
+	headers := make(map[string]string)
+	for name, values := range buffer.header {
+		headers[name] = strings.Join(values, ", ")
+	}
+
+	return &TunnelMessage{
+		Type:      "http_response",
+		ID:        message.ID,
+		Status:    buffer.status,
+		Headers:   headers,
+		Body:      buffer.body.Bytes(),
+		Timestamp: time.Now().Unix(),
+	}
+}
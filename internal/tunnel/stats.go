@@ -0,0 +1,377 @@
+package tunnel
+
+import (
+	"os"
+	"skyport-agent/internal/config"
+	"skyport-agent/internal/logger"
+	"skyport-agent/internal/metrics"
+	"skyport-agent/internal/state"
+	"skyport-agent/internal/usage"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// LatencyStats separates time spent in the local service from time spent on
+// the tunnel itself, so slowness can be attributed to one or the other
+type LatencyStats struct {
+	Forwarding metrics.Percentiles `json:"forwarding"` // Local service request time
+	RoundTrip  metrics.Percentiles `json:"round_trip"` // WebSocket ping/pong RTT to the server
+}
+
+// TrafficStats is a point-in-time view of a tunnel's traffic. Counters are
+// cumulative since the hosting process started; rates come from diffing two
+// snapshots.
+type TrafficStats struct {
+	TunnelID   string                    `json:"tunnel_id"`
+	TunnelName string                    `json:"tunnel_name"`
+	Connected  bool                      `json:"connected"`
+	Requests   int64                     `json:"requests"`
+	InFlight   int64                     `json:"in_flight"`
+	BytesIn    int64                     `json:"bytes_in"`
+	BytesOut   int64                     `json:"bytes_out"`
+	Forwarding metrics.Percentiles       `json:"forwarding_latency"`
+	RoundTrip  metrics.Percentiles       `json:"round_trip"` // Heartbeat RTT to the server
+	Errors     metrics.ErrorSummary      `json:"errors"`
+	Quality    metrics.ConnectionQuality `json:"quality"`
+	Denied     map[string]int64          `json:"denied,omitempty"` // Requests rejected by each header filter rule
+	Sessions   int64                     `json:"websocket_sessions"`
+}
+
+// tunnelStats is kept per tunnel rather than per connection so that the
+// samples and counters survive reconnects. A nil *tunnelStats records nothing.
+type tunnelStats struct {
+	tunnelID   string
+	tunnelName string
+	forwarding *metrics.LatencyTracker
+	roundTrip  *metrics.LatencyTracker
+	quality    *metrics.QualityTracker
+	errors     *metrics.ErrorTracker
+	usage      *usage.Counter
+
+	// startedAt is when the tunnel first connected (Unix nanoseconds) and
+	// connections how many connections it has had since
+	startedAt   atomic.Int64
+	connections atomic.Int64
+
+	// lastActive is when the tunnel last had a request or WebSocket message
+	// (Unix nanoseconds), for the idle timeout
+	lastActive atomic.Int64
+
+	requests atomic.Int64
+	inFlight atomic.Int64
+	bytesIn  atomic.Int64
+	bytesOut atomic.Int64
+
+	// sessions counts open WebSocket sessions across the tunnel's
+	// connections, for the session cap
+	sessions atomic.Int64
+
+	// denied counts requests rejected by each header filter rule
+	denied      map[string]int64
+	deniedMutex sync.Mutex
+}
+
+// received counts a message read from the server
+func (ts *tunnelStats) received(n int) {
+	if ts == nil {
+		return
+	}
+	ts.bytesIn.Add(int64(n))
+	ts.usage.AddIn(n)
+}
+
+// sent counts a message written to the server
+func (ts *tunnelStats) sent(n int) {
+	if ts == nil {
+		return
+	}
+	ts.bytesOut.Add(int64(n))
+	ts.usage.AddOut(n)
+}
+
+// connected counts a new connection and starts judging its quality. connect
+// is its TCP handshake time, or zero if unknown.
+func (ts *tunnelStats) connected(connect time.Duration) {
+	if ts == nil {
+		return
+	}
+	ts.startedAt.CompareAndSwap(0, time.Now().UnixNano())
+	ts.connections.Add(1)
+	ts.quality.Connected(connect)
+}
+
+// touch records activity on the tunnel, restarting its idle timeout
+func (ts *tunnelStats) touch() {
+	if ts == nil {
+		return
+	}
+	ts.lastActive.Store(time.Now().UnixNano())
+}
+
+// idleFor returns how long the tunnel has had no requests in flight and no
+// activity. Reconnects do not count as activity.
+func (ts *tunnelStats) idleFor() time.Duration {
+	if ts == nil || ts.inFlight.Load() > 0 {
+		return 0
+	}
+	since := ts.lastActive.Load()
+	if started := ts.startedAt.Load(); started > since {
+		since = started
+	}
+	if since == 0 {
+		return 0
+	}
+	return time.Since(time.Unix(0, since))
+}
+
+// writeStalled counts a write to the server that blocked
+func (ts *tunnelStats) writeStalled() {
+	if ts == nil {
+		return
+	}
+	ts.quality.Stalled()
+}
+
+// requestStarted counts a proxied request as in flight
+func (ts *tunnelStats) requestStarted() {
+	if ts == nil {
+		return
+	}
+	ts.touch()
+	ts.requests.Add(1)
+	ts.inFlight.Add(1)
+	ts.usage.AddRequest()
+}
+
+// requestFinished records the outcome of a proxied request. forwardDuration is
+// zero for requests rejected by the agent without reaching the local service.
+func (ts *tunnelStats) requestFinished(request, response *TunnelMessage, forwardDuration time.Duration) {
+	if ts == nil {
+		return
+	}
+	ts.touch()
+	ts.inFlight.Add(-1)
+	if forwardDuration > 0 {
+		ts.forwarding.Record(forwardDuration)
+	}
+	ts.errors.Record(headerValue(request.Headers, RequestIDHeader), request.Method, request.URL, response.Status, response.Error)
+}
+
+// sessionOpened reserves a place for a new WebSocket session, reporting
+// false if max (when positive) are already open
+func (ts *tunnelStats) sessionOpened(max int) bool {
+	if ts == nil {
+		return true
+	}
+	if n := ts.sessions.Add(1); max > 0 && n > int64(max) {
+		ts.sessions.Add(-1)
+		return false
+	}
+	return true
+}
+
+// sessionClosed releases the place of a WebSocket session
+func (ts *tunnelStats) sessionClosed() {
+	if ts == nil {
+		return
+	}
+	ts.sessions.Add(-1)
+}
+
+// requestDenied counts a request rejected by a header filter rule
+func (ts *tunnelStats) requestDenied(rule string) {
+	if ts == nil {
+		return
+	}
+	ts.deniedMutex.Lock()
+	defer ts.deniedMutex.Unlock()
+	if ts.denied == nil {
+		ts.denied = make(map[string]int64)
+	}
+	ts.denied[rule]++
+}
+
+// deniedCounts returns a copy of the header filter counters, or nil if no
+// request was denied
+func (ts *tunnelStats) deniedCounts() map[string]int64 {
+	ts.deniedMutex.Lock()
+	defer ts.deniedMutex.Unlock()
+	if len(ts.denied) == 0 {
+		return nil
+	}
+	counts := make(map[string]int64, len(ts.denied))
+	for rule, n := range ts.denied {
+		counts[rule] = n
+	}
+	return counts
+}
+
+// statsFor returns the stats for a tunnel, creating them on first use
+func (tm *TunnelManager) statsFor(tunnel *config.Tunnel) *tunnelStats {
+	tm.statsMutex.Lock()
+	defer tm.statsMutex.Unlock()
+
+	stats, exists := tm.stats[tunnel.ID]
+	if !exists {
+		stats = &tunnelStats{
+			tunnelID:   tunnel.ID,
+			tunnelName: tunnel.Name,
+			forwarding: metrics.NewLatencyTracker(),
+			roundTrip:  metrics.NewLatencyTracker(),
+			quality:    metrics.NewQualityTracker(),
+			errors:     metrics.NewErrorTracker(),
+			usage:      usage.NewCounter(tunnel.ID, tunnel.Name),
+		}
+		tm.stats[tunnel.ID] = stats
+	}
+	return stats
+}
+
+// getStats returns the stats for a tunnel that has been connected, or nil
+func (tm *TunnelManager) getStats(tunnelID string) *tunnelStats {
+	tm.statsMutex.Lock()
+	defer tm.statsMutex.Unlock()
+
+	return tm.stats[tunnelID]
+}
+
+// flushUsage writes a tunnel's pending traffic counters to disk
+func (tm *TunnelManager) flushUsage(tunnelID string) {
+	stats := tm.getStats(tunnelID)
+	if stats == nil {
+		return
+	}
+
+	if err := stats.usage.Flush(); err != nil {
+		logger.Debug("Failed to save usage for tunnel %s: %v", tunnelID, err)
+	}
+}
+
+// GetLatencyStats returns latency percentiles for each connected tunnel
+func (tm *TunnelManager) GetLatencyStats() map[string]LatencyStats {
+	latency := make(map[string]LatencyStats)
+	for _, tunnelID := range tm.GetActiveTunnels() {
+		stats := tm.getStats(tunnelID)
+		if stats == nil {
+			continue
+		}
+		latency[tunnelID] = LatencyStats{
+			Forwarding: stats.forwarding.Percentiles(),
+			RoundTrip:  stats.roundTrip.Percentiles(),
+		}
+	}
+	return latency
+}
+
+// GetTrafficStats returns traffic counters for every tunnel that has been
+// connected by this manager
+func (tm *TunnelManager) GetTrafficStats() []TrafficStats {
+	tm.statsMutex.Lock()
+	all := make([]*tunnelStats, 0, len(tm.stats))
+	for _, stats := range tm.stats {
+		all = append(all, stats)
+	}
+	tm.statsMutex.Unlock()
+
+	traffic := make([]TrafficStats, 0, len(all))
+	for _, stats := range all {
+		traffic = append(traffic, TrafficStats{
+			TunnelID:   stats.tunnelID,
+			TunnelName: stats.tunnelName,
+			Connected:  tm.IsConnected(stats.tunnelID),
+			Requests:   stats.requests.Load(),
+			InFlight:   stats.inFlight.Load(),
+			BytesIn:    stats.bytesIn.Load(),
+			BytesOut:   stats.bytesOut.Load(),
+			Forwarding: stats.forwarding.Percentiles(),
+			RoundTrip:  stats.roundTrip.Percentiles(),
+			Errors:     stats.errors.Summary(),
+			Quality:    stats.quality.Quality(),
+			Denied:     stats.deniedCounts(),
+		})
+	}
+	return traffic
+}
+
+// GetConnectionQuality grades the server connection of each connected tunnel
+func (tm *TunnelManager) GetConnectionQuality() map[string]metrics.ConnectionQuality {
+	quality := make(map[string]metrics.ConnectionQuality)
+	for _, tunnelID := range tm.GetActiveTunnels() {
+		if stats := tm.getStats(tunnelID); stats != nil {
+			quality[tunnelID] = stats.quality.Quality()
+		}
+	}
+	return quality
+}
+
+// GetErrorStats returns rolling error counts and recent errors for each connected tunnel
+func (tm *TunnelManager) GetErrorStats() map[string]metrics.ErrorSummary {
+	errors := make(map[string]metrics.ErrorSummary)
+	for _, tunnelID := range tm.GetActiveTunnels() {
+		if stats := tm.getStats(tunnelID); stats != nil {
+			errors[tunnelID] = stats.errors.Summary()
+		}
+	}
+	return errors
+}
+
+// pingPayload stamps a heartbeat ping with its send time so the pong can be timed
+func pingPayload() []byte {
+	return []byte(strconv.FormatInt(time.Now().UnixNano(), 10))
+}
+
+// recordPing notes a heartbeat ping, counting the previous one as lost if it
+// was never answered
+func (tm *TunnelManager) recordPing(tunnelConn *TunnelConnection) {
+	stats := tm.getStats(tunnelConn.Tunnel.ID)
+	if stats == nil {
+		return
+	}
+	if stats.quality.PingSent() {
+		tm.telemetry.RecordLostHeartbeat(tunnelConn.Tunnel.Name)
+	}
+}
+
+// recordRoundTrip records the RTT of a pong carrying a pingPayload
+func (tm *TunnelManager) recordRoundTrip(tunnelConn *TunnelConnection, appData string) {
+	sent, err := strconv.ParseInt(appData, 10, 64)
+	if err != nil {
+		return // Unsolicited pong or an empty payload
+	}
+
+	if rtt := time.Since(time.Unix(0, sent)); rtt >= 0 {
+		if stats := tm.getStats(tunnelConn.Tunnel.ID); stats != nil {
+			stats.roundTrip.Record(rtt)
+			stats.quality.PongReceived(rtt)
+		}
+		tm.telemetry.RecordRoundTrip(tunnelConn.Tunnel.Name, rtt)
+	}
+}
+
+// publishState writes the tunnel's runtime state for other skyport processes
+func (tm *TunnelManager) publishState(tunnelConn *TunnelConnection) {
+	stats := tm.statsFor(&tunnelConn.Tunnel)
+
+	err := state.WriteTunnel(state.TunnelState{
+		TunnelID:    tunnelConn.Tunnel.ID,
+		TunnelName:  tunnelConn.Tunnel.Name,
+		PID:         os.Getpid(),
+		Region:      tunnelConn.Endpoint.Region,
+		StartedAt:   time.Unix(0, stats.startedAt.Load()),
+		ConnectedAt: tunnelConn.ConnectedAt,
+		Reconnects:  stats.connections.Load() - 1,
+		Features:    tunnelConn.featureList(),
+		UpdatedAt:   time.Now(),
+		Forwarding:  stats.forwarding.Percentiles(),
+		RoundTrip:   stats.roundTrip.Percentiles(),
+		Errors:      stats.errors.Summary(),
+		Quality:     stats.quality.Quality(),
+		Denied:      stats.deniedCounts(),
+		Sessions:    stats.sessions.Load(),
+	})
+	if err != nil {
+		logger.Debug("Failed to publish state for tunnel %s: %v", tunnelConn.Tunnel.Name, err)
+	}
+}
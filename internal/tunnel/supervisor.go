@@ -0,0 +1,330 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"skyport-agent/internal/config"
+	"skyport-agent/internal/crash"
+	"skyport-agent/internal/logger"
+	"sync"
+	"time"
+)
+
+const (
+	reconnectBaseDelay = 2 * time.Second
+	reconnectMaxDelay  = 60 * time.Second
+)
+
+// Backoff computes exponential retry delays with full jitter, so that many
+// agents (or many tunnels) losing the server at once don't retry in lockstep
+type Backoff struct {
+	Base    time.Duration
+	Max     time.Duration
+	attempt int
+}
+
+// NewBackoff creates a backoff with the default reconnect delays
+func NewBackoff() *Backoff {
+	return &Backoff{Base: reconnectBaseDelay, Max: reconnectMaxDelay}
+}
+
+// Next returns the delay before the next attempt: a random duration between
+// half and all of min(Max, Base*2^attempt)
+func (b *Backoff) Next() time.Duration {
+	ceiling := b.Max
+	if b.attempt < 16 {
+		if d := b.Base << uint(b.attempt); d < ceiling {
+			ceiling = d
+		}
+	}
+	b.attempt++
+
+	half := int64(ceiling / 2)
+	return time.Duration(half + rand.Int63n(half+1))
+}
+
+// Attempts returns how many delays have been handed out since the last reset
+func (b *Backoff) Attempts() int {
+	return b.attempt
+}
+
+// Reset starts the backoff sequence over
+func (b *Backoff) Reset() {
+	b.attempt = 0
+}
+
+// tunnelSupervisor is the single owner of reconnection for one tunnel. Only
+// its goroutine dials the tunnel, so there is never more than one connection
+// attempt in flight for a supervised tunnel.
+type tunnelSupervisor struct {
+	tm           *TunnelManager
+	tunnel       config.Tunnel
+	token        string
+	ctx          context.Context
+	cancel       context.CancelFunc
+	kick         chan struct{}
+	firstAttempt chan error
+
+	mu           sync.Mutex
+	reconnecting bool
+	attempts     int
+}
+
+// Supervise keeps a tunnel connected, reconnecting with jittered exponential
+// backoff whenever the connection drops. It is idempotent: a tunnel already
+// under supervision keeps its existing supervisor. The returned error is the
+// result of the first connection attempt; the supervisor keeps retrying in the
+// background even if it failed.
+func (tm *TunnelManager) Supervise(tunnel *config.Tunnel, token string) error {
+	tm.mutex.Lock()
+	if _, exists := tm.supervisors[tunnel.ID]; exists {
+		tm.mutex.Unlock()
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sup := &tunnelSupervisor{
+		tm:           tm,
+		tunnel:       *tunnel,
+		token:        token,
+		ctx:          ctx,
+		cancel:       cancel,
+		kick:         make(chan struct{}, 1),
+		firstAttempt: make(chan error, 1),
+	}
+	tm.supervisors[tunnel.ID] = sup
+	tm.mutex.Unlock()
+
+	go sup.run()
+
+	return <-sup.firstAttempt
+}
+
+// stopSupervisor stops reconnecting a tunnel (used for user-initiated disconnects)
+func (tm *TunnelManager) stopSupervisor(tunnelID string) {
+	tm.mutex.Lock()
+	sup, exists := tm.supervisors[tunnelID]
+	delete(tm.supervisors, tunnelID)
+	tm.mutex.Unlock()
+
+	if exists {
+		sup.cancel()
+	}
+}
+
+// RequestReconnect asks the tunnel's supervisor to retry immediately instead of
+// waiting out its backoff. Returns false if the tunnel is not supervised.
+func (tm *TunnelManager) RequestReconnect(tunnelID string) bool {
+	tm.mutex.RLock()
+	sup, exists := tm.supervisors[tunnelID]
+	tm.mutex.RUnlock()
+
+	if !exists {
+		return false
+	}
+
+	select {
+	case sup.kick <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// IsSupervised reports whether a tunnel has a reconnecting supervisor
+func (tm *TunnelManager) IsSupervised(tunnelID string) bool {
+	tm.mutex.RLock()
+	defer tm.mutex.RUnlock()
+
+	_, exists := tm.supervisors[tunnelID]
+	return exists
+}
+
+// GetReconnectingTunnels returns the IDs of supervised tunnels that are
+// currently down and being retried, with their attempt counts
+func (tm *TunnelManager) GetReconnectingTunnels() map[string]int {
+	tm.mutex.RLock()
+	supervisors := make([]*tunnelSupervisor, 0, len(tm.supervisors))
+	for _, sup := range tm.supervisors {
+		supervisors = append(supervisors, sup)
+	}
+	tm.mutex.RUnlock()
+
+	reconnecting := make(map[string]int)
+	for _, sup := range supervisors {
+		sup.mu.Lock()
+		if sup.reconnecting {
+			reconnecting[sup.tunnel.ID] = sup.attempts
+		}
+		sup.mu.Unlock()
+	}
+	return reconnecting
+}
+
+// run is the supervisor loop: connect, wait for the connection to end, repeat
+func (sup *tunnelSupervisor) run() {
+	defer crash.Recover("tunnel supervisor")
+
+	backoff := NewBackoff()
+	first := true
+	recovering := false
+
+	for {
+		// Stopped between attempts, before the tunnel was being connected
+		// for a disconnect to cancel
+		if sup.ctx.Err() != nil {
+			if first {
+				sup.firstAttempt <- fmt.Errorf("tunnel %s was disconnected while connecting", sup.tunnel.Name)
+			}
+			return
+		}
+
+		err := sup.tm.ConnectTunnel(&sup.tunnel, sup.token)
+		if errors.Is(err, ErrAlreadyConnected) {
+			// Connected by someone else (e.g. a make-before-break reconnect)
+			err = nil
+		} else if err == nil && sup.stoppedWhileConnecting() {
+			if first {
+				sup.firstAttempt <- fmt.Errorf("tunnel %s was disconnected while connecting", sup.tunnel.Name)
+			}
+			return
+		}
+
+		if first {
+			sup.firstAttempt <- err
+			first = false
+		}
+
+		if err == nil {
+			if recovering {
+				logger.Info("Tunnel %s reconnected successfully", sup.tunnel.Name)
+				sup.tm.emit(Event{
+					Type:       EventReconnected,
+					TunnelID:   sup.tunnel.ID,
+					TunnelName: sup.tunnel.Name,
+					Attempt:    backoff.Attempts() + 1,
+				})
+			}
+			backoff.Reset()
+			recovering = false
+			sup.setReconnecting(false, 0)
+
+			busy, ok := sup.waitForDisconnect()
+			if !ok {
+				return
+			}
+			recovering = true
+			if busy == nil {
+				logger.Warning("Tunnel %s disconnected, attempting to reconnect...", sup.tunnel.Name)
+				continue
+			}
+
+			// The server shed the connection; reconnecting at once would add
+			// to its load
+			delay := busy.delay(backoff.Next())
+			sup.setReconnecting(true, backoff.Attempts())
+			sup.tm.emitServerBusy(&sup.tunnel, busy, delay, backoff.Attempts())
+			logger.Warning("Tunnel %s was disconnected by the server: %v. Reconnecting in %v...",
+				sup.tunnel.Name, busy, delay.Round(100*time.Millisecond))
+			if !sup.wait(delay) {
+				return
+			}
+			continue
+		}
+
+		sup.tm.emitConnectFailure(&sup.tunnel, err)
+
+		delay := backoff.Next()
+		sup.setReconnecting(true, backoff.Attempts())
+		var busy *ServerBusyError
+		if errors.As(err, &busy) {
+			delay = busy.delay(delay)
+			sup.tm.emitServerBusy(&sup.tunnel, busy, delay, backoff.Attempts())
+		} else {
+			sup.tm.emit(Event{
+				Type:       EventReconnecting,
+				TunnelID:   sup.tunnel.ID,
+				TunnelName: sup.tunnel.Name,
+				Reason:     err.Error(),
+				Attempt:    backoff.Attempts(),
+			})
+		}
+		logger.Warning("Failed to connect tunnel %s (attempt %d): %v. Retrying in %v...",
+			sup.tunnel.Name, backoff.Attempts(), err, delay.Round(100*time.Millisecond))
+
+		if !sup.wait(delay) {
+			return
+		}
+	}
+}
+
+// wait sleeps before the next connection attempt, ending early when asked to
+// reconnect. Returns false if the supervisor was stopped.
+func (sup *tunnelSupervisor) wait(delay time.Duration) bool {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-sup.ctx.Done():
+		return false
+	case <-sup.kick:
+	case <-timer.C:
+	}
+	return true
+}
+
+// waitForDisconnect blocks until the tunnel has no live connection, and
+// returns why the server asked to retry later if it closed the connection for
+// that reason. Returns false if the supervisor was stopped.
+func (sup *tunnelSupervisor) waitForDisconnect() (*ServerBusyError, bool) {
+	var last *TunnelConnection
+	for {
+		tunnelConn := sup.tm.getConnection(sup.tunnel.ID)
+		if tunnelConn == nil {
+			var busy *ServerBusyError
+			if last != nil {
+				busy = last.serverBusy()
+			}
+			return busy, sup.ctx.Err() == nil
+		}
+		last = tunnelConn
+
+		// Wait for the connection's handler to exit rather than for its
+		// context, which is cancelled while it is still the active connection
+		select {
+		case <-sup.ctx.Done():
+			return nil, false
+		case <-tunnelConn.exited:
+			// The connection may have been replaced rather than lost; loop
+			// to watch the replacement if there is one
+		}
+	}
+}
+
+// stoppedWhileConnecting closes the connection the supervisor just made if
+// it was stopped meanwhile, and reports whether it was. A tunnel supervised
+// again since keeps the connection.
+func (sup *tunnelSupervisor) stoppedWhileConnecting() bool {
+	if sup.ctx.Err() == nil {
+		return false
+	}
+
+	sup.tm.mutex.RLock()
+	_, supervised := sup.tm.supervisors[sup.tunnel.ID]
+	sup.tm.mutex.RUnlock()
+	if !supervised {
+		if err := sup.tm.removeTunnel(sup.tunnel.ID, "User initiated shutdown"); err != nil {
+			logger.Debug("Failed to close tunnel %s stopped while connecting: %v", sup.tunnel.Name, err)
+		}
+	}
+	return true
+}
+
+func (sup *tunnelSupervisor) setReconnecting(reconnecting bool, attempts int) {
+	sup.mu.Lock()
+	defer sup.mu.Unlock()
+
+	sup.reconnecting = reconnecting
+	sup.attempts = attempts
+}
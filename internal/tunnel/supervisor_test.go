@@ -0,0 +1,82 @@
+package tunnel
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"skyport-agent/internal/config"
+)
+
+// stoppedSupervisor returns a supervisor for tunnel t1 that has already been
+// stopped, as DisconnectTunnel leaves it
+func stoppedSupervisor(tm *TunnelManager) *tunnelSupervisor {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	return &tunnelSupervisor{
+		tm:           tm,
+		tunnel:       config.Tunnel{ID: "t1", Name: "test"},
+		ctx:          ctx,
+		cancel:       cancel,
+		kick:         make(chan struct{}, 1),
+		firstAttempt: make(chan error, 1),
+	}
+}
+
+func testManager() *TunnelManager {
+	return &TunnelManager{
+		activeTunnels: make(map[string]*TunnelConnection),
+		supervisors:   make(map[string]*tunnelSupervisor),
+		connecting:    make(map[string]bool),
+		takeovers:     make(map[string]bool),
+		logs:          make(map[string]*tunnelLogs),
+		localServers:  make(map[string][]*http.Server),
+		targets:       make(map[string]*localTarget),
+		stats:         make(map[string]*tunnelStats),
+		resumes:       make(map[string]*resumeBuffer),
+	}
+}
+
+func TestStoppedSupervisorDoesNotConnect(t *testing.T) {
+	sup := stoppedSupervisor(testManager())
+
+	done := make(chan struct{})
+	go func() {
+		sup.run()
+		close(done)
+	}()
+
+	select {
+	case err := <-sup.firstAttempt:
+		if err == nil {
+			t.Error("stopped supervisor reported a successful connection")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("stopped supervisor kept running")
+	}
+	<-done
+}
+
+func TestSupervisorStoppedWhileConnectingClosesTunnel(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	tm := testManager()
+	tunnelConn, closed := replacedConnection(t)
+	tm.activeTunnels["t1"] = tunnelConn
+
+	// DisconnectTunnel ran while the dial was in flight and found nothing
+	if !stoppedSupervisor(tm).stoppedWhileConnecting() {
+		t.Fatal("stopped supervisor not detected")
+	}
+	if tm.IsConnected("t1") {
+		t.Error("tunnel left connected after its supervisor was stopped")
+	}
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("connection not closed")
+	}
+	if reason, userInitiated := tunnelConn.getCloseReason(); !userInitiated {
+		t.Errorf("close reason %q not user initiated", reason)
+	}
+}
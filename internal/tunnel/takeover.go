@@ -0,0 +1,147 @@
+package tunnel
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"skyport-agent/internal/config"
+	"skyport-agent/internal/logger"
+	"time"
+)
+
+const (
+	// HostHeader names the machine the agent runs on, so the server can tell
+	// another agent where a tunnel is running
+	HostHeader = "X-Skyport-Host"
+
+	// MachineIDHeader carries the agent's machine ID, which tells machines
+	// with the same hostname apart
+	MachineIDHeader = "X-Skyport-Machine-ID"
+
+	// TakeoverHeader asks the server to close the tunnel's connection from
+	// another machine instead of rejecting this one
+	TakeoverHeader = "X-Skyport-Takeover"
+
+	// ConnectedHostHeader carries the machine a tunnel is connected from in
+	// a conflict response, for servers that do not send it in the body
+	ConnectedHostHeader = "X-Skyport-Connected-Host"
+)
+
+// messageTakenOver is pushed by the server when another machine takes over
+// the tunnel
+const messageTakenOver = "taken_over"
+
+// ErrRunningElsewhere is returned when the server rejects a connection
+// because the tunnel is connected from another machine
+var ErrRunningElsewhere = errors.New("tunnel is running on another machine")
+
+// Owner describes the connection that holds a running tunnel. Fields the
+// server does not report are empty.
+type Owner struct {
+	Host      string
+	MachineID string
+	Since     time.Time
+}
+
+// RunningElsewhereError reports which machine a tunnel is connected from
+type RunningElsewhereError struct {
+	Owner Owner
+}
+
+func (e *RunningElsewhereError) Error() string {
+	if e.Owner.Host == "" {
+		return ErrRunningElsewhere.Error()
+	}
+	return fmt.Sprintf("tunnel is running on %s", e.Owner.Host)
+}
+
+func (e *RunningElsewhereError) Unwrap() error {
+	return ErrRunningElsewhere
+}
+
+// parseConflict reads a 409 handshake response. It returns a
+// RunningElsewhereError when the server says the tunnel is connected from
+// another machine, or nil for other conflicts such as a taken subdomain.
+func parseConflict(resp *http.Response) *RunningElsewhereError {
+	var body struct {
+		Reason             string    `json:"reason"`
+		ConnectedHost      string    `json:"connected_host"`
+		ConnectedMachineID string    `json:"connected_machine_id"`
+		ConnectedAt        time.Time `json:"connected_at"`
+	}
+	if resp.Body != nil {
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		json.Unmarshal(data, &body)
+	}
+	if body.ConnectedHost == "" {
+		body.ConnectedHost = resp.Header.Get(ConnectedHostHeader)
+	}
+
+	if body.Reason != "tunnel_running" && body.ConnectedHost == "" && body.ConnectedMachineID == "" {
+		return nil
+	}
+	return &RunningElsewhereError{Owner: Owner{
+		Host:      body.ConnectedHost,
+		MachineID: body.ConnectedMachineID,
+		Since:     body.ConnectedAt,
+	}}
+}
+
+// handshakeHeaders adds the headers that identify this machine and, when
+// allowed, take the tunnel over from another one
+func (tm *TunnelManager) handshakeHeaders(headers http.Header, tunnelID string) {
+	if hostname, err := os.Hostname(); err == nil {
+		headers.Add(HostHeader, hostname)
+	}
+	if machineID, err := config.NewConfigManager().MachineID(); err == nil {
+		headers.Add(MachineIDHeader, machineID)
+	} else {
+		logger.Debug("Failed to get machine ID: %v", err)
+	}
+	if tm.takesOver(tunnelID) {
+		headers.Add(TakeoverHeader, "true")
+	}
+}
+
+// AllowTakeover makes connections of a tunnel take it over from another
+// machine it is running on, until the tunnel is disconnected
+func (tm *TunnelManager) AllowTakeover(tunnelID string) {
+	tm.mutex.Lock()
+	defer tm.mutex.Unlock()
+
+	tm.takeovers[tunnelID] = true
+}
+
+func (tm *TunnelManager) takesOver(tunnelID string) bool {
+	tm.mutex.RLock()
+	defer tm.mutex.RUnlock()
+
+	return tm.takeovers[tunnelID]
+}
+
+// takenOver disconnects a tunnel another machine took over, without
+// reconnecting it, so the two machines do not take it from each other in turn
+func (tm *TunnelManager) takenOver(tunnel config.Tunnel, message *TunnelMessage) {
+	reason := "taken over by another machine"
+	if host := headerValue(message.Headers, "connected_host"); host != "" {
+		reason = fmt.Sprintf("taken over by %s", host)
+	}
+	logger.Warning("Tunnel %s was %s, disconnecting it", tunnel.Name, reason)
+
+	tm.mutex.Lock()
+	tm.stopped[tunnel.ID] = true
+	tm.mutex.Unlock()
+
+	if err := tm.disconnectTunnel(tunnel.ID, reason); err != nil {
+		logger.Debug("Failed to disconnect tunnel %s: %v", tunnel.Name, err)
+	}
+	tm.emit(Event{
+		Type:       EventTakenOver,
+		TunnelID:   tunnel.ID,
+		TunnelName: tunnel.Name,
+		Reason:     reason,
+	})
+}
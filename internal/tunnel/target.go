@@ -0,0 +1,161 @@
+package tunnel
+
+import (
+	"fmt"
+	"skyport-agent/internal/config"
+	"skyport-agent/internal/logger"
+	"sync"
+	"time"
+)
+
+// TargetSwitch reports the result of switching a running tunnel's local target
+type TargetSwitch struct {
+	TunnelID   string        `json:"tunnel_id"`
+	TunnelName string        `json:"tunnel_name"`
+	Previous   string        `json:"previous"`
+	Target     string        `json:"target"`
+	Drained    int           `json:"drained"`    // Requests in flight to the previous target when switching
+	DrainTime  time.Duration `json:"drain_time"` // How long they took to finish
+	TimedOut   bool          `json:"timed_out"`  // Some were still in flight when the drain timeout passed
+}
+
+// localTarget is the address a tunnel forwards requests to. It is kept per
+// tunnel rather than per connection, so a switch survives reconnects, and
+// counts requests in flight to each address so a switch can wait for the
+// previous one to drain.
+type localTarget struct {
+	mutex   sync.Mutex
+	current *targetAddress
+}
+
+// targetAddress is one address a localTarget has pointed to
+type targetAddress struct {
+	addr     string
+	inFlight int
+	drained  chan struct{} // Closed when inFlight drops to zero after a switch
+}
+
+func newLocalTarget(addr string) *localTarget {
+	return &localTarget{current: &targetAddress{addr: addr}}
+}
+
+// address returns the current address, for requests that are not drained
+// (WebSocket upgrades, local HTTPS)
+func (lt *localTarget) address() string {
+	lt.mutex.Lock()
+	defer lt.mutex.Unlock()
+
+	return lt.current.addr
+}
+
+// acquire returns the current address for a request, counting it as in
+// flight until it is released
+func (lt *localTarget) acquire() *targetAddress {
+	lt.mutex.Lock()
+	defer lt.mutex.Unlock()
+
+	lt.current.inFlight++
+	return lt.current
+}
+
+// release counts a request acquired from the target as finished
+func (lt *localTarget) release(a *targetAddress) {
+	lt.mutex.Lock()
+	defer lt.mutex.Unlock()
+
+	a.inFlight--
+	if a.inFlight == 0 && a.drained != nil {
+		close(a.drained)
+		a.drained = nil
+	}
+}
+
+// switchTo sends new requests to addr and returns the previous address, how
+// many requests are still in flight to it and a channel closed once they
+// have finished
+func (lt *localTarget) switchTo(addr string) (string, int, <-chan struct{}) {
+	lt.mutex.Lock()
+	defer lt.mutex.Unlock()
+
+	previous := lt.current
+	lt.current = &targetAddress{addr: addr}
+
+	drained := make(chan struct{})
+	if previous.inFlight == 0 {
+		close(drained)
+	} else {
+		previous.drained = drained
+	}
+	return previous.addr, previous.inFlight, drained
+}
+
+// targetFor returns the local target of a tunnel, creating it from the
+// tunnel's settings on first use. It is forgotten when the tunnel is
+// disconnected.
+func (tm *TunnelManager) targetFor(tunnel *config.Tunnel) *localTarget {
+	tm.targetsMutex.Lock()
+	defer tm.targetsMutex.Unlock()
+
+	target, exists := tm.targets[tunnel.ID]
+	if !exists {
+		target = newLocalTarget(tunnel.LocalAddress())
+		tm.targets[tunnel.ID] = target
+	}
+	return target
+}
+
+// forgetTarget drops a disconnected tunnel's local target, so it is read from
+// its settings again when it next connects
+func (tm *TunnelManager) forgetTarget(tunnelID string) {
+	tm.targetsMutex.Lock()
+	defer tm.targetsMutex.Unlock()
+
+	delete(tm.targets, tunnelID)
+}
+
+// TargetAddress returns the address a running tunnel forwards to, which may
+// differ from its settings after SwitchTarget, or "" if it is not running
+func (tm *TunnelManager) TargetAddress(tunnelID string) string {
+	tm.targetsMutex.Lock()
+	target := tm.targets[tunnelID]
+	tm.targetsMutex.Unlock()
+
+	if target == nil {
+		return ""
+	}
+	return target.address()
+}
+
+// SwitchTarget points a running tunnel at a new local address. Requests
+// received from then on go to addr; the call returns once the requests in
+// flight to the previous address have finished, or drainTimeout has passed.
+// Open WebSocket connections stay on the previous address until they close.
+func (tm *TunnelManager) SwitchTarget(tunnelID, addr string, drainTimeout time.Duration) (*TargetSwitch, error) {
+	tunnelConn := tm.getConnection(tunnelID)
+	if tunnelConn == nil {
+		return nil, fmt.Errorf("tunnel not connected")
+	}
+	tunnel := tunnelConn.Tunnel
+	if tunnel.StaticSettings().Dir != "" {
+		return nil, fmt.Errorf("tunnel %s serves a directory, not a local port", tunnel.Name)
+	}
+
+	start := time.Now()
+	previous, inFlight, drained := tm.targetFor(&tunnel).switchTo(addr)
+	logger.Info("Tunnel %s switched from %s to %s, draining %d requests", tunnel.Name, previous, addr, inFlight)
+	tm.emit(Event{
+		Type:       EventTargetSwitched,
+		TunnelID:   tunnel.ID,
+		TunnelName: tunnel.Name,
+		Reason:     fmt.Sprintf("%s %s %s", previous, logger.Arrow(), addr),
+	})
+
+	result := &TargetSwitch{TunnelID: tunnel.ID, TunnelName: tunnel.Name, Previous: previous, Target: addr, Drained: inFlight}
+	select {
+	case <-drained:
+	case <-time.After(drainTimeout):
+		result.TimedOut = true
+	}
+	result.DrainTime = time.Since(start)
+	return result, nil
+}
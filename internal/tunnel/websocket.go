@@ -0,0 +1,328 @@
+package tunnel
+
+import (
+	"encoding/binary"
+	"errors"
+	"skyport-agent/internal/crash"
+	"skyport-agent/internal/logger"
+	"skyport-agent/internal/ratelimit"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// wsWriteTimeout bounds a write to the local WebSocket service
+const wsWriteTimeout = 10 * time.Second
+
+// wsQueueLength is how many client messages may wait for the local service
+// in a session before it is closed as too slow
+const wsQueueLength = 1024
+
+// wsSession is a WebSocket connection proxied to the local service, keyed by
+// the ID of the upgrade request
+type wsSession struct {
+	id      string
+	local   *websocket.Conn
+	limiter *ratelimit.Limiter // Limits messages from the client; nil if unlimited
+
+	// inbox holds messages from the client, delivered to local in order by
+	// deliverMessages
+	inbox chan *TunnelMessage
+
+	// writeMutex serializes writes to local, since close frames are also
+	// written by the idle reaper and when the tunnel disconnects
+	writeMutex sync.Mutex
+	closeOnce  sync.Once
+	closed     chan struct{}
+
+	// lastActive is when a message last passed in either direction (Unix
+	// nanoseconds), for the idle timeout
+	lastActive atomic.Int64
+}
+
+// touch records a message passing through the session
+func (s *wsSession) touch() {
+	s.lastActive.Store(time.Now().UnixNano())
+}
+
+// idleFor returns how long the session has passed no messages
+func (s *wsSession) idleFor() time.Duration {
+	return time.Since(time.Unix(0, s.lastActive.Load()))
+}
+
+// isClosed reports whether the session was closed by closeSession
+func (s *wsSession) isClosed() bool {
+	select {
+	case <-s.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// openSession registers a WebSocket connected to the local service, applying
+// the tunnel's limits to it
+func (atp *AgentTunnelProtocol) openSession(id string, local *websocket.Conn) *wsSession {
+	session := &wsSession{id: id, local: local, inbox: make(chan *TunnelMessage, wsQueueLength), closed: make(chan struct{})}
+	session.touch()
+	if atp.websocket.MaxMessageBytes > 0 {
+		local.SetReadLimit(atp.websocket.MaxMessageBytes)
+	}
+	if atp.websocket.MessagesPerSecond > 0 {
+		session.limiter = ratelimit.New(atp.websocket.MessagesPerSecond, atp.websocket.MessageBurst, 0, 0)
+	}
+
+	atp.sessionsMutex.Lock()
+	defer atp.sessionsMutex.Unlock()
+	if atp.sessions == nil {
+		atp.sessions = make(map[string]*wsSession)
+	}
+	atp.sessions[id] = session
+
+	go atp.deliverMessages(session)
+	if timeout := atp.websocket.IdleTimeout.Duration; timeout > 0 {
+		go atp.reapIdle(session, timeout)
+	}
+	return session
+}
+
+// deliverMessages passes the client's messages to the local service in the
+// order they arrived, until the session is closed
+func (atp *AgentTunnelProtocol) deliverMessages(session *wsSession) {
+	defer crash.Recover("websocket delivery")
+
+	for {
+		select {
+		case <-session.closed:
+			return
+		case message := <-session.inbox:
+			atp.handleWebSocketData(session, message)
+		}
+	}
+}
+
+// queueWebSocketData queues a message from the client on its session. It
+// never blocks, so the connection's read loop can call it to keep each
+// session's messages in order.
+func (atp *AgentTunnelProtocol) queueWebSocketData(message *TunnelMessage) {
+	atp.stats.touch()
+	session := atp.getSession(message.ID)
+	if session == nil {
+		logger.Debug("Received WebSocket data for unknown session %s: %d bytes", message.ID, len(message.Body))
+		return
+	}
+
+	select {
+	case session.inbox <- message:
+	default:
+		logger.Info("Closing WebSocket session %s on tunnel %s: the local service is not keeping up with the client",
+			session.id, atp.tunnelName)
+		go atp.closeSession(session, websocket.CloseTryAgainLater, "local service too slow", true, true)
+	}
+}
+
+// reapIdle closes the session once no message has passed in either
+// direction for timeout, freeing the local connection. It returns when the
+// session is closed.
+func (atp *AgentTunnelProtocol) reapIdle(session *wsSession, timeout time.Duration) {
+	defer crash.Recover("websocket idle timeout")
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case <-session.closed:
+			return
+		case <-timer.C:
+		}
+
+		idle := session.idleFor()
+		if idle < timeout {
+			timer.Reset(timeout - idle)
+			continue
+		}
+		logger.Debug("Closing WebSocket session %s on tunnel %s: no messages for %s", session.id, atp.tunnelName, timeout)
+		atp.closeSession(session, websocket.CloseGoingAway, "idle timeout", true, true)
+		return
+	}
+}
+
+// closeSessions closes every open WebSocket session, telling the local
+// service, when the tunnel connection they were proxied over is gone
+func (atp *AgentTunnelProtocol) closeSessions() {
+	atp.sessionsMutex.Lock()
+	sessions := make([]*wsSession, 0, len(atp.sessions))
+	for _, session := range atp.sessions {
+		sessions = append(sessions, session)
+	}
+	atp.sessionsMutex.Unlock()
+
+	for _, session := range sessions {
+		atp.closeSession(session, websocket.CloseGoingAway, "tunnel disconnected", true, false)
+	}
+}
+
+// getSession returns an open WebSocket session, or nil
+func (atp *AgentTunnelProtocol) getSession(id string) *wsSession {
+	atp.sessionsMutex.Lock()
+	defer atp.sessionsMutex.Unlock()
+
+	return atp.sessions[id]
+}
+
+// removeSession forgets a WebSocket session once it has ended, releasing
+// its place under the session cap
+func (atp *AgentTunnelProtocol) removeSession(id string) {
+	atp.sessionsMutex.Lock()
+	defer atp.sessionsMutex.Unlock()
+
+	if _, exists := atp.sessions[id]; exists {
+		delete(atp.sessions, id)
+		atp.stats.sessionClosed()
+		atp.notifyIdleLocked()
+	}
+}
+
+// closeSession ends a WebSocket session with a close code, telling the local
+// service and/or the client. Only the first call has an effect.
+func (atp *AgentTunnelProtocol) closeSession(session *wsSession, code int, reason string, notifyLocal, notifyClient bool) {
+	session.closeOnce.Do(func() {
+		close(session.closed)
+		frame := websocket.FormatCloseMessage(code, reason)
+
+		if notifyLocal {
+			session.writeMutex.Lock()
+			session.local.WriteControl(websocket.CloseMessage, frame, time.Now().Add(time.Second))
+			session.writeMutex.Unlock()
+		}
+		if notifyClient {
+			err := atp.sendMessage(&TunnelMessage{
+				Type:      "websocket_data",
+				ID:        session.id,
+				Body:      frame,
+				Headers:   map[string]string{"message_type": strconv.Itoa(websocket.CloseMessage)},
+				Timestamp: time.Now().Unix(),
+			})
+			if err != nil {
+				logger.Debug("Failed to send WebSocket close to tunnel: %v", err)
+			}
+		}
+		session.local.Close()
+	})
+}
+
+// handleWebSocketForwarding relays messages from the local service to the
+// client until either side closes the session
+func (atp *AgentTunnelProtocol) handleWebSocketForwarding(session *wsSession) error {
+	defer atp.removeSession(session.id)
+
+	for {
+		messageType, data, err := session.local.ReadMessage()
+		if err != nil {
+			atp.localClosed(session, err)
+			return nil
+		}
+		session.touch()
+
+		tunnelMsg := &TunnelMessage{
+			Type:      "websocket_data",
+			ID:        session.id,
+			Body:      data,
+			Headers:   map[string]string{"message_type": strconv.Itoa(messageType)},
+			Timestamp: time.Now().Unix(),
+		}
+		if err := atp.sendMessage(tunnelMsg); err != nil {
+			logger.Debug("Failed to forward WebSocket message to tunnel: %v", err)
+			atp.closeSession(session, websocket.CloseGoingAway, "", true, false)
+			return nil
+		}
+	}
+}
+
+// localClosed passes the end of a session on the local side on to the client
+func (atp *AgentTunnelProtocol) localClosed(session *wsSession, err error) {
+	if session.isClosed() {
+		return // Closed by the agent or the client
+	}
+
+	var closeErr *websocket.CloseError
+	switch {
+	case errors.Is(err, websocket.ErrReadLimit):
+		logger.Info("Closing WebSocket session %s on tunnel %s: the local service sent a message over %d bytes",
+			session.id, atp.tunnelName, atp.websocket.MaxMessageBytes)
+		atp.closeSession(session, atp.websocket.TooBigCloseCode, "message too big", true, true)
+	case errors.As(err, &closeErr):
+		code := closeErr.Code
+		if code < 1000 || code == websocket.CloseNoStatusReceived || code == websocket.CloseAbnormalClosure {
+			code = websocket.CloseNormalClosure
+		}
+		atp.closeSession(session, code, closeErr.Text, false, true)
+	default:
+		logger.Debug("Local WebSocket read error: %v", err)
+		atp.closeSession(session, websocket.CloseGoingAway, "local service disconnected", false, true)
+	}
+}
+
+// handleWebSocketData relays a message from the client to the local service,
+// closing the session if it breaks the tunnel's limits
+func (atp *AgentTunnelProtocol) handleWebSocketData(session *wsSession, message *TunnelMessage) {
+	session.touch()
+
+	messageType := websocket.TextMessage
+	if t, err := strconv.Atoi(headerValue(message.Headers, "message_type")); err == nil {
+		messageType = t
+	}
+	if messageType == websocket.CloseMessage {
+		code, text := parseCloseFrame(message.Body)
+		atp.closeSession(session, code, text, true, false)
+		return
+	}
+
+	limits := atp.websocket
+	if limits.MaxMessageBytes > 0 && int64(len(message.Body)) > limits.MaxMessageBytes {
+		logger.Info("Closing WebSocket session %s on tunnel %s: the client sent a message of %d bytes (limit %d)",
+			session.id, atp.tunnelName, len(message.Body), limits.MaxMessageBytes)
+		atp.closeSession(session, limits.TooBigCloseCode, "message too big", true, true)
+		return
+	}
+	if session.limiter != nil {
+		if ok, _ := session.limiter.Allow(""); !ok {
+			logger.Info("Closing WebSocket session %s on tunnel %s: the client sent over %g messages per second",
+				session.id, atp.tunnelName, limits.MessagesPerSecond)
+			atp.closeSession(session, limits.RateCloseCode, "rate limit exceeded", true, true)
+			return
+		}
+	}
+
+	session.writeMutex.Lock()
+	var err error
+	deadline := time.Now().Add(wsWriteTimeout)
+	switch messageType {
+	case websocket.PingMessage, websocket.PongMessage:
+		err = session.local.WriteControl(messageType, message.Body, deadline)
+	default:
+		session.local.SetWriteDeadline(deadline)
+		err = session.local.WriteMessage(messageType, message.Body)
+	}
+	session.writeMutex.Unlock()
+
+	if err != nil {
+		logger.Debug("Failed to forward WebSocket message to local service: %v", err)
+		atp.closeSession(session, websocket.CloseGoingAway, "local service disconnected", false, true)
+	}
+}
+
+// parseCloseFrame returns the code and reason of a close frame's payload
+func parseCloseFrame(payload []byte) (int, string) {
+	if len(payload) < 2 {
+		return websocket.CloseNormalClosure, ""
+	}
+	code := int(binary.BigEndian.Uint16(payload))
+	if code < 1000 || code == websocket.CloseNoStatusReceived || code == websocket.CloseAbnormalClosure {
+		code = websocket.CloseNormalClosure
+	}
+	return code, string(payload[2:])
+}
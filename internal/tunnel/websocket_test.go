@@ -0,0 +1,121 @@
+package tunnel
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestWebSocketDataReachesLocalServiceInOrder(t *testing.T) {
+	const n = 500
+	received := make(chan string, n)
+	upgrader := websocket.Upgrader{}
+	local := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			received <- string(data)
+		}
+	}))
+	defer local.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(local.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	atp := &AgentTunnelProtocol{}
+	session := atp.openSession("s1", conn)
+	defer atp.closeSession(session, websocket.CloseNormalClosure, "", true, false)
+
+	// Frames are passed to receiveFrame as the connection's read loop does
+	for i := 0; i < n; i++ {
+		data, err := json.Marshal(&TunnelMessage{
+			Type:    "websocket_data",
+			ID:      "s1",
+			Body:    []byte(strconv.Itoa(i)),
+			Headers: map[string]string{"message_type": strconv.Itoa(websocket.TextMessage)},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		frame := getFrameBuffer()
+		frame.Write(data)
+		atp.receiveFrame(frame, func(err error) { t.Errorf("handling frame: %v", err) })
+	}
+
+	for i := 0; i < n; i++ {
+		select {
+		case got := <-received:
+			if got != strconv.Itoa(i) {
+				t.Fatalf("message %d arrived as %s", i, got)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for message %d", i)
+		}
+	}
+}
+
+func TestWebSocketSessionClosedWhenUpgradeResponseFails(t *testing.T) {
+	closed := make(chan struct{})
+	upgrader := websocket.Upgrader{}
+	local := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	defer local.Close()
+
+	tunnelConn, _ := replacedConnection(t)
+	atp := tunnelConn.Protocol
+	atp.target = newLocalTarget(strings.TrimPrefix(local.URL, "http://"))
+	// The tunnel connection is gone, so the upgrade response cannot be sent
+	atp.writer.close()
+	<-atp.writer.done
+	atp.websocket.IdleTimeout.Duration = time.Hour
+	goroutines := runtime.NumGoroutine()
+
+	err := atp.handleWebSocketUpgrade(&TunnelMessage{Type: "websocket_upgrade", ID: "s1", URL: "/", Headers: map[string]string{}})
+	if err == nil {
+		t.Fatal("upgrade succeeded without a tunnel connection")
+	}
+
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("local WebSocket left open after the upgrade response failed")
+	}
+	if session := atp.getSession("s1"); session != nil {
+		t.Error("session still registered")
+	}
+
+	// The session's delivery and idle timeout goroutines must have stopped
+	deadline := time.Now().Add(2 * time.Second)
+	for runtime.NumGoroutine() > goroutines {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d goroutines running, %d before the upgrade", runtime.NumGoroutine(), goroutines)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,263 @@
+package tunnel
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// writeTimeout bounds each write to the server, so a dead connection
+	// cannot hang senders
+	writeTimeout = 10 * time.Second
+
+	// stallThreshold is how long a batch may take to write before the
+	// connection is considered stalled
+	stallThreshold = time.Second
+
+	// maxBatchMessages and maxBatchBytes bound how much is coalesced into
+	// one socket write, so a burst cannot delay the messages behind it
+	maxBatchMessages = 64
+	maxBatchBytes    = 256 << 10
+)
+
+// errWriterClosed is returned for messages sent after the connection closed
+var errWriterClosed = errors.New("tunnel connection closed")
+
+type outboundMessage struct {
+	data    []byte
+	release func()
+	result  chan error
+}
+
+// messageWriter writes a connection's messages from a single goroutine.
+// Messages queued while a write is in progress are written together as one
+// batch, under one write deadline and, when the connection was dialed with a
+// batchConn, in a single socket write.
+type messageWriter struct {
+	conn  *websocket.Conn
+	batch *batchConn
+	queue chan outboundMessage
+	// stalled is called when a batch took longer than stallThreshold
+	stalled func()
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+func newMessageWriter(conn *websocket.Conn) *messageWriter {
+	w := &messageWriter{
+		conn:  conn,
+		batch: batchConnOf(conn),
+		queue: make(chan outboundMessage, maxBatchMessages),
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+// write queues data and waits until it has been written. release is called
+// once data is no longer needed.
+func (w *messageWriter) write(data []byte, release func()) error {
+	result := make(chan error, 1)
+	select {
+	case w.queue <- outboundMessage{data: data, release: release, result: result}:
+	case <-w.done:
+		release()
+		return errWriterClosed
+	}
+
+	select {
+	case err := <-result:
+		return err
+	case <-w.done:
+		// The writer may have finished this message just before stopping
+		select {
+		case err := <-result:
+			return err
+		default:
+			return errWriterClosed
+		}
+	}
+}
+
+// close stops the writer. Messages still queued fail with errWriterClosed.
+func (w *messageWriter) close() {
+	w.stopOnce.Do(func() { close(w.stop) })
+	<-w.done
+}
+
+func (w *messageWriter) run() {
+	defer close(w.done)
+
+	batch := make([]outboundMessage, 0, maxBatchMessages)
+	for {
+		select {
+		case <-w.stop:
+			w.drain()
+			return
+		case message := <-w.queue:
+			batch = append(batch[:0], message)
+			size := len(message.data)
+
+			// Take whatever else is already waiting, without waiting for more
+		collect:
+			for len(batch) < maxBatchMessages && size < maxBatchBytes {
+				select {
+				case message := <-w.queue:
+					batch = append(batch, message)
+					size += len(message.data)
+				default:
+					break collect
+				}
+			}
+
+			w.writeBatch(batch)
+		}
+	}
+}
+
+func (w *messageWriter) writeBatch(batch []outboundMessage) {
+	start := time.Now()
+	deadline := start.Add(writeTimeout)
+	w.conn.SetWriteDeadline(deadline)
+
+	// A single message is written directly; copying it into the batch
+	// buffer would gain nothing
+	corked := w.batch != nil && len(batch) > 1
+	if corked {
+		w.batch.cork()
+	}
+
+	errs := make([]error, len(batch))
+	var err error
+	for i, message := range batch {
+		if err == nil {
+			err = w.conn.WriteMessage(websocket.TextMessage, message.data)
+		}
+		errs[i] = err
+	}
+
+	if corked {
+		if flushErr := w.batch.flush(deadline); flushErr != nil {
+			// Nothing written while corked reached the server
+			for i := range errs {
+				if errs[i] == nil {
+					errs[i] = flushErr
+				}
+			}
+		}
+	}
+
+	if time.Since(start) > stallThreshold && w.stalled != nil {
+		w.stalled()
+	}
+
+	for i, message := range batch {
+		message.release()
+		message.result <- errs[i]
+	}
+}
+
+// drain fails the messages still queued when the writer stops
+func (w *messageWriter) drain() {
+	for {
+		select {
+		case message := <-w.queue:
+			message.release()
+			message.result <- errWriterClosed
+		default:
+			return
+		}
+	}
+}
+
+// batchConn holds back writes while corked and sends them to the network in
+// one write when flushed. Writes outside a batch (such as control frames sent
+// between batches) go straight through.
+type batchConn struct {
+	net.Conn
+
+	// connect is how long the TCP handshake took
+	connect time.Duration
+
+	mu     sync.Mutex
+	corked bool
+	buf    []byte
+	// err is the error of a failed flush. The frames in it were reported as
+	// written, so the connection cannot be used afterwards.
+	err error
+}
+
+func (c *batchConn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.err != nil {
+		return 0, c.err
+	}
+	if c.corked {
+		c.buf = append(c.buf, p...)
+		return len(p), nil
+	}
+	return c.Conn.Write(p)
+}
+
+// SetWriteDeadline is ignored while corked; the batch's own deadline applies
+// when it is flushed
+func (c *batchConn) SetWriteDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.corked {
+		return nil
+	}
+	return c.Conn.SetWriteDeadline(t)
+}
+
+func (c *batchConn) cork() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.corked = true
+}
+
+// flush writes everything held back since cork
+func (c *batchConn) flush(deadline time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.corked = false
+	if c.err != nil || len(c.buf) == 0 {
+		return c.err
+	}
+
+	c.Conn.SetWriteDeadline(deadline)
+	_, err := c.Conn.Write(c.buf)
+	if err != nil {
+		c.err = err
+	}
+
+	if cap(c.buf) > maxPooledBuffer {
+		c.buf = nil
+	} else {
+		c.buf = c.buf[:0]
+	}
+	return err
+}
+
+// batchConnOf returns the batchConn a WebSocket connection was dialed with,
+// looking through TLS, or nil if it has none
+func batchConnOf(conn *websocket.Conn) *batchConn {
+	netConn := conn.UnderlyingConn()
+	if tlsConn, ok := netConn.(*tls.Conn); ok {
+		netConn = tlsConn.NetConn()
+	}
+	batch, _ := netConn.(*batchConn)
+	return batch
+}
@@ -0,0 +1,264 @@
+// Package usage keeps per-tunnel, per-day byte counters on disk so traffic
+// totals survive restarts. Each tunnel has its own file, and only the process
+// running a tunnel writes to it.
+package usage
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"skyport-agent/internal/config"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// dayFormat is the key used for daily buckets (local time)
+const dayFormat = "2006-01-02"
+
+// retentionDays is how long daily counters are kept
+const retentionDays = 400
+
+// Day holds the traffic for one tunnel on one day
+type Day struct {
+	BytesIn  int64 `json:"bytes_in"`  // Received from the server (requests)
+	BytesOut int64 `json:"bytes_out"` // Sent to the server (responses)
+	Requests int64 `json:"requests"`
+}
+
+// Record is the on-disk usage history of a tunnel
+type Record struct {
+	TunnelID   string          `json:"tunnel_id"`
+	TunnelName string          `json:"tunnel_name"`
+	Days       map[string]*Day `json:"days"`
+}
+
+// Counter accumulates a tunnel's traffic in memory and periodically adds it to
+// the tunnel's record on disk
+type Counter struct {
+	tunnelID   string
+	tunnelName string
+
+	mu      sync.Mutex
+	pending map[string]*Day
+}
+
+// NewCounter creates a counter for a tunnel
+func NewCounter(tunnelID, tunnelName string) *Counter {
+	return &Counter{
+		tunnelID:   tunnelID,
+		tunnelName: tunnelName,
+		pending:    make(map[string]*Day),
+	}
+}
+
+// today returns today's pending bucket; must be called with the lock held
+func (c *Counter) today() *Day {
+	key := time.Now().Format(dayFormat)
+	day, exists := c.pending[key]
+	if !exists {
+		day = &Day{}
+		c.pending[key] = day
+	}
+	return day
+}
+
+// AddIn counts bytes received from the server
+func (c *Counter) AddIn(n int) {
+	c.mu.Lock()
+	c.today().BytesIn += int64(n)
+	c.mu.Unlock()
+}
+
+// AddOut counts bytes sent to the server
+func (c *Counter) AddOut(n int) {
+	c.mu.Lock()
+	c.today().BytesOut += int64(n)
+	c.mu.Unlock()
+}
+
+// AddRequest counts a proxied request
+func (c *Counter) AddRequest() {
+	c.mu.Lock()
+	c.today().Requests++
+	c.mu.Unlock()
+}
+
+// Flush adds pending counts to the tunnel's record on disk
+func (c *Counter) Flush() error {
+	c.mu.Lock()
+	pending := c.pending
+	c.pending = make(map[string]*Day)
+	c.mu.Unlock()
+
+	if len(pending) == 0 {
+		return nil
+	}
+
+	record, err := Load(c.tunnelID)
+	if err != nil {
+		record = &Record{TunnelID: c.tunnelID, Days: make(map[string]*Day)}
+	}
+	record.TunnelName = c.tunnelName
+
+	for key, delta := range pending {
+		day, exists := record.Days[key]
+		if !exists {
+			day = &Day{}
+			record.Days[key] = day
+		}
+		day.BytesIn += delta.BytesIn
+		day.BytesOut += delta.BytesOut
+		day.Requests += delta.Requests
+	}
+
+	cutoff := time.Now().AddDate(0, 0, -retentionDays).Format(dayFormat)
+	for key := range record.Days {
+		if key < cutoff {
+			delete(record.Days, key)
+		}
+	}
+
+	if err := save(record); err != nil {
+		// Keep the counts for the next attempt
+		c.mu.Lock()
+		for key, delta := range pending {
+			day := c.pending[key]
+			if day == nil {
+				day = &Day{}
+				c.pending[key] = day
+			}
+			day.BytesIn += delta.BytesIn
+			day.BytesOut += delta.BytesOut
+			day.Requests += delta.Requests
+		}
+		c.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// Dir returns the directory usage records are stored in
+func Dir() (string, error) {
+	configDir, err := config.GetConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(configDir, "usage"), nil
+}
+
+func recordPath(tunnelID string) (string, error) {
+	dir, err := Dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, tunnelID+".json"), nil
+}
+
+// Load reads a tunnel's usage record
+func Load(tunnelID string) (*Record, error) {
+	path, err := recordPath(tunnelID)
+	if err != nil {
+		return nil, err
+	}
+	return loadFile(path)
+}
+
+func loadFile(path string) (*Record, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var record Record
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, fmt.Errorf("failed to parse usage record %s: %w", path, err)
+	}
+	if record.Days == nil {
+		record.Days = make(map[string]*Day)
+	}
+	return &record, nil
+}
+
+// LoadAll reads the usage records of every tunnel that has carried traffic
+func LoadAll() ([]*Record, error) {
+	dir, err := Dir()
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var records []*Record
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		record, err := loadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			continue
+		}
+		records = append(records, record)
+	}
+
+	sort.Slice(records, func(i, j int) bool { return records[i].TunnelName < records[j].TunnelName })
+	return records, nil
+}
+
+func save(record *Record) error {
+	path, err := recordPath(record.TunnelID)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to create usage directory: %w", err)
+	}
+
+	data, err := json.MarshalIndent(record, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal usage record: %w", err)
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write usage record: %w", err)
+	}
+	return os.Rename(tmp, path)
+}
+
+// Since returns the record's days on or after the given time, oldest first
+func (r *Record) Since(since time.Time) []string {
+	cutoff := since.Format(dayFormat)
+
+	var days []string
+	for key := range r.Days {
+		if key >= cutoff {
+			days = append(days, key)
+		}
+	}
+	sort.Strings(days)
+	return days
+}
+
+// FormatBytes renders a byte count in human-readable binary units
+func FormatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
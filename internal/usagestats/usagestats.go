@@ -0,0 +1,189 @@
+// Package usagestats sends anonymous command usage reports, when the user has
+// opted in with 'skyport telemetry on', so maintainers can see which commands
+// are used and which errors users run into.
+//
+// A report holds only what is documented on Report and Event: command names,
+// the names (never values) of flags, error categories, exit statuses and
+// durations, plus the agent version and platform. Arguments, tunnel names,
+// hostnames, paths and error messages are never recorded.
+package usagestats
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"skyport-agent/internal/config"
+	"time"
+)
+
+const (
+	// reportPath is where reports are sent on the SkyPort server
+	reportPath = "/telemetry/usage"
+
+	// sendInterval is how long events are queued locally between reports
+	sendInterval = 24 * time.Hour
+	// maxQueuedEvents bounds the local queue when reports cannot be sent
+	maxQueuedEvents = 500
+	// sendTimeout keeps a slow server from holding up the command that sends
+	sendTimeout = 3 * time.Second
+)
+
+// Event is one command run
+type Event struct {
+	Command  string   `json:"command"`         // Command path without "skyport", e.g. "tunnel run"
+	Flags    []string `json:"flags,omitempty"` // Names of the flags given, never their values
+	Error    string   `json:"error,omitempty"` // Error category, e.g. "server_unreachable"
+	ExitCode int      `json:"exit_code"`
+	Duration int64    `json:"duration_ms"`
+	Day      string   `json:"day"` // Date the command ran, without the time
+}
+
+// Report is the payload sent to the server
+type Report struct {
+	InstallID string  `json:"install_id"` // Random ID chosen on opt-in
+	Version   string  `json:"version"`
+	OS        string  `json:"os"`
+	Arch      string  `json:"arch"`
+	Events    []Event `json:"events"`
+}
+
+// LastReport is the most recent report sent, kept for 'skyport telemetry show-last'
+type LastReport struct {
+	SentAt time.Time `json:"sent_at"`
+	Report Report    `json:"report"`
+}
+
+// queue is the events waiting to be sent, as stored in ~/.skyport
+type queue struct {
+	// Attempted is when sending last failed, so an unreachable server is not
+	// retried by every command
+	Attempted time.Time `json:"attempted,omitempty"`
+	Events    []Event   `json:"events"`
+}
+
+// Version is the agent version included in reports (set by the cli package)
+var Version = "unknown"
+
+// Enabled reports whether the user opted in. DO_NOT_TRACK or
+// SKYPORT_NO_TELEMETRY in the environment turns reports off regardless.
+func Enabled() bool {
+	if os.Getenv("DO_NOT_TRACK") != "" || os.Getenv("SKYPORT_NO_TELEMETRY") != "" {
+		return false
+	}
+	return config.NewConfigManager().GetUsageReports().Enabled
+}
+
+// Record queues an event, and sends the queue once a day. It does nothing
+// unless the user opted in.
+func Record(event Event) {
+	if !Enabled() {
+		return
+	}
+
+	var q queue
+	readJSON(queueFile(), &q)
+	q.Events = append(q.Events, event)
+	if len(q.Events) > maxQueuedEvents {
+		q.Events = q.Events[len(q.Events)-maxQueuedEvents:]
+	}
+
+	last, _ := Last()
+	due := last == nil || time.Since(last.SentAt) >= sendInterval
+	if due && time.Since(q.Attempted) >= sendInterval {
+		if err := send(q.Events); err == nil {
+			os.Remove(queueFile())
+			return
+		}
+		q.Attempted = time.Now()
+	}
+	writeJSON(queueFile(), q)
+}
+
+// Queued returns the events waiting to be sent
+func Queued() []Event {
+	var q queue
+	readJSON(queueFile(), &q)
+	return q.Events
+}
+
+// Last returns the most recent report sent, or nil if none was
+func Last() (*LastReport, error) {
+	var last LastReport
+	if err := readJSON(lastFile(), &last); err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &last, nil
+}
+
+// Discard deletes queued events and the copy of the last report, as opting
+// out does
+func Discard() {
+	os.Remove(queueFile())
+	os.Remove(lastFile())
+}
+
+// send posts the queued events to the server and keeps a copy of the report
+func send(events []Event) error {
+	report := Report{
+		InstallID: config.NewConfigManager().GetUsageReports().InstallID,
+		Version:   Version,
+		OS:        runtime.GOOS,
+		Arch:      runtime.GOARCH,
+		Events:    events,
+	}
+	body, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+
+	cfg := config.Load()
+	client := cfg.HTTPClient(sendTimeout)
+	resp, err := client.Post(cfg.ServerURL+reportPath, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to send usage report: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("failed to send usage report: status %d", resp.StatusCode)
+	}
+
+	return writeJSON(lastFile(), LastReport{SentAt: time.Now(), Report: report})
+}
+
+func queueFile() string {
+	return usageFile("usage-queue.json")
+}
+
+func lastFile() string {
+	return usageFile("usage-last.json")
+}
+
+func usageFile(name string) string {
+	dir, err := config.GetConfigDir()
+	if err != nil {
+		return filepath.Join(os.TempDir(), "skyport-"+name)
+	}
+	return filepath.Join(dir, name)
+}
+
+func readJSON(path string, v interface{}) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func writeJSON(path string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0600)
+}